@@ -0,0 +1,161 @@
+// Package accesslog writes structured logs of the requests of the HTTP
+// server, sampled so that busy instances can keep them on. Each record
+// holds the method and route of the request, its status and latency, and,
+// for rate lookups, the hash of the normalized address (see
+// tracing.KeyHash, the same as in trace spans) and whether the cache hit.
+// Addresses themselves are never logged.
+//
+// The cache hits are reported by Tracer, which must be given to the cache
+// with lrucache.WithTracer.
+package accesslog
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/tracing"
+)
+
+// Logger logs a sample of the requests of an HTTP handler.
+type Logger struct {
+	logger *slog.Logger
+	sample float64
+	slow   time.Duration
+	route  func(*http.Request) string
+	rand   func() float64
+}
+
+// Option configures a Logger.
+type Option func(*Logger)
+
+// WithSlow logs every request taking d or longer, whatever the sample.
+func WithSlow(d time.Duration) Option {
+	return func(l *Logger) {
+		l.slow = d
+	}
+}
+
+// WithRoute sets how the route of a request is logged, e.g. as the pattern
+// it matches, such as "GET /rate/{address}"; see httpserver.Server.Route.
+// By default the route is not logged, since paths hold addresses.
+func WithRoute(route func(*http.Request) string) Option {
+	return func(l *Logger) {
+		l.route = route
+	}
+}
+
+// New returns a Logger writing to logger the share sample, between 0 and 1,
+// of the requests, and every one failing with a 5xx status.
+func New(logger *slog.Logger, sample float64, opts ...Option) *Logger {
+	l := &Logger{logger: logger, sample: sample, rand: rand.Float64}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// record collects what the lookups of a request report to Tracer.
+type record struct {
+	mu      sync.Mutex
+	lookups int
+	hits    int
+	key     string // hash of the first key looked up
+}
+
+type recordKey struct{}
+
+// Middleware logs the requests to next.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &record{}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), recordKey{}, rec)))
+		latency := time.Since(start)
+
+		if !l.sampled(sw.status, latency) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.Int("status", sw.status),
+			slog.Duration("latency", latency),
+		}
+		if l.route != nil {
+			attrs = append(attrs, slog.String("route", l.route(r)))
+		}
+		rec.mu.Lock()
+		switch {
+		case rec.lookups == 1:
+			cache := "miss"
+			if rec.hits == 1 {
+				cache = "hit"
+			}
+			attrs = append(attrs, slog.String("key", rec.key), slog.String("cache", cache))
+		case rec.lookups > 1:
+			attrs = append(attrs, slog.Int("lookups", rec.lookups), slog.Int("hits", rec.hits))
+		}
+		rec.mu.Unlock()
+		l.logger.LogAttrs(r.Context(), slog.LevelInfo, "access", attrs...)
+	})
+}
+
+// sampled reports whether to log a request replied status after latency.
+func (l *Logger) sampled(status int, latency time.Duration) bool {
+	if status >= 500 || l.slow > 0 && latency >= l.slow {
+		return true
+	}
+	return l.sample >= 1 || l.sample > 0 && l.rand() < l.sample
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to flush
+// an event stream.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Tracer implements lrucache.Tracer, reporting the lookups of a request to
+// the Logger logging it. Lookups coalesced with those of another request
+// are only reported in the log of that request.
+type Tracer struct{}
+
+var _ lrucache.Tracer[string] = Tracer{}
+
+// StartLookup notes the hash of key; the end function notes whether the
+// lookup hit.
+func (Tracer) StartLookup(ctx context.Context, key string) (context.Context, func(bool, error)) {
+	rec, ok := ctx.Value(recordKey{}).(*record)
+	if !ok {
+		return ctx, func(bool, error) {}
+	}
+	return ctx, func(hit bool, _ error) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.lookups == 0 {
+			rec.key = tracing.KeyHash(key)
+		}
+		rec.lookups++
+		if hit {
+			rec.hits++
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/tracing"
+)
+
+func TestMiddleware(t *testing.T) {
+	cache := lrucache.New[string, int](10, lrucache.WithKeyNormalizer(strings.ToUpper), lrucache.WithTracer[string](Tracer{}))
+	cache.Insert("A", 1)
+	loader := func(ctx context.Context, key string) (int, error) { return 2, nil }
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rate/{address}", func(w http.ResponseWriter, r *http.Request) {
+		cache.GetOrLoadCtx(r.Context(), r.PathValue("address"), loader)
+	})
+	mux.HandleFunc("GET /batch", func(w http.ResponseWriter, r *http.Request) {
+		for _, key := range []string{"a", "b", "c"} {
+			cache.GetOrLoadCtx(r.Context(), key, loader)
+		}
+	})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	var buf bytes.Buffer
+	l := New(slog.New(slog.NewJSONHandler(&buf, nil)), 0.5, WithSlow(time.Hour), WithRoute(func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}))
+	h := l.Middleware(mux)
+	get := func(path string, draw float64) map[string]any {
+		t.Helper()
+		l.rand = func() float64 { return draw }
+		buf.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		if buf.Len() == 0 {
+			return nil
+		}
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+
+	e := get("/rate/a", 0.1)
+	if e["route"] != "GET /rate/{address}" || e["status"] != 200.0 || e["cache"] != "hit" || e["key"] != tracing.KeyHash("A") {
+		t.Errorf("hit logged as %v", e)
+	}
+	if strings.Contains(buf.String(), `"a"`) || strings.Contains(buf.String(), "/rate/a") {
+		t.Errorf("address logged in %s", buf.String())
+	}
+	if e := get("/rate/z", 0.1); e["cache"] != "miss" {
+		t.Errorf("miss logged as %v", e)
+	}
+	if e := get("/batch", 0.1); e["lookups"] != 3.0 || e["hits"] != 1.0 {
+		t.Errorf("batch logged as %v", e)
+	}
+	if e := get("/rate/a", 0.9); e != nil {
+		t.Errorf("request out of the sample logged: %v", e)
+	}
+	if e := get("/fail", 0.9); e == nil || e["status"] != 502.0 {
+		t.Errorf("failed request logged as %v, want it logged whatever the sample", e)
+	}
+}
@@ -0,0 +1,85 @@
+package addrnorm
+
+// standard lists the words mapped to each USPS standard abbreviation. The
+// standard abbreviations themselves are kept as they are.
+var standard = map[string][]string{
+	// street suffixes, Publication 28 appendix C1
+	"ALY":  {"ALLEE", "ALLEY", "ALLY"},
+	"AVE":  {"AV", "AVEN", "AVENU", "AVENUE", "AVN", "AVNUE"},
+	"BLVD": {"BOUL", "BOULEVARD", "BOULV"},
+	"BRG":  {"BRIDGE", "BRDGE"},
+	"CYN":  {"CANYON", "CANYN", "CNYN"},
+	"CSWY": {"CAUSEWAY", "CAUSWA"},
+	"CTR":  {"CEN", "CENT", "CENTER", "CENTR", "CENTRE", "CNTER", "CNTR"},
+	"CIR":  {"CIRC", "CIRCL", "CIRCLE", "CRCL", "CRCLE"},
+	"CT":   {"COURT"},
+	"CV":   {"COVE"},
+	"CRK":  {"CREEK"},
+	"XING": {"CROSSING", "CRSSNG"},
+	"DR":   {"DRIV", "DRIVE", "DRV"},
+	"ESTS": {"ESTATES"},
+	"EXPY": {"EXP", "EXPR", "EXPRESS", "EXPRESSWAY", "EXPW"},
+	"FWY":  {"FREEWAY", "FREEWY", "FRWAY", "FRWY"},
+	"GDNS": {"GARDENS"},
+	"GRV":  {"GROVE"},
+	"HBR":  {"HARBOR", "HARB", "HRBOR"},
+	"HTS":  {"HEIGHTS", "HT"},
+	"HWY":  {"HIGHWAY", "HIGHWY", "HIWAY", "HIWY", "HWAY"},
+	"HOLW": {"HOLLOW", "HLLW", "HOLWS"},
+	"JCT":  {"JUNCTION", "JCTION", "JUNCTN"},
+	"LNDG": {"LANDING"},
+	"LN":   {"LANE"},
+	"MNR":  {"MANOR"},
+	"MDWS": {"MEADOWS"},
+	"ORCH": {"ORCHARD"},
+	"PKWY": {"PARKWAY", "PARKWY", "PKWAY", "PKY"},
+	"PL":   {"PLACE"},
+	"PLZ":  {"PLAZA", "PLZA"},
+	"PT":   {"POINT"},
+	"RDG":  {"RIDGE"},
+	"RD":   {"ROAD"},
+	"SQ":   {"SQUARE", "SQR", "SQRE", "SQU"},
+	"STA":  {"STATION", "STATN", "STN"},
+	"ST":   {"STR", "STREET", "STRT"},
+	"TER":  {"TERRACE", "TERR"},
+	"TRL":  {"TRAIL", "TRAILS", "TRLS"},
+	"TPKE": {"TURNPIKE", "TRNPK", "TURNPK"},
+	"VLY":  {"VALLEY", "VALLY", "VLLY"},
+	"VW":   {"VIEW"},
+	"VLG":  {"VILLAGE", "VILL", "VILLAG", "VILLG"},
+
+	// directionals, appendix B
+	"N":  {"NORTH"},
+	"S":  {"SOUTH"},
+	"E":  {"EAST"},
+	"W":  {"WEST"},
+	"NE": {"NORTHEAST"},
+	"NW": {"NORTHWEST"},
+	"SE": {"SOUTHEAST"},
+	"SW": {"SOUTHWEST"},
+
+	// secondary unit designators, appendix C2
+	"APT":  {"APARTMENT"},
+	"BSMT": {"BASEMENT"},
+	"BLDG": {"BUILDING"},
+	"DEPT": {"DEPARTMENT"},
+	"FL":   {"FLOOR"},
+	"HNGR": {"HANGAR"},
+	"LBBY": {"LOBBY"},
+	"OFC":  {"OFFICE"},
+	"PH":   {"PENTHOUSE"},
+	"RM":   {"ROOM"},
+	"STE":  {"SUITE"},
+	"TRLR": {"TRAILER"},
+}
+
+// abbreviations maps every word of standard to its abbreviation.
+var abbreviations = func() map[string]string {
+	m := make(map[string]string)
+	for std, words := range standard {
+		for _, w := range words {
+			m[w] = std
+		}
+	}
+	return m
+}()
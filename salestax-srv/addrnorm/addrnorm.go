@@ -0,0 +1,62 @@
+// Package addrnorm normalizes US street addresses for use as cache keys, so
+// that "123 Main St." and "123 MAIN STREET" share one entry.
+//
+// Normalize upper-cases the address, drops periods and apostrophes, turns
+// other punctuation into spaces, collapses whitespace and maps every word
+// with a USPS standard abbreviation (Publication 28) to it: street suffixes
+// such as STREET and STR to ST, directionals such as NORTH to N and
+// secondary unit designators such as SUITE to STE. Non-standard
+// abbreviations are mapped the same way, e.g. AV and AVEN to AVE.
+//
+// The result is only meant to compare equal for spellings of the same
+// address; it is not a validated USPS address.
+package addrnorm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Normalize returns the normalized form of addr. Normalizing a normalized
+// address returns it unchanged.
+func Normalize(addr string) string {
+	var b strings.Builder
+	b.Grow(len(addr))
+	space := false // a space is due before the next character
+	for _, r := range addr {
+		switch {
+		case r == '.' || r == '\'' || r == '’':
+			// "St." is "St", "O'Brien" is "OBrien"
+			continue
+		case r == '#':
+			// "#4" is "# 4", like "Apt 4"
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = true
+			continue
+		case unicode.IsSpace(r) || unicode.IsPunct(r) && r != '-' && r != '/' && r != '&':
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	words := strings.Fields(b.String())
+	changed := false
+	for i, w := range words {
+		if std, ok := abbreviations[w]; ok {
+			words[i] = std
+			changed = true
+		}
+	}
+	if !changed {
+		return b.String()
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,43 @@
+package addrnorm
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"123 Main St.", "123 MAIN ST"},
+		{"123 MAIN STREET", "123 MAIN ST"},
+		{"  123   main\tstreet ", "123 MAIN ST"},
+		{"456 North Oak Avenue, Suite 200", "456 N OAK AVE STE 200"},
+		{"456 N. Oak Av., Ste. 200", "456 N OAK AVE STE 200"},
+		{"789 Elm Blvd Apt #4", "789 ELM BLVD APT # 4"},
+		{"789 Elm Boulevard Apartment#4", "789 ELM BLVD APT # 4"},
+		{"12 O'Brien Pkwy; Springfield, IL 62701", "12 OBRIEN PKWY SPRINGFIELD IL 62701"},
+		{"1/2 Rue-de-la-Paix & 5th", "1/2 RUE-DE-LA-PAIX & 5TH"},
+		{"", ""},
+		{" ,. ", ""},
+	}
+	for _, tt := range tests {
+		got := Normalize(tt.in)
+		if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if again := Normalize(got); again != got {
+			t.Errorf("Normalize(%q) = %q, not idempotent", got, again)
+		}
+	}
+}
+
+func TestAbbreviationsAreStandard(t *testing.T) {
+	for std, words := range standard {
+		if again, ok := abbreviations[std]; ok {
+			t.Errorf("%s is mapped to %s", std, again)
+		}
+		for _, w := range words {
+			if abbreviations[w] != std {
+				t.Errorf("%s is listed for both %s and %s", w, std, abbreviations[w])
+			}
+		}
+	}
+}
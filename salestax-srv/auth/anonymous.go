@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// AnonymousName is the name of the Client of anonymous requests, see
+// WithAnonymous.
+const AnonymousName = "anonymous"
+
+// anonymousIPs is the number of client IPs whose rate limits are tracked;
+// that of the least recently seen is reset to make room.
+const anonymousIPs = 100000
+
+// anonymous lets requests without credentials through, limited per IP.
+type anonymous struct {
+	prefixes []string
+	rate     float64
+	burst    int
+	limits   *lrucache.LRUCache[string, *bucket]
+}
+
+// WithAnonymous lets HTTP requests without credentials to the paths starting
+// with one of prefixes, e.g. "/tax/", through as the client named
+// AnonymousName with the Read role, limited to rate requests per second and
+// bursts of burst from each IP: those of the browsers of a storefront, say.
+// The IP is that of the connection, so behind a proxy all share one limit.
+// A burst of 0 is rate. RPCs always need credentials.
+func WithAnonymous(rate float64, burst int, prefixes ...string) Option {
+	if burst == 0 {
+		burst = max(1, int(rate))
+	}
+	return func(a *Authenticator) {
+		a.anonymous = &anonymous{
+			prefixes: prefixes,
+			rate:     rate,
+			burst:    burst,
+			limits:   lrucache.New[string, *bucket](anonymousIPs),
+		}
+	}
+}
+
+// covers reports whether anonymous requests may go to path.
+func (an *anonymous) covers(path string) bool {
+	for _, p := range an.prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize authorizes an anonymous request from ip like Authorize does.
+func (an *anonymous) authorize(ip string, role Role) (Client, error) {
+	c := Client{Name: AnonymousName, Role: Read, Rate: an.rate, Burst: an.burst}
+	if !Read.permits(role) {
+		return c, fmt.Errorf("%w: anonymous clients have the %s role, %s is required", ErrForbidden, Read, role)
+	}
+	b, _ := an.limits.GetOrLoad(ip, func(string) (*bucket, error) {
+		return newBucket(an.rate, an.burst), nil
+	})
+	if !b.allow(time.Now()) {
+		return c, fmt.Errorf("%w for %s", ErrRateLimited, ip)
+	}
+	return c, nil
+}
+
+// remoteIP returns the IP of the client of r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,232 @@
+// Package auth authenticates the clients of the servers, by API key or by
+// the certificate they present over mutual TLS, limits the rate of the
+// requests of each client and authorizes them by role: read-only clients
+// look rates up, admin clients also write them and use the admin and debug
+// endpoints.
+//
+// The clients are listed in a JSON file:
+//
+//	[
+//	  {"name": "checkout", "role": "read", "key": "k-4f9a...", "rate": 200},
+//	  {"name": "acme-pos", "role": "read", "key": "k-07c1...", "tenant": "acme"},
+//	  {"name": "rates-sync", "role": "admin", "cert": "rates-sync.internal"}
+//	]
+//
+// An API key is sent as "Authorization: Bearer <key>" or "X-API-Key: <key>",
+// over gRPC in the metadata of the same names, and to the memcached and
+// Redis protocol servers as their packages describe. A certificate
+// identifies a client by its subject common name, once verified against the
+// client CAs of the server. WithAnonymous lets HTTP requests without either
+// look rates up, e.g. from a browser, within a rate limit per IP.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Role is what a client is allowed to do.
+type Role string
+
+// Roles of a Client.
+const (
+	Read  Role = "read"  // look rates and taxes up
+	Admin Role = "admin" // also write and invalidate rates, and administer the server
+)
+
+// permits reports whether r allows what role want allows.
+func (r Role) permits(want Role) bool {
+	return r == Admin || r == want
+}
+
+// Client is a client of the servers, identified by Key or by Cert.
+type Client struct {
+	Name  string  `json:"name"`
+	Role  Role    `json:"role"`
+	Key   string  `json:"key,omitempty"`   // API key
+	Cert  string  `json:"cert,omitempty"`  // subject common name of its client certificate
+	Rate  float64 `json:"rate,omitempty"`  // requests per second, 0 for no limit
+	Burst int     `json:"burst,omitempty"` // requests allowed at once, by default Rate
+	// Tenant is the tenant the requests of the client are served for, see
+	// package tenant; empty for clients of every tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Errors returned by Authorize.
+var (
+	// ErrUnauthenticated is returned for requests without valid credentials.
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	// ErrForbidden is returned for requests the role of the client does not
+	// permit.
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited is returned for requests over the rate limit of the
+	// client.
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// Authenticator authenticates, rate limits and authorizes the requests of a
+// fixed set of clients. It is safe for concurrent use.
+type Authenticator struct {
+	keys      map[[sha256.Size]byte]*client // by the hash of the key
+	certs     map[string]*client
+	public    []string
+	anonymous *anonymous // nil unless WithAnonymous was given
+}
+
+// client is a Client with its rate limiter.
+type client struct {
+	Client
+	limit *bucket // nil for no limit
+}
+
+// Option configures an Authenticator.
+type Option func(*Authenticator)
+
+// WithPublic leaves the HTTP paths given open to anyone, e.g. the health
+// checks probed by an orchestrator.
+func WithPublic(paths ...string) Option {
+	return func(a *Authenticator) {
+		a.public = append(a.public, paths...)
+	}
+}
+
+// New returns an Authenticator of clients.
+func New(clients []Client, opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:  make(map[[sha256.Size]byte]*client),
+		certs: make(map[string]*client),
+	}
+	for i, c := range clients {
+		if err := a.add(c); err != nil {
+			return nil, fmt.Errorf("client %d (%s): %w", i+1, c.Name, err)
+		}
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a, nil
+}
+
+func (a *Authenticator) add(c Client) error {
+	switch {
+	case c.Name == "":
+		return errors.New("no name")
+	case c.Role != Read && c.Role != Admin:
+		return fmt.Errorf("role %q is not %s or %s", c.Role, Read, Admin)
+	case (c.Key == "") == (c.Cert == ""):
+		return errors.New("exactly one of key and cert is required")
+	case c.Rate < 0 || c.Burst < 0:
+		return errors.New("negative rate limit")
+	}
+	cl := &client{Client: c}
+	if c.Rate > 0 {
+		burst := c.Burst
+		if burst == 0 {
+			burst = max(1, int(c.Rate))
+		}
+		cl.limit = newBucket(c.Rate, burst)
+	}
+	if c.Key != "" {
+		h := sha256.Sum256([]byte(c.Key))
+		if _, dup := a.keys[h]; dup {
+			return errors.New("duplicate key")
+		}
+		a.keys[h] = cl
+		return nil
+	}
+	if _, dup := a.certs[c.Cert]; dup {
+		return fmt.Errorf("duplicate cert %q", c.Cert)
+	}
+	a.certs[c.Cert] = cl
+	return nil
+}
+
+// OpenClients returns an Authenticator of the clients in the JSON file at path.
+func OpenClients(path string, opts ...Option) (*Authenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := ReadClients(f, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+// ReadClients returns an Authenticator of the clients in the JSON array read from
+// r.
+func ReadClients(r io.Reader, opts ...Option) (*Authenticator, error) {
+	var clients []Client
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&clients); err != nil {
+		return nil, err
+	}
+	return New(clients, opts...)
+}
+
+// Len returns the number of clients.
+func (a *Authenticator) Len() int {
+	return len(a.keys) + len(a.certs)
+}
+
+// Authorize returns the client presenting key or, if key is empty, the
+// verified client certificate of state, which may be nil. It fails with
+// ErrUnauthenticated if neither identifies a client, ErrForbidden if its
+// role does not permit role, and ErrRateLimited if it is over its rate
+// limit.
+func (a *Authenticator) Authorize(key string, state *tls.ConnectionState, role Role) (Client, error) {
+	c := a.identify(key, state)
+	if c == nil {
+		return Client{}, ErrUnauthenticated
+	}
+	if !c.Role.permits(role) {
+		return c.Client, fmt.Errorf("%w: client %s has the %s role, %s is required", ErrForbidden, c.Name, c.Role, role)
+	}
+	if c.limit != nil && !c.limit.allow(time.Now()) {
+		return c.Client, fmt.Errorf("%w for client %s", ErrRateLimited, c.Name)
+	}
+	return c.Client, nil
+}
+
+func (a *Authenticator) identify(key string, state *tls.ConnectionState) *client {
+	if key != "" {
+		return a.keys[sha256.Sum256([]byte(key))]
+	}
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	return a.certs[state.VerifiedChains[0][0].Subject.CommonName]
+}
+
+// bearer returns the API key of an Authorization header value and of an
+// X-API-Key header value, preferring the former.
+func bearer(authorization, apiKey string) string {
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(apiKey)
+}
+
+type clientKey struct{}
+
+// FromContext returns the client of a request authorized by the middleware
+// or the interceptor of an Authenticator.
+func FromContext(ctx context.Context) (Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(Client)
+	return c, ok
+}
+
+func withClient(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
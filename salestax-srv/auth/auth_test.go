@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorize(t *testing.T) {
+	a, err := ReadClients(strings.NewReader(`[
+		{"name": "checkout", "role": "read", "key": "r-key"},
+		{"name": "sync", "role": "admin", "key": "a-key", "rate": 1, "burst": 2}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 2 {
+		t.Errorf("Len = %d, want 2", a.Len())
+	}
+
+	if c, err := a.Authorize("r-key", nil, Read); err != nil || c.Name != "checkout" {
+		t.Errorf("Authorize(r-key, read) = %v, %v", c, err)
+	}
+	if _, err := a.Authorize("r-key", nil, Admin); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Authorize(r-key, admin): %v, want ErrForbidden", err)
+	}
+	for _, key := range []string{"", "wrong"} {
+		if _, err := a.Authorize(key, nil, Read); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Authorize(%q): %v, want ErrUnauthenticated", key, err)
+		}
+	}
+	// admins may read, up to their burst
+	for i := range 2 {
+		if _, err := a.Authorize("a-key", nil, Read); err != nil {
+			t.Errorf("request %d of admin: %v", i+1, err)
+		}
+	}
+	if _, err := a.Authorize("a-key", nil, Admin); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("request over the burst: %v, want ErrRateLimited", err)
+	}
+
+	for _, bad := range []string{
+		`[{"name": "x", "role": "root", "key": "k"}]`,
+		`[{"name": "x", "role": "read"}]`,
+		`[{"name": "x", "role": "read", "key": "k", "cert": "c"}]`,
+		`[{"name": "x", "role": "read", "key": "k"}, {"name": "y", "role": "admin", "key": "k"}]`,
+		`[{"role": "read", "key": "k"}]`,
+	} {
+		if _, err := ReadClients(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadClients(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ca, caKey := newCert(t, "test CA", nil, nil)
+	clientCert, clientKey := newCert(t, "sync.internal", ca, caKey)
+
+	a, err := New([]Client{
+		{Name: "checkout", Role: Read, Key: "r-key"},
+		{Name: "sync", Role: Admin, Cert: "sync.internal"},
+	}, WithPublic("/healthz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := FromContext(r.Context())
+		w.Write([]byte(c.Name))
+	})))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	ts.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	defer ts.Close()
+
+	anonymous := ts.Client()
+	transport := anonymous.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientCert.Raw},
+		PrivateKey:  clientKey,
+	}}
+	withCert := &http.Client{Transport: transport}
+
+	for _, tc := range []struct {
+		client *http.Client
+		method string
+		path   string
+		key    string
+		status int
+		name   string
+	}{
+		{anonymous, http.MethodGet, "/healthz", "", http.StatusOK, ""},
+		{anonymous, http.MethodGet, "/rate/x", "", http.StatusUnauthorized, ""},
+		{anonymous, http.MethodGet, "/rate/x", "r-key", http.StatusOK, "checkout"},
+		{anonymous, http.MethodPut, "/rate/x", "r-key", http.StatusForbidden, ""},
+		{anonymous, http.MethodPost, "/rates:batchGet", "r-key", http.StatusOK, "checkout"},
+		{anonymous, http.MethodGet, "/admin/cache", "r-key", http.StatusForbidden, ""},
+		{withCert, http.MethodGet, "/rate/x", "", http.StatusOK, "sync"},
+		{withCert, http.MethodPut, "/rate/x", "", http.StatusOK, "sync"},
+	} {
+		req, _ := http.NewRequest(tc.method, ts.URL+tc.path, nil)
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		resp, err := tc.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s with key %q: %s, want %d", tc.method, tc.path, tc.key, resp.Status, tc.status)
+		} else if tc.status == http.StatusOK && string(body) != tc.name {
+			t.Errorf("%s %s with key %q: client %q, want %q", tc.method, tc.path, tc.key, body, tc.name)
+		}
+	}
+}
+
+// newCert returns a certificate of cn signed by parent, self-signed if
+// parent is nil.
+func newCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestAnonymous(t *testing.T) {
+	a, err := New([]Client{{Name: "checkout", Role: Read, Key: "r-key"}}, WithAnonymous(1, 2, "/tax/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := FromContext(r.Context())
+		w.Write([]byte(c.Name))
+	}))
+	for _, tc := range []struct {
+		method, path, key, ip string
+		status                int
+		name                  string
+	}{
+		{http.MethodGet, "/tax/x", "", "192.0.2.1", http.StatusOK, AnonymousName},
+		{http.MethodGet, "/tax/x", "", "192.0.2.1", http.StatusOK, AnonymousName},
+		{http.MethodGet, "/tax/x", "", "192.0.2.1", http.StatusTooManyRequests, ""}, // over the burst
+		{http.MethodGet, "/tax/x", "", "192.0.2.2", http.StatusOK, AnonymousName},   // limited per IP
+		{http.MethodGet, "/tax/x", "r-key", "192.0.2.1", http.StatusOK, "checkout"},
+		{http.MethodGet, "/tax/x", "wrong", "192.0.2.3", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/rate/x", "", "192.0.2.3", http.StatusUnauthorized, ""},
+		{http.MethodPut, "/tax/x", "", "192.0.2.3", http.StatusForbidden, ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.RemoteAddr = tc.ip + ":4711"
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s with key %q from %s: %d, want %d", tc.method, tc.path, tc.key, tc.ip, rec.Code, tc.status)
+		} else if tc.status == http.StatusOK && rec.Body.String() != tc.name {
+			t.Errorf("%s %s with key %q from %s: client %q, want %q", tc.method, tc.path, tc.key, tc.ip, rec.Body, tc.name)
+		}
+	}
+}
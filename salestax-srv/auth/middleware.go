@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPRole returns the role an HTTP request requires: Read for GET and HEAD,
+// except under /admin/ and /debug/, and for the lookups of POST
+// /rates:batchGet, and Admin for everything else.
+func HTTPRole(r *http.Request) Role {
+	if strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/debug/") {
+		return Admin
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return Read
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/rates:batchGet" {
+		return Read
+	}
+	return Admin
+}
+
+// Middleware authorizes every request to next but those to the public
+// paths, replying 401, 403 or 429 to the others that fail; see Authorize,
+// HTTPRole and WithAnonymous. The client is added to the request context,
+// see FromContext.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(a.public, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := bearer(r.Header.Get("Authorization"), r.Header.Get("X-API-Key"))
+		c, err := a.Authorize(key, r.TLS, HTTPRole(r))
+		if key == "" && errors.Is(err, ErrUnauthenticated) && a.anonymous != nil && a.anonymous.covers(r.URL.Path) {
+			c, err = a.anonymous.authorize(remoteIP(r), HTTPRole(r))
+		}
+		if err != nil {
+			code := httpStatus(err)
+			if code == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="salestax"`)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(struct {
+				Error string `json:"error"`
+			}{err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withClient(r.Context(), c)))
+	})
+}
+
+// httpStatus returns the HTTP status reporting a failed Authorize.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
+
+// GRPCRole returns the role an RPC requires: Admin for those that write,
+// SetRate, and Read for the others.
+func GRPCRole(fullMethod string) Role {
+	if strings.HasSuffix(fullMethod, "/SetRate") {
+		return Admin
+	}
+	return Read
+}
+
+// UnaryServerInterceptor returns an interceptor authorizing every RPC, see
+// Authorize and GRPCRole, and failing those that fail with
+// codes.Unauthenticated, PermissionDenied or ResourceExhausted. The client
+// is added to the RPC context, see FromContext.
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		first := func(name string) string {
+			if v := md.Get(name); len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+		key := bearer(first("authorization"), first("x-api-key"))
+		var state *tls.ConnectionState
+		if p, ok := peer.FromContext(ctx); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				state = &info.State
+			}
+		}
+		c, err := a.Authorize(key, state, GRPCRole(info.FullMethod))
+		if err != nil {
+			return nil, status.Error(grpcCode(err), err.Error())
+		}
+		return handler(withClient(ctx, c), req)
+	}
+}
+
+// grpcCode returns the gRPC status code reporting a failed Authorize.
+func grpcCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return codes.PermissionDenied
+	case errors.Is(err, ErrRateLimited):
+		return codes.ResourceExhausted
+	}
+	return codes.Unauthenticated
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/config"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// benchWorkloads are the operation mixes of -workload. loader is the original
+// demo; the others exercise the cache alone, like the workloads of the
+// lrucache/compare benchmarks, to size the cache for the hardware.
+var benchWorkloads = map[string]struct {
+	reads float64 // fraction of Gets among the operations, the rest Inserts
+	zipf  bool    // a few hot keys instead of uniformly drawn keys
+}{
+	"read":  {reads: 0.9},
+	"write": {reads: 0.1},
+	"zipf":  {reads: 0.75, zipf: true},
+}
+
+// runBench implements "salestax-srv bench", the successor of the original
+// demo: it looks up random addresses from a key space twice the cache size
+// through the 10ms fake loader and reports throughput and hit ratio. The
+// other workloads measure raw cache operations per second instead. With
+// -seed the keys drawn and the evictions are the same from one run to the
+// next, except for the interleaving of concurrent workers.
+func runBench(args []string) error {
+	fs := newFlagSet("bench", "")
+	cacheCfg := config.Default().Cache
+	registerCacheFlags(fs, &cacheCfg)
+	attempts := fs.Int("attempts", 10000, "number of lookups")
+	keys := fs.Int("keys", 0, "size of the key space (default twice -size)")
+	workers := fs.Int("concurrency", 1, "number of concurrent lookup goroutines")
+	seed := fs.Uint64("seed", 0, "seed of the keys drawn and of the random numbers of the cache, for reproducible runs, at some cost in throughput (0 for a random seed)")
+	workload := fs.String("workload", "loader", "loader (lookups through the fake loader), read (90% reads), write (90% writes) or zipf (75% reads of Zipf distributed keys)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	mix, ok := benchWorkloads[*workload]
+	if !ok && *workload != "loader" {
+		return fmt.Errorf("unknown -workload %q", *workload)
+	}
+	var opts []lrucache.Option
+	if *seed != 0 {
+		// which hashes keys more slowly than the default, see WithRand
+		opts = append(opts, lrucache.WithRand(rand.NewPCG(*seed, 0)))
+	} else {
+		*seed = rand.Uint64()
+	}
+	c, err := newCache(cacheCfg, opts...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if *keys <= 0 {
+		*keys = cacheCfg.Size * 2
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	if ok {
+		// start full, as a long running server would be
+		for i := range min(cacheCfg.Size, *keys) {
+			c.Insert(strconv.Itoa(i), float64(i))
+		}
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		n := *attempts / *workers
+		if w < *attempts%*workers {
+			n++
+		}
+		r := rand.New(rand.NewPCG(*seed, uint64(w)+1))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok {
+				operations(c, r, n, *keys, mix.reads, mix.zipf)
+			} else {
+				lookups(c, r, n, *keys)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	st := c.Stats()
+	fmt.Printf("operations:   %d in %v (%.0f ops/s)\n", *attempts, elapsed.Round(time.Millisecond), float64(*attempts)/elapsed.Seconds())
+	fmt.Printf("hit ratio:    %.3f (%d hits, %d misses)\n", st.HitRatio(), st.Hits, st.Misses)
+	fmt.Printf("loader calls: %d\n", st.LoaderCalls)
+	fmt.Printf("evictions:    %d\n", st.Evictions)
+	fmt.Printf("entries:      %d\n", st.Size)
+	return nil
+}
+
+// lookups looks up n keys drawn with r from [0, keys).
+func lookups(c *salestax.Cache, r *rand.Rand, n, keys int) {
+	for i := 0; i < n; i++ {
+		c.GetOrLoad(strconv.Itoa(r.IntN(keys)), sales_tax_lookup)
+	}
+}
+
+// operations runs n cache operations, a fraction reads of them Gets and the
+// rest Inserts, on keys drawn with r from [0, keys).
+func operations(c *salestax.Cache, r *rand.Rand, n, keys int, reads float64, zipf bool) {
+	z := rand.NewZipf(r, 1.1, 1, uint64(keys-1))
+	for i := 0; i < n; i++ {
+		k := r.IntN(keys)
+		if zipf {
+			k = int(z.Uint64())
+		}
+		if r.Float64() < reads {
+			c.Get(strconv.Itoa(k))
+		} else {
+			c.Insert(strconv.Itoa(k), float64(k))
+		}
+	}
+}
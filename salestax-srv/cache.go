@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/addrnorm"
+	"github.com/jared-d-smith/psl/salestax-srv/config"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// policies maps config.Policies to eviction policies.
+var policies = map[string]lrucache.PolicyFactory[string]{
+	"lru":    lrucache.NewLRUPolicy[string],
+	"lfu":    lrucache.NewLFUPolicy[string],
+	"fifo":   lrucache.NewFIFOPolicy[string],
+	"random": lrucache.NewRandomPolicy[string],
+	"arc":    lrucache.NewARCPolicy[string],
+	"slru":   lrucache.NewSLRUPolicy[string],
+}
+
+// keyInterner is the Interner shared by the caches of cache.intern_keys.
+var keyInterner = lrucache.NewInterner[string]()
+
+// registerCacheFlags binds the cache flags shared by serve and bench to cfg,
+// using its current values as defaults.
+func registerCacheFlags(fs *flag.FlagSet, cfg *config.Cache) {
+	fs.IntVar(&cfg.Size, "size", cfg.Size, "maximum number of cached rates")
+	fs.IntVar(&cfg.Shards, "shards", cfg.Shards, "number of independently locked cache shards")
+	fs.DurationVar(&cfg.TTL, "ttl", cfg.TTL, "time to live of cached rates (0 disables expiration)")
+	fs.DurationVar(&cfg.NegativeTTL, "negative-ttl", cfg.NegativeTTL, "remember loader failures for this long (0 disables)")
+	fs.StringVar(&cfg.Policy, "policy", cfg.Policy, "eviction policy: "+strings.Join(config.Policies, ", "))
+	fs.BoolVar(&cfg.Approximate, "approx", cfg.Approximate, "use approximate LRU for lock free hits")
+	fs.BoolVar(&cfg.TinyLFU, "tinylfu", cfg.TinyLFU, "only cache loaded rates requested more often than the rate they would evict")
+	fs.IntVar(&cfg.HotKeys, "hot-keys", cfg.HotKeys, "number of most requested addresses reported by /stats (0 disables)")
+	fs.DurationVar(&cfg.HotWindow, "hot-keys-window", cfg.HotWindow, "window over which -hot-keys counts requests")
+	fs.BoolVar(&cfg.Normalize, "normalize", cfg.Normalize, "normalize addresses (case, punctuation, USPS abbreviations) so spellings of one address share an entry")
+	fs.IntVar(&cfg.History, "history", cfg.History, "maximum number of cached rate histories for ?as_of= lookups (0 disables them)")
+	fs.BoolVar(&cfg.Windows, "stats-windows", cfg.Windows, "report hit ratios and loader latencies over the last 1m, 5m and 1h in /stats")
+}
+
+// newCache builds the cache described by cfg plus any extra options.
+func newCache(cfg config.Cache, extra ...lrucache.Option) (*salestax.Cache, error) {
+	opts, err := cacheOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return salestax.New(cfg.Size, append(opts, extra...)...), nil
+}
+
+// newRateCache is newCache for rate breakdowns.
+func newRateCache(cfg config.Cache, extra ...lrucache.Option) (*salestax.RateCache, error) {
+	opts, err := cacheOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return salestax.NewRateCache(cfg.Size, append(opts, extra...)...), nil
+}
+
+// newHistoryCache is newCache for the rate histories of as-of lookups,
+// holding up to cfg.History of them.
+func newHistoryCache(cfg config.Cache) (*salestax.HistoryCache, error) {
+	opts, err := cacheOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return salestax.NewHistoryCache(cfg.History, opts...), nil
+}
+
+// cacheOptions returns the options of the cache described by cfg.
+func cacheOptions(cfg config.Cache) ([]lrucache.Option, error) {
+	if cfg.Size <= 0 {
+		return nil, fmt.Errorf("-size must be positive, got %d", cfg.Size)
+	}
+	factory, ok := policies[cfg.Policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown -policy %q, want one of %s", cfg.Policy, strings.Join(config.Policies, ", "))
+	}
+	opts := []lrucache.Option{
+		lrucache.WithShards(cfg.Shards),
+		lrucache.WithTTL(cfg.TTL),
+		lrucache.WithNegativeTTL(cfg.NegativeTTL),
+		lrucache.WithPolicy(factory),
+	}
+	if cfg.Approximate {
+		opts = append(opts, lrucache.WithApproximateLRU())
+	}
+	if cfg.TinyLFU {
+		opts = append(opts, lrucache.WithTinyLFU())
+	}
+	if cfg.HotKeys > 0 {
+		opts = append(opts, lrucache.WithHotKeys(cfg.HotKeys, cfg.HotWindow))
+	}
+	if cfg.Normalize {
+		opts = append(opts, lrucache.WithKeyNormalizer(addrnorm.Normalize))
+	}
+	if cfg.HashSalt != "" {
+		opts = append(opts, lrucache.WithKeyCanonicalizer[string](salestax.HashKeys([]byte(cfg.HashSalt))))
+	}
+	if cfg.InternKeys {
+		opts = append(opts, lrucache.WithKeyInterner(keyInterner))
+	}
+	if cfg.Windows {
+		opts = append(opts, lrucache.WithStatsWindows())
+	}
+	if m := cfg.Memory; m.Enabled {
+		opts = append(opts, lrucache.WithMemoryPressure(lrucache.MemoryPressure{
+			Limit:   uint64(m.Limit),
+			High:    m.High,
+			Low:     m.Low,
+			MinSize: m.MinSize,
+		}))
+	}
+	if cfg.TTL > 0 {
+		// don't keep expired rates around until they happen to be looked up
+		opts = append(opts, lrucache.WithSweepInterval(max(cfg.TTL/10, time.Second)))
+	}
+	return opts, nil
+}
@@ -0,0 +1,56 @@
+// Package cache defines Cache, the minimal interface shared by the cache
+// implementations, so that callers can depend on it instead of a concrete
+// cache and swap implementations: an LRUCache in production, Noop or a fake
+// in tests, another library in benchmarks.
+package cache
+
+import "github.com/jared-d-smith/psl/salestax-srv/lrucache"
+
+// Cache is a key value cache. Implementations must be safe for concurrent
+// use.
+type Cache[K comparable, V any] interface {
+	// Get returns the value cached for key and whether there was one.
+	Get(key K) (V, bool)
+	// Set stores value under key. An error means the value was not stored.
+	Set(key K, value V) error
+	// Delete removes key and reports whether it was present.
+	Delete(key K) bool
+	// Len returns the number of cached items.
+	Len() int
+}
+
+// lru adapts an LRUCache to Cache.
+type lru[K comparable, V any] struct {
+	c *lrucache.LRUCache[K, V]
+}
+
+// LRU returns c as a Cache. Get reports expired items as missing.
+func LRU[K comparable, V any](c *lrucache.LRUCache[K, V]) Cache[K, V] {
+	return lru[K, V]{c}
+}
+
+func (l lru[K, V]) Get(key K) (V, bool) {
+	item, err := l.c.Get(key)
+	if err != nil {
+		var zero V
+		return zero, false
+	}
+	return item.Value(), true
+}
+
+func (l lru[K, V]) Set(key K, value V) error { return l.c.Insert(key, value) }
+func (l lru[K, V]) Delete(key K) bool        { return l.c.Delete(key) }
+func (l lru[K, V]) Len() int                 { return l.c.Len() }
+
+// Noop is a Cache that stores nothing: every Get is a miss. It is useful to
+// measure or test a caller without caching.
+type Noop[K comparable, V any] struct{}
+
+func (Noop[K, V]) Get(key K) (V, bool) {
+	var zero V
+	return zero, false
+}
+
+func (Noop[K, V]) Set(key K, value V) error { return nil }
+func (Noop[K, V]) Delete(key K) bool        { return false }
+func (Noop[K, V]) Len() int                 { return 0 }
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+func TestImplementations(t *testing.T) {
+	for name, c := range map[string]Cache[string, int]{
+		"lru": LRU(lrucache.New[string, int](10)),
+	} {
+		if err := c.Set("a", 1); err != nil {
+			t.Fatalf("%s: Set: %v", name, err)
+		}
+		if v, ok := c.Get("a"); !ok || v != 1 {
+			t.Errorf("%s: Get = %d, %v, want 1, true", name, v, ok)
+		}
+		if n := c.Len(); n != 1 {
+			t.Errorf("%s: Len = %d, want 1", name, n)
+		}
+		if !c.Delete("a") || c.Delete("a") {
+			t.Errorf("%s: Delete did not report presence", name)
+		}
+		if _, ok := c.Get("a"); ok {
+			t.Errorf("%s: Get after Delete hit", name)
+		}
+	}
+}
+
+func TestNoop(t *testing.T) {
+	var c Cache[string, int] = Noop[string, int]{}
+	if err := c.Set("a", 1); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("Noop Get hit")
+	}
+	if c.Len() != 0 || c.Delete("a") {
+		t.Error("Noop kept a value")
+	}
+}
@@ -0,0 +1,125 @@
+// Package certreload serves a TLS certificate that is reloaded from its
+// files while the server runs, so that rotating the certificate needs no
+// restart. A Reloader plugs into tls.Config.GetCertificate:
+//
+//	r, err := certreload.New("server.crt", "server.key")
+//	...
+//	cfg := &tls.Config{GetCertificate: r.GetCertificate}
+//	go r.Watch(ctx, 30*time.Second, func(err error) { log.Print(err) })
+//
+// Reload can also be called directly, e.g. on SIGHUP.
+package certreload
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reloader holds the certificate of a certificate and a key file. It is
+// safe for concurrent use.
+type Reloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+
+	mu    sync.Mutex // serializes reloads, guards stamp
+	stamp [2]stamp   // of the files the certificate was loaded from
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	mod  time.Time
+	size int64
+}
+
+// New returns a Reloader of the PEM certificate and key in certFile and
+// keyFile, which are loaded right away.
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from the files again. If that fails the
+// certificate loaded before is kept.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+func (r *Reloader) reload() error {
+	stamps, err := r.stamps()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", r.certFile, err)
+	}
+	r.cert.Store(&cert)
+	r.stamp = stamps
+	return nil
+}
+
+// stamps returns the current stamps of the files.
+func (r *Reloader) stamps() ([2]stamp, error) {
+	var stamps [2]stamp
+	for i, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return stamps, err
+		}
+		stamps[i] = stamp{mod: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps, nil
+}
+
+// Changed reports whether the files changed since the certificate was
+// loaded.
+func (r *Reloader) Changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stamps, err := r.stamps()
+	return err != nil || stamps != r.stamp
+}
+
+// Watch checks the files every interval until ctx is done, and reloads the
+// certificate when they changed. Errors are passed to onError, which may be
+// nil; the certificate loaded before is kept meanwhile. A certificate and
+// key rotated one after the other may fail to load in between, until the
+// next check.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !r.Changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Certificate returns the certificate loaded last.
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// GetCertificate returns the certificate loaded last; it is a
+// tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
@@ -0,0 +1,340 @@
+// Package client is the Go client of salestax-srv, for a single node or a
+// cluster of them. With several nodes it shards lookups across them by
+// consistent hashing: each address is looked up on the node owning it in a
+// Ring, so that every node caches a share of the addresses instead of all
+// of them, and the capacity of the cluster grows with its size.
+//
+// Requests share a pool of keep-alive connections per node. A node that
+// cannot be reached is skipped for a cooldown, its addresses failing over
+// to the next nodes of the ring meanwhile, and requests failing for reasons
+// that may pass are retried with exponential backoff. WithLocalCache keeps
+// the rates received, served while fresh and as a fallback when the nodes
+// fail.
+//
+//	c, err := client.New([]string{"http://salestax:8080"}, client.WithLocalCache(10000, time.Minute))
+//	...
+//	rate, err := c.GetRate(ctx, "1 Main St, Springfield, IL 62701")
+//	if errors.Is(err, salestax.ErrNotFound) {
+//		...
+//	}
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Client looks up rates on the node of a cluster owning each address. It
+// is safe for concurrent use.
+type Client struct {
+	ring     *Ring
+	http     *http.Client
+	failover int
+	cooldown time.Duration
+	replicas int
+	retries  int
+	backoff  time.Duration
+	peer     bool // requests are those of a peer, see Peers
+	apiKey   string
+
+	local    *lrucache.LRUCache[string, localRate] // nil unless WithLocalCache
+	localTTL time.Duration
+
+	mu   sync.Mutex
+	down map[string]time.Time // nodes skipped until the time
+}
+
+// Option configures optional Client behavior, e.g.
+// New(nodes, WithFailover(2)).
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client of requests to the nodes, by default
+// one with a 5 second timeout keeping up to 32 idle connections per node.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) { cl.http = c }
+}
+
+// WithReplicas sets the number of virtual nodes per node of the ring, by
+// default DefaultReplicas.
+func WithReplicas(n int) Option {
+	return func(cl *Client) { cl.replicas = n }
+}
+
+// WithFailover sets how many other nodes are tried, in ring order, when the
+// owner of an address cannot be reached. The default is 1; 0 disables
+// failover.
+func WithFailover(n int) Option {
+	return func(cl *Client) { cl.failover = n }
+}
+
+// WithCooldown sets how long a node that could not be reached is skipped,
+// by default 10 seconds.
+func WithCooldown(d time.Duration) Option {
+	return func(cl *Client) { cl.cooldown = d }
+}
+
+// WithRetries sets how many times a request failing for a reason that may
+// pass is repeated: the nodes could not be reached, or replied 502, 503 or
+// 504. The default is 2; 0 disables retries.
+func WithRetries(n int) Option {
+	return func(cl *Client) { cl.retries = n }
+}
+
+// WithBackoff sets the wait before the first retry, doubled for each
+// further one, by default 100 milliseconds.
+func WithBackoff(d time.Duration) Option {
+	return func(cl *Client) { cl.backoff = d }
+}
+
+// WithAPIKey sends key with every request, for servers requiring
+// authentication.
+func WithAPIKey(key string) Option {
+	return func(cl *Client) { cl.apiKey = key }
+}
+
+// WithLocalCache keeps the last sz rates received by GetRate in memory.
+// They are returned without a request for ttl after they were received,
+// then only when the request fails with anything but salestax.ErrNotFound:
+// a stale rate is deemed better than none while the cluster is down. A
+// zero ttl only keeps them as a fallback.
+func WithLocalCache(sz int, ttl time.Duration) Option {
+	return func(cl *Client) {
+		cl.local = lrucache.New[string, localRate](sz)
+		cl.localTTL = ttl
+	}
+}
+
+// localRate is a rate in the local cache of a Client.
+type localRate struct {
+	rate     salestax.TaxRate
+	received time.Time
+}
+
+// ErrUnavailable is returned, wrapping the error of the last attempt, when
+// none of the nodes for an address could be reached.
+var ErrUnavailable = errors.New("salestax-srv unavailable")
+
+// New returns a client of the nodes at the base URLs of nodes, e.g.
+// "http://10.0.0.1:8080". Every client of a cluster must be given the same
+// nodes to agree on their owners.
+func New(nodes []string, opts ...Option) (*Client, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("no salestax-srv nodes")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+	c := &Client{
+		http:     &http.Client{Timeout: 5 * time.Second, Transport: transport},
+		failover: 1,
+		cooldown: 10 * time.Second,
+		retries:  2,
+		backoff:  100 * time.Millisecond,
+		down:     make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.failover < 0 || c.retries < 0 {
+		return nil, fmt.Errorf("negative failover %d or retries %d", c.failover, c.retries)
+	}
+	bases := make([]string, len(nodes))
+	for i, node := range nodes {
+		u, err := url.Parse(node)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("node %q is not a base URL", node)
+		}
+		bases[i] = strings.TrimSuffix(node, "/")
+	}
+	c.ring = NewRing(c.replicas, bases...)
+	return c, nil
+}
+
+// Node returns the base URL of the node owning address.
+func (c *Client) Node(address string) string {
+	return c.ring.Owner(address)
+}
+
+// GetRate returns the rate of address, looked up on the node owning it or
+// in the local cache. Errors wrap salestax.ErrNotFound for addresses
+// without a rate, ErrUnavailable if no node could be reached, and are a
+// *StatusError for the other error responses.
+func (c *Client) GetRate(ctx context.Context, address string) (salestax.TaxRate, error) {
+	var cached localRate
+	var ok bool
+	if c.local != nil {
+		if item, err := c.local.Get(address); err == nil {
+			cached, ok = item.Value(), true
+			if time.Since(cached.received) < c.localTTL {
+				return cached.rate, nil
+			}
+		}
+	}
+	var body httpserver.RateResponse
+	err := c.get(ctx, address, "/rate/"+url.PathEscape(address), &body)
+	switch {
+	case err == nil:
+		rate := body.TaxRate()
+		if c.local != nil {
+			c.local.Insert(address, localRate{rate: rate, received: time.Now()})
+		}
+		return rate, nil
+	case errors.Is(err, salestax.ErrNotFound):
+		if c.local != nil {
+			c.local.Delete(address)
+		}
+	case ok:
+		return cached.rate, nil
+	}
+	return salestax.TaxRate{}, err
+}
+
+// Tax returns the tax on amount for goods of category at address, which may
+// be empty, calculated by the node owning address.
+func (c *Client) Tax(ctx context.Context, address string, amount float64, category string) (salestax.Calculation, error) {
+	q := url.Values{"amount": {strconv.FormatFloat(amount, 'f', -1, 64)}}
+	if category != "" {
+		q.Set("category", category)
+	}
+	var body httpserver.TaxResponse
+	if err := c.get(ctx, address, "/tax/"+url.PathEscape(address)+"?"+q.Encode(), &body); err != nil {
+		return salestax.Calculation{}, err
+	}
+	return body.Calculation, nil
+}
+
+// get decodes the response to GET path on the node of key, failing over to
+// the next nodes of the ring while they cannot be reached, and retrying
+// while the failure may pass.
+func (c *Client) get(ctx context.Context, key, path string, v any) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.getOnce(ctx, key, path, v)
+		if err == nil || attempt == c.retries || !retryable(err) {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) getOnce(ctx context.Context, key, path string, v any) error {
+	var err error
+	for _, node := range c.nodes(key) {
+		err = c.getNode(ctx, node, path, v)
+		var se *StatusError
+		if err == nil || errors.As(err, &se) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the node did not answer
+		c.mu.Lock()
+		c.down[node] = time.Now().Add(c.cooldown)
+		c.mu.Unlock()
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// retryable reports whether a request failing with err may succeed later.
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch se.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, ErrUnavailable)
+}
+
+// nodes returns the nodes to try for key in order: the owner and failover
+// nodes that are not cooling down, or all of them if all are.
+func (c *Client) nodes(key string) []string {
+	nodes := c.ring.Nodes(key, 1+c.failover)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	up := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if until, ok := c.down[node]; ok && now.Before(until) {
+			continue
+		}
+		delete(c.down, node)
+		up = append(up, node)
+	}
+	if len(up) == 0 {
+		return nodes
+	}
+	return up
+}
+
+func (c *Client) getNode(ctx context.Context, node, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.peer {
+		req.Header.Set(PeerHeader, "1")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		se := &StatusError{Node: node, StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var body httpserver.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+			se.Message = body.Error
+		}
+		return se
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// StatusError is an error response of a node. It wraps salestax.ErrNotFound,
+// ErrThrottled or ErrBackendUnavailable for the responses reporting them.
+type StatusError struct {
+	Node       string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("salestax-srv %s: %d %s", e.Node, e.StatusCode, e.Message)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return salestax.ErrNotFound
+	case http.StatusTooManyRequests:
+		return salestax.ErrThrottled
+	case http.StatusServiceUnavailable:
+		return salestax.ErrBackendUnavailable
+	}
+	return nil
+}
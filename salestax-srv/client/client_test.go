@@ -0,0 +1,249 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(0, "a", "b", "c", "a")
+	if r.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", r.Len())
+	}
+	if NewRing(0).Owner("x") != "" {
+		t.Error("empty ring has an owner")
+	}
+
+	owned := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("%d Main St", i)
+		owned[r.Owner(key)]++
+		nodes := r.Nodes(key, 5)
+		if len(nodes) != 3 || nodes[0] != r.Owner(key) || nodes[1] == nodes[0] || nodes[2] == nodes[1] || nodes[2] == nodes[0] {
+			t.Fatalf("Nodes(%q, 5) = %q", key, nodes)
+		}
+	}
+	for node, n := range owned {
+		if n < 700 || n > 1300 {
+			t.Errorf("node %s owns %d of 3000 keys", node, n)
+		}
+	}
+
+	// adding a node only moves keys to it
+	r4 := NewRing(0, "a", "b", "c", "d")
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("%d Main St", i)
+		if before, after := r.Owner(key), r4.Owner(key); before != after {
+			if after != "d" {
+				t.Fatalf("%q moved from %s to %s", key, before, after)
+			}
+			moved++
+		}
+	}
+	if moved < 500 || moved > 1000 {
+		t.Errorf("%d of 3000 keys moved to the new node, want about 750", moved)
+	}
+}
+
+func TestClient(t *testing.T) {
+	var nodes []string
+	caches := map[string]*salestax.RateCache{}
+	servers := map[string]*httptest.Server{}
+	for i := 0; i < 3; i++ {
+		c := salestax.NewRateCache(100)
+		loader := func(_ context.Context, address string) (salestax.TaxRate, error) {
+			if strings.HasPrefix(address, "nowhere") {
+				return salestax.TaxRate{}, salestax.ErrNotFound
+			}
+			return salestax.Flat(0.05), nil
+		}
+		ts := httptest.NewServer(httpserver.New("", c, loader).Handler())
+		defer ts.Close()
+		nodes = append(nodes, ts.URL)
+		caches[ts.URL], servers[ts.URL] = c, ts
+	}
+	cl, err := New(nodes, WithFailover(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 30; i++ {
+		address := fmt.Sprintf("%d Main St", i)
+		rate, err := cl.GetRate(ctx, address)
+		if err != nil || rate.Total() != 0.05 {
+			t.Fatalf("GetRate(%q) = %v, %v", address, rate, err)
+		}
+		for node, c := range caches {
+			if c.Contains(address) != (node == cl.Node(address)) {
+				t.Errorf("%q cached on %s, owned by %s", address, node, cl.Node(address))
+			}
+		}
+	}
+	if calc, err := cl.Tax(ctx, "1 Main St", 10, ""); err != nil || calc.Tax != 0.5 {
+		t.Errorf("Tax = %+v, %v, want 0.50", calc, err)
+	}
+	_, err = cl.GetRate(ctx, "nowhere")
+	var se *StatusError
+	if !errors.Is(err, salestax.ErrNotFound) || !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Errorf("GetGetRate(nowhere): %v, want a 404 StatusError", err)
+	}
+
+	// the owner goes down: its addresses fail over to the next node
+	owner := cl.Node("1 Main St")
+	servers[owner].Close()
+	if rate, err := cl.GetRate(ctx, "1 Main St"); err != nil || rate.Total() != 0.05 {
+		t.Fatalf("GetRate after the owner went down = %v, %v", rate, err)
+	}
+	if got := cl.nodes("1 Main St"); len(got) != 2 || got[0] == owner {
+		t.Errorf("nodes after the owner went down = %q, want the 2 others", got)
+	}
+
+	if _, err := New(nil); err == nil {
+		t.Error("New without nodes succeeded")
+	}
+	if _, err := New([]string{"localhost:8080"}); err == nil {
+		t.Error("New accepted a node that is not a URL")
+	}
+}
+
+func TestPeers(t *testing.T) {
+	const n = 3
+	var nodes []string
+	handlers := make([]http.Handler, n)
+	for i := 0; i < n; i++ {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		defer ts.Close()
+		nodes = append(nodes, ts.URL)
+	}
+	var mu sync.Mutex
+	loads := map[string][]string{} // node -> addresses loaded from the backend
+	for i, node := range nodes {
+		peers, err := NewPeers(node, nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local := func(_ context.Context, address string) (salestax.TaxRate, error) {
+			mu.Lock()
+			loads[node] = append(loads[node], address)
+			mu.Unlock()
+			return salestax.Flat(0.05), nil
+		}
+		hs := httpserver.New("", salestax.NewRateCache(100), peers.Loader(local))
+		hs.Use(peers.Middleware)
+		handlers[i] = hs.Handler()
+	}
+
+	// every node looks up every address; each is loaded once, by its owner
+	cl, _ := New(nodes)
+	for i := 0; i < 20; i++ {
+		address := fmt.Sprintf("%d Main St", i)
+		for _, node := range nodes {
+			resp, err := http.Get(node + "/rate/" + url.PathEscape(address))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s from %s: %v %v", address, node, resp, err)
+			}
+			resp.Body.Close()
+		}
+		var loaders []string
+		for node, addresses := range loads {
+			if slices.Contains(addresses, address) {
+				loaders = append(loaders, node)
+			}
+		}
+		if len(loaders) != 1 || loaders[0] != cl.Node(address) {
+			t.Errorf("%q loaded by %q, owned by %s", address, loaders, cl.Node(address))
+		}
+	}
+
+	if _, err := NewPeers("http://elsewhere", nodes); err == nil {
+		t.Error("NewPeers accepted a node outside the cluster")
+	}
+}
+
+func TestGetRate(t *testing.T) {
+	var mu sync.Mutex
+	requests, failures := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if failures > 0 {
+			failures--
+			http.Error(w, `{"error": "backend unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		if strings.Contains(r.URL.Path, "nowhere") {
+			http.Error(w, `{"error": "Key not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"address": "x", "rate": 0.07, "components": [{"level": "state", "rate": 0.06}, {"level": "city", "rate": 0.01}]}`))
+	}))
+	defer ts.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+	ctx := context.Background()
+
+	cl, err := New([]string{ts.URL}, WithBackoff(time.Millisecond), WithLocalCache(10, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	failures = 2
+	mu.Unlock()
+	if rate, err := cl.GetRate(ctx, "1 Main St"); err != nil || len(rate.Components) != 2 {
+		t.Fatalf("GetRate after 2 failures = %v, %v", rate, err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	if _, err := cl.GetRate(ctx, "1 Main St"); err != nil || count() != 3 {
+		t.Errorf("fresh rate not served from the local cache: %v, %d requests", err, count())
+	}
+	if _, err := cl.GetRate(ctx, "nowhere"); !errors.Is(err, salestax.ErrNotFound) {
+		t.Errorf("GetRate(nowhere): %v, want ErrNotFound", err)
+	}
+
+	// a stale rate is the fallback while the server is down
+	stale, _ := New([]string{ts.URL}, WithRetries(0), WithLocalCache(10, 0))
+	if _, err := stale.GetRate(ctx, "1 Main St"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	failures = 1
+	mu.Unlock()
+	if rate, err := stale.GetRate(ctx, "1 Main St"); err != nil || rate.Total() == 0 {
+		t.Errorf("GetRate while failing = %v, %v, want the stale rate", rate, err)
+	}
+	mu.Lock()
+	failures = 1
+	mu.Unlock()
+	var se *StatusError
+	if _, err := stale.GetRate(ctx, "2 Oak St"); !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable || se.Message != "backend unavailable" {
+		t.Errorf("GetRate(2 Oak St) while failing: %v, want a 503 StatusError", err)
+	}
+
+	ts.Close()
+	down, _ := New([]string{ts.URL}, WithRetries(1), WithBackoff(time.Millisecond))
+	if _, err := down.GetRate(ctx, "1 Main St"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("GetRate with the server down: %v, want ErrUnavailable", err)
+	}
+}
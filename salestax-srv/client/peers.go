@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// PeerHeader marks the requests a node sends to the peer owning a key, so
+// that the peer loads the key itself instead of asking further.
+const PeerHeader = "X-Salestax-Peer"
+
+type peerKey struct{}
+
+// Peers fills the cache of a node from the caches of the other nodes of its
+// cluster, groupcache style: on a miss the node asks the peer owning the
+// key, whose own miss is the only call of the backend for the key in the
+// whole cluster. Each node still caches what its peers returned, for the
+// TTL of its cache.
+type Peers struct {
+	self   string
+	client *Client
+}
+
+// NewPeers returns the peers of the node self, one of the base URLs of
+// nodes, which all nodes must be given alike; see New for the options.
+func NewPeers(self string, nodes []string, opts ...Option) (*Peers, error) {
+	self = strings.TrimSuffix(self, "/")
+	// answers are either the owner's or loaded locally, never another
+	// peer's, and the loader of the node has retries of its own
+	c, err := New(nodes, append(opts, WithFailover(0), WithRetries(0))...)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(c.ring.nodes, self) {
+		return nil, fmt.Errorf("node %q is not one of the peers", self)
+	}
+	c.peer = true
+	return &Peers{self: self, client: c}, nil
+}
+
+// Owner returns the base URL of the peer owning key.
+func (p *Peers) Owner(key string) string {
+	return p.client.Node(key)
+}
+
+// Loader returns a loader asking the owner of a key for it, and calling
+// local for the keys the node owns, those a peer asks for, and those whose
+// owner cannot be reached. Keys the owner does not know are not found
+// without calling local.
+func (p *Peers) Loader(local salestax.RateLoaderFuncCtx) salestax.RateLoaderFuncCtx {
+	return func(ctx context.Context, key string) (salestax.TaxRate, error) {
+		if p.Owner(key) == p.self || ctx.Value(peerKey{}) != nil {
+			return local(ctx, key)
+		}
+		rate, err := p.client.GetRate(ctx, key)
+		var se *StatusError
+		if err == nil || errors.As(err, &se) || ctx.Err() != nil {
+			return rate, err
+		}
+		return local(ctx, key)
+	}
+}
+
+// Middleware marks the context of requests sent by a peer, so that Loader
+// loads them locally. Every node of the cluster must use it.
+func (p *Peers) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(PeerHeader) != "" {
+			r = r.WithContext(context.WithValue(r.Context(), peerKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// DefaultReplicas is the number of virtual nodes a Ring places per node.
+const DefaultReplicas = 100
+
+// Ring is a consistent hash ring of nodes. Each node is placed at replicas
+// points of the ring and owns the keys hashing up to each of them, so that
+// adding or removing a node moves only the keys it gains or loses. A Ring
+// is immutable and safe for concurrent use.
+type Ring struct {
+	points []uint64
+	owners map[uint64]string
+	nodes  []string
+}
+
+// NewRing returns a ring of nodes with replicas virtual nodes each, or
+// DefaultReplicas if replicas is not positive. Duplicate nodes are ignored.
+func NewRing(replicas int, nodes ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{owners: make(map[uint64]string)}
+	for _, node := range nodes {
+		if slices.Contains(r.nodes, node) {
+			continue
+		}
+		r.nodes = append(r.nodes, node)
+		for i := 0; i < replicas; i++ {
+			p := hash(node + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[p]; taken {
+				continue
+			}
+			r.owners[p] = node
+			r.points = append(r.points, p)
+		}
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+// Len returns the number of nodes of the ring.
+func (r *Ring) Len() int {
+	return len(r.nodes)
+}
+
+// Owner returns the node owning key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if nodes := r.Nodes(key, 1); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return ""
+}
+
+// Nodes returns up to n distinct nodes for key: its owner, then the nodes
+// that would own it if the ones before were removed, in order.
+func (r *Ring) Nodes(key string, n int) []string {
+	n = min(n, len(r.nodes))
+	if n <= 0 {
+		return nil
+	}
+	out := make([]string, 0, n)
+	i, _ := slices.BinarySearch(r.points, hash(key))
+	for j := 0; len(out) < n; j++ {
+		node := r.owners[r.points[(i+j)%len(r.points)]]
+		if !slices.Contains(out, node) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// hash is FNV-1a, mixed so that similar keys, e.g. the virtual nodes of a
+// node, spread over the whole ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,684 @@
+// Package config loads the salestax-srv server configuration from a YAML file
+// with environment variable overrides.
+//
+// A configuration file looks like
+//
+//	cache:
+//	  size: 100000
+//	  shards: 16
+//	  ttl: 24h
+//	  policy: slru
+//	loader:
+//	  backend: http
+//	  url: http://rates.internal/v1/rate
+//	redis:
+//	  addr: redis.internal:6379
+//	http:
+//	  addr: ":8080"
+//	grpc:
+//	  addr: ":9090"
+//
+// Every setting can be overridden by an environment variable named after its
+// path, e.g. SALESTAX_CACHE_SIZE=200000 or SALESTAX_REDIS_ADDR=localhost:6379.
+// Lists are comma separated.
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable override.
+const EnvPrefix = "SALESTAX"
+
+// Policies lists the eviction policy names accepted in cache.policy.
+var Policies = []string{"arc", "fifo", "lfu", "lru", "random", "slru"}
+
+// Backends lists the loader backends accepted in loader.backend.
+var Backends = []string{"avalara", "canada", "csv", "euvat", "fake", "http", "none", "taxjar"}
+
+// Config is the complete server configuration.
+type Config struct {
+	Cache      Cache      `yaml:"cache"`
+	Loader     Loader     `yaml:"loader"`
+	Redis      Redis      `yaml:"redis"`
+	Memcached  Memcached  `yaml:"memcached"`
+	Disk       Disk       `yaml:"disk"`
+	Peers      Peers      `yaml:"peers"`
+	HTTP       HTTP       `yaml:"http"`
+	GRPC       Listener   `yaml:"grpc"`
+	Memcache   Listener   `yaml:"memcache"` // memcached text protocol, disabled by default
+	RESP       Listener   `yaml:"resp"`     // Redis protocol, disabled by default
+	Snapshot   Snapshot   `yaml:"snapshot"`
+	WAL        WAL        `yaml:"wal"`
+	Encryption Encryption `yaml:"encryption"`
+	Shutdown   Shutdown   `yaml:"shutdown"`
+	Watch      Watch      `yaml:"watch"`
+	Replica    Replica    `yaml:"replica"`
+	Tax        Tax        `yaml:"tax"`
+	Tenants    []Tenant   `yaml:"tenants"` // served by the HTTP server besides the default one
+	Warm       string     `yaml:"warm"`    // CSV or JSON file loaded before serving
+	Log        Log        `yaml:"log"`
+	Tracing    Tracing    `yaml:"tracing"`
+	Admin      Admin      `yaml:"admin"`
+	Auth       Auth       `yaml:"auth"`
+	TLS        TLS        `yaml:"tls"`
+	Debug      bool       `yaml:"debug"` // serve /debug/pprof and /debug/cache over HTTP
+}
+
+// Cache configures the in-process cache.
+type Cache struct {
+	Size        int           `yaml:"size"`
+	Shards      int           `yaml:"shards"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+	Policy      string        `yaml:"policy"`
+	Approximate bool          `yaml:"approximate"`
+	TinyLFU     bool          `yaml:"tinylfu"`
+	HotKeys     int           `yaml:"hot_keys"`        // most requested addresses tracked for /stats, 0 disables
+	HotWindow   time.Duration `yaml:"hot_keys_window"` // over which they are counted
+	Windows     bool          `yaml:"stats_windows"`   // add 1m, 5m and 1h hit ratios and load latencies to /stats
+	Normalize   bool          `yaml:"normalize"`       // key rates by addrnorm.Normalize(address)
+	History     int           `yaml:"history"`         // rate histories cached for as-of lookups, 0 disables them
+	InternKeys  bool          `yaml:"intern_keys"`     // store an address once for all caches, see lrucache.Interner
+	// HashSalt, if not empty, keys rates by the salted hash of their
+	// address, see salestax.HashKeys, so that addresses are held neither in
+	// memory nor in snapshots, logs, metrics or debug endpoints. It is best
+	// given as SALESTAX_CACHE_HASH_SALT, and shared by the instances sharing
+	// a second tier. The snapshot, the write-ahead log and the disk tier
+	// written before it was set still hold addresses, and are best removed.
+	HashSalt string `yaml:"hash_salt"`
+	// Tenants is the number of rates the tenants cache together, of which
+	// each is guaranteed its size; what their sizes leave is lent to those
+	// filling their cache, see tenant.Pool. 0 caps each at its size.
+	Tenants int    `yaml:"tenants"`
+	Memory  Memory `yaml:"memory"`
+}
+
+// Memory shrinks the cache while the memory in use is over High of Limit
+// bytes, or of the soft limit of GOMEMLIMIT if Limit is 0, and regrows it to
+// cache.size once it is back under Low; see lrucache.WithMemoryPressure. It
+// is disabled unless Enabled.
+type Memory struct {
+	Enabled bool    `yaml:"enabled"`
+	Limit   int     `yaml:"limit"`
+	High    float64 `yaml:"high"`
+	Low     float64 `yaml:"low"`
+	MinSize int     `yaml:"min_size"` // never shrunk below, by default a tenth of cache.size
+}
+
+// Loader selects the backend rates are loaded from on a miss.
+type Loader struct {
+	Backend     string        `yaml:"backend"`
+	URL         string        `yaml:"url"`
+	Table       string        `yaml:"table"` // ZIP code rate table of the csv backend
+	Timeout     time.Duration `yaml:"timeout"`
+	Concurrency int           `yaml:"concurrency"` // max loader calls in flight, 0 for no limit
+	Rate        float64       `yaml:"rate"`        // max loader calls per second, 0 for no limit
+	FailFast    bool          `yaml:"fail_fast"`   // fail misses over a limit instead of queueing them
+	Breaker     Breaker       `yaml:"breaker"`
+	Retry       Retry         `yaml:"retry"`
+	Avalara     Avalara       `yaml:"avalara"`
+	TaxJar      TaxJar        `yaml:"taxjar"`
+	// International routes Canadian and EU addresses to the canada and
+	// euvat backends and only the others to Backend.
+	International bool `yaml:"international"`
+}
+
+// Avalara configures the avalara backend, the Avalara AvaTax tax rates API.
+// The credentials are best given as SALESTAX_LOADER_AVALARA_ACCOUNT_ID and
+// SALESTAX_LOADER_AVALARA_LICENSE_KEY.
+type Avalara struct {
+	URL        string `yaml:"url"` // e.g. https://sandbox-rest.avatax.com, production if empty
+	AccountID  string `yaml:"account_id"`
+	LicenseKey string `yaml:"license_key"`
+}
+
+// TaxJar configures the taxjar backend, the TaxJar rates API. The token is
+// best given as SALESTAX_LOADER_TAXJAR_TOKEN.
+type TaxJar struct {
+	URL   string `yaml:"url"` // e.g. https://api.sandbox.taxjar.com, production if empty
+	Token string `yaml:"token"`
+}
+
+// Retry configures retrying failed loader calls. Attempts below 2 disable
+// it.
+type Retry struct {
+	Attempts   int           `yaml:"attempts"`    // loader calls per miss, including the first
+	Backoff    time.Duration `yaml:"backoff"`     // wait before the first retry, doubled for each further one
+	MaxBackoff time.Duration `yaml:"max_backoff"` // cap of the wait, 0 for none
+	Jitter     float64       `yaml:"jitter"`      // randomize waits by up to this fraction
+}
+
+// Breaker configures the circuit breaker around the loader. Zero Failures
+// disables it.
+type Breaker struct {
+	Failures int           `yaml:"failures"` // consecutive loader errors that open the breaker
+	Cooldown time.Duration `yaml:"cooldown"` // how long it stays open before a trial call
+}
+
+// Redis configures Redis as the shared second cache tier. An empty Addr
+// disables it. Channel is the pub/sub channel on which the instances
+// sharing it broadcast invalidations; empty disables them.
+type Redis struct {
+	Addr    string `yaml:"addr"`
+	Prefix  string `yaml:"prefix"`
+	Channel string `yaml:"channel"`
+}
+
+// Memcached configures memcached as the shared second cache tier. No servers
+// disables it.
+type Memcached struct {
+	Servers []string `yaml:"servers"`
+	Prefix  string   `yaml:"prefix"`
+}
+
+// Disk configures the bbolt file at Path as the second cache tier, local to
+// the node: every rate cached is written to it, so that the misses of the
+// cache, bounded by its size, are answered from the disk. An empty Path
+// disables it. Expired rates are removed every Sweep. NoSync trades the
+// file surviving a power loss for faster writes.
+type Disk struct {
+	Path   string        `yaml:"path"`
+	Sweep  time.Duration `yaml:"sweep"`
+	NoSync bool          `yaml:"no_sync"`
+}
+
+// Peers configures peer-to-peer cache filling: on a miss a node asks the
+// node of Nodes owning the address, by consistent hashing, before its
+// loader. Self is the base URL of this node, one of Nodes, e.g.
+// http://10.0.0.1:8080. No Nodes disables it.
+type Peers struct {
+	Self  string   `yaml:"self"`
+	Nodes []string `yaml:"nodes"`
+	Key   string   `yaml:"key"` // API key presented to the other nodes, required with auth
+}
+
+// Listener is a server listen address. An empty Addr disables the server.
+type Listener struct {
+	Addr string `yaml:"addr"`
+}
+
+// HTTP configures the HTTP server: its listen address, empty to disable it,
+// the addresses a POST /rates:batchGet, or a BulkGetRates of the gRPC
+// server, may look up, and the origins of the web pages that may call it
+// from the browser.
+type HTTP struct {
+	Addr     string `yaml:"addr"`
+	MaxBatch int    `yaml:"max_batch"`
+	CORS     CORS   `yaml:"cors"`
+}
+
+// CORS configures the Cross-Origin Resource Sharing of the HTTP server. An
+// empty Origins disables it; "*" allows any origin and
+// "https://*.example.com" the subdomains of example.com.
+type CORS struct {
+	Origins []string      `yaml:"origins"`
+	MaxAge  time.Duration `yaml:"max_age"` // how long browsers cache preflights
+}
+
+// Admin configures the admin API of the HTTP server, which changes the
+// cache settings at runtime. An empty Token disables it. The token is best
+// given as SALESTAX_ADMIN_TOKEN.
+type Admin struct {
+	Token string `yaml:"token"`
+}
+
+// Auth configures the authentication and authorization of the clients of
+// the servers, which the memcached and Redis protocol servers take only by
+// API key. Clients is a JSON file of their API keys or
+// client certificate names, roles and rate limits; see package auth. Empty
+// disables it.
+type Auth struct {
+	Clients   string    `yaml:"clients"`
+	Anonymous Anonymous `yaml:"anonymous"`
+}
+
+// Anonymous lets HTTP requests without credentials, e.g. those of the
+// browsers of a storefront, make read-only requests to the paths starting
+// with one of Paths, up to Rate per second and bursts of Burst from each
+// IP. A zero Rate disables it; without Clients it makes the whole server
+// read-only.
+type Anonymous struct {
+	Rate  float64  `yaml:"rate"`
+	Burst int      `yaml:"burst"` // by default Rate
+	Paths []string `yaml:"paths"`
+}
+
+// Enabled reports whether a authenticates the clients.
+func (a Auth) Enabled() bool {
+	return a.Clients != "" || a.Anonymous.Rate > 0
+}
+
+// TLS configures serving HTTP and gRPC over TLS, with the certificate and
+// key in the PEM files Cert and Key. They are reloaded when they change,
+// checked every Reload, and on SIGHUP. ClientCA, a PEM file of CA
+// certificates read at startup, makes the servers verify the client
+// certificates signed by them, for mutual TLS. An empty Cert disables it.
+type TLS struct {
+	Cert     string        `yaml:"cert"`
+	Key      string        `yaml:"key"`
+	Reload   time.Duration `yaml:"reload"` // 0 only reloads on SIGHUP
+	ClientCA string        `yaml:"client_ca"`
+}
+
+// Snapshot configures saving the cache across restarts. An empty Path
+// disables it.
+type Snapshot struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Store    Store         `yaml:"store"`
+}
+
+// Store configures uploading every snapshot saved to the bucket Bucket of
+// an S3 compatible object storage at Endpoint, e.g.
+// https://s3.us-east-1.amazonaws.com or https://storage.googleapis.com,
+// under Prefix, keeping the last Keep of them, 0 for all. An instance
+// without a snapshot at startup downloads the latest, so that new instances
+// start warm. An empty Bucket disables it. The keys are best given as
+// SALESTAX_SNAPSHOT_STORE_ACCESS_KEY and SALESTAX_SNAPSHOT_STORE_SECRET_KEY.
+type Store struct {
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Keep      int    `yaml:"keep"`
+}
+
+// WAL configures the write-ahead log of the changes to the cache in the
+// directory Dir, empty for none, replayed at startup after the snapshot:
+// segments of up to MaxSize bytes, at most MaxSegments of them, written out
+// every Sync. Saving a snapshot removes the segments it made redundant.
+type WAL struct {
+	Dir         string        `yaml:"dir"`
+	MaxSize     int           `yaml:"max_size"`
+	MaxSegments int           `yaml:"max_segments"`
+	Sync        time.Duration `yaml:"sync"`
+}
+
+// Encryption configures the AES-256-GCM encryption of the snapshots, the
+// WAL segments and the disk tier, those uploaded to object storage
+// included. Key is the base64 of a 32 bytes key, best given as
+// SALESTAX_ENCRYPTION_KEY, or with KMS the key wrapped by a key management
+// service: KMS is then the command printing the key unwrapped from its
+// standard input. OldKeys, in the same form as Key, still decrypt the files
+// written before a rotation. An empty Key disables it, and files written
+// encrypted fail to load.
+type Encryption struct {
+	Key     string   `yaml:"key"`
+	OldKeys []string `yaml:"old_keys"`
+	KMS     []string `yaml:"kms"`
+}
+
+// Shutdown configures how the servers stop on SIGTERM or SIGINT: they fail
+// /readyz for Delay, so load balancers stop sending requests, then stop
+// accepting connections and wait up to Timeout for those in flight.
+type Shutdown struct {
+	Delay   time.Duration `yaml:"delay"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Watch configures GET /watch, the stream of rate changes of the HTTP
+// server: how many events are kept for the watchers that reconnect, and how
+// many may be queued for one before it is dropped.
+type Watch struct {
+	Enabled bool `yaml:"enabled"`
+	History int  `yaml:"history"`
+	Buffer  int  `yaml:"buffer"`
+}
+
+// Replica makes the server a read-only replica of the primary at the base
+// URL Primary, empty for none: it pulls the snapshot of the primary every
+// Interval, follows its change stream in between if Watch, and loads its
+// misses from the primary instead of the loader backend. Key is the admin
+// token or the API key of an admin client of the primary, best given as
+// SALESTAX_REPLICA_KEY.
+type Replica struct {
+	Primary  string        `yaml:"primary"`
+	Interval time.Duration `yaml:"interval"`
+	Watch    bool          `yaml:"watch"`
+	Key      string        `yaml:"key"`
+}
+
+// Tax configures the rounding of tax calculations by GET /tax, the
+// taxability rules of product categories, the calendar of tax holidays and
+// the exemption certificates of customers. An empty Taxability or Holidays
+// disables them, as does a zero Customers the exemptions.
+type Tax struct {
+	Rounding   string `yaml:"rounding"`   // half-up or half-even (banker's rounding)
+	Precision  int    `yaml:"precision"`  // decimal places of tax amounts
+	PerLine    bool   `yaml:"per_line"`   // round each jurisdiction's tax instead of the total
+	Taxability string `yaml:"taxability"` // CSV matrix of category, level, code, treatment and rate
+	RulesSize  int    `yaml:"rules_size"` // taxability rules cached
+	Holidays   string `yaml:"holidays"`   // JSON calendar of tax holidays
+	Exemptions string `yaml:"exemptions"` // JSON list of exemption certificates, else only those PUT
+	Customers  int    `yaml:"customers"`  // customers whose exemptions are cached
+}
+
+// Tenant is a tenant of the HTTP server, whose rates are cached apart from
+// those of the other tenants, in up to Size of them, and whose taxes are
+// rounded and whose categories are taxed as configured here; see package
+// tenant. Settings left empty are those of tax. Its requests are those of
+// the auth clients of the tenant, or those naming it in the X-Tenant header.
+type Tenant struct {
+	Name       string `yaml:"name"`
+	Size       int    `yaml:"size"` // quota of rates cached, by default cache.size
+	Rounding   string `yaml:"rounding"`
+	Precision  int    `yaml:"precision"`
+	PerLine    bool   `yaml:"per_line"`
+	Taxability string `yaml:"taxability"`
+	Holidays   string `yaml:"holidays"`
+}
+
+// TenantTax returns the tax configuration of t, that of c.Tax but for what
+// t sets. Tenants have no exemption certificates.
+func (c Config) TenantTax(t Tenant) Tax {
+	tax := c.Tax
+	if t.Rounding != "" {
+		tax.Rounding = t.Rounding
+	}
+	if t.Precision > 0 {
+		tax.Precision = t.Precision
+	}
+	tax.PerLine = tax.PerLine || t.PerLine
+	if t.Taxability != "" {
+		tax.Taxability = t.Taxability
+	}
+	if t.Holidays != "" {
+		tax.Holidays = t.Holidays
+	}
+	tax.Exemptions, tax.Customers = "", 0
+	return tax
+}
+
+// RoundingModes lists the modes accepted in tax.rounding.
+var RoundingModes = []string{"half-up", "half-even"}
+
+// Log configures the server log.
+type Log struct {
+	Level  string    `yaml:"level"` // debug, info, warn or error
+	Access AccessLog `yaml:"access"`
+}
+
+// AccessLog configures the access log of the HTTP server: the share Sample
+// of the requests logged, from 0 (disabled) to 1 (all), and the latency
+// from which a request is always logged, 0 for none. Server errors are
+// always logged while it is enabled.
+type AccessLog struct {
+	Sample float64       `yaml:"sample"`
+	Slow   time.Duration `yaml:"slow"`
+}
+
+// LogLevels lists the levels accepted in log.level.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Tracing configures OpenTelemetry tracing.
+type Tracing struct {
+	Exporter string `yaml:"exporter"` // none, stdout or otlp
+	// Endpoint is the OTLP/HTTP collector URL. If empty the standard
+	// OTEL_EXPORTER_OTLP_* environment variables apply.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// TracingExporters lists the exporters accepted in tracing.exporter.
+var TracingExporters = []string{"none", "stdout", "otlp"}
+
+// Default returns the configuration used for settings that are not given.
+func Default() Config {
+	return Config{
+		Cache: Cache{
+			Size:      50000,
+			Shards:    1,
+			Policy:    "lru",
+			HotWindow: 5 * time.Minute,
+			Memory:    Memory{High: 0.9, Low: 0.7},
+		},
+		Redis:     Redis{Prefix: "salestax:"},
+		Memcached: Memcached{Prefix: "salestax:"},
+		Disk:      Disk{Sweep: time.Hour},
+		Snapshot:  Snapshot{Store: Store{Prefix: "salestax/", Region: "us-east-1", Keep: 24}},
+		HTTP:      HTTP{Addr: ":8080", MaxBatch: 100, CORS: CORS{MaxAge: 10 * time.Minute}},
+		GRPC:      Listener{Addr: ":9090"},
+		Log:       Log{Level: "info"},
+		Tax:       Tax{Rounding: "half-up", Precision: 2, RulesSize: 10000, Customers: 10000},
+		Tracing:   Tracing{Exporter: "none"},
+		TLS:       TLS{Reload: time.Minute},
+		Auth:      Auth{Anonymous: Anonymous{Paths: []string{"/rate/", "/rates:batchGet", "/tax/"}}},
+		Shutdown:  Shutdown{Timeout: 10 * time.Second},
+		Watch:     Watch{History: 1024, Buffer: 256},
+		Replica:   Replica{Interval: time.Minute},
+		WAL:       WAL{MaxSize: 64 << 20, MaxSegments: 8, Sync: time.Second},
+		Loader: Loader{
+			Backend: "fake",
+			Timeout: 5 * time.Second,
+			Breaker: Breaker{Cooldown: 30 * time.Second},
+			Retry:   Retry{Backoff: 50 * time.Millisecond, Jitter: 0.2},
+		},
+	}
+}
+
+// Load returns the default configuration overlaid with the file at path (if
+// path is not empty) and then with the environment. The result is not
+// validated, so that command line flags can still be applied; call Validate
+// when done.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides settings with the environment variables found by
+// lookup, e.g. os.LookupEnv.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, s); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, s string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.String:
+		field.SetString(s)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Cache.Size > 0, "cache.size must be positive, got %d", c.Cache.Size)
+	check(c.Cache.Shards > 0, "cache.shards must be positive, got %d", c.Cache.Shards)
+	check(c.Cache.TTL >= 0, "cache.ttl must not be negative, got %v", c.Cache.TTL)
+	check(c.Cache.NegativeTTL >= 0, "cache.negative_ttl must not be negative, got %v", c.Cache.NegativeTTL)
+	check(c.Cache.History >= 0, "cache.history must not be negative, got %d", c.Cache.History)
+	check(c.Cache.HotKeys >= 0, "cache.hot_keys must not be negative, got %d", c.Cache.HotKeys)
+	check(c.Cache.HotWindow >= 0, "cache.hot_keys_window must not be negative, got %v", c.Cache.HotWindow)
+	if m := c.Cache.Memory; m.Enabled {
+		check(m.Limit >= 0, "cache.memory.limit must not be negative, got %d", m.Limit)
+		check(0 < m.Low && m.Low < m.High && m.High <= 1, "cache.memory.low %v and high %v must be 0 < low < high <= 1", m.Low, m.High)
+		check(m.MinSize >= 0 && m.MinSize <= c.Cache.Size, "cache.memory.min_size must be between 0 and cache.size, got %d", m.MinSize)
+	}
+	check(slices.Contains(Policies, c.Cache.Policy), "cache.policy %q is not one of %s", c.Cache.Policy, strings.Join(Policies, ", "))
+	check(slices.Contains(Backends, c.Loader.Backend), "loader.backend %q is not one of %s", c.Loader.Backend, strings.Join(Backends, ", "))
+	check(c.Loader.Backend != "http" || c.Loader.URL != "", "loader.url is required with loader.backend http")
+	check(c.Loader.Backend != "csv" || c.Loader.Table != "", "loader.table is required with loader.backend csv")
+	check(c.Loader.Backend != "avalara" || c.Loader.Avalara.AccountID != "" && c.Loader.Avalara.LicenseKey != "", "loader.avalara.account_id and license_key are required with loader.backend avalara")
+	check(c.Loader.Backend != "taxjar" || c.Loader.TaxJar.Token != "", "loader.taxjar.token is required with loader.backend taxjar")
+	check(slices.Contains(RoundingModes, c.Tax.Rounding), "tax.rounding %q is not one of %s", c.Tax.Rounding, strings.Join(RoundingModes, ", "))
+	check(c.Tax.Precision >= 0 && c.Tax.Precision <= 6, "tax.precision must be between 0 and 6, got %d", c.Tax.Precision)
+	check(c.Tax.RulesSize > 0, "tax.rules_size must be positive, got %d", c.Tax.RulesSize)
+	check(c.Tax.Customers >= 0, "tax.customers must not be negative, got %d", c.Tax.Customers)
+	check(c.Tax.Exemptions == "" || c.Tax.Customers > 0, "tax.exemptions requires positive tax.customers")
+	check(c.Loader.Timeout > 0, "loader.timeout must be positive, got %v", c.Loader.Timeout)
+	check(c.Loader.Concurrency >= 0, "loader.concurrency must not be negative, got %d", c.Loader.Concurrency)
+	check(c.Loader.Rate >= 0, "loader.rate must not be negative, got %v", c.Loader.Rate)
+	check(c.Loader.Breaker.Failures >= 0, "loader.breaker.failures must not be negative, got %d", c.Loader.Breaker.Failures)
+	check(c.Loader.Retry.Attempts >= 0, "loader.retry.attempts must not be negative, got %d", c.Loader.Retry.Attempts)
+	check(c.Loader.Retry.Jitter >= 0 && c.Loader.Retry.Jitter <= 1, "loader.retry.jitter must be between 0 and 1, got %v", c.Loader.Retry.Jitter)
+	check(c.Loader.Breaker.Failures == 0 || c.Loader.Breaker.Cooldown > 0, "loader.breaker.cooldown must be positive, got %v", c.Loader.Breaker.Cooldown)
+	check(c.Redis.Addr == "" || len(c.Memcached.Servers) == 0, "redis and memcached are both configured, pick one second tier")
+	check(c.Redis.Channel == "" || c.Redis.Addr != "", "redis.channel requires redis.addr")
+	check(c.Disk.Path == "" || c.Redis.Addr == "" && len(c.Memcached.Servers) == 0, "disk and redis or memcached are both configured, pick one second tier")
+	check(c.Disk.Sweep > 0, "disk.sweep must be positive, got %v", c.Disk.Sweep)
+	check(len(c.Peers.Nodes) == 0 || slices.Contains(c.Peers.Nodes, c.Peers.Self), "peers.self %q is not one of peers.nodes", c.Peers.Self)
+	check(len(c.Peers.Nodes) == 0 || c.HTTP.Addr != "", "peers require http.addr")
+	check(c.HTTP.Addr != "" || c.GRPC.Addr != "" || c.Memcache.Addr != "" || c.RESP.Addr != "", "http.addr, grpc.addr, memcache.addr and resp.addr are all empty, nothing to serve")
+	check(!c.Debug || c.HTTP.Addr != "", "debug requires http.addr")
+	check(c.Admin.Token == "" || c.HTTP.Addr != "", "admin.token requires http.addr")
+	check(c.Admin.Token == "" || !c.Auth.Enabled(), "admin.token and auth are exclusive, with auth the admin API is open to admin clients")
+	check(c.Auth.Clients != "" || c.Auth.Anonymous.Rate == 0 || c.Memcache.Addr == "" && c.RESP.Addr == "", "auth.anonymous does not cover memcache.addr and resp.addr, which take the API keys of auth.clients")
+	check(c.Auth.Clients == "" || len(c.Peers.Nodes) == 0 || c.Peers.Key != "", "peers.key is required with auth.clients")
+	check(c.Auth.Clients != "" || c.Auth.Anonymous.Rate == 0 || len(c.Peers.Nodes) == 0, "auth.anonymous with peers requires auth.clients, listing peers.key")
+	check(c.Auth.Anonymous.Rate >= 0 && c.Auth.Anonymous.Burst >= 0, "auth.anonymous.rate and burst must not be negative")
+	check(c.Auth.Anonymous.Rate == 0 || len(c.Auth.Anonymous.Paths) > 0, "auth.anonymous.paths is required with auth.anonymous.rate")
+	for _, o := range c.HTTP.CORS.Origins {
+		u, err := url.Parse(o)
+		check(o == "*" || err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "", "http.cors.origins: %q is not *, nor a scheme and host", o)
+	}
+	check(len(c.HTTP.CORS.Origins) == 0 || c.HTTP.Addr != "", "http.cors.origins requires http.addr")
+	check(c.HTTP.CORS.MaxAge >= 0, "http.cors.max_age must not be negative, got %v", c.HTTP.CORS.MaxAge)
+	check((c.TLS.Cert == "") == (c.TLS.Key == ""), "tls.cert and tls.key must be given together")
+	check(c.TLS.ClientCA == "" || c.TLS.Cert != "", "tls.client_ca requires tls.cert")
+	check(c.TLS.Reload >= 0, "tls.reload must not be negative, got %v", c.TLS.Reload)
+	check(c.Snapshot.Interval >= 0, "snapshot.interval must not be negative, got %v", c.Snapshot.Interval)
+	check(c.Snapshot.Interval == 0 || c.Snapshot.Path != "", "snapshot.interval requires snapshot.path")
+	if st := c.Snapshot.Store; st.Bucket != "" {
+		u, err := url.Parse(st.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "snapshot.store.endpoint %q is not an http or https URL", st.Endpoint)
+		check(c.Snapshot.Path != "", "snapshot.store.bucket requires snapshot.path")
+		check((st.AccessKey == "") == (st.SecretKey == ""), "snapshot.store.access_key and secret_key must be given together")
+	}
+	check(c.Snapshot.Store.Keep >= 0, "snapshot.store.keep must not be negative, got %d", c.Snapshot.Store.Keep)
+	check(c.WAL.MaxSize > 0, "wal.max_size must be positive, got %d", c.WAL.MaxSize)
+	check(c.WAL.MaxSegments > 0, "wal.max_segments must be positive, got %d", c.WAL.MaxSegments)
+	check(c.WAL.Sync > 0, "wal.sync must be positive, got %v", c.WAL.Sync)
+	check(c.Cache.HashSalt == "" || len(c.Cache.HashSalt) >= 16, "cache.hash_salt must be at least 16 characters, got %d", len(c.Cache.HashSalt))
+	check(c.Encryption.Key != "" || len(c.Encryption.OldKeys) == 0 && len(c.Encryption.KMS) == 0, "encryption.old_keys and encryption.kms require encryption.key")
+	if c.Encryption.Key != "" && len(c.Encryption.KMS) == 0 {
+		for _, k := range append([]string{c.Encryption.Key}, c.Encryption.OldKeys...) {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+			check(err == nil && len(key) == 32, "encryption: a key is not the base64 of 32 bytes")
+		}
+	}
+	check(c.HTTP.MaxBatch > 0, "http.max_batch must be positive, got %d", c.HTTP.MaxBatch)
+	check(!c.Watch.Enabled || c.HTTP.Addr != "", "watch.enabled requires http.addr")
+	check(c.Watch.History >= 0, "watch.history must not be negative, got %d", c.Watch.History)
+	check(c.Watch.Buffer > 0, "watch.buffer must be positive, got %d", c.Watch.Buffer)
+	if c.Replica.Primary != "" {
+		u, err := url.Parse(c.Replica.Primary)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "replica.primary %q is not an http or https URL", c.Replica.Primary)
+		check(len(c.Tenants) == 0, "replica.primary and tenants are exclusive, tenants are not replicated")
+		check(c.WAL.Dir == "", "replica.primary and wal.dir are exclusive, a replica restarts from the primary")
+	}
+	check(c.Replica.Interval > 0, "replica.interval must be positive, got %v", c.Replica.Interval)
+	check(len(c.Tenants) == 0 || c.HTTP.Addr != "", "tenants requires http.addr")
+	tenants, quotas := make(map[string]bool), 0
+	for _, t := range c.Tenants {
+		quotas += cmp.Or(t.Size, c.Cache.Size)
+		check(t.Name != "", "tenants: a tenant has no name")
+		check(!tenants[t.Name], "tenants: %q is listed twice", t.Name)
+		tenants[t.Name] = true
+		check(t.Size >= 0, "tenants: size of %q must not be negative, got %d", t.Name, t.Size)
+		check(t.Rounding == "" || slices.Contains(RoundingModes, t.Rounding), "tenants: rounding %q of %q is not one of %s", t.Rounding, t.Name, strings.Join(RoundingModes, ", "))
+		check(t.Precision >= 0 && t.Precision <= 6, "tenants: precision of %q must be between 0 and 6, got %d", t.Name, t.Precision)
+	}
+	check(c.Cache.Tenants >= 0, "cache.tenants must not be negative, got %d", c.Cache.Tenants)
+	check(c.Cache.Tenants == 0 || c.Cache.Tenants >= quotas, "cache.tenants %d is less than the sizes of the tenants, %d", c.Cache.Tenants, quotas)
+	check(c.Shutdown.Delay >= 0, "shutdown.delay must not be negative, got %v", c.Shutdown.Delay)
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout must be positive, got %v", c.Shutdown.Timeout)
+	check(slices.Contains(LogLevels, c.Log.Level), "log.level %q is not one of %s", c.Log.Level, strings.Join(LogLevels, ", "))
+	check(c.Log.Access.Sample >= 0 && c.Log.Access.Sample <= 1, "log.access.sample must be between 0 and 1, got %v", c.Log.Access.Sample)
+	check(c.Log.Access.Slow >= 0, "log.access.slow must not be negative, got %v", c.Log.Access.Slow)
+	check(slices.Contains(TracingExporters, c.Tracing.Exporter), "tracing.exporter %q is not one of %s", c.Tracing.Exporter, strings.Join(TracingExporters, ", "))
+	return errors.Join(errs...)
+}
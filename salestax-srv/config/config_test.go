@@ -0,0 +1,230 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "salestax.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t, `
+cache:
+  size: 100000
+  ttl: 24h
+  policy: slru
+  memory:
+    enabled: true
+loader:
+  backend: http
+  url: http://rates.internal/v1/rate
+  retry:
+    attempts: 3
+http:
+  cors:
+    origins: [https://shop.example.com]
+`)
+	t.Setenv("SALESTAX_CACHE_SIZE", "200000")
+	t.Setenv("SALESTAX_LOADER_RETRY_JITTER", "0.5")
+	t.Setenv("SALESTAX_REDIS_ADDR", "localhost:6379")
+	t.Setenv("SALESTAX_HTTP_CORS_ORIGINS", "https://a.example.com, ,https://b.example.com")
+	t.Setenv("SALESTAX_WATCH_ENABLED", "true")
+	t.Setenv("SALESTAX_SHUTDOWN_DELAY", "5s")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want any
+	}{
+		{"cache.size from the environment", cfg.Cache.Size, 200000},
+		{"cache.ttl from the file", cfg.Cache.TTL, 24 * time.Hour},
+		{"cache.policy from the file", cfg.Cache.Policy, "slru"},
+		{"cache.shards by default", cfg.Cache.Shards, 1},
+		{"cache.memory.high by default under a set section", cfg.Cache.Memory.High, 0.9},
+		{"loader.url from the file", cfg.Loader.URL, "http://rates.internal/v1/rate"},
+		{"loader.retry.attempts from the file", cfg.Loader.Retry.Attempts, 3},
+		{"loader.retry.jitter from the environment", cfg.Loader.Retry.Jitter, 0.5},
+		{"loader.retry.backoff by default", cfg.Loader.Retry.Backoff, 50 * time.Millisecond},
+		{"redis.addr from the environment", cfg.Redis.Addr, "localhost:6379"},
+		{"redis.prefix by default", cfg.Redis.Prefix, "salestax:"},
+		{"watch.enabled from the environment", cfg.Watch.Enabled, true},
+		{"shutdown.delay from the environment", cfg.Shutdown.Delay, 5 * time.Second},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if want := []string{"https://a.example.com", "https://b.example.com"}; !slices.Equal(cfg.HTTP.CORS.Origins, want) {
+		t.Errorf("http.cors.origins = %q, want the environment list %q", cfg.HTTP.CORS.Origins, want)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+
+	if cfg, err := Load(""); err != nil || cfg.Cache.Size != 200000 || cfg.Cache.Policy != "lru" {
+		t.Errorf("Load without a file = %+v, %v, want the defaults and the environment", cfg.Cache, err)
+	}
+	if _, err := Load(writeFile(t, "")); err != nil {
+		t.Errorf("Load of an empty file = %v", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Load of a missing file = %v, want it not found", err)
+	}
+	if _, err := Load(writeFile(t, "cache:\n  sise: 10\n")); err == nil || !strings.Contains(err.Error(), "sise") {
+		t.Errorf("Load of an unknown setting = %v, want it reported", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	for _, tt := range []struct {
+		name, value string
+		check       func(Config) bool
+		err         bool
+	}{
+		{"SALESTAX_CACHE_SHARDS", "16", func(c Config) bool { return c.Cache.Shards == 16 }, false},
+		{"SALESTAX_CACHE_HOT_KEYS_WINDOW", "1m", func(c Config) bool { return c.Cache.HotWindow == time.Minute }, false},
+		{"SALESTAX_CACHE_MEMORY_LOW", "0.5", func(c Config) bool { return c.Cache.Memory.Low == 0.5 }, false},
+		{"SALESTAX_LOADER_AVALARA_LICENSE_KEY", "k", func(c Config) bool { return c.Loader.Avalara.LicenseKey == "k" }, false},
+		{"SALESTAX_MEMCACHED_SERVERS", "a:11211,b:11211", func(c Config) bool { return len(c.Memcached.Servers) == 2 }, false},
+		{"SALESTAX_AUTH_ANONYMOUS_RATE", "10", func(c Config) bool { return c.Auth.Anonymous.Rate == 10 }, false},
+		{"SALESTAX_DEBUG", "1", func(c Config) bool { return c.Debug }, false},
+		{"SALESTAX_GRPC_ADDR", "", func(c Config) bool { return c.GRPC.Addr == "" }, false},
+		{"SALESTAX_CACHE_SIZE", "many", nil, true},
+		{"SALESTAX_CACHE_TTL", "10", nil, true},
+		{"SALESTAX_LOADER_RATE", "fast", nil, true},
+		{"SALESTAX_DEBUG", "sure", nil, true},
+		{"SALESTAX_TENANTS", "a,b", nil, true},
+	} {
+		cfg := Default()
+		err := cfg.ApplyEnv(func(name string) (string, bool) {
+			if name == tt.name {
+				return tt.value, true
+			}
+			return "", false
+		})
+		switch {
+		case tt.err && (err == nil || !strings.HasPrefix(err.Error(), tt.name+": ")):
+			t.Errorf("%s=%s: ApplyEnv = %v, want an error naming the variable", tt.name, tt.value, err)
+		case !tt.err && err != nil:
+			t.Errorf("%s=%s: ApplyEnv = %v", tt.name, tt.value, err)
+		case !tt.err && !tt.check(cfg):
+			t.Errorf("%s=%s was not applied", tt.name, tt.value)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("the default configuration is invalid: %v", err)
+	}
+	for _, tt := range []struct {
+		name   string
+		modify func(*Config)
+		want   string // in the error, empty for valid
+	}{
+		{"no cache", func(c *Config) { c.Cache.Size = 0 }, "cache.size must be positive"},
+		{"unknown policy", func(c *Config) { c.Cache.Policy = "mru" }, `cache.policy "mru"`},
+		{"memory low above high", func(c *Config) {
+			c.Cache.Memory = Memory{Enabled: true, High: 0.7, Low: 0.9}
+		}, "cache.memory.low 0.9 and high 0.7"},
+		{"memory above the limit", func(c *Config) {
+			c.Cache.Memory = Memory{Enabled: true, High: 1.5, Low: 0.7}
+		}, "cache.memory.low 0.7 and high 1.5"},
+		{"memory disabled", func(c *Config) { c.Cache.Memory = Memory{High: 1.5} }, ""},
+		{"http backend without url", func(c *Config) { c.Loader.Backend = "http" }, "loader.url is required"},
+		{"two second tiers", func(c *Config) {
+			c.Redis.Addr = "localhost:6379"
+			c.Memcached.Servers = []string{"localhost:11211"}
+		}, "pick one second tier"},
+		{"nothing to serve", func(c *Config) { c.HTTP.Addr, c.GRPC.Addr = "", "" }, "nothing to serve"},
+		{"resp only", func(c *Config) { c.HTTP.Addr, c.GRPC.Addr, c.RESP.Addr = "", "", ":6379" }, ""},
+
+		{"auth clients with memcache and resp", func(c *Config) {
+			c.Auth.Clients = "clients.json"
+			c.Memcache.Addr, c.RESP.Addr = ":11211", ":6379"
+		}, ""},
+		{"anonymous with resp", func(c *Config) {
+			c.Auth.Anonymous.Rate = 10
+			c.RESP.Addr = ":6379"
+		}, "auth.anonymous does not cover memcache.addr and resp.addr"},
+		{"anonymous and clients with resp", func(c *Config) {
+			c.Auth.Clients = "clients.json"
+			c.Auth.Anonymous.Rate = 10
+			c.RESP.Addr = ":6379"
+		}, ""},
+		{"auth without memcache and resp", func(c *Config) { c.Auth.Clients = "clients.json" }, ""},
+		{"memcache and resp without auth", func(c *Config) { c.Memcache.Addr, c.RESP.Addr = ":11211", ":6379" }, ""},
+		{"admin token with auth", func(c *Config) {
+			c.Admin.Token = "s3cret"
+			c.Auth.Clients = "clients.json"
+		}, "admin.token and auth are exclusive"},
+		{"auth clients with peers", func(c *Config) {
+			c.Auth.Clients = "clients.json"
+			c.Peers = Peers{Self: "http://a:8080", Nodes: []string{"http://a:8080", "http://b:8080"}}
+		}, "peers.key is required"},
+		{"anonymous with peers", func(c *Config) {
+			c.Auth.Anonymous.Rate = 10
+			c.Peers = Peers{Self: "http://a:8080", Nodes: []string{"http://a:8080"}, Key: "k"}
+		}, "auth.anonymous with peers requires auth.clients"},
+
+		{"replica", func(c *Config) { c.Replica.Primary = "http://primary:8080" }, ""},
+		{"replica with memcache and resp", func(c *Config) {
+			c.Replica.Primary = "http://primary:8080"
+			c.Memcache.Addr, c.RESP.Addr = ":11211", ":6379"
+		}, ""},
+		{"replica not a URL", func(c *Config) { c.Replica.Primary = "primary:8080" }, `replica.primary "primary:8080" is not an http or https URL`},
+		{"replica with tenants", func(c *Config) {
+			c.Replica.Primary = "http://primary:8080"
+			c.Tenants = []Tenant{{Name: "a"}}
+		}, "replica.primary and tenants are exclusive"},
+		{"replica with wal", func(c *Config) {
+			c.Replica.Primary = "http://primary:8080"
+			c.WAL.Dir = "/var/lib/salestax/wal"
+		}, "replica.primary and wal.dir are exclusive"},
+
+		{"cors origin with a path", func(c *Config) { c.HTTP.CORS.Origins = []string{"https://shop.example.com/cart"} }, "is not *, nor a scheme and host"},
+		{"cors origins", func(c *Config) { c.HTTP.CORS.Origins = []string{"*", "https://*.example.com", "http://localhost:3000"} }, ""},
+		{"tls key without cert", func(c *Config) { c.TLS.Key = "key.pem" }, "tls.cert and tls.key must be given together"},
+		{"short hash salt", func(c *Config) { c.Cache.HashSalt = "salt" }, "cache.hash_salt must be at least 16 characters"},
+		{"encryption key not base64", func(c *Config) { c.Encryption.Key = "not a key" }, "not the base64 of 32 bytes"},
+		{"encryption key of kms", func(c *Config) {
+			c.Encryption.Key = "wrapped"
+			c.Encryption.KMS = []string{"unwrap"}
+		}, ""},
+		{"tenant listed twice", func(c *Config) { c.Tenants = []Tenant{{Name: "a"}, {Name: "a"}} }, `tenants: "a" is listed twice`},
+		{"tenants over the pool", func(c *Config) {
+			c.Tenants = []Tenant{{Name: "a", Size: 10}, {Name: "b", Size: 20}}
+			c.Cache.Tenants = 25
+		}, "cache.tenants 25 is less than the sizes of the tenants, 30"},
+	} {
+		cfg := Default()
+		tt.modify(&cfg)
+		err := cfg.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: Validate = %v, want valid", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: Validate = %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+
+	// every invalid setting is reported
+	cfg := Default()
+	cfg.Cache.Size, cfg.Log.Level, cfg.WAL.Sync = -1, "trace", 0
+	if err := cfg.Validate(); err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("Validate of three invalid settings = %v, want the three", err)
+	}
+}
@@ -0,0 +1,246 @@
+// Package grpcserver exposes a salestax.RateCache as the gRPC RateService
+// defined in ratepb/rate.proto. The service deals in combined rates only.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/jared-d-smith/psl/salestax-srv/grpcserver/ratepb"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// DefaultAddr is the listen address used when none is configured.
+const DefaultAddr = ":9090"
+
+// DefaultMaxBatch is the number of addresses BulkGetRates accepts unless
+// changed with SetMaxBatch.
+const DefaultMaxBatch = 100
+
+// Server serves the RateService from a cache. A nil loader makes the server
+// cache-only: misses are reported as codes.NotFound instead of being loaded.
+type Server struct {
+	addr string
+	srv  *grpc.Server
+	svc  *service
+}
+
+// New returns a Server listening on addr (DefaultAddr if empty). The RPC
+// context is passed to loader. opts are passed through to grpc.NewServer.
+func New(addr string, cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx, opts ...grpc.ServerOption) *Server {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	s := &Server{
+		addr: addr,
+		srv:  grpc.NewServer(opts...),
+	}
+	s.svc = NewService(cache, loader).(*service)
+	ratepb.RegisterRateServiceServer(s.srv, s.svc)
+	return s
+}
+
+// Addr returns the configured listen address.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// EnableCoalescing makes the server look rates up through coalescer, which
+// shares them with the concurrent RPCs for the same address, including the
+// requests of other servers using it. coalescer must look up the cache and
+// the loader of the server. It must be called before the server starts
+// serving.
+func (s *Server) EnableCoalescing(coalescer *salestax.Coalescer) {
+	s.svc.coalescer = coalescer
+}
+
+// EnableWatch makes SetRate publish the rates it writes to the watchers of
+// hub. It must be called before the server starts serving.
+func (s *Server) EnableWatch(hub *watch.Hub) {
+	s.svc.watch = hub
+}
+
+// EnableCluster makes SetRate broadcast its writes through cluster, so that
+// the other instances evict their copies. The cluster must invalidate the
+// cache of the server. It must be called before the server starts serving.
+func (s *Server) EnableCluster(cluster *invalidation.Cluster) {
+	s.svc.cluster = cluster
+}
+
+// SetMaxBatch sets the number of addresses BulkGetRates accepts, by default
+// DefaultMaxBatch. It must be called before the server starts serving.
+func (s *Server) SetMaxBatch(n int) {
+	s.svc.maxBatch = n
+}
+
+// SetReadOnly makes SetRate fail with codes.PermissionDenied, for
+// read-only replicas. It must be called before the server starts serving.
+func (s *Server) SetReadOnly() {
+	s.svc.readOnly = true
+}
+
+// ListenAndServe serves requests until Shutdown is called, in which case
+// it returns nil.
+func (s *Server) ListenAndServe() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve serves requests on lis until Shutdown is called.
+func (s *Server) Serve(lis net.Listener) error {
+	err := s.srv.Serve(lis)
+	if err == grpc.ErrServerStopped {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting new RPCs and waits for in-flight RPCs to finish.
+// If ctx expires first the remaining RPCs are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.srv.Stop()
+		return ctx.Err()
+	}
+}
+
+// service implements ratepb.RateServiceServer.
+type service struct {
+	ratepb.UnimplementedRateServiceServer
+	cache     *salestax.RateCache
+	loader    salestax.RateLoaderFuncCtx
+	coalescer *salestax.Coalescer   // nil unless EnableCoalescing was called
+	watch     *watch.Hub            // nil unless EnableWatch was called
+	cluster   *invalidation.Cluster // nil unless EnableCluster was called
+	maxBatch  int
+	readOnly  bool
+}
+
+// NewService returns the RateService implementation backed by cache, for
+// registering on an existing grpc.Server.
+func NewService(cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx) ratepb.RateServiceServer {
+	return &service{
+		cache:    cache,
+		loader:   loader,
+		maxBatch: DefaultMaxBatch,
+	}
+}
+
+func (s *service) lookup(ctx context.Context, address string) (float64, error) {
+	if s.coalescer != nil {
+		rate, err := s.coalescer.Lookup(ctx, address)
+		if err != nil {
+			return 0, lookupError(ctx, err)
+		}
+		return rate.Total(), nil
+	}
+	if s.loader == nil {
+		item, err := s.cache.Get(address)
+		if err != nil {
+			return 0, status.Error(codes.NotFound, err.Error())
+		}
+		return item.Value().Total(), nil
+	}
+	rate, err := s.cache.Rate(ctx, address, s.loader)
+	if err != nil {
+		return 0, lookupError(ctx, err)
+	}
+	return rate, nil
+}
+
+// lookupError returns the gRPC status error reporting a failed lookup.
+func lookupError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return status.Error(lookupCode(err), err.Error())
+}
+
+// lookupCode returns the gRPC status code reporting a failed lookup.
+func lookupCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, salestax.ErrNotFound), errors.Is(err, salestax.ErrExpired):
+		return codes.NotFound
+	case errors.Is(err, salestax.ErrThrottled):
+		return codes.ResourceExhausted
+	}
+	return codes.Unavailable
+}
+
+func (s *service) GetRate(ctx context.Context, req *ratepb.GetRateRequest) (*ratepb.GetRateResponse, error) {
+	rate, err := s.lookup(ctx, req.GetAddress())
+	if err != nil {
+		return nil, err
+	}
+	return &ratepb.GetRateResponse{Address: req.GetAddress(), Rate: rate}, nil
+}
+
+func (s *service) SetRate(ctx context.Context, req *ratepb.SetRateRequest) (*ratepb.SetRateResponse, error) {
+	if s.readOnly {
+		return nil, status.Error(codes.PermissionDenied, "read-only replica, write to the primary")
+	}
+	rate := salestax.Flat(req.GetRate())
+	if err := s.cache.Insert(req.GetAddress(), rate); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if s.watch != nil {
+		s.watch.Publish(watch.Event{Kind: watch.Update, Address: req.GetAddress(), Rate: &rate})
+	}
+	if s.cluster != nil {
+		if _, err := s.cluster.Invalidate(ctx, invalidation.Event{Keys: []string{req.GetAddress()}}); err != nil {
+			return nil, status.Error(codes.Unavailable, fmt.Sprintf("broadcasting the invalidation of %s: %v", req.GetAddress(), err))
+		}
+	}
+	return &ratepb.SetRateResponse{}, nil
+}
+
+func (s *service) BulkGetRates(ctx context.Context, req *ratepb.BulkGetRatesRequest) (*ratepb.BulkGetRatesResponse, error) {
+	if n := len(req.GetAddresses()); n > s.maxBatch {
+		return nil, status.Errorf(codes.InvalidArgument, "%d addresses, at most %d are allowed", n, s.maxBatch)
+	}
+	resp := &ratepb.BulkGetRatesResponse{
+		Results: make([]*ratepb.RateResult, 0, len(req.GetAddresses())),
+	}
+	for _, address := range req.GetAddresses() {
+		result := &ratepb.RateResult{Address: address}
+		if rate, err := s.lookup(ctx, address); err != nil {
+			result.Error = status.Convert(err).Message()
+		} else {
+			result.Rate = rate
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+func (s *service) Stats(ctx context.Context, req *ratepb.StatsRequest) (*ratepb.StatsResponse, error) {
+	st := s.cache.Stats()
+	return &ratepb.StatsResponse{
+		Entries:      int64(st.Size),
+		Hits:         st.Hits,
+		Misses:       st.Misses,
+		Evictions:    st.Evictions,
+		Expirations:  st.Expirations,
+		LoaderCalls:  st.LoaderCalls,
+		LoaderErrors: st.LoaderErrors,
+	}, nil
+}
@@ -0,0 +1,239 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jared-d-smith/psl/salestax-srv/grpcserver/ratepb"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// loader loads 0.05 for "loaded", fails with ErrNotFound for "nowhere" and
+// with another error for the other addresses.
+func loader(_ context.Context, address string) (salestax.TaxRate, error) {
+	switch address {
+	case "loaded":
+		return salestax.Flat(0.05), nil
+	case "nowhere":
+		return salestax.TaxRate{}, fmt.Errorf("%s: %w", address, salestax.ErrNotFound)
+	}
+	return salestax.TaxRate{}, errors.New("backend down")
+}
+
+// newTestClient serves s over an in-memory connection until the test ends,
+// once the setup functions have been called on it, and returns a client of
+// it.
+func newTestClient(t *testing.T, s *Server, setup ...func(*Server)) ratepb.RateServiceClient {
+	t.Helper()
+	for _, f := range setup {
+		f(s)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Shutdown(context.Background())
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return ratepb.NewRateServiceClient(conn)
+}
+
+func TestGetRate(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("cached", salestax.Flat(0.0725))
+	c := newTestClient(t, New("", cache, loader))
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		address string
+		rate    float64
+		code    codes.Code
+	}{
+		{"cached", 0.0725, codes.OK},
+		{"loaded", 0.05, codes.OK},
+		{"nowhere", 0, codes.NotFound},
+		{"elsewhere", 0, codes.Unavailable},
+	} {
+		resp, err := c.GetRate(ctx, &ratepb.GetRateRequest{Address: tt.address})
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("GetRate(%q) = %v, want %v", tt.address, err, tt.code)
+			continue
+		}
+		if err == nil && (resp.GetAddress() != tt.address || resp.GetRate() != tt.rate) {
+			t.Errorf("GetRate(%q) = %s %v, want %v", tt.address, resp.GetAddress(), resp.GetRate(), tt.rate)
+		}
+	}
+	if !cache.Contains("loaded") {
+		t.Error("the loaded rate was not cached")
+	}
+}
+
+func TestCacheOnly(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("cached", salestax.Flat(0.0725))
+	c := newTestClient(t, New("", cache, nil))
+	ctx := context.Background()
+
+	if resp, err := c.GetRate(ctx, &ratepb.GetRateRequest{Address: "cached"}); err != nil || resp.GetRate() != 0.0725 {
+		t.Errorf("GetRate(cached) = %v, %v, want 0.0725", resp.GetRate(), err)
+	}
+	if _, err := c.GetRate(ctx, &ratepb.GetRateRequest{Address: "loaded"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetRate(loaded) = %v, want NotFound", err)
+	}
+}
+
+// bus records the keys of the events published.
+type bus struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (b *bus) Publish(_ context.Context, msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var e invalidation.Event
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, e.Keys...)
+	return nil
+}
+
+func (b *bus) Subscribe(ctx context.Context, _ func([]byte)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestSetRate(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	c := newTestClient(t, New("", cache, nil))
+	ctx := context.Background()
+
+	if _, err := c.SetRate(ctx, &ratepb.SetRateRequest{Address: "a", Rate: 0.06}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.GetRate(ctx, &ratepb.GetRateRequest{Address: "a"}); err != nil || resp.GetRate() != 0.06 {
+		t.Errorf("GetRate(a) = %v, %v, want 0.06", resp.GetRate(), err)
+	}
+
+	b := &bus{}
+	c = newTestClient(t, New("", salestax.NewRateCache(100), nil), func(s *Server) {
+		s.EnableCluster(invalidation.New(b))
+	})
+	if _, err := c.SetRate(ctx, &ratepb.SetRateRequest{Address: "a", Rate: 0.06}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a"}; !slices.Equal(b.keys, want) {
+		t.Errorf("broadcast %q, want %q", b.keys, want)
+	}
+
+	cache = salestax.NewRateCache(100)
+	c = newTestClient(t, New("", cache, nil), (*Server).SetReadOnly)
+	if _, err := c.SetRate(ctx, &ratepb.SetRateRequest{Address: "a", Rate: 0.06}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("SetRate on a read-only server = %v, want PermissionDenied", err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("read-only server cached %v", cache.Keys())
+	}
+}
+
+func TestBulkGetRates(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("cached", salestax.Flat(0.0725))
+	c := newTestClient(t, New("", cache, loader))
+
+	resp, err := c.BulkGetRates(context.Background(), &ratepb.BulkGetRatesRequest{Addresses: []string{"cached", "nowhere", "loaded", "elsewhere"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		address string
+		rate    float64
+		failed  bool
+	}{
+		{"cached", 0.0725, false},
+		{"nowhere", 0, true},
+		{"loaded", 0.05, false},
+		{"elsewhere", 0, true},
+	}
+	if len(resp.GetResults()) != len(want) {
+		t.Fatalf("got %d results, want %d", len(resp.GetResults()), len(want))
+	}
+	for i, r := range resp.GetResults() {
+		if r.GetAddress() != want[i].address || r.GetRate() != want[i].rate || (r.GetError() != "") != want[i].failed {
+			t.Errorf("result %d = %s %v %q, want %s %v failed %v", i, r.GetAddress(), r.GetRate(), r.GetError(), want[i].address, want[i].rate, want[i].failed)
+		}
+	}
+}
+
+func TestBulkGetRatesMaxBatch(t *testing.T) {
+	s := New("", salestax.NewRateCache(100), loader)
+	s.SetMaxBatch(2)
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	_, err := c.BulkGetRates(ctx, &ratepb.BulkGetRatesRequest{Addresses: []string{"loaded", "loaded", "elsewhere"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BulkGetRates of 3 addresses = %v, want InvalidArgument", err)
+	}
+	if resp, err := c.BulkGetRates(ctx, &ratepb.BulkGetRatesRequest{Addresses: []string{"loaded", "elsewhere"}}); err != nil || len(resp.GetResults()) != 2 {
+		t.Errorf("BulkGetRates of 2 addresses = %v, %v, want 2 results", resp, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("cached", salestax.Flat(0.0725))
+	c := newTestClient(t, New("", cache, loader))
+	ctx := context.Background()
+
+	for _, address := range []string{"cached", "loaded", "elsewhere"} {
+		c.GetRate(ctx, &ratepb.GetRateRequest{Address: address})
+	}
+	st, err := c.Stats(ctx, &ratepb.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.GetEntries() != 2 || st.GetHits() != 1 || st.GetMisses() != 2 || st.GetLoaderCalls() != 2 || st.GetLoaderErrors() != 1 {
+		t.Errorf("Stats = %v, want 2 entries, 1 hit, 2 misses, 2 loader calls, 1 error", st)
+	}
+}
+
+func TestLookupCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want codes.Code
+	}{
+		{salestax.ErrNotFound, codes.NotFound},
+		{fmt.Errorf("loading a: %w", salestax.ErrNotFound), codes.NotFound},
+		{salestax.ErrExpired, codes.NotFound},
+		{fmt.Errorf("loading a: %w", salestax.ErrThrottled), codes.ResourceExhausted},
+		{errors.New("backend down"), codes.Unavailable},
+	} {
+		if got := lookupCode(tt.err); got != tt.want {
+			t.Errorf("lookupCode(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package ratepb contains the generated protobuf and gRPC code for the
+// RateService defined in rate.proto.
+package ratepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rate.proto
@@ -0,0 +1,587 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.28.3
+// source: rate.proto
+
+package ratepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetRateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRateRequest) Reset() {
+	*x = GetRateRequest{}
+	mi := &file_rate_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRateRequest) ProtoMessage() {}
+
+func (x *GetRateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRateRequest.ProtoReflect.Descriptor instead.
+func (*GetRateRequest) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetRateRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type GetRateResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Rate          float64                `protobuf:"fixed64,2,opt,name=rate,proto3" json:"rate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRateResponse) Reset() {
+	*x = GetRateResponse{}
+	mi := &file_rate_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRateResponse) ProtoMessage() {}
+
+func (x *GetRateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRateResponse.ProtoReflect.Descriptor instead.
+func (*GetRateResponse) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetRateResponse) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *GetRateResponse) GetRate() float64 {
+	if x != nil {
+		return x.Rate
+	}
+	return 0
+}
+
+type SetRateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Rate          float64                `protobuf:"fixed64,2,opt,name=rate,proto3" json:"rate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetRateRequest) Reset() {
+	*x = SetRateRequest{}
+	mi := &file_rate_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetRateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetRateRequest) ProtoMessage() {}
+
+func (x *SetRateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetRateRequest.ProtoReflect.Descriptor instead.
+func (*SetRateRequest) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SetRateRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *SetRateRequest) GetRate() float64 {
+	if x != nil {
+		return x.Rate
+	}
+	return 0
+}
+
+type SetRateResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetRateResponse) Reset() {
+	*x = SetRateResponse{}
+	mi := &file_rate_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetRateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetRateResponse) ProtoMessage() {}
+
+func (x *SetRateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetRateResponse.ProtoReflect.Descriptor instead.
+func (*SetRateResponse) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{3}
+}
+
+type BulkGetRatesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Addresses     []string               `protobuf:"bytes,1,rep,name=addresses,proto3" json:"addresses,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BulkGetRatesRequest) Reset() {
+	*x = BulkGetRatesRequest{}
+	mi := &file_rate_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BulkGetRatesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkGetRatesRequest) ProtoMessage() {}
+
+func (x *BulkGetRatesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkGetRatesRequest.ProtoReflect.Descriptor instead.
+func (*BulkGetRatesRequest) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BulkGetRatesRequest) GetAddresses() []string {
+	if x != nil {
+		return x.Addresses
+	}
+	return nil
+}
+
+type RateResult struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Address string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Rate    float64                `protobuf:"fixed64,2,opt,name=rate,proto3" json:"rate,omitempty"`
+	// error is set instead of rate when the lookup failed.
+	Error         string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RateResult) Reset() {
+	*x = RateResult{}
+	mi := &file_rate_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RateResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RateResult) ProtoMessage() {}
+
+func (x *RateResult) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RateResult.ProtoReflect.Descriptor instead.
+func (*RateResult) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RateResult) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *RateResult) GetRate() float64 {
+	if x != nil {
+		return x.Rate
+	}
+	return 0
+}
+
+func (x *RateResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type BulkGetRatesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*RateResult          `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BulkGetRatesResponse) Reset() {
+	*x = BulkGetRatesResponse{}
+	mi := &file_rate_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BulkGetRatesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkGetRatesResponse) ProtoMessage() {}
+
+func (x *BulkGetRatesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkGetRatesResponse.ProtoReflect.Descriptor instead.
+func (*BulkGetRatesResponse) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BulkGetRatesResponse) GetResults() []*RateResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_rate_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{7}
+}
+
+type StatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entries       int64                  `protobuf:"varint,1,opt,name=entries,proto3" json:"entries,omitempty"`
+	Hits          uint64                 `protobuf:"varint,2,opt,name=hits,proto3" json:"hits,omitempty"`
+	Misses        uint64                 `protobuf:"varint,3,opt,name=misses,proto3" json:"misses,omitempty"`
+	Evictions     uint64                 `protobuf:"varint,4,opt,name=evictions,proto3" json:"evictions,omitempty"`
+	Expirations   uint64                 `protobuf:"varint,5,opt,name=expirations,proto3" json:"expirations,omitempty"`
+	LoaderCalls   uint64                 `protobuf:"varint,6,opt,name=loader_calls,json=loaderCalls,proto3" json:"loader_calls,omitempty"`
+	LoaderErrors  uint64                 `protobuf:"varint,7,opt,name=loader_errors,json=loaderErrors,proto3" json:"loader_errors,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	mi := &file_rate_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_rate_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *StatsResponse) GetEntries() int64 {
+	if x != nil {
+		return x.Entries
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetHits() uint64 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetMisses() uint64 {
+	if x != nil {
+		return x.Misses
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetEvictions() uint64 {
+	if x != nil {
+		return x.Evictions
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetExpirations() uint64 {
+	if x != nil {
+		return x.Expirations
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetLoaderCalls() uint64 {
+	if x != nil {
+		return x.LoaderCalls
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetLoaderErrors() uint64 {
+	if x != nil {
+		return x.LoaderErrors
+	}
+	return 0
+}
+
+var File_rate_proto protoreflect.FileDescriptor
+
+const file_rate_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"rate.proto\x12\vsalestax.v1\"*\n" +
+	"\x0eGetRateRequest\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\"?\n" +
+	"\x0fGetRateResponse\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x12\n" +
+	"\x04rate\x18\x02 \x01(\x01R\x04rate\">\n" +
+	"\x0eSetRateRequest\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x12\n" +
+	"\x04rate\x18\x02 \x01(\x01R\x04rate\"\x11\n" +
+	"\x0fSetRateResponse\"3\n" +
+	"\x13BulkGetRatesRequest\x12\x1c\n" +
+	"\taddresses\x18\x01 \x03(\tR\taddresses\"P\n" +
+	"\n" +
+	"RateResult\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x12\n" +
+	"\x04rate\x18\x02 \x01(\x01R\x04rate\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"I\n" +
+	"\x14BulkGetRatesResponse\x121\n" +
+	"\aresults\x18\x01 \x03(\v2\x17.salestax.v1.RateResultR\aresults\"\x0e\n" +
+	"\fStatsRequest\"\xdd\x01\n" +
+	"\rStatsResponse\x12\x18\n" +
+	"\aentries\x18\x01 \x01(\x03R\aentries\x12\x12\n" +
+	"\x04hits\x18\x02 \x01(\x04R\x04hits\x12\x16\n" +
+	"\x06misses\x18\x03 \x01(\x04R\x06misses\x12\x1c\n" +
+	"\tevictions\x18\x04 \x01(\x04R\tevictions\x12 \n" +
+	"\vexpirations\x18\x05 \x01(\x04R\vexpirations\x12!\n" +
+	"\floader_calls\x18\x06 \x01(\x04R\vloaderCalls\x12#\n" +
+	"\rloader_errors\x18\a \x01(\x04R\floaderErrors2\xae\x02\n" +
+	"\vRateService\x12D\n" +
+	"\aGetRate\x12\x1b.salestax.v1.GetRateRequest\x1a\x1c.salestax.v1.GetRateResponse\x12D\n" +
+	"\aSetRate\x12\x1b.salestax.v1.SetRateRequest\x1a\x1c.salestax.v1.SetRateResponse\x12S\n" +
+	"\fBulkGetRates\x12 .salestax.v1.BulkGetRatesRequest\x1a!.salestax.v1.BulkGetRatesResponse\x12>\n" +
+	"\x05Stats\x12\x19.salestax.v1.StatsRequest\x1a\x1a.salestax.v1.StatsResponseB=Z;github.com/jared-d-smith/psl/salestax-srv/grpcserver/ratepbb\x06proto3"
+
+var (
+	file_rate_proto_rawDescOnce sync.Once
+	file_rate_proto_rawDescData []byte
+)
+
+func file_rate_proto_rawDescGZIP() []byte {
+	file_rate_proto_rawDescOnce.Do(func() {
+		file_rate_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_rate_proto_rawDesc), len(file_rate_proto_rawDesc)))
+	})
+	return file_rate_proto_rawDescData
+}
+
+var file_rate_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_rate_proto_goTypes = []any{
+	(*GetRateRequest)(nil),       // 0: salestax.v1.GetRateRequest
+	(*GetRateResponse)(nil),      // 1: salestax.v1.GetRateResponse
+	(*SetRateRequest)(nil),       // 2: salestax.v1.SetRateRequest
+	(*SetRateResponse)(nil),      // 3: salestax.v1.SetRateResponse
+	(*BulkGetRatesRequest)(nil),  // 4: salestax.v1.BulkGetRatesRequest
+	(*RateResult)(nil),           // 5: salestax.v1.RateResult
+	(*BulkGetRatesResponse)(nil), // 6: salestax.v1.BulkGetRatesResponse
+	(*StatsRequest)(nil),         // 7: salestax.v1.StatsRequest
+	(*StatsResponse)(nil),        // 8: salestax.v1.StatsResponse
+}
+var file_rate_proto_depIdxs = []int32{
+	5, // 0: salestax.v1.BulkGetRatesResponse.results:type_name -> salestax.v1.RateResult
+	0, // 1: salestax.v1.RateService.GetRate:input_type -> salestax.v1.GetRateRequest
+	2, // 2: salestax.v1.RateService.SetRate:input_type -> salestax.v1.SetRateRequest
+	4, // 3: salestax.v1.RateService.BulkGetRates:input_type -> salestax.v1.BulkGetRatesRequest
+	7, // 4: salestax.v1.RateService.Stats:input_type -> salestax.v1.StatsRequest
+	1, // 5: salestax.v1.RateService.GetRate:output_type -> salestax.v1.GetRateResponse
+	3, // 6: salestax.v1.RateService.SetRate:output_type -> salestax.v1.SetRateResponse
+	6, // 7: salestax.v1.RateService.BulkGetRates:output_type -> salestax.v1.BulkGetRatesResponse
+	8, // 8: salestax.v1.RateService.Stats:output_type -> salestax.v1.StatsResponse
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_rate_proto_init() }
+func file_rate_proto_init() {
+	if File_rate_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_rate_proto_rawDesc), len(file_rate_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rate_proto_goTypes,
+		DependencyIndexes: file_rate_proto_depIdxs,
+		MessageInfos:      file_rate_proto_msgTypes,
+	}.Build()
+	File_rate_proto = out.File
+	file_rate_proto_goTypes = nil
+	file_rate_proto_depIdxs = nil
+}
@@ -0,0 +1,252 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Admin configures the admin API mounted by EnableAdmin.
+type Admin struct {
+	Token    string                                    // bearer token required of every admin request, see EnableAdmin
+	Policy   string                                    // name of the eviction policy the cache was built with
+	Policies map[string]lrucache.PolicyFactory[string] // the policies PATCH /admin/cache may switch to
+	Snapshot func(context.Context) error               // saves a snapshot of the cache, nil if there is none
+}
+
+// AdminCacheRequest is the body of PATCH /admin/cache. Settings left out
+// are not changed. Durations are in the syntax of time.ParseDuration; a
+// zero NegativeTTL disables negative caching.
+type AdminCacheRequest struct {
+	Size        *int    `json:"size,omitempty"`
+	TTL         *string `json:"ttl,omitempty"`
+	NegativeTTL *string `json:"negative_ttl,omitempty"`
+	Policy      *string `json:"policy,omitempty"`
+}
+
+// AdminCacheResponse is the body returned by GET and PATCH /admin/cache.
+type AdminCacheResponse struct {
+	Size        int    `json:"size"`
+	TTL         string `json:"ttl"`
+	NegativeTTL string `json:"negative_ttl"`
+	Policy      string `json:"policy"`
+}
+
+// AdminEvictRequest is the body of POST /admin/evict.
+type AdminEvictRequest struct {
+	N int `json:"n"`
+}
+
+// AdminEvictResponse is the body returned by POST /admin/evict.
+type AdminEvictResponse struct {
+	Evicted int `json:"evicted"`
+	Entries int `json:"entries"` // left in the cache
+}
+
+// admin is the state of the admin API.
+type admin struct {
+	Admin
+	mu sync.Mutex // serializes changes, guards Policy
+}
+
+// EnableAdmin mounts the admin API, which changes the settings of the cache
+// at runtime:
+//
+//	GET    /admin/cache     the current size, TTLs and eviction policy
+//	PATCH  /admin/cache     change them, body {"size": 200000, "ttl": "12h",
+//	                        "negative_ttl": "0s", "policy": "lfu"}
+//	POST   /admin/evict     evict the n items the eviction policy would
+//	                        evict next, body {"n": 10000}, e.g. from a
+//	                        controller shedding memory under pressure
+//	POST   /admin/snapshot  save a snapshot of the cache now
+//	GET    /admin/snapshot  download a snapshot of the cache, in the format
+//	                        of RateCache.SaveSnapshot, e.g. for replicas
+//
+// Admin requests must carry config.Token in an "Authorization: Bearer"
+// header. An empty Token leaves authorizing them to middleware, such as
+// that of auth.Authenticator; only leave it empty behind such middleware. It
+// must be called before the server starts serving.
+func (s *Server) EnableAdmin(config Admin) {
+	a := &admin{Admin: config}
+	s.handle("GET /admin/cache", a.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		writeJSON(w, http.StatusOK, s.adminCache(a))
+	})).ServeHTTP, operation{
+		id:       "getAdminCache",
+		summary:  "The size, TTLs and eviction policy of the cache",
+		response: AdminCacheResponse{},
+		errors:   []int{http.StatusUnauthorized},
+	})
+	s.handle("PATCH /admin/cache", a.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handlePatchAdminCache(w, r, a)
+	})).ServeHTTP, operation{
+		id:       "patchAdminCache",
+		summary:  "Change the size, TTLs or eviction policy of the cache",
+		body:     AdminCacheRequest{},
+		response: AdminCacheResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusUnauthorized},
+	})
+	s.handle("POST /admin/evict", a.authorize(http.HandlerFunc(s.handleAdminEvict)).ServeHTTP, operation{
+		id:       "evictOldest",
+		summary:  "Evict the next victims of the eviction policy",
+		body:     AdminEvictRequest{},
+		response: AdminEvictResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusUnauthorized},
+	})
+	s.handle("POST /admin/snapshot", a.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleAdminSnapshot(w, r, a)
+	})).ServeHTTP, operation{
+		id:      "saveSnapshot",
+		summary: "Save a snapshot of the cache",
+		errors:  []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError},
+	})
+	s.handle("GET /admin/snapshot", a.authorize(http.HandlerFunc(s.handleGetAdminSnapshot)).ServeHTTP, operation{
+		id:          "getSnapshot",
+		summary:     "Download a snapshot of the cache",
+		response:    []byte{},
+		contentType: "application/octet-stream",
+		errors:      []int{http.StatusUnauthorized},
+	})
+}
+
+// authorize lets only requests bearing the admin token through to next.
+func (a *admin) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) adminCache(a *admin) AdminCacheResponse {
+	return AdminCacheResponse{
+		Size:        s.cache.Cap(),
+		TTL:         s.cache.TTL().String(),
+		NegativeTTL: s.cache.NegativeTTL().String(),
+		Policy:      a.Policy,
+	}
+}
+
+func (s *Server) handlePatchAdminCache(w http.ResponseWriter, r *http.Request, a *admin) {
+	var req AdminCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// validate everything before changing anything
+	if req.Size != nil && *req.Size <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("size must be positive, got %d", *req.Size))
+		return
+	}
+	ttl, err := parseDuration("ttl", req.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	negTTL, err := parseDuration("negative_ttl", req.NegativeTTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var factory lrucache.PolicyFactory[string]
+	if req.Policy != nil {
+		var ok bool
+		if factory, ok = a.Policies[*req.Policy]; !ok {
+			names := make([]string, 0, len(a.Policies))
+			for name := range a.Policies {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown policy %q, want one of %s", *req.Policy, strings.Join(names, ", ")))
+			return
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req.Size != nil {
+		s.cache.Resize(*req.Size)
+	}
+	if ttl != nil {
+		s.cache.SetTTL(*ttl)
+	}
+	if negTTL != nil {
+		s.cache.SetNegativeTTL(*negTTL)
+	}
+	if factory != nil {
+		s.cache.SetPolicy(factory)
+		a.Policy = *req.Policy
+	}
+	writeJSON(w, http.StatusOK, s.adminCache(a))
+}
+
+// parseDuration parses the duration of the setting name, if given.
+func parseDuration(name string, s *string) (*time.Duration, error) {
+	if s == nil {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(*s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("%s must not be negative, got %s", name, d)
+	}
+	return &d, nil
+}
+
+func (s *Server) handleAdminEvict(w http.ResponseWriter, r *http.Request) {
+	var req AdminEvictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.N <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("n must be positive, got %d", req.N))
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminEvictResponse{
+		Evicted: s.cache.RemoveOldest(req.N),
+		Entries: s.cache.Len(),
+	})
+}
+
+func (s *Server) handleAdminSnapshot(w http.ResponseWriter, r *http.Request, a *admin) {
+	if a.Snapshot == nil {
+		writeError(w, http.StatusNotFound, errors.New("no snapshot file configured"))
+		return
+	}
+	if err := a.Snapshot(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetAdminSnapshot streams the snapshot as it is taken. Once it has
+// started, a failure can only be reported by cutting it short, which fails
+// the decoding of the client.
+func (s *Server) handleGetAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := s.cache.SaveSnapshot(w); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
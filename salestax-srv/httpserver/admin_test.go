@@ -0,0 +1,175 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+const adminToken = "s3cret"
+
+// doAdmin is do with the bearer token.
+func doAdmin(t *testing.T, ts *httptest.Server, token, method, path, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding the %d response: %v", method, path, resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	s := New("", salestax.NewRateCache(10), nil)
+	s.EnableAdmin(Admin{Token: adminToken})
+	ts := serve(t, s)
+	for _, token := range []string{"", "wrong", adminToken + "x"} {
+		var e ErrorResponse
+		if code := doAdmin(t, ts, token, "GET", "/admin/cache", "", &e); code != http.StatusUnauthorized {
+			t.Errorf("GET /admin/cache with token %q = %d, want 401", token, code)
+		}
+	}
+	if code := doAdmin(t, ts, adminToken, "GET", "/admin/cache", "", nil); code != http.StatusOK {
+		t.Errorf("GET /admin/cache with the token = %d, want 200", code)
+	}
+}
+
+func TestAdminCache(t *testing.T) {
+	cache := salestax.NewRateCache(10, lrucache.WithTTL(time.Hour))
+	s := New("", cache, nil)
+	s.EnableAdmin(Admin{Token: adminToken, Policy: "lru", Policies: map[string]lrucache.PolicyFactory[string]{
+		"lru": lrucache.NewLRUPolicy[string],
+		"lfu": lrucache.NewLFUPolicy[string],
+	}})
+	ts := serve(t, s)
+
+	var got AdminCacheResponse
+	if code := doAdmin(t, ts, adminToken, "GET", "/admin/cache", "", &got); code != http.StatusOK ||
+		got != (AdminCacheResponse{Size: 10, TTL: "1h0m0s", NegativeTTL: "0s", Policy: "lru"}) {
+		t.Errorf("GET /admin/cache = %d %+v", code, got)
+	}
+	body := `{"size": 20, "ttl": "12h", "negative_ttl": "1m", "policy": "lfu"}`
+	if code := doAdmin(t, ts, adminToken, "PATCH", "/admin/cache", body, &got); code != http.StatusOK ||
+		got != (AdminCacheResponse{Size: 20, TTL: "12h0m0s", NegativeTTL: "1m0s", Policy: "lfu"}) {
+		t.Errorf("PATCH /admin/cache = %d %+v", code, got)
+	}
+	if cache.Cap() != 20 || cache.TTL() != 12*time.Hour {
+		t.Errorf("after PATCH the cache holds %d with a TTL of %v", cache.Cap(), cache.TTL())
+	}
+
+	for _, body := range []string{
+		`{"size": 0}`,
+		`{"ttl": "forever"}`,
+		`{"negative_ttl": "-1s"}`,
+		`{"policy": "mru"}`,
+		`{"size": 30, "policy": "mru"}`,
+		`{`,
+	} {
+		var e ErrorResponse
+		if code := doAdmin(t, ts, adminToken, "PATCH", "/admin/cache", body, &e); code != http.StatusBadRequest {
+			t.Errorf("PATCH /admin/cache %s = %d, want 400", body, code)
+		}
+	}
+	if cache.Cap() != 20 {
+		t.Errorf("a rejected PATCH resized the cache to %d", cache.Cap())
+	}
+}
+
+func TestAdminSnapshot(t *testing.T) {
+	cache := salestax.NewRateCache(10)
+	cache.Insert("a", salestax.Flat(0.05))
+	saved := 0
+	s := New("", cache, nil)
+	s.EnableAdmin(Admin{Token: adminToken, Snapshot: func(context.Context) error {
+		saved++
+		if saved > 1 {
+			return errors.New("disk full")
+		}
+		return nil
+	}})
+	ts := serve(t, s)
+
+	if code := doAdmin(t, ts, adminToken, "POST", "/admin/snapshot", "", nil); code != http.StatusNoContent || saved != 1 {
+		t.Errorf("POST /admin/snapshot = %d with %d saves, want 204 and 1", code, saved)
+	}
+	var e ErrorResponse
+	if code := doAdmin(t, ts, adminToken, "POST", "/admin/snapshot", "", &e); code != http.StatusInternalServerError || e.Error != "disk full" {
+		t.Errorf("POST /admin/snapshot failing = %d %+v, want 500", code, e)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/admin/snapshot", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	copied := salestax.NewRateCache(10)
+	if err := copied.LoadSnapshot(resp.Body); err != nil {
+		t.Fatalf("loading the snapshot of GET /admin/snapshot: %v", err)
+	}
+	if item, err := copied.Get("a"); err != nil || item.Value().Total() != 0.05 {
+		t.Errorf("snapshot copy holds %v, %v, want the rate of a", item, err)
+	}
+}
+
+func TestAdminNoSnapshot(t *testing.T) {
+	s := New("", salestax.NewRateCache(10), nil)
+	s.EnableAdmin(Admin{Token: adminToken})
+	ts := serve(t, s)
+	var e ErrorResponse
+	if code := doAdmin(t, ts, adminToken, "POST", "/admin/snapshot", "", &e); code != http.StatusNotFound {
+		t.Errorf("POST /admin/snapshot without a snapshot file = %d, want 404", code)
+	}
+}
+
+func TestAdminEvict(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	s := New("", cache, nil)
+	s.EnableAdmin(Admin{Token: adminToken})
+	ts := serve(t, s)
+	for _, address := range []string{"a", "b", "c", "d", "e"} {
+		cache.Insert(address, salestax.Flat(0.05))
+	}
+	cache.Get("a")
+
+	var got AdminEvictResponse
+	if code := doAdmin(t, ts, adminToken, "POST", "/admin/evict", `{"n": 3}`, &got); code != http.StatusOK || got != (AdminEvictResponse{Evicted: 3, Entries: 2}) {
+		t.Errorf("POST /admin/evict 3 = %d %+v, want 3 evicted and 2 left", code, got)
+	}
+	if !cache.Contains("a") || !cache.Contains("e") {
+		t.Errorf("evicted %v, want the least recently used b, c and d", cache.Keys())
+	}
+	if code := doAdmin(t, ts, adminToken, "POST", "/admin/evict", `{"n": 10}`, &got); code != http.StatusOK || got != (AdminEvictResponse{Evicted: 2, Entries: 0}) {
+		t.Errorf("POST /admin/evict more than cached = %d %+v, want the 2 left evicted", code, got)
+	}
+	for _, body := range []string{`{"n": 0}`, `{"n": -1}`, `{}`, `[`} {
+		var e ErrorResponse
+		if code := doAdmin(t, ts, adminToken, "POST", "/admin/evict", body, &e); code != http.StatusBadRequest {
+			t.Errorf("POST /admin/evict %s = %d, want 400", body, code)
+		}
+	}
+	if code := doAdmin(t, ts, "", "POST", "/admin/evict", `{"n": 1}`, nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /admin/evict without the token = %d, want 401", code)
+	}
+}
@@ -0,0 +1,110 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxBatch is the number of addresses POST /rates:batchGet accepts
+// unless changed with SetMaxBatch.
+const DefaultMaxBatch = 100
+
+// batchConcurrency bounds the lookups of a batch running at once, so that a
+// batch of misses does not take every connection to the loader backend.
+const batchConcurrency = 16
+
+// BatchGetRatesRequest is the body accepted by POST /rates:batchGet.
+type BatchGetRatesRequest struct {
+	Requests []BatchRateQuery `json:"requests"`
+}
+
+// BatchRateQuery is the lookup of one address in a BatchGetRatesRequest,
+// as GET /rate/{address}?category= would do it.
+type BatchRateQuery struct {
+	Address  string `json:"address"`
+	Category string `json:"category,omitempty"`
+}
+
+// BatchGetRatesResponse is the body returned by POST /rates:batchGet: one
+// result per query, in the order of the request.
+type BatchGetRatesResponse struct {
+	Results []BatchRateResult `json:"results"`
+}
+
+// BatchRateResult is the outcome of a BatchRateQuery: either Rate or Error
+// is set.
+type BatchRateResult struct {
+	Address  string        `json:"address"`
+	Category string        `json:"category,omitempty"`
+	Rate     *RateResponse `json:"rate,omitempty"`
+	Error    *BatchError   `json:"error,omitempty"`
+}
+
+// BatchError is a failed lookup in a BatchRateResult. Status is the HTTP
+// status GET /rate/{address} would have replied, e.g. 404 for an unknown
+// address.
+type BatchError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// SetMaxBatch sets the number of addresses POST /rates:batchGet accepts, by
+// default DefaultMaxBatch. It must be called before the server starts
+// serving.
+func (s *Server) SetMaxBatch(n int) {
+	s.maxBatch = n
+}
+
+// handleBatchGetRates looks up every address of the batch concurrently. The
+// request only fails as a whole if it is malformed; lookups that fail are
+// reported in their result, so the reply is 200 even if all of them do.
+func (s *Server) handleBatchGetRates(w http.ResponseWriter, r *http.Request) {
+	var req BatchGetRatesRequest
+	if r.Method == http.MethodGet {
+		// ?address=...&address=...&category=..., cacheable unlike POST
+		q := r.URL.Query()
+		for _, address := range q["address"] {
+			req.Requests = append(req.Requests, BatchRateQuery{Address: address, Category: q.Get("category")})
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch n := len(req.Requests); {
+	case n == 0:
+		writeError(w, http.StatusBadRequest, errors.New("no requests"))
+		return
+	case n > s.maxBatch:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%d requests, at most %d are allowed", n, s.maxBatch))
+		return
+	}
+
+	resp := BatchGetRatesResponse{Results: make([]BatchRateResult, len(req.Requests))}
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, q := range req.Requests {
+		res := &resp.Results[i]
+		res.Address, res.Category = q.Address, q.Category
+		if q.Address == "" {
+			res.Error = &BatchError{Status: http.StatusBadRequest, Message: "no address"}
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			rate, err := s.rateFor(r.Context(), q.Address, q.Category)
+			if err != nil {
+				res.Error = &BatchError{Status: lookupStatus(err), Message: err.Error()}
+				return
+			}
+			rr := rateResponse(q.Address, rate)
+			res.Rate = &rr
+		}()
+	}
+	wg.Wait()
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,64 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func TestBatchGetRates(t *testing.T) {
+	errBackend := errors.New("backend down")
+	s := New("", salestax.NewRateCache(100), func(ctx context.Context, key string) (salestax.TaxRate, error) {
+		switch key {
+		case "nowhere":
+			return salestax.TaxRate{}, salestax.ErrNotFound
+		case "broken":
+			return salestax.TaxRate{}, errBackend
+		}
+		return salestax.Flat(0.05), nil
+	})
+	s.SetMaxBatch(4)
+	ts := serve(t, s)
+
+	var got BatchGetRatesResponse
+	body := `{"requests": [{"address": "a"}, {"address": "nowhere"}, {"address": ""}, {"address": "broken"}]}`
+	if code := do(t, ts, "POST", "/rates:batchGet", body, &got); code != http.StatusOK || len(got.Results) != 4 {
+		t.Fatalf("POST /rates:batchGet = %d %+v, want 200 with 4 results", code, got)
+	}
+	if r := got.Results[0]; r.Address != "a" || r.Rate == nil || r.Rate.Rate != 0.05 || r.Error != nil {
+		t.Errorf("result of a = %+v, want its rate", r)
+	}
+	for i, want := range map[int]int{1: http.StatusNotFound, 2: http.StatusBadRequest, 3: http.StatusBadGateway} {
+		if r := got.Results[i]; r.Rate != nil || r.Error == nil || r.Error.Status != want {
+			t.Errorf("result %d = %+v, want an error of status %d", i, r, want)
+		}
+	}
+
+	got = BatchGetRatesResponse{}
+	if code := do(t, ts, "GET", "/rates:batchGet?address=a&address=b&category=food", "", &got); code != http.StatusOK || len(got.Results) != 2 {
+		t.Fatalf("GET /rates:batchGet = %d %+v, want 200 with 2 results", code, got)
+	}
+	for _, r := range got.Results {
+		if r.Category != "food" || r.Rate == nil {
+			t.Errorf("GET result %+v, want a rate of category food", r)
+		}
+	}
+
+	for _, body := range []string{
+		`{"requests": []}`,
+		`{"requests": [{"address": "a"}, {"address": "b"}, {"address": "c"}, {"address": "d"}, {"address": "e"}]}`,
+		`{"requests": `,
+	} {
+		var e ErrorResponse
+		if code := do(t, ts, "POST", "/rates:batchGet", body, &e); code != http.StatusBadRequest {
+			t.Errorf("POST /rates:batchGet %s = %d, want 400", body, code)
+		}
+	}
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/rates:batchGet", "", &e); code != http.StatusBadRequest {
+		t.Errorf("GET /rates:batchGet without addresses = %d, want 400", code)
+	}
+}
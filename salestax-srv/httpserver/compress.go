@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the size below which JSON responses are sent uncompressed,
+// since gzip saves little on them.
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// conditional buffers the JSON responses of next to give them an ETag, a
+// weak one since the gzipped and the identity encodings share it, and
+// replies 304 to GET and HEAD requests whose If-None-Match holds it, e.g.
+// those of clients polling the rates of the same addresses. Responses the
+// client accepts gzipped and of at least gzipMinSize are compressed. Other
+// responses, such as the event stream of GET /watch, the profiles of
+// /debug/pprof/ and errors, are passed through untouched.
+func conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferedWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		if !bw.buffered {
+			return
+		}
+		body := bw.buf.Bytes()
+		sum := sha256.Sum256(body)
+		etag := `W/"` + hex.EncodeToString(sum[:12]) + `"`
+		h := w.Header()
+		h.Set("ETag", etag)
+		h.Add("Vary", "Accept-Encoding")
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatch(r.Header.Get("If-None-Match"), etag) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if len(body) < gzipMinSize || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			w.WriteHeader(http.StatusOK)
+			w.Write(body)
+			return
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusOK)
+		gz := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(gz)
+		gz.Reset(w)
+		gz.Write(body)
+		gz.Close()
+	})
+}
+
+// bufferedWriter holds back a 200 JSON response until the handler returns,
+// and passes any other response through.
+type bufferedWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	wroteHeader bool
+	buffered    bool
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	ct, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if code == http.StatusOK && ct == "application/json" && w.Header().Get("Content-Encoding") == "" {
+		w.buffered = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffered {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to flush
+// the event stream of GET /watch.
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// etagMatch reports whether the If-None-Match header value header holds
+// etag, by weak comparison.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the Accept-Encoding header value header
+// admits gzip.
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0.001", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.000", false},
+		{"br, gzip;q=0.0", false},
+		{"br", false},
+		{"x-gzip", false},
+	} {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	const etag = `W/"abc"`
+	for _, tt := range []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`"xyz", W/"abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`"abcd"`, false},
+		{`W/"ab"`, false},
+	} {
+		if got := etagMatch(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatch(%q, %s) = %v, want %v", tt.header, etag, got, tt.want)
+		}
+	}
+}
+
+// jsonHandler replies body as JSON with status, setting its Content-Length.
+func jsonHandler(status int, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	})
+}
+
+func TestConditional(t *testing.T) {
+	small := `{"rate": 0.05}`
+	large := `{"addresses": "` + strings.Repeat("1 Main St, ", gzipMinSize/10) + `"}`
+
+	reply := func(h http.Handler, method string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/rate/a", nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		conditional(h).ServeHTTP(rec, r)
+		return rec
+	}
+
+	t.Run("small", func(t *testing.T) {
+		rec := reply(jsonHandler(http.StatusOK, small), "GET", "Accept-Encoding", "gzip")
+		if rec.Code != http.StatusOK || rec.Body.String() != small || rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("small response = %d %q encoded %q, want it uncompressed", rec.Code, rec.Body, rec.Header().Get("Content-Encoding"))
+		}
+		if etag := rec.Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+			t.Errorf("ETag = %q, want a weak one", etag)
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		rec := reply(jsonHandler(http.StatusOK, large), "GET", "Accept-Encoding", "gzip")
+		if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" {
+			t.Fatalf("large response encoded %q with length %q, want gzip without the length",
+				rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Length"))
+		}
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil || string(body) != large {
+			t.Errorf("gunzipped body = %.40q, %v, want the response", body, err)
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+		}
+	})
+
+	t.Run("refused gzip", func(t *testing.T) {
+		rec := reply(jsonHandler(http.StatusOK, large), "GET", "Accept-Encoding", "gzip;q=0")
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+			t.Errorf("response to gzip;q=0 encoded %q, want it uncompressed", rec.Header().Get("Content-Encoding"))
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		etag := reply(jsonHandler(http.StatusOK, small), "GET").Header().Get("ETag")
+		for _, inm := range []string{etag, strings.TrimPrefix(etag, "W/"), "*"} {
+			rec := reply(jsonHandler(http.StatusOK, small), "GET", "If-None-Match", inm)
+			if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+				t.Errorf("If-None-Match %s = %d with %d bytes, want 304 and no body", inm, rec.Code, rec.Body.Len())
+			}
+			if rec.Header().Get("Content-Type") != "" || rec.Header().Get("Content-Length") != "" {
+				t.Errorf("304 with Content-Type %q and Content-Length %q, want neither",
+					rec.Header().Get("Content-Type"), rec.Header().Get("Content-Length"))
+			}
+			if rec.Header().Get("ETag") != etag {
+				t.Errorf("304 with ETag %q, want %q", rec.Header().Get("ETag"), etag)
+			}
+		}
+		if rec := reply(jsonHandler(http.StatusOK, small), "HEAD", "If-None-Match", etag); rec.Code != http.StatusNotModified {
+			t.Errorf("HEAD with a matching If-None-Match = %d, want 304", rec.Code)
+		}
+		if rec := reply(jsonHandler(http.StatusOK, small), "PUT", "If-None-Match", etag); rec.Code != http.StatusOK {
+			t.Errorf("PUT with a matching If-None-Match = %d, want 200", rec.Code)
+		}
+		if rec := reply(jsonHandler(http.StatusOK, `{"rate": 0.06}`), "GET", "If-None-Match", etag); rec.Code != http.StatusOK {
+			t.Errorf("changed response with the old ETag = %d, want 200", rec.Code)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, status := range []int{http.StatusNotFound, http.StatusBadGateway, http.StatusCreated} {
+			rec := reply(jsonHandler(status, large), "GET", "Accept-Encoding", "gzip")
+			if rec.Code != status || rec.Header().Get("ETag") != "" || rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+				t.Errorf("%d response = %d with ETag %q, encoded %q, want it passed through", status, rec.Code,
+					rec.Header().Get("ETag"), rec.Header().Get("Content-Encoding"))
+			}
+		}
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		var unbuffered bool
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "id: 1\n\n")
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("Flush: %v", err)
+			}
+			unbuffered = rec.Body.String() == "id: 1\n\n" && rec.Flushed
+		})
+		conditional(h).ServeHTTP(rec, httptest.NewRequest("GET", "/watch", nil))
+		if !unbuffered || rec.Header().Get("ETag") != "" {
+			t.Errorf("event stream buffered (%v) or given an ETag %q, want it passed through", !unbuffered, rec.Header().Get("ETag"))
+		}
+	})
+
+	t.Run("pprof", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		conditional(http.HandlerFunc(pprof.Index)).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("pprof index = %d %s with ETag %q, want it passed through",
+				rec.Code, rec.Header().Get("Content-Type"), rec.Header().Get("ETag"))
+		}
+	})
+}
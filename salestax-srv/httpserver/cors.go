@@ -0,0 +1,98 @@
+package httpserver
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS configures the Cross-Origin Resource Sharing of the server, which
+// lets the pages of the origins given call it from the browser, e.g. to
+// show the estimated tax of a cart. Only GET, HEAD and POST are allowed, and
+// no cookies: browsers authenticate with an API key header, if at all.
+type CORS struct {
+	// Origins allowed, such as "https://shop.example.com". "*" allows any,
+	// "https://*.example.com" the subdomains of example.com.
+	Origins []string
+	// MaxAge is how long browsers may cache the reply to a preflight.
+	MaxAge time.Duration
+}
+
+// corsHeaders are the request headers a cross-origin request may set.
+var corsHeaders = []string{"authorization", "content-type", "if-none-match", "last-event-id", "x-api-key"}
+
+// Middleware adds the CORS headers to the responses of next to the
+// requests of the allowed origins, and replies to their preflight requests
+// itself, since those carry no credentials. It must run before
+// authentication, i.e. be passed to Server.Use after the authentication
+// middleware.
+func (c CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !c.allows(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// without the headers the browser hides the response
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", "ETag")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		switch r.Header.Get("Access-Control-Request-Method") {
+		case http.MethodGet, http.MethodHead, http.MethodPost:
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var headers []string
+		for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			header = strings.ToLower(strings.TrimSpace(header))
+			if slices.Contains(corsHeaders, header) {
+				headers = append(headers, header)
+			}
+		}
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+		if headers != nil {
+			h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allows reports whether origin is one of c.Origins.
+func (c CORS) allows(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || o == origin {
+			return true
+		}
+		// https://*.example.com matches https://shop.example.com
+		if scheme, domain, ok := strings.Cut(o, "://*."); ok {
+			host, ok := strings.CutPrefix(origin, scheme+"://")
+			if ok && strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSAllows(t *testing.T) {
+	c := CORS{Origins: []string{"https://shop.example.com", "https://*.example.org", "http://localhost:3000"}}
+	for _, tt := range []struct {
+		origin string
+		want   bool
+	}{
+		{"https://shop.example.com", true},
+		{"http://shop.example.com", false},
+		{"https://shop.example.com:8443", false},
+		{"https://cart.shop.example.com", false},
+		{"https://shop.example.com.attacker.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://a.example.org", false},
+		{"https://a.example.org:8443", false},
+		{"https://evil.example.org.attacker.com", false},
+		{"https://evilexample.org", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"http://localhost", false},
+		{"null", false},
+	} {
+		if got := c.allows(tt.origin); got != tt.want {
+			t.Errorf("allows(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	if !(CORS{Origins: []string{"*"}}).allows("https://anything.test") {
+		t.Error(`"*" does not allow any origin`)
+	}
+	if (CORS{}).allows("https://shop.example.com") {
+		t.Error("no origins allow an origin")
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	c := CORS{Origins: []string{"https://shop.example.com"}, MaxAge: 10 * time.Minute}
+	var served int
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(method, origin string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/rate/a", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	t.Run("same origin", func(t *testing.T) {
+		served = 0
+		rec := send("GET", "")
+		if served != 1 || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "" {
+			t.Errorf("request without Origin: served %d, headers %v, want it untouched", served, rec.Header())
+		}
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		served = 0
+		rec := send("GET", "https://shop.example.com")
+		if served != 1 || rec.Header().Get("Access-Control-Allow-Origin") != "https://shop.example.com" ||
+			rec.Header().Get("Access-Control-Expose-Headers") != "ETag" || rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("allowed request: served %d, headers %v", served, rec.Header())
+		}
+	})
+
+	t.Run("disallowed", func(t *testing.T) {
+		for _, origin := range []string{"https://shop.example.com.attacker.com", "http://shop.example.com", "https://shop.example.com:8443"} {
+			served = 0
+			rec := send("GET", origin)
+			if served != 1 || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("request of %s: served %d, Allow-Origin %q, want it served without the CORS headers",
+					origin, served, rec.Header().Get("Access-Control-Allow-Origin"))
+			}
+		}
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		served = 0
+		rec := send("OPTIONS", "https://shop.example.com",
+			"Access-Control-Request-Method", "POST",
+			"Access-Control-Request-Headers", "Content-Type, X-API-Key, X-Forwarded-For, Cookie")
+		if rec.Code != http.StatusNoContent || served != 0 {
+			t.Fatalf("preflight = %d, served %d, want 204 without calling the handler", rec.Code, served)
+		}
+		for header, want := range map[string]string{
+			"Access-Control-Allow-Origin":  "https://shop.example.com",
+			"Access-Control-Allow-Methods": "GET, HEAD, POST",
+			"Access-Control-Allow-Headers": "content-type, x-api-key",
+			"Access-Control-Max-Age":       "600",
+		} {
+			if got := rec.Header().Get(header); got != want {
+				t.Errorf("preflight %s = %q, want %q", header, got, want)
+			}
+		}
+		if vary := rec.Header().Values("Vary"); len(vary) != 3 {
+			t.Errorf("preflight Vary %q, want Origin and the requested method and headers", vary)
+		}
+
+		rec = send("OPTIONS", "https://shop.example.com", "Access-Control-Request-Method", "GET", "Access-Control-Request-Headers", "Cookie")
+		if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Headers") != "" {
+			t.Errorf("preflight of headers none allowed = %d with %q, want 204 allowing none",
+				rec.Code, rec.Header().Get("Access-Control-Allow-Headers"))
+		}
+	})
+
+	t.Run("preflight refused", func(t *testing.T) {
+		served = 0
+		for _, tt := range []struct{ origin, method string }{
+			{"https://shop.example.com.attacker.com", "GET"},
+			{"http://shop.example.com", "GET"},
+			{"https://shop.example.com", "PUT"},
+			{"https://shop.example.com", "DELETE"},
+		} {
+			rec := send("OPTIONS", tt.origin, "Access-Control-Request-Method", tt.method)
+			if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+				t.Errorf("preflight of %s %s = %d, want 403", tt.method, tt.origin, rec.Code)
+			}
+		}
+		if served != 0 {
+			t.Errorf("refused preflights reached the handler %d times", served)
+		}
+	})
+
+	t.Run("plain OPTIONS", func(t *testing.T) {
+		served = 0
+		if send("OPTIONS", "https://shop.example.com"); served != 1 {
+			t.Error("OPTIONS without Access-Control-Request-Method was not passed to the handler")
+		}
+	})
+}
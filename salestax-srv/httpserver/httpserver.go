@@ -0,0 +1,1013 @@
+// Package httpserver exposes a salestax.RateCache over HTTP.
+//
+// Endpoints:
+//
+//	GET    /rate/{address}  look up the rate and its breakdown, calling the
+//	                        loader on a miss; ?category= selects the rate of
+//	                        a category of goods, see EnableTaxability
+//	PUT    /rate/{address}  store a rate, body {"rate": 0.0725} or a breakdown
+//	                        {"components": [{"level": "state", "rate": 0.06}, ...]}
+//	DELETE /rate/{address}  remove a rate
+//	POST   /rates:batchGet  look up to SetMaxBatch addresses in one round trip,
+//	                        body {"requests": [{"address": "...", "category": "..."}, ...]};
+//	                        each result holds the rate or the error of its
+//	                        address, see BatchGetRatesResponse
+//	GET    /rates:batchGet?address=...&address=...&category=...
+//	                        the same, for clients polling a set of addresses
+//	GET    /tax/{address}?amount=19.99&category=reduced
+//	                        the tax on an amount, itemized by jurisdiction
+//	                        and rounded as configured with SetRounding; the
+//	                        category is optional, and ?date= is the date of
+//	                        the sale for the holidays of EnableHolidays
+//	POST   /invalidate      remove the rates of a rate change, body
+//	                        {"prefix": "TX:"} or {"level": "state", "code": "48"}
+//	                        to remove those of addresses starting with prefix
+//	                        or with a component of the jurisdiction
+//	GET    /stats           cache statistics
+//	GET    /healthz         liveness, 200 while the process serves requests
+//	GET    /readyz          readiness, 200 once SetReady was called and every
+//	                        ready check passes, 503 otherwise
+//	GET    /openapi.json    the OpenAPI 3 document of the endpoints enabled,
+//	                        for generating clients
+//
+// JSON responses carry an ETag: GET requests whose If-None-Match holds it
+// are replied 304 Not Modified. Those of 1 KiB or more are gzipped for
+// clients accepting it.
+//
+// EnableHistory adds historical lookups:
+//
+//	GET    /rate/{address}?as_of=2024-03-01
+//	                        the rate in effect at a time, given as a date or
+//	                        in RFC 3339, loading the history on a miss
+//	GET    /rate/{address}/history
+//	                        all effective-dated records of the address
+//
+// and makes PUT add the rate to the history of the address as well.
+//
+// EnableExemptions adds the exemption certificates of customers, which
+// GET /tax applies given ?customer=:
+//
+//	GET    /exemptions/{customer}
+//	                        the certificates of a customer, loading them on a
+//	                        miss
+//	PUT    /exemptions/{customer}
+//	                        store the certificates of a customer, body
+//	                        [{"id": "R-1", "customer": "c1", "expires": "2027-12-31",
+//	                          "jurisdictions": [{"level": "state", "code": "TX"}]}]
+//	DELETE /exemptions/{customer}
+//	                        remove the cached certificates of a customer
+//
+// EnableCluster makes PUT, DELETE and POST /invalidate broadcast their
+// invalidations to the other instances of a cluster.
+//
+// SetReadOnly makes a replica of the server, whose PUT, DELETE and POST
+// /invalidate reply 403: its rates are those of its primary.
+//
+// EnableWatch adds GET /watch, a stream of Server-Sent Events of the rate
+// changes, for services keeping their own copy of the rates.
+//
+// EnableAdmin adds an authenticated admin API under /admin/ that resizes
+// the cache, changes its TTLs and eviction policy, sheds its cold tail and
+// saves snapshots.
+//
+// EnableDebug adds:
+//
+//	GET    /debug/cache     shard sizes, next victims and most hit addresses,
+//	                        up to ?n= (default 10) of each
+//	GET    /debug/cache/{address}
+//	                        when the rate of an address (?category=) was
+//	                        cached and last hit, its hits and TTL left
+//	       /debug/pprof/    the net/http/pprof profiles
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/taxability"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// DefaultAddr is the listen address used when none is configured.
+const DefaultAddr = ":8080"
+
+// Server serves rate lookups from a cache. A nil loader makes the server
+// cache-only: misses are reported as 404 instead of being loaded.
+type Server struct {
+	cache     *salestax.RateCache
+	loader    salestax.RateLoaderFuncCtx
+	coalescer *salestax.Coalescer // nil unless EnableCoalescing was called
+
+	history       *salestax.HistoryCache // nil unless EnableHistory was called
+	historyLoader salestax.HistoryLoaderFuncCtx
+
+	rounding   salestax.Rounding
+	taxability *taxability.Resolver // nil unless EnableTaxability was called
+	holidays   *taxability.Calendar // nil unless EnableHolidays was called
+
+	exemptions      *taxability.ExemptionCache // nil unless EnableExemptions was called
+	exemptionLoader taxability.ExemptionLoaderFuncCtx
+
+	cluster *invalidation.Cluster // nil unless EnableCluster was called
+
+	maxBatch int
+	readOnly bool
+
+	watch     *watch.Hub // nil unless EnableWatch was called
+	stopWatch chan struct{}
+
+	mux    *http.ServeMux
+	srv    *http.Server
+	ready  atomic.Bool
+	checks []readyCheck
+	routes []route // documented in the OpenAPI document
+}
+
+// readyCheck is a named dependency probed by GET /readyz.
+type readyCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+// RateResponse is the body returned by GET and PUT /rate/{address}. Rate is
+// the sum of the components.
+type RateResponse struct {
+	Address        string               `json:"address"`
+	Rate           float64              `json:"rate"`
+	Country        string               `json:"country,omitempty"`
+	Category       string               `json:"category,omitempty"`
+	Components     []salestax.Component `json:"components"`
+	EffectiveFrom  time.Time            `json:"effective_from,omitzero"`
+	EffectiveUntil time.Time            `json:"effective_until,omitzero"`
+}
+
+// RateRequest is the body accepted by PUT /rate/{address}: either the
+// combined Rate or its Components, in which case Rate may be left out.
+type RateRequest struct {
+	Rate           float64              `json:"rate"`
+	Country        string               `json:"country,omitempty"`
+	Category       string               `json:"category,omitempty"`
+	Components     []salestax.Component `json:"components"`
+	EffectiveFrom  time.Time            `json:"effective_from,omitzero"`
+	EffectiveUntil time.Time            `json:"effective_until,omitzero"`
+}
+
+// TaxResponse is the body returned by GET /tax/{address}.
+type TaxResponse struct {
+	Address string `json:"address"`
+	salestax.Calculation
+	Holidays     []string `json:"holidays,omitempty"`     // the tax holidays that exempted any line
+	Certificates []string `json:"certificates,omitempty"` // the exemption certificates that exempted any line
+}
+
+// InvalidateRequest is the body accepted by POST /invalidate: either Prefix
+// or Code, optionally with Level; see salestax.RateCache.InvalidateByPrefix
+// and InvalidateByJurisdiction.
+type InvalidateRequest struct {
+	Prefix string `json:"prefix,omitempty"`
+	Level  string `json:"level,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// InvalidateResponse is the body returned by POST /invalidate.
+type InvalidateResponse struct {
+	Invalidated int `json:"invalidated"` // rates and histories removed from this instance
+}
+
+// ExemptionsResponse is the body returned by GET and PUT
+// /exemptions/{customer}.
+type ExemptionsResponse struct {
+	Customer     string                   `json:"customer"`
+	Certificates []taxability.Certificate `json:"certificates"`
+}
+
+// HistoryResponse is the body returned by GET /rate/{address}/history.
+type HistoryResponse struct {
+	Address string         `json:"address"`
+	Records []RateResponse `json:"records"`
+}
+
+// StatsResponse is the body returned by GET /stats.
+type StatsResponse struct {
+	Entries      int           `json:"entries"`
+	Hits         uint64        `json:"hits"`
+	Misses       uint64        `json:"misses"`
+	HitRatio     float64       `json:"hit_ratio"`
+	Evictions    uint64        `json:"evictions"`
+	Expirations  uint64        `json:"expirations"`
+	LoaderCalls  uint64        `json:"loader_calls"`
+	LoaderErrors uint64        `json:"loader_errors"`
+	HotKeys      []DebugKey    `json:"hot_keys,omitempty"` // with lrucache.WithHotKeys, most requested first
+	Windows      []StatsWindow `json:"windows,omitempty"`  // with lrucache.WithStatsWindows, shortest first
+}
+
+// StatsWindow holds the counters of a StatsResponse over the last Window,
+// e.g. "5m0s".
+type StatsWindow struct {
+	Window       string  `json:"window"`
+	Hits         uint64  `json:"hits"`
+	Misses       uint64  `json:"misses"`
+	HitRatio     float64 `json:"hit_ratio"`
+	LoaderCalls  uint64  `json:"loader_calls"`
+	LoaderErrors uint64  `json:"loader_errors"`
+	LoadP50      float64 `json:"load_p50_seconds"`
+	LoadP99      float64 `json:"load_p99_seconds"`
+}
+
+// HealthResponse is the body returned by GET /healthz and by GET /readyz when
+// ready.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// DebugCacheResponse is the body returned by GET /debug/cache.
+type DebugCacheResponse struct {
+	Shards []DebugShard `json:"shards"`
+	Hot    []DebugKey   `json:"hot"` // hottest first
+}
+
+// DebugShard describes one cache shard in a DebugCacheResponse.
+type DebugShard struct {
+	Entries  int      `json:"entries"`
+	Weight   int      `json:"weight"`
+	Capacity int      `json:"capacity"`
+	Tail     []string `json:"tail"` // next evicted first
+}
+
+// DebugKey is an address with its number of requests: hits since it was
+// stored in a DebugCacheResponse, lookups over the tracking window in a
+// StatsResponse.
+type DebugKey struct {
+	Address string `json:"address"`
+	Hits    uint64 `json:"hits"`
+}
+
+// DebugEntryResponse is the body returned by GET /debug/cache/{address}.
+type DebugEntryResponse struct {
+	Key        string    `json:"key"`
+	Created    time.Time `json:"created"`
+	Accessed   time.Time `json:"accessed,omitzero"` // absent if never hit
+	Hits       uint32    `json:"hits"`
+	Expires    time.Time `json:"expires,omitzero"` // absent if it never expires
+	TTLSeconds float64   `json:"ttl_seconds"`      // left, 0 if it never expires
+	Version    uint64    `json:"version"`
+}
+
+// ErrorResponse is the body returned with any non 2xx status.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// New returns a Server listening on addr (DefaultAddr if empty). The request
+// context is passed to loader; use RateLoaderFunc.WithContext for loaders
+// that don't take one.
+func New(addr string, cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx) *Server {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	s := &Server{
+		cache:     cache,
+		loader:    loader,
+		rounding:  salestax.DefaultRounding,
+		maxBatch:  DefaultMaxBatch,
+		stopWatch: make(chan struct{}),
+		mux:       http.NewServeMux(),
+	}
+	s.handle("GET /rate/{address}", s.handleGetRate, operation{
+		id:      "getRate",
+		summary: "Look up the rate of an address",
+		query: []param{
+			{name: "category", description: "category of goods"},
+			{name: "as_of", description: "date or RFC 3339 time the rate was in effect, with historical lookups"},
+		},
+		response: RateResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable},
+	})
+	s.handle("PUT /rate/{address}", s.writing(s.handlePutRate), operation{
+		id:       "putRate",
+		summary:  "Store the rate of an address",
+		body:     RateRequest{},
+		response: RateResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusInternalServerError, http.StatusBadGateway},
+	})
+	s.handle("DELETE /rate/{address}", s.writing(s.handleDeleteRate), operation{
+		id:      "deleteRate",
+		summary: "Remove the rate of an address",
+		errors:  []int{http.StatusNotFound, http.StatusBadGateway},
+	})
+	s.handle("GET /rates:batchGet", s.handleBatchGetRates, operation{
+		id:      "batchGetRatesByQuery",
+		summary: "Look up the rates of several addresses",
+		query: []param{
+			{name: "address", description: "an address, repeated for each", required: true},
+			{name: "category", description: "category of goods of every address"},
+		},
+		response: BatchGetRatesResponse{},
+		errors:   []int{http.StatusBadRequest},
+	})
+	s.handle("POST /rates:batchGet", s.handleBatchGetRates, operation{
+		id:       "batchGetRates",
+		summary:  "Look up the rates of several addresses",
+		body:     BatchGetRatesRequest{},
+		response: BatchGetRatesResponse{},
+		errors:   []int{http.StatusBadRequest},
+	})
+	s.handle("GET /tax/{address}", s.handleGetTax, operation{
+		id:      "getTax",
+		summary: "Calculate the tax on an amount",
+		query: []param{
+			{name: "amount", typ: "number", required: true},
+			{name: "category", description: "category of goods"},
+			{name: "date", description: "date of the sale, for tax holidays"},
+			{name: "customer", description: "customer whose exemption certificates apply"},
+		},
+		response: TaxResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable},
+	})
+	s.handle("POST /invalidate", s.writing(s.handleInvalidate), operation{
+		id:       "invalidate",
+		summary:  "Remove the rates of a prefix or a jurisdiction",
+		body:     InvalidateRequest{},
+		response: InvalidateResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusBadGateway},
+	})
+	s.handle("GET /stats", s.handleStats, operation{
+		id:       "getStats",
+		summary:  "Cache statistics",
+		response: StatsResponse{},
+	})
+	s.handle("GET /healthz", s.handleHealthz, operation{
+		id:       "healthz",
+		summary:  "Liveness",
+		response: HealthResponse{},
+	})
+	s.handle("GET /readyz", s.handleReadyz, operation{
+		id:       "readyz",
+		summary:  "Readiness",
+		response: HealthResponse{},
+		errors:   []int{http.StatusServiceUnavailable},
+	})
+	s.mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           conditional(s.mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Addr returns the configured listen address.
+func (s *Server) Addr() string {
+	return s.srv.Addr
+}
+
+// Handler returns the HTTP handler serving the API, for mounting in another
+// server or in tests.
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
+}
+
+// Route returns the pattern of the handler serving r, such as
+// "GET /rate/{address}", or "" if there is none, e.g. to log requests
+// without the addresses in their path.
+func (s *Server) Route(r *http.Request) string {
+	_, pattern := s.mux.Handler(r)
+	return pattern
+}
+
+// Handle mounts an additional handler, e.g. a promhttp.Handler on /metrics.
+// It must be called before the server starts serving.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// SetReady marks the server ready, or not, for GET /readyz. A new server is
+// not ready, so that instances still warming their cache get no traffic.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// AddReadyCheck registers a check that GET /readyz runs on every probe once
+// the server is ready, e.g. that the loader backend is reachable. The request
+// context is passed to check. It must be called before the server starts
+// serving.
+func (s *Server) AddReadyCheck(name string, check func(context.Context) error) {
+	s.checks = append(s.checks, readyCheck{name: name, check: check})
+}
+
+// Use wraps every handler of the server, including ones mounted with Handle,
+// in middleware such as tracing.Middleware. Middleware added later runs
+// first. It must be called before the server starts serving.
+func (s *Server) Use(middleware func(http.Handler) http.Handler) {
+	s.srv.Handler = middleware(s.srv.Handler)
+}
+
+// SetTLSConfig makes the server serve HTTPS with cfg, which holds the
+// certificate of the server and, for mutual TLS, the CAs of the client
+// certificates. It must be called before the server starts serving.
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.srv.TLSConfig = cfg
+}
+
+// SetRounding sets how GET /tax rounds tax amounts, by default
+// salestax.DefaultRounding. It must be called before the server starts
+// serving.
+func (s *Server) SetRounding(r salestax.Rounding) {
+	s.rounding = r
+}
+
+// errReadOnly is replied to the writes of a read-only server.
+var errReadOnly = errors.New("read-only replica, write to the primary")
+
+// SetReadOnly makes the server refuse the writes of rates and exemptions
+// with 403, for read-only replicas kept by package replica. It must be
+// called before the server starts serving.
+func (s *Server) SetReadOnly() {
+	s.readOnly = true
+}
+
+// writing refuses the requests to a write handler of a read-only server.
+func (s *Server) writing(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly {
+			writeError(w, http.StatusForbidden, errReadOnly)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// EnableTaxability makes the category parameter of GET /rate and GET /tax
+// a product category, whose rate resolver looks up by the taxability rules
+// of the jurisdictions of the address. It must be called before the server
+// starts serving.
+func (s *Server) EnableTaxability(resolver *taxability.Resolver) {
+	s.taxability = resolver
+}
+
+// EnableHolidays makes GET /tax exempt the products covered by the tax
+// holidays of calendar on the date of the sale, today unless given. It must
+// be called before the server starts serving.
+func (s *Server) EnableHolidays(calendar *taxability.Calendar) {
+	s.holidays = calendar
+}
+
+// EnableExemptions serves the exemption certificates in exemptions,
+// calling loader on a miss, and makes GET /tax apply those of the customer
+// given. A nil loader makes them cache-only. It must be called before the
+// server starts serving.
+func (s *Server) EnableExemptions(exemptions *taxability.ExemptionCache, loader taxability.ExemptionLoaderFuncCtx) {
+	s.exemptions, s.exemptionLoader = exemptions, loader
+	s.handle("GET /exemptions/{customer}", s.handleGetExemptions, operation{
+		id:       "getExemptions",
+		summary:  "The exemption certificates of a customer",
+		response: ExemptionsResponse{},
+		errors:   []int{http.StatusNotFound, http.StatusBadGateway},
+	})
+	s.handle("PUT /exemptions/{customer}", s.writing(s.handlePutExemptions), operation{
+		id:       "putExemptions",
+		summary:  "Store the exemption certificates of a customer",
+		body:     taxability.Exemptions{},
+		response: ExemptionsResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusInternalServerError},
+	})
+	s.handle("DELETE /exemptions/{customer}", s.writing(s.handleDeleteExemptions), operation{
+		id:      "deleteExemptions",
+		summary: "Remove the cached exemption certificates of a customer",
+		errors:  []int{http.StatusNotFound},
+	})
+}
+
+// EnableCluster broadcasts the writes and invalidations of the server
+// through cluster, so that the other instances evict their copies. The
+// cluster must invalidate the caches of the server. It must be called
+// before the server starts serving.
+func (s *Server) EnableCluster(cluster *invalidation.Cluster) {
+	s.cluster = cluster
+}
+
+// EnableCoalescing makes the server look rates up through coalescer, which
+// shares them with the concurrent requests for the same address, including
+// those of other servers using it. coalescer must look up the cache and the
+// loader of the server. It must be called before the server starts serving.
+func (s *Server) EnableCoalescing(coalescer *salestax.Coalescer) {
+	s.coalescer = coalescer
+}
+
+// EnableHistory serves historical lookups from history, calling loader on a
+// miss; a nil loader makes them cache-only. It must be called before the
+// server starts serving.
+func (s *Server) EnableHistory(history *salestax.HistoryCache, loader salestax.HistoryLoaderFuncCtx) {
+	s.history, s.historyLoader = history, loader
+	s.handle("GET /rate/{address}/history", s.handleGetHistory, operation{
+		id:       "getHistory",
+		summary:  "The effective-dated rates of an address",
+		response: HistoryResponse{},
+		errors:   []int{http.StatusNotFound, http.StatusBadGateway},
+	})
+}
+
+// EnableDebug mounts GET /debug/cache and the net/http/pprof handlers under
+// /debug/pprof/. Both expose internals, /debug/cache also cached addresses,
+// so only enable them where the listener is not reachable from outside. It
+// must be called before the server starts serving.
+func (s *Server) EnableDebug() {
+	s.handle("GET /debug/cache", s.handleDebugCache, operation{
+		id:       "debugCache",
+		summary:  "Shard sizes, next victims and most hit addresses",
+		query:    []param{{name: "n", typ: "integer", description: "addresses of each, by default 10"}},
+		response: DebugCacheResponse{},
+		errors:   []int{http.StatusBadRequest},
+	})
+	s.handle("GET /debug/cache/{address}", s.handleDebugEntry, operation{
+		id:       "debugEntry",
+		summary:  "When the rate of an address was cached and last hit, its hits and TTL left",
+		query:    []param{{name: "category", typ: "string", description: "product category, by default the general rate"}},
+		response: DebugEntryResponse{},
+		errors:   []int{http.StatusNotFound},
+	})
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// ListenAndServe serves requests until Shutdown is called, in which case
+// it returns nil.
+func (s *Server) ListenAndServe() error {
+	var err error
+	if s.srv.TLSConfig != nil {
+		err = s.srv.ListenAndServeTLS("", "")
+	} else {
+		err = s.srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests
+// to finish or ctx to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) handleGetRate(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		s.handleGetRateAsOf(w, r, address, asOf)
+		return
+	}
+	rate, err := s.rateFor(r.Context(), address, r.URL.Query().Get("category"))
+	if err != nil {
+		writeError(w, lookupStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rateResponse(address, rate))
+}
+
+// rateFor returns the rate of products of category at address: with
+// EnableTaxability that of the taxability rules of category, otherwise the
+// rate cached under salestax.CategoryKey(address, category).
+func (s *Server) rateFor(ctx context.Context, address, category string) (salestax.TaxRate, error) {
+	if s.taxability != nil && category != "" {
+		return s.taxability.RateFor(ctx, address, category)
+	}
+	return s.lookup(ctx, salestax.CategoryKey(address, category))
+}
+
+// lookup returns the rate of key, calling the loader on a miss unless the
+// server is cache-only.
+func (s *Server) lookup(ctx context.Context, key string) (salestax.TaxRate, error) {
+	if s.coalescer != nil {
+		return s.coalescer.Lookup(ctx, key)
+	}
+	if s.loader == nil {
+		item, err := s.cache.Get(key)
+		if err != nil {
+			return salestax.TaxRate{}, err
+		}
+		return item.Value(), nil
+	}
+	return s.cache.GetOrLoadCtx(ctx, key, s.loader)
+}
+
+func (s *Server) handleGetTax(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	q := r.URL.Query()
+	amount, err := strconv.ParseFloat(q.Get("amount"), 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("amount %q is not a number", q.Get("amount")))
+		return
+	}
+
+	date := time.Now()
+	if d := q.Get("date"); d != "" {
+		if date, err = parseTime(d); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	rate, err := s.rateFor(r.Context(), address, q.Get("category"))
+	if err != nil {
+		writeError(w, lookupStatus(err), err)
+		return
+	}
+	var holidays []string
+	if s.holidays != nil {
+		rate, holidays = s.holidays.Apply(rate, q.Get("category"), amount, date)
+	}
+	var certificates []string
+	if customer := q.Get("customer"); customer != "" {
+		if s.exemptions == nil {
+			writeError(w, http.StatusBadRequest, errors.New("exemptions are not enabled"))
+			return
+		}
+		if rate, certificates, err = s.exemptions.Apply(r.Context(), rate, customer, date, s.exemptionLoader); err != nil {
+			writeError(w, lookupStatus(err), err)
+			return
+		}
+	}
+	calc, err := salestax.Calculate(rate, amount, s.rounding)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if calc.Category == "" {
+		calc.Category = q.Get("category")
+	}
+	writeJSON(w, http.StatusOK, TaxResponse{Address: address, Calculation: calc, Holidays: holidays, Certificates: certificates})
+}
+
+func (s *Server) handleGetRateAsOf(w http.ResponseWriter, r *http.Request, address, asOf string) {
+	if s.history == nil {
+		writeError(w, http.StatusBadRequest, errors.New("historical lookups are not enabled"))
+		return
+	}
+	t, err := parseTime(asOf)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rate, err := s.history.GetRateAsOfCtx(r.Context(), address, t, s.historyLoader)
+	if err != nil {
+		writeError(w, lookupStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rateResponse(address, rate))
+}
+
+// parseTime parses the as_of parameter, a date (taken as midnight UTC) or
+// an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("as_of %q is neither a date nor an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	var history salestax.RateHistory
+	var err error
+	if s.historyLoader == nil {
+		history, err = s.history.Lookup(address)
+	} else {
+		history, err = s.history.GetOrLoadCtx(r.Context(), address, s.historyLoader)
+	}
+	if err != nil {
+		writeError(w, lookupStatus(err), err)
+		return
+	}
+	resp := HistoryResponse{Address: address, Records: make([]RateResponse, len(history))}
+	for i, rate := range history {
+		resp.Records[i] = rateResponse(address, rate)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handlePutRate(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+
+	var req RateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rate := salestax.Flat(req.Rate)
+	if len(req.Components) > 0 {
+		rate.Components = req.Components
+		if total := rate.Total(); req.Rate != 0 && math.Abs(req.Rate-total) > 1e-9 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("rate %v is not the sum %v of the components", req.Rate, total))
+			return
+		}
+	}
+	rate.Country, rate.Category = req.Country, req.Category
+	rate.EffectiveFrom, rate.EffectiveUntil = req.EffectiveFrom, req.EffectiveUntil
+	if err := s.cache.Insert(address, rate); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if s.history != nil {
+		if err := s.history.AddRecord(address, rate); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	s.publishUpdate(address, rate)
+	if err := s.broadcast(r.Context(), address); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rateResponse(address, rate))
+}
+
+func rateResponse(address string, rate salestax.TaxRate) RateResponse {
+	return RateResponse{
+		Address:        address,
+		Rate:           rate.Total(),
+		Country:        rate.Country,
+		Category:       rate.Category,
+		Components:     rate.Components,
+		EffectiveFrom:  rate.EffectiveFrom,
+		EffectiveUntil: rate.EffectiveUntil,
+	}
+}
+
+// TaxRate returns the rate of r, e.g. as served by another salestax-srv.
+func (r RateResponse) TaxRate() salestax.TaxRate {
+	rate := salestax.Flat(r.Rate)
+	if len(r.Components) > 0 {
+		rate.Components = r.Components
+	}
+	rate.Country, rate.Category = r.Country, r.Category
+	rate.EffectiveFrom, rate.EffectiveUntil = r.EffectiveFrom, r.EffectiveUntil
+	return rate
+}
+
+func (s *Server) handleDeleteRate(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	deleted := s.cache.Delete(address)
+	if s.history != nil && s.history.Delete(address) {
+		deleted = true
+	}
+	// other instances, and watchers, may hold a copy even if this one did not
+	s.publish(watch.Event{Kind: watch.Delete, Address: address})
+	if err := s.broadcast(r.Context(), address); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, salestax.ErrNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// broadcast makes the other instances of the cluster evict their copies of
+// the rate of address, after a write.
+func (s *Server) broadcast(ctx context.Context, address string) error {
+	if s.cluster == nil {
+		return nil
+	}
+	if _, err := s.cluster.Invalidate(ctx, invalidation.Event{Keys: []string{address}}); err != nil {
+		return fmt.Errorf("broadcasting the invalidation of %s: %w", address, err)
+	}
+	return nil
+}
+
+func (s *Server) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	var req InvalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch {
+	case req.Prefix != "" && (req.Code != "" || req.Level != ""):
+		writeError(w, http.StatusBadRequest, errors.New("give either a prefix or a jurisdiction"))
+		return
+	case req.Prefix == "" && req.Code == "":
+		writeError(w, http.StatusBadRequest, errors.New("a prefix or a jurisdiction code is required"))
+		return
+	}
+	s.publish(watch.Event{Kind: watch.Invalidate, Prefix: req.Prefix, Level: req.Level, Code: req.Code})
+	if s.cluster != nil {
+		n, err := s.cluster.Invalidate(r.Context(), invalidation.Event{Prefix: req.Prefix, Level: req.Level, Code: req.Code})
+		if err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Errorf("invalidated %d locally, broadcasting failed: %w", n, err))
+			return
+		}
+		writeJSON(w, http.StatusOK, InvalidateResponse{Invalidated: n})
+		return
+	}
+
+	var n int
+	if req.Prefix != "" {
+		n = s.cache.InvalidateByPrefix(req.Prefix)
+		if s.history != nil {
+			n += s.history.InvalidateByPrefix(req.Prefix)
+		}
+	} else {
+		n = s.cache.InvalidateByJurisdiction(req.Level, req.Code)
+		if s.history != nil {
+			n += s.history.InvalidateByJurisdiction(req.Level, req.Code)
+		}
+	}
+	writeJSON(w, http.StatusOK, InvalidateResponse{Invalidated: n})
+}
+
+func (s *Server) handleGetExemptions(w http.ResponseWriter, r *http.Request) {
+	customer := r.PathValue("customer")
+	var e taxability.Exemptions
+	var err error
+	if s.exemptionLoader == nil {
+		e, err = s.exemptions.Lookup(customer)
+	} else {
+		e, err = s.exemptions.GetOrLoadCtx(r.Context(), customer, s.exemptionLoader)
+	}
+	if err != nil {
+		writeError(w, lookupStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ExemptionsResponse{Customer: customer, Certificates: certificates(e)})
+}
+
+func (s *Server) handlePutExemptions(w http.ResponseWriter, r *http.Request) {
+	customer := r.PathValue("customer")
+	var e taxability.Exemptions
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := e.Check(customer); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.exemptions.Insert(customer, e); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ExemptionsResponse{Customer: customer, Certificates: certificates(e)})
+}
+
+func (s *Server) handleDeleteExemptions(w http.ResponseWriter, r *http.Request) {
+	if !s.exemptions.Delete(r.PathValue("customer")) {
+		writeError(w, http.StatusNotFound, salestax.ErrNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// certificates returns e as a list that encodes as [] rather than null.
+func certificates(e taxability.Exemptions) []taxability.Certificate {
+	if e == nil {
+		return []taxability.Certificate{}
+	}
+	return e
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	st := s.cache.Stats()
+	writeJSON(w, http.StatusOK, StatsResponse{
+		Entries:      st.Size,
+		Hits:         st.Hits,
+		Misses:       st.Misses,
+		HitRatio:     st.HitRatio(),
+		Evictions:    st.Evictions,
+		Expirations:  st.Expirations,
+		LoaderCalls:  st.LoaderCalls,
+		LoaderErrors: st.LoaderErrors,
+		HotKeys:      debugKeys(s.cache.HotKeys(0)),
+		Windows:      statsWindows(st.Windows),
+	})
+}
+
+func statsWindows(windows []lrucache.WindowStats) []StatsWindow {
+	var out []StatsWindow
+	for _, w := range windows {
+		out = append(out, StatsWindow{
+			Window:       w.Window.String(),
+			Hits:         w.Hits,
+			Misses:       w.Misses,
+			HitRatio:     w.HitRatio(),
+			LoaderCalls:  w.LoaderCalls,
+			LoaderErrors: w.LoaderErrors,
+			LoadP50:      w.LoadP50.Seconds(),
+			LoadP99:      w.LoadP99.Seconds(),
+		})
+	}
+	return out
+}
+
+func (s *Server) handleDebugCache(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid n %q", v))
+			return
+		}
+	}
+	in := s.cache.Inspect(n)
+	resp := DebugCacheResponse{Shards: []DebugShard{}, Hot: []DebugKey{}}
+	for _, sh := range in.Shards {
+		resp.Shards = append(resp.Shards, DebugShard{
+			Entries:  sh.Len,
+			Weight:   sh.Weight,
+			Capacity: sh.Capacity,
+			Tail:     append([]string{}, sh.Tail...),
+		})
+	}
+	resp.Hot = append(resp.Hot, debugKeys(in.Hot)...)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleDebugEntry peeks at the item, so that looking at it counts neither
+// as a hit nor as an access for the eviction policy.
+func (s *Server) handleDebugEntry(w http.ResponseWriter, r *http.Request) {
+	key := salestax.CategoryKey(r.PathValue("address"), r.URL.Query().Get("category"))
+	item, info, err := s.cache.PeekWithInfo(key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, DebugEntryResponse{
+		Key:        item.Key(),
+		Created:    info.Created,
+		Accessed:   info.Accessed,
+		Hits:       info.Hits,
+		Expires:    info.Expires,
+		TTLSeconds: info.TTL.Seconds(),
+		Version:    info.Version,
+	})
+}
+
+func debugKeys(keys []lrucache.KeyHits[string]) []DebugKey {
+	var out []DebugKey
+	for _, k := range keys {
+		out = append(out, DebugKey{Address: k.Key, Hits: k.Hits})
+	}
+	return out
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeError(w, http.StatusServiceUnavailable, errors.New("Warming up"))
+		return
+	}
+	for _, c := range s.checks {
+		if err := c.check(r.Context()); err != nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("%s: %w", c.name, err))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ready"})
+}
+
+// lookupStatus returns the HTTP status reporting a failed lookup.
+func lookupStatus(err error) int {
+	switch {
+	case errors.Is(err, salestax.ErrNotFound), errors.Is(err, salestax.ErrExpired):
+		return http.StatusNotFound
+	case errors.Is(err, salestax.ErrThrottled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, salestax.ErrBackendUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,586 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache/lrucachetest"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/taxability"
+)
+
+// newTestServer returns a server of a fresh cache of 100 rates and an
+// httptest server serving its handler.
+func newTestServer(t *testing.T, loader salestax.RateLoaderFuncCtx, opts ...lrucache.Option) (*Server, *httptest.Server) {
+	t.Helper()
+	s := New("", salestax.NewRateCache(100, opts...), loader)
+	return s, serve(t, s)
+}
+
+// serve returns an httptest server serving the handler of s, once s is set
+// up.
+func serve(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends a request with body, if not empty, and decodes the JSON response
+// into out, if not nil.
+func do(t *testing.T, ts *httptest.Server, method, path, body string, out any) int {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding the %d response: %v", method, path, resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestRate(t *testing.T) {
+	var loads atomic.Int32
+	_, ts := newTestServer(t, func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		loads.Add(1)
+		if address == "nowhere" {
+			return salestax.TaxRate{}, salestax.ErrNotFound
+		}
+		return salestax.Flat(0.0725), nil
+	})
+
+	var got RateResponse
+	for range 2 {
+		if code := do(t, ts, "GET", "/rate/1%20Main%20St", "", &got); code != http.StatusOK || got.Rate != 0.0725 || got.Address != "1 Main St" {
+			t.Fatalf("GET /rate = %d %+v, want 200 with 0.0725", code, got)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loader called %d times, want 1", n)
+	}
+
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/rate/nowhere", "", &e); code != http.StatusNotFound || e.Error == "" {
+		t.Errorf("GET /rate of an unknown address = %d %+v, want 404 with an error", code, e)
+	}
+
+	body := `{"components": [{"level": "state", "code": "06", "rate": 0.06}, {"level": "county", "code": "06037", "rate": 0.0025}]}`
+	if code := do(t, ts, "PUT", "/rate/2%20Elm%20St", body, &got); code != http.StatusOK || math.Abs(got.Rate-0.0625) > 1e-12 || len(got.Components) != 2 {
+		t.Fatalf("PUT /rate = %d %+v, want 200 with the sum of the components", code, got)
+	}
+	if code := do(t, ts, "GET", "/rate/2%20Elm%20St", "", &got); code != http.StatusOK || math.Abs(got.Rate-0.0625) > 1e-12 {
+		t.Errorf("GET /rate after PUT = %d %+v", code, got)
+	}
+	for _, body := range []string{
+		`{"rate": `,
+		`{"rate": 0.5, "components": [{"level": "state", "rate": 0.06}]}`,
+	} {
+		if code := do(t, ts, "PUT", "/rate/2%20Elm%20St", body, &e); code != http.StatusBadRequest {
+			t.Errorf("PUT /rate %s = %d %+v, want 400", body, code, e)
+		}
+	}
+
+	if code := do(t, ts, "DELETE", "/rate/2%20Elm%20St", "", nil); code != http.StatusNoContent {
+		t.Errorf("DELETE /rate = %d, want 204", code)
+	}
+	if code := do(t, ts, "DELETE", "/rate/2%20Elm%20St", "", &e); code != http.StatusNotFound {
+		t.Errorf("DELETE /rate of a deleted address = %d, want 404", code)
+	}
+}
+
+func TestCacheOnly(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/rate/1%20Main%20St", "", &e); code != http.StatusNotFound {
+		t.Errorf("GET /rate of a cache-only server on a miss = %d, want 404", code)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, ts := newTestServer(t, func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		return salestax.Flat(0.05), nil
+	})
+	do(t, ts, "GET", "/rate/a", "", nil)
+	do(t, ts, "GET", "/rate/a", "", nil)
+	do(t, ts, "GET", "/rate/b", "", nil)
+
+	var st StatsResponse
+	if code := do(t, ts, "GET", "/stats", "", &st); code != http.StatusOK {
+		t.Fatalf("GET /stats = %d", code)
+	}
+	if st.Entries != 2 || st.Hits != 1 || st.Misses != 2 || st.LoaderCalls != 2 {
+		t.Errorf("GET /stats = %+v, want 2 entries, 1 hit, 2 misses and 2 loader calls", st)
+	}
+	if math.Abs(st.HitRatio-1.0/3) > 1e-9 {
+		t.Errorf("hit ratio = %v, want 1/3", st.HitRatio)
+	}
+}
+
+func TestLookupStatus(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{salestax.ErrNotFound, http.StatusNotFound},
+		{salestax.ErrExpired, http.StatusNotFound},
+		{fmt.Errorf("%w: %w", salestax.ErrLoaderFailed, salestax.ErrNotFound), http.StatusNotFound},
+		{salestax.ErrThrottled, http.StatusTooManyRequests},
+		{salestax.ErrBackendUnavailable, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: backend down", salestax.ErrLoaderFailed), http.StatusBadGateway},
+		{errors.New("anything else"), http.StatusBadGateway},
+	} {
+		if got := lookupStatus(tt.err); got != tt.want {
+			t.Errorf("lookupStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	s := New(addr, salestax.NewRateCache(10), func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		close(started)
+		<-release
+		return salestax.Flat(0.07), nil
+	})
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	status := make(chan int, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for range 100 {
+			if resp, err = http.Get("http://" + addr + "/rate/a"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the request in flight finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("request in flight during Shutdown = %d, want 200", code)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ListenAndServe after Shutdown = %v, want nil", err)
+	}
+	if _, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		t.Error("the server still accepts connections after Shutdown")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := New("", salestax.NewRateCache(10), nil)
+	var backendErr atomic.Value
+	s.AddReadyCheck("backend", func(ctx context.Context) error {
+		err, _ := backendErr.Load().(error)
+		return err
+	})
+	ts := serve(t, s)
+
+	var h HealthResponse
+	if code := do(t, ts, "GET", "/healthz", "", &h); code != http.StatusOK || h.Status != "ok" {
+		t.Errorf("GET /healthz = %d %+v, want 200 ok", code, h)
+	}
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/readyz", "", &e); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz before SetReady = %d, want 503", code)
+	}
+	s.SetReady(true)
+	if code := do(t, ts, "GET", "/readyz", "", &h); code != http.StatusOK || h.Status != "ready" {
+		t.Errorf("GET /readyz once ready = %d %+v, want 200 ready", code, h)
+	}
+	backendErr.Store(errors.New("connection refused"))
+	if code := do(t, ts, "GET", "/readyz", "", &e); code != http.StatusServiceUnavailable || !strings.HasPrefix(e.Error, "backend: ") {
+		t.Errorf("GET /readyz with a failing check = %d %+v, want 503 naming the check", code, e)
+	}
+}
+
+func TestDebugCache(t *testing.T) {
+	cache := salestax.NewRateCache(100, lrucache.WithShards(2))
+	s := New("", cache, nil)
+	s.EnableDebug()
+	ts := serve(t, s)
+	for _, address := range []string{"a", "b", "c", "d"} {
+		cache.Insert(address, salestax.Flat(0.05))
+	}
+	for range 3 {
+		do(t, ts, "GET", "/rate/c", "", nil)
+	}
+
+	var got DebugCacheResponse
+	if code := do(t, ts, "GET", "/debug/cache?n=1", "", &got); code != http.StatusOK {
+		t.Fatalf("GET /debug/cache = %d", code)
+	}
+	entries := 0
+	for _, sh := range got.Shards {
+		entries += sh.Entries
+		if len(sh.Tail) > 1 {
+			t.Errorf("shard tail %q, want at most n=1 addresses", sh.Tail)
+		}
+	}
+	if len(got.Shards) != 2 || entries != 4 {
+		t.Errorf("GET /debug/cache shards = %+v, want 2 holding 4 entries", got.Shards)
+	}
+	if len(got.Hot) != 1 || got.Hot[0].Address != "c" || got.Hot[0].Hits != 3 {
+		t.Errorf("GET /debug/cache hot = %+v, want c with 3 hits", got.Hot)
+	}
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/debug/cache?n=-1", "", &e); code != http.StatusBadRequest {
+		t.Errorf("GET /debug/cache?n=-1 = %d, want 400", code)
+	}
+	if code := do(t, ts, "GET", "/debug/pprof/", "", nil); code != http.StatusOK {
+		t.Errorf("GET /debug/pprof/ = %d, want 200", code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	jan := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	jul := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	var loads atomic.Int32
+	s := New("", salestax.NewRateCache(100), nil)
+	s.EnableHistory(salestax.NewHistoryCache(100), func(ctx context.Context, address string) (salestax.RateHistory, error) {
+		loads.Add(1)
+		old, cur := salestax.Flat(0.06), salestax.Flat(0.065)
+		old.EffectiveFrom, old.EffectiveUntil = jan, jul
+		cur.EffectiveFrom = jul
+		return salestax.RateHistory{old, cur}, nil
+	})
+	ts := serve(t, s)
+
+	var got RateResponse
+	for _, tt := range []struct {
+		asOf string
+		want float64
+	}{
+		{"2026-03-01", 0.06},
+		{"2026-07-01T00:00:00Z", 0.065},
+		{"2027-01-01", 0.065},
+	} {
+		if code := do(t, ts, "GET", "/rate/a?as_of="+tt.asOf, "", &got); code != http.StatusOK || got.Rate != tt.want {
+			t.Errorf("GET /rate?as_of=%s = %d %+v, want %v", tt.asOf, code, got, tt.want)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("history loaded %d times, want 1", n)
+	}
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/rate/a?as_of=2025-12-31", "", &e); code != http.StatusNotFound {
+		t.Errorf("GET /rate as of before any record = %d, want 404", code)
+	}
+	if code := do(t, ts, "GET", "/rate/a?as_of=yesterday", "", &e); code != http.StatusBadRequest {
+		t.Errorf("GET /rate?as_of=yesterday = %d, want 400", code)
+	}
+
+	var h HistoryResponse
+	if code := do(t, ts, "GET", "/rate/a/history", "", &h); code != http.StatusOK || len(h.Records) != 2 || !h.Records[1].EffectiveFrom.Equal(jul) {
+		t.Errorf("GET /rate/a/history = %d %+v, want both records in order", code, h)
+	}
+
+	// a PUT adds the rate to the history
+	if code := do(t, ts, "PUT", "/rate/a", `{"rate": 0.07, "effective_from": "2027-01-01T00:00:00Z"}`, &got); code != http.StatusOK {
+		t.Fatalf("PUT /rate = %d", code)
+	}
+	if code := do(t, ts, "GET", "/rate/a/history", "", &h); code != http.StatusOK || len(h.Records) != 3 {
+		t.Errorf("GET /rate/a/history after PUT = %d with %d records, want 3", code, len(h.Records))
+	}
+	if code := do(t, ts, "GET", "/rate/a?as_of=2027-02-01", "", &got); code != http.StatusOK || got.Rate != 0.07 {
+		t.Errorf("GET /rate as of after the PUT = %d %+v, want 0.07", code, got)
+	}
+}
+
+func TestHistoryNotEnabled(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/rate/a?as_of=2026-03-01", "", &e); code != http.StatusBadRequest {
+		t.Errorf("GET /rate?as_of without EnableHistory = %d, want 400", code)
+	}
+}
+
+// texas is the rate of an address of Austin, TX.
+var texas = salestax.TaxRate{Components: []salestax.Component{
+	{Level: salestax.State, Code: "TX", Rate: 0.0625},
+	{Level: salestax.County, Name: "Travis", Rate: 0.01},
+	{Level: salestax.City, Name: "Austin", Rate: 0.01},
+}}
+
+func TestTax(t *testing.T) {
+	loader := func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		return texas, nil
+	}
+	_, ts := newTestServer(t, loader)
+	var got TaxResponse
+	if code := do(t, ts, "GET", "/tax/a?amount=19.99", "", &got); code != http.StatusOK {
+		t.Fatalf("GET /tax = %d", code)
+	}
+	if got.Address != "a" || got.Tax != 1.65 || got.Total != 21.64 || len(got.Lines) != 3 {
+		t.Errorf("GET /tax = %+v, want 1.65 of tax over 3 lines", got)
+	}
+	var lines float64
+	for _, l := range got.Lines {
+		lines += l.Tax
+	}
+	if math.Abs(lines-got.Tax) > 1e-9 {
+		t.Errorf("lines add up to %v, not the tax %v", lines, got.Tax)
+	}
+
+	var e ErrorResponse
+	for _, q := range []string{"", "amount=abc", "amount=NaN", "amount=Inf", "amount=1&date=tomorrow"} {
+		if code := do(t, ts, "GET", "/tax/a?"+q, "", &e); code != http.StatusBadRequest {
+			t.Errorf("GET /tax?%s = %d, want 400", q, code)
+		}
+	}
+	if code := do(t, ts, "GET", "/tax/a?amount=1&customer=acme", "", &e); code != http.StatusBadRequest {
+		t.Errorf("GET /tax?customer without EnableExemptions = %d, want 400", code)
+	}
+
+	s := New("", salestax.NewRateCache(10), loader)
+	s.SetRounding(salestax.Rounding{Mode: salestax.RoundHalfUp, Precision: 0})
+	if code := do(t, serve(t, s), "GET", "/tax/a?amount=19.99", "", &got); code != http.StatusOK || got.Tax != 2 {
+		t.Errorf("GET /tax rounded to units = %d %+v, want 2 of tax", code, got)
+	}
+}
+
+func TestTaxHoliday(t *testing.T) {
+	calendar, err := taxability.ReadCalendar(strings.NewReader(`[{"name": "back to school",
+		"start": "2026-08-07", "end": "2026-08-09", "jurisdictions": [{"level": "state", "code": "TX"}],
+		"categories": ["clothing"], "max_amount": 100}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("", salestax.NewRateCache(10), func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		return texas, nil
+	})
+	s.EnableHolidays(calendar)
+	ts := serve(t, s)
+
+	for _, tt := range []struct {
+		query    string
+		tax      float64
+		holidays int
+	}{
+		{"amount=50&category=clothing&date=2026-08-08", 1, 1},
+		{"amount=50&category=clothing&date=2026-08-10", 4.13, 0},
+		{"amount=150&category=clothing&date=2026-08-08", 12.38, 0},
+		{"amount=50&date=2026-08-08", 4.13, 0},
+	} {
+		var got TaxResponse
+		if code := do(t, ts, "GET", "/tax/a?"+tt.query, "", &got); code != http.StatusOK || got.Tax != tt.tax || len(got.Holidays) != tt.holidays {
+			t.Errorf("GET /tax?%s = %d %+v, want %v of tax and %d holidays", tt.query, code, got, tt.tax, tt.holidays)
+		}
+	}
+}
+
+func TestExemptions(t *testing.T) {
+	s := New("", salestax.NewRateCache(10), func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		return texas, nil
+	})
+	s.EnableExemptions(taxability.NewExemptionCache(10), nil)
+	ts := serve(t, s)
+
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/exemptions/acme", "", &e); code != http.StatusNotFound {
+		t.Errorf("GET /exemptions of an unknown customer = %d, want 404", code)
+	}
+	for _, body := range []string{
+		`[{"id": "R-1", "customer": "other"}]`,
+		`[{"id": "", "customer": "acme"}]`,
+		`[{"id": "R-1", "customer": "acme", "expires": "someday"}]`,
+		`{"id": "R-1"}`,
+	} {
+		if code := do(t, ts, "PUT", "/exemptions/acme", body, &e); code != http.StatusBadRequest {
+			t.Errorf("PUT /exemptions %s = %d, want 400", body, code)
+		}
+	}
+	var got ExemptionsResponse
+	body := `[{"id": "R-1", "customer": "acme", "expires": "2026-12-31", "jurisdictions": [{"level": "state", "code": "TX"}]}]`
+	if code := do(t, ts, "PUT", "/exemptions/acme", body, &got); code != http.StatusOK || len(got.Certificates) != 1 {
+		t.Fatalf("PUT /exemptions = %d %+v", code, got)
+	}
+	if code := do(t, ts, "GET", "/exemptions/acme", "", &got); code != http.StatusOK || got.Customer != "acme" || got.Certificates[0].ID != "R-1" {
+		t.Errorf("GET /exemptions = %d %+v, want R-1", code, got)
+	}
+
+	var tax TaxResponse
+	if code := do(t, ts, "GET", "/tax/a?amount=100&customer=acme&date=2026-06-01", "", &tax); code != http.StatusOK || tax.Tax != 2 || !slices.Equal(tax.Certificates, []string{"R-1"}) {
+		t.Errorf("GET /tax for acme = %d %+v, want the state exempted by R-1", code, tax)
+	}
+	tax = TaxResponse{}
+	if code := do(t, ts, "GET", "/tax/a?amount=100&customer=acme&date=2027-01-01", "", &tax); code != http.StatusOK || tax.Tax != 8.25 || len(tax.Certificates) != 0 {
+		t.Errorf("GET /tax for acme once R-1 expired = %d %+v, want no exemption", code, tax)
+	}
+
+	if code := do(t, ts, "DELETE", "/exemptions/acme", "", nil); code != http.StatusNoContent {
+		t.Errorf("DELETE /exemptions = %d, want 204", code)
+	}
+	if code := do(t, ts, "DELETE", "/exemptions/acme", "", &e); code != http.StatusNotFound {
+		t.Errorf("DELETE /exemptions of a deleted customer = %d, want 404", code)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	history := salestax.NewHistoryCache(100)
+	s := New("", cache, nil)
+	s.EnableHistory(history, nil)
+	ts := serve(t, s)
+	california := salestax.TaxRate{Components: []salestax.Component{{Level: salestax.State, Code: "06", Rate: 0.0725}}}
+	for _, address := range []string{"TX:1", "TX:2", "CA:1"} {
+		rate := texas
+		if strings.HasPrefix(address, "CA:") {
+			rate = california
+		}
+		cache.Insert(address, rate)
+	}
+	history.AddRecord("TX:1", texas)
+
+	var got InvalidateResponse
+	if code := do(t, ts, "POST", "/invalidate", `{"prefix": "TX:"}`, &got); code != http.StatusOK || got.Invalidated != 3 {
+		t.Errorf("POST /invalidate by prefix = %d %+v, want the 2 rates and the history", code, got)
+	}
+	if cache.Len() != 1 || history.Len() != 0 {
+		t.Errorf("after invalidating TX: %d rates and %d histories left, want 1 and 0", cache.Len(), history.Len())
+	}
+	if code := do(t, ts, "POST", "/invalidate", `{"level": "state", "code": "06"}`, &got); code != http.StatusOK || got.Invalidated != 1 || cache.Len() != 0 {
+		t.Errorf("POST /invalidate by jurisdiction = %d %+v, want the CA rate", code, got)
+	}
+
+	var e ErrorResponse
+	for _, body := range []string{`{}`, `{"prefix": "TX:", "code": "48"}`, `{"level": "state"}`, `[`} {
+		if code := do(t, ts, "POST", "/invalidate", body, &e); code != http.StatusBadRequest {
+			t.Errorf("POST /invalidate %s = %d, want 400", body, code)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	cache := salestax.NewRateCache(10)
+	cache.Insert("a", salestax.Flat(0.05))
+	s := New("", cache, nil)
+	s.EnableExemptions(taxability.NewExemptionCache(10), nil)
+	s.SetReadOnly()
+	ts := serve(t, s)
+
+	for _, req := range []struct{ method, path, body string }{
+		{"PUT", "/rate/a", `{"rate": 0.06}`},
+		{"DELETE", "/rate/a", ""},
+		{"POST", "/invalidate", `{"prefix": "a"}`},
+		{"PUT", "/exemptions/acme", `[]`},
+		{"DELETE", "/exemptions/acme", ""},
+	} {
+		var e ErrorResponse
+		if code := do(t, ts, req.method, req.path, req.body, &e); code != http.StatusForbidden || e.Error != errReadOnly.Error() {
+			t.Errorf("%s %s on a replica = %d %+v, want 403", req.method, req.path, code, e)
+		}
+	}
+	var got RateResponse
+	if code := do(t, ts, "GET", "/rate/a", "", &got); code != http.StatusOK || got.Rate != 0.05 {
+		t.Errorf("GET /rate on a replica = %d %+v, want the rate unchanged", code, got)
+	}
+}
+
+func TestDebugEntry(t *testing.T) {
+	cache := salestax.NewRateCache(10, lrucache.WithTTL(time.Hour))
+	s := New("", cache, nil)
+	s.EnableDebug()
+	ts := serve(t, s)
+	before := time.Now()
+	cache.Insert("a", salestax.Flat(0.05))
+	cache.Insert(salestax.CategoryKey("a", "food"), salestax.Flat(0.01))
+
+	var got DebugEntryResponse
+	for range 2 {
+		if code := do(t, ts, "GET", "/debug/cache/a", "", &got); code != http.StatusOK {
+			t.Fatalf("GET /debug/cache/a = %d", code)
+		}
+	}
+	if got.Key != "a" || got.Hits != 0 || !got.Accessed.IsZero() || got.Created.Before(before) {
+		t.Errorf("GET /debug/cache/a = %+v, want a created after %v and never hit, peeking included", got, before)
+	}
+	if got.TTLSeconds <= 3590 || got.TTLSeconds > 3600 || got.Expires.Sub(got.Created.Add(time.Hour)).Abs() > time.Second {
+		t.Errorf("TTL %vs expiring at %v, want an hour from creation", got.TTLSeconds, got.Expires)
+	}
+
+	do(t, ts, "GET", "/rate/a", "", nil)
+	do(t, ts, "GET", "/rate/a", "", nil)
+	got = DebugEntryResponse{}
+	if code := do(t, ts, "GET", "/debug/cache/a", "", &got); code != http.StatusOK || got.Hits != 2 || got.Accessed.Before(got.Created) {
+		t.Errorf("GET /debug/cache/a after 2 hits = %d %+v", code, got)
+	}
+	if code := do(t, ts, "GET", "/debug/cache/a?category=food", "", &got); code != http.StatusOK || got.Key != salestax.CategoryKey("a", "food") {
+		t.Errorf("GET /debug/cache/a?category=food = %d %+v, want the food rate", code, got)
+	}
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/debug/cache/b", "", &e); code != http.StatusNotFound {
+		t.Errorf("GET /debug/cache of an uncached address = %d, want 404", code)
+	}
+}
+
+func TestDebugEntryClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := lrucachetest.NewClock(start)
+	cache := salestax.NewRateCache(10, lrucache.WithTTL(time.Hour), lrucache.WithClock(clock))
+	s := New("", cache, nil)
+	s.EnableDebug()
+	ts := serve(t, s)
+	cache.Insert("a", salestax.Flat(0.05))
+	clock.Advance(10 * time.Minute)
+
+	// the TTL is left on the cache's clock, not the wall clock
+	var got DebugEntryResponse
+	if code := do(t, ts, "GET", "/debug/cache/a", "", &got); code != http.StatusOK {
+		t.Fatalf("GET /debug/cache/a = %d", code)
+	}
+	if !got.Created.Equal(start) || got.TTLSeconds != 50*60 {
+		t.Errorf("GET /debug/cache/a = %+v, want created at %v with 50m left", got, start)
+	}
+}
@@ -0,0 +1,226 @@
+package httpserver
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is the version of the HTTP API in the OpenAPI document.
+const apiVersion = "1.0"
+
+// operation documents a route in the OpenAPI document served on
+// GET /openapi.json. The parameters of its path come from its pattern.
+type operation struct {
+	id          string
+	summary     string
+	query       []param
+	body        any    // a value of the type of the JSON request body, nil if none
+	response    any    // a value of the type of the JSON response body, nil for 204 No Content
+	contentType string // of the response, by default application/json
+	errors      []int  // statuses of the ErrorResponses it may reply
+}
+
+// param is a query parameter of an operation.
+type param struct {
+	name        string
+	typ         string // OpenAPI type, by default string
+	description string
+	required    bool
+}
+
+// route is a documented route of the server.
+type route struct {
+	method, path string
+	op           operation
+}
+
+// handle mounts handler on pattern and documents it as op.
+func (s *Server) handle(pattern string, handler http.HandlerFunc, op operation) {
+	s.mux.HandleFunc(pattern, handler)
+	method, path, _ := strings.Cut(pattern, " ")
+	s.routes = append(s.routes, route{method: strings.ToLower(method), path: path, op: op})
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.openAPI())
+}
+
+// openAPI returns the OpenAPI 3 document of the routes mounted so far.
+func (s *Server) openAPI() map[string]any {
+	g := &schemas{defs: make(map[string]any)}
+	paths := make(map[string]map[string]any)
+	for _, rt := range s.routes {
+		if paths[rt.path] == nil {
+			paths[rt.path] = make(map[string]any)
+		}
+		paths[rt.path][rt.method] = g.operation(rt)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "salestax-srv",
+			"version": apiVersion,
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": g.defs},
+	}
+}
+
+// operation returns the OpenAPI operation object of rt.
+func (g *schemas) operation(rt route) map[string]any {
+	op := rt.op
+	var params []any
+	for _, seg := range strings.Split(rt.path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			params = append(params, map[string]any{
+				"name":     strings.TrimSuffix(name, "}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	for _, p := range op.query {
+		typ := p.typ
+		if typ == "" {
+			typ = "string"
+		}
+		qp := map[string]any{
+			"name":     p.name,
+			"in":       "query",
+			"required": p.required,
+			"schema":   map[string]any{"type": typ},
+		}
+		if p.description != "" {
+			qp["description"] = p.description
+		}
+		params = append(params, qp)
+	}
+
+	responses := make(map[string]any)
+	if op.response == nil {
+		responses["204"] = map[string]any{"description": http.StatusText(http.StatusNoContent)}
+	} else {
+		ct := op.contentType
+		if ct == "" {
+			ct = "application/json"
+		}
+		schema := g.schema(reflect.TypeOf(op.response))
+		if ct == "application/octet-stream" {
+			schema = map[string]any{"type": "string", "format": "binary"}
+		}
+		responses["200"] = map[string]any{
+			"description": http.StatusText(http.StatusOK),
+			"content":     map[string]any{ct: map[string]any{"schema": schema}},
+		}
+	}
+	for _, code := range op.errors {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(ErrorResponse{}))}},
+		}
+	}
+
+	o := map[string]any{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"responses":   responses,
+	}
+	if params != nil {
+		o["parameters"] = params
+	}
+	if op.body != nil {
+		o["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(op.body))}},
+		}
+	}
+	return o
+}
+
+// schemas derives JSON schemas from Go types, defining named structs once
+// under components/schemas.
+type schemas struct {
+	defs map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of the JSON encoding of t.
+func (g *schemas) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // defined, for types that refer to themselves
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// object returns the schema of a struct, whose fields without omitempty or
+// omitzero are required.
+func (g *schemas) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	g.fields(t, props, &required)
+	o := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		o["required"] = required
+	}
+	return o
+}
+
+// fields adds the properties of the fields of struct t, and of those of its
+// embedded structs, as encoding/json encodes them.
+func (g *schemas) fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.fields(f.Type, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+}
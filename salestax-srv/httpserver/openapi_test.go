@@ -0,0 +1,178 @@
+package httpserver
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/taxability"
+)
+
+// openAPIDoc is the part of an OpenAPI document the tests look at.
+type openAPIDoc struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string `json:"operationId"`
+	Parameters  []struct {
+		Name     string `json:"name"`
+		In       string `json:"in"`
+		Required bool   `json:"required"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type openAPISchema struct {
+	Ref        string                   `json:"$ref"`
+	Type       string                   `json:"type"`
+	Properties map[string]openAPISchema `json:"properties"`
+	Items      *openAPISchema           `json:"items"`
+	Required   []string                 `json:"required"`
+}
+
+// refs returns the schemas s refers to, itself included.
+func (s openAPISchema) refs() []string {
+	var refs []string
+	if s.Ref != "" {
+		refs = append(refs, strings.TrimPrefix(s.Ref, "#/components/schemas/"))
+	}
+	if s.Items != nil {
+		refs = append(refs, s.Items.refs()...)
+	}
+	for _, p := range s.Properties {
+		refs = append(refs, p.refs()...)
+	}
+	return refs
+}
+
+func getOpenAPI(t *testing.T, s *Server) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if code := do(t, serve(t, s), "GET", "/openapi.json", "", &doc); code != http.StatusOK {
+		t.Fatalf("GET /openapi.json = %d", code)
+	}
+	return doc
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc := getOpenAPI(t, New("", salestax.NewRateCache(10), nil))
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q, want 3.0.3", doc.OpenAPI)
+	}
+	for _, path := range []string{"/rate/{address}", "/rates:batchGet", "/tax/{address}", "/invalidate", "/stats", "/healthz", "/readyz"} {
+		if doc.Paths[path] == nil {
+			t.Errorf("%s is not documented", path)
+		}
+	}
+	if doc.Paths["/exemptions/{customer}"] != nil || doc.Paths["/admin/cache"] != nil {
+		t.Error("the routes of features not enabled are documented")
+	}
+
+	get := doc.Paths["/rate/{address}"]["get"]
+	if get.OperationID != "getRate" {
+		t.Errorf("GET /rate/{address} operationId = %q", get.OperationID)
+	}
+	var params []string
+	for _, p := range get.Parameters {
+		params = append(params, p.In+":"+p.Name)
+		if p.In == "path" && !p.Required {
+			t.Errorf("path parameter %s not required", p.Name)
+		}
+	}
+	if want := []string{"path:address", "query:category", "query:as_of"}; !slices.Equal(params, want) {
+		t.Errorf("GET /rate/{address} parameters %q, want %q", params, want)
+	}
+	if ref := get.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/RateResponse" {
+		t.Errorf("GET /rate/{address} 200 schema %q, want RateResponse", ref)
+	}
+	if ref := get.Responses["404"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/ErrorResponse" {
+		t.Errorf("GET /rate/{address} 404 schema %q, want ErrorResponse", ref)
+	}
+	if put := doc.Paths["/rate/{address}"]["put"]; put.RequestBody == nil ||
+		put.RequestBody.Content["application/json"].Schema.Ref != "#/components/schemas/RateRequest" {
+		t.Error("PUT /rate/{address} does not take a RateRequest")
+	}
+	if _, ok := doc.Paths["/rate/{address}"]["delete"].Responses["204"]; !ok {
+		t.Error("DELETE /rate/{address} does not reply 204")
+	}
+
+	rate := doc.Components.Schemas["RateResponse"]
+	if !slices.Contains(rate.Required, "address") || slices.Contains(rate.Required, "country") || slices.Contains(rate.Required, "effective_from") {
+		t.Errorf("RateResponse requires %q, want address but not the omitempty or omitzero fields", rate.Required)
+	}
+	if tax := doc.Components.Schemas["TaxResponse"]; tax.Properties["amount"].Type != "number" || tax.Properties["lines"].Type != "array" {
+		t.Errorf("TaxResponse properties %v, want those of the embedded Calculation", tax.Properties)
+	}
+}
+
+func TestOpenAPIEnabled(t *testing.T) {
+	s := New("", salestax.NewRateCache(10), nil)
+	s.EnableExemptions(taxability.NewExemptionCache(10), nil)
+	s.EnableHistory(salestax.NewHistoryCache(10), nil)
+	s.EnableAdmin(Admin{Token: adminToken})
+	s.EnableDebug()
+	doc := getOpenAPI(t, s)
+	for path, methods := range map[string][]string{
+		"/exemptions/{customer}":  {"get", "put", "delete"},
+		"/rate/{address}/history": {"get"},
+		"/admin/cache":            {"get", "patch"},
+		"/admin/evict":            {"post"},
+		"/admin/snapshot":         {"get", "post"},
+		"/debug/cache":            {"get"},
+		"/debug/cache/{address}":  {"get"},
+	} {
+		for _, m := range methods {
+			if _, ok := doc.Paths[path][m]; !ok {
+				t.Errorf("%s %s is not documented", strings.ToUpper(m), path)
+			}
+		}
+	}
+	if ct := doc.Paths["/admin/snapshot"]["get"].Responses["200"].Content; ct["application/octet-stream"].Schema.Type != "string" {
+		t.Errorf("GET /admin/snapshot content %v, want a binary string", ct)
+	}
+
+	// every schema referred to is defined
+	for path, methods := range doc.Paths {
+		for m, op := range methods {
+			var refs []string
+			for _, r := range op.Responses {
+				for _, c := range r.Content {
+					refs = append(refs, c.Schema.refs()...)
+				}
+			}
+			if op.RequestBody != nil {
+				for _, c := range op.RequestBody.Content {
+					refs = append(refs, c.Schema.refs()...)
+				}
+			}
+			for _, ref := range refs {
+				if _, ok := doc.Components.Schemas[ref]; !ok {
+					t.Errorf("%s %s refers to the undefined schema %s", m, path, ref)
+				}
+			}
+		}
+	}
+	for name, schema := range doc.Components.Schemas {
+		for _, ref := range schema.refs() {
+			if _, ok := doc.Components.Schemas[ref]; !ok {
+				t.Errorf("schema %s refers to the undefined schema %s", name, ref)
+			}
+		}
+	}
+}
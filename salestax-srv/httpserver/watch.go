@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// watchHeartbeat is how often an idle GET /watch stream is sent a comment,
+// so that proxies do not close it.
+const watchHeartbeat = 15 * time.Second
+
+// EnableWatch mounts GET /watch, a stream of Server-Sent Events of the rate
+// changes published to hub, and makes PUT, DELETE and POST /invalidate
+// publish theirs. Each event is a watch.Event in JSON, under its ID and its
+// kind as the event type:
+//
+//	id: 42
+//	event: update
+//	data: {"id":42,"kind":"update","address":"TX:1 Congress Ave","rate":{...}}
+//
+// A client reconnecting with the Last-Event-ID header, or ?after=, is sent
+// the events it missed first. The invalidations received from the other
+// instances of a cluster are only streamed if passed to hub, see
+// invalidation.Cluster.Notify. It must be called before the server starts
+// serving.
+func (s *Server) EnableWatch(hub *watch.Hub) {
+	s.watch = hub
+	s.handle("GET /watch", s.handleWatch, operation{
+		id:          "watch",
+		summary:     "Stream rate changes as Server-Sent Events of watch.Event",
+		query:       []param{{name: "after", typ: "integer", description: "ID of the last event seen, as the Last-Event-ID header"}},
+		response:    watch.Event{},
+		contentType: "text/event-stream",
+		errors:      []int{http.StatusBadRequest},
+	})
+	// streams only end with their client, Shutdown would wait for them
+	s.srv.RegisterOnShutdown(func() { close(s.stopWatch) })
+}
+
+// publish sends e to the watchers, if EnableWatch was called.
+func (s *Server) publish(e watch.Event) {
+	if s.watch != nil {
+		s.watch.Publish(e)
+	}
+}
+
+// publishUpdate publishes the write of rate to address.
+func (s *Server) publishUpdate(address string, rate salestax.TaxRate) {
+	s.publish(watch.Event{Kind: watch.Update, Address: address, Rate: &rate})
+}
+
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	var last uint64
+	if after != "" {
+		var err error
+		if last, err = strconv.ParseUint(after, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid last event ID %q", after))
+			return
+		}
+	}
+	rc := http.NewResponseController(w)
+	sub := s.watch.Subscribe(last)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		// a ResponseWriter that cannot flush would buffer the stream
+		return
+	}
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopWatch:
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				// dropped for not keeping up, the client reconnects
+				return
+			}
+			data, _ := json.Marshal(e)
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Kind, data)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// sse is a Server-Sent Event read from a GET /watch stream.
+type sse struct {
+	id, event string
+	data      watch.Event
+}
+
+// openWatch opens a GET /watch stream with the given Last-Event-ID, if not
+// empty, once it is subscribed to hub.
+func openWatch(t *testing.T, ts *httptest.Server, hub *watch.Hub, lastID string) *bufio.Reader {
+	t.Helper()
+	watchers := hub.Watchers()
+	req, _ := http.NewRequest("GET", ts.URL+"/watch", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /watch = %d %s, want 200 text/event-stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for deadline := time.Now().Add(time.Second); hub.Watchers() == watchers; {
+		if time.Now().After(deadline) {
+			t.Fatal("GET /watch did not subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// next reads the next event of a stream.
+func next(t *testing.T, r *bufio.Reader) sse {
+	t.Helper()
+	var e sse
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch k, v, _ := strings.Cut(line, ": "); k {
+		case "":
+			if e.id != "" {
+				return e
+			}
+		case "id":
+			e.id = v
+		case "event":
+			e.event = v
+		case "data":
+			if err := json.Unmarshal([]byte(v), &e.data); err != nil {
+				t.Fatalf("event data %s: %v", v, err)
+			}
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	hub := watch.New()
+	cache := salestax.NewRateCache(10)
+	s := New("", cache, nil)
+	s.EnableWatch(hub)
+	ts := serve(t, s)
+
+	stream := openWatch(t, ts, hub, "")
+	do(t, ts, "PUT", "/rate/a", `{"rate": 0.05}`, nil)
+	do(t, ts, "DELETE", "/rate/a", "", nil)
+	do(t, ts, "POST", "/invalidate", `{"prefix": "TX:"}`, nil)
+
+	want := []sse{
+		{"1", "update", watch.Event{ID: 1, Kind: watch.Update, Address: "a"}},
+		{"2", "delete", watch.Event{ID: 2, Kind: watch.Delete, Address: "a"}},
+		{"3", "invalidate", watch.Event{ID: 3, Kind: watch.Invalidate, Prefix: "TX:"}},
+	}
+	for _, w := range want {
+		got := next(t, stream)
+		if got.id != w.id || got.event != w.event || got.data.ID != w.data.ID || got.data.Kind != w.data.Kind ||
+			got.data.Address != w.data.Address || got.data.Prefix != w.data.Prefix {
+			t.Errorf("event %+v, want %+v", got, w)
+		}
+		if w.data.Kind == watch.Update && (got.data.Rate == nil || got.data.Rate.Total() != 0.05) {
+			t.Errorf("update event with rate %v, want 0.05", got.data.Rate)
+		}
+	}
+
+	// reconnecting after the first event replays the others
+	resumed := openWatch(t, ts, hub, "1")
+	for _, w := range want[1:] {
+		if got := next(t, resumed); got.id != w.id || got.event != w.event {
+			t.Errorf("replayed event %s %s, want %s %s", got.id, got.event, w.id, w.event)
+		}
+	}
+
+	var e ErrorResponse
+	if code := do(t, ts, "GET", "/watch?after=last", "", &e); code != http.StatusBadRequest {
+		t.Errorf("GET /watch?after=last = %d, want 400", code)
+	}
+}
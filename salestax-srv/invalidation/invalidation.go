@@ -0,0 +1,121 @@
+// Package invalidation broadcasts cache invalidations to every salestax-srv
+// instance of a fleet. Each instance holds its own copy of the rates it
+// served, which a rate change published to one instance, or through the
+// shared second tier, leaves stale on the others; a Cluster publishes the
+// invalidation on a Bus, e.g. a Redis channel, and evicts the keys of the
+// events published by the other instances from its own caches.
+package invalidation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Event is an invalidation: the keys starting with Prefix, those of rates
+// with a component of the jurisdiction of Level and Code (see
+// salestax.RateCache.InvalidateByJurisdiction), or Keys. An event of Keys
+// is for a write that already updated the shared tiers, so only local
+// copies are evicted; the others are removed from the shared tiers too.
+type Event struct {
+	Origin string   `json:"origin"` // the ID of the publishing Cluster
+	Prefix string   `json:"prefix,omitempty"`
+	Level  string   `json:"level,omitempty"`
+	Code   string   `json:"code,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+}
+
+// Bus carries the messages of a Cluster between instances.
+type Bus interface {
+	// Publish sends msg to every subscriber.
+	Publish(ctx context.Context, msg []byte) error
+	// Subscribe calls handle with every message published, in order,
+	// until ctx is done or the subscription fails. The messages of the
+	// subscriber itself may be included.
+	Subscribe(ctx context.Context, handle func(msg []byte)) error
+}
+
+// Target is a cache invalidated by a Cluster. salestax.RateCache and
+// salestax.HistoryCache are Targets.
+type Target interface {
+	InvalidateByPrefix(prefix string) int
+	InvalidateByJurisdiction(level, code string) int
+	Evict(key string) bool
+}
+
+// Cluster invalidates its targets together with those of the other
+// instances subscribed to its Bus.
+type Cluster struct {
+	bus     Bus
+	id      string
+	targets []Target
+	notify  []func(Event)
+}
+
+// New returns a Cluster invalidating targets, its local caches, through
+// bus.
+func New(bus Bus, targets ...Target) *Cluster {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Cluster{bus: bus, id: hex.EncodeToString(id), targets: targets}
+}
+
+// ID returns the random ID that identifies the events of c.
+func (c *Cluster) ID() string {
+	return c.id
+}
+
+// Notify registers fn to be called with every event of another instance
+// that Run applies, e.g. to pass it on to watchers of the rates. It must be
+// called before Run.
+func (c *Cluster) Notify(fn func(Event)) {
+	c.notify = append(c.notify, fn)
+}
+
+// Invalidate applies e to the local caches and publishes it to the other
+// instances. It returns the number of items removed locally, also if
+// publishing failed.
+func (c *Cluster) Invalidate(ctx context.Context, e Event) (int, error) {
+	e.Origin = c.id
+	n := c.apply(e)
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return n, err
+	}
+	return n, c.bus.Publish(ctx, msg)
+}
+
+// Run applies the events published by other instances to the local caches
+// until ctx is done or the subscription fails. Messages that are not events
+// are ignored.
+func (c *Cluster) Run(ctx context.Context) error {
+	return c.bus.Subscribe(ctx, func(msg []byte) {
+		var e Event
+		if err := json.Unmarshal(msg, &e); err != nil || e.Origin == c.id {
+			return
+		}
+		c.apply(e)
+		for _, fn := range c.notify {
+			fn(e)
+		}
+	})
+}
+
+func (c *Cluster) apply(e Event) int {
+	n := 0
+	for _, t := range c.targets {
+		switch {
+		case e.Prefix != "":
+			n += t.InvalidateByPrefix(e.Prefix)
+		case e.Code != "":
+			n += t.InvalidateByJurisdiction(e.Level, e.Code)
+		}
+		for _, key := range e.Keys {
+			if t.Evict(key) {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,99 @@
+package invalidation
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// bus delivers every message to all subscribers, like a Redis channel.
+type bus struct {
+	mu   sync.Mutex
+	subs []chan []byte
+}
+
+func (b *bus) Publish(_ context.Context, msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		ch <- msg
+	}
+	return nil
+}
+
+func (b *bus) Subscribe(ctx context.Context, handle func([]byte)) error {
+	ch := make(chan []byte, 10)
+	b.mu.Lock()
+	b.subs = append(b.subs, ch)
+	b.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-ch:
+			handle(msg)
+		}
+	}
+}
+
+func (b *bus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func TestCluster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &bus{}
+	tx := salestax.TaxRate{Components: []salestax.Component{{Level: salestax.State, Code: "48", Rate: 0.0625}}}
+	caches := make([]*salestax.RateCache, 3)
+	clusters := make([]*Cluster, 3)
+	notified := make(chan Event, 10)
+	for i := range caches {
+		caches[i] = salestax.NewRateCache(10)
+		caches[i].Insert("TX:1 Congress Ave", tx)
+		caches[i].Insert("TX:2 Congress Ave", tx)
+		caches[i].Insert("CA:1 Main St", salestax.Flat(0.0725))
+		clusters[i] = New(b, caches[i])
+		if i == 2 {
+			clusters[i].Notify(func(e Event) { notified <- e })
+		}
+		go clusters[i].Run(ctx)
+	}
+	for b.subscribers() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if clusters[0].ID() == clusters[1].ID() {
+		t.Fatal("clusters share an ID")
+	}
+
+	eventually := func(what string, cond func(c *salestax.RateCache) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for _, c := range caches {
+			for !cond(c) {
+				if time.Now().After(deadline) {
+					t.Fatalf("%s not invalidated on every instance", what)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	if n, err := clusters[0].Invalidate(ctx, Event{Keys: []string{"CA:1 Main St"}}); n != 1 || err != nil {
+		t.Errorf("Invalidate(keys) = %d, %v, want 1", n, err)
+	}
+	eventually("key", func(c *salestax.RateCache) bool { return !c.Contains("CA:1 Main St") })
+	if e := <-notified; len(e.Keys) != 1 || e.Keys[0] != "CA:1 Main St" || e.Origin != clusters[0].ID() {
+		t.Errorf("notified of %+v, want the keys event of the first instance", e)
+	}
+
+	if n, err := clusters[1].Invalidate(ctx, Event{Level: salestax.State, Code: "48"}); n != 2 || err != nil {
+		t.Errorf("Invalidate(jurisdiction) = %d, %v, want 2", n, err)
+	}
+	eventually("jurisdiction", func(c *salestax.RateCache) bool { return c.Len() == 0 })
+}
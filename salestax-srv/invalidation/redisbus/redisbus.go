@@ -0,0 +1,51 @@
+// Package redisbus implements invalidation.Bus on a Redis pub/sub channel.
+package redisbus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/redis/go-redis/v9"
+)
+
+// Bus publishes messages on a Redis channel.
+type Bus struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+var _ invalidation.Bus = (*Bus)(nil)
+
+// New returns a Bus on channel of client.
+func New(client redis.UniversalClient, channel string) *Bus {
+	return &Bus{client: client, channel: channel}
+}
+
+func (b *Bus) Publish(ctx context.Context, msg []byte) error {
+	return b.client.Publish(ctx, b.channel, msg).Err()
+}
+
+// Subscribe receives the messages of the channel. Redis does not queue
+// messages for subscribers, so those published while the connection is
+// being re-established are lost.
+func (b *Bus) Subscribe(ctx context.Context, handle func(msg []byte)) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+	// wait for the confirmation so that a bad address fails now
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return errors.New("redis subscription closed")
+			}
+			handle([]byte(msg.Payload))
+		}
+	}
+}
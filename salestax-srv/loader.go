@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/config"
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource/avalara"
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource/canada"
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource/csvtable"
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource/euvat"
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource/taxjar"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/tracing"
+)
+
+// registerLoaderFlags binds the loader flags to cfg, using its current values
+// as defaults.
+func registerLoaderFlags(fs *flag.FlagSet, cfg *config.Loader) {
+	fs.StringVar(&cfg.Backend, "loader", cfg.Backend, "loader backend: avalara, canada (GST/HST/PST), csv (ZIP code rate table), euvat, fake (10ms simulated lookup), http, taxjar or none")
+	fs.StringVar(&cfg.URL, "loader-url", cfg.URL, "base URL of the http backend, queried as <url>/<address> and <url>/<address>/history")
+	fs.BoolVar(&cfg.International, "loader-international", cfg.International, "look Canadian and EU addresses up in the built-in canada and euvat rate tables")
+	fs.StringVar(&cfg.Table, "loader-table", cfg.Table, "CSV file of rates by ZIP code for the csv backend, e.g. a state DOR table")
+	fs.DurationVar(&cfg.Timeout, "loader-timeout", cfg.Timeout, "timeout of a single loader call, after which it is abandoned")
+	fs.IntVar(&cfg.Concurrency, "loader-concurrency", cfg.Concurrency, "maximum loader calls in flight (0 for no limit)")
+	fs.Float64Var(&cfg.Rate, "loader-rate", cfg.Rate, "maximum loader calls per second (0 for no limit)")
+	fs.BoolVar(&cfg.FailFast, "loader-fail-fast", cfg.FailFast, "fail misses over a loader limit instead of queueing them")
+	fs.IntVar(&cfg.Breaker.Failures, "loader-breaker-failures", cfg.Breaker.Failures, "stop calling the loader after this many consecutive errors (0 disables)")
+	fs.DurationVar(&cfg.Breaker.Cooldown, "loader-breaker-cooldown", cfg.Breaker.Cooldown, "how long the loader is not called once the breaker opened")
+	fs.IntVar(&cfg.Retry.Attempts, "loader-attempts", cfg.Retry.Attempts, "loader calls per miss including retries (below 2 disables retries)")
+	fs.DurationVar(&cfg.Retry.Backoff, "loader-retry-backoff", cfg.Retry.Backoff, "wait before the first loader retry, doubled for each further one")
+}
+
+// loaderOptions returns the cache options limiting calls to the loader.
+func loaderOptions(cfg config.Loader) []lrucache.Option {
+	opts := []lrucache.Option{
+		lrucache.WithLoaderTimeout(cfg.Timeout),
+		lrucache.WithLoaderConcurrency(cfg.Concurrency),
+		lrucache.WithLoaderRate(cfg.Rate),
+		lrucache.WithCircuitBreaker(cfg.Breaker.Failures, cfg.Breaker.Cooldown),
+	}
+	if cfg.FailFast {
+		opts = append(opts, lrucache.WithLoaderFailFast())
+	}
+	if cfg.Retry.Attempts > 1 {
+		opts = append(opts, lrucache.WithRetry(lrucache.RetryPolicy{
+			MaxAttempts: cfg.Retry.Attempts,
+			Backoff:     cfg.Retry.Backoff,
+			MaxBackoff:  cfg.Retry.MaxBackoff,
+			Jitter:      cfg.Retry.Jitter,
+			Retryable:   retryable,
+		}))
+	}
+	return opts
+}
+
+// newLoader returns the configured loader. It returns nil for "none", which
+// makes the servers cache-only, unless international lookups are enabled.
+func newLoader(cfg config.Loader) (salestax.RateLoaderFuncCtx, error) {
+	loader, err := backendLoader(cfg)
+	if err != nil || !cfg.International {
+		return loader, err
+	}
+	return international(loader), nil
+}
+
+// backendLoader returns the loader of cfg.Backend.
+func backendLoader(cfg config.Loader) (salestax.RateLoaderFuncCtx, error) {
+	switch cfg.Backend {
+	case "csv":
+		if cfg.Table == "" {
+			return nil, fmt.Errorf("-loader csv requires -loader-table")
+		}
+		table, err := csvtable.Open(cfg.Table)
+		if err != nil {
+			return nil, err
+		}
+		return table.LookupRate, nil
+	case "avalara":
+		client, err := avalara.New(cfg.Avalara.URL, cfg.Avalara.AccountID, cfg.Avalara.LicenseKey, &http.Client{Timeout: cfg.Timeout})
+		if err != nil {
+			return nil, err
+		}
+		return client.LookupRate, nil
+	case "taxjar":
+		client, err := taxjar.New(cfg.TaxJar.URL, cfg.TaxJar.Token, &http.Client{Timeout: cfg.Timeout})
+		if err != nil {
+			return nil, err
+		}
+		return client.LookupRate, nil
+	case "canada":
+		return canada.LookupRate, nil
+	case "euvat":
+		return euvat.LookupRate, nil
+	case "fake":
+		return salestax.RateLoaderFunc(func(key string) (salestax.TaxRate, error) {
+			rate, err := sales_tax_lookup(key)
+			return salestax.Flat(rate), err
+		}).WithContext(), nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("-loader http requires -loader-url")
+		}
+		return httpLoader(strings.TrimSuffix(cfg.URL, "/"), &http.Client{Timeout: cfg.Timeout}), nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown -loader %q, want one of %s", cfg.Backend, strings.Join(config.Backends, ", "))
+}
+
+// international looks Canadian and EU addresses up with the canada and
+// euvat rate sources and all others with loader, which may be nil.
+func international(loader salestax.RateLoaderFuncCtx) salestax.RateLoaderFuncCtx {
+	return func(ctx context.Context, key string) (salestax.TaxRate, error) {
+		if _, ok := canada.Province(key); ok {
+			return canada.LookupRate(ctx, key)
+		}
+		address, _ := ratesource.SplitCategory(key)
+		if euvat.Member(ratesource.ParseAddress(address).Country) {
+			return euvat.LookupRate(ctx, key)
+		}
+		if loader == nil {
+			return salestax.TaxRate{}, salestax.ErrNotFound
+		}
+		return loader(ctx, key)
+	}
+}
+
+// loaderCheck returns a readiness check of the configured backend, or nil if
+// there is nothing to check. TaxJar has no free endpoint to probe, so it is
+// not checked.
+func loaderCheck(cfg config.Loader) func(context.Context) error {
+	switch cfg.Backend {
+	case "http":
+		return httpPing(strings.TrimSuffix(cfg.URL, "/"), &http.Client{Timeout: cfg.Timeout})
+	case "avalara":
+		client, err := avalara.New(cfg.Avalara.URL, cfg.Avalara.AccountID, cfg.Avalara.LicenseKey, &http.Client{Timeout: cfg.Timeout})
+		if err != nil {
+			return nil
+		}
+		return client.Ping
+	}
+	return nil
+}
+
+// httpPing checks that the backend at base answers. Any response short of a
+// server error counts, since the backend need not serve its base URL.
+func httpPing(base string, client *http.Client) func(context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, base+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("rate backend returned %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// httpLoader looks rates up at base/<address>, expecting a JSON body of the
+// form {"rate": 0.0725} as served by the rate service, or with the breakdown
+// into "components", the "effective_from" and "effective_until" times and
+// the "country" and "category" served by another salestax-srv.
+func httpLoader(base string, client *http.Client) salestax.RateLoaderFuncCtx {
+	return func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/"+url.PathEscape(address), nil)
+		if err != nil {
+			return salestax.TaxRate{}, err
+		}
+		tracing.Inject(ctx, req.Header)
+		resp, err := client.Do(req)
+		if err != nil {
+			return salestax.TaxRate{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return salestax.TaxRate{}, &statusError{code: resp.StatusCode, status: resp.Status}
+		}
+		var body httpserver.RateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return salestax.TaxRate{}, err
+		}
+		return body.TaxRate(), nil
+	}
+}
+
+// newHistoryLoader returns the loader of rate histories for as-of lookups,
+// given the configured loader of current rates. Only the http backend keeps
+// histories, when it is another salestax-srv; for the others the history is
+// the current rate. It returns nil if loader is nil.
+func newHistoryLoader(cfg config.Loader, loader salestax.RateLoaderFuncCtx) salestax.HistoryLoaderFuncCtx {
+	switch {
+	case loader == nil:
+		return nil
+	case cfg.Backend == "http" && !cfg.International:
+		return httpHistoryLoader(strings.TrimSuffix(cfg.URL, "/"), &http.Client{Timeout: cfg.Timeout})
+	}
+	return salestax.CurrentHistory(loader)
+}
+
+// httpHistoryLoader looks rate histories up at base/<address>/history,
+// expecting the JSON body served by another salestax-srv.
+func httpHistoryLoader(base string, client *http.Client) salestax.HistoryLoaderFuncCtx {
+	return func(ctx context.Context, address string) (salestax.RateHistory, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/"+url.PathEscape(address)+"/history", nil)
+		if err != nil {
+			return nil, err
+		}
+		tracing.Inject(ctx, req.Header)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+		}
+		var body httpserver.HistoryResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		history := make(salestax.RateHistory, len(body.Records))
+		for i, r := range body.Records {
+			history[i] = r.TaxRate()
+		}
+		return history, nil
+	}
+}
+
+// statusError is a non 200 response of the http backend.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "rate backend returned " + e.status
+}
+
+// retryable retries all loader errors except client errors of the http
+// backend, e.g. 404 for an unknown address, addresses missing from a rate
+// table and the unknown addresses and rejected credentials of the rate
+// APIs, which would only fail again.
+func retryable(err error) bool {
+	if errors.Is(err, salestax.ErrNotFound) || errors.Is(err, ratesource.ErrUnauthorized) {
+		return false
+	}
+	var ae *ratesource.APIError
+	if errors.As(err, &ae) {
+		return ae.Temporary()
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= http.StatusInternalServerError || se.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// Fake slow lookup routine. The street addresses are stringify'd random numbers
+// from [0, CACHE*2]. This routine sleeps for 10ms before returning.
+func sales_tax_lookup(key string) (float64, error) {
+	val, _ := strconv.ParseInt(key, 10, 64)
+	fval := float64(val) * 1.238712
+	time.Sleep(10 * time.Millisecond)
+	return fval, nil
+}
@@ -0,0 +1,94 @@
+package lrucache
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// sketchDepth is the number of rows of the count-min sketch.
+	sketchDepth = 4
+	// sketchMax caps the counters as in TinyLFU, so that a formerly hot key
+	// needs few resets to look cold again.
+	sketchMax = 15
+	// sketchSample is the number of requests per key of capacity after which
+	// all counters are halved.
+	sketchSample = 10
+)
+
+// WithTinyLFU adds a TinyLFU admission filter in front of the eviction
+// policy. Every lookup is recorded in a small frequency sketch, and when a
+// shard is full a newly loaded key only replaces the policy's victim if it
+// was requested more often recently; otherwise the loaded value is returned
+// to the caller without being cached. This keeps one-off lookups from
+// flushing popular keys under skewed traffic. Values stored with Insert are
+// always admitted. Rejected loads are counted in Stats.Rejections.
+func WithTinyLFU() Option {
+	return func(o *options) {
+		o.tinyLFU = true
+	}
+}
+
+// sketch is a count-min sketch estimating how often each key was requested
+// recently. Counters are halved every sample requests so that the estimates
+// follow shifts in popularity. It only uses atomics, so requests can be
+// recorded under the segment read lock; an increment racing with a reset may
+// be lost, which merely lowers an estimate.
+type sketch[K comparable] struct {
+	hash     hasher[K]
+	counters []atomic.Uint32 // sketchDepth rows of mask+1 counters
+	mask     uint64
+	sample   uint64
+	requests atomic.Uint64
+}
+
+// newSketch returns a sketch sized for a segment holding capacity keys.
+func newSketch[K comparable](capacity int, hash hasher[K]) *sketch[K] {
+	width := 16
+	for width < capacity && width < 1<<20 {
+		width *= 2
+	}
+	return &sketch[K]{
+		hash:     hash,
+		counters: make([]atomic.Uint32, sketchDepth*width),
+		mask:     uint64(width - 1),
+		sample:   uint64(sketchSample * max(capacity, 1)),
+	}
+}
+
+// counter returns the counter of row for a key hashing to h. Each row uses a
+// different hash derived from the two halves of h (double hashing).
+func (s *sketch[K]) counter(h uint64, row int) *atomic.Uint32 {
+	lo, hi := h&0xffffffff, h>>32|1
+	return &s.counters[uint64(row)*(s.mask+1)+(lo+uint64(row)*hi)&s.mask]
+}
+
+// increment records a request for key.
+func (s *sketch[K]) increment(key K) {
+	h := s.hash.hash(key)
+	for row := range sketchDepth {
+		if c := s.counter(h, row); c.Load() < sketchMax {
+			c.Add(1)
+		}
+	}
+	if s.requests.Add(1)%s.sample == 0 {
+		s.reset()
+	}
+}
+
+// estimate returns the approximate number of recent requests for key.
+func (s *sketch[K]) estimate(key K) uint32 {
+	h := s.hash.hash(key)
+	n := uint32(sketchMax)
+	for row := range sketchDepth {
+		n = min(n, s.counter(h, row).Load())
+	}
+	return n
+}
+
+// reset halves every counter.
+func (s *sketch[K]) reset() {
+	for i := range s.counters {
+		c := &s.counters[i]
+		c.Store(c.Load() / 2)
+	}
+}
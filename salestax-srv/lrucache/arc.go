@@ -0,0 +1,138 @@
+package lrucache
+
+// arcPolicy implements the Adaptive Replacement Cache policy (Megiddo &
+// Modha). Resident keys live in t1 (seen once recently) or t2 (seen at least
+// twice); b1 and b2 remember keys recently evicted from each. A hit in a
+// ghost list shifts the target size p of t1 towards the list that would have
+// kept the key, so the policy adapts between recency and frequency.
+//
+// Unlike the paper, the victim is chosen before the incoming key is known, so
+// a ghost hit adjusts p for the following eviction rather than for the one
+// that made room for it.
+type arcPolicy[K comparable] struct {
+	c, p           int
+	slab           slab[K]
+	t1, t2, b1, b2 nodeList
+	where          map[K]int
+}
+
+// NewARCPolicy returns an adaptive replacement policy for a shard holding up
+// to capacity keys. It tracks up to 2*capacity keys including the ghosts.
+func NewARCPolicy[K comparable](capacity int) EvictionPolicy[K] {
+	p := &arcPolicy[K]{
+		c:     capacity,
+		slab:  newSlab[K](2*capacity + 4),
+		where: make(map[K]int, preallocHint(2*capacity)),
+	}
+	p.t1 = p.slab.newList()
+	p.t2 = p.slab.newList()
+	p.b1 = p.slab.newList()
+	p.b2 = p.slab.newList()
+	return p
+}
+
+// list returns the list node i is on.
+func (p *arcPolicy[K]) list(i int) *nodeList {
+	switch {
+	case p.slab.on(&p.t1, i):
+		return &p.t1
+	case p.slab.on(&p.t2, i):
+		return &p.t2
+	case p.slab.on(&p.b1, i):
+		return &p.b1
+	}
+	return &p.b2
+}
+
+func (p *arcPolicy[K]) move(i int, to *nodeList) {
+	p.slab.moveToFront(p.list(i), to, i)
+}
+
+func (p *arcPolicy[K]) Add(key K) {
+	i, ok := p.where[key]
+	switch {
+	case !ok:
+		p.where[key] = p.slab.pushFront(&p.t1, key)
+	case p.slab.on(&p.b1, i):
+		p.p = min(p.c, p.p+max(1, p.b2.len/p.b1.len))
+		p.move(i, &p.t2)
+	case p.slab.on(&p.b2, i):
+		p.p = max(0, p.p-max(1, p.b1.len/p.b2.len))
+		p.move(i, &p.t2)
+	default:
+		p.move(i, &p.t2)
+	}
+	p.trimGhosts()
+}
+
+func (p *arcPolicy[K]) Access(key K) {
+	if i, ok := p.where[key]; ok && (p.slab.on(&p.t1, i) || p.slab.on(&p.t2, i)) {
+		p.move(i, &p.t2)
+	}
+}
+
+func (p *arcPolicy[K]) Remove(key K) {
+	if i, ok := p.where[key]; ok {
+		p.slab.remove(p.list(i), i)
+		delete(p.where, key)
+	}
+}
+
+func (p *arcPolicy[K]) Evict(key K) {
+	i, ok := p.where[key]
+	if !ok {
+		return
+	}
+	switch {
+	case p.slab.on(&p.t1, i):
+		p.move(i, &p.b1)
+	case p.slab.on(&p.t2, i):
+		p.move(i, &p.b2)
+	}
+	p.trimGhosts()
+}
+
+func (p *arcPolicy[K]) Victim() (K, bool) {
+	var i int
+	var ok bool
+	if p.t1.len > 0 && (p.t1.len > p.p || p.t2.len == 0) {
+		i, ok = p.slab.back(&p.t1)
+	} else {
+		i, ok = p.slab.back(&p.t2)
+	}
+	if !ok {
+		var zero K
+		return zero, false
+	}
+	return p.slab.key(i), true
+}
+
+func (p *arcPolicy[K]) Resize(capacity int) {
+	p.c = capacity
+	p.p = min(p.p, capacity)
+	p.trimGhosts()
+}
+
+// Keys lists t2 before t1. Which of the two tails is evicted next depends on
+// p, so the order is only approximate.
+func (p *arcPolicy[K]) Keys() []K {
+	keys := make([]K, 0, p.t1.len+p.t2.len)
+	keys = p.slab.appendKeys(keys, &p.t2)
+	return p.slab.appendKeys(keys, &p.t1)
+}
+
+// trimGhosts keeps |t1|+|b1| <= c and the total directory size <= 2c.
+func (p *arcPolicy[K]) trimGhosts() {
+	for p.t1.len+p.b1.len > p.c && p.b1.len > 0 {
+		p.dropGhost(&p.b1)
+	}
+	for p.t1.len+p.t2.len+p.b1.len+p.b2.len > 2*p.c && p.b2.len > 0 {
+		p.dropGhost(&p.b2)
+	}
+}
+
+func (p *arcPolicy[K]) dropGhost(l *nodeList) {
+	i, _ := p.slab.back(l)
+	delete(p.where, p.slab.key(i))
+	p.slab.remove(l, i)
+}
@@ -0,0 +1,239 @@
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// FastRateLookupMulti is the batch form of GetOrLoad. It checks the cache
+// for every key, passes all misses to a single loader call and inserts the
+// loaded values with one lock acquisition per shard, so with a single shard
+// other readers either see none or all of the batch.
+//
+// The returned map holds an entry for every key that was cached or loaded.
+// Keys the loader did not return, or that are negatively cached, are absent.
+// If the loader fails, the cached values are returned along with the error.
+// Unlike GetOrLoad, batches are not coalesced with concurrent loads. A
+// batch is one loader call for WithLoaderConcurrency, WithLoaderRate and
+// WithCircuitBreaker: while the breaker is open its misses fail with
+// ErrBackendUnavailable. WithLoaderTimeout bounds the loader call like
+// those of GetOrLoad.
+func (c *LRUCache[K, V]) FastRateLookupMulti(keys []K, loader BatchLoaderFunc[K, V]) (map[K]V, error) {
+	var lctx BatchLoaderFuncCtx[K, V]
+	if loader != nil {
+		lctx = func(_ context.Context, keys []K) (map[K]V, error) {
+			return loader(keys)
+		}
+	}
+	return c.FastRateLookupMultiCtx(context.Background(), keys, lctx)
+}
+
+// FastRateLookupMultiCtx is FastRateLookupMulti with a context that is passed
+// to the loader.
+func (c *LRUCache[K, V]) FastRateLookupMultiCtx(ctx context.Context, keys []K, loader BatchLoaderFuncCtx[K, V]) (map[K]V, error) {
+	if c.norm == nil && c.canon == nil {
+		return c.lookupMulti(ctx, keys, loader)
+	}
+	ckeys, from := c.cacheKeys(ctx, keys)
+	found, err := c.lookupMulti(ctx, ckeys, batchLoaderFor(from, loader))
+	// return the values under the keys the caller asked for
+	values := make(map[K]V, len(found))
+	for i, key := range keys {
+		if value, ok := found[ckeys[i]]; ok {
+			values[key] = value
+		}
+	}
+	return values, err
+}
+
+// lookupMulti implements FastRateLookupMultiCtx for cache keys.
+func (c *LRUCache[K, V]) lookupMulti(ctx context.Context, keys []K, loader BatchLoaderFuncCtx[K, V]) (map[K]V, error) {
+	values := make(map[K]V, len(keys))
+	var misses []K
+	missed := make(map[K]bool)
+	for _, key := range keys {
+		if _, done := values[key]; done || missed[key] {
+			continue
+		}
+		if value, _, err := c.shard(key).value(key); err == nil {
+			values[key] = value
+		} else if c.shard(key).negativeLookup(key) == nil {
+			misses = append(misses, key)
+			missed[key] = true
+		}
+	}
+	if len(misses) == 0 {
+		return values, nil
+	}
+	if loader == nil {
+		return values, ErrNotFound
+	}
+
+	ok, trial := c.breaker.allow(c.clock.Now())
+	if !ok {
+		c.stats.unavailable.Add(1)
+		return values, ErrBackendUnavailable
+	}
+	release, err := c.limit.acquire(ctx)
+	if err != nil {
+		c.breakerDone(trial, loadAbandoned)
+		c.stats.throttled.Add(1)
+		return values, err
+	}
+	start := c.clock.Now()
+	loaded, err := c.callBatch(ctx, misses, loader)
+	release()
+	c.stats.load(start, err)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			c.breakerDone(trial, loadAbandoned)
+			return values, ctxErr
+		}
+		c.breakerDone(trial, loadFailed)
+		return values, fmt.Errorf("%w: %w", ErrLoaderFailed, err)
+	}
+	c.breakerDone(trial, loadSucceeded)
+
+	found := misses[:0]
+	for _, key := range misses {
+		if _, ok := loaded[key]; ok {
+			found = append(found, key)
+		}
+	}
+	expires := c.expiry(c.TTL())
+	for s, keys := range c.byShard(found) {
+		s.mutex.Lock()
+		for _, key := range keys {
+			// a value rejected by TinyLFU or too heavy to cache is still
+			// returned to the caller
+			_ = s.admitLocked(key, loaded[key], expires)
+			values[key] = loaded[key]
+		}
+		s.unlock()
+	}
+
+	return values, nil
+}
+
+// callBatch is callOnce for a batch loader: it abandons the call after the
+// WithLoaderTimeout deadline even if the loader ignores its context.
+func (c *LRUCache[K, V]) callBatch(ctx context.Context, keys []K, loader BatchLoaderFuncCtx[K, V]) (map[K]V, error) {
+	if c.timeout <= 0 {
+		return safeBatchLoad(ctx, keys, loader)
+	}
+	tctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type result struct {
+		values map[K]V
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		values, err := safeBatchLoad(tctx, keys, loader)
+		done <- result{values, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-tctx.Done():
+		r.err = tctx.Err()
+	}
+	if r.err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		c.stats.timeouts.Add(1)
+		return nil, ErrLoaderTimeout
+	}
+	return r.values, r.err
+}
+
+// safeBatchLoad is safeLoad for a batch loader.
+func safeBatchLoad[K comparable, V any](ctx context.Context, keys []K, loader BatchLoaderFuncCtx[K, V]) (values map[K]V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			values, err = nil, fmt.Errorf("loader panicked: %v", r)
+		}
+	}()
+	return loader(ctx, keys)
+}
+
+// InsertBatch is the batch form of Insert, e.g. for a nightly rate table
+// sync. Each shard is locked once for all of its keys, so with a single
+// shard other readers either see none or all of the batch. With a
+// write-through store the values are persisted first, in one SetBatch call
+// if the store is a BatchStore; if that fails the error is returned and the
+// cache is left unchanged, though a store without SetBatch may have kept
+// the values written before the failing one. Values too heavy for their
+// shard are skipped and reported by the returned error; the others are
+// inserted.
+func (c *LRUCache[K, V]) InsertBatch(values map[K]V) error {
+	if c.norm != nil || c.canon != nil {
+		byCacheKey := make(map[K]V, len(values))
+		for key, value := range values {
+			byCacheKey[c.cacheKey(context.Background(), key)] = value
+		}
+		values = byCacheKey
+	}
+	if c.store != nil {
+		if err := c.storeSetBatch(values); err != nil {
+			return err
+		}
+	}
+	keys := make([]K, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	var firstErr error
+	expires := c.expiry(c.TTL())
+	for s, keys := range c.byShard(keys) {
+		s.mutex.Lock()
+		for _, key := range keys {
+			if err := s.insertLocked(key, values[key], expires); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("%v: %w", key, err)
+			}
+		}
+		s.unlock()
+	}
+	for key, value := range values {
+		if c.behind != nil {
+			c.storeSet(key, value)
+		}
+		if c.l2 != nil {
+			c.l2Set(context.Background(), key, value, c.TTL())
+		}
+	}
+	return firstErr
+}
+
+// DeleteBatch is the batch form of Delete. Each shard is locked once for all
+// of its keys. It returns the number of keys that were present in the cache.
+func (c *LRUCache[K, V]) DeleteBatch(keys []K) int {
+	keys, _ = c.cacheKeys(context.Background(), keys)
+	for _, key := range keys {
+		c.storeDelete(key)
+		if c.l2 != nil {
+			c.l2Delete(key)
+		}
+	}
+	n := 0
+	for s, keys := range c.byShard(keys) {
+		s.mutex.Lock()
+		for _, key := range keys {
+			if s.deleteLocked(key) {
+				n++
+			}
+		}
+		s.unlock()
+	}
+	return n
+}
+
+// byShard groups keys by the shard they belong to.
+func (c *LRUCache[K, V]) byShard(keys []K) map[*segment[K, V]][]K {
+	groups := make(map[*segment[K, V]][]K, len(c.shards))
+	for _, key := range keys {
+		s := c.shard(key)
+		groups[s] = append(groups[s], key)
+	}
+	return groups
+}
@@ -0,0 +1,136 @@
+package lrucache
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const benchSize = 50000
+
+func benchKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}
+
+func benchmarkGetParallel(b *testing.B, opts ...Option) {
+	c := New[string, float64](benchSize, opts...)
+	keys := benchKeys(benchSize)
+	for i, k := range keys {
+		c.Insert(k, float64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkGetParallelExact(b *testing.B) {
+	benchmarkGetParallel(b)
+}
+
+func BenchmarkGetParallelApproximate(b *testing.B) {
+	benchmarkGetParallel(b, WithApproximateLRU())
+}
+
+// benchmarkInsertEvict measures the steady state of a full cache: every
+// Insert of a new key evicts the least recently used one.
+func benchmarkInsertEvict(b *testing.B, opts ...Option) {
+	c := New[string, float64](benchSize, opts...)
+	keys := benchKeys(4 * benchSize)
+	for _, k := range keys[:benchSize] {
+		c.Insert(k, 1)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		c.Insert(keys[i%len(keys)], 1)
+	}
+}
+
+func BenchmarkInsertEvictLRU(b *testing.B) {
+	benchmarkInsertEvict(b)
+}
+
+func BenchmarkInsertEvictSLRU(b *testing.B) {
+	benchmarkInsertEvict(b, WithPolicy(NewSLRUPolicy[string]))
+}
+
+func BenchmarkInsertEvictARC(b *testing.B) {
+	benchmarkInsertEvict(b, WithPolicy(NewARCPolicy[string]))
+}
+
+func BenchmarkInsertEvictLFU(b *testing.B) {
+	benchmarkInsertEvict(b, WithPolicy(NewLFUPolicy[string]))
+}
+
+// BenchmarkGetHit measures a hit moving a key to the front of the LRU list.
+func BenchmarkGetHit(b *testing.B) {
+	c := New[string, float64](benchSize)
+	keys := benchKeys(benchSize)
+	for _, k := range keys {
+		c.Insert(k, 1)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+// benchmarkKeyMemory reports the heap held per address by a rate cache and
+// a history cache both holding it, the addresses sliced out of requests as
+// by the HTTP server.
+func benchmarkKeyMemory(b *testing.B, in *Interner[string]) {
+	const n = 100000
+	padding := strings.Repeat("x", 200) // the rest of the request
+	var held uint64
+	for range b.N {
+		keys := make([]string, n)
+		for i := range keys {
+			request := fmt.Sprintf("GET /rate/%d Main Street, Springfield, IL 62701 HTTP/1.1\r\n%s", i, padding)
+			keys[i] = request[len("GET /rate/"):strings.Index(request, " HTTP/")]
+		}
+		before := heapInUse()
+		var opts []Option
+		if in != nil {
+			opts = append(opts, WithKeyInterner(in))
+		}
+		rates := New[string, float64](n, opts...)
+		histories := New[string, float64](n, opts...)
+		for i, k := range keys {
+			rates.Insert(k, float64(i))
+			histories.Insert(strings.Clone(k), float64(i))
+		}
+		keys = nil
+		held += heapInUse() - before
+		runtime.KeepAlive(rates)
+		runtime.KeepAlive(histories)
+	}
+	b.ReportMetric(float64(held)/float64(b.N)/n, "B/address")
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func BenchmarkKeyMemoryPlain(b *testing.B) {
+	benchmarkKeyMemory(b, nil)
+}
+
+func BenchmarkKeyMemoryInterned(b *testing.B) {
+	benchmarkKeyMemory(b, NewInterner[string]())
+}
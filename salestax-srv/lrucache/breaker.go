@@ -0,0 +1,129 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// breakerState is the state of a breaker.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // loader calls go ahead
+	breakerOpen                         // loader calls fail with ErrBackendUnavailable
+	breakerHalfOpen                     // one trial call decides between closed and open
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// outcome is the result of a loader call reported to a breaker.
+type outcome int
+
+const (
+	loadSucceeded outcome = iota
+	loadFailed
+	loadAbandoned // the caller gave up, which says nothing about the backend
+)
+
+// breaker is a circuit breaker around the loader. It opens after threshold
+// consecutive failures, rejects calls for cooldown and then lets a single
+// trial call through: if that succeeds the breaker closes again, otherwise it
+// stays open for another cooldown. A nil *breaker allows everything.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // consecutive failures while closed
+	opened   time.Time // when the breaker last opened
+	trial    bool      // a half-open trial call is in flight
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a loader call may go ahead and, if so, whether it is
+// the half-open trial. Every allowed call must be reported to done.
+func (b *breaker) allow(now time.Time) (ok, trial bool) {
+	if b == nil {
+		return true, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.opened) < b.cooldown {
+			return false, false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.trial {
+			return false, false
+		}
+		b.trial = true
+		return true, true
+	}
+	return true, false
+}
+
+// done records the outcome of a call allowed by allow and returns the new
+// state if it changed.
+func (b *breaker) done(now time.Time, trial bool, o outcome) (breakerState, bool) {
+	if b == nil || o == loadAbandoned && !trial {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if trial {
+		b.trial = false
+		switch o {
+		case loadSucceeded:
+			b.state, b.failures = breakerClosed, 0
+			return b.state, true
+		case loadFailed:
+			b.state, b.opened = breakerOpen, now
+			return b.state, true
+		}
+		return 0, false
+	}
+	// calls that started before the breaker opened don't affect it
+	if b.state != breakerClosed {
+		return 0, false
+	}
+	if o == loadSucceeded {
+		b.failures = 0
+		return 0, false
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return 0, false
+	}
+	b.state, b.opened = breakerOpen, now
+	return b.state, true
+}
+
+// breakerDone reports the outcome of a loader call to the circuit breaker and
+// logs state changes, which are worth a warning: while the breaker is open
+// every miss fails.
+func (c *LRUCache[K, V]) breakerDone(trial bool, o outcome) {
+	state, changed := c.breaker.done(c.clock.Now(), trial, o)
+	if changed && c.logger != nil {
+		c.logger.Warn("lrucache: circuit breaker "+state.String(), "cooldown", c.breaker.cooldown)
+	}
+}
@@ -0,0 +1,105 @@
+package lrucache
+
+import "context"
+
+// KeyCanonicalizer maps the keys that share a value to one cache key, e.g.
+// every street address of a tax jurisdiction to its FIPS code or to a
+// lat/lon grid cell, so that they share one cache entry. It is called on
+// every cache operation with a key, so it should be fast: a local table, or
+// a geocoder behind a cache of its own.
+type KeyCanonicalizer[K comparable] interface {
+	Canonicalize(ctx context.Context, key K) (K, error)
+}
+
+// KeyCanonicalizerFunc is a function implementing KeyCanonicalizer.
+type KeyCanonicalizerFunc[K comparable] func(ctx context.Context, key K) (K, error)
+
+// Canonicalize calls f(ctx, key).
+func (f KeyCanonicalizerFunc[K]) Canonicalize(ctx context.Context, key K) (K, error) {
+	return f(ctx, key)
+}
+
+// WithKeyCanonicalizer caches values under the key returned by canon
+// instead of the key passed in, after WithKeyNormalizer if both are given.
+// The loader is still called with the key it was looked up by, whichever
+// key of the group misses first, while the store, the second tier, Keys,
+// Range and the eviction callback see the canonical key. FastRateLookupMulti
+// returns the caller's keys. If canon fails the key itself is used and the
+// failure is counted in Stats.CanonErrors. Snapshots hold canonical
+// keys, so LoadSnapshot does not canonicalize again. K must match the cache
+// being constructed, otherwise New panics.
+func WithKeyCanonicalizer[K comparable](canon KeyCanonicalizer[K]) Option {
+	return func(o *options) {
+		o.canonicalizer = canon
+	}
+}
+
+// cacheKey returns the key under which key is cached.
+func (c *LRUCache[K, V]) cacheKey(ctx context.Context, key K) K {
+	return c.canonical(ctx, c.normalize(key))
+}
+
+// canonical returns the canonical form of the normalized key, or key itself
+// without a canonicalizer or if it fails.
+func (c *LRUCache[K, V]) canonical(ctx context.Context, key K) K {
+	if c.canon == nil {
+		return key
+	}
+	ck, err := c.canon.Canonicalize(ctx, key)
+	if err != nil {
+		c.stats.canonErrors.Add(1)
+		return key
+	}
+	return ck
+}
+
+// cacheKeys returns the keys under which keys are cached, and for each of
+// them the first key it was derived from, normalized. keys is not modified.
+func (c *LRUCache[K, V]) cacheKeys(ctx context.Context, keys []K) (ckeys []K, from map[K]K) {
+	if c.norm == nil && c.canon == nil {
+		return keys, nil
+	}
+	ckeys = make([]K, len(keys))
+	from = make(map[K]K, len(keys))
+	for i, key := range keys {
+		key = c.normalize(key)
+		ckeys[i] = c.canonical(ctx, key)
+		if _, ok := from[ckeys[i]]; !ok {
+			from[ckeys[i]] = key
+		}
+	}
+	return ckeys, from
+}
+
+// loaderFor returns a loader for cache key ck that calls loader with key,
+// the key the lookup was made with.
+func loaderFor[K comparable, V any](ck, key K, loader LoaderFuncCtx[K, V]) LoaderFuncCtx[K, V] {
+	if ck == key || loader == nil {
+		return loader
+	}
+	return func(ctx context.Context, _ K) (V, error) {
+		return loader(ctx, key)
+	}
+}
+
+// batchLoaderFor returns a batch loader for cache keys that calls loader
+// with the keys they were derived from, as returned by cacheKeys.
+func batchLoaderFor[K comparable, V any](from map[K]K, loader BatchLoaderFuncCtx[K, V]) BatchLoaderFuncCtx[K, V] {
+	if from == nil || loader == nil {
+		return loader
+	}
+	return func(ctx context.Context, ckeys []K) (map[K]V, error) {
+		keys := make([]K, len(ckeys))
+		for i, ck := range ckeys {
+			keys[i] = from[ck]
+		}
+		loaded, err := loader(ctx, keys)
+		values := make(map[K]V, len(loaded))
+		for i, ck := range ckeys {
+			if value, ok := loaded[keys[i]]; ok {
+				values[ck] = value
+			}
+		}
+		return values, err
+	}
+}
@@ -0,0 +1,52 @@
+package lrucache
+
+import "time"
+
+// Clock tells the time to a cache: when items expire and are refreshed
+// ahead, when failures and breakers cool down, how long retries and
+// throttled loads wait, and when the background sweeps and flushes run. It
+// is the system clock unless WithClock is given, e.g. the fake clock of
+// lrucachetest, so that tests of TTLs decide when time passes instead of
+// sleeping.
+type Clock interface {
+	Now() time.Time
+	// After is time.After.
+	After(d time.Duration) <-chan time.Time
+	// NewTimer is time.NewTimer.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock, see time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// WithClock makes the cache tell the time with clock instead of the system
+// clock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// systemClock is the Clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) NewTimer(d time.Duration) Timer         { return systemTimer{time.NewTimer(d)} }
+
+// systemTimer is a Timer of the time package.
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// since returns the time elapsed on clock since t.
+func since(clock Clock, t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
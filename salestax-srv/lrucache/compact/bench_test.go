@@ -0,0 +1,108 @@
+package compact
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+const benchSize = 1 << 20
+
+// benchKeys returns n addresses.
+func benchKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i) + " Main Street, Springfield, IL 62701"
+	}
+	return keys
+}
+
+// benchmarkGC reports how long a collection takes with a full cache of
+// benchSize rates in the heap. The collection runs concurrently with the
+// program but for brief pauses, which are reported as pause-ns/op.
+func benchmarkGC(b *testing.B, fill func(keys []string) any) {
+	cache := fill(benchKeys(benchSize))
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for range b.N {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+	b.ReportMetric(float64(after.HeapAlloc)/benchSize, "B/entry")
+	runtime.KeepAlive(cache)
+}
+
+func BenchmarkGCLRUCache(b *testing.B) {
+	benchmarkGC(b, func(keys []string) any {
+		c := lrucache.New[string, float64](len(keys))
+		for i, k := range keys {
+			c.Insert(k, float64(i))
+		}
+		return c
+	})
+}
+
+func BenchmarkGCCompact(b *testing.B) {
+	benchmarkGC(b, func(keys []string) any {
+		c := New[float64](len(keys))
+		for i, k := range keys {
+			c.Insert(k, float64(i))
+		}
+		return c
+	})
+}
+
+func BenchmarkGCEngine(b *testing.B) {
+	benchmarkGC(b, func(keys []string) any {
+		c := lrucache.New[string, float64](len(keys), lrucache.WithEngine(NewEngine[float64]))
+		for i, k := range keys {
+			c.Insert(k, float64(i))
+		}
+		return c
+	})
+}
+
+func BenchmarkGetLRUCache(b *testing.B) {
+	keys := benchKeys(benchSize)
+	c := lrucache.New[string, float64](len(keys))
+	for i, k := range keys {
+		c.Insert(k, float64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkGetCompact(b *testing.B) {
+	keys := benchKeys(benchSize)
+	c := New[float64](len(keys))
+	for i, k := range keys {
+		c.Insert(k, float64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkLookupEngine(b *testing.B) {
+	keys := benchKeys(benchSize)
+	c := lrucache.New[string, float64](len(keys), lrucache.WithEngine(NewEngine[float64]))
+	for i, k := range keys {
+		c.Insert(k, float64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		c.Lookup(keys[i%len(keys)])
+	}
+}
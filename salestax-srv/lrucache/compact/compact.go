@@ -0,0 +1,216 @@
+// Package compact implements an LRU cache for very large numbers of small
+// values whose entries hold no pointers, so that the garbage collector has
+// nothing to scan in them however many there are.
+//
+// An lrucache.LRUCache allocates an item per entry, referenced from a map
+// and holding the key and the value, and the collector visits every one of
+// them on each cycle: with tens of millions of entries marking takes long
+// enough to show in the latencies. A compact Cache instead preallocates its
+// entries in one slice per shard, links them by index, copies the keys into
+// a byte arena and indexes them by hash in a map of integers. It takes no
+// pointer to the heap per entry and allocates nothing on a hit.
+//
+// The values must be free of pointers themselves: numbers, booleans and
+// arrays and structs of them, such as a rate as a float64; New panics
+// otherwise. A compact Cache only evicts the least recently used entry and
+// expires entries lazily, on the Get that finds them expired. For loaders
+// and most other features of lrucache, build an LRUCache storing its
+// entries the same way with
+//
+//	lrucache.New[string, float64](sz, lrucache.WithEngine(compact.NewEngine[float64]))
+//
+// See lrucache.WithEngine for the options it excludes.
+package compact
+
+import (
+	"fmt"
+	"hash/maphash"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Cache is a concurrent LRU cache of string keys and pointer free values
+// of type V.
+type Cache[V any] struct {
+	shards []*shard[V]
+	seed   maphash.Seed
+	ttl    time.Duration
+	now    func() time.Time
+	stats  counters
+}
+
+// counters are the statistics of a Cache, updated atomically.
+type counters struct {
+	hits, misses, evictions, expirations atomic.Uint64
+}
+
+// Option configures a Cache at construction time.
+type Option func(*options)
+
+type options struct {
+	ttl    time.Duration
+	shards int
+	clock  lrucache.Clock
+}
+
+// WithTTL sets the time to live of the entries inserted by Insert. A zero or
+// negative d disables expiration.
+func WithTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// WithShards partitions the keys across n independently locked shards, each
+// with an equal part of the capacity, like lrucache.WithShards. n is capped
+// at the cache size; the default is 1.
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// WithClock makes the cache tell the time with clock instead of the system
+// clock, e.g. the fake clock of lrucachetest.
+func WithClock(clock lrucache.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// New returns a Cache holding up to sz entries, all of them allocated up
+// front. It panics if sz <= 0 or if V holds pointers.
+func New[V any](sz int, opts ...Option) *Cache[V] {
+	if sz <= 0 {
+		panic("compact: size too small (<=0)")
+	}
+	checkPointers[V]()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	n := min(max(o.shards, 1), sz)
+	c := &Cache[V]{
+		shards: make([]*shard[V], n),
+		seed:   maphash.MakeSeed(),
+		ttl:    o.ttl,
+		now:    time.Now,
+	}
+	if o.clock != nil {
+		c.now = o.clock.Now
+	}
+	for i := range c.shards {
+		size := sz / n
+		if i < sz%n {
+			size++
+		}
+		c.shards[i] = newShard[V](size, &c.stats)
+	}
+	return c
+}
+
+// Get returns the value of key, lrucache.ErrNotFound if it is not cached or
+// lrucache.ErrExpired if its TTL elapsed, in which case it is removed.
+func (c *Cache[V]) Get(key string) (V, error) {
+	h := maphash.String(c.seed, key)
+	return c.shard(h).get(h, key, c.now().UnixNano())
+}
+
+// Insert stores value under key with the TTL of the cache, evicting the
+// least recently used entry if the shard of key is full.
+func (c *Cache[V]) Insert(key string, value V) error {
+	return c.InsertWithTTL(key, value, c.ttl)
+}
+
+// InsertWithTTL is Insert with a TTL of its own, none if ttl <= 0.
+func (c *Cache[V]) InsertWithTTL(key string, value V, ttl time.Duration) error {
+	var expires int64
+	if ttl > 0 {
+		expires = c.now().Add(ttl).UnixNano()
+	}
+	h := maphash.String(c.seed, key)
+	return c.shard(h).insert(h, key, value, expires)
+}
+
+// Delete removes key and reports whether it was cached.
+func (c *Cache[V]) Delete(key string) bool {
+	h := maphash.String(c.seed, key)
+	return c.shard(h).delete(h, key)
+}
+
+// Purge removes every entry.
+func (c *Cache[V]) Purge() {
+	for _, s := range c.shards {
+		s.purge()
+	}
+}
+
+// Len returns the number of entries, including expired ones not removed
+// yet.
+func (c *Cache[V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.len()
+	}
+	return n
+}
+
+// Cap returns the maximum number of entries.
+func (c *Cache[V]) Cap() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.size()
+	}
+	return n
+}
+
+// Stats returns the counters of the cache, those of an LRUCache that apply.
+func (c *Cache[V]) Stats() lrucache.Stats {
+	n := c.Len()
+	return lrucache.Stats{
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		Evictions:   c.stats.evictions.Load(),
+		Expirations: c.stats.expirations.Load(),
+		Size:        n,
+		Weight:      n,
+	}
+}
+
+func (c *Cache[V]) shard(h uint64) *shard[V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[h%uint64(len(c.shards))]
+}
+
+// checkPointers panics if values of type V hold pointers.
+func checkPointers[V any]() {
+	if t := reflect.TypeFor[V](); hasPointers(t) {
+		panic(fmt.Sprintf("compact: %v holds pointers", t))
+	}
+}
+
+// hasPointers reports whether values of t hold pointers.
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Array:
+		return t.Len() > 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
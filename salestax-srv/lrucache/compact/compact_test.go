@@ -0,0 +1,324 @@
+package compact
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache/lrucachetest"
+)
+
+func TestCache(t *testing.T) {
+	c := New[float64](3)
+	for i, k := range []string{"a", "b", "c"} {
+		c.Insert(k, float64(i))
+	}
+	if v, err := c.Get("a"); err != nil || v != 0 {
+		t.Fatalf("Get(a) = %v, %v", v, err)
+	}
+	c.Insert("d", 3) // evicts b, the least recently used
+	if _, err := c.Get("b"); !errors.Is(err, lrucache.ErrNotFound) {
+		t.Errorf("Get(b) error = %v, want ErrNotFound", err)
+	}
+	c.Insert("c", 20)
+	if v, _ := c.Get("c"); v != 20 {
+		t.Errorf("Get(c) = %v after the update, want 20", v)
+	}
+	if !c.Delete("a") || c.Delete("a") {
+		t.Error("Delete(a) did not report it cached once")
+	}
+	if c.Len() != 2 || c.Cap() != 3 {
+		t.Errorf("Len, Cap = %d, %d, want 2, 3", c.Len(), c.Cap())
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 1 || st.Evictions != 1 || st.Size != 2 {
+		t.Errorf("Stats() = %+v", st)
+	}
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after Purge", c.Len())
+	}
+	c.Insert("e", 5)
+	if v, err := c.Get("e"); err != nil || v != 5 {
+		t.Errorf("Get(e) after Purge = %v, %v", v, err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	c := New[int](10, WithTTL(time.Minute), WithClock(clock))
+	c.Insert("a", 1)
+	c.InsertWithTTL("b", 2, time.Hour)
+	c.InsertWithTTL("c", 3, 0)
+	clock.Advance(2 * time.Minute)
+	if _, err := c.Get("a"); !errors.Is(err, lrucache.ErrExpired) {
+		t.Errorf("Get(a) error = %v, want ErrExpired", err)
+	}
+	if _, err := c.Get("a"); !errors.Is(err, lrucache.ErrNotFound) {
+		t.Errorf("Get(a) error once removed = %v, want ErrNotFound", err)
+	}
+	for _, k := range []string{"b", "c"} {
+		if _, err := c.Get(k); err != nil {
+			t.Errorf("Get(%s): %v", k, err)
+		}
+	}
+	if st := c.Stats(); st.Expirations != 1 {
+		t.Errorf("%d expirations, want 1", st.Expirations)
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	s := newShard[int](3, new(counters))
+	// three keys of the same hash share a chain
+	for i, k := range []string{"a", "b", "c"} {
+		s.insert(42, k, i, 0)
+	}
+	s.delete(42, "b")
+	for i, k := range []string{"a", "c"} {
+		if v, err := s.get(42, k, 0); err != nil || v != []int{0, 2}[i] {
+			t.Errorf("get(%s) = %d, %v", k, v, err)
+		}
+	}
+	s.insert(42, "d", 3, 0)
+	s.insert(42, "e", 4, 0) // evicts a
+	if _, err := s.get(42, "a", 0); err == nil {
+		t.Error("a was not evicted")
+	}
+	if len(s.index) != 1 || s.n != 3 {
+		t.Errorf("%d hashes indexed, %d entries, want 1, 3", len(s.index), s.n)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	c := New[int](100)
+	long := strings.Repeat("x", 1000)
+	for i := range 1000 {
+		c.Insert(fmt.Sprint(i, long), i)
+	}
+	s := c.shards[0]
+	if len(s.arena) > 2*(100*1004+minCompaction) {
+		t.Errorf("the arena grew to %d bytes for 100 keys", len(s.arena))
+	}
+	for i := 900; i < 1000; i++ {
+		if v, err := c.Get(fmt.Sprint(i, long)); err != nil || v != i {
+			t.Fatalf("Get(%d) = %d, %v", i, v, err)
+		}
+	}
+}
+
+func TestPointers(t *testing.T) {
+	type rate struct {
+		Rate  float64
+		Parts [4]float32
+	}
+	New[rate](1) // no pointers: fine
+	for name, fn := range map[string]func(){
+		"string": func() { New[string](1) },
+		"slice":  func() { New[[]float64](1) },
+		"struct": func() { New[struct{ Name string }](1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New[%s] did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	c := New[int](100, WithShards(4))
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				k := fmt.Sprint((w * i) % 300)
+				if i%3 == 0 {
+					c.Insert(k, i)
+				} else {
+					c.Get(k)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Len() > 100 {
+		t.Errorf("Len() = %d over the capacity", c.Len())
+	}
+}
+
+func TestEngine(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	var evicted []string
+	c := lrucache.New[string, float64](3,
+		lrucache.WithEngine(NewEngine[float64]),
+		lrucache.WithTTL(time.Minute),
+		lrucache.WithClock(clock),
+		lrucache.WithOnEvict(func(key string, _ float64, reason lrucache.EvictReason) {
+			evicted = append(evicted, fmt.Sprint(key, " ", reason))
+		}))
+	loads := 0
+	loader := func(key string) (float64, error) {
+		loads++
+		return float64(len(key)), nil
+	}
+	for _, k := range []string{"a", "bb", "ccc", "a"} {
+		if v, err := c.GetOrLoad(k, loader); err != nil || v != float64(len(k)) {
+			t.Fatalf("GetOrLoad(%s) = %v, %v", k, v, err)
+		}
+	}
+	if loads != 3 {
+		t.Errorf("%d loads, want a hit on a", loads)
+	}
+	if keys := c.Keys(); !slices.Equal(keys, []string{"a", "ccc", "bb"}) {
+		t.Errorf("Keys() = %q, most recently used first", keys)
+	}
+	c.Insert("dddd", 4) // evicts bb, the least recently used
+	if c.Contains("bb") || c.Len() != 3 {
+		t.Errorf("Contains(bb), Len() = %v, %d after an insert into a full cache", c.Contains("bb"), c.Len())
+	}
+	if item, ok := c.Oldest(); !ok || item.Key() != "ccc" {
+		t.Errorf("Oldest() = %v, %v, want ccc", item, ok)
+	}
+	if !c.Delete("ccc") || c.Delete("ccc") {
+		t.Error("Delete(ccc) did not report it cached once")
+	}
+
+	c.InsertWithTTL("e", 5, time.Hour)
+	clock.Advance(2 * time.Minute)
+	if _, err := c.Get("a"); !errors.Is(err, lrucache.ErrExpired) {
+		t.Errorf("Get(a) error = %v, want ErrExpired", err)
+	}
+	if item, err := c.Peek("e"); err != nil || item.Value() != 5 {
+		t.Errorf("Peek(e) = %v, %v", item, err)
+	}
+
+	c.Resize(1) // keeps e, the most recently used
+	if keys := c.Keys(); !slices.Equal(keys, []string{"e"}) || c.Cap() != 1 {
+		t.Errorf("Keys(), Cap() = %q, %d after Resize(1)", keys, c.Cap())
+	}
+	c.Resize(2)
+	c.Insert("f", 6)
+	if keys := c.Keys(); !slices.Equal(keys, []string{"f", "e"}) {
+		t.Errorf("Keys() = %q after growing", keys)
+	}
+
+	want := []string{"bb capacity", "ccc deleted", "a expired", "dddd capacity"}
+	if !slices.Equal(evicted, want) {
+		t.Errorf("evicted %q, want %q", evicted, want)
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 4 || st.Evictions != 2 || st.Size != 2 {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestEngineVersion(t *testing.T) {
+	c := lrucache.New[string, int](2, lrucache.WithEngine(NewEngine[int]))
+	if err := c.InsertIfVersion("a", 1, 0); err != nil {
+		t.Errorf("InsertIfVersion of an absent key = %v", err)
+	}
+	if _, v, err := c.GetWithVersion("a"); v != 0 || err != nil {
+		t.Errorf("GetWithVersion(a) = %d, %v, want version 0", v, err)
+	}
+	if err := c.InsertIfVersion("a", 2, 0); !errors.Is(err, lrucache.ErrVersionMismatch) {
+		t.Errorf("InsertIfVersion of a cached key = %v, want ErrVersionMismatch", err)
+	}
+}
+
+func TestEngineShards(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	c := lrucache.New[string, int](40,
+		lrucache.WithEngine(NewEngine[int]),
+		lrucache.WithShards(4),
+		lrucache.WithClock(clock),
+		lrucache.WithStaleWhileRevalidate(time.Minute))
+	for i := range 8 {
+		c.InsertWithTTL(fmt.Sprint(i), i, time.Minute)
+	}
+	clock.Advance(90 * time.Second)
+	refreshed := make(chan string, 8)
+	if v, err := c.GetOrLoad("3", func(key string) (int, error) {
+		refreshed <- key
+		return 30, nil
+	}); err != nil || v != 3 {
+		t.Errorf("GetOrLoad of a stale key = %d, %v, want 3 served stale", v, err)
+	}
+	if key := <-refreshed; key != "3" {
+		t.Errorf("refreshed %s, want 3", key)
+	}
+
+	if n := c.DeleteFunc(func(key string) bool { return key < "2" }); n != 2 {
+		t.Errorf("DeleteFunc = %d, want 2", n)
+	}
+	if n := c.RemoveOldest(3); n != 3 {
+		t.Errorf("RemoveOldest(3) = %d", n)
+	}
+	if n := c.Len(); n != 3 {
+		t.Errorf("Len() = %d, want 3", n)
+	}
+	in := c.Inspect(8)
+	tails := 0
+	for _, s := range in.Shards {
+		if len(s.Tail) != s.Len {
+			t.Errorf("shard %+v lists a tail of another length", s)
+		}
+		tails += len(s.Tail)
+	}
+	if tails != 3 {
+		t.Errorf("Inspect lists %d keys, want 3", tails)
+	}
+	if n := c.RemoveOldest(10); n != 3 || c.Len() != 0 {
+		t.Errorf("RemoveOldest(10) = %d leaving %d", n, c.Len())
+	}
+}
+
+func TestEngineSweep(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	c := lrucache.New[string, int](10,
+		lrucache.WithEngine(NewEngine[int]),
+		lrucache.WithClock(clock),
+		lrucache.WithSweepInterval(time.Minute))
+	defer c.Close()
+	c.InsertWithTTL("a", 1, time.Second)
+	c.Insert("b", 2)
+	// the sweeper may not have started its timer yet
+	for deadline := time.Now().Add(5 * time.Second); c.Len() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("the sweeper did not remove the expired item")
+		}
+		clock.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+	if !c.Contains("b") {
+		t.Error("the sweeper removed an item that does not expire")
+	}
+}
+
+func TestEngineOptions(t *testing.T) {
+	engine := lrucache.WithEngine(NewEngine[int])
+	for name, fn := range map[string]func(){
+		"pointers":   func() { lrucache.New[string, string](1, lrucache.WithEngine(NewEngine[string])) },
+		"key type":   func() { lrucache.New[int, int](1, engine) },
+		"value type": func() { lrucache.New[string, float64](1, engine) },
+		"policy":     func() { lrucache.New[string, int](1, engine, lrucache.WithPolicy(lrucache.NewLFUPolicy[string])) },
+		"weigher":    func() { lrucache.New[string, int](1, engine, lrucache.WithWeigher(func(string, int) int { return 1 })) },
+		"approx":     func() { lrucache.New[string, int](1, engine, lrucache.WithApproximateLRU()) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New with %s did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
@@ -0,0 +1,97 @@
+package compact
+
+import (
+	"hash/maphash"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// engine is a shard serving as the lrucache.Engine of a segment of an
+// LRUCache, which locks it and evicts from it: the lock of the shard and
+// its counters are unused.
+type engine[V any] struct {
+	*shard[V]
+	seed maphash.Seed
+}
+
+// NewEngine returns an lrucache.Engine of sz entries, all of them allocated
+// up front, for lrucache.WithEngine(compact.NewEngine[V]). It panics if V
+// holds pointers.
+func NewEngine[V any](sz int) lrucache.Engine[string, V] {
+	checkPointers[V]()
+	return &engine[V]{shard: newShard[V](sz, new(counters)), seed: maphash.MakeSeed()}
+}
+
+func (e *engine[V]) Get(key string, touch bool) (V, time.Time, bool) {
+	i := e.find(maphash.String(e.seed, key), key)
+	if i == none {
+		var zero V
+		return zero, time.Time{}, false
+	}
+	if touch {
+		e.moveToFront(i)
+	}
+	return e.entries[i].value, unixTime(e.entries[i].expires), true
+}
+
+func (e *engine[V]) Set(key string, value V, expires time.Time) error {
+	var ns int64
+	if !expires.IsZero() {
+		ns = expires.UnixNano()
+	}
+	return e.set(maphash.String(e.seed, key), key, value, ns)
+}
+
+func (e *engine[V]) Delete(key string) bool {
+	i := e.find(maphash.String(e.seed, key), key)
+	if i == none {
+		return false
+	}
+	e.remove(i)
+	return true
+}
+
+func (e *engine[V]) Oldest() (string, V, time.Time, bool) {
+	if e.tail == none {
+		var zero V
+		return "", zero, time.Time{}, false
+	}
+	en := &e.entries[e.tail]
+	return e.key(en), en.value, unixTime(en.expires), true
+}
+
+func (e *engine[V]) Range(fn func(key string, value V, expires time.Time) bool) {
+	for i := e.head; i != none; i = e.entries[i].next {
+		en := &e.entries[i]
+		if !fn(e.key(en), en.value, unixTime(en.expires)) {
+			return
+		}
+	}
+}
+
+// Resize moves the entries to a shard of sz entries, the least recently
+// used first so that their order is kept.
+func (e *engine[V]) Resize(sz int) {
+	if sz == len(e.entries) {
+		return
+	}
+	s := newShard[V](sz, e.stats)
+	for i := e.tail; i != none; i = e.entries[i].prev {
+		en := &e.entries[i]
+		s.set(en.hash, e.key(en), en.value, en.expires)
+	}
+	e.shard = s
+}
+
+func (e *engine[V]) Len() int {
+	return e.n
+}
+
+// unixTime is the time of ns Unix nanoseconds, zero for 0.
+func unixTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
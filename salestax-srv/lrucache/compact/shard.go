@@ -0,0 +1,265 @@
+package compact
+
+import (
+	"math"
+	"sync"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// none is the index of no entry.
+const none = -1
+
+// minCompaction is the garbage the arena of a shard may hold before it is
+// compacted, however small the shard.
+const minCompaction = 64 << 10
+
+// shard is an independently locked part of a Cache. Its entries are linked
+// by index: in the LRU list, in the chains of the keys of the same hash,
+// and in the list of free entries.
+type shard[V any] struct {
+	mu      sync.Mutex
+	entries []entry[V]       // all of them, allocated by newShard
+	index   map[uint64]int32 // hash of a key to the first entry of its chain
+	arena   []byte           // the keys of the entries, one after the other
+	garbage int              // bytes of arena no entry refers to anymore
+	head    int32            // most recently used entry, or none
+	tail    int32            // least recently used entry, or none
+	free    int32            // first free entry, the next ones linked by next
+	n       int              // entries in use
+	stats   *counters
+}
+
+// entry is a key and its value: the key is arena[off:off+klen].
+type entry[V any] struct {
+	hash       uint64
+	off, klen  uint32
+	prev, next int32 // LRU neighbours, toward head and toward tail
+	chain      int32 // next entry of the same hash
+	expires    int64 // Unix nanoseconds, 0 if it never expires
+	value      V
+}
+
+func newShard[V any](sz int, stats *counters) *shard[V] {
+	if sz > math.MaxInt32 {
+		panic("compact: more than 2^31 entries in a shard, use more shards")
+	}
+	s := &shard[V]{
+		entries: make([]entry[V], sz),
+		index:   make(map[uint64]int32, sz),
+		stats:   stats,
+	}
+	s.reset()
+	return s
+}
+
+// reset frees every entry. The caller must hold the lock.
+func (s *shard[V]) reset() {
+	for i := range s.entries {
+		s.entries[i] = entry[V]{next: int32(i + 1)}
+	}
+	s.entries[len(s.entries)-1].next = none
+	clear(s.index)
+	s.arena = s.arena[:0]
+	s.garbage = 0
+	s.head, s.tail, s.free = none, none, 0
+	s.n = 0
+}
+
+func (s *shard[V]) get(h uint64, key string, now int64) (V, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(h, key)
+	if i == none {
+		s.stats.misses.Add(1)
+		var zero V
+		return zero, lrucache.ErrNotFound
+	}
+	e := &s.entries[i]
+	if e.expires != 0 && now > e.expires {
+		s.remove(i)
+		s.stats.misses.Add(1)
+		s.stats.expirations.Add(1)
+		var zero V
+		return zero, lrucache.ErrExpired
+	}
+	s.moveToFront(i)
+	s.stats.hits.Add(1)
+	return e.value, nil
+}
+
+func (s *shard[V]) insert(h uint64, key string, value V, expires int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set(h, key, value, expires)
+}
+
+// set stores value under key as the most recently used entry, evicting the
+// least recently used one if the shard is full. The caller must hold the
+// lock.
+func (s *shard[V]) set(h uint64, key string, value V, expires int64) error {
+	if i := s.find(h, key); i != none {
+		s.entries[i].value = value
+		s.entries[i].expires = expires
+		s.moveToFront(i)
+		return nil
+	}
+	if len(s.arena)+len(key) > math.MaxUint32 {
+		s.compact()
+		if len(s.arena)+len(key) > math.MaxUint32 {
+			return lrucache.ErrTooLarge
+		}
+	}
+	if s.free == none {
+		s.remove(s.tail)
+		s.stats.evictions.Add(1)
+	}
+	i := s.free
+	e := &s.entries[i]
+	s.free = e.next
+	chain, ok := s.index[h]
+	if !ok {
+		chain = none
+	}
+	*e = entry[V]{
+		hash:    h,
+		off:     uint32(len(s.arena)),
+		klen:    uint32(len(key)),
+		chain:   chain,
+		expires: expires,
+		value:   value,
+	}
+	s.arena = append(s.arena, key...)
+	s.index[h] = i
+	s.pushFront(i)
+	s.n++
+	return nil
+}
+
+func (s *shard[V]) delete(h uint64, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(h, key)
+	if i == none {
+		return false
+	}
+	s.remove(i)
+	return true
+}
+
+func (s *shard[V]) purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+func (s *shard[V]) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+func (s *shard[V]) size() int {
+	return len(s.entries)
+}
+
+// key returns the key of entry e. The caller must hold the lock.
+func (s *shard[V]) key(e *entry[V]) string {
+	return string(s.arena[e.off : e.off+e.klen])
+}
+
+// find returns the entry of key, or none. The caller must hold the lock.
+func (s *shard[V]) find(h uint64, key string) int32 {
+	i, ok := s.index[h]
+	if !ok {
+		return none
+	}
+	for i != none {
+		e := &s.entries[i]
+		if string(s.arena[e.off:e.off+e.klen]) == key {
+			return i
+		}
+		i = e.chain
+	}
+	return none
+}
+
+// remove frees entry i, compacting the arena once it is mostly garbage.
+// The caller must hold the lock.
+func (s *shard[V]) remove(i int32) {
+	e := &s.entries[i]
+	s.unlink(i)
+	if first := s.index[e.hash]; first == i {
+		if e.chain == none {
+			delete(s.index, e.hash)
+		} else {
+			s.index[e.hash] = e.chain
+		}
+	} else {
+		for j := first; ; j = s.entries[j].chain {
+			if s.entries[j].chain == i {
+				s.entries[j].chain = e.chain
+				break
+			}
+		}
+	}
+	s.garbage += int(e.klen)
+	*e = entry[V]{next: s.free}
+	s.free = i
+	s.n--
+	if s.garbage > minCompaction && s.garbage > len(s.arena)/2 {
+		s.compact()
+	}
+}
+
+// compact copies the keys still referred to into a new arena. The caller
+// must hold the lock.
+func (s *shard[V]) compact() {
+	arena := make([]byte, 0, len(s.arena)-s.garbage)
+	for i := s.head; i != none; i = s.entries[i].next {
+		e := &s.entries[i]
+		off := len(arena)
+		arena = append(arena, s.arena[e.off:e.off+e.klen]...)
+		e.off = uint32(off)
+	}
+	s.arena = arena
+	s.garbage = 0
+}
+
+// pushFront links entry i as the most recently used. The caller must hold
+// the lock.
+func (s *shard[V]) pushFront(i int32) {
+	e := &s.entries[i]
+	e.prev, e.next = none, s.head
+	if s.head != none {
+		s.entries[s.head].prev = i
+	}
+	s.head = i
+	if s.tail == none {
+		s.tail = i
+	}
+}
+
+// unlink takes entry i out of the LRU list. The caller must hold the lock.
+func (s *shard[V]) unlink(i int32) {
+	e := &s.entries[i]
+	if e.prev != none {
+		s.entries[e.prev].next = e.next
+	} else {
+		s.head = e.next
+	}
+	if e.next != none {
+		s.entries[e.next].prev = e.prev
+	} else {
+		s.tail = e.prev
+	}
+}
+
+// moveToFront marks entry i as the most recently used. The caller must hold
+// the lock.
+func (s *shard[V]) moveToFront(i int32) {
+	if s.head != i {
+		s.unlink(i)
+		s.pushFront(i)
+	}
+}
@@ -0,0 +1,177 @@
+package compare
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dgraph-io/ristretto/v2"
+	lru "github.com/hashicorp/golang-lru/v2"
+
+	"github.com/jared-d-smith/psl/salestax-srv/cache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+const (
+	cacheSize = 10000
+	keySpace  = 2 * cacheSize
+	traceLen  = 1 << 16 // keys per goroutine, replayed in a loop
+)
+
+type hashicorpAdapter struct{ c *lru.Cache[string, float64] }
+
+func (a hashicorpAdapter) Get(key string) (float64, bool) { return a.c.Get(key) }
+func (a hashicorpAdapter) Set(key string, value float64) error {
+	a.c.Add(key, value)
+	return nil
+}
+func (a hashicorpAdapter) Delete(key string) bool { return a.c.Remove(key) }
+func (a hashicorpAdapter) Len() int               { return a.c.Len() }
+
+type ristrettoAdapter struct {
+	c *ristretto.Cache[string, float64]
+}
+
+func (a ristrettoAdapter) Get(key string) (float64, bool) { return a.c.Get(key) }
+func (a ristrettoAdapter) Set(key string, value float64) error {
+	a.c.Set(key, value, 1)
+	return nil
+}
+
+func (a ristrettoAdapter) Delete(key string) bool {
+	_, ok := a.c.Get(key)
+	a.c.Del(key)
+	return ok
+}
+
+// Len is not used by the workloads. ristretto only counts its keys with
+// Config.Metrics, which would slow it down, so it reports 0 here.
+func (a ristrettoAdapter) Len() int {
+	return int(a.c.Metrics.KeysAdded() - a.c.Metrics.KeysEvicted())
+}
+
+// Wait blocks until buffered writes are applied; ristretto's Set is
+// asynchronous and the prefilled keys would otherwise still be missing.
+func (a ristrettoAdapter) Wait() { a.c.Wait() }
+
+// impls are the caches under test, each returning a fresh instance.
+var impls = []struct {
+	name string
+	new  func(b *testing.B) cache.Cache[string, float64]
+}{
+	{"lrucache/shards=1", newLRUCache(1)},
+	{"lrucache/shards=4", newLRUCache(4)},
+	{"lrucache/shards=16", newLRUCache(16)},
+	{"lrucache/shards=16/approx", newLRUCache(16, lrucache.WithApproximateLRU())},
+	{"golang-lru", func(b *testing.B) cache.Cache[string, float64] {
+		c, err := lru.New[string, float64](cacheSize)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return hashicorpAdapter{c}
+	}},
+	{"ristretto", func(b *testing.B) cache.Cache[string, float64] {
+		c, err := ristretto.NewCache(&ristretto.Config[string, float64]{
+			NumCounters: 10 * cacheSize,
+			MaxCost:     cacheSize, // cost 1 per item, like the others
+			BufferItems: 64,
+			// otherwise ristretto's own overhead counts towards MaxCost
+			IgnoreInternalCost: true,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(c.Close)
+		return ristrettoAdapter{c}
+	}},
+}
+
+func newLRUCache(shards int, opts ...lrucache.Option) func(b *testing.B) cache.Cache[string, float64] {
+	return func(b *testing.B) cache.Cache[string, float64] {
+		opts := append([]lrucache.Option{lrucache.WithShards(shards)}, opts...)
+		return cache.LRU(lrucache.New[string, float64](cacheSize, opts...))
+	}
+}
+
+// workload describes the operation mix: the share of reads, and how keys are
+// drawn from the key space.
+type workload struct {
+	name  string
+	reads float64 // fraction of operations that are reads, the rest writes
+	zipf  bool    // Zipfian (a few hot keys) instead of uniform keys
+}
+
+var workloads = []workload{
+	{"read-heavy", 0.9, false},
+	{"write-heavy", 0.1, false},
+	{"zipf-mixed", 0.75, true},
+}
+
+var keys = func() []string {
+	keys := make([]string, keySpace)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}()
+
+// trace precomputes the key indexes of one goroutine, so that random number
+// generation is not part of the measurement.
+func (w workload) trace(seed uint64) []int {
+	r := rand.New(rand.NewPCG(seed, seed))
+	zipf := rand.NewZipf(r, 1.1, 1, keySpace-1)
+	trace := make([]int, traceLen)
+	for i := range trace {
+		if w.zipf {
+			trace[i] = int(zipf.Uint64())
+		} else {
+			trace[i] = r.IntN(keySpace)
+		}
+	}
+	return trace
+}
+
+func BenchmarkCompare(b *testing.B) {
+	for _, w := range workloads {
+		for _, impl := range impls {
+			b.Run(w.name+"/"+impl.name, func(b *testing.B) {
+				benchmarkWorkload(b, w, impl.new(b))
+			})
+		}
+	}
+}
+
+func benchmarkWorkload(b *testing.B, w workload, c cache.Cache[string, float64]) {
+	for i := range cacheSize {
+		c.Set(keys[i], float64(i))
+	}
+	if w, ok := c.(interface{ Wait() }); ok {
+		w.Wait()
+	}
+	readEvery := int(w.reads * 100)
+	var seed atomic.Uint64
+	var hits, total atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		trace := w.trace(seed.Add(1))
+		var h, n int64
+		for i := 0; pb.Next(); i++ {
+			k := keys[trace[i%len(trace)]]
+			if i%100 < readEvery {
+				if _, ok := c.Get(k); ok {
+					h++
+				}
+				n++
+			} else {
+				c.Set(k, 1)
+			}
+		}
+		hits.Add(h)
+		total.Add(n)
+	})
+	if n := total.Load(); n > 0 {
+		b.ReportMetric(float64(hits.Load())/float64(n), "hit-ratio")
+	}
+}
@@ -0,0 +1,7 @@
+// Package compare benchmarks lrucache against other Go caches,
+// hashicorp/golang-lru and dgraph-io/ristretto, on the same workloads. It
+// only contains benchmarks and lives in its own package so that lrucache
+// itself does not depend on them:
+//
+//	go test -bench . -benchmem ./lrucache/compare
+package compare
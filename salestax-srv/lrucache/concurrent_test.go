@@ -0,0 +1,327 @@
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// These tests are mostly useful under the race detector (go test -race); they
+// also check the invariants that must hold however the goroutines interleave.
+
+func TestConcurrentAccess(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"exact", nil},
+		{"approximate", []Option{WithApproximateLRU()}},
+		{"sharded", []Option{WithShards(4)}},
+		{"ttl", []Option{WithTTL(time.Millisecond), WithSweepInterval(time.Millisecond)}},
+		{"lfu", []Option{WithPolicy(NewLFUPolicy[int])}},
+		{"arc", []Option{WithPolicy(NewARCPolicy[int])}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const size = 64
+			c := New[int, int](size, tc.opts...)
+			defer c.Close()
+			var wg sync.WaitGroup
+			for g := range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range 2000 {
+						k := (g*7 + i) % (size * 2)
+						switch i % 5 {
+						case 0:
+							c.Insert(k, k)
+						case 1:
+							c.Delete(k)
+						case 2:
+							c.Keys()
+						default:
+							if item, err := c.Get(k); err == nil && item.Value() != k {
+								t.Errorf("Get(%d) = %d", k, item.Value())
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if n := c.Len(); n > size {
+				t.Errorf("Len = %d, exceeds the capacity %d", n, size)
+			}
+		})
+	}
+}
+
+func TestConcurrentLoadsCoalesce(t *testing.T) {
+	c := New[string, int](10)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (int, error) {
+		calls.Add(1)
+		<-release
+		return len(key), nil
+	}
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.GetOrLoadCtx(context.Background(), "abc", loader); err != nil || v != 3 {
+				t.Errorf("GetOrLoadCtx = %d, %v, want 3, nil", v, err)
+			}
+		}()
+	}
+	// let the goroutines pile up on the in-flight load
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("loader called %d times, want 1", n)
+	}
+}
+
+func TestSlowLoadDoesNotBlockOtherKeys(t *testing.T) {
+	// a single shard, so every key shares one segment lock
+	c := New[string, int](10)
+	c.Insert("hit", 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go c.GetOrLoadCtx(context.Background(), "slow", func(ctx context.Context, key string) (int, error) {
+		close(started)
+		<-release
+		return 0, nil
+	})
+	<-started
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.Get("hit"); err != nil {
+			t.Errorf("Get during slow load: %v", err)
+		}
+		v, err := c.GetOrLoadCtx(context.Background(), "fast", func(ctx context.Context, key string) (int, error) {
+			return 2, nil
+		})
+		if err != nil || v != 2 {
+			t.Errorf("GetOrLoadCtx during slow load = %d, %v, want 2, nil", v, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hit and load of other keys blocked by a slow load")
+	}
+}
+
+func TestConcurrentResize(t *testing.T) {
+	c := New[string, int](100, WithShards(4))
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.Insert(strconv.Itoa(i%500), i)
+		}
+	}()
+	for _, sz := range []int{10, 200, 4, 50} {
+		c.Resize(sz)
+	}
+	close(stop)
+	wg.Wait()
+	if c.Len() > 50 {
+		t.Errorf("Len = %d, exceeds the capacity 50", c.Len())
+	}
+}
+
+func TestCloseCancelsRefreshes(t *testing.T) {
+	c := New[string, int](10, WithTTL(time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+	c.Insert("a", 1)
+	time.Sleep(2 * time.Millisecond)
+
+	started := make(chan struct{})
+	cancelled := false
+	v, err := c.GetOrLoadCtx(context.Background(), "a", func(ctx context.Context, key string) (int, error) {
+		close(started)
+		<-ctx.Done()
+		cancelled = true
+		return 0, ctx.Err()
+	})
+	if err != nil || v != 1 {
+		t.Fatalf("stale GetOrLoadCtx = %v, %v, want 1, nil", v, err)
+	}
+	<-started
+	c.Close()
+	// Close waited for the refresh, so this is no race
+	if !cancelled {
+		t.Error("Close returned before the refresh was cancelled")
+	}
+
+	// no refresh starts after Close
+	c.GetOrLoad("a", func(string) (int, error) {
+		t.Error("refresh after Close")
+		return 2, nil
+	})
+}
+
+func TestLoaderPanic(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"no timeout", nil},
+		{"timeout", []Option{WithLoaderTimeout(time.Second)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New[int, int](10, append(tc.opts, WithLoaderConcurrency(1))...)
+			_, err := c.GetOrLoadCtx(context.Background(), 1, func(context.Context, int) (int, error) {
+				panic("boom")
+			})
+			if !errors.Is(err, ErrLoaderFailed) || !strings.Contains(err.Error(), "loader panicked: boom") {
+				t.Fatalf("GetOrLoad error = %v, want the panic as ErrLoaderFailed", err)
+			}
+			// the loader slot was released
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if v, err := c.GetOrLoadCtx(ctx, 2, func(context.Context, int) (int, error) { return 2, nil }); err != nil || v != 2 {
+				t.Errorf("GetOrLoad after the panic = %d, %v", v, err)
+			}
+		})
+	}
+}
+
+func TestFirstCallerCancelled(t *testing.T) {
+	c := New[int, int](10)
+	started, release := make(chan struct{}), make(chan struct{})
+	var loaderErr atomic.Value
+	loader := func(ctx context.Context, k int) (int, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			loaderErr.Store(err)
+		}
+		return k, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoadCtx(ctx, 1, loader)
+		first <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() {
+		v, err := c.GetOrLoadCtx(context.Background(), 1, loader)
+		if err == nil && v != 1 {
+			err = fmt.Errorf("value %d", v)
+		}
+		second <- err
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("second caller: %v", err)
+	}
+	if err := loaderErr.Load(); err != nil {
+		t.Errorf("the loader saw its context %v", err)
+	}
+	if _, err := c.Get(1); err != nil {
+		t.Errorf("the value was not cached: %v", err)
+	}
+}
+
+func TestBatchLoaderLimit(t *testing.T) {
+	c := New[int, int](10, WithLoaderConcurrency(1), WithLoaderFailFast())
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.GetOrLoad(1, func(k int) (int, error) {
+			close(started)
+			<-release
+			return k, nil
+		})
+	}()
+	<-started
+
+	calls := 0
+	batch := func(keys []int) (map[int]int, error) {
+		calls++
+		values := make(map[int]int, len(keys))
+		for _, k := range keys {
+			values[k] = k
+		}
+		return values, nil
+	}
+	if _, err := c.FastRateLookupMulti([]int{2, 3}, batch); !errors.Is(err, ErrThrottled) || calls != 0 {
+		t.Errorf("batch while the slot is taken = %v with %d calls, want ErrThrottled without calling the loader", err, calls)
+	}
+	if st := c.Stats(); st.Throttled != 1 {
+		t.Errorf("Throttled = %d, want 1", st.Throttled)
+	}
+	close(release)
+	<-done
+	if got, err := c.FastRateLookupMulti([]int{1, 2, 3}, batch); err != nil || len(got) != 3 || calls != 1 {
+		t.Errorf("batch once the slot is free = %v, %v with %d calls", got, err, calls)
+	}
+	// the batch released its slot
+	if v, err := c.GetOrLoad(4, func(k int) (int, error) { return k, nil }); err != nil || v != 4 {
+		t.Errorf("GetOrLoad after the batch = %d, %v", v, err)
+	}
+}
+
+func TestBatchLoaderTimeout(t *testing.T) {
+	c := New[int, int](10, WithLoaderTimeout(10*time.Millisecond))
+	c.Insert(1, 1)
+	release := make(chan struct{})
+	defer close(release)
+	// the loader ignores its context
+	got, err := c.FastRateLookupMulti([]int{1, 2}, func(keys []int) (map[int]int, error) {
+		<-release
+		return map[int]int{2: 2}, nil
+	})
+	if !errors.Is(err, ErrLoaderTimeout) || !errors.Is(err, ErrLoaderFailed) || got[1] != 1 {
+		t.Errorf("batch of a hanging loader = %v, %v, want the cached value and ErrLoaderTimeout", got, err)
+	}
+	if st := c.Stats(); st.Timeouts != 1 || st.LoaderErrors != 1 {
+		t.Errorf("Timeouts = %d, LoaderErrors = %d, want 1 and 1", st.Timeouts, st.LoaderErrors)
+	}
+	if c.Contains(2) {
+		t.Error("the result of the abandoned batch was cached")
+	}
+
+	// the caller's own deadline is not a timeout of the loader
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = c.FastRateLookupMultiCtx(ctx, []int{3}, func(ctx context.Context, keys []int) (map[int]int, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || c.Stats().Timeouts != 1 {
+		t.Errorf("batch past the caller's deadline = %v with %d timeouts, want its deadline", err, c.Stats().Timeouts)
+	}
+
+	_, err = c.FastRateLookupMulti([]int{4}, func([]int) (map[int]int, error) { panic("boom") })
+	if !errors.Is(err, ErrLoaderFailed) || !strings.Contains(err.Error(), "loader panicked: boom") {
+		t.Errorf("batch of a panicking loader = %v, want the panic as ErrLoaderFailed", err)
+	}
+}
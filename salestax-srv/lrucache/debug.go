@@ -0,0 +1,81 @@
+package lrucache
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Inspection is a view of the cache internals for troubleshooting, see
+// Inspect.
+type Inspection[K comparable] struct {
+	Shards []ShardInfo[K]
+	Hot    []KeyHits[K] // the most hit live keys, hottest first
+}
+
+// ShardInfo describes one shard of the cache.
+type ShardInfo[K comparable] struct {
+	Len      int // items, including expired ones not removed yet
+	Weight   int // total cost of the items
+	Capacity int
+	Tail     []K // the next victims, the next one first; nil for unordered policies
+}
+
+// KeyHits is a key with a number of requests: hits since the item was stored
+// for Inspect, lookups over the window for HotKeys.
+type KeyHits[K comparable] struct {
+	Key  K
+	Hits uint64
+}
+
+// Inspect returns the size of every shard with up to n of its next victims,
+// and the n live keys with the most hits since they were stored. It visits
+// every item with each shard's read lock held in turn, so it is meant for
+// debugging, not for frequent polling.
+func (c *LRUCache[K, V]) Inspect(n int) Inspection[K] {
+	var in Inspection[K]
+	for _, s := range c.shards {
+		info, hot := s.inspect(n)
+		in.Shards = append(in.Shards, info)
+		in.Hot = append(in.Hot, hot...)
+	}
+	in.Hot = topHits(in.Hot, n)
+	return in
+}
+
+func (s *segment[K, V]) inspect(n int) (ShardInfo[K], []KeyHits[K]) {
+	now := s.clock.Now()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	info := ShardInfo[K]{Len: len(s.cache), Weight: s.used, Capacity: s.size}
+	if s.engine != nil {
+		// entries of an Engine keep no hits
+		keys := s.engineKeys(nil)
+		info.Len = len(keys)
+		for i := len(keys) - 1; i >= 0 && len(info.Tail) < n; i-- {
+			info.Tail = append(info.Tail, keys[i])
+		}
+		return info, nil
+	}
+	if op, ok := s.policy.(OrderedPolicy[K]); ok {
+		keys := op.Keys()
+		for i := len(keys) - 1; i >= 0 && len(info.Tail) < n; i-- {
+			info.Tail = append(info.Tail, keys[i])
+		}
+	}
+	var hot []KeyHits[K]
+	for key, item := range s.cache {
+		if hits := item.hits.Load(); hits > 0 && !item.expired(now) {
+			hot = append(hot, KeyHits[K]{Key: key, Hits: uint64(hits)})
+		}
+	}
+	return info, topHits(hot, n)
+}
+
+// topHits sorts keys by descending hits and keeps the first n.
+func topHits[K comparable](keys []KeyHits[K], n int) []KeyHits[K] {
+	slices.SortFunc(keys, func(a, b KeyHits[K]) int {
+		return cmp.Compare(b.Hits, a.Hits)
+	})
+	return keys[:min(max(n, 0), len(keys))]
+}
@@ -0,0 +1,153 @@
+package lrucache
+
+import "time"
+
+// Engine stores the items of one shard in place of its map and eviction
+// policy, see WithEngine. It keeps its entries in least recently used order
+// and never evicts on its own: the cache removes the Oldest entry before it
+// sets a new key in a full engine. It is called with the shard lock held,
+// the write lock for Set, Delete, Resize and a Get that touches the entry,
+// at least the read lock for the other calls.
+type Engine[K comparable, V any] interface {
+	// Get returns the value of key and when it expires, zero for never. If
+	// touch is set the entry becomes the most recently used.
+	Get(key K, touch bool) (value V, expires time.Time, ok bool)
+	// Set stores value under key as the most recently used entry.
+	Set(key K, value V, expires time.Time) error
+	// Delete removes key and reports whether it was stored.
+	Delete(key K) bool
+	// Oldest returns the least recently used entry.
+	Oldest() (key K, value V, expires time.Time, ok bool)
+	// Range calls fn for every entry, the most recently used first, until
+	// fn returns false.
+	Range(fn func(key K, value V, expires time.Time) bool)
+	// Resize changes the capacity to sz entries. When shrinking, the cache
+	// has removed the entries over sz already.
+	Resize(sz int)
+	// Len returns the number of entries, expired ones included.
+	Len() int
+}
+
+// EngineFactory returns an Engine holding up to sz entries, for WithEngine.
+type EngineFactory[K comparable, V any] func(sz int) Engine[K, V]
+
+// engineItem returns an item for an entry of an Engine. It is built on each
+// call and has no version, creation time or hits.
+func engineItem[K comparable, V any](key K, value V, expires time.Time) *CacheItem[K, V] {
+	return &CacheItem[K, V]{key: key, value: value, expires: expires, cost: 1}
+}
+
+// expiredAt reports whether an entry expiring at expires has expired at now.
+func expiredAt(expires, now time.Time) bool {
+	return !expires.IsZero() && now.After(expires)
+}
+
+// engineGet is get for a segment backed by an Engine.
+func (s *segment[K, V]) engineGet(key K) (V, time.Time, error) {
+	now := s.record(key)
+	var zero V
+	s.mutex.Lock()
+	defer s.unlock()
+	value, expires, ok := s.engine.Get(key, true)
+	if !ok {
+		s.stats.miss(now)
+		return zero, time.Time{}, ErrNotFound
+	}
+	if expiredAt(expires, now) {
+		// keep it around for staleItem until the window passes
+		if expiredAt(expires, now.Add(-s.stale)) {
+			s.removeLocked(key, EvictExpired)
+			s.stats.expirations.Add(1)
+		}
+		s.stats.miss(now)
+		return zero, time.Time{}, ErrExpired
+	}
+	s.stats.hit(now)
+	return value, expires, nil
+}
+
+// enginePeek is peek and staleItem for a segment backed by an Engine: it
+// returns the item for key unless it had expired by until or, for a from that
+// is not zero, had not expired yet at from. The caller must hold the lock.
+func (s *segment[K, V]) enginePeek(key K, from, until time.Time) *CacheItem[K, V] {
+	value, expires, ok := s.engine.Get(key, false)
+	if !ok || expiredAt(expires, until) || !from.IsZero() && !expiredAt(expires, from) {
+		return nil
+	}
+	return engineItem(key, value, expires)
+}
+
+// engineInsert is insertLocked for a segment backed by an Engine.
+func (s *segment[K, V]) engineInsert(key K, value V, expires time.Time) error {
+	_, _, exists := s.engine.Get(key, false)
+	if !exists && s.used >= s.size {
+		s.prune(s.used-s.size+1, EvictCapacity)
+	}
+	if err := s.engine.Set(key, value, expires); err != nil {
+		return err
+	}
+	if !exists {
+		s.used++
+	}
+	if s.journal != nil {
+		s.journal.Insert(key, value, expires)
+	}
+	return nil
+}
+
+// enginePrune is prune for a segment backed by an Engine.
+func (s *segment[K, V]) enginePrune(n int, reason EvictReason) int {
+	i := 0
+	for i < n || s.used > s.size {
+		key, value, _, ok := s.engine.Oldest()
+		if !ok {
+			break
+		}
+		s.engine.Delete(key)
+		s.used--
+		s.evicted(key, value, reason)
+		s.stats.evictions.Add(1)
+		i++
+	}
+	return i
+}
+
+// engineRemove is removeLocked for a segment backed by an Engine.
+func (s *segment[K, V]) engineRemove(key K, reason EvictReason) {
+	value, _, ok := s.engine.Get(key, false)
+	if !ok {
+		return
+	}
+	s.engine.Delete(key)
+	s.used--
+	s.evicted(key, value, reason)
+	if reason == EvictDeleted && s.journal != nil {
+		s.journal.Delete(key)
+	}
+}
+
+// engineKeys returns the keys of the entries of the Engine for which match
+// returns true, the most recently used first; it returns them all for a nil
+// match. The caller must hold the lock.
+func (s *segment[K, V]) engineKeys(match func(K, time.Time) bool) []K {
+	var keys []K
+	s.engine.Range(func(key K, _ V, expires time.Time) bool {
+		if match == nil || match(key, expires) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// engineSnapshot is snapshot for a segment backed by an Engine.
+func (s *segment[K, V]) engineSnapshot(now time.Time) []*CacheItem[K, V] {
+	items := make([]*CacheItem[K, V], 0, s.engine.Len())
+	s.engine.Range(func(key K, value V, expires time.Time) bool {
+		if !expiredAt(expires, now) {
+			items = append(items, engineItem(key, value, expires))
+		}
+		return true
+	})
+	return items
+}
@@ -0,0 +1,40 @@
+package lrucache
+
+import "errors"
+
+// Errors returned by the cache, for use with errors.Is. The messages predate
+// the variables and are kept for anyone matching on them.
+var (
+	// ErrNotFound is returned for a key that is not in the cache when there
+	// is no loader to fall back to.
+	ErrNotFound = errors.New("Key not found")
+	// ErrExpired is returned by Get for a key whose TTL elapsed. It is not
+	// ErrNotFound, so callers can tell a stale key from an unknown one.
+	ErrExpired = errors.New("Key expired")
+	// ErrLoaderFailed wraps the error of a failed loader call, so both
+	// errors.Is(err, ErrLoaderFailed) and matching the loader's own error
+	// work. It is also what a negatively cached key returns.
+	ErrLoaderFailed = errors.New("Using provided data acquistion routine")
+	// ErrLoaderTimeout is the loader error of a call abandoned after the
+	// WithLoaderTimeout deadline. It is wrapped in ErrLoaderFailed.
+	ErrLoaderTimeout = errors.New("Loader timed out")
+	// ErrThrottled is returned for a miss over the WithLoaderConcurrency or
+	// WithLoaderRate limit with WithLoaderFailFast.
+	ErrThrottled = errors.New("Loader limit exceeded")
+	// ErrBackendUnavailable is returned by GetOrLoad and Lookup instead
+	// of calling the loader while the circuit breaker configured with
+	// WithCircuitBreaker is open.
+	ErrBackendUnavailable = errors.New("Backend unavailable")
+	// ErrTooLarge is returned by Insert for a value heavier than the whole
+	// cache capacity, see WithWeigher.
+	ErrTooLarge = errors.New("Value too large for cache")
+	// ErrVersionMismatch is returned by InsertIfVersion when the key has
+	// been updated since the expected version was read.
+	ErrVersionMismatch = errors.New("Version mismatch")
+	// ErrSnapshotVersion is returned by LoadSnapshot for a snapshot written
+	// in an unknown format.
+	ErrSnapshotVersion = errors.New("Unsupported snapshot version")
+	// ErrSnapshotCorrupt is returned by LoadSnapshot for a snapshot that is
+	// damaged or cut short.
+	ErrSnapshotCorrupt = errors.New("Corrupt snapshot")
+)
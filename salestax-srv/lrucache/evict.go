@@ -0,0 +1,59 @@
+package lrucache
+
+// EvictReason tells an eviction callback why an item left the cache.
+type EvictReason int
+
+const (
+	// EvictCapacity means the item was evicted to make room for another.
+	EvictCapacity EvictReason = iota + 1
+	// EvictExpired means the item's TTL elapsed.
+	EvictExpired
+	// EvictDeleted means the item was removed explicitly.
+	EvictDeleted
+	// EvictPressure means the item was evicted by WithMemoryPressure
+	// shrinking the cache.
+	EvictPressure
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictPressure:
+		return "pressure"
+	}
+	return "unknown"
+}
+
+// OnEvictFunc is called for every item that leaves the cache.
+type OnEvictFunc[K comparable, V any] func(key K, value V, reason EvictReason)
+
+// eviction is a callback invocation queued while the segment lock is held.
+type eviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// evicted queues the callback for an item. The caller must hold the write
+// lock.
+func (s *segment[K, V]) evicted(key K, value V, reason EvictReason) {
+	if s.onEvict != nil {
+		s.pending = append(s.pending, eviction[K, V]{key, value, reason})
+	}
+}
+
+// unlock releases the write lock and then runs the eviction callbacks queued
+// while it was held, so that callbacks may safely call back into the cache.
+func (s *segment[K, V]) unlock() {
+	pending := s.pending
+	s.pending = nil
+	s.mutex.Unlock()
+	for _, e := range pending {
+		s.onEvict(e.key, e.value, e.reason)
+	}
+}
@@ -0,0 +1,107 @@
+package lrucache
+
+import "time"
+
+// expiryHeap is a min-heap of the keys of a segment that expire, ordered by
+// expiration time, so that a sweep only visits the items that are due
+// instead of scanning the whole segment. index holds the position of every
+// key in entries, making updates and removals O(log n).
+type expiryHeap[K comparable] struct {
+	entries []expiryEntry[K]
+	index   map[K]int
+}
+
+type expiryEntry[K comparable] struct {
+	key     K
+	expires time.Time
+}
+
+func newExpiryHeap[K comparable]() *expiryHeap[K] {
+	return &expiryHeap[K]{index: make(map[K]int)}
+}
+
+// set records that key expires at expires, replacing any earlier time. A
+// zero expires means the key never expires and removes it from the heap.
+func (h *expiryHeap[K]) set(key K, expires time.Time) {
+	if expires.IsZero() {
+		h.remove(key)
+		return
+	}
+	i, ok := h.index[key]
+	if !ok {
+		i = len(h.entries)
+		h.entries = append(h.entries, expiryEntry[K]{key: key, expires: expires})
+		h.index[key] = i
+		h.up(i)
+		return
+	}
+	h.entries[i].expires = expires
+	h.fix(i)
+}
+
+func (h *expiryHeap[K]) remove(key K) {
+	i, ok := h.index[key]
+	if !ok {
+		return
+	}
+	last := len(h.entries) - 1
+	h.swap(i, last)
+	h.entries[last] = expiryEntry[K]{}
+	h.entries = h.entries[:last]
+	delete(h.index, key)
+	if i < last {
+		h.fix(i)
+	}
+}
+
+// next returns the key that expires first, or false if the heap is empty.
+func (h *expiryHeap[K]) next() (K, time.Time, bool) {
+	if len(h.entries) == 0 {
+		var zero K
+		return zero, time.Time{}, false
+	}
+	return h.entries[0].key, h.entries[0].expires, true
+}
+
+func (h *expiryHeap[K]) fix(i int) {
+	if !h.down(i) {
+		h.up(i)
+	}
+}
+
+func (h *expiryHeap[K]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.entries[i].expires.Before(h.entries[parent].expires) {
+			return
+		}
+		h.swap(i, parent)
+		i = parent
+	}
+}
+
+// down moves entry i towards the leaves and reports whether it moved.
+func (h *expiryHeap[K]) down(i int) bool {
+	start := i
+	for {
+		child := 2*i + 1
+		if child >= len(h.entries) {
+			break
+		}
+		if right := child + 1; right < len(h.entries) && h.entries[right].expires.Before(h.entries[child].expires) {
+			child = right
+		}
+		if !h.entries[child].expires.Before(h.entries[i].expires) {
+			break
+		}
+		h.swap(i, child)
+		i = child
+	}
+	return i > start
+}
+
+func (h *expiryHeap[K]) swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.index[h.entries[i].key] = i
+	h.index[h.entries[j].key] = j
+}
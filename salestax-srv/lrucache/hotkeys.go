@@ -0,0 +1,130 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHotKeysWindow is the window of WithHotKeys when none is given.
+const DefaultHotKeysWindow = 5 * time.Minute
+
+// hotKeysSlack is how many candidates each shard tracks per requested hot
+// key. More candidates make the counts of the top keys more accurate.
+const hotKeysSlack = 4
+
+// WithHotKeys tracks the k most requested keys over roughly the last window,
+// for HotKeys: e.g. to pick the addresses of a warm-up file or to check that
+// the capacity covers the working set. Every Get, GetOrLoad and Lookup is
+// counted, hit or miss. A zero window selects DefaultHotKeysWindow. Counting
+// takes a per-shard lock, so it costs throughput on many-core machines; it
+// is off by default.
+func WithHotKeys(k int, window time.Duration) Option {
+	return func(o *options) {
+		o.hotKeys = k
+		o.hotKeysWindow = window
+	}
+}
+
+// HotKeys returns up to k of the most requested keys with their number of
+// requests over the last window given to WithHotKeys, the most requested
+// first. k is capped at, and zero or less means, the configured number of
+// hot keys. It returns nil without WithHotKeys. Counts are approximate: a
+// key requested rarely may be over-counted once it enters the list.
+func (c *LRUCache[K, V]) HotKeys(k int) []KeyHits[K] {
+	if c.hotKeys <= 0 {
+		return nil
+	}
+	if k <= 0 || k > c.hotKeys {
+		k = c.hotKeys
+	}
+	now := c.clock.Now()
+	var keys []KeyHits[K]
+	for _, s := range c.shards {
+		keys = append(keys, s.hot.top(now)...)
+	}
+	return topHits(keys, k)
+}
+
+// hotKeys counts requests per key over a sliding window. The window is
+// split into two halves: requests are counted in cur, and every half window
+// cur becomes prev, so that the reported counts cover between half and all
+// of the window. Each half uses the Space-Saving algorithm (Metwally et al.)
+// to find the heavy hitters in bounded memory.
+type hotKeys[K comparable] struct {
+	mu        sync.Mutex
+	size      int
+	half      time.Duration
+	rotated   time.Time
+	cur, prev map[K]uint64
+}
+
+func newHotKeys[K comparable](k int, window time.Duration, now time.Time) *hotKeys[K] {
+	if window <= 0 {
+		window = DefaultHotKeysWindow
+	}
+	size := hotKeysSlack * k
+	return &hotKeys[K]{
+		size:    size,
+		half:    window / 2,
+		rotated: now,
+		cur:     make(map[K]uint64, size),
+	}
+}
+
+// record counts a request for key.
+func (h *hotKeys[K]) record(key K, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(now)
+	if n, ok := h.cur[key]; ok || len(h.cur) < h.size {
+		h.cur[key] = n + 1
+		return
+	}
+	// replace the least counted candidate; the newcomer inherits its count
+	// as an upper bound of how often it may have been missed
+	var victim K
+	low := ^uint64(0)
+	for k, n := range h.cur {
+		if n < low {
+			victim, low = k, n
+		}
+	}
+	delete(h.cur, victim)
+	h.cur[key] = low + 1
+}
+
+// top returns the candidates with their counts over both halves.
+func (h *hotKeys[K]) top(now time.Time) []KeyHits[K] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(now)
+	counts := make(map[K]uint64, len(h.cur)+len(h.prev))
+	for k, n := range h.prev {
+		counts[k] += n
+	}
+	for k, n := range h.cur {
+		counts[k] += n
+	}
+	keys := make([]KeyHits[K], 0, len(counts))
+	for k, n := range counts {
+		keys = append(keys, KeyHits[K]{Key: k, Hits: n})
+	}
+	return keys
+}
+
+// rotate starts a new half window if the current one is over. The caller
+// must hold h.mu.
+func (h *hotKeys[K]) rotate(now time.Time) {
+	elapsed := now.Sub(h.rotated)
+	if elapsed < h.half {
+		return
+	}
+	if elapsed < 2*h.half {
+		h.prev = h.cur
+	} else {
+		// idle for a whole window, nothing recent to keep
+		h.prev = nil
+	}
+	h.cur = make(map[K]uint64, h.size)
+	h.rotated = now
+}
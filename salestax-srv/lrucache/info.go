@@ -0,0 +1,68 @@
+package lrucache
+
+import "time"
+
+// ItemInfo is the metadata of a cached item, for debugging why a key was
+// or was not evicted, or to pick the keys worth warming.
+type ItemInfo struct {
+	Created  time.Time     // when the value was inserted or loaded
+	Accessed time.Time     // last hit, the zero time if none
+	Hits     uint32        // hits since Created
+	Expires  time.Time     // the zero time if the item never expires
+	TTL      time.Duration // left until Expires, 0 if the item never expires
+	Version  uint64        // see CacheItem.Version
+}
+
+// Info returns the metadata of the item at now. Hits and Accessed are
+// those of the item: a value inserted again starts from none.
+func (ci *CacheItem[K, V]) Info(now time.Time) ItemInfo {
+	info := ItemInfo{
+		Created: ci.created,
+		Hits:    ci.hits.Load(),
+		Expires: ci.expires,
+		Version: ci.version,
+	}
+	if ns := ci.accessed.Load(); ns != 0 {
+		info.Accessed = time.Unix(0, ns)
+	}
+	if !ci.expires.IsZero() {
+		info.TTL = max(ci.expires.Sub(now), 0)
+	}
+	return info
+}
+
+// GetWithInfo is Get returning the value and the metadata of its item, this
+// hit included. Peek and CacheItem.Info read the metadata without counting
+// a hit.
+func (c *LRUCache[K, V]) GetWithInfo(key K) (V, ItemInfo, error) {
+	item, err := c.Get(key)
+	if err != nil {
+		var zero V
+		return zero, ItemInfo{}, err
+	}
+	return item.value, item.Info(c.clock.Now()), nil
+}
+
+// PeekWithInfo is Peek returning the metadata of the item as well, its TTL
+// taken at the time of the cache's clock.
+func (c *LRUCache[K, V]) PeekWithInfo(key K) (*CacheItem[K, V], ItemInfo, error) {
+	item, err := c.Peek(key)
+	if err != nil {
+		return nil, ItemInfo{}, err
+	}
+	return item, item.Info(c.clock.Now()), nil
+}
+
+// hit records a hit on the item at now.
+func (ci *CacheItem[K, V]) hit(now time.Time) {
+	ci.hits.Add(1)
+	ci.accessed.Store(now.UnixNano())
+}
+
+// lastUsed returns when the item was last hit, or stored if it never was.
+func (ci *CacheItem[K, V]) lastUsed() time.Time {
+	if ns := ci.accessed.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return ci.created
+}
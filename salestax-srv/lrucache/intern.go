@@ -0,0 +1,102 @@
+package lrucache
+
+import (
+	"hash/maphash"
+	"strings"
+	"sync"
+)
+
+// internShards is the number of independently locked parts of an Interner.
+const internShards = 64
+
+// Interner holds one copy of each key of the caches sharing it, see
+// WithKeyInterner, so that a key cached by several of them, e.g. an address
+// with its rate and its rate history, is stored once. A string key is
+// copied when first interned, so that a key sliced out of a larger buffer,
+// such as a request, does not keep the buffer alive. Keys are counted by the
+// caches holding them and forgotten once none does. An Interner costs a map
+// entry per key: it saves memory when caches share keys or when keys are
+// slices of larger strings, not otherwise.
+type Interner[K comparable] struct {
+	seed   maphash.Seed
+	shards [internShards]internShard[K]
+}
+
+type internShard[K comparable] struct {
+	mu   sync.Mutex
+	keys map[K]internedKey[K]
+}
+
+// internedKey is the copy of a key and the number of items holding it.
+type internedKey[K comparable] struct {
+	key  K
+	refs int
+}
+
+// NewInterner returns an empty Interner.
+func NewInterner[K comparable]() *Interner[K] {
+	in := &Interner[K]{seed: maphash.MakeSeed()}
+	for i := range in.shards {
+		in.shards[i].keys = make(map[K]internedKey[K])
+	}
+	return in
+}
+
+// WithKeyInterner stores the keys of the cache in in, shared with the other
+// caches given the same Interner. K must match the cache being constructed,
+// otherwise New panics.
+func WithKeyInterner[K comparable](in *Interner[K]) Option {
+	return func(o *options) {
+		o.interner = in
+	}
+}
+
+// Len returns the number of distinct keys interned.
+func (in *Interner[K]) Len() int {
+	n := 0
+	for i := range in.shards {
+		sh := &in.shards[i]
+		sh.mu.Lock()
+		n += len(sh.keys)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// intern returns the copy of key held by in, making one if there is none,
+// and counts one more item holding it.
+func (in *Interner[K]) intern(key K) K {
+	sh := in.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.keys[key]
+	if !ok {
+		e.key = key
+		if s, ok := any(key).(string); ok {
+			e.key = any(strings.Clone(s)).(K)
+		}
+	}
+	e.refs++
+	sh.keys[e.key] = e
+	return e.key
+}
+
+// release counts one item less holding key, forgetting it after the last.
+func (in *Interner[K]) release(key K) {
+	sh := in.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.keys[key]
+	switch {
+	case !ok:
+	case e.refs <= 1:
+		delete(sh.keys, key)
+	default:
+		e.refs--
+		sh.keys[key] = e
+	}
+}
+
+func (in *Interner[K]) shard(key K) *internShard[K] {
+	return &in.shards[maphash.Comparable(in.seed, key)%internShards]
+}
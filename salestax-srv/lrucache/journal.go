@@ -0,0 +1,28 @@
+package lrucache
+
+import "time"
+
+// Journal records the changes to the items of a cache, e.g. in a
+// write-ahead log, so that a restarted process can restore them with
+// Restore. Insert is called for every item inserted, written or loaded,
+// and Delete for every item deleted explicitly; items evicted for capacity
+// or expiry are not reported, as replaying the journal evicts them again.
+//
+// Both are called with the lock of the shard of key held, so that the
+// journal sees the changes of each key in the order they were made. They
+// must be fast, e.g. buffering their records, and must not call back into
+// the cache. See WithJournal.
+type Journal[K comparable, V any] interface {
+	Insert(key K, value V, expires time.Time)
+	Delete(key K)
+}
+
+// Restore inserts value for key, expiring at expires or never if it is
+// zero, into this cache only, as LoadSnapshot does: the store and the
+// second tier are left alone. It is for replaying a journal, whose
+// deletions are replayed with Evict. It returns ErrTooLarge for an item
+// heavier than a shard.
+func (c *LRUCache[K, V]) Restore(key K, value V, expires time.Time) error {
+	key = c.normalize(key)
+	return c.shard(key).insert(key, value, expires)
+}
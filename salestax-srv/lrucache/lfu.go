@@ -0,0 +1,119 @@
+package lrucache
+
+import "slices"
+
+// lfuEntry is a key tracked by lfuPolicy.
+type lfuEntry struct {
+	freq int
+	node int
+}
+
+// lfuPolicy evicts the least frequently used key, breaking ties by recency.
+// Keys are kept in one list per access count, so every operation is O(1).
+type lfuPolicy[K comparable] struct {
+	slab    slab[K]
+	entries map[K]lfuEntry
+	freqs   map[int]nodeList // only non-empty lists are kept
+	minFreq int
+}
+
+// NewLFUPolicy returns a least frequently used policy. It retains heavy
+// hitters (e.g. popular ZIP codes) better than LRU, at the price of keeping
+// formerly popular keys around after their traffic stops.
+func NewLFUPolicy[K comparable](capacity int) EvictionPolicy[K] {
+	return &lfuPolicy[K]{
+		slab:    newSlab[K](capacity + 1),
+		entries: make(map[K]lfuEntry, preallocHint(capacity)),
+		freqs:   make(map[int]nodeList),
+	}
+}
+
+// link adds the node of e to the front of its frequency list.
+func (p *lfuPolicy[K]) link(e lfuEntry) {
+	l, ok := p.freqs[e.freq]
+	if !ok {
+		l = p.slab.newList()
+	}
+	p.slab.link(&l, e.node)
+	p.freqs[e.freq] = l
+}
+
+// unlink removes e from its frequency list, dropping the list if it became
+// empty. It reports whether that happened.
+func (p *lfuPolicy[K]) unlink(e lfuEntry) bool {
+	l := p.freqs[e.freq]
+	p.slab.unlink(&l, e.node)
+	if l.len == 0 {
+		p.slab.release(l.head)
+		delete(p.freqs, e.freq)
+		return true
+	}
+	p.freqs[e.freq] = l
+	return false
+}
+
+func (p *lfuPolicy[K]) Add(key K) {
+	e := lfuEntry{freq: 1, node: p.slab.newNode(key)}
+	p.entries[key] = e
+	p.link(e)
+	p.minFreq = 1
+}
+
+func (p *lfuPolicy[K]) Access(key K) {
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	if p.unlink(e) && p.minFreq == e.freq {
+		p.minFreq++
+	}
+	e.freq++
+	p.entries[key] = e
+	p.link(e)
+}
+
+func (p *lfuPolicy[K]) Remove(key K) {
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	delete(p.entries, key)
+	emptied := p.unlink(e)
+	p.slab.release(e.node)
+	if emptied && p.minFreq == e.freq {
+		// the minimum moved to some higher, unknown frequency
+		p.minFreq = 0
+		for f := range p.freqs {
+			if p.minFreq == 0 || f < p.minFreq {
+				p.minFreq = f
+			}
+		}
+	}
+}
+
+func (p *lfuPolicy[K]) Evict(key K) {
+	p.Remove(key)
+}
+
+func (p *lfuPolicy[K]) Keys() []K {
+	freqs := make([]int, 0, len(p.freqs))
+	for f := range p.freqs {
+		freqs = append(freqs, f)
+	}
+	slices.Sort(freqs)
+	keys := make([]K, 0, len(p.entries))
+	for i := len(freqs) - 1; i >= 0; i-- {
+		l := p.freqs[freqs[i]]
+		keys = p.slab.appendKeys(keys, &l)
+	}
+	return keys
+}
+
+func (p *lfuPolicy[K]) Victim() (K, bool) {
+	if l, ok := p.freqs[p.minFreq]; ok {
+		i, _ := p.slab.back(&l)
+		return p.slab.key(i), true
+	}
+	var zero K
+	return zero, false
+}
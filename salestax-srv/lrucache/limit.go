@@ -0,0 +1,108 @@
+package lrucache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// loadLimiter bounds the loader calls of a cache, so that a burst of misses
+// on distinct keys cannot overwhelm a slow backend. A nil *loadLimiter allows
+// everything.
+type loadLimiter struct {
+	sem      chan struct{} // one token per running loader call, nil for no limit
+	bucket   *tokenBucket  // nil for no rate limit
+	failFast bool
+	clock    Clock
+}
+
+func newLoadLimiter(concurrency int, rate float64, failFast bool, clock Clock) *loadLimiter {
+	if concurrency <= 0 && rate <= 0 {
+		return nil
+	}
+	l := &loadLimiter{failFast: failFast, clock: clock}
+	if concurrency > 0 {
+		l.sem = make(chan struct{}, concurrency)
+	}
+	if rate > 0 {
+		burst := max(1, int(rate))
+		l.bucket = &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: clock.Now()}
+	}
+	return l
+}
+
+// acquire waits until a loader call may start, or fails right away if the
+// limiter fails fast. The returned release must be called when the call is
+// done.
+func (l *loadLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.bucket != nil {
+		wait, ok := l.bucket.reserve(l.clock.Now(), !l.failFast)
+		if !ok {
+			return nil, ErrThrottled
+		}
+		if wait > 0 {
+			t := l.clock.NewTimer(wait)
+			select {
+			case <-t.C():
+			case <-ctx.Done():
+				t.Stop()
+				l.bucket.cancel()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if l.sem == nil {
+		return func() {}, nil
+	}
+	if l.failFast {
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			return nil, ErrThrottled
+		}
+	} else {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-l.sem }, nil
+}
+
+// tokenBucket allows rate events per second on average with bursts of up to
+// burst events.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64 // negative while waiters hold reservations
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait before using it. With
+// wait false no token is taken unless one is available right now.
+func (b *tokenBucket) reserve(now time.Time, wait bool) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 && !wait {
+		return 0, false
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// cancel returns a token taken by reserve that was not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens = min(b.burst, b.tokens+1)
+	b.mu.Unlock()
+}
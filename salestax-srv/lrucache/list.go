@@ -0,0 +1,132 @@
+package lrucache
+
+// slab holds the nodes of one or more doubly linked lists of keys. Nodes refer
+// to each other by index instead of by pointer and freed nodes are reused, so
+// once a shard has filled up, inserts and evictions relink existing nodes
+// instead of allocating new ones, and keys are stored as K instead of being
+// boxed into an interface the way container/list stores them. Moving a node
+// between two lists of the same slab never allocates.
+//
+// Each list has a sentinel node: its next is the front of the list and its
+// prev the back, so an empty list is a sentinel linked to itself.
+type slab[K comparable] struct {
+	nodes []node[K]
+	free  int // first free node, chained through next; -1 if none
+}
+
+type node[K comparable] struct {
+	key        K
+	prev, next int
+	list       int // sentinel of the list the node is on
+}
+
+// nodeList is one list of a slab.
+type nodeList struct {
+	head int // sentinel node
+	len  int
+}
+
+// maxPrealloc caps the room that policies and segments reserve up front for
+// their keys, so that a huge capacity does not allocate before any item is
+// stored. Past it, slabs and maps grow as keys are added.
+const maxPrealloc = 1024
+
+// preallocHint returns the room to reserve for capacity keys.
+func preallocHint(capacity int) int {
+	return min(max(capacity, 0), maxPrealloc)
+}
+
+// newSlab returns a slab with room for capacity nodes, sentinels included,
+// before it grows. The room is capped by maxPrealloc.
+func newSlab[K comparable](capacity int) slab[K] {
+	return slab[K]{nodes: make([]node[K], 0, preallocHint(capacity)), free: -1}
+}
+
+// newNode returns an unlinked node holding key.
+func (s *slab[K]) newNode(key K) int {
+	i := s.free
+	if i >= 0 {
+		s.free = s.nodes[i].next
+	} else {
+		i = len(s.nodes)
+		s.nodes = append(s.nodes, node[K]{})
+	}
+	s.nodes[i].key = key
+	return i
+}
+
+// release frees an unlinked node. Its key is cleared so that the slab does
+// not keep it reachable.
+func (s *slab[K]) release(i int) {
+	s.nodes[i] = node[K]{next: s.free}
+	s.free = i
+}
+
+// newList returns an empty list.
+func (s *slab[K]) newList() nodeList {
+	var zero K
+	i := s.newNode(zero)
+	s.nodes[i].prev, s.nodes[i].next, s.nodes[i].list = i, i, i
+	return nodeList{head: i}
+}
+
+// link inserts the unlinked node i at the front of l.
+func (s *slab[K]) link(l *nodeList, i int) {
+	first := s.nodes[l.head].next
+	s.nodes[i].prev, s.nodes[i].next, s.nodes[i].list = l.head, first, l.head
+	s.nodes[first].prev = i
+	s.nodes[l.head].next = i
+	l.len++
+}
+
+// unlink takes node i off l, which must be the list it is on.
+func (s *slab[K]) unlink(l *nodeList, i int) {
+	prev, next := s.nodes[i].prev, s.nodes[i].next
+	s.nodes[prev].next = next
+	s.nodes[next].prev = prev
+	l.len--
+}
+
+// pushFront adds key at the front of l and returns its node.
+func (s *slab[K]) pushFront(l *nodeList, key K) int {
+	i := s.newNode(key)
+	s.link(l, i)
+	return i
+}
+
+// moveToFront moves node i from list from to the front of list to, which
+// may be the same list.
+func (s *slab[K]) moveToFront(from, to *nodeList, i int) {
+	s.unlink(from, i)
+	s.link(to, i)
+}
+
+// remove takes node i off l and frees it.
+func (s *slab[K]) remove(l *nodeList, i int) {
+	s.unlink(l, i)
+	s.release(i)
+}
+
+// back returns the last node of l, or false if l is empty.
+func (s *slab[K]) back(l *nodeList) (int, bool) {
+	i := s.nodes[l.head].prev
+	return i, i != l.head
+}
+
+// key returns the key of node i.
+func (s *slab[K]) key(i int) K {
+	return s.nodes[i].key
+}
+
+// on reports whether node i is on list l.
+func (s *slab[K]) on(l *nodeList, i int) bool {
+	return s.nodes[i].list == l.head
+}
+
+// appendKeys appends the keys of l to keys, front to back.
+func (s *slab[K]) appendKeys(keys []K, l *nodeList) []K {
+	for i := s.nodes[l.head].next; i != l.head; i = s.nodes[i].next {
+		keys = append(keys, s.nodes[i].key)
+	}
+	return keys
+}
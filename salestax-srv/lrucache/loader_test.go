@@ -0,0 +1,183 @@
+package lrucache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache/lrucachetest"
+)
+
+// The tests of this file drive the waits of the loader with the fake clock
+// of lrucachetest, which the tests of package lrucache cannot import.
+
+var errDown = errors.New("backend down")
+
+// load calls GetOrLoadCtx on its own goroutine and returns the channel of
+// its error.
+func load(ctx context.Context, c *lrucache.LRUCache[string, int], key string, loader lrucache.LoaderFuncCtx[string, int]) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetOrLoadCtx(ctx, key, loader)
+		done <- err
+	}()
+	return done
+}
+
+func TestRetry(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	loader := lrucachetest.NewLoader[string, int](clock)
+	loader.Script("a", lrucachetest.Result[int]{Err: errDown}, lrucachetest.Result[int]{Err: errDown}, lrucachetest.Result[int]{Value: 1})
+	c := lrucache.New[string, int](10, lrucache.WithClock(clock),
+		lrucache.WithRetry(lrucache.RetryPolicy{MaxAttempts: 5, Backoff: time.Second}))
+
+	done := load(context.Background(), c, "a", loader.Load)
+	// the first retry waits the backoff, the second twice as long
+	for i, wait := range []time.Duration{time.Second, 2 * time.Second} {
+		clock.WaitForTimers(1)
+		if n := loader.CallCount("a"); n != i+1 {
+			t.Fatalf("%d calls during backoff %d, want %d", n, i+1, i+1)
+		}
+		clock.Advance(wait)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("GetOrLoadCtx = %v, want the third attempt to succeed", err)
+	}
+	if v, err := c.Get("a"); err != nil || v.Value() != 1 {
+		t.Errorf("Get(a) = %v, %v, want 1", v, err)
+	}
+	if st := c.Stats(); st.LoaderCalls != 3 || st.LoaderErrors != 2 || st.Retries != 2 {
+		t.Errorf("stats %d calls, %d errors, %d retries, want 3, 2 and 2", st.LoaderCalls, st.LoaderErrors, st.Retries)
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	loader := lrucachetest.NewLoader[string, int](clock)
+	loader.Script("a", lrucachetest.Result[int]{Err: errDown})
+	c := lrucache.New[string, int](10, lrucache.WithClock(clock),
+		lrucache.WithRetry(lrucache.RetryPolicy{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Second}))
+
+	done := load(context.Background(), c, "a", loader.Load)
+	// MaxBackoff caps the second wait
+	for range 2 {
+		clock.WaitForTimers(1)
+		clock.Advance(time.Second)
+	}
+	if err := <-done; !errors.Is(err, lrucache.ErrLoaderFailed) || !errors.Is(err, errDown) {
+		t.Errorf("GetOrLoadCtx = %v, want the loader error", err)
+	}
+	if n := loader.CallCount("a"); n != 3 {
+		t.Errorf("%d loader calls, want MaxAttempts 3", n)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	errUnknown := errors.New("unknown address")
+	loader := lrucachetest.NewLoader[string, int](nil)
+	loader.Script("a", lrucachetest.Result[int]{Err: errUnknown}, lrucachetest.Result[int]{Value: 1})
+	c := lrucache.New[string, int](10, lrucache.WithClock(lrucachetest.NewClock(time.Now())),
+		lrucache.WithRetry(lrucache.RetryPolicy{
+			MaxAttempts: 5,
+			Retryable:   func(err error) bool { return !errors.Is(err, errUnknown) },
+		}))
+
+	// fails without waiting for a retry, which the fake clock would block
+	if _, err := c.GetOrLoadCtx(context.Background(), "a", loader.Load); !errors.Is(err, errUnknown) {
+		t.Errorf("GetOrLoadCtx = %v, want %v", err, errUnknown)
+	}
+	if n, st := loader.CallCount("a"), c.Stats(); n != 1 || st.Retries != 0 {
+		t.Errorf("%d loader calls and %d retries, want 1 and none", n, st.Retries)
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	loader := lrucachetest.NewLoader[string, int](clock)
+	loader.Script("a", lrucachetest.Result[int]{Err: errDown}, lrucachetest.Result[int]{Value: 1})
+	c := lrucache.New[string, int](10, lrucache.WithClock(clock),
+		lrucache.WithRetry(lrucache.RetryPolicy{MaxAttempts: 5, Backoff: time.Hour}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := load(ctx, c, "a", loader.Load)
+	clock.WaitForTimers(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("GetOrLoadCtx cancelled in the backoff = %v, want context.Canceled", err)
+	}
+	if n := loader.CallCount("a"); n != 1 {
+		t.Errorf("%d loader calls, want no retry once cancelled", n)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	loader := lrucachetest.NewLoader[string, int](clock)
+	loader.Script("hot", lrucachetest.Result[int]{Value: 1}, lrucachetest.Result[int]{Value: 2, Latency: time.Second})
+	loader.Script("cold", lrucachetest.Result[int]{Value: 1}, lrucachetest.Result[int]{Value: 2})
+	c := lrucache.New[string, int](10, lrucache.WithClock(clock), lrucache.WithTTL(time.Minute),
+		lrucache.WithSweepInterval(time.Hour), lrucache.WithRefreshAhead(10*time.Second, 3))
+	defer c.Close()
+	get := func(key string) int {
+		t.Helper()
+		v, err := c.GetOrLoadCtx(context.Background(), key, loader.Load)
+		if err != nil {
+			t.Fatalf("GetOrLoadCtx(%s): %v", key, err)
+		}
+		return v
+	}
+	get("hot")
+	get("cold")
+	for range 3 {
+		get("hot")
+	}
+	get("cold")
+
+	// hits close to the expiry reload the hot item once, serving the
+	// current value meanwhile
+	clock.Advance(50 * time.Second)
+	for range 3 {
+		if v := get("hot"); v != 1 {
+			t.Errorf("GetOrLoadCtx(hot) during the refresh = %d, want 1", v)
+		}
+	}
+	if v := get("cold"); v != 1 {
+		t.Errorf("GetOrLoadCtx(cold) = %d, want 1", v)
+	}
+	clock.WaitForTimers(2) // the sweeper and the reload
+
+	// past the old expiry a lookup of hot either hits the reloaded item or
+	// joins the reload still in flight
+	clock.Advance(11 * time.Second)
+	if v := get("hot"); v != 2 {
+		t.Errorf("GetOrLoadCtx(hot) after the refresh = %d, want the reloaded 2", v)
+	}
+	if hot, cold := loader.CallCount("hot"), loader.CallCount("cold"); hot != 2 || cold != 1 {
+		t.Errorf("loaded hot %d and cold %d times, want 2 and 1", hot, cold)
+	}
+	if st := c.Stats(); st.Refreshes != 1 {
+		t.Errorf("Refreshes = %d, want 1", st.Refreshes)
+	}
+}
+
+func TestRefreshAheadClose(t *testing.T) {
+	clock := lrucachetest.NewClock(time.Now())
+	loader := lrucachetest.NewLoader[string, int](clock)
+	loader.Script("a", lrucachetest.Result[int]{Value: 1}, lrucachetest.Result[int]{Value: 2, Latency: time.Hour})
+	c := lrucache.New[string, int](10, lrucache.WithClock(clock), lrucache.WithTTL(time.Minute),
+		lrucache.WithSweepInterval(time.Hour), lrucache.WithRefreshAhead(time.Minute, 1))
+	c.GetOrLoadCtx(context.Background(), "a", loader.Load)
+	c.GetOrLoadCtx(context.Background(), "a", loader.Load)
+	clock.WaitForTimers(2) // the sweeper and the reload
+
+	// Close cancels the reload rather than wait for its hour
+	c.Close()
+	if item, err := c.Peek("a"); err != nil || item.Value() != 1 {
+		t.Errorf("Peek(a) = %v, %v, want 1 kept", item, err)
+	}
+	if n := loader.CallCount("a"); n != 2 {
+		t.Errorf("%d loader calls, want the load and the cancelled reload", n)
+	}
+}
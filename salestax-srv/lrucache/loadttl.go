@@ -0,0 +1,75 @@
+package lrucache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// LoaderFuncTTL is a LoaderFunc that also returns how long its value is
+// cached, e.g. shorter for the rates of a special event district than for
+// the others. A positive TTL overrides that of the cache for this value, in
+// the cache and in the second tier; zero or less keeps the TTL of the cache.
+type LoaderFuncTTL[K comparable, V any] func(K) (V, time.Duration, error)
+
+// LoaderFuncTTLCtx is a LoaderFuncTTL that receives the caller's context.
+type LoaderFuncTTLCtx[K comparable, V any] func(context.Context, K) (V, time.Duration, error)
+
+// WithContext adapts a context unaware loader to LoaderFuncTTLCtx. The
+// context is ignored. A nil loader stays nil.
+func (fn LoaderFuncTTL[K, V]) WithContext() LoaderFuncTTLCtx[K, V] {
+	if fn == nil {
+		return nil
+	}
+	return func(_ context.Context, key K) (V, time.Duration, error) {
+		return fn(key)
+	}
+}
+
+// Loader adapts fn to LoaderFuncCtx, for WithLoaderCtx and the other places
+// taking one. The TTLs it returns are still honored by the cache that calls
+// it, including for background refreshes. A nil loader stays nil.
+func (fn LoaderFuncTTLCtx[K, V]) Loader() LoaderFuncCtx[K, V] {
+	if fn == nil {
+		return nil
+	}
+	return func(ctx context.Context, key K) (V, error) {
+		value, ttl, err := fn(ctx, key)
+		if err == nil && ttl > 0 {
+			if d, ok := ctx.Value(loadTTLKey{}).(*atomic.Int64); ok {
+				d.Store(int64(ttl))
+			}
+		}
+		return value, err
+	}
+}
+
+// GetOrLoadTTL is GetOrLoad with a loader choosing the TTL of each value it
+// loads.
+func (c *LRUCache[K, V]) GetOrLoadTTL(key K, loader LoaderFuncTTL[K, V]) (V, error) {
+	return c.GetOrLoadTTLCtx(context.Background(), key, loader.WithContext())
+}
+
+// GetOrLoadTTLCtx is GetOrLoadTTL with a context passed to the loader.
+func (c *LRUCache[K, V]) GetOrLoadTTLCtx(ctx context.Context, key K, loader LoaderFuncTTLCtx[K, V]) (V, error) {
+	return c.GetOrLoadCtx(ctx, key, loader.Loader())
+}
+
+// loadTTLKey is the context key of the TTL returned by the loader of a
+// load, a *atomic.Int64 set by LoaderFuncTTLCtx.Loader.
+type loadTTLKey struct{}
+
+// loadTTL returns the TTL of a value loaded with the context returned by
+// withLoadTTL, the TTL of the cache unless the loader chose one.
+func (c *LRUCache[K, V]) loadTTL(ctx context.Context) time.Duration {
+	if d := ctx.Value(loadTTLKey{}).(*atomic.Int64).Load(); d > 0 {
+		return time.Duration(d)
+	}
+	return c.TTL()
+}
+
+// withLoadTTL returns ctx set up for the loader to return its TTL to
+// loadTTL.
+func withLoadTTL(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadTTLKey{}, new(atomic.Int64))
+}
@@ -0,0 +1,66 @@
+package lrucache
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// DefaultSlowLoad is the loader latency above which a load is logged as slow,
+// see WithSlowLoadThreshold.
+const DefaultSlowLoad = 100 * time.Millisecond
+
+// Tracer observes lookups made through GetOrLoad and Lookup. StartLookup
+// is called when a lookup starts and may return a derived context, which is
+// passed on to the loader; end is called once the lookup has finished. hit
+// reports whether the value was served from the cache without waiting for
+// a load.
+type Tracer[K comparable] interface {
+	StartLookup(ctx context.Context, key K) (_ context.Context, end func(hit bool, err error))
+}
+
+// multiTracer reports lookups to several tracers, see WithTracer.
+type multiTracer[K comparable] []Tracer[K]
+
+func (m multiTracer[K]) StartLookup(ctx context.Context, key K) (context.Context, func(bool, error)) {
+	ends := make([]func(bool, error), len(m))
+	for i, t := range m {
+		ctx, ends[i] = t.StartLookup(ctx, key)
+	}
+	return ctx, func(hit bool, err error) {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i](hit, err)
+		}
+	}
+}
+
+// logEvictions wraps onEvict so that every removal is logged at debug level
+// before the user callback, if any, runs.
+func logEvictions[K comparable, V any](logger *slog.Logger, onEvict OnEvictFunc[K, V]) OnEvictFunc[K, V] {
+	return func(key K, value V, reason EvictReason) {
+		logger.Debug("lrucache: item removed", "key", key, "reason", reason.String())
+		if onEvict != nil {
+			onEvict(key, value, reason)
+		}
+	}
+}
+
+// logLoad logs a failed or slow loader call at debug level.
+func (c *LRUCache[K, V]) logLoad(ctx context.Context, key K, d time.Duration, err error) {
+	if c.logger == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		c.logger.DebugContext(ctx, "lrucache: loader failed", "key", key, "duration", d, "err", err)
+	case d >= c.slowLoad:
+		c.logger.DebugContext(ctx, "lrucache: slow load", "key", key, "duration", d)
+	}
+}
+
+// debug logs msg at debug level if a logger is configured.
+func (c *LRUCache[K, V]) debug(msg string, args ...any) {
+	if c.logger != nil {
+		c.logger.Debug(msg, args...)
+	}
+}
@@ -1,137 +1,806 @@
-// Package lrucache implements a LRU cache server for string keys and double
-// values (associated tax value at address).
+// Package lrucache implements a generic LRU cache server. It was originally
+// written for string keys and double values (associated tax value at address),
+// see the salestax package for that API.
 //
 // It is a simple cache server with an LRU (least recently used) eviction policy.
 // It utilizes unordered map (i.e. hash table) and list to provide O(1) insertion
-// and lookup.
+// and lookup. Other eviction policies (LFU, FIFO, random, ARC, SLRU) can be selected
+// with WithPolicy. Optionally the keys are partitioned across several
+// independently locked shards (WithShards) to reduce lock contention. The
+// capacity is an item count by default, or a weight budget such as bytes with
+// WithWeigher and NewWithMaxBytes. For tens of millions of small values, the
+// compact package stores entries the garbage collector does not scan, as a
+// cache of its own or as the Engine of an LRUCache, see WithEngine.
 package lrucache
 
 import (
-	"container/list"
-	"errors"
-	"math"
+	"context"
+	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // LRUCache is a concurrent/thread safe implementation of a LRU Cache server.
-type LRUCache struct {
-	size  int
-	list  *list.List
-	cache map[interface{}]*list.Element
-	mutex sync.RWMutex
+type LRUCache[K comparable, V any] struct {
+	size   int // guarded by sizeMu
+	full   int // size set by New or Resize, guarded by sizeMu; see WithMemoryPressure
+	sizeMu sync.Mutex
+	shards []*segment[K, V]
+	hash   hasher[K]
+	rand   *random // nil for the global source
+	stats  counters
+
+	ttl       atomic.Int64 // time.Duration, see SetTTL
+	negTTL    atomic.Int64 // time.Duration, see SetNegativeTTL
+	stale     time.Duration
+	ahead     time.Duration
+	aheadHits uint32
+	timeout   time.Duration
+	hotKeys   int
+	loader    LoaderFuncCtx[K, V]
+	limit     *loadLimiter // nil if not configured
+	breaker   *breaker     // nil if not configured
+	retry     *RetryPolicy // nil if not configured
+
+	store        Store[K, V]        // write-through, nil if not configured
+	behind       *writeBehind[K, V] // write-behind, nil if not configured
+	onStoreError func(error)
+	l2           SecondTier[K, V] // nil if not configured
+
+	logger   *slog.Logger // nil if not configured
+	slowLoad time.Duration
+	tracer   Tracer[K]           // nil if not configured
+	norm     func(K) K           // nil if not configured
+	canon    KeyCanonicalizer[K] // nil if not configured
+	clock    Clock
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	// background refreshes, which Close cancels and waits for
+	refreshCtx  context.Context
+	stopRefresh context.CancelFunc
+	refreshMu   sync.Mutex // guards closed, so that none starts during Close
+	refreshWG   sync.WaitGroup
+	closed      bool
+}
+
+// CacheItem hold the key/value pairs in the LRUCache.
+type CacheItem[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time // zero means the item never expires
+	cost    int       // weight charged against the shard capacity
+	version uint64    // see Version
+	created time.Time // when the item was stored
+
+	referenced atomic.Bool   // hit since last eviction scan (approximate LRU)
+	hits       atomic.Uint32 // hits since the item was stored
+	accessed   atomic.Int64  // Unix nanoseconds of the last hit, 0 for none
+	refreshing atomic.Bool   // a refresh-ahead reload has been started
+}
+
+// Key returns the key the item is stored under.
+func (ci *CacheItem[K, V]) Key() K {
+	return ci.key
+}
+
+// Value returns the cached value.
+func (ci *CacheItem[K, V]) Value() V {
+	return ci.value
+}
+
+// Expires returns the time after which the item is no longer served. The
+// zero time means the item never expires.
+func (ci *CacheItem[K, V]) Expires() time.Time {
+	return ci.expires
 }
 
-// CacheItem hold the key/value pairs in the LRUCache. Intentionally stayed
-// away from interface{} to avoid generics overhead since it does not appear
-// to be valuable in this case.
-type CacheItem struct {
-	key   string
-	value float64
+// Version identifies this value of the key for InsertIfVersion. Every
+// insert of a key, including loads, gives it a new version; versions are
+// never reused, even after the key was evicted and inserted again.
+func (ci *CacheItem[K, V]) Version() uint64 {
+	return ci.version
 }
 
-// LoaderFunc is a function that matches the signiture of sales_tax_lookup.
-type LoaderFunc func(string) (float64, error)
+func (ci *CacheItem[K, V]) expired(now time.Time) bool {
+	return !ci.expires.IsZero() && now.After(ci.expires)
+}
+
+// negativeEntry is a remembered loader failure.
+type negativeEntry struct {
+	err     error
+	expires time.Time
+}
 
-// New returns a pointer to an initialized LRUCache structure.
-func New(sz int) *LRUCache {
+// LoaderFunc is a function that matches the signiture of sales_tax_lookup,
+// generalized to any key and value type.
+type LoaderFunc[K comparable, V any] func(K) (V, error)
+
+// LoaderFuncCtx is a LoaderFunc that receives the caller's context so that
+// cancellation and deadlines reach the slow backend lookup.
+type LoaderFuncCtx[K comparable, V any] func(context.Context, K) (V, error)
+
+// WithContext adapts a context unaware loader to LoaderFuncCtx. The context is
+// ignored. A nil loader stays nil.
+func (fn LoaderFunc[K, V]) WithContext() LoaderFuncCtx[K, V] {
+	if fn == nil {
+		return nil
+	}
+	return func(_ context.Context, key K) (V, error) {
+		return fn(key)
+	}
+}
+
+// BatchLoaderFunc loads several keys in one backend round trip. Keys that
+// cannot be resolved are simply left out of the returned map.
+type BatchLoaderFunc[K comparable, V any] func([]K) (map[K]V, error)
+
+// BatchLoaderFuncCtx is a BatchLoaderFunc that receives the caller's context.
+type BatchLoaderFuncCtx[K comparable, V any] func(context.Context, []K) (map[K]V, error)
+
+// New returns a pointer to an initialized LRUCache structure. If a sweep
+// interval is configured the caller must call Close when done with the cache.
+func New[K comparable, V any](sz int, opts ...Option) *LRUCache[K, V] {
 	if sz <= 0 {
 		panic("LRUCache size too small (<=0)")
 	}
-	c := &LRUCache{
-		size:  sz,
-		list:  list.New(),
-		cache: make(map[interface{}]*list.Element, sz+1),
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	n := o.shards
+	if n < 1 {
+		n = 1
+	}
+	if n > sz {
+		n = sz
+	}
+	var engine EngineFactory[K, V]
+	if o.engine != nil {
+		factory, ok := o.engine.(EngineFactory[K, V])
+		if !ok {
+			panic("LRUCache engine does not match the cache key/value types")
+		}
+		if o.policy != nil || o.weigher != nil || o.approximate || o.refreshAhead > 0 || o.interner != nil {
+			panic("LRUCache engine cannot be combined with per-item options")
+		}
+		engine = factory
+	}
+	newPolicy := PolicyFactory[K](NewLRUPolicy[K])
+	if o.policy != nil {
+		factory, ok := o.policy.(PolicyFactory[K])
+		if !ok {
+			panic("LRUCache policy does not match the cache key type")
+		}
+		newPolicy = factory
+	}
+	var onEvict OnEvictFunc[K, V]
+	if o.onEvict != nil {
+		fn, ok := o.onEvict.(OnEvictFunc[K, V])
+		if !ok {
+			panic("LRUCache eviction callback does not match the cache key/value types")
+		}
+		onEvict = fn
+	}
+	if o.logger != nil {
+		onEvict = logEvictions(o.logger, onEvict)
+	}
+	var journal Journal[K, V]
+	if o.journal != nil {
+		j, ok := o.journal.(Journal[K, V])
+		if !ok {
+			panic("LRUCache journal does not match the cache key/value types")
+		}
+		journal = j
+	}
+	var weigher WeigherFunc[K, V]
+	if o.weigher != nil {
+		fn, ok := o.weigher.(WeigherFunc[K, V])
+		if !ok {
+			panic("LRUCache weigher does not match the cache key/value types")
+		}
+		weigher = fn
+	}
+	var interner *Interner[K]
+	if o.interner != nil {
+		in, ok := o.interner.(*Interner[K])
+		if !ok {
+			panic("LRUCache key interner does not match the cache key type")
+		}
+		interner = in
+	}
+	var pressure MemoryPressure
+	if o.pressure != nil {
+		pressure = o.pressure.withDefaults()
+	}
+	clock := o.clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	r := newRandom(o.rand)
+	c := &LRUCache[K, V]{
+		size:      sz,
+		full:      sz,
+		shards:    make([]*segment[K, V], n),
+		hash:      newHasher[K](r),
+		rand:      r,
+		stale:     o.stale,
+		ahead:     o.refreshAhead,
+		aheadHits: uint32(max(1, o.refreshAheadHits)),
+		done:      make(chan struct{}),
+		logger:    o.logger,
+		slowLoad:  o.slowLoad,
+		limit:     newLoadLimiter(o.loaderConcurrency, o.loaderRate, o.loaderFailFast, clock),
+		breaker:   newBreaker(o.breakerFailures, o.breakerCooldown),
+		retry:     o.retry,
+		timeout:   o.loaderTimeout,
+		hotKeys:   o.hotKeys,
+		clock:     clock,
+	}
+	c.refreshCtx, c.stopRefresh = context.WithCancel(context.Background())
+	c.ttl.Store(int64(o.ttl))
+	c.negTTL.Store(int64(max(o.negativeTTL, 0)))
+	if c.slowLoad <= 0 {
+		c.slowLoad = DefaultSlowLoad
+	}
+	c.stats.windows = newStatWindows(o.statsWindows, clock)
+	cfg := segmentConfig[K, V]{
+		negative: o.negativeTTL > 0,
+		sweep:    o.sweepInterval > 0,
+		tinyLFU:  o.tinyLFU,
+		hotKeys:  o.hotKeys,
+		hotTime:  o.hotKeysWindow,
+		approx:   o.approximate,
+		onEvict:  onEvict,
+		journal:  journal,
+		weigher:  weigher,
+		stale:    o.stale,
+		stats:    &c.stats,
+		clock:    clock,
+		rand:     r,
+		interner: interner,
+		engine:   engine,
+	}
+	for i := range c.shards {
+		size := shardSize(sz, n, i)
+		var policy EvictionPolicy[K]
+		if engine == nil {
+			policy = newPolicy(itemCapacity(size, weigher != nil))
+		}
+		c.shards[i] = newSegment[K, V](size, policy, cfg)
+	}
+	if o.loader != nil {
+		loader, ok := o.loader.(LoaderFuncCtx[K, V])
+		if !ok {
+			panic("LRUCache loader does not match the cache key/value types")
+		}
+		c.loader = loader
+	}
+	if o.store != nil {
+		store, ok := o.store.(Store[K, V])
+		if !ok {
+			panic("LRUCache store does not match the cache key/value types")
+		}
+		if c.loader == nil {
+			c.loader = store.Get
+		}
+		c.onStoreError = o.onStoreError
+		if o.writeBehind {
+			c.behind = newWriteBehind(store, o.storeBatch, o.storeInterval, o.onStoreError, &c.stats, clock)
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.behind.run(c.done)
+			}()
+		} else {
+			c.store = store
+		}
+	}
+	if o.l2 != nil {
+		l2, ok := o.l2.(SecondTier[K, V])
+		if !ok {
+			panic("LRUCache second tier does not match the cache key/value types")
+		}
+		c.l2 = l2
+	}
+	var tracers multiTracer[K]
+	for _, t := range o.tracers {
+		tracer, ok := t.(Tracer[K])
+		if !ok {
+			panic("LRUCache tracer does not match the cache key type")
+		}
+		tracers = append(tracers, tracer)
+	}
+	switch len(tracers) {
+	case 0:
+	case 1:
+		c.tracer = tracers[0]
+	default:
+		c.tracer = tracers
+	}
+	if o.normalize != nil {
+		norm, ok := o.normalize.(func(K) K)
+		if !ok {
+			panic("LRUCache key normalizer does not match the cache key type")
+		}
+		c.norm = norm
+	}
+	if o.canonicalizer != nil {
+		canon, ok := o.canonicalizer.(KeyCanonicalizer[K])
+		if !ok {
+			panic("LRUCache key canonicalizer does not match the cache key type")
+		}
+		c.canon = canon
+	}
+	if o.sweepInterval > 0 {
+		c.wg.Add(1)
+		go c.sweeper(o.sweepInterval)
+	}
+	if o.pressure != nil {
+		c.wg.Add(1)
+		go c.relieve(pressure)
 	}
 	return c
 }
 
-// FastRateLookup implements the requested speed up utlizing the underlying
-// LRUCache. It is expected that the user of this function will provide
-// sales_tax_lookup routine as the second parameter to the function (fptr). This
-// enables automatic slow lookup with caching in the event that a cache miss occurs.
+// Close cancels the background refreshes in flight and waits for them,
+// stops the background sweeper and flushes pending write-behind writes, if
+// any. The cache remains usable afterwards; expired items are still dropped
+// lazily on lookup, store writes become synchronous and no background
+// refreshes start anymore.
+func (c *LRUCache[K, V]) Close() error {
+	c.closeOnce.Do(func() {
+		c.refreshMu.Lock()
+		c.closed = true
+		c.refreshMu.Unlock()
+		c.stopRefresh()
+		c.refreshWG.Wait()
+		close(c.done)
+	})
+	c.wg.Wait()
+	return nil
+}
+
+// GetOrLoad returns the value cached for key. On a miss it calls loader,
+// caches the value it returns and returns it:
+//
+//   - hit: the cached value and a nil error; loader is not called.
+//   - miss, loader succeeds: the loaded value, which is now cached, and a
+//     nil error.
+//   - miss, loader fails: the zero value of V and an error wrapping both
+//     ErrLoaderFailed and the loader's error, for errors.Is and errors.As.
+//     Nothing is cached unless WithNegativeTTL is set.
+//   - miss, loader is nil: the zero value and ErrNotFound or ErrExpired.
 //
-// Subtle difference.  Get/Set return *CacheItem / FastRateLookup returns value type (float64)
-func (c *LRUCache) FastRateLookup(key string, loader LoaderFunc) (float64, error) {
-	taxRate := math.NaN()
+// Concurrent misses on the same key are coalesced into a single loader call
+// whose result and error are shared by all waiting callers. No cache lock is
+// held while the loader runs, so a slow load only delays the callers of its
+// own key: hits and loads of other keys proceed. With a second
+// tier configured it is consulted before the loader, even if loader is nil.
+func (c *LRUCache[K, V]) GetOrLoad(key K, loader LoaderFunc[K, V]) (V, error) {
+	return c.GetOrLoadCtx(context.Background(), key, loader.WithContext())
+}
+
+// GetOrLoadCtx is GetOrLoad with a context. A caller returns ctx.Err() once
+// ctx is done, without cancelling the load. Because the load is shared, it
+// runs with the values of the context of the caller that started it, but not
+// its deadline or cancellation: that caller going away does not fail the
+// others waiting. Use WithLoaderTimeout to bound the loader calls. A loader
+// that panics fails the load with ErrLoaderFailed.
+func (c *LRUCache[K, V]) GetOrLoadCtx(ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (V, error) {
+	key = c.normalize(key)
+	if c.canon != nil {
+		ck := c.canonical(ctx, key)
+		loader = loaderFor(ck, key, loader)
+		key = ck
+	}
+	if c.tracer == nil {
+		value, _, err := c.lookup(ctx, key, loader)
+		return value, err
+	}
+	ctx, end := c.tracer.StartLookup(ctx, key)
+	value, hit, err := c.lookup(ctx, key, loader)
+	end(hit, err)
+	return value, err
+}
+
+// FastRateLookup is the original name of GetOrLoad.
+//
+// Deprecated: Use GetOrLoad.
+func (c *LRUCache[K, V]) FastRateLookup(key K, loader LoaderFunc[K, V]) (V, error) {
+	return c.GetOrLoad(key, loader)
+}
+
+// FastRateLookupCtx is the original name of GetOrLoadCtx.
+//
+// Deprecated: Use GetOrLoadCtx.
+func (c *LRUCache[K, V]) FastRateLookupCtx(ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (V, error) {
+	return c.GetOrLoadCtx(ctx, key, loader)
+}
+
+// lookup implements GetOrLoadCtx. hit reports whether the value was
+// served without a load, including stale values.
+func (c *LRUCache[K, V]) lookup(ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (_ V, hit bool, _ error) {
+	var value V
 
 	// test to see if key exists in the cache
-	if val, err := c.Get(key); err == nil {
-		taxRate = val.value
+	if val, item, err := c.shard(key).value(key); err == nil {
+		value = val
+		hit = true
+		if loader != nil && c.ahead > 0 {
+			c.refreshAhead(item, loader)
+		}
 	} else {
-		// cache miss but a loader function has been provided
-		if loader != nil {
-			// slow lookup using user provided routine
-			taxRate, err := loader(key)
-			if err != nil {
-				return math.NaN(), errors.New("Using provided data acquistion routine")
+		// cache miss but a loader function or second tier has been provided
+		if loader != nil || c.l2 != nil {
+			// expired but within the stale window: serve it and refresh
+			if item := c.shard(key).staleItem(key); item != nil {
+				c.stats.staleHits.Add(1)
+				c.refresh(key, loader)
+				return item.value, true, nil
+			}
+
+			// known bad key, don't hit the backend again until it expires
+			if nerr := c.shard(key).negativeLookup(key); nerr != nil {
+				var zero V
+				return zero, false, nerr
 			}
-			// insert value retreived from user provided routine into cache
-			c.Insert(key, taxRate)
+
+			// slow lookup using user provided routine, shared with any
+			// other callers missing on the same key: it must outlive
+			// the caller that started it, WithLoaderTimeout bounds it
+			shared := context.WithoutCancel(ctx)
+			value, err = c.shard(key).loads.do(ctx, key, func() (V, error) {
+				return c.load(shared, key, loader)
+			})
 			if err != nil {
-				return math.NaN(), errors.New("Value insertion into cache failed")
+				var zero V
+				return zero, false, err
 			}
 		} else {
 			// Cache miss with no user provided data loader, return error
-			return math.NaN(), err
+			var zero V
+			return zero, false, err
+		}
+	}
+
+	return value, hit, nil
+}
+
+// Lookup returns the value for key, calling the loader configured with
+// WithLoader on a cache miss. Without a configured loader it only consults
+// the cache.
+func (c *LRUCache[K, V]) Lookup(key K) (V, error) {
+	return c.LookupCtx(context.Background(), key)
+}
+
+// LookupCtx is Lookup with a context passed to the configured loader.
+func (c *LRUCache[K, V]) LookupCtx(ctx context.Context, key K) (V, error) {
+	return c.GetOrLoadCtx(ctx, key, c.loader)
+}
+
+// load fetches key from the second tier or else calls loader, and inserts
+// the result into the cache. Loaded values are written to the second tier.
+func (c *LRUCache[K, V]) load(ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (V, error) {
+	if c.l2 != nil {
+		if value, ok := c.l2Get(ctx, key); ok {
+			if err := c.shard(key).admit(key, value, c.expiry(c.TTL())); err != nil {
+				var zero V
+				return zero, fmt.Errorf("Value insertion into cache failed: %w", err)
+			}
+			return value, nil
+		}
+		if loader == nil {
+			var zero V
+			return zero, ErrNotFound
 		}
 	}
 
-	return taxRate, nil
+	ok, trial := c.breaker.allow(c.clock.Now())
+	if !ok {
+		c.stats.unavailable.Add(1)
+		var zero V
+		return zero, ErrBackendUnavailable
+	}
+	release, err := c.limit.acquire(ctx)
+	if err != nil {
+		c.breakerDone(trial, loadAbandoned)
+		c.stats.throttled.Add(1)
+		var zero V
+		return zero, err
+	}
+	ctx = withLoadTTL(ctx)
+	value, err := c.callLoader(ctx, key, loader)
+	release()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// the caller gave up, that says nothing about the key itself
+			c.breakerDone(trial, loadAbandoned)
+			var zero V
+			return zero, ctxErr
+		}
+		c.breakerDone(trial, loadFailed)
+		err = fmt.Errorf("%w: %w", ErrLoaderFailed, err)
+		if negTTL := c.NegativeTTL(); negTTL > 0 {
+			c.shard(key).rememberFailure(key, err, c.clock.Now().Add(negTTL))
+		}
+		var zero V
+		return zero, err
+	}
+	c.breakerDone(trial, loadSucceeded)
+	// insert value retreived from user provided routine into cache, it
+	// came from the backend so it is not written to the store
+	ttl := c.loadTTL(ctx)
+	if err := c.shard(key).admit(key, value, c.expiry(ttl)); err != nil {
+		var zero V
+		return zero, fmt.Errorf("Value insertion into cache failed: %w", err)
+	}
+	if c.l2 != nil {
+		c.l2Set(ctx, key, value, ttl)
+	}
+	return value, nil
 }
 
-// Get tests to see if a key exists in the cache. If it does not, an error
-// is returned. If the key is found, error is set to nil and a pointer to the CacheItem
+// refreshAhead counts a hit on item and reloads it in the background once it
+// is hot and close to expiring. Each item is refreshed at most once; the
+// reloaded value is a new item that starts counting from zero.
+func (c *LRUCache[K, V]) refreshAhead(item *CacheItem[K, V], loader LoaderFuncCtx[K, V]) {
+	hits := item.hits.Load()
+	if item.expires.IsZero() || item.expires.Sub(c.clock.Now()) > c.ahead || hits < c.aheadHits {
+		return
+	}
+	if item.refreshing.CompareAndSwap(false, true) {
+		c.refresh(item.key, loader)
+	}
+}
+
+// refresh reloads key in the background unless a load for it is already in
+// flight, the key is negatively cached or the cache is closed. The load is
+// not tied to any caller's context but is cancelled by Close.
+func (c *LRUCache[K, V]) refresh(key K, loader LoaderFuncCtx[K, V]) {
+	if c.shard(key).negativeLookup(key) != nil {
+		return
+	}
+	c.refreshMu.Lock()
+	if c.closed {
+		c.refreshMu.Unlock()
+		return
+	}
+	c.refreshWG.Add(1)
+	c.refreshMu.Unlock()
+	started := c.shard(key).loads.start(key, func() (V, error) {
+		defer c.refreshWG.Done()
+		return c.load(c.refreshCtx, key, loader)
+	})
+	if started {
+		c.stats.refreshes.Add(1)
+	} else {
+		c.refreshWG.Done()
+	}
+}
+
+// Get tests to see if a key exists in the cache. If it does not, ErrNotFound
+// (or ErrExpired) is returned. If the key is found, error is set to nil and a pointer to the CacheItem
 // is returned.
-func (c *LRUCache) Get(key string) (*CacheItem, error) {
-	c.mutex.RLock()
-	elem, exists := c.cache[key]
-	c.mutex.RUnlock()
-
-	if exists {
-		item := elem.Value.(*CacheItem)
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
-		c.list.MoveToFront(elem)
+func (c *LRUCache[K, V]) Get(key K) (*CacheItem[K, V], error) {
+	key = c.cacheKey(context.Background(), key)
+	return c.shard(key).get(key)
+}
+
+// Peek is Get without side effects: the eviction order, the hit/miss counters
+// and expired items are left untouched, so monitoring tools can inspect the
+// cache without distorting it.
+func (c *LRUCache[K, V]) Peek(key K) (*CacheItem[K, V], error) {
+	key = c.cacheKey(context.Background(), key)
+	if item, ok := c.shard(key).peek(key); ok {
 		return item, nil
 	}
-	return nil, errors.New("Key not found")
+	return nil, ErrNotFound
 }
 
-// Insert inserts a key value pair into the LRUCache. It returns an error
-// if necessary.
-func (c *LRUCache) Insert(key string, value float64) error {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// Contains reports whether key is in the cache and not expired, without
+// updating its recency.
+func (c *LRUCache[K, V]) Contains(key K) bool {
+	key = c.cacheKey(context.Background(), key)
+	_, ok := c.shard(key).peek(key)
+	return ok
+}
 
-	// test to see if elem exists in cache
-	if elem, exists := c.cache[key]; exists {
-		c.list.MoveToFront(elem)
-		item := elem.Value.(*CacheItem)
-		item.value = value
-	} else {
+// Insert inserts a key value pair into the LRUCache using the cache-wide TTL.
+// It returns an error if necessary.
+func (c *LRUCache[K, V]) Insert(key K, value V) error {
+	return c.InsertWithTTL(key, value, c.TTL())
+}
 
-		// test if cache is full
-		if c.list.Len() >= c.size {
-			c.prune(1)
-		}
-		ci := &CacheItem{
-			key:   key,
-			value: value,
+// InsertWithTTL inserts a key value pair that expires after ttl, overriding
+// the cache-wide TTL. A zero or negative ttl means the item never expires.
+// With a weigher configured, a value heavier than its shard's capacity is
+// rejected with an error and the cache is left unchanged. With a store
+// configured the value is also written to it, see WithWriteThrough.
+func (c *LRUCache[K, V]) InsertWithTTL(key K, value V, ttl time.Duration) error {
+	key = c.cacheKey(context.Background(), key)
+	if c.store != nil {
+		if err := c.storeSet(key, value); err != nil {
+			return err
 		}
-		c.cache[key] = c.list.PushFront(ci)
+	}
+	if err := c.shard(key).insert(key, value, c.expiry(ttl)); err != nil {
+		return err
+	}
+	if c.behind != nil {
+		c.storeSet(key, value)
+	}
+	if c.l2 != nil {
+		c.l2Set(context.Background(), key, value, ttl)
 	}
 	return nil
 }
 
-func (c *LRUCache) prune(n int) error {
-	for i := 0; i < n; i++ {
-		elem := c.list.Back()
-		if elem == nil {
-			return nil
+// Delete removes key from the cache, and from the store if one is configured.
+// It reports whether the key was present in the cache.
+func (c *LRUCache[K, V]) Delete(key K) bool {
+	key = c.cacheKey(context.Background(), key)
+	c.storeDelete(key)
+	if c.l2 != nil {
+		c.l2Delete(key)
+	}
+	return c.shard(key).delete(key)
+}
+
+// Evict removes key from this cache only, leaving the store and the second
+// tier alone, and reports whether it was present. It is for copies made
+// stale by a write of another instance sharing them, which already updated
+// the shared tiers.
+func (c *LRUCache[K, V]) Evict(key K) bool {
+	key = c.cacheKey(context.Background(), key)
+	return c.shard(key).delete(key)
+}
+
+// DeleteFunc removes every key for which match returns true and returns the
+// number of items removed. match is called with a shard lock held and must
+// not call back into the cache.
+func (c *LRUCache[K, V]) DeleteFunc(match func(key K) bool) int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.deleteFunc(match)
+	}
+	return n
+}
+
+// Purge removes every item from the cache. Eviction callbacks are fired with
+// EvictDeleted for each of them.
+func (c *LRUCache[K, V]) Purge() {
+	for _, s := range c.shards {
+		s.deleteFunc(nil)
+	}
+}
+
+// Len returns the number of items in the cache, including expired items that
+// have not been removed yet.
+func (c *LRUCache[K, V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.len()
+	}
+	return n
+}
+
+// Keys returns the keys of all live items in eviction order, the next victim
+// last (for the default policy: most recently used first). With several
+// shards the order only holds within a shard: keys are listed shard by shard.
+// Policies without an inherent order (random) list keys in no particular
+// order.
+func (c *LRUCache[K, V]) Keys() []K {
+	var keys []K
+	for _, s := range c.shards {
+		for _, item := range s.snapshot() {
+			keys = append(keys, item.key)
+		}
+	}
+	return keys
+}
+
+// Range calls fn for every live item in the order of Keys until fn returns
+// false. Each shard is snapshotted under its read lock just before it is
+// visited, so fn sees a consistent view of one shard at a time, may call back
+// into the cache, and does not observe writes made to a shard after it was
+// snapshotted.
+func (c *LRUCache[K, V]) Range(fn func(key K, value V) bool) {
+	for _, s := range c.shards {
+		for _, item := range s.snapshot() {
+			if !fn(item.key, item.value) {
+				return
+			}
+		}
+	}
+}
+
+// Cap returns the maximum number of items the cache holds, or its weight
+// budget if a weigher is configured. Under WithMemoryPressure it is less than
+// the size given to New or Resize while the cache is shrunk.
+func (c *LRUCache[K, V]) Cap() int {
+	c.sizeMu.Lock()
+	defer c.sizeMu.Unlock()
+	return c.size
+}
+
+// Resize changes the capacity of the cache at runtime. When shrinking, items
+// are evicted as chosen by the eviction policy (firing eviction callbacks
+// with EvictCapacity) until the cache fits. The shard count is fixed at
+// construction, so sz is raised to at least one item per shard; sz <= 0
+// panics like it does in New. Under WithMemoryPressure sz is also the size
+// the cache regrows to.
+func (c *LRUCache[K, V]) Resize(sz int) {
+	if sz <= 0 {
+		panic("LRUCache size too small (<=0)")
+	}
+	sz = max(sz, len(c.shards))
+
+	c.sizeMu.Lock()
+	defer c.sizeMu.Unlock()
+	c.full = sz
+	c.resizeLocked(sz, EvictCapacity)
+}
+
+// resizeLocked sets the capacity to sz, evicting items for reason. The
+// caller must hold sizeMu.
+func (c *LRUCache[K, V]) resizeLocked(sz int, reason EvictReason) {
+	n := len(c.shards)
+	c.size = sz
+	for i, s := range c.shards {
+		s.resize(shardSize(sz, n, i), reason)
+	}
+}
+
+// shardSize spreads the capacity sz over n shards so that the shard sizes
+// add up to exactly sz.
+func shardSize(sz, n, i int) int {
+	if i < sz%n {
+		return sz/n + 1
+	}
+	return sz / n
+}
+
+// shard returns the segment responsible for key.
+func (c *LRUCache[K, V]) shard(key K) *segment[K, V] {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[c.hash.hash(key)%uint64(len(c.shards))]
+}
+
+// expiry converts a TTL into an absolute expiration time, the zero time for
+// no expiration.
+func (c *LRUCache[K, V]) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.clock.Now().Add(ttl)
+}
+
+// sweep removes every expired item from the cache.
+func (c *LRUCache[K, V]) sweep() {
+	now := c.clock.Now()
+	for _, s := range c.shards {
+		s.sweep(now)
+	}
+}
+
+func (c *LRUCache[K, V]) sweeper(interval time.Duration) {
+	defer c.wg.Done()
+	t := c.clock.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			c.sweep()
+			t.Reset(interval)
+		case <-c.done:
+			return
 		}
-		c.list.Remove(elem)
-		item := elem.Value.(*CacheItem)
-		delete(c.cache, item.key)
 	}
-	return nil
 }
@@ -0,0 +1,1368 @@
+package lrucache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	errBackend := errors.New("backend down")
+
+	t.Run("hit", func(t *testing.T) {
+		c := New[string, float64](10)
+		c.Insert("a", 0.07)
+		got, err := c.GetOrLoad("a", func(string) (float64, error) {
+			t.Fatal("loader called on a hit")
+			return 0, nil
+		})
+		if err != nil || got != 0.07 {
+			t.Fatalf("GetOrLoad = %v, %v, want 0.07, nil", got, err)
+		}
+	})
+
+	t.Run("miss loader success", func(t *testing.T) {
+		c := New[string, float64](10)
+		calls := 0
+		loader := func(key string) (float64, error) {
+			calls++
+			return 0.0725, nil
+		}
+		for range 2 {
+			got, err := c.GetOrLoad("a", loader)
+			if err != nil || got != 0.0725 {
+				t.Fatalf("GetOrLoad = %v, %v, want 0.0725, nil", got, err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("loader called %d times, want 1", calls)
+		}
+		if item, err := c.Get("a"); err != nil || item.Value() != 0.0725 {
+			t.Errorf("Get after load = %v, %v, want the loaded value", item, err)
+		}
+	})
+
+	t.Run("miss loader error", func(t *testing.T) {
+		c := New[string, float64](10)
+		got, err := c.GetOrLoad("a", func(string) (float64, error) {
+			return 0.5, errBackend
+		})
+		if got != 0 {
+			t.Errorf("value = %v, want the zero value", got)
+		}
+		if !errors.Is(err, ErrLoaderFailed) || !errors.Is(err, errBackend) {
+			t.Errorf("err = %v, want it to wrap ErrLoaderFailed and the loader error", err)
+		}
+		if _, err := c.Get("a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get after failed load: err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("miss no loader", func(t *testing.T) {
+		c := New[string, float64](10)
+		if _, err := c.GetOrLoadCtx(t.Context(), "a", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("err = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestInsertGet(t *testing.T) {
+	c := New[string, int](3)
+	if _, err := c.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get on empty cache: err = %v, want ErrNotFound", err)
+	}
+	for i, k := range []string{"a", "b", "c"} {
+		if err := c.Insert(k, i); err != nil {
+			t.Fatalf("Insert(%q): %v", k, err)
+		}
+	}
+	for i, k := range []string{"a", "b", "c"} {
+		item, err := c.Get(k)
+		if err != nil || item.Value() != i {
+			t.Errorf("Get(%q) = %v, %v, want %d", k, item, err, i)
+		}
+	}
+	c.Insert("b", 10)
+	if item, _ := c.Get("b"); item.Value() != 10 {
+		t.Errorf("Get after update = %d, want 10", item.Value())
+	}
+	if c.Len() != 3 {
+		t.Errorf("Len = %d, want 3", c.Len())
+	}
+}
+
+func TestEvictionOrder(t *testing.T) {
+	c := New[int, int](3)
+	for i := range 3 {
+		c.Insert(i, i)
+	}
+	c.Get(0) // 1 is now the least recently used key
+	c.Insert(3, 3)
+	if c.Contains(1) {
+		t.Error("least recently used key 1 was not evicted")
+	}
+	for _, k := range []int{0, 2, 3} {
+		if !c.Contains(k) {
+			t.Errorf("key %d was evicted", k)
+		}
+	}
+	if got, want := c.Keys(), []int{3, 0, 2}; !slices.Equal(got, want) {
+		t.Errorf("Keys = %v, want %v", got, want)
+	}
+	if st := c.Stats(); st.Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", st.Evictions)
+	}
+}
+
+func TestPeekDoesNotPromote(t *testing.T) {
+	c := New[int, int](2)
+	c.Insert(0, 0)
+	c.Insert(1, 1)
+	if _, err := c.Peek(0); err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	c.Insert(2, 2)
+	if c.Contains(0) {
+		t.Error("Peek promoted key 0")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	var evicted []int
+	c := New[int, int](3, WithOnEvict(func(k, v int, reason EvictReason) {
+		if reason == EvictDeleted {
+			evicted = append(evicted, k)
+		}
+	}))
+	c.Insert(1, 1)
+	c.Insert(2, 2)
+	if !c.Delete(1) {
+		t.Error("Delete of a present key returned false")
+	}
+	if c.Delete(1) {
+		t.Error("Delete of an absent key returned true")
+	}
+	if c.Contains(1) || c.Len() != 1 {
+		t.Errorf("after Delete: Contains = %v, Len = %d", c.Contains(1), c.Len())
+	}
+	c.Insert(3, 3)
+	if !c.Evict(3) || c.Evict(3) || c.Contains(3) {
+		t.Error("Evict did not remove a present key exactly once")
+	}
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("Len after Purge = %d", c.Len())
+	}
+	if want := []int{1, 3, 2}; !slices.Equal(evicted, want) {
+		t.Errorf("deleted keys = %v, want %v", evicted, want)
+	}
+}
+
+func TestInsertDeleteBatch(t *testing.T) {
+	c := New[int, int](100, WithShards(4))
+	values := make(map[int]int)
+	for i := range 50 {
+		values[i] = i * 10
+	}
+	if err := c.InsertBatch(values); err != nil {
+		t.Fatalf("InsertBatch: %v", err)
+	}
+	for k, want := range values {
+		if item, err := c.Get(k); err != nil || item.Value() != want {
+			t.Errorf("Get(%d) after InsertBatch = %v, %v, want %d", k, item, err, want)
+		}
+	}
+	keys := []int{-1, 1000}
+	for i := range 25 {
+		keys = append(keys, i)
+	}
+	if n := c.DeleteBatch(keys); n != 25 {
+		t.Errorf("DeleteBatch removed %d keys, want 25", n)
+	}
+	if n := c.Len(); n != 25 {
+		t.Errorf("Len after DeleteBatch = %d, want 25", n)
+	}
+	if c.Contains(0) || !c.Contains(49) {
+		t.Error("DeleteBatch removed the wrong keys")
+	}
+}
+
+func TestInsertIfVersion(t *testing.T) {
+	c := New[string, int](10)
+	if err := c.InsertIfVersion("a", 1, 0); err != nil {
+		t.Fatalf("InsertIfVersion of absent key: %v", err)
+	}
+	_, v1, err := c.GetWithVersion("a")
+	if err != nil || v1 == 0 {
+		t.Fatalf("GetWithVersion = %d, %v, want a version", v1, err)
+	}
+	if err := c.InsertIfVersion("a", 2, 0); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("InsertIfVersion 0 of present key: err = %v, want ErrVersionMismatch", err)
+	}
+	if err := c.InsertIfVersion("a", 2, v1); err != nil {
+		t.Fatalf("InsertIfVersion with current version: %v", err)
+	}
+	// the second writer read v1 too and must not clobber the first
+	if err := c.InsertIfVersion("a", 3, v1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("InsertIfVersion with stale version: err = %v, want ErrVersionMismatch", err)
+	}
+	value, v2, _ := c.GetWithVersion("a")
+	if value != 2 || v2 == v1 {
+		t.Errorf("GetWithVersion = %d, version %d, want 2 with a version other than %d", value, v2, v1)
+	}
+	// versions are not reused once the key is gone
+	c.Delete("a")
+	c.Insert("a", 4)
+	if _, v3, _ := c.GetWithVersion("a"); v3 == v1 || v3 == v2 {
+		t.Errorf("version %d reused after Delete", v3)
+	}
+}
+
+func TestResize(t *testing.T) {
+	c := New[int, int](4)
+	for i := range 4 {
+		c.Insert(i, i)
+	}
+	c.Resize(2)
+	if c.Len() != 2 || c.Cap() != 2 {
+		t.Fatalf("after shrinking: Len = %d, Cap = %d, want 2, 2", c.Len(), c.Cap())
+	}
+	if !c.Contains(2) || !c.Contains(3) {
+		t.Errorf("shrinking kept %v, want the most recent keys 3 and 2", c.Keys())
+	}
+	c.Resize(10)
+	for i := range 10 {
+		c.Insert(i, i)
+	}
+	if c.Len() != 10 {
+		t.Errorf("after growing: Len = %d, want 10", c.Len())
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c := New[int, int](10, WithTTL(time.Hour))
+	c.InsertWithTTL(1, 1, time.Nanosecond)
+	c.Insert(2, 2)
+	time.Sleep(time.Millisecond)
+	if _, err := c.Get(1); !errors.Is(err, ErrExpired) {
+		t.Errorf("Get of expired key: err = %v, want ErrExpired", err)
+	}
+	if _, err := c.Get(2); err != nil {
+		t.Errorf("Get of live key: %v", err)
+	}
+	if st := c.Stats(); st.Expirations != 1 {
+		t.Errorf("Expirations = %d, want 1", st.Expirations)
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	c := New[int, int](10)
+	c.Insert(1, 1)
+	c.SetTTL(time.Nanosecond)
+	if c.TTL() != time.Nanosecond {
+		t.Errorf("TTL = %v, want 1ns", c.TTL())
+	}
+	c.Insert(2, 2)
+	time.Sleep(time.Millisecond)
+	if _, err := c.Get(1); err != nil {
+		t.Errorf("Get of a key inserted before SetTTL: %v", err)
+	}
+	if _, err := c.Get(2); !errors.Is(err, ErrExpired) {
+		t.Errorf("Get of a key inserted after SetTTL: err = %v, want ErrExpired", err)
+	}
+}
+
+func TestLoaderTTL(t *testing.T) {
+	// special event districts, here odd keys, are cached for a minute
+	loader := LoaderFuncTTL[int, int](func(k int) (int, time.Duration, error) {
+		if k%2 == 1 {
+			return k, time.Minute, nil
+		}
+		return k, 0, nil
+	})
+	c := New[int, int](10, WithTTL(time.Hour), WithLoaderCtx(loader.WithContext().Loader()))
+	if v, err := c.GetOrLoadTTL(1, loader); err != nil || v != 1 {
+		t.Fatalf("GetOrLoadTTL(1) = %d, %v", v, err)
+	}
+	c.Lookup(2)
+	c.Lookup(3)
+	for k, want := range map[int]time.Duration{1: time.Minute, 2: time.Hour, 3: time.Minute} {
+		item, err := c.Peek(k)
+		if err != nil {
+			t.Fatalf("Peek(%d): %v", k, err)
+		}
+		if ttl := time.Until(item.Expires()); ttl > want || ttl < want-time.Second {
+			t.Errorf("%d expires in %v, want %v", k, ttl, want)
+		}
+	}
+}
+
+// fixedClock is the system clock, but for Now returning now.
+type fixedClock struct {
+	systemClock
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time { return c.now }
+
+func TestGetWithInfo(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fixedClock{now: start}
+	c := New[int, int](10, WithTTL(time.Hour), WithClock(clock))
+	c.Insert(1, 10)
+	clock.now = start.Add(time.Minute)
+
+	item, info, err := c.PeekWithInfo(1)
+	if err != nil || !info.Accessed.IsZero() || info.Hits != 0 || info.TTL != 59*time.Minute {
+		t.Errorf("PeekWithInfo(1) before any hit = %+v, %v, want no hits and 59m left", info, err)
+	}
+	v, info, err := c.GetWithInfo(1)
+	if err != nil || v != 10 {
+		t.Fatalf("GetWithInfo(1) = %d, %v", v, err)
+	}
+	want := ItemInfo{
+		Created:  start,
+		Accessed: start.Add(time.Minute),
+		Hits:     1,
+		Expires:  start.Add(time.Hour),
+		TTL:      59 * time.Minute,
+		Version:  item.Version(),
+	}
+	if !info.Created.Equal(want.Created) || !info.Accessed.Equal(want.Accessed) ||
+		info.Hits != want.Hits || !info.Expires.Equal(want.Expires) || info.TTL != want.TTL || info.Version != want.Version {
+		t.Errorf("GetWithInfo(1) info = %+v, want %+v", info, want)
+	}
+	if _, _, err := c.GetWithInfo(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetWithInfo(2) error = %v, want ErrNotFound", err)
+	}
+
+	// a new value starts over
+	c.Insert(1, 11)
+	item, _ = c.Peek(1)
+	if info := item.Info(clock.now); !info.Created.Equal(clock.now) || info.Hits != 0 {
+		t.Errorf("after Insert info = %+v, want created now and no hits", info)
+	}
+}
+
+func TestRemoveOldest(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d shards", shards), func(t *testing.T) {
+			clock := &fixedClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+			var evicted []int
+			c := New[int, int](100, WithShards(shards), WithClock(clock),
+				WithOnEvict(func(k, _ int, reason EvictReason) {
+					if reason == EvictCapacity {
+						evicted = append(evicted, k)
+					}
+				}))
+			if _, ok := c.Oldest(); ok {
+				t.Error("Oldest of an empty cache is ok")
+			}
+			for k := 1; k <= 8; k++ {
+				clock.now = clock.now.Add(time.Second)
+				c.Insert(k, k)
+			}
+			clock.now = clock.now.Add(time.Second)
+			c.Get(1)
+
+			if item, ok := c.Oldest(); !ok || item.Key() != 2 {
+				t.Errorf("Oldest() = %v, %t, want 2", item, ok)
+			}
+			if item, ok := c.Newest(); !ok || item.Key() != 1 {
+				t.Errorf("Newest() = %v, %t, want 1", item, ok)
+			}
+			if n := c.RemoveOldest(3); n != 3 {
+				t.Errorf("RemoveOldest(3) = %d, want 3", n)
+			}
+			if !slices.Equal(evicted, []int{2, 3, 4}) {
+				t.Errorf("evicted %v, want [2 3 4]", evicted)
+			}
+			if n := c.RemoveOldest(10); n != 5 || c.Len() != 0 {
+				t.Errorf("RemoveOldest(10) = %d leaving %d, want 5 leaving 0", n, c.Len())
+			}
+			if st := c.Stats(); st.Evictions != 8 {
+				t.Errorf("%d evictions, want 8", st.Evictions)
+			}
+		})
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	var used uint64
+	var evicted []EvictReason
+	p := MemoryPressure{Limit: 100, Step: 0.25, MinSize: 4, Interval: time.Hour, Usage: func() uint64 { return used }}
+	c := New[int, int](10, WithMemoryPressure(p), WithOnEvict(func(_, _ int, reason EvictReason) {
+		evicted = append(evicted, reason)
+	}))
+	defer c.Close()
+	for k := range 10 {
+		c.Insert(k, k)
+	}
+	check := func(u uint64, want int) {
+		t.Helper()
+		used = u
+		c.checkPressure(p.withDefaults())
+		if c.Cap() != want {
+			t.Errorf("with %d used size = %d, want %d", u, c.Cap(), want)
+		}
+	}
+
+	check(80, 10) // between low and high
+	check(95, 8)
+	check(95, 6)
+	check(95, 4)
+	check(95, 4) // MinSize
+	if c.Len() != 4 || len(evicted) != 6 || evicted[0] != EvictPressure {
+		t.Errorf("%d items left, evicted %v, want 4 left after 6 pressure evictions", c.Len(), evicted)
+	}
+	check(80, 4)
+	check(50, 6)
+	c.Resize(7)
+	check(50, 7) // regrows to the size of Resize only
+}
+
+func TestMemoryPressureInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		p    MemoryPressure
+	}{
+		{"low over high", MemoryPressure{High: 0.6, Low: 0.8}},
+		{"low equal to high", MemoryPressure{High: 0.8, Low: 0.8}},
+		{"high under the default low", MemoryPressure{High: 0.5}},
+		{"low over the default high", MemoryPressure{Low: 0.95}},
+		{"high over 1", MemoryPressure{High: 1.5}},
+		{"step over 1", MemoryPressure{Step: 2}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New with %s did not panic", tt.name)
+				}
+			}()
+			New[int, int](10, WithMemoryPressure(tt.p)).Close()
+		}()
+	}
+	// the limits themselves are valid
+	New[int, int](10, WithMemoryPressure(MemoryPressure{High: 1, Low: 0.99, Step: 1})).Close()
+}
+
+func TestKeyInterner(t *testing.T) {
+	in := NewInterner[string]()
+	rates := New[string, int](10, WithKeyInterner(in))
+	histories := New[string, int](10, WithKeyInterner(in))
+	request := "GET /rate/98101 HTTP/1.1"
+	key := request[len("GET /rate/"):strings.Index(request, " HTTP")]
+	rates.Insert(key, 1)
+	histories.Insert(strings.Clone(key), 2)
+
+	a, _ := rates.Peek(key)
+	b, _ := histories.Peek(key)
+	if unsafe.StringData(a.Key()) != unsafe.StringData(b.Key()) {
+		t.Error("the caches hold distinct copies of the key")
+	}
+	if unsafe.StringData(a.Key()) == unsafe.StringData(key) {
+		t.Error("the cache holds a slice of the request")
+	}
+	if in.Len() != 1 {
+		t.Errorf("%d keys interned, want 1", in.Len())
+	}
+
+	// an update keeps the key stored
+	rates.Insert(strings.Clone(key), 3)
+	if a, _ := rates.Peek(key); unsafe.StringData(a.Key()) != unsafe.StringData(b.Key()) {
+		t.Error("an update replaced the key stored")
+	}
+	rates.Delete(key)
+	if in.Len() != 1 {
+		t.Errorf("after a Delete %d keys interned, want 1", in.Len())
+	}
+	histories.Resize(1)
+	histories.Insert("98102", 4)
+	if in.Len() != 1 {
+		t.Errorf("after an eviction %d keys interned, want 1", in.Len())
+	}
+	histories.Purge()
+	if in.Len() != 0 {
+		t.Errorf("after Purge %d keys interned, want 0", in.Len())
+	}
+}
+
+func TestSetNegativeTTL(t *testing.T) {
+	c := New[int, int](10)
+	calls := 0
+	fail := func(int) (int, error) {
+		calls++
+		return 0, errors.New("backend down")
+	}
+	c.GetOrLoad(1, fail)
+	c.GetOrLoad(1, fail)
+	if calls != 2 {
+		t.Errorf("%d loader calls without negative caching, want 2", calls)
+	}
+	c.SetNegativeTTL(time.Hour)
+	c.GetOrLoad(1, fail)
+	c.GetOrLoad(1, fail)
+	if calls != 3 {
+		t.Errorf("%d loader calls with negative caching, want 3", calls)
+	}
+	c.SetNegativeTTL(0)
+	if c.NegativeTTL() != 0 {
+		t.Errorf("NegativeTTL = %v, want 0", c.NegativeTTL())
+	}
+	c.GetOrLoad(1, fail)
+	if calls != 4 {
+		t.Errorf("%d loader calls after disabling negative caching, want 4", calls)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	c := New[int, int](3)
+	for i := range 3 {
+		c.Insert(i, i)
+	}
+	c.Get(0)
+	c.SetPolicy(NewFIFOPolicy[int])
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d after SetPolicy, want 3", c.Len())
+	}
+	// the LRU order 0, 2, 1 becomes the insertion order of FIFO, so that
+	// hits no longer save 1 from eviction
+	c.Get(1)
+	c.Insert(3, 3)
+	if c.Contains(1) || !c.Contains(0) || !c.Contains(2) {
+		t.Errorf("after evicting one key: %v, want 1 evicted", c.Keys())
+	}
+}
+
+func TestPolicyPrealloc(t *testing.T) {
+	factories := map[string]PolicyFactory[string]{
+		"lru":    NewLRUPolicy[string],
+		"fifo":   NewFIFOPolicy[string],
+		"lfu":    NewLFUPolicy[string],
+		"slru":   NewSLRUPolicy[string],
+		"arc":    NewARCPolicy[string],
+		"random": NewRandomPolicy[string],
+	}
+	for name, factory := range factories {
+		// a capacity of a billion keys must not be allocated up front
+		before := heapInUse()
+		p := factory(1 << 30)
+		if after := heapInUse(); after > before+1<<20 {
+			t.Errorf("%s policy of 1<<30 keys allocated %d bytes before any key", name, after-before)
+		}
+		p.Add("a")
+		if key, ok := p.Victim(); !ok || key != "a" {
+			t.Errorf("%s Victim = %q, %v, want a", name, key, ok)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	// the interval is long enough for the test to drive the sweeps itself
+	c := New[int, int](100, WithSweepInterval(time.Hour))
+	defer c.Close()
+	for i := range 10 {
+		c.InsertWithTTL(i, i, time.Duration(i+1)*time.Minute)
+	}
+	c.InsertWithTTL(0, 0, time.Hour) // extended
+	c.InsertWithTTL(1, 1, 0)         // made permanent
+	c.InsertWithTTL(9, 9, time.Second)
+	c.Delete(2)
+	c.Insert(10, 10)
+
+	s := c.shards[0]
+	s.sweep(time.Now().Add(5*time.Minute + 30*time.Second))
+	want := []int{0, 1, 5, 6, 7, 8, 10}
+	for _, k := range want {
+		if s.cache[k] == nil {
+			t.Errorf("key %d was swept, want it kept", k)
+		}
+	}
+	if n := c.Len(); n != len(want) {
+		t.Errorf("Len = %d after sweep, want %d", n, len(want))
+	}
+	if n := len(s.expiry.entries); n != 5 {
+		t.Errorf("expiry heap holds %d keys, want 5", n)
+	}
+	if st := c.Stats(); st.Expirations != 3 {
+		t.Errorf("Expirations = %d, want 3", st.Expirations)
+	}
+}
+
+func TestTinyLFU(t *testing.T) {
+	// seeded, as keys colliding in the sketch would be estimated alike
+	c := New[string, int](2, WithTinyLFU(), WithRand(rand.NewPCG(1, 1)))
+	loader := func(key string) (int, error) { return len(key), nil }
+	for _, k := range []string{"a", "bb"} {
+		for range 3 {
+			c.GetOrLoad(k, loader)
+		}
+	}
+	// requested once, less than the victim: returned but not cached
+	if v, err := c.GetOrLoad("ccc", loader); err != nil || v != 3 {
+		t.Fatalf("GetOrLoad = %d, %v, want 3, nil", v, err)
+	}
+	if c.Contains("ccc") {
+		t.Error("cold key admitted over a hot victim")
+	}
+	if st := c.Stats(); st.Rejections != 1 {
+		t.Errorf("Rejections = %d, want 1", st.Rejections)
+	}
+	// once it is requested more often than the victim it gets in
+	for range 4 {
+		c.GetOrLoad("ccc", loader)
+	}
+	if !c.Contains("ccc") {
+		t.Error("hot key not admitted")
+	}
+	// Insert bypasses the filter
+	c.Insert("dddd", 4)
+	if !c.Contains("dddd") {
+		t.Error("inserted key not admitted")
+	}
+}
+
+func TestBatchTinyLFU(t *testing.T) {
+	c := New[string, int](2, WithTinyLFU(), WithRand(rand.NewPCG(1, 1)))
+	for _, k := range []string{"a", "bb"} {
+		for range 3 {
+			c.GetOrLoad(k, func(key string) (int, error) { return len(key), nil })
+		}
+	}
+	batch := func(keys []string) (map[string]int, error) {
+		values := make(map[string]int, len(keys))
+		for _, k := range keys {
+			values[k] = len(k)
+		}
+		return values, nil
+	}
+	// requested once, less than the victims: returned but not cached
+	got, err := c.FastRateLookupMulti([]string{"a", "ccc", "dddd"}, batch)
+	if want := map[string]int{"a": 1, "ccc": 3, "dddd": 4}; err != nil || !maps.Equal(got, want) {
+		t.Fatalf("FastRateLookupMulti = %v, %v, want %v", got, err, want)
+	}
+	if c.Contains("ccc") || c.Contains("dddd") || !c.Contains("a") || !c.Contains("bb") {
+		t.Errorf("cold batch keys admitted over hot victims: %v", c.Keys())
+	}
+	if st := c.Stats(); st.Rejections != 2 {
+		t.Errorf("Rejections = %d, want 2", st.Rejections)
+	}
+	for range 4 {
+		c.FastRateLookupMulti([]string{"ccc"}, batch)
+	}
+	if !c.Contains("ccc") {
+		t.Error("hot batch key not admitted")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	c := New[string, int](4)
+	for _, k := range []string{"a", "b", "c"} {
+		c.Insert(k, 0)
+	}
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+
+	in := c.Inspect(2)
+	if len(in.Shards) != 1 {
+		t.Fatalf("Inspect returned %d shards, want 1", len(in.Shards))
+	}
+	sh := in.Shards[0]
+	if sh.Len != 3 || sh.Capacity != 4 || !slices.Equal(sh.Tail, []string{"c", "a"}) {
+		t.Errorf("shard = %+v, want 3 of 4 items with tail [c a]", sh)
+	}
+	want := []KeyHits[string]{{"a", 2}, {"b", 1}}
+	if !slices.Equal(in.Hot, want) {
+		t.Errorf("Hot = %v, want %v", in.Hot, want)
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	c := New[int, int](100, WithShards(4), WithHotKeys(3, time.Hour))
+	for k := range 20 {
+		// key k is requested k+1 times
+		for range k + 1 {
+			c.Get(k)
+		}
+	}
+	want := []KeyHits[int]{{19, 20}, {18, 19}, {17, 18}}
+	if got := c.HotKeys(0); !slices.Equal(got, want) {
+		t.Errorf("HotKeys(0) = %v, want %v", got, want)
+	}
+	if got := c.HotKeys(1); !slices.Equal(got, want[:1]) {
+		t.Errorf("HotKeys(1) = %v, want %v", got, want[:1])
+	}
+	if got := New[int, int](10).HotKeys(3); got != nil {
+		t.Errorf("HotKeys without WithHotKeys = %v, want nil", got)
+	}
+}
+
+func TestHotKeysWindow(t *testing.T) {
+	start := time.Now()
+	h := newHotKeys[string](2, time.Minute, start)
+	h.record("old", start)
+	h.record("old", start)
+	h.record("new", start.Add(40*time.Second))
+	if got := topHits(h.top(start.Add(40*time.Second)), 2); !slices.Equal(got, []KeyHits[string]{{"old", 2}, {"new", 1}}) {
+		t.Errorf("top within the window = %v", got)
+	}
+	// "old" was counted in the half before last and has left the window
+	if got := h.top(start.Add(70 * time.Second)); !slices.Equal(got, []KeyHits[string]{{"new", 1}}) {
+		t.Errorf("top after a rotation = %v", got)
+	}
+	if got := h.top(start.Add(5 * time.Minute)); len(got) != 0 {
+		t.Errorf("top after an idle window = %v, want none", got)
+	}
+}
+
+func TestStatsWindows(t *testing.T) {
+	c := New[string, int](10, WithStatsWindows())
+	c.Insert("a", 1)
+	c.Get("a")
+	c.Get("b")
+	c.GetOrLoad("c", func(string) (int, error) { return 3, nil })
+	st := c.Stats()
+	if len(st.Windows) != len(DefaultStatsWindows) {
+		t.Fatalf("got %d windows, want %d", len(st.Windows), len(DefaultStatsWindows))
+	}
+	for i, w := range st.Windows {
+		if w.Window != DefaultStatsWindows[i] || w.Hits != 1 || w.Misses != 2 || w.LoaderCalls != 1 || w.LoaderErrors != 0 {
+			t.Errorf("window %d = %+v, want %v with 1 hit, 2 misses and 1 load", i, w, DefaultStatsWindows[i])
+		}
+		if w.LoadP50 <= 0 || w.LoadP99 < w.LoadP50 {
+			t.Errorf("window %v load latency p50 %v p99 %v", w.Window, w.LoadP50, w.LoadP99)
+		}
+	}
+	if st := New[string, int](10).Stats(); st.Windows != nil {
+		t.Errorf("Windows without WithStatsWindows = %v, want nil", st.Windows)
+	}
+}
+
+func TestStatRing(t *testing.T) {
+	start := time.Unix(1000, 0)
+	r := newStatRing(time.Minute)
+	for i := range 3 {
+		r.bucket(start).hits.Add(1)
+		r.bucket(start.Add(30 * time.Second)).misses.Add(1)
+		b := r.bucket(start.Add(59 * time.Second))
+		b.loads.Add(1)
+		b.latency[latencyBucket(time.Duration(i+1)*time.Millisecond)].Add(1)
+	}
+	st := r.stats(start.Add(59 * time.Second))
+	if st.Hits != 3 || st.Misses != 3 || st.LoaderCalls != 3 {
+		t.Errorf("stats within the window = %+v", st)
+	}
+	if p50 := st.LoadP50; p50 < 2*time.Millisecond || p50 > 5*time.Millisecond/2 {
+		t.Errorf("p50 = %v, want about 2ms", p50)
+	}
+	// the first bucket has left the window, the others are still in it
+	if st := r.stats(start.Add(80 * time.Second)); st.Hits != 0 || st.Misses != 3 || st.LoaderCalls != 3 {
+		t.Errorf("stats after 80s = %+v", st)
+	}
+	// a new period reusing the first bucket clears it
+	r.bucket(start.Add(time.Minute)).hits.Add(1)
+	if st := r.stats(start.Add(time.Minute)); st.Hits != 1 {
+		t.Errorf("hits after the bucket was reused = %d, want 1", st.Hits)
+	}
+	if st := r.stats(start.Add(time.Hour)); st.Hits+st.Misses+st.LoaderCalls != 0 || st.LoadP99 != 0 {
+		t.Errorf("stats after an idle window = %+v, want none", st)
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	for _, d := range []time.Duration{0, time.Microsecond, 1500 * time.Microsecond, time.Second, time.Minute} {
+		i := latencyBucket(d)
+		if limit := latencyLimit(i); d >= limit && i < latencyBuckets-1 {
+			t.Errorf("%v counted in bucket %d with upper bound %v", d, i, limit)
+		}
+		if i > 0 && i < latencyBuckets-1 && d < latencyLimit(i-1) {
+			t.Errorf("%v counted in bucket %d above bound %v", d, i, latencyLimit(i-1))
+		}
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	var loads []string
+	c := New[string, int](100, WithShards(4), WithKeyNormalizer(strings.ToLower))
+	loader := func(key string) (int, error) {
+		loads = append(loads, key)
+		return len(key), nil
+	}
+	c.Insert("A", 1)
+	if item, err := c.Get("a"); err != nil || item.Value() != 1 || item.Key() != "a" {
+		t.Errorf("Get(a) = %v, %v, want the item inserted as A", item, err)
+	}
+	if v, err := c.GetOrLoad("BC", loader); err != nil || v != 2 {
+		t.Errorf("GetOrLoad(BC) = %v, %v", v, err)
+	}
+	if v, err := c.GetOrLoad("bc", loader); err != nil || v != 2 || !slices.Equal(loads, []string{"bc"}) {
+		t.Errorf("GetOrLoad(bc) = %v, %v with loads %q, want one load of bc", v, err, loads)
+	}
+	got, err := c.FastRateLookupMulti([]string{"A", "Bc", "D"}, func(keys []string) (map[string]int, error) {
+		return map[string]int{"d": 4}, nil
+	})
+	if want := map[string]int{"A": 1, "Bc": 2, "D": 4}; err != nil || !maps.Equal(got, want) {
+		t.Errorf("FastRateLookupMulti = %v, %v, want %v", got, err, want)
+	}
+	c.InsertBatch(map[string]int{"E": 5})
+	if !c.Contains("e") || c.Len() != 4 {
+		t.Errorf("after InsertBatch: Contains(e) = %v, Len = %d, want true and 4", c.Contains("e"), c.Len())
+	}
+	if !c.Delete("A") || c.DeleteBatch([]string{"BC", "D"}) != 2 || c.Len() != 1 {
+		t.Errorf("deleting by other spellings left keys %v", c.Keys())
+	}
+}
+
+func TestKeyCanonicalizer(t *testing.T) {
+	errNoZip := errors.New("no zip code")
+	// addresses are "street, zip"; every address of a zip code has one rate
+	zip := KeyCanonicalizerFunc[string](func(_ context.Context, key string) (string, error) {
+		_, zip, ok := strings.Cut(key, ", ")
+		if !ok {
+			return "", errNoZip
+		}
+		return zip, nil
+	})
+	var loads []string
+	loader := func(key string) (int, error) {
+		loads = append(loads, key)
+		return len(loads), nil
+	}
+	c := New[string, int](10, WithKeyNormalizer(strings.ToLower), WithKeyCanonicalizer[string](zip))
+
+	if v, err := c.GetOrLoad("1 Main St, 10001", loader); err != nil || v != 1 {
+		t.Errorf("GetOrLoad = %v, %v", v, err)
+	}
+	if v, err := c.GetOrLoad("2 Elm St, 10001", loader); err != nil || v != 1 {
+		t.Errorf("GetOrLoad of the same zip = %v, %v, want the cached 1", v, err)
+	}
+	if !slices.Equal(loads, []string{"1 main st, 10001"}) || !slices.Equal(c.Keys(), []string{"10001"}) {
+		t.Errorf("loads %q, keys %q, want one load of the normalized address cached as 10001", loads, c.Keys())
+	}
+
+	got, err := c.FastRateLookupMulti([]string{"3 Oak St, 10001", "4 Oak St, 20002", "5 Oak St, 20002"}, func(keys []string) (map[string]int, error) {
+		loads = append(loads, keys...)
+		return map[string]int{keys[0]: 2}, nil
+	})
+	if want := map[string]int{"3 Oak St, 10001": 1, "4 Oak St, 20002": 2, "5 Oak St, 20002": 2}; err != nil || !maps.Equal(got, want) {
+		t.Errorf("FastRateLookupMulti = %v, %v, want %v", got, err, want)
+	}
+	if !slices.Equal(loads[1:], []string{"4 oak st, 20002"}) {
+		t.Errorf("batch loads %q, want only the first address of 20002", loads[1:])
+	}
+
+	c.Insert("no zip", 3)
+	if !c.Contains("NO ZIP") || c.Stats().CanonErrors != 2 {
+		t.Errorf("Contains(NO ZIP) = %v with %d errors, want it cached as it is", c.Contains("NO ZIP"), c.Stats().CanonErrors)
+	}
+	if !c.Delete("9 Any Rd, 20002") || c.Contains("4 Oak St, 20002") {
+		t.Error("Delete of another address of 20002 did not remove it")
+	}
+}
+
+func TestShardedCapacity(t *testing.T) {
+	c := New[int, int](100, WithShards(8))
+	for i := range 1000 {
+		c.Insert(i, i)
+	}
+	if c.Len() > 100 {
+		t.Errorf("Len = %d, exceeds the capacity 100", c.Len())
+	}
+	for i := 990; i < 1000; i++ {
+		if !c.Contains(i) {
+			t.Errorf("recent key %d missing", i)
+		}
+	}
+}
+
+// recordingTracer records the lookups reported to it.
+type recordingTracer struct {
+	name string
+	log  *[]string
+}
+
+func (r recordingTracer) StartLookup(ctx context.Context, key string) (context.Context, func(bool, error)) {
+	*r.log = append(*r.log, r.name+" start "+key)
+	return ctx, func(hit bool, err error) {
+		*r.log = append(*r.log, fmt.Sprintf("%s end %v", r.name, hit))
+	}
+}
+
+func TestTracers(t *testing.T) {
+	var log []string
+	c := New[string, int](10,
+		WithKeyNormalizer(strings.ToUpper),
+		WithTracer[string](recordingTracer{"outer", &log}),
+		WithTracer[string](recordingTracer{"inner", &log}))
+	c.Insert("a", 1)
+	c.GetOrLoad("a", nil)
+	want := []string{"outer start A", "inner start A", "inner end true", "outer end true"}
+	if !slices.Equal(log, want) {
+		t.Errorf("tracers saw %q, want %q", log, want)
+	}
+}
+
+// recordingJournal records the changes it is told of.
+type recordingJournal struct {
+	log []string
+}
+
+func (j *recordingJournal) Insert(key string, value int, expires time.Time) {
+	j.log = append(j.log, fmt.Sprintf("insert %s %d %v", key, value, !expires.IsZero()))
+}
+
+func (j *recordingJournal) Delete(key string) {
+	j.log = append(j.log, "delete "+key)
+}
+
+func TestJournal(t *testing.T) {
+	j := &recordingJournal{}
+	c := New[string, int](2, WithJournal[string, int](j))
+	c.Insert("a", 1)
+	c.InsertWithTTL("b", 2, time.Hour)
+	c.GetOrLoad("c", func(string) (int, error) { return 3, nil }) // evicts a
+	c.Delete("b")
+	c.Delete("x")
+	want := []string{"insert a 1 false", "insert b 2 true", "insert c 3 false", "delete b"}
+	if !slices.Equal(j.log, want) {
+		t.Errorf("journal saw %q, want %q", j.log, want)
+	}
+
+	// replaying them into another cache restores it, evicting a again
+	r := New[string, int](2)
+	r.Restore("a", 1, time.Time{})
+	r.Restore("b", 2, time.Now().Add(time.Hour))
+	r.Restore("c", 3, time.Time{})
+	r.Evict("b")
+	if keys := r.Keys(); !slices.Equal(keys, c.Keys()) {
+		t.Errorf("restored keys %q, want %q", keys, c.Keys())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := New[string, int](10)
+	c.Insert("a", 1)
+	c.InsertWithTTL("b", 2, time.Hour)
+	c.Insert("c", 3)
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	r := New[string, int](10)
+	if err := r.LoadSnapshot(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if keys := r.Keys(); !slices.Equal(keys, c.Keys()) {
+		t.Errorf("loaded keys %q, want %q", keys, c.Keys())
+	}
+	if item, err := r.Get("b"); err != nil || item.Value() != 2 || item.Expires().IsZero() {
+		t.Errorf("b = %v, %v, want 2 expiring", item, err)
+	}
+
+	damaged := slices.Clone(snapshot)
+	damaged[len(damaged)-5] ^= 0xff
+	if err := New[string, int](10).LoadSnapshot(bytes.NewReader(damaged)); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("LoadSnapshot of a damaged snapshot: %v, want ErrSnapshotCorrupt", err)
+	}
+	r = New[string, int](10)
+	if err := r.LoadSnapshot(bytes.NewReader(snapshot[:len(snapshot)-3])); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("LoadSnapshot of a snapshot cut short: %v, want ErrSnapshotCorrupt", err)
+	}
+	if r.Len() != 2 {
+		t.Errorf("%d items loaded before the cut, want 2", r.Len())
+	}
+
+	newer := slices.Clone(snapshot)
+	newer[len(snapshotMagic)+1] = snapshotVersion + 1
+	if err := New[string, int](10).LoadSnapshot(bytes.NewReader(newer)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("LoadSnapshot of a newer snapshot: %v, want ErrSnapshotVersion", err)
+	}
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	// the bare gob stream of the first format
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	enc.Encode(snapshotHeader{Version: 1, Created: time.Now(), Count: 2})
+	enc.Encode(snapshotEntry[string, int]{Key: "a", Value: 1})
+	enc.Encode(snapshotEntry[string, int]{Key: "b", Value: 2, Expires: time.Now().Add(time.Hour)})
+
+	c := New[string, int](10)
+	if err := c.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if keys := c.Keys(); !slices.Equal(keys, []string{"b", "a"}) {
+		t.Errorf("loaded keys %q, want [b a]", keys)
+	}
+}
+
+func TestWithRand(t *testing.T) {
+	// the keys left by random evictions over 4 shards of TinyLFU admission
+	run := func(seed uint64) []int {
+		c := New[int, int](64, WithRand(rand.NewPCG(seed, seed)), WithShards(4), WithTinyLFU(), WithPolicy(NewRandomPolicy[int]))
+		for i := range 1000 {
+			c.Insert(i%300, i)
+			c.Get(i % 7)
+		}
+		return slices.Sorted(slices.Values(c.Keys()))
+	}
+	first := run(1)
+	if again := run(1); !slices.Equal(again, first) {
+		t.Errorf("same seed kept %v, then %v", first, again)
+	}
+	if other := run(2); slices.Equal(other, first) {
+		t.Errorf("seeds 1 and 2 both kept %v", first)
+	}
+
+	p := RetryPolicy{MaxAttempts: 3, Backoff: time.Second, Jitter: 0.5}
+	wait := func(seed uint64) time.Duration {
+		d, _ := p.backoff(1, errors.New("down"), newRandom(rand.NewPCG(seed, seed)))
+		return d
+	}
+	if wait(1) != wait(1) {
+		t.Error("same seed jittered the backoff differently")
+	}
+}
+
+func TestBatchCircuitBreaker(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fixedClock{now: start}
+	c := New[int, int](10, WithCircuitBreaker(2, time.Minute), WithClock(clock))
+	c.Insert(1, 1)
+	calls := 0
+	fail := func([]int) (map[int]int, error) {
+		calls++
+		return nil, errors.New("backend down")
+	}
+	for range 2 {
+		if got, err := c.FastRateLookupMulti([]int{1, 2}, fail); !errors.Is(err, ErrLoaderFailed) || got[1] != 1 {
+			t.Fatalf("failing batch = %v, %v, want the cached value and ErrLoaderFailed", got, err)
+		}
+	}
+	// the breaker is open for batches and single loads alike
+	got, err := c.FastRateLookupMulti([]int{1, 2}, fail)
+	if !errors.Is(err, ErrBackendUnavailable) || got[1] != 1 || calls != 2 {
+		t.Errorf("batch with the breaker open = %v, %v with %d calls, want ErrBackendUnavailable without a call", got, err, calls)
+	}
+	if _, err := c.GetOrLoad(3, func(k int) (int, error) { return k, nil }); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("GetOrLoad with the breaker open = %v, want ErrBackendUnavailable", err)
+	}
+	if st := c.Stats(); st.Unavailable != 2 {
+		t.Errorf("Unavailable = %d, want 2", st.Unavailable)
+	}
+
+	// a successful batch trial closes it
+	clock.now = start.Add(2 * time.Minute)
+	got, err = c.FastRateLookupMulti([]int{2}, func(keys []int) (map[int]int, error) {
+		return map[int]int{2: 2}, nil
+	})
+	if err != nil || got[2] != 2 {
+		t.Errorf("trial batch = %v, %v", got, err)
+	}
+	if v, err := c.GetOrLoad(3, func(k int) (int, error) { return k, nil }); err != nil || v != 3 {
+		t.Errorf("GetOrLoad after the trial = %d, %v, want the breaker closed", v, err)
+	}
+}
+
+// memStore is a Store in memory recording the writes made to it, which fail
+// with err if it is set.
+type memStore struct {
+	mu     sync.Mutex
+	values map[string]int
+	log    []string
+	err    error
+}
+
+func newMemStore() *memStore {
+	return &memStore{values: make(map[string]int)}
+}
+
+func (s *memStore) Get(_ context.Context, key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memStore) Set(_ context.Context, key string, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, fmt.Sprintf("set %s=%d", key, value))
+	if s.err != nil {
+		return s.err
+	}
+	s.values[key] = value
+	return nil
+}
+
+func (s *memStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, "delete "+key)
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memStore) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// writes returns the writes made so far, sorted, and forgets them.
+func (s *memStore) writes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log := s.log
+	s.log = nil
+	slices.Sort(log)
+	return log
+}
+
+// batchStore is a memStore taking batches, each one signalled on flushed.
+type batchStore struct {
+	*memStore
+	flushed chan struct{}
+}
+
+func newBatchStore() *batchStore {
+	return &batchStore{memStore: newMemStore(), flushed: make(chan struct{}, 10)}
+}
+
+func (s *batchStore) SetBatch(_ context.Context, values map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := make([]string, 0, len(values))
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		batch = append(batch, fmt.Sprintf("%s=%d", key, values[key]))
+	}
+	s.log = append(s.log, "setBatch "+strings.Join(batch, " "))
+	s.flushed <- struct{}{}
+	if s.err != nil {
+		return s.err
+	}
+	maps.Copy(s.values, values)
+	return nil
+}
+
+func TestWriteThrough(t *testing.T) {
+	s := newMemStore()
+	var handled []error
+	c := New[string, int](10, WithWriteThrough[string, int](s), WithStoreErrorHandler(func(err error) { handled = append(handled, err) }))
+	if err := c.Insert("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.writes(); !slices.Equal(got, []string{"set a=1"}) {
+		t.Errorf("store saw %q, want a set of a", got)
+	}
+
+	// a failed write leaves the cache unchanged
+	boom := errors.New("store down")
+	s.fail(boom)
+	if err := c.Insert("a", 2); !errors.Is(err, boom) {
+		t.Errorf("Insert with the store down = %v, want %v", err, boom)
+	}
+	if err := c.InsertWithTTL("b", 2, time.Hour); !errors.Is(err, boom) {
+		t.Errorf("InsertWithTTL with the store down = %v, want %v", err, boom)
+	}
+	if v, err := c.Get("a"); err != nil || v.Value() != 1 || c.Contains("b") {
+		t.Errorf("failed writes left %q with a = %v, want a = 1 alone", c.Keys(), v)
+	}
+	// a failed delete has no caller to return to
+	c.Delete("a")
+	if len(handled) != 1 || !errors.Is(handled[0], boom) {
+		t.Errorf("store error handler got %v, want the failed delete", handled)
+	}
+	if st := c.Stats(); st.StoreErrors != 3 {
+		t.Errorf("StoreErrors = %d, want 3", st.StoreErrors)
+	}
+}
+
+func TestWriteThroughBatch(t *testing.T) {
+	s := newBatchStore()
+	c := New[string, int](10, WithWriteThrough[string, int](s))
+	if err := c.InsertBatch(map[string]int{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.writes(); !slices.Equal(got, []string{"setBatch a=1 b=2"}) {
+		t.Errorf("store saw %q, want one batch", got)
+	}
+
+	boom := errors.New("store down")
+	s.fail(boom)
+	if err := c.InsertBatch(map[string]int{"a": 3, "c": 3}); !errors.Is(err, boom) {
+		t.Errorf("InsertBatch with the store down = %v, want %v", err, boom)
+	}
+	if v, err := c.Get("a"); err != nil || v.Value() != 1 || c.Contains("c") {
+		t.Errorf("failed batch left %q with a = %v, want a = 1 and b", c.Keys(), v)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	s := newBatchStore()
+	c := New[string, int](10, WithWriteBehind[string, int](s, 100, time.Hour))
+	c.Insert("a", 1)
+	c.Insert("a", 2)
+	c.Insert("b", 3)
+	c.Insert("c", 4)
+	c.Delete("c")
+	if got := s.writes(); len(got) != 0 {
+		t.Errorf("store saw %q before the flush", got)
+	}
+	if v, err := c.Get("a"); err != nil || v.Value() != 2 {
+		t.Errorf("Get(a) = %v, %v before the flush, want 2", v, err)
+	}
+
+	// Close flushes the last write of each key, the sets in one batch
+	c.Close()
+	if got, want := s.writes(), []string{"delete c", "setBatch a=2 b=3"}; !slices.Equal(got, want) {
+		t.Errorf("store saw %q on Close, want %q", got, want)
+	}
+	// and later writes are made right away
+	c.Insert("d", 5)
+	c.Delete("a")
+	if got, want := s.writes(), []string{"delete a", "set d=5"}; !slices.Equal(got, want) {
+		t.Errorf("store saw %q after Close, want %q", got, want)
+	}
+}
+
+func TestWriteBehindFullBatch(t *testing.T) {
+	s := newBatchStore()
+	var handled []error
+	var mu sync.Mutex
+	c := New[string, int](10, WithWriteBehind[string, int](s, 2, time.Hour), WithStoreErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, err)
+	}))
+
+	// a full batch is flushed without waiting for the interval
+	c.Insert("a", 1)
+	c.Insert("b", 2)
+	<-s.flushed
+	if got := s.writes(); !slices.Equal(got, []string{"setBatch a=1 b=2"}) {
+		t.Errorf("store saw %q, want the full batch", got)
+	}
+
+	// failed flushes are reported to the store error handler
+	boom := errors.New("store down")
+	s.fail(boom)
+	c.Insert("a", 3)
+	c.Insert("c", 3)
+	<-s.flushed
+	c.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 1 || !errors.Is(handled[0], boom) {
+		t.Errorf("store error handler got %v, want the failed flush", handled)
+	}
+	if st := c.Stats(); st.StoreErrors != 1 {
+		t.Errorf("StoreErrors = %d, want 1", st.StoreErrors)
+	}
+}
+
+func TestWeigher(t *testing.T) {
+	var evicted []string
+	c := New[string, int](10, WithShards(1), WithWeigher(func(_ string, v int) int { return v }),
+		WithOnEvict(func(key string, _ int, reason EvictReason) {
+			if reason != EvictCapacity {
+				t.Errorf("%s evicted for %v, want capacity", key, reason)
+			}
+			evicted = append(evicted, key)
+		}))
+	check := func(keys []string, weight int, wantEvicted ...string) {
+		t.Helper()
+		if got := c.Keys(); !slices.Equal(got, keys) {
+			t.Errorf("keys = %q, want %q", got, keys)
+		}
+		if got := c.Weight(); got != weight {
+			t.Errorf("Weight = %d, want %d", got, weight)
+		}
+		if !slices.Equal(evicted, wantEvicted) {
+			t.Errorf("evicted %q, want %q", evicted, wantEvicted)
+		}
+	}
+	c.Insert("a", 3)
+	c.Insert("b", 3)
+	c.Insert("c", 3)
+	check([]string{"c", "b", "a"}, 9)
+
+	// the oldest items are evicted until the new one fits
+	c.Insert("d", 5)
+	check([]string{"d", "c"}, 8, "a", "b")
+
+	// an item heavier than the capacity leaves the cache unchanged
+	if err := c.Insert("e", 11); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Insert of 11 = %v, want ErrTooLarge", err)
+	}
+	check([]string{"d", "c"}, 8, "a", "b")
+
+	// a heavier replacement evicts other items to fit
+	c.Insert("c", 7)
+	check([]string{"c"}, 7, "a", "b", "d")
+
+	// Resize is in weight units
+	c.Resize(5)
+	check(nil, 0, "a", "b", "d", "c")
+	c.Insert("f", 4)
+	c.Insert("g", 1)
+	check([]string{"g", "f"}, 5, "a", "b", "d", "c")
+	c.Insert("h", 1)
+	check([]string{"h", "g"}, 2, "a", "b", "d", "c", "f")
+}
+
+func TestNewWithMaxBytes(t *testing.T) {
+	c := NewWithMaxBytes[string, string](2000, WithShards(1))
+	value := strings.Repeat("x", 400)
+	size := SizeOf("a", value)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := c.Insert(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fit := 2000 / size
+	if c.Len() != fit || c.Weight() != fit*size {
+		t.Errorf("cache of 2000 bytes holds %d items weighing %d, want %d of %d bytes", c.Len(), c.Weight(), fit, size)
+	}
+	if err := c.Insert("big", strings.Repeat("x", 2000)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Insert of 2000 bytes = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestNewWithMaxBytesPrealloc(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"lru":     nil,
+		"arc":     {WithPolicy(NewARCPolicy[string])},
+		"tinylfu": {WithTinyLFU()},
+	} {
+		// the byte budget is not an item count to allocate for
+		before := heapInUse()
+		c := NewWithMaxBytes[string, float64](64<<20, opts...)
+		if after := heapInUse(); after > before+16<<20 {
+			t.Errorf("%s cache of 64 MiB allocated %d bytes before any item", name, after-before)
+		}
+		c.Insert("a", 0.0725)
+		if item, err := c.Get("a"); err != nil || item.Value() != 0.0725 {
+			t.Errorf("%s Get(a) = %v, %v, want 0.0725", name, item, err)
+		}
+	}
+}
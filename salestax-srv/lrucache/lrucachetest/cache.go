@@ -0,0 +1,153 @@
+package lrucachetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jared-d-smith/psl/salestax-srv/cache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Op names a Cache operation in a recorded Call.
+type Op string
+
+// Operations recorded by Cache.
+const (
+	OpGet    Op = "Get"
+	OpSet    Op = "Set"
+	OpDelete Op = "Delete"
+	OpLoad   Op = "Load" // a loader call made by GetOrLoad
+)
+
+// Call is one recorded Cache operation.
+type Call[K comparable] struct {
+	Op  Op
+	Key K
+	Hit bool // for OpGet, whether it was a hit; for OpLoad, whether it succeeded
+}
+
+// Cache is an in-memory cache.Cache with GetOrLoad, for testing code that
+// uses an lrucache.LRUCache. It never evicts or expires anything: whether a
+// lookup hits is decided by the test, through Set, Delete and Miss. Loads
+// are not coalesced. It is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	mu     sync.Mutex
+	items  map[K]V
+	misses map[K]int // forced misses left per key
+	calls  []Call[K]
+	stats  lrucache.Stats
+}
+
+var _ cache.Cache[string, int] = (*Cache[string, int])(nil)
+
+// NewCache returns an empty cache.
+func NewCache[K comparable, V any]() *Cache[K, V] {
+	return &Cache[K, V]{items: make(map[K]V), misses: make(map[K]int)}
+}
+
+// Get returns the value stored for key, unless a miss was scripted with Miss.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLocked(key)
+}
+
+func (c *Cache[K, V]) getLocked(key K) (V, bool) {
+	value, ok := c.items[key]
+	if n := c.misses[key]; n > 0 {
+		c.misses[key] = n - 1
+		var zero V
+		value, ok = zero, false
+	}
+	if ok {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+	c.calls = append(c.calls, Call[K]{Op: OpGet, Key: key, Hit: ok})
+	return value, ok
+}
+
+// Set stores value under key. It never fails.
+func (c *Cache[K, V]) Set(key K, value V) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = value
+	c.calls = append(c.calls, Call[K]{Op: OpSet, Key: key})
+	return nil
+}
+
+// Delete removes key and reports whether it was present.
+func (c *Cache[K, V]) Delete(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	delete(c.items, key)
+	c.calls = append(c.calls, Call[K]{Op: OpDelete, Key: key, Hit: ok})
+	return ok
+}
+
+// Len returns the number of stored values.
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// GetOrLoad mirrors lrucache.LRUCache.GetOrLoadCtx: on a miss it calls
+// loader and stores the value. A failed load returns an error wrapping
+// lrucache.ErrLoaderFailed, or ctx.Err() if ctx is done; a miss without a
+// loader returns lrucache.ErrNotFound.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, loader lrucache.LoaderFuncCtx[K, V]) (V, error) {
+	c.mu.Lock()
+	value, ok := c.getLocked(key)
+	c.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	var zero V
+	if loader == nil {
+		return zero, lrucache.ErrNotFound
+	}
+
+	value, err := loader(ctx, key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.LoaderCalls++
+	c.calls = append(c.calls, Call[K]{Op: OpLoad, Key: key, Hit: err == nil})
+	if err != nil {
+		c.stats.LoaderErrors++
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return zero, ctxErr
+		}
+		return zero, fmt.Errorf("%w: %w", lrucache.ErrLoaderFailed, err)
+	}
+	c.items[key] = value
+	return value, nil
+}
+
+// Miss makes the next n lookups of key miss, whether or not it is stored.
+func (c *Cache[K, V]) Miss(key K, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses[key] = n
+}
+
+// Calls returns the recorded operations, in call order.
+func (c *Cache[K, V]) Calls() []Call[K] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call[K](nil), c.calls...)
+}
+
+// Stats returns the hit, miss and loader counters and the size, like
+// lrucache.LRUCache.Stats; the other counters stay zero.
+func (c *Cache[K, V]) Stats() lrucache.Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	st.Size = len(c.items)
+	st.Weight = st.Size
+	return st
+}
@@ -0,0 +1,135 @@
+package lrucachetest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Clock is a fake clock that only moves when Advance is called. It is safe
+// for concurrent use. It implements lrucache.Clock, so that a cache given
+// lrucache.WithClock(clock) expires, refreshes and sweeps its items as the
+// test advances it.
+type Clock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when a timer is added
+	now    time.Time
+	timers []*timer // pending, in no particular order
+}
+
+var _ lrucache.Clock = (*Clock)(nil)
+
+// timer is a pending timer of a Clock, firing at at.
+type timer struct {
+	clock *Clock
+	at    time.Time
+	c     chan time.Time
+}
+
+// NewClock returns a clock set to now.
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After is time.After on the fake clock: the channel receives the time once
+// Advance has moved the clock d past now. A d of zero or less fires right
+// away.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer is time.NewTimer on the fake clock, firing as After does.
+func (c *Clock) NewTimer(d time.Duration) lrucache.Timer {
+	t := &timer{clock: c, c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startLocked(t, d)
+	return t
+}
+
+// startLocked fires t once the clock is d past now, right away if d is
+// zero or less.
+func (c *Clock) startLocked(t *timer, d time.Duration) {
+	if d <= 0 {
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		return
+	}
+	t.at = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+}
+
+// stopLocked removes t from the pending timers, and reports whether it was.
+func (c *Clock) stopLocked(t *timer) bool {
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+	c.timers = slices.Delete(c.timers, i, i+1)
+	return true
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.stopLocked(t)
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	pending := t.clock.stopLocked(t)
+	t.clock.startLocked(t, d)
+	return pending
+}
+
+// Advance moves the clock forward by d and fires every timer that is due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		// a fired value not received yet stands for this one, as with
+		// time.Timer
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	clear(c.timers[len(pending):])
+	c.timers = pending
+}
+
+// WaitForTimers blocks until at least n timers are waiting, e.g. until n
+// loads are in their scripted latency, so that a following Advance is known
+// to release them.
+func (c *Clock) WaitForTimers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
@@ -0,0 +1,20 @@
+// Package lrucachetest provides test doubles for code that uses lrucache:
+//
+//   - Cache, a deterministic in-memory cache whose hits and misses can be
+//     scripted and which records every call;
+//   - Loader, a loader returning scripted results after scripted latencies
+//     and recording the keys it was called with;
+//   - Clock, a fake clock driving those latencies and, given to
+//     lrucache.WithClock, the TTLs, refreshes and sweeps of a cache, so
+//     that a test decides when a slow load returns or an item expires
+//     instead of sleeping.
+//
+// A Loader can be passed to a real LRUCache as well as to Cache:
+//
+//	clock := lrucachetest.NewClock(time.Now())
+//	loader := lrucachetest.NewLoader[string, float64](clock)
+//	loader.Script("1 Main St", lrucachetest.Result[float64]{Value: 0.0725, Latency: time.Second})
+//	go c.GetOrLoadCtx(ctx, "1 Main St", loader.Load)
+//	clock.WaitForTimers(1) // the load is waiting for its latency
+//	clock.Advance(time.Second)
+package lrucachetest
@@ -0,0 +1,94 @@
+package lrucachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrUnscripted is returned by Loader for a key without a scripted result.
+var ErrUnscripted = errors.New("lrucachetest: no result scripted for key")
+
+// Result is a scripted outcome of a Loader call.
+type Result[V any] struct {
+	Value   V
+	Err     error
+	Latency time.Duration // on the Loader's clock, before the call returns
+}
+
+// Loader is a fake loader returning scripted results. Its Load method has
+// the signature of lrucache.LoaderFuncCtx. It is safe for concurrent use.
+type Loader[K comparable, V any] struct {
+	clock *Clock
+
+	mu      sync.Mutex
+	results map[K][]Result[V]
+	calls   []K
+}
+
+// NewLoader returns a loader without scripted results. Latencies are waited
+// for on clock, which may be nil if no result has one.
+func NewLoader[K comparable, V any](clock *Clock) *Loader[K, V] {
+	return &Loader[K, V]{clock: clock, results: make(map[K][]Result[V])}
+}
+
+// Script sets the results of the following calls for key, in order. The
+// last result is repeated for any further calls.
+func (l *Loader[K, V]) Script(key K, results ...Result[V]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results[key] = results
+}
+
+// Load records the call and returns the next scripted result for key after
+// its latency, or ctx.Err() if ctx is done first.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	l.calls = append(l.calls, key)
+	results, ok := l.results[key]
+	var r Result[V]
+	if ok && len(results) > 0 {
+		r = results[0]
+		if len(results) > 1 {
+			l.results[key] = results[1:]
+		}
+	} else {
+		r.Err = fmt.Errorf("%w: %v", ErrUnscripted, key)
+	}
+	l.mu.Unlock()
+
+	if r.Latency > 0 {
+		if l.clock == nil {
+			panic("lrucachetest: Result.Latency requires a Loader with a Clock")
+		}
+		select {
+		case <-l.clock.After(r.Latency):
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	return r.Value, r.Err
+}
+
+// Calls returns the keys Load was called with, in call order.
+func (l *Loader[K, V]) Calls() []K {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]K(nil), l.calls...)
+}
+
+// CallCount returns how often Load was called for key.
+func (l *Loader[K, V]) CallCount(key K) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, k := range l.calls {
+		if k == key {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,105 @@
+package lrucachetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache[string, int]()
+	c.Set("a", 1)
+	c.Miss("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Error("scripted miss hit")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get = %d, %v, want 1, true", v, ok)
+	}
+
+	loader := NewLoader[string, int](nil)
+	loader.Script("b", Result[int]{Err: errors.New("down")}, Result[int]{Value: 2})
+	if _, err := c.GetOrLoad(context.Background(), "b", loader.Load); !errors.Is(err, lrucache.ErrLoaderFailed) {
+		t.Errorf("GetOrLoad with failing loader: err = %v, want ErrLoaderFailed", err)
+	}
+	if v, err := c.GetOrLoad(context.Background(), "b", loader.Load); err != nil || v != 2 {
+		t.Errorf("GetOrLoad = %d, %v, want 2, nil", v, err)
+	}
+	if v, err := c.GetOrLoad(context.Background(), "b", loader.Load); err != nil || v != 2 {
+		t.Errorf("GetOrLoad of loaded key = %d, %v, want 2, nil", v, err)
+	}
+	if n := loader.CallCount("b"); n != 2 {
+		t.Errorf("loader called %d times, want 2", n)
+	}
+
+	want := []Call[string]{
+		{OpSet, "a", false},
+		{OpGet, "a", false},
+		{OpGet, "a", true},
+		{OpGet, "b", false},
+		{OpLoad, "b", false},
+		{OpGet, "b", false},
+		{OpLoad, "b", true},
+		{OpGet, "b", true},
+	}
+	if got := c.Calls(); !slices.Equal(got, want) {
+		t.Errorf("Calls = %v, want %v", got, want)
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 3 || st.LoaderCalls != 2 || st.LoaderErrors != 1 || st.Size != 2 {
+		t.Errorf("Stats = %+v", st)
+	}
+}
+
+func TestLoaderLatency(t *testing.T) {
+	clock := NewClock(time.Now())
+	loader := NewLoader[string, int](clock)
+	loader.Script("a", Result[int]{Value: 1, Latency: time.Second})
+	c := lrucache.New[string, int](10)
+
+	done := make(chan int)
+	go func() {
+		v, _ := c.GetOrLoadCtx(context.Background(), "a", loader.Load)
+		done <- v
+	}()
+	clock.WaitForTimers(1)
+	clock.Advance(time.Second - time.Nanosecond)
+	select {
+	case <-done:
+		t.Fatal("load returned before its latency elapsed")
+	default:
+	}
+	clock.Advance(time.Nanosecond)
+	if v := <-done; v != 1 {
+		t.Errorf("GetOrLoadCtx = %d, want 1", v)
+	}
+	if _, err := loader.Load(context.Background(), "unknown"); !errors.Is(err, ErrUnscripted) {
+		t.Errorf("Load of unscripted key: err = %v, want ErrUnscripted", err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := NewClock(time.Now())
+	c := lrucache.New[string, int](10, lrucache.WithClock(clock), lrucache.WithTTL(time.Minute), lrucache.WithSweepInterval(time.Hour))
+	defer c.Close()
+	c.Insert("a", 1)
+	clock.Advance(time.Minute)
+	if item, err := c.Get("a"); err != nil || item.Value() != 1 {
+		t.Errorf("Get after the TTL = %v, %v, want 1", item, err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := c.Get("a"); !errors.Is(err, lrucache.ErrExpired) {
+		t.Errorf("Get past the TTL: err = %v, want ErrExpired", err)
+	}
+
+	c.Insert("b", 2)
+	clock.WaitForTimers(1) // the sweeper
+	clock.Advance(time.Hour)
+	clock.WaitForTimers(1) // the sweeper, once the sweep is done
+	if n := c.Len(); n != 0 {
+		t.Errorf("%d items left after the sweep, want 0", n)
+	}
+}
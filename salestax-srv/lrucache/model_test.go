@@ -0,0 +1,121 @@
+package lrucache
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// lruModel is a trivially correct LRU cache used as the reference in
+// TestLRUModel: keys are kept in a slice, most recently used first.
+type lruModel struct {
+	size   int
+	keys   []int
+	values map[int]int
+}
+
+func (m *lruModel) touch(k int) {
+	m.keys = slices.Insert(slices.DeleteFunc(m.keys, func(x int) bool { return x == k }), 0, k)
+}
+
+func (m *lruModel) insert(k, v int) {
+	m.touch(k)
+	m.values[k] = v
+	if len(m.keys) > m.size {
+		delete(m.values, m.keys[m.size])
+		m.keys = m.keys[:m.size]
+	}
+}
+
+func (m *lruModel) get(k int) (int, bool) {
+	v, ok := m.values[k]
+	if ok {
+		m.touch(k)
+	}
+	return v, ok
+}
+
+func (m *lruModel) delete(k int) bool {
+	_, ok := m.values[k]
+	delete(m.values, k)
+	m.keys = slices.DeleteFunc(m.keys, func(x int) bool { return x == k })
+	return ok
+}
+
+// TestLRUModel runs random operation sequences against the cache and the
+// reference model and requires identical results and eviction order after
+// every step.
+func TestLRUModel(t *testing.T) {
+	for seed := range uint64(50) {
+		r := rand.New(rand.NewPCG(seed, seed))
+		size := 1 + r.IntN(8)
+		keys := 2 * size
+		c := New[int, int](size)
+		m := &lruModel{size: size, values: map[int]int{}}
+		for step := range 500 {
+			k := r.IntN(keys)
+			switch r.IntN(4) {
+			case 0, 1:
+				v := r.Int()
+				c.Insert(k, v)
+				m.insert(k, v)
+			case 2:
+				want, ok := m.get(k)
+				item, err := c.Get(k)
+				if ok != (err == nil) || ok && item.Value() != want {
+					t.Fatalf("seed %d step %d: Get(%d) = %v, %v, model has %d, %v", seed, step, k, item, err, want, ok)
+				}
+			case 3:
+				if got, want := c.Delete(k), m.delete(k); got != want {
+					t.Fatalf("seed %d step %d: Delete(%d) = %v, model %v", seed, step, k, got, want)
+				}
+			}
+			if got := c.Keys(); !slices.Equal(got, m.keys) {
+				t.Fatalf("seed %d step %d: Keys = %v, model %v", seed, step, got, m.keys)
+			}
+		}
+	}
+}
+
+// TestPolicyInvariants checks, for every policy, that random operation
+// sequences never exceed the capacity and never return a stale or deleted
+// value.
+func TestPolicyInvariants(t *testing.T) {
+	policies := map[string]PolicyFactory[int]{
+		"lru":    NewLRUPolicy[int],
+		"lfu":    NewLFUPolicy[int],
+		"fifo":   NewFIFOPolicy[int],
+		"random": NewRandomPolicy[int],
+		"arc":    NewARCPolicy[int],
+		"slru":   NewSLRUPolicy[int],
+	}
+	for name, factory := range policies {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(1, 2))
+			const size = 16
+			c := New[int, int](size, WithPolicy(factory))
+			latest := map[int]int{}
+			for step := range 5000 {
+				k := r.IntN(4 * size)
+				switch r.IntN(3) {
+				case 0:
+					v := r.Int()
+					c.Insert(k, v)
+					latest[k] = v
+				case 1:
+					if item, err := c.Get(k); err == nil {
+						if v, ok := latest[k]; !ok || item.Value() != v {
+							t.Fatalf("step %d: Get(%d) = %d, latest value %d, %v", step, k, item.Value(), v, ok)
+						}
+					}
+				case 2:
+					c.Delete(k)
+					delete(latest, k)
+				}
+				if n := c.Len(); n > size {
+					t.Fatalf("step %d: Len = %d, exceeds the capacity %d", step, n, size)
+				}
+			}
+		})
+	}
+}
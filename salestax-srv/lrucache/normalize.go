@@ -0,0 +1,29 @@
+package lrucache
+
+// WithKeyNormalizer maps every key passed to the cache through fn before it
+// is used, so that spellings of the same key share one entry: e.g.
+// addrnorm.Normalize for street addresses. Lookups, inserts, deletes and
+// their batch forms are covered; the loader, the store and the second tier
+// see the normalized key, and Keys, Range and the eviction callback return
+// it. FastRateLookupMulti still returns the caller's keys. fn must be
+// deterministic, and normalizing a normalized key must not change it. K must
+// match the cache being constructed, otherwise New panics.
+func WithKeyNormalizer[K comparable](fn func(key K) K) Option {
+	return func(o *options) {
+		o.normalize = fn
+	}
+}
+
+// normalize returns key as stored by the cache.
+func (c *LRUCache[K, V]) normalize(key K) K {
+	if c.norm == nil {
+		return key
+	}
+	return c.norm(key)
+}
+
+// NormalizeKey returns key as the cache stores it: normalized by the
+// function given to WithKeyNormalizer, or key itself without one.
+func (c *LRUCache[K, V]) NormalizeKey(key K) K {
+	return c.normalize(key)
+}
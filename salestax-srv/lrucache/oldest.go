@@ -0,0 +1,121 @@
+package lrucache
+
+import "time"
+
+// Oldest returns the item that RemoveOldest would remove first: the next
+// victim of the eviction policy, or with several shards the one of their next
+// victims that was stored or hit the longest ago. ok is false if the cache is
+// empty. Under WithApproximateLRU a victim hit since the last eviction may
+// still get a second chance. With WithEngine and several shards it is the
+// next victim of the first shard holding items.
+func (c *LRUCache[K, V]) Oldest() (item *CacheItem[K, V], ok bool) {
+	for _, s := range c.shards {
+		if v, ok := s.oldest(); ok && (item == nil || v.lastUsed().Before(item.lastUsed())) {
+			item = v
+		}
+	}
+	return item, item != nil
+}
+
+// Newest returns the item stored or hit last. ok is false if the cache is
+// empty. It visits every item, with each shard's read lock held in turn.
+// With WithEngine and several shards it is the newest item of the first
+// shard holding items.
+func (c *LRUCache[K, V]) Newest() (item *CacheItem[K, V], ok bool) {
+	for _, s := range c.shards {
+		if v, ok := s.newest(); ok && (item == nil || v.lastUsed().After(item.lastUsed())) {
+			item = v
+		}
+	}
+	return item, item != nil
+}
+
+// RemoveOldest evicts up to n items, the cold tail of the cache, one Oldest
+// at a time, and returns the number evicted. It is for shedding memory on
+// demand, e.g. from a controller watching the heap: the eviction callbacks
+// fire with EvictCapacity and the items count as Stats.Evictions. The store
+// and the second tier are left alone. With WithEngine, whose items do not
+// tell when they were used, the shards give up their oldest items in turn.
+func (c *LRUCache[K, V]) RemoveOldest(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if len(c.shards) == 1 {
+		return c.shards[0].removeOldest(n)
+	}
+	if c.shards[0].engine != nil {
+		removed, idle := 0, 0
+		for i := 0; removed < n && idle < len(c.shards); i++ {
+			if c.shards[i%len(c.shards)].removeOldest(1) > 0 {
+				removed, idle = removed+1, 0
+			} else {
+				idle++
+			}
+		}
+		return removed
+	}
+	victims := make([]*CacheItem[K, V], len(c.shards))
+	for i, s := range c.shards {
+		victims[i], _ = s.oldest()
+	}
+	removed := 0
+	for removed < n {
+		i := -1
+		for j, v := range victims {
+			if v != nil && (i < 0 || v.lastUsed().Before(victims[i].lastUsed())) {
+				i = j
+			}
+		}
+		if i < 0 {
+			break
+		}
+		removed += c.shards[i].removeOldest(1)
+		victims[i], _ = c.shards[i].oldest()
+	}
+	return removed
+}
+
+// oldest returns the next victim of the segment.
+func (s *segment[K, V]) oldest() (*CacheItem[K, V], bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.engine != nil {
+		key, value, expires, ok := s.engine.Oldest()
+		if !ok {
+			return nil, false
+		}
+		return engineItem(key, value, expires), true
+	}
+	key, ok := s.policy.Victim()
+	if !ok {
+		return nil, false
+	}
+	return s.cache[key], true
+}
+
+// newest returns the item of the segment stored or hit last.
+func (s *segment[K, V]) newest() (*CacheItem[K, V], bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var newest *CacheItem[K, V]
+	if s.engine != nil {
+		s.engine.Range(func(key K, value V, expires time.Time) bool {
+			newest = engineItem(key, value, expires)
+			return false
+		})
+		return newest, newest != nil
+	}
+	for _, item := range s.cache {
+		if newest == nil || item.lastUsed().After(newest.lastUsed()) {
+			newest = item
+		}
+	}
+	return newest, newest != nil
+}
+
+// removeOldest evicts the next n victims of the segment.
+func (s *segment[K, V]) removeOldest(n int) int {
+	s.mutex.Lock()
+	defer s.unlock()
+	return s.prune(n, EvictCapacity)
+}
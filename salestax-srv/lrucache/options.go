@@ -0,0 +1,338 @@
+package lrucache
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// Option configures optional LRUCache behavior at construction time, e.g.
+// New[string, float64](sz, WithTTL(24*time.Hour)).
+type Option func(*options)
+
+type options struct {
+	ttl           time.Duration
+	sweepInterval time.Duration
+	negativeTTL   time.Duration
+	loader        any // LoaderFuncCtx[K, V], checked by New
+	shards        int
+	approximate   bool
+	tinyLFU       bool
+	policy        any // PolicyFactory[K], checked by New
+	engine        any // EngineFactory[K, V], checked by New
+	onEvict       any // OnEvictFunc[K, V], checked by New
+	weigher       any // WeigherFunc[K, V], checked by New
+	stale         time.Duration
+
+	loaderTimeout     time.Duration
+	loaderConcurrency int
+	loaderRate        float64
+	loaderFailFast    bool
+	breakerFailures   int
+	breakerCooldown   time.Duration
+	retry             *RetryPolicy
+
+	refreshAhead     time.Duration
+	refreshAheadHits int
+
+	store         any // Store[K, V], checked by New
+	writeBehind   bool
+	storeBatch    int
+	storeInterval time.Duration
+	onStoreError  func(error)
+	l2            any // SecondTier[K, V], checked by New
+	journal       any // Journal[K, V], checked by New
+
+	logger   *slog.Logger
+	slowLoad time.Duration
+	tracers  []any // Tracer[K]s, checked by New
+
+	hotKeys       int
+	hotKeysWindow time.Duration
+	statsWindows  []time.Duration
+	normalize     any // func(K) K, checked by New
+	canonicalizer any // KeyCanonicalizer[K], checked by New
+	clock         Clock
+	rand          rand.Source
+	pressure      *MemoryPressure
+	interner      any // *Interner[K], checked by New
+}
+
+// WithTTL sets the cache-wide time to live applied by Insert. Entries older
+// than d are treated as missing. A zero or negative d disables expiration.
+func WithTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// WithSweepInterval starts a background goroutine that removes expired
+// entries every d. Without it expired entries are only dropped lazily when
+// they are looked up. Entries are indexed by expiration time, so a sweep only
+// visits the ones that are due. Call Close to stop the sweeper.
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = d
+	}
+}
+
+// WithLoader attaches the loader used by Lookup, so that every call site of a
+// cache shares one loader. K and V must match the cache being constructed,
+// otherwise New panics.
+func WithLoader[K comparable, V any](fn LoaderFunc[K, V]) Option {
+	return WithLoaderCtx(fn.WithContext())
+}
+
+// WithLoaderCtx is WithLoader for a context aware loader.
+func WithLoaderCtx[K comparable, V any](fn LoaderFuncCtx[K, V]) Option {
+	return func(o *options) {
+		o.loader = fn
+	}
+}
+
+// WithNegativeTTL remembers loader failures for d so that GetOrLoad
+// returns the cached error instead of calling the loader again for a key that
+// is known to be bad (e.g. an invalid ZIP). Keep d short; a successful Insert
+// or Delete of the key clears the negative entry early.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.negativeTTL = d
+	}
+}
+
+// WithLoaderTimeout abandons a loader call that has not returned after d.
+// The loader's context is cancelled at the deadline, and the caller stops
+// waiting even if the loader ignores it. A timed out call counts as a
+// loader error (and is retried under WithRetry), as opposed to the caller's
+// own context expiring.
+func WithLoaderTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.loaderTimeout = d
+	}
+}
+
+// WithLoaderConcurrency allows at most n loader calls to run at once across
+// all shards. Further misses on other keys wait for a running call to finish,
+// or until their context is done; see WithLoaderFailFast. Coalesced misses
+// on the same key share one call and count once.
+func WithLoaderConcurrency(n int) Option {
+	return func(o *options) {
+		o.loaderConcurrency = n
+	}
+}
+
+// WithLoaderRate limits loader calls to r per second on average, allowing
+// bursts of up to r calls (at least one). Misses over the limit wait for
+// their turn, or until their context is done; see WithLoaderFailFast.
+func WithLoaderRate(r float64) Option {
+	return func(o *options) {
+		o.loaderRate = r
+	}
+}
+
+// WithLoaderFailFast makes misses over the WithLoaderConcurrency or
+// WithLoaderRate limit return an error right away instead of waiting. The
+// error is not negatively cached.
+func WithLoaderFailFast() Option {
+	return func(o *options) {
+		o.loaderFailFast = true
+	}
+}
+
+// WithCircuitBreaker stops calling the loader after failures consecutive
+// loader errors: for the following cooldown GetOrLoad, Lookup and
+// FastRateLookupMulti fail misses right away with ErrBackendUnavailable
+// instead of waiting out a backend that is down. After the cooldown one
+// trial call is let through; if it succeeds the loader is used again,
+// otherwise the breaker stays open for another cooldown. Loads abandoned
+// because the caller's context is done are not counted. Hits, including
+// stale ones, are served as usual.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.breakerFailures = failures
+		o.breakerCooldown = cooldown
+	}
+}
+
+// WithRetry retries failed loader calls according to policy before the
+// load fails, waiting with exponential backoff between attempts. Callers of
+// GetOrLoad wait for the retries, up to their context deadline; the
+// attempts of a load count as one failure for WithCircuitBreaker and hold
+// one WithLoaderConcurrency slot.
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = &policy
+	}
+}
+
+// WithStaleWhileRevalidate keeps expired items for window past their TTL.
+// During that window GetOrLoad and Lookup return the stale value
+// immediately and reload the key in the background, so callers never wait
+// for the loader on an expired hot key. Get still reports such items as
+// expired. At most one refresh per key runs at a time; a failed refresh
+// leaves the stale value in place until the window passes.
+func WithStaleWhileRevalidate(window time.Duration) Option {
+	return func(o *options) {
+		o.stale = window
+	}
+}
+
+// WithRefreshAhead reloads hot items in the background before they expire.
+// When GetOrLoad or Lookup hits an item that expires within window and
+// has been hit at least minHits times since it was loaded, the loader is run
+// asynchronously and its result replaces the item, so frequently requested
+// keys never see a miss. Rarely used keys are left to expire. It only has an
+// effect together with a TTL.
+func WithRefreshAhead(window time.Duration, minHits int) Option {
+	return func(o *options) {
+		o.refreshAhead = window
+		o.refreshAheadHits = minHits
+	}
+}
+
+// WithWriteThrough persists every Insert to store before the cache is updated;
+// if the store fails, Insert returns its error and the cache is left
+// unchanged. Delete removes the key from the store as well. Values obtained
+// from a loader are not written back, and without a configured loader
+// Lookup reads misses from store. K and V must match the cache being
+// constructed, otherwise New panics.
+func WithWriteThrough[K comparable, V any](store Store[K, V]) Option {
+	return func(o *options) {
+		o.store = store
+		o.writeBehind = false
+	}
+}
+
+// WithWriteBehind is WithWriteThrough with asynchronous writes: Insert and
+// Delete only queue the change, and a background goroutine applies queued
+// changes every interval or as soon as batch distinct keys are waiting,
+// keeping only the last change per key. A store implementing BatchStore
+// receives all values of a flush at once. Close flushes the queue; call it
+// before exiting. Zero values select DefaultWriteBehindBatch and
+// DefaultWriteBehindInterval.
+func WithWriteBehind[K comparable, V any](store Store[K, V], batch int, interval time.Duration) Option {
+	return func(o *options) {
+		o.store = store
+		o.writeBehind = true
+		o.storeBatch = batch
+		o.storeInterval = interval
+	}
+}
+
+// WithStoreErrorHandler registers fn to be called with store errors that
+// cannot be returned to a caller: failed write-behind flushes and failed
+// write-through deletes. Errors are also counted in Stats.StoreErrors.
+func WithStoreErrorHandler(fn func(err error)) Option {
+	return func(o *options) {
+		o.onStoreError = fn
+	}
+}
+
+// WithSecondTier adds a shared second cache tier behind the in-process one.
+// Misses are looked up in l2 before calling the loader, and values from the
+// loader, Insert and Delete are propagated to it, using the cache-wide or
+// per-item TTL. Errors from l2 are counted in Stats.L2Errors and otherwise
+// treated as a miss. K and V must match the cache being constructed,
+// otherwise New panics.
+func WithSecondTier[K comparable, V any](l2 SecondTier[K, V]) Option {
+	return func(o *options) {
+		o.l2 = l2
+	}
+}
+
+// WithJournal records the items inserted into the cache, whether written or
+// loaded, and those deleted from it in j, e.g. a write-ahead log replayed on
+// restart; see Journal. K and V must match the cache being constructed,
+// otherwise New panics.
+func WithJournal[K comparable, V any](j Journal[K, V]) Option {
+	return func(o *options) {
+		o.journal = j
+	}
+}
+
+// WithLogger logs cache events at debug level to logger: item removals with
+// their reason, failed loader calls, slow loader calls and snapshot saves and
+// restores, and at info level the resizes of WithMemoryPressure. Nothing is
+// logged by default. Keys are included in the records,
+// so mind what they contain before enabling debug logging in production.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithSlowLoadThreshold sets the loader latency from which a successful load
+// is logged as slow. The default is DefaultSlowLoad.
+func WithSlowLoadThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slowLoad = d
+	}
+}
+
+// WithTracer reports every GetOrLoad and Lookup to tracer, e.g. to
+// create a trace span per lookup (see the tracing package). Given more than
+// once, every tracer sees every lookup, the first one outermost. K must
+// match the cache being constructed, otherwise New panics.
+func WithTracer[K comparable](tracer Tracer[K]) Option {
+	return func(o *options) {
+		o.tracers = append(o.tracers, tracer)
+	}
+}
+
+// WithShards partitions the keys across n independently locked LRU segments
+// to reduce lock contention on many-core machines. Each shard gets an equal
+// part of the capacity and evicts on its own, so eviction order is only LRU
+// within a shard. n is capped at the cache size; the default is 1.
+func WithShards(n int) Option {
+	return func(o *options) {
+		o.shards = n
+	}
+}
+
+// WithApproximateLRU trades exact recency tracking for a read path that only
+// takes the read lock. A hit marks the item as referenced instead of informing
+// the eviction policy; at eviction time a referenced victim gets a second
+// chance (CLOCK) and is reported to the policy as accessed. Hot items still
+// survive, but among items hit since the last eviction the order is
+// approximate. It can be combined with any policy.
+func WithApproximateLRU() Option {
+	return func(o *options) {
+		o.approximate = true
+	}
+}
+
+// WithPolicy selects the eviction policy, e.g. WithPolicy(NewLFUPolicy[string]).
+// factory is called once per shard with the shard capacity. K must match the
+// cache being constructed, otherwise New panics. The default is
+// NewLRUPolicy.
+func WithPolicy[K comparable](factory PolicyFactory[K]) Option {
+	return func(o *options) {
+		o.policy = factory
+	}
+}
+
+// WithEngine stores the items of every shard in an Engine made by factory
+// with the shard capacity, instead of a map and an eviction policy, e.g.
+// WithEngine(compact.NewEngine[float64]) for entries the garbage collector
+// does not scan. The engine evicts the least recently used item. Its items
+// keep no per-item state: they have no version, creation time or hits, so
+// New panics when WithEngine is combined with WithPolicy, WithWeigher,
+// WithApproximateLRU, WithRefreshAhead or WithKeyInterner, and SetPolicy has
+// no effect. K and V must match the cache being constructed, otherwise New
+// panics.
+func WithEngine[K comparable, V any](factory EngineFactory[K, V]) Option {
+	return func(o *options) {
+		o.engine = factory
+	}
+}
+
+// WithOnEvict registers fn to be called for every item removed by a capacity
+// eviction, a TTL expiration or an explicit delete. fn runs after the cache
+// lock has been released, on the goroutine that caused the removal, so it may
+// call back into the cache; it should not block for long. K and V must match
+// the cache being constructed, otherwise New panics.
+func WithOnEvict[K comparable, V any](fn func(key K, value V, reason EvictReason)) Option {
+	return func(o *options) {
+		o.onEvict = OnEvictFunc[K, V](fn)
+	}
+}
@@ -0,0 +1,156 @@
+package lrucache
+
+// EvictionPolicy decides which key a full cache evicts. Each shard owns its
+// own policy instance and only calls it with the shard's write lock held, so
+// implementations need no locking of their own.
+type EvictionPolicy[K comparable] interface {
+	// Add records a key that was just inserted.
+	Add(key K)
+	// Access records a hit on, or an update of, a key already present.
+	Access(key K)
+	// Remove forgets a key that was deleted or expired.
+	Remove(key K)
+	// Evict forgets a key that was evicted because the cache was full. It
+	// is separate from Remove for policies that remember evicted keys.
+	Evict(key K)
+	// Victim returns the key that should be evicted next without evicting
+	// it. ok is false if the policy tracks no keys.
+	Victim() (key K, ok bool)
+}
+
+// OrderedPolicy is implemented by policies that can list their keys in
+// eviction order. LRUCache.Keys and Range use it when available.
+type OrderedPolicy[K comparable] interface {
+	EvictionPolicy[K]
+	// Keys returns the resident keys, the next victim last.
+	Keys() []K
+}
+
+// ResizablePolicy is implemented by policies whose bookkeeping depends on the
+// shard capacity. Resize is called when LRUCache.Resize changes it.
+type ResizablePolicy[K comparable] interface {
+	EvictionPolicy[K]
+	Resize(capacity int)
+}
+
+// PolicyFactory returns a new policy for a shard holding up to capacity keys.
+type PolicyFactory[K comparable] func(capacity int) EvictionPolicy[K]
+
+// lruPolicy evicts the least recently used key. This is the default.
+type lruPolicy[K comparable] struct {
+	slab  slab[K]
+	list  nodeList // most recently used first
+	elems map[K]int
+}
+
+// NewLRUPolicy returns a least recently used policy, the default.
+func NewLRUPolicy[K comparable](capacity int) EvictionPolicy[K] {
+	return newLRUPolicy[K](capacity)
+}
+
+func newLRUPolicy[K comparable](capacity int) *lruPolicy[K] {
+	p := &lruPolicy[K]{
+		slab:  newSlab[K](capacity + 1),
+		elems: make(map[K]int, preallocHint(capacity)),
+	}
+	p.list = p.slab.newList()
+	return p
+}
+
+func (p *lruPolicy[K]) Add(key K) {
+	p.elems[key] = p.slab.pushFront(&p.list, key)
+}
+
+func (p *lruPolicy[K]) Access(key K) {
+	if i, ok := p.elems[key]; ok {
+		p.slab.moveToFront(&p.list, &p.list, i)
+	}
+}
+
+func (p *lruPolicy[K]) Remove(key K) {
+	if i, ok := p.elems[key]; ok {
+		p.slab.remove(&p.list, i)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lruPolicy[K]) Evict(key K) {
+	p.Remove(key)
+}
+
+func (p *lruPolicy[K]) Keys() []K {
+	return p.slab.appendKeys(make([]K, 0, p.list.len), &p.list)
+}
+
+func (p *lruPolicy[K]) Victim() (K, bool) {
+	if i, ok := p.slab.back(&p.list); ok {
+		return p.slab.key(i), true
+	}
+	var zero K
+	return zero, false
+}
+
+// fifoPolicy evicts the oldest inserted key regardless of use.
+type fifoPolicy[K comparable] struct {
+	lruPolicy[K]
+}
+
+// NewFIFOPolicy returns a first in, first out policy. Hits do not affect the
+// eviction order.
+func NewFIFOPolicy[K comparable](capacity int) EvictionPolicy[K] {
+	return &fifoPolicy[K]{*newLRUPolicy[K](capacity)}
+}
+
+func (p *fifoPolicy[K]) Access(key K) {}
+
+// randomPolicy evicts a uniformly random key.
+type randomPolicy[K comparable] struct {
+	keys  []K
+	index map[K]int
+	rand  *random
+}
+
+// NewRandomPolicy returns a policy that evicts a random key. It keeps no
+// ordering at all, which makes it the cheapest policy on the hit path.
+func NewRandomPolicy[K comparable](capacity int) EvictionPolicy[K] {
+	return &randomPolicy[K]{
+		keys:  make([]K, 0, preallocHint(capacity)),
+		index: make(map[K]int, preallocHint(capacity)),
+	}
+}
+
+func (p *randomPolicy[K]) Add(key K) {
+	p.index[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+}
+
+func (p *randomPolicy[K]) Access(key K) {}
+
+func (p *randomPolicy[K]) Remove(key K) {
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	// move the last key into the hole
+	last := len(p.keys) - 1
+	p.keys[i] = p.keys[last]
+	p.index[p.keys[i]] = i
+	p.keys = p.keys[:last]
+	delete(p.index, key)
+}
+
+func (p *randomPolicy[K]) Evict(key K) {
+	p.Remove(key)
+}
+
+func (p *randomPolicy[K]) Victim() (K, bool) {
+	if len(p.keys) == 0 {
+		var zero K
+		return zero, false
+	}
+	return p.keys[p.rand.IntN(len(p.keys))], true
+}
+
+func (p *randomPolicy[K]) setRand(r *random) {
+	p.rand = r
+}
@@ -0,0 +1,154 @@
+package lrucache
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"time"
+)
+
+// Defaults of MemoryPressure.
+const (
+	DefaultPressureHigh     = 0.9
+	DefaultPressureLow      = 0.7
+	DefaultPressureStep     = 0.1
+	DefaultPressureInterval = time.Second
+)
+
+// MemoryPressure configures WithMemoryPressure. Zero fields select the
+// defaults. Low must be under High and neither High nor Step may exceed 1,
+// the defaults included, otherwise New panics.
+type MemoryPressure struct {
+	// Limit is the memory in bytes the process should stay under. 0 selects
+	// the soft limit of debug.SetMemoryLimit, set e.g. with GOMEMLIMIT;
+	// without one the cache is never shrunk.
+	Limit uint64
+	// High is the fraction of Limit in use from which the cache shrinks,
+	// DefaultPressureHigh by default.
+	High float64
+	// Low is the fraction of Limit in use under which a shrunk cache
+	// regrows, DefaultPressureLow by default.
+	Low float64
+	// Step is the fraction of the full size by which the cache shrinks or
+	// regrows at each check, DefaultPressureStep by default.
+	Step float64
+	// MinSize is the size the cache is never shrunk below, a tenth of the
+	// full size by default.
+	MinSize int
+	// Interval is the time between checks, DefaultPressureInterval by
+	// default. It should leave the garbage collector the time to reclaim
+	// what the last shrink evicted.
+	Interval time.Duration
+	// Usage returns the memory in use in bytes. By default it is the memory
+	// mapped by the Go runtime less the heap returned to the system, the
+	// amount the soft limit applies to.
+	Usage func() uint64
+}
+
+// WithMemoryPressure starts a background goroutine that checks the memory
+// in use every p.Interval and, while it is over p.High of p.Limit, shrinks
+// the cache by p.Step of its size at each check, evicting as chosen by the
+// eviction policy with EvictPressure. Once the memory in use is back under
+// p.Low, the cache regrows at the same pace to the size given to New or
+// Resize. Shrinking and regrowing are logged at info level with WithLogger.
+// Call Close to stop the goroutine.
+func WithMemoryPressure(p MemoryPressure) Option {
+	return func(o *options) {
+		o.pressure = &p
+	}
+}
+
+func (p MemoryPressure) withDefaults() MemoryPressure {
+	if p.High <= 0 {
+		p.High = DefaultPressureHigh
+	}
+	if p.Low <= 0 {
+		p.Low = DefaultPressureLow
+	}
+	if p.Step <= 0 {
+		p.Step = DefaultPressureStep
+	}
+	if p.Interval <= 0 {
+		p.Interval = DefaultPressureInterval
+	}
+	if p.Usage == nil {
+		p.Usage = memoryInUse
+	}
+	switch {
+	case p.Low >= p.High:
+		panic("LRUCache memory pressure Low must be under High")
+	case p.High > 1:
+		panic("LRUCache memory pressure High above 1")
+	case p.Step > 1:
+		panic("LRUCache memory pressure Step above 1")
+	}
+	return p
+}
+
+// relieve checks the memory pressure every p.Interval until Close.
+func (c *LRUCache[K, V]) relieve(p MemoryPressure) {
+	defer c.wg.Done()
+	t := c.clock.NewTimer(p.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			c.checkPressure(p)
+			t.Reset(p.Interval)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// checkPressure shrinks the cache by a step if the memory in use is over
+// p.High of the limit, and regrows it by a step if it is under p.Low.
+func (c *LRUCache[K, V]) checkPressure(p MemoryPressure) {
+	limit := p.Limit
+	if limit == 0 {
+		soft := debug.SetMemoryLimit(-1)
+		if soft == math.MaxInt64 {
+			return
+		}
+		limit = uint64(soft)
+	}
+	used := p.Usage()
+
+	c.sizeMu.Lock()
+	defer c.sizeMu.Unlock()
+	step := max(int(float64(c.full)*p.Step), 1)
+	floor := p.MinSize
+	if floor <= 0 {
+		floor = c.full / 10
+	}
+	floor = min(max(floor, len(c.shards)), c.full)
+
+	var sz int
+	var reason EvictReason
+	switch {
+	case float64(used) >= p.High*float64(limit) && c.size > floor:
+		sz, reason = max(c.size-step, floor), EvictPressure
+	case float64(used) < p.Low*float64(limit) && c.size < c.full:
+		sz, reason = min(c.size+step, c.full), EvictCapacity
+	default:
+		return
+	}
+	if c.logger != nil {
+		msg := "lrucache: shrinking under memory pressure"
+		if sz > c.size {
+			msg = "lrucache: regrowing after memory pressure"
+		}
+		c.logger.Info(msg, "size", sz, "full", c.full, "used", used, "limit", limit)
+	}
+	c.resizeLocked(sz, reason)
+}
+
+// memoryInUse returns the memory the soft limit of the runtime applies to.
+func memoryInUse() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
@@ -0,0 +1,110 @@
+package lrucache
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/rand/v2"
+	"sync"
+)
+
+// WithRand makes the cache draw its random numbers from src instead of the
+// global source: the victims of NewRandomPolicy, the jitter of WithRetry,
+// and the seeds with which keys are hashed to their shard and into the
+// TinyLFU sketch. Keys are then hashed with FNV-1a of their default
+// formatting instead of maphash, slower for other keys than strings but the
+// same from one run to the next, so that a test or a benchmark seeded alike
+// evicts and admits the same keys every time. src is only used under a lock
+// of the cache, it need not be safe for concurrent use.
+func WithRand(src rand.Source) Option {
+	return func(o *options) {
+		o.rand = src
+	}
+}
+
+// random is the source of the random numbers of a cache. A nil *random uses
+// the global source.
+type random struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newRandom(src rand.Source) *random {
+	if src == nil {
+		return nil
+	}
+	return &random{r: rand.New(src)}
+}
+
+func (r *random) Float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+func (r *random) IntN(n int) int {
+	if r == nil {
+		return rand.IntN(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.IntN(n)
+}
+
+func (r *random) Uint64() uint64 {
+	if r == nil {
+		return rand.Uint64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Uint64()
+}
+
+// randomized is implemented by the policies drawing random numbers, to be
+// given those of the cache.
+type randomized interface {
+	setRand(r *random)
+}
+
+// randomize gives policy the random numbers of the segment, if it draws
+// any.
+func (s *segment[K, V]) randomize(policy EvictionPolicy[K]) {
+	if p, ok := policy.(randomized); ok && s.rand != nil {
+		p.setRand(s.rand)
+	}
+}
+
+// hasher hashes keys, with maphash and a seed of its own, or reproducibly
+// if made by newHasher from the source of WithRand.
+type hasher[K comparable] struct {
+	seed   maphash.Seed
+	stable bool
+	basis  uint64 // FNV-1a offset basis when stable, drawn from the source
+}
+
+// newHasher returns a hasher, stable if r is not nil.
+func newHasher[K comparable](r *random) hasher[K] {
+	if r == nil {
+		return hasher[K]{seed: maphash.MakeSeed()}
+	}
+	return hasher[K]{stable: true, basis: r.Uint64()}
+}
+
+func (h hasher[K]) hash(key K) uint64 {
+	if !h.stable {
+		return maphash.Comparable(h.seed, key)
+	}
+	s, ok := any(key).(string)
+	if !ok {
+		s = fmt.Sprint(key)
+	}
+	const prime = 1099511628211
+	sum := h.basis
+	for i := 0; i < len(s); i++ {
+		sum ^= uint64(s[i])
+		sum *= prime
+	}
+	return sum
+}
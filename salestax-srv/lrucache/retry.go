@@ -0,0 +1,125 @@
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRetryBackoff is the wait before the first retry when
+// RetryPolicy.Backoff is not set.
+const DefaultRetryBackoff = 50 * time.Millisecond
+
+// RetryPolicy configures how failed loader calls are retried, see WithRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the number of loader calls per load, including the
+	// first one. Values below 2 disable retries.
+	MaxAttempts int
+	// Backoff is the wait before the first retry; it doubles for every
+	// further retry. The default is DefaultRetryBackoff.
+	Backoff time.Duration
+	// MaxBackoff caps the wait between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+	// Jitter randomizes every wait by up to this fraction of it, e.g. 0.2
+	// for ±20%, so that callers that failed together don't retry together.
+	Jitter float64
+	// Retryable reports whether a loader error is worth retrying, e.g. a
+	// timeout but not an unknown address. Nil retries every error.
+	Retryable func(err error) bool
+}
+
+// backoff returns the wait after the given failed attempt (starting at 1),
+// jittered with r, or false if err should not be retried.
+func (p *RetryPolicy) backoff(attempt int, err error, r *random) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || p.Retryable != nil && !p.Retryable(err) {
+		return 0, false
+	}
+	d := p.Backoff
+	if d <= 0 {
+		d = DefaultRetryBackoff
+	}
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((r.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return max(d, 0), true
+}
+
+// callLoader calls loader, retrying failures as configured with WithRetry.
+// It gives up early when ctx is done and returns the last loader error.
+func (c *LRUCache[K, V]) callLoader(ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (V, error) {
+	for attempt := 1; ; attempt++ {
+		start := c.clock.Now()
+		value, err := c.callOnce(ctx, key, loader)
+		c.stats.load(start, err)
+		c.logLoad(ctx, key, since(c.clock, start), err)
+		if err == nil {
+			return value, nil
+		}
+		wait, ok := c.retry.backoff(attempt, err, c.rand)
+		if !ok || ctx.Err() != nil {
+			return value, err
+		}
+		c.stats.retries.Add(1)
+		t := c.clock.NewTimer(wait)
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			t.Stop()
+			return value, err
+		}
+	}
+}
+
+// callOnce makes a single loader call, abandoning it after the
+// WithLoaderTimeout deadline even if the loader ignores its context. The
+// abandoned call keeps running on its own goroutine; its result is dropped.
+func (c *LRUCache[K, V]) callOnce(ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (V, error) {
+	if c.timeout <= 0 {
+		return safeLoad(ctx, key, loader)
+	}
+	tctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type result struct {
+		value V
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := safeLoad(tctx, key, loader)
+		done <- result{value, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-tctx.Done():
+		r.err = tctx.Err()
+	}
+	if r.err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		// our deadline, not the caller's: a failure of the backend
+		c.stats.timeouts.Add(1)
+		var zero V
+		return zero, ErrLoaderTimeout
+	}
+	return r.value, r.err
+}
+
+// safeLoad calls loader, returning its panic as an error, so that the load
+// fails like any other: its loader slot is released, the breaker told and
+// the failure negatively cached.
+func safeLoad[K comparable, V any](ctx context.Context, key K, loader LoaderFuncCtx[K, V]) (value V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero V
+			value, err = zero, fmt.Errorf("loader panicked: %v", r)
+		}
+	}()
+	return loader(ctx, key)
+}
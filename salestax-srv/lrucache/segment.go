@@ -0,0 +1,587 @@
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// segment is one independently locked shard of a (possibly sharded)
+// LRUCache. The map holds the items; the policy decides which one to evict.
+// With a single shard and the default LRU policy it behaves exactly like the
+// original unsharded cache. With WithEngine an Engine takes the place of
+// both, and the map and the policy are nil.
+//
+// Capacity is measured in cost units: every item costs 1 unless a weigher is
+// configured, in which case size is a weight budget (e.g. bytes).
+type segment[K comparable, V any] struct {
+	size   int
+	used   int // total cost of the items in cache
+	cache  map[K]*CacheItem[K, V]
+	policy EvictionPolicy[K]
+	engine Engine[K, V] // nil unless WithEngine is used
+	mutex  sync.RWMutex
+	serial uint64      // last item version handed out
+	loads  group[K, V] // in-flight loads, locked on its own
+
+	negative map[K]negativeEntry // nil unless negative caching is enabled
+	expiry   *expiryHeap[K]      // nil unless a sweeper runs
+	sketch   *sketch[K]          // nil unless admission is enabled
+	hot      *hotKeys[K]         // nil unless hot keys are tracked
+	stats    *counters
+
+	onEvict OnEvictFunc[K, V] // nil if no callback is configured
+	pending []eviction[K, V]  // callbacks to run once the write lock is released
+	journal Journal[K, V]     // nil if no journal is configured
+	weigher WeigherFunc[K, V] // nil means every item costs 1
+	stale   time.Duration     // how long expired items are kept to be served stale
+	clock   Clock
+	rand    *random      // nil for the global source
+	intern  *Interner[K] // nil if keys are not interned
+
+	// approx replaces the policy Access on every hit with a reference bit
+	// that is set under the read lock and consulted at eviction time (second
+	// chance / CLOCK), so that hits never take the write lock.
+	approx bool
+}
+
+// segmentConfig holds the settings shared by all segments of a cache.
+type segmentConfig[K comparable, V any] struct {
+	negative bool
+	sweep    bool
+	tinyLFU  bool
+	hotKeys  int
+	hotTime  time.Duration
+	approx   bool
+	onEvict  OnEvictFunc[K, V]
+	journal  Journal[K, V]
+	weigher  WeigherFunc[K, V]
+	stale    time.Duration
+	stats    *counters
+	clock    Clock
+	rand     *random             // nil for the global source
+	interner *Interner[K]        // nil if keys are not interned
+	engine   EngineFactory[K, V] // nil for the map and the policy
+}
+
+func newSegment[K comparable, V any](sz int, policy EvictionPolicy[K], cfg segmentConfig[K, V]) *segment[K, V] {
+	s := &segment[K, V]{
+		size:    sz,
+		policy:  policy,
+		stats:   cfg.stats,
+		approx:  cfg.approx,
+		onEvict: cfg.onEvict,
+		journal: cfg.journal,
+		weigher: cfg.weigher,
+		stale:   cfg.stale,
+		clock:   cfg.clock,
+		rand:    cfg.rand,
+		intern:  cfg.interner,
+	}
+	switch {
+	case cfg.engine != nil:
+		s.engine = cfg.engine(sz)
+	case cfg.weigher != nil:
+		// sz is a weight budget, not an item count
+		s.cache = make(map[K]*CacheItem[K, V])
+	default:
+		s.cache = make(map[K]*CacheItem[K, V], preallocHint(sz+1))
+	}
+	s.randomize(policy)
+	if cfg.negative {
+		s.negative = make(map[K]negativeEntry)
+	}
+	if cfg.sweep && cfg.engine == nil {
+		// a segment backed by an Engine sweeps it in full instead
+		s.expiry = newExpiryHeap[K]()
+	}
+	if cfg.tinyLFU {
+		s.sketch = newSketch[K](itemCapacity(sz, cfg.weigher != nil), newHasher[K](cfg.rand))
+	}
+	if cfg.hotKeys > 0 {
+		s.hot = newHotKeys[K](cfg.hotKeys, cfg.hotTime, cfg.clock.Now())
+	}
+	return s
+}
+
+func (s *segment[K, V]) get(key K) (*CacheItem[K, V], error) {
+	if s.engine != nil {
+		value, expires, err := s.engineGet(key)
+		if err != nil {
+			return nil, err
+		}
+		return engineItem(key, value, expires), nil
+	}
+	now := s.record(key)
+
+	s.mutex.RLock()
+	item, exists := s.cache[key]
+	if exists && s.approx && !item.expired(now) {
+		item.referenced.Store(true)
+		s.mutex.RUnlock()
+		item.hit(now)
+		s.stats.hit(now)
+		return item, nil
+	}
+	s.mutex.RUnlock()
+
+	if exists {
+		s.mutex.Lock()
+		defer s.unlock()
+		// look again, the item may have been replaced or removed while the
+		// lock was released
+		if item, exists = s.cache[key]; exists {
+			if item.expired(now) {
+				if !item.expired(now.Add(-s.stale)) {
+					// keep it around for staleItem until the window passes
+					s.stats.miss(now)
+					return nil, ErrExpired
+				}
+				s.removeLocked(key, EvictExpired)
+				s.stats.expirations.Add(1)
+				s.stats.miss(now)
+				return nil, ErrExpired
+			}
+			if s.approx {
+				item.referenced.Store(true)
+			} else {
+				s.policy.Access(key)
+			}
+			item.hit(now)
+			s.stats.hit(now)
+			return item, nil
+		}
+	}
+	s.stats.miss(now)
+	return nil, ErrNotFound
+}
+
+// value is get for callers after the value: a segment backed by an Engine
+// builds no item then, and returns a nil one.
+func (s *segment[K, V]) value(key K) (V, *CacheItem[K, V], error) {
+	if s.engine != nil {
+		value, _, err := s.engineGet(key)
+		return value, nil, err
+	}
+	item, err := s.get(key)
+	if err != nil {
+		var zero V
+		return zero, nil, err
+	}
+	return item.value, item, nil
+}
+
+// record counts a request for key in the admission sketch and the hot keys,
+// and returns its time.
+func (s *segment[K, V]) record(key K) time.Time {
+	now := s.clock.Now()
+	if s.sketch != nil {
+		s.sketch.increment(key)
+	}
+	if s.hot != nil {
+		s.hot.record(key, now)
+	}
+	return now
+}
+
+// peek returns the live item for key without touching the policy, the
+// reference bit or the stats. Expired items are reported as missing but left
+// in place.
+func (s *segment[K, V]) peek(key K) (*CacheItem[K, V], bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.engine != nil {
+		item := s.enginePeek(key, time.Time{}, s.clock.Now())
+		return item, item != nil
+	}
+	item, exists := s.cache[key]
+	if !exists || item.expired(s.clock.Now()) {
+		return nil, false
+	}
+	return item, true
+}
+
+// staleItem returns the item for key if it has expired but is still within
+// the stale window, nil otherwise.
+func (s *segment[K, V]) staleItem(key K) *CacheItem[K, V] {
+	if s.stale <= 0 {
+		return nil
+	}
+	now := s.clock.Now()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.engine != nil {
+		return s.enginePeek(key, now, now.Add(-s.stale))
+	}
+	item, exists := s.cache[key]
+	if !exists || !item.expired(now) || item.expired(now.Add(-s.stale)) {
+		return nil
+	}
+	return item
+}
+
+func (s *segment[K, V]) insert(key K, value V, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.unlock()
+	return s.insertLocked(key, value, expires)
+}
+
+// insertLocked inserts or updates key. The caller must hold the write lock.
+// An item that alone exceeds the segment capacity is rejected, replacing
+// nothing.
+func (s *segment[K, V]) insertLocked(key K, value V, expires time.Time) error {
+	cost := s.cost(key, value)
+	if cost > s.size {
+		return ErrTooLarge
+	}
+
+	if s.negative != nil {
+		delete(s.negative, key)
+	}
+	if s.engine != nil {
+		return s.engineInsert(key, value, expires)
+	}
+
+	old, exists := s.cache[key]
+	switch {
+	case exists:
+		// keep the key stored, rather than hold the caller's copy as well
+		key = old.key
+	case s.intern != nil:
+		key = s.intern.intern(key)
+	}
+
+	// items handed out by get are never modified, always store a new one
+	s.serial++
+	ci := &CacheItem[K, V]{
+		key:     key,
+		value:   value,
+		expires: expires,
+		cost:    cost,
+		version: s.serial,
+		created: s.clock.Now(),
+	}
+
+	if exists {
+		s.cache[key] = ci
+		s.setExpiry(key, expires)
+		s.used += cost - old.cost
+		s.policy.Access(key)
+		// a heavier value may push the segment over its budget
+		s.prune(0, EvictCapacity)
+	} else {
+
+		// test if cache is full
+		for s.used+cost > s.size && len(s.cache) > 0 {
+			s.prune(1, EvictCapacity)
+		}
+		s.cache[key] = ci
+		s.setExpiry(key, expires)
+		s.used += cost
+		s.policy.Add(key)
+	}
+	if s.journal != nil {
+		s.journal.Insert(key, value, expires)
+	}
+	return nil
+}
+
+// admit is insert for values the cache looked up itself, from the loader or
+// the second tier. With a TinyLFU filter a new key that does not fit is
+// dropped unless it was requested more often than the next victim.
+func (s *segment[K, V]) admit(key K, value V, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.unlock()
+	return s.admitLocked(key, value, expires)
+}
+
+// admitLocked is admit for callers holding the write lock.
+func (s *segment[K, V]) admitLocked(key K, value V, expires time.Time) error {
+	if s.sketch != nil && !s.admits(key, value) {
+		s.stats.rejections.Add(1)
+		return nil
+	}
+	return s.insertLocked(key, value, expires)
+}
+
+// admits reports whether the TinyLFU filter lets key in. The caller must hold
+// the write lock.
+func (s *segment[K, V]) admits(key K, value V) bool {
+	if s.has(key) || s.used+s.cost(key, value) <= s.size {
+		return true
+	}
+	victim, ok := s.victim()
+	return !ok || s.sketch.estimate(key) > s.sketch.estimate(victim)
+}
+
+// has reports whether key is stored, expired or not. The caller must hold
+// the lock.
+func (s *segment[K, V]) has(key K) bool {
+	if s.engine != nil {
+		_, _, ok := s.engine.Get(key, false)
+		return ok
+	}
+	_, ok := s.cache[key]
+	return ok
+}
+
+// victim returns the key the segment evicts next. The caller must hold the
+// lock.
+func (s *segment[K, V]) victim() (K, bool) {
+	if s.engine != nil {
+		key, _, _, ok := s.engine.Oldest()
+		return key, ok
+	}
+	return s.policy.Victim()
+}
+
+func (s *segment[K, V]) cost(key K, value V) int {
+	if s.weigher != nil {
+		return s.weigher(key, value)
+	}
+	return 1
+}
+
+// setExpiry keeps the expiry heap, if any, in step with the item stored for
+// key.
+func (s *segment[K, V]) setExpiry(key K, expires time.Time) {
+	if s.expiry != nil {
+		s.expiry.set(key, expires)
+	}
+}
+
+func (s *segment[K, V]) delete(key K) bool {
+	s.mutex.Lock()
+	defer s.unlock()
+	return s.deleteLocked(key)
+}
+
+// deleteLocked removes key and any negative entry for it, and reports whether
+// key was cached. The caller must hold the write lock.
+func (s *segment[K, V]) deleteLocked(key K) bool {
+	if s.negative != nil {
+		delete(s.negative, key)
+	}
+	exists := s.has(key)
+	if exists {
+		s.removeLocked(key, EvictDeleted)
+	}
+	return exists
+}
+
+// deleteFunc removes every item whose key matches and returns how many
+// were removed. A nil match removes everything, including negative entries.
+func (s *segment[K, V]) deleteFunc(match func(K) bool) int {
+	s.mutex.Lock()
+	defer s.unlock()
+
+	n := 0
+	if s.engine != nil {
+		for _, key := range s.engineKeys(func(key K, _ time.Time) bool { return match == nil || match(key) }) {
+			s.removeLocked(key, EvictDeleted)
+			n++
+		}
+	}
+	for _, item := range s.cache {
+		if match == nil || match(item.key) {
+			s.removeLocked(item.key, EvictDeleted)
+			n++
+		}
+	}
+	for key := range s.negative {
+		if match == nil || match(key) {
+			delete(s.negative, key)
+		}
+	}
+	return n
+}
+
+// snapshot returns the live items of the segment, in eviction order (next
+// victim last) if the policy can provide one.
+func (s *segment[K, V]) snapshot() []*CacheItem[K, V] {
+	now := s.clock.Now()
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.engine != nil {
+		return s.engineSnapshot(now)
+	}
+
+	items := make([]*CacheItem[K, V], 0, len(s.cache))
+	if op, ok := s.policy.(OrderedPolicy[K]); ok {
+		for _, key := range op.Keys() {
+			if item := s.cache[key]; !item.expired(now) {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	for _, item := range s.cache {
+		if !item.expired(now) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resize changes the capacity, evicting items for reason if the segment is
+// over it.
+func (s *segment[K, V]) resize(sz int, reason EvictReason) {
+	s.mutex.Lock()
+	defer s.unlock()
+
+	s.size = sz
+	if rp, ok := s.policy.(ResizablePolicy[K]); ok {
+		rp.Resize(itemCapacity(sz, s.weigher != nil))
+	}
+	s.prune(0, reason)
+	if s.engine != nil {
+		s.engine.Resize(sz)
+	}
+}
+
+// weight returns the total cost of the items in the segment.
+func (s *segment[K, V]) weight() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.used
+}
+
+func (s *segment[K, V]) len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.engine != nil {
+		return s.engine.Len()
+	}
+	return len(s.cache)
+}
+
+// prune evicts n items as chosen by the policy, and then keeps evicting while
+// the segment is over its capacity, for reason. It returns the number of
+// items evicted. The caller must hold the write lock.
+func (s *segment[K, V]) prune(n int, reason EvictReason) int {
+	if s.engine != nil {
+		return s.enginePrune(n, reason)
+	}
+	i := 0
+	for i < n || s.used > s.size {
+		key, ok := s.policy.Victim()
+		if !ok {
+			break
+		}
+		item := s.cache[key]
+		if s.approx && item.referenced.Swap(false) {
+			// used since it was last considered, give it a second chance
+			s.policy.Access(key)
+			continue
+		}
+		delete(s.cache, key)
+		s.used -= item.cost
+		if s.expiry != nil {
+			s.expiry.remove(key)
+		}
+		s.evicted(key, item.value, reason)
+		s.policy.Evict(key)
+		if s.intern != nil {
+			s.intern.release(key)
+		}
+		s.stats.evictions.Add(1)
+		i++
+	}
+	return i
+}
+
+// removeLocked drops key from the map and the policy. The caller must hold
+// the write lock.
+func (s *segment[K, V]) removeLocked(key K, reason EvictReason) {
+	if s.engine != nil {
+		s.engineRemove(key, reason)
+		return
+	}
+	if item, ok := s.cache[key]; ok {
+		delete(s.cache, key)
+		s.used -= item.cost
+		if s.expiry != nil {
+			s.expiry.remove(key)
+		}
+		s.policy.Remove(key)
+		s.evicted(key, item.value, reason)
+		if s.intern != nil {
+			s.intern.release(key)
+		}
+		if reason == EvictDeleted && s.journal != nil {
+			s.journal.Delete(key)
+		}
+	}
+}
+
+// negativeLookup returns the remembered loader error for key, if any.
+func (s *segment[K, V]) negativeLookup(key K) error {
+	s.mutex.RLock()
+	ne, ok := s.negative[key]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	if s.clock.Now().After(ne.expires) {
+		s.mutex.Lock()
+		if cur, ok := s.negative[key]; ok && cur.expires.Equal(ne.expires) {
+			delete(s.negative, key)
+		}
+		s.mutex.Unlock()
+		return nil
+	}
+	s.stats.negativeHits.Add(1)
+	return ne.err
+}
+
+// rememberFailure records a loader failure for key when negative caching is
+// enabled. The negative map is capped at the segment size; when it is full an
+// arbitrary entry is dropped to make room.
+func (s *segment[K, V]) rememberFailure(key K, err error, expires time.Time) {
+	s.mutex.Lock()
+	defer s.unlock()
+	if s.negative == nil {
+		return
+	}
+	if _, exists := s.negative[key]; !exists && len(s.negative) >= s.size {
+		for k := range s.negative {
+			delete(s.negative, k)
+			break
+		}
+	}
+	s.negative[key] = negativeEntry{err: err, expires: expires}
+}
+
+// sweep removes every expired item from the segment, except those still within
+// the stale window. With an expiry heap only the items that are due are
+// visited.
+func (s *segment[K, V]) sweep(now time.Time) {
+	s.mutex.Lock()
+	defer s.unlock()
+	for key, ne := range s.negative {
+		if now.After(ne.expires) {
+			delete(s.negative, key)
+		}
+	}
+	if s.expiry != nil {
+		for {
+			key, expires, ok := s.expiry.next()
+			if !ok || !now.Add(-s.stale).After(expires) {
+				return
+			}
+			s.removeLocked(key, EvictExpired)
+			s.stats.expirations.Add(1)
+		}
+	}
+	if s.engine != nil {
+		for _, key := range s.engineKeys(func(_ K, expires time.Time) bool { return expiredAt(expires, now.Add(-s.stale)) }) {
+			s.removeLocked(key, EvictExpired)
+			s.stats.expirations.Add(1)
+		}
+	}
+	for _, item := range s.cache {
+		if item.expired(now.Add(-s.stale)) {
+			s.removeLocked(item.key, EvictExpired)
+			s.stats.expirations.Add(1)
+		}
+	}
+}
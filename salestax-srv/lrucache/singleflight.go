@@ -0,0 +1,84 @@
+package lrucache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// call is an in-flight or completed loader invocation.
+type call[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// group coalesces concurrent loads of the same key so that only one loader
+// call runs per key at a time. It is a trimmed down, generic version of
+// golang.org/x/sync/singleflight.
+type group[K comparable, V any] struct {
+	mu sync.Mutex
+	m  map[K]*call[V]
+}
+
+// do runs fn for key unless a call for key is already in flight, in which case
+// it waits for that call and returns its result. fn runs on its own goroutine
+// so that every caller, including the one that started it, stops waiting and
+// returns ctx.Err() as soon as its ctx is done. fn itself keeps running and
+// its result is still shared with callers that are waiting.
+func (g *group[K, V]) do(ctx context.Context, key K, fn func() (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[K]*call[V])
+	}
+	c, ok := g.m[key]
+	if !ok {
+		c = &call[V]{done: make(chan struct{})}
+		g.m[key] = c
+		go g.run(key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// start runs fn for key in the background unless a call for key is already
+// in flight. It does not wait and reports whether fn was started.
+func (g *group[K, V]) start(key K, fn func() (V, error)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil {
+		g.m = make(map[K]*call[V])
+	}
+	if _, ok := g.m[key]; ok {
+		return false
+	}
+	c := &call[V]{done: make(chan struct{})}
+	g.m[key] = c
+	go g.run(key, c, fn)
+	return true
+}
+
+// run calls fn and hands its result to the callers waiting for key. fn runs
+// on a goroutine of its own, out of reach of the recovery of net/http, so a
+// panic fails the call instead of the process.
+func (g *group[K, V]) run(key K, c *call[V], fn func() (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero V
+			c.val, c.err = zero, fmt.Errorf("%w: loader panicked: %v", ErrLoaderFailed, r)
+		}
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+}
@@ -0,0 +1,103 @@
+package lrucache
+
+// slruProtectedRatio is the share of the capacity reserved for the protected
+// segment by NewSLRUPolicy.
+const slruProtectedRatio = 0.8
+
+// slruPolicy is a segmented LRU. New keys enter the probation segment and are
+// only promoted to the protected segment on a second access, so a scan of
+// one-time keys (e.g. a bulk address import) churns through probation without
+// flushing the frequently used working set. When protected overflows, its
+// least recently used key is demoted back to the head of probation. Victims
+// are taken from the tail of probation first.
+type slruPolicy[K comparable] struct {
+	protectedCap int
+	slab         slab[K]
+	probation    nodeList
+	protected    nodeList
+	elems        map[K]int
+}
+
+// NewSLRUPolicy returns a scan resistant segmented LRU policy that reserves
+// 80% of capacity for keys accessed at least twice.
+func NewSLRUPolicy[K comparable](capacity int) EvictionPolicy[K] {
+	p := &slruPolicy[K]{
+		protectedCap: slruProtectedCap(capacity),
+		slab:         newSlab[K](capacity + 2),
+		elems:        make(map[K]int, preallocHint(capacity)),
+	}
+	p.probation = p.slab.newList()
+	p.protected = p.slab.newList()
+	return p
+}
+
+func slruProtectedCap(capacity int) int {
+	return max(1, int(float64(capacity)*slruProtectedRatio))
+}
+
+func (p *slruPolicy[K]) Resize(capacity int) {
+	p.protectedCap = slruProtectedCap(capacity)
+	for p.protected.len > p.protectedCap {
+		p.demote()
+	}
+}
+
+// demote moves the coldest protected key to the head of probation.
+func (p *slruPolicy[K]) demote() {
+	back, _ := p.slab.back(&p.protected)
+	p.slab.moveToFront(&p.protected, &p.probation, back)
+}
+
+func (p *slruPolicy[K]) Add(key K) {
+	p.elems[key] = p.slab.pushFront(&p.probation, key)
+}
+
+func (p *slruPolicy[K]) Access(key K) {
+	i, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	if p.slab.on(&p.protected, i) {
+		p.slab.moveToFront(&p.protected, &p.protected, i)
+		return
+	}
+	p.slab.moveToFront(&p.probation, &p.protected, i)
+	if p.protected.len > p.protectedCap {
+		// demote the coldest protected key, it gets one more chance
+		p.demote()
+	}
+}
+
+func (p *slruPolicy[K]) Remove(key K) {
+	i, ok := p.elems[key]
+	if !ok {
+		return
+	}
+	if p.slab.on(&p.protected, i) {
+		p.slab.remove(&p.protected, i)
+	} else {
+		p.slab.remove(&p.probation, i)
+	}
+	delete(p.elems, key)
+}
+
+func (p *slruPolicy[K]) Evict(key K) {
+	p.Remove(key)
+}
+
+func (p *slruPolicy[K]) Keys() []K {
+	keys := make([]K, 0, len(p.elems))
+	keys = p.slab.appendKeys(keys, &p.protected)
+	return p.slab.appendKeys(keys, &p.probation)
+}
+
+func (p *slruPolicy[K]) Victim() (K, bool) {
+	if i, ok := p.slab.back(&p.probation); ok {
+		return p.slab.key(i), true
+	}
+	if i, ok := p.slab.back(&p.protected); ok {
+		return p.slab.key(i), true
+	}
+	var zero K
+	return zero, false
+}
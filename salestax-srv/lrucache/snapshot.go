@@ -0,0 +1,223 @@
+package lrucache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"time"
+)
+
+// A snapshot starts with snapshotMagic and the version of its format, a
+// big-endian uint16, followed by frames: the big-endian uint32 length of a
+// payload, the payload and its CRC-32C. The payloads make up a gob stream
+// of a snapshotHeader and its Count snapshotEntry values. gob skips the
+// fields it does not know, so fields added to either are ignored by older
+// binaries; a layout they could not read gets the next version, which they
+// refuse with ErrSnapshotVersion instead of misreading it.
+//
+// Version 1 is the bare gob stream, without magic nor frames. It is still
+// loaded, so that upgrading keeps the snapshots of the former binary.
+const (
+	snapshotMagic   = "\x89LRU\r\n\x1a\n" // like that of PNG, garbled by text transfers
+	snapshotVersion = 2
+	// maxFrame bounds the payload allocated for a corrupt length.
+	maxFrame = 1 << 30
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshotHeader starts every snapshot stream. It is followed by Count
+// snapshotEntry values.
+type snapshotHeader struct {
+	Version int
+	Created time.Time
+	Count   int
+}
+
+type snapshotEntry[K comparable, V any] struct {
+	Key     K
+	Value   V
+	Expires time.Time
+}
+
+// SaveSnapshot writes the live items of the cache to w using encoding/gob, so
+// K and V must be gob encodable. Items are written next victim first, which
+// lets LoadSnapshot restore the eviction order of each shard. The snapshot is
+// taken shard by shard like Range, so writes made during SaveSnapshot may or
+// may not be included.
+func (c *LRUCache[K, V]) SaveSnapshot(w io.Writer) error {
+	start := c.clock.Now()
+	var items []*CacheItem[K, V]
+	for _, s := range c.shards {
+		shard := s.snapshot()
+		slices.Reverse(shard)
+		items = append(items, shard...)
+	}
+
+	preamble := binary.BigEndian.AppendUint16([]byte(snapshotMagic), snapshotVersion)
+	if _, err := w.Write(preamble); err != nil {
+		return err
+	}
+	fw := newFrameWriter(w)
+	hdr := snapshotHeader{Version: snapshotVersion, Created: c.clock.Now(), Count: len(items)}
+	if err := fw.encode(hdr); err != nil {
+		return err
+	}
+	for _, item := range items {
+		e := snapshotEntry[K, V]{Key: item.key, Value: item.value, Expires: item.expires}
+		if err := fw.encode(e); err != nil {
+			return err
+		}
+	}
+	c.debug("lrucache: snapshot saved", "items", len(items), "duration", since(c.clock, start))
+	return nil
+}
+
+// LoadSnapshot inserts the items of a snapshot written by SaveSnapshot,
+// keeping their original expiration times. Items that expired in the meantime
+// are skipped, and existing items with the same key are replaced. If the
+// snapshot holds more items than the cache capacity, the ones closest to
+// eviction are evicted again while loading.
+//
+// A snapshot of a newer format returns ErrSnapshotVersion, and one that is
+// damaged or cut short ErrSnapshotCorrupt, after loading the items before
+// the damage.
+func (c *LRUCache[K, V]) LoadSnapshot(r io.Reader) error {
+	dec, version, err := newSnapshotDecoder(r)
+	if err != nil {
+		return err
+	}
+	var hdr snapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return snapshotCorrupt(err, "header")
+	}
+	if hdr.Version != version {
+		return fmt.Errorf("%w: header of version %d in a snapshot of version %d", ErrSnapshotCorrupt, hdr.Version, version)
+	}
+
+	now := c.clock.Now()
+	loaded := 0
+	for i := 0; i < hdr.Count; i++ {
+		var e snapshotEntry[K, V]
+		if err := dec.Decode(&e); err != nil {
+			return snapshotCorrupt(err, fmt.Sprintf("item %d of %d", i+1, hdr.Count))
+		}
+		if !e.Expires.IsZero() && now.After(e.Expires) {
+			continue
+		}
+		// an item too heavy for this cache's weight budget is dropped
+		key := c.normalize(e.Key)
+		if c.shard(key).insert(key, e.Value, e.Expires) == nil {
+			loaded++
+		}
+	}
+	c.debug("lrucache: snapshot restored", "items", loaded, "skipped", hdr.Count-loaded,
+		"created", hdr.Created, "version", version, "duration", since(c.clock, now))
+	return nil
+}
+
+// newSnapshotDecoder returns the decoder of the gob stream of the snapshot
+// read from r, and the version of its format.
+func newSnapshotDecoder(r io.Reader) (*gob.Decoder, int, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(snapshotMagic)); err != nil || string(magic) != snapshotMagic {
+		return gob.NewDecoder(br), 1, nil
+	}
+	var preamble [len(snapshotMagic) + 2]byte
+	if _, err := io.ReadFull(br, preamble[:]); err != nil {
+		return nil, 0, snapshotCorrupt(err, "version")
+	}
+	if version := binary.BigEndian.Uint16(preamble[len(snapshotMagic):]); version != snapshotVersion {
+		return nil, 0, fmt.Errorf("%w %d", ErrSnapshotVersion, version)
+	}
+	return gob.NewDecoder(&frameReader{r: br}), snapshotVersion, nil
+}
+
+// snapshotCorrupt returns the error of decoding what of a snapshot.
+func snapshotCorrupt(err error, what string) error {
+	switch {
+	case errors.Is(err, ErrSnapshotCorrupt):
+		return err
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: cut short before %s", ErrSnapshotCorrupt, what)
+	}
+	return fmt.Errorf("%w: decoding %s: %w", ErrSnapshotCorrupt, what, err)
+}
+
+// frameWriter writes a gob stream to w, a checksummed frame per value.
+type frameWriter struct {
+	w     io.Writer
+	buf   bytes.Buffer
+	enc   *gob.Encoder
+	frame []byte
+}
+
+func newFrameWriter(w io.Writer) *frameWriter {
+	fw := &frameWriter{w: w}
+	fw.enc = gob.NewEncoder(&fw.buf)
+	return fw
+}
+
+// encode writes v, with the types it sends first, in a single frame.
+func (fw *frameWriter) encode(v any) error {
+	fw.buf.Reset()
+	if err := fw.enc.Encode(v); err != nil {
+		return err
+	}
+	payload := fw.buf.Bytes()
+	fw.frame = binary.BigEndian.AppendUint32(fw.frame[:0], uint32(len(payload)))
+	fw.frame = append(fw.frame, payload...)
+	fw.frame = binary.BigEndian.AppendUint32(fw.frame, crc32.Checksum(payload, castagnoli))
+	_, err := fw.w.Write(fw.frame)
+	return err
+}
+
+// frameReader reads the payloads of the frames written by a frameWriter,
+// failing with ErrSnapshotCorrupt on a checksum mismatch.
+type frameReader struct {
+	r     io.Reader
+	frame []byte
+	rest  []byte // of the payload of frame
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for len(fr.rest) == 0 {
+		if err := fr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, fr.rest)
+	fr.rest = fr.rest[n:]
+	return n, nil
+}
+
+// next reads the next frame, returning io.EOF at the end of r.
+func (fr *frameReader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(fr.r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrame {
+		return fmt.Errorf("%w: frame of %d bytes", ErrSnapshotCorrupt, n)
+	}
+	fr.frame = slices.Grow(fr.frame[:0], int(n)+4)[:n+4]
+	if _, err := io.ReadFull(fr.r, fr.frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	payload := fr.frame[:n]
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(fr.frame[n:]) {
+		return fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+	fr.rest = payload
+	return nil
+}
@@ -0,0 +1,124 @@
+package lrucache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time snapshot of the cache counters. Counters are
+// cumulative since the cache was created.
+type Stats struct {
+	Hits         uint64 // Get/GetOrLoad calls served from the cache
+	Misses       uint64 // lookups for keys that were absent or expired
+	Evictions    uint64 // items removed to make room for new ones
+	Expirations  uint64 // items removed because their TTL elapsed
+	Rejections   uint64 // loaded values not cached by the WithTinyLFU filter
+	LoaderCalls  uint64 // loader invocations (after coalescing)
+	LoaderErrors uint64 // loader invocations that returned an error
+	Retries      uint64 // loader invocations repeated after an error
+	Timeouts     uint64 // loader invocations abandoned after the WithLoaderTimeout deadline
+	Throttled    uint64 // misses refused or abandoned while waiting for a loader slot
+	Unavailable  uint64 // misses failed with ErrBackendUnavailable by the circuit breaker
+	NegativeHits uint64 // lookups answered with a cached loader error
+	StaleHits    uint64 // lookups answered with an expired value while it was refreshed
+	Refreshes    uint64 // background reloads (stale-while-revalidate and refresh-ahead)
+	StoreErrors  uint64 // failed writes to the backing store
+	L2Hits       uint64 // misses answered by the second tier
+	L2Misses     uint64 // misses the second tier could not answer either
+	L2Errors     uint64 // failed second tier requests
+	CanonErrors  uint64 // keys cached as they are because the WithKeyCanonicalizer call failed
+	Size         int    // current number of items
+	Weight       int    // total weight of the items, equal to Size without a weigher
+
+	Windows []WindowStats // with WithStatsWindows, the shortest window first
+}
+
+// HitRatio returns Hits / (Hits + Misses), or 0 if there were no lookups.
+func (s Stats) HitRatio() float64 {
+	return hitRatio(s.Hits, s.Misses)
+}
+
+func hitRatio(hits, misses uint64) float64 {
+	total := hits + misses
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// counters are updated atomically so that recording them never contends
+// with the cache mutex.
+type counters struct {
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	expirations  atomic.Uint64
+	rejections   atomic.Uint64
+	loaderCalls  atomic.Uint64
+	loaderErrors atomic.Uint64
+	retries      atomic.Uint64
+	timeouts     atomic.Uint64
+	throttled    atomic.Uint64
+	unavailable  atomic.Uint64
+	negativeHits atomic.Uint64
+	staleHits    atomic.Uint64
+	refreshes    atomic.Uint64
+	storeErrors  atomic.Uint64
+	l2Hits       atomic.Uint64
+	l2Misses     atomic.Uint64
+	l2Errors     atomic.Uint64
+	canonErrors  atomic.Uint64
+
+	windows *statWindows // nil without WithStatsWindows
+}
+
+// hit counts a lookup served from the cache.
+func (c *counters) hit(now time.Time) {
+	c.hits.Add(1)
+	c.windows.lookup(now, true)
+}
+
+// miss counts a lookup for a key that was absent or expired.
+func (c *counters) miss(now time.Time) {
+	c.misses.Add(1)
+	c.windows.lookup(now, false)
+}
+
+// load counts a loader call that started at start.
+func (c *counters) load(start time.Time, err error) {
+	c.loaderCalls.Add(1)
+	if err != nil {
+		c.loaderErrors.Add(1)
+	}
+	c.windows.load(start, err)
+}
+
+// Stats returns a snapshot of the cache counters. The individual counters are
+// read without a common lock, so a snapshot taken under load may be slightly
+// inconsistent (e.g. Hits+Misses lagging a concurrent Get).
+func (c *LRUCache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:         c.stats.hits.Load(),
+		Misses:       c.stats.misses.Load(),
+		Evictions:    c.stats.evictions.Load(),
+		Expirations:  c.stats.expirations.Load(),
+		Rejections:   c.stats.rejections.Load(),
+		LoaderCalls:  c.stats.loaderCalls.Load(),
+		LoaderErrors: c.stats.loaderErrors.Load(),
+		Retries:      c.stats.retries.Load(),
+		Timeouts:     c.stats.timeouts.Load(),
+		Throttled:    c.stats.throttled.Load(),
+		Unavailable:  c.stats.unavailable.Load(),
+		NegativeHits: c.stats.negativeHits.Load(),
+		StaleHits:    c.stats.staleHits.Load(),
+		Refreshes:    c.stats.refreshes.Load(),
+		StoreErrors:  c.stats.storeErrors.Load(),
+		L2Hits:       c.stats.l2Hits.Load(),
+		L2Misses:     c.stats.l2Misses.Load(),
+		L2Errors:     c.stats.l2Errors.Load(),
+		CanonErrors:  c.stats.canonErrors.Load(),
+		Size:         c.Len(),
+		Weight:       c.Weight(),
+		Windows:      c.stats.windows.stats(c.clock.Now()),
+	}
+}
@@ -0,0 +1,201 @@
+package lrucache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store is a persistent backing store (e.g. the tax rate database) that the
+// cache writes updates to. See WithWriteThrough and WithWriteBehind.
+type Store[K comparable, V any] interface {
+	Get(ctx context.Context, key K) (V, error)
+	Set(ctx context.Context, key K, value V) error
+	Delete(ctx context.Context, key K) error
+}
+
+// BatchStore is implemented by stores that can persist several values in one
+// round trip. Write-behind flushes use SetBatch when it is available.
+type BatchStore[K comparable, V any] interface {
+	Store[K, V]
+	SetBatch(ctx context.Context, values map[K]V) error
+}
+
+// Defaults for WithWriteBehind.
+const (
+	DefaultWriteBehindBatch    = 100
+	DefaultWriteBehindInterval = time.Second
+)
+
+// storeOp is a pending write-behind operation. Later operations on the same
+// key replace earlier ones.
+type storeOp[V any] struct {
+	value  V
+	delete bool
+}
+
+// writeBehind queues writes to a Store and applies them in batches from a
+// background goroutine.
+type writeBehind[K comparable, V any] struct {
+	store    Store[K, V]
+	batch    int
+	interval time.Duration
+	onError  func(error)
+	stats    *counters
+	clock    Clock
+
+	mu      sync.Mutex
+	pending map[K]storeOp[V]
+	closed  bool
+	kick    chan struct{}
+}
+
+func newWriteBehind[K comparable, V any](store Store[K, V], batch int, interval time.Duration, onError func(error), stats *counters, clock Clock) *writeBehind[K, V] {
+	if batch <= 0 {
+		batch = DefaultWriteBehindBatch
+	}
+	if interval <= 0 {
+		interval = DefaultWriteBehindInterval
+	}
+	return &writeBehind[K, V]{
+		store:    store,
+		batch:    batch,
+		interval: interval,
+		onError:  onError,
+		stats:    stats,
+		clock:    clock,
+		pending:  make(map[K]storeOp[V]),
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues op for key. Once the writer has stopped, op is applied
+// synchronously instead so that no write is lost.
+func (w *writeBehind[K, V]) enqueue(key K, op storeOp[V]) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		w.apply(map[K]storeOp[V]{key: op})
+		return
+	}
+	w.pending[key] = op
+	full := len(w.pending) >= w.batch
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run flushes the queue every interval or whenever a full batch is waiting,
+// until done is closed. The remaining writes are flushed before it returns.
+func (w *writeBehind[K, V]) run(done <-chan struct{}) {
+	t := w.clock.NewTimer(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			t.Reset(w.interval)
+		case <-w.kick:
+		case <-done:
+			w.mu.Lock()
+			w.closed = true
+			w.mu.Unlock()
+			w.flush()
+			return
+		}
+		w.flush()
+	}
+}
+
+func (w *writeBehind[K, V]) flush() {
+	w.mu.Lock()
+	ops := w.pending
+	w.pending = make(map[K]storeOp[V])
+	w.mu.Unlock()
+	if len(ops) > 0 {
+		w.apply(ops)
+	}
+}
+
+func (w *writeBehind[K, V]) apply(ops map[K]storeOp[V]) {
+	ctx := context.Background()
+	sets := make(map[K]V, len(ops))
+	for key, op := range ops {
+		if op.delete {
+			w.failed(w.store.Delete(ctx, key))
+		} else {
+			sets[key] = op.value
+		}
+	}
+	if bs, ok := w.store.(BatchStore[K, V]); ok && len(sets) > 1 {
+		w.failed(bs.SetBatch(ctx, sets))
+		return
+	}
+	for key, value := range sets {
+		w.failed(w.store.Set(ctx, key, value))
+	}
+}
+
+func (w *writeBehind[K, V]) failed(err error) {
+	if err == nil {
+		return
+	}
+	w.stats.storeErrors.Add(1)
+	if w.onError != nil {
+		w.onError(err)
+	}
+}
+
+// storeSet persists an inserted value. With write-through it returns the
+// store's error; with write-behind the write is only queued.
+func (c *LRUCache[K, V]) storeSet(key K, value V) error {
+	switch {
+	case c.behind != nil:
+		c.behind.enqueue(key, storeOp[V]{value: value})
+	case c.store != nil:
+		if err := c.store.Set(context.Background(), key, value); err != nil {
+			c.stats.storeErrors.Add(1)
+			return err
+		}
+	}
+	return nil
+}
+
+// storeSetBatch persists the values of InsertBatch to a write-through store,
+// in one call if it is a BatchStore. Write-behind stores are handled by
+// storeSet.
+func (c *LRUCache[K, V]) storeSetBatch(values map[K]V) error {
+	if bs, ok := c.store.(BatchStore[K, V]); ok {
+		if err := bs.SetBatch(context.Background(), values); err != nil {
+			c.stats.storeErrors.Add(1)
+			return err
+		}
+		return nil
+	}
+	for key, value := range values {
+		if err := c.storeSet(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeDelete removes a deleted key from the store. Delete cannot return an
+// error, so write-through failures are reported to the store error handler.
+func (c *LRUCache[K, V]) storeDelete(key K) {
+	switch {
+	case c.behind != nil:
+		c.behind.enqueue(key, storeOp[V]{delete: true})
+	case c.store != nil:
+		if err := c.store.Delete(context.Background(), key); err != nil {
+			c.stats.storeErrors.Add(1)
+			if c.onStoreError != nil {
+				c.onStoreError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package lrucache
+
+import (
+	"context"
+	"time"
+)
+
+// SecondTier is a shared cache, such as Redis or memcached, that sits between
+// the in-process cache and the loader. A fleet of servers configured with the
+// same second tier shares warm values: a miss in one process is answered
+// from the second tier before falling back to the loader. See WithSecondTier.
+type SecondTier[K comparable, V any] interface {
+	// Get returns the value for key. ok is false if the tier does not hold
+	// key; err is reserved for failures talking to the tier.
+	Get(ctx context.Context, key K) (value V, ok bool, err error)
+	// Set stores value for ttl. A zero ttl means no expiration.
+	Set(ctx context.Context, key K, value V, ttl time.Duration) error
+	Delete(ctx context.Context, key K) error
+}
+
+// SecondTier returns the second tier of the cache, nil without
+// WithSecondTier.
+func (c *LRUCache[K, V]) SecondTier() SecondTier[K, V] {
+	return c.l2
+}
+
+// l2Get looks key up in the second tier. Failures are counted and treated as
+// a miss so that an unavailable tier only costs latency.
+func (c *LRUCache[K, V]) l2Get(ctx context.Context, key K) (V, bool) {
+	value, ok, err := c.l2.Get(ctx, key)
+	switch {
+	case err != nil:
+		c.stats.l2Errors.Add(1)
+		var zero V
+		return zero, false
+	case ok:
+		c.stats.l2Hits.Add(1)
+	default:
+		c.stats.l2Misses.Add(1)
+	}
+	return value, ok
+}
+
+// l2Set writes value to the second tier, counting failures.
+func (c *LRUCache[K, V]) l2Set(ctx context.Context, key K, value V, ttl time.Duration) {
+	if err := c.l2.Set(ctx, key, value, max(ttl, 0)); err != nil {
+		c.stats.l2Errors.Add(1)
+	}
+}
+
+// l2Delete removes key from the second tier, counting failures.
+func (c *LRUCache[K, V]) l2Delete(key K) {
+	if err := c.l2.Delete(context.Background(), key); err != nil {
+		c.stats.l2Errors.Add(1)
+	}
+}
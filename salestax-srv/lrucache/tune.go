@@ -0,0 +1,81 @@
+package lrucache
+
+import "time"
+
+// TTL returns the time to live of the items inserted without one of their
+// own, 0 if they never expire; see WithTTL.
+func (c *LRUCache[K, V]) TTL() time.Duration {
+	return time.Duration(c.ttl.Load())
+}
+
+// SetTTL changes the time to live of the items inserted from now on, 0 for
+// no expiration. Items already cached keep their expiry. Expired items are
+// removed as they are looked up, or by the sweeper if WithSweepInterval
+// started one.
+func (c *LRUCache[K, V]) SetTTL(d time.Duration) {
+	c.ttl.Store(int64(d))
+}
+
+// NegativeTTL returns how long loader failures are remembered, 0 if
+// negative caching is disabled; see WithNegativeTTL.
+func (c *LRUCache[K, V]) NegativeTTL() time.Duration {
+	return time.Duration(c.negTTL.Load())
+}
+
+// SetNegativeTTL changes how long the loader failures from now on are
+// remembered. A d <= 0 disables negative caching and forgets the failures
+// remembered so far; a positive d enables it again.
+func (c *LRUCache[K, V]) SetNegativeTTL(d time.Duration) {
+	d = max(d, 0)
+	c.negTTL.Store(int64(d))
+	for _, s := range c.shards {
+		s.setNegative(d > 0)
+	}
+}
+
+// SetPolicy replaces the eviction policy of every shard with one made by
+// factory, keeping the cached items: they are added to the new policy in
+// the order of the old one, the next victim first, where it has one. What
+// the old policy learned beyond that order, such as the access counts of
+// LFU or the ghost entries of ARC, is lost. It has no effect on a cache
+// built with WithEngine.
+func (c *LRUCache[K, V]) SetPolicy(factory PolicyFactory[K]) {
+	for _, s := range c.shards {
+		s.setPolicy(factory)
+	}
+}
+
+// setNegative enables or disables the negative entries of the segment.
+func (s *segment[K, V]) setNegative(enabled bool) {
+	s.mutex.Lock()
+	defer s.unlock()
+	switch {
+	case enabled && s.negative == nil:
+		s.negative = make(map[K]negativeEntry)
+	case !enabled:
+		s.negative = nil
+	}
+}
+
+// setPolicy replaces the policy of the segment with one made by factory.
+func (s *segment[K, V]) setPolicy(factory PolicyFactory[K]) {
+	s.mutex.Lock()
+	defer s.unlock()
+	if s.engine != nil {
+		return
+	}
+	var keys []K
+	if op, ok := s.policy.(OrderedPolicy[K]); ok {
+		keys = op.Keys()
+	} else {
+		for key := range s.cache {
+			keys = append(keys, key)
+		}
+	}
+	policy := factory(itemCapacity(s.size, s.weigher != nil))
+	s.randomize(policy)
+	for i := len(keys) - 1; i >= 0; i-- {
+		policy.Add(keys[i])
+	}
+	s.policy = policy
+}
@@ -0,0 +1,67 @@
+package lrucache
+
+import "context"
+
+// GetWithVersion is Get returning the value and its version, for a later
+// InsertIfVersion.
+func (c *LRUCache[K, V]) GetWithVersion(key K) (V, uint64, error) {
+	item, err := c.Get(key)
+	if err != nil {
+		var zero V
+		return zero, 0, err
+	}
+	return item.value, item.version, nil
+}
+
+// InsertIfVersion is Insert for optimistic concurrency: it only stores value
+// if the key's current version is expected, as returned by GetWithVersion,
+// and returns ErrVersionMismatch otherwise, leaving the cache unchanged. An
+// expected version of 0 only matches a key that is absent or expired. Two
+// writers that read the same version can thus not both succeed, and the
+// loser can re-read and retry instead of overwriting newer data.
+//
+// The items of a cache built with WithEngine have no version: GetWithVersion
+// returns 0 for them and InsertIfVersion only stores keys absent or expired.
+//
+// With a write-through store the value is written while the key's shard is
+// locked, so that the store sees the writes in the order the cache accepted
+// them.
+func (c *LRUCache[K, V]) InsertIfVersion(key K, value V, expected uint64) error {
+	key = c.cacheKey(context.Background(), key)
+	if err := c.insertIfVersion(key, value, expected); err != nil {
+		return err
+	}
+	if c.behind != nil {
+		c.storeSet(key, value)
+	}
+	if c.l2 != nil {
+		c.l2Set(context.Background(), key, value, c.TTL())
+	}
+	return nil
+}
+
+// insertIfVersion is the compare and swap of InsertIfVersion, done under the
+// shard lock.
+func (c *LRUCache[K, V]) insertIfVersion(key K, value V, expected uint64) error {
+	s := c.shard(key)
+	s.mutex.Lock()
+	defer s.unlock()
+
+	var current uint64
+	if s.engine != nil {
+		if _, expires, ok := s.engine.Get(key, false); ok && !expiredAt(expires, c.clock.Now()) {
+			return ErrVersionMismatch
+		}
+	} else if item, exists := s.cache[key]; exists && !item.expired(c.clock.Now()) {
+		current = item.version
+	}
+	if current != expected {
+		return ErrVersionMismatch
+	}
+	if c.store != nil {
+		if err := c.storeSet(key, value); err != nil {
+			return err
+		}
+	}
+	return s.insertLocked(key, value, c.expiry(c.TTL()))
+}
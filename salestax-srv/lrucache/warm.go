@@ -0,0 +1,23 @@
+package lrucache
+
+import (
+	"context"
+	"iter"
+)
+
+// Warm inserts the pairs of seq using the cache-wide TTL and returns how many
+// were inserted. Unlike Insert it only fills this cache: nothing is written to
+// the store or the second tier, since warm data normally comes from there.
+// When seq holds more than the cache capacity, later pairs evict earlier ones,
+// so list the most important keys last.
+func (c *LRUCache[K, V]) Warm(seq iter.Seq2[K, V]) int {
+	n := 0
+	expires := c.expiry(c.TTL())
+	for key, value := range seq {
+		key = c.cacheKey(context.Background(), key)
+		if c.shard(key).insert(key, value, expires) == nil {
+			n++
+		}
+	}
+	return n
+}
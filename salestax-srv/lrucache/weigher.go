@@ -0,0 +1,72 @@
+package lrucache
+
+import "unsafe"
+
+// WeigherFunc returns the cost of an item, e.g. its approximate size in
+// bytes. It must not return a negative value, and it must return the same
+// cost for the same key/value pair.
+type WeigherFunc[K comparable, V any] func(key K, value V) int
+
+// itemOverhead approximates the per-item memory used by the cache besides the
+// key and value themselves: the CacheItem, its map entry and the policy's
+// bookkeeping.
+const itemOverhead = 128
+
+// itemCapacity returns the number of items a shard of capacity sz is sized
+// for: sz itself, or with a weigher, where sz is a weight budget, the number
+// of items of the per-item overhead SizeOf charges that fit the budget.
+func itemCapacity(sz int, weighted bool) int {
+	if !weighted {
+		return sz
+	}
+	return max(1, sz/itemOverhead)
+}
+
+// WithWeigher bounds the cache by total weight instead of item count. The
+// size passed to New becomes the weight budget, and items are evicted until
+// the weight of the remaining ones fits it. An item heavier than a shard's
+// share of the budget is rejected by Insert. Eviction policies and the
+// TinyLFU sketch are sized for as many items as fit the budget at the
+// per-item overhead of SizeOf. K and V must match the cache being
+// constructed, otherwise New panics.
+func WithWeigher[K comparable, V any](fn func(key K, value V) int) Option {
+	return func(o *options) {
+		o.weigher = WeigherFunc[K, V](fn)
+	}
+}
+
+// NewWithMaxBytes returns a cache limited to roughly maxBytes of memory. Items
+// are weighed by SizeOf unless a WithWeigher option supplies a more accurate
+// estimate.
+func NewWithMaxBytes[K comparable, V any](maxBytes int, opts ...Option) *LRUCache[K, V] {
+	opts = append([]Option{WithWeigher(SizeOf[K, V])}, opts...)
+	return New[K, V](maxBytes, opts...)
+}
+
+// SizeOf estimates the memory held by an item in bytes: the fixed size of K
+// and V, the contents of string and []byte keys and values, and a constant
+// per-item overhead. Memory referenced through other pointers is not counted.
+func SizeOf[K comparable, V any](key K, value V) int {
+	n := itemOverhead + int(unsafe.Sizeof(key)) + int(unsafe.Sizeof(value))
+	return n + indirectSize(key) + indirectSize(value)
+}
+
+func indirectSize(v any) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return cap(v)
+	}
+	return 0
+}
+
+// Weight returns the total weight of the items in the cache. Without a
+// weigher every item weighs 1, so it equals Len.
+func (c *LRUCache[K, V]) Weight() int {
+	n := 0
+	for _, s := range c.shards {
+		n += s.weight()
+	}
+	return n
+}
@@ -0,0 +1,242 @@
+package lrucache
+
+import (
+	"math/bits"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStatsWindows are the windows of WithStatsWindows when none are
+// given.
+var DefaultStatsWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// windowBuckets is the number of buckets a window is split into. The
+// current bucket is only partly filled, so a window covers between
+// (windowBuckets-1)/windowBuckets of its duration and all of it.
+const windowBuckets = 60
+
+// Loader latencies are counted in a histogram with latencySteps buckets per
+// power of two from 2^latencyMinExp ns (about 1µs) to 2^latencyMaxExp ns
+// (about 34s), so percentiles are off by at most a fifth.
+const (
+	latencyMinExp  = 10
+	latencyMaxExp  = 35
+	latencySteps   = 4
+	latencyBuckets = (latencyMaxExp-latencyMinExp)*latencySteps + 2 // and one each below and above
+)
+
+// WithStatsWindows adds hit ratios and loader latency percentiles over the
+// last windows to Stats, next to the lifetime counters: e.g. to spot a
+// regression right after a deploy, which barely moves a ratio counted over
+// weeks. No windows selects DefaultStatsWindows. Every lookup and load then
+// updates a few more atomic counters per window; it is off by default.
+func WithStatsWindows(windows ...time.Duration) Option {
+	return func(o *options) {
+		if len(windows) == 0 {
+			windows = DefaultStatsWindows
+		}
+		o.statsWindows = windows
+	}
+}
+
+// WindowStats are the counters of Stats restricted to the last Window.
+type WindowStats struct {
+	Window       time.Duration
+	Hits         uint64
+	Misses       uint64
+	LoaderCalls  uint64
+	LoaderErrors uint64
+	LoadP50      time.Duration // median loader latency, 0 without loads
+	LoadP99      time.Duration
+}
+
+// HitRatio returns Hits / (Hits + Misses), or 0 if there were no lookups.
+func (w WindowStats) HitRatio() float64 {
+	return hitRatio(w.Hits, w.Misses)
+}
+
+// statWindows holds one ring per window; recording updates all of them.
+type statWindows struct {
+	rings []*statRing
+	clock Clock
+}
+
+func newStatWindows(windows []time.Duration, clock Clock) *statWindows {
+	windows = slices.Clone(windows)
+	slices.Sort(windows)
+	w := &statWindows{clock: clock}
+	for _, d := range slices.Compact(windows) {
+		if d > 0 {
+			w.rings = append(w.rings, newStatRing(d))
+		}
+	}
+	if len(w.rings) == 0 {
+		return nil
+	}
+	return w
+}
+
+// lookup records a Get, a hit or a miss. w may be nil.
+func (w *statWindows) lookup(now time.Time, hit bool) {
+	if w == nil {
+		return
+	}
+	for _, r := range w.rings {
+		b := r.bucket(now)
+		if hit {
+			b.hits.Add(1)
+		} else {
+			b.misses.Add(1)
+		}
+	}
+}
+
+// load records a loader call that started at start. w may be nil.
+func (w *statWindows) load(start time.Time, err error) {
+	if w == nil {
+		return
+	}
+	now := w.clock.Now()
+	i := latencyBucket(now.Sub(start))
+	for _, r := range w.rings {
+		b := r.bucket(now)
+		b.loads.Add(1)
+		if err != nil {
+			b.errors.Add(1)
+		}
+		b.latency[i].Add(1)
+	}
+}
+
+// stats returns the counters of every window, shortest first. w may be nil.
+func (w *statWindows) stats(now time.Time) []WindowStats {
+	if w == nil {
+		return nil
+	}
+	out := make([]WindowStats, 0, len(w.rings))
+	for _, r := range w.rings {
+		out = append(out, r.stats(now))
+	}
+	return out
+}
+
+// statRing is a window split into windowBuckets buckets that are reused
+// round robin: a bucket is cleared when the first event of a newer period
+// lands in it.
+type statRing struct {
+	window  time.Duration
+	width   int64 // of a bucket, in nanoseconds
+	mu      sync.Mutex
+	buckets [windowBuckets]statBucket
+}
+
+type statBucket struct {
+	period  atomic.Int64 // time / width of the events counted, 0 if none
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	loads   atomic.Uint64
+	errors  atomic.Uint64
+	latency [latencyBuckets]atomic.Uint64
+}
+
+func newStatRing(window time.Duration) *statRing {
+	return &statRing{
+		window: window,
+		width:  max(int64(window/windowBuckets), 1),
+	}
+}
+
+// bucket returns the bucket counting events at now, clearing it first if it
+// still holds an older period. Events racing with the clear may be lost or
+// counted in the new period, which is fine for statistics.
+func (r *statRing) bucket(now time.Time) *statBucket {
+	p := now.UnixNano() / r.width
+	b := &r.buckets[p%windowBuckets]
+	if b.period.Load() != p {
+		r.mu.Lock()
+		if b.period.Load() != p {
+			b.reset()
+			b.period.Store(p)
+		}
+		r.mu.Unlock()
+	}
+	return b
+}
+
+func (b *statBucket) reset() {
+	b.hits.Store(0)
+	b.misses.Store(0)
+	b.loads.Store(0)
+	b.errors.Store(0)
+	for i := range b.latency {
+		b.latency[i].Store(0)
+	}
+}
+
+func (r *statRing) stats(now time.Time) WindowStats {
+	st := WindowStats{Window: r.window}
+	p := now.UnixNano() / r.width
+	var latency [latencyBuckets]uint64
+	for i := range r.buckets {
+		b := &r.buckets[i]
+		if bp := b.period.Load(); bp <= p-windowBuckets || bp > p {
+			continue
+		}
+		st.Hits += b.hits.Load()
+		st.Misses += b.misses.Load()
+		st.LoaderCalls += b.loads.Load()
+		st.LoaderErrors += b.errors.Load()
+		for j := range latency {
+			latency[j] += b.latency[j].Load()
+		}
+	}
+	st.LoadP50 = latencyQuantile(&latency, 0.50)
+	st.LoadP99 = latencyQuantile(&latency, 0.99)
+	return st
+}
+
+// latencyBucket returns the histogram bucket counting d.
+func latencyBucket(d time.Duration) int {
+	ns := uint64(max(d, 0))
+	exp := bits.Len64(ns) - 1 // ns is in [2^exp, 2^(exp+1))
+	if exp < latencyMinExp {
+		return 0
+	}
+	if exp >= latencyMaxExp {
+		return latencyBuckets - 1
+	}
+	// the bits after the leading one pick the step within the power of two
+	step := int(ns>>(exp-2)) & (latencySteps - 1)
+	return (exp-latencyMinExp)*latencySteps + step + 1
+}
+
+// latencyLimit returns the upper bound of histogram bucket i.
+func latencyLimit(i int) time.Duration {
+	if i == 0 {
+		return 1 << latencyMinExp
+	}
+	exp, step := (i-1)/latencySteps+latencyMinExp, (i-1)%latencySteps
+	return time.Duration(1<<exp + (step+1)<<(exp-2))
+}
+
+// latencyQuantile returns the upper bound of the bucket holding quantile q
+// of the latencies counted in h, or 0 if h is empty.
+func latencyQuantile(h *[latencyBuckets]uint64, q float64) time.Duration {
+	var total uint64
+	for _, n := range h {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(total-1)) + 1
+	var seen uint64
+	for i, n := range h {
+		if seen += n; seen >= rank {
+			return latencyLimit(i)
+		}
+	}
+	return latencyLimit(latencyBuckets - 1)
+}
@@ -1,37 +1,79 @@
+// Command salestax-srv serves cached sales tax rates.
 //
+// Usage:
+//
+//	salestax-srv serve    [flags]         run the HTTP and gRPC servers
+//	salestax-srv warm     [flags] file    push a CSV/JSON rate file to a running server
+//	salestax-srv bench    [flags]         measure the cache against the fake loader
+//	salestax-srv snapshot [flags] file    inspect or build a snapshot file
+//
+// Run "salestax-srv <command> -h" for the flags of a command.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
-	"math/rand"
-	"strconv"
-	"time"
+	"os"
 )
 
-const CACHE_SIZE = 50000
-const ATTEMPTS = 10000
-
-func main() {
-
-	c := lrucache.New(CACHE_SIZE)
+// command is a salestax-srv subcommand. run receives the arguments following
+// the command name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
 
-	rand.Seed(time.Now().UnixNano())
+var commands = []command{
+	{"serve", "run the HTTP and gRPC servers", runServe},
+	{"warm", "push a CSV/JSON rate file to a running server", runWarm},
+	{"bench", "measure the cache against the fake loader", runBench},
+	{"snapshot", "inspect or build a snapshot file", runSnapshot},
+}
 
-	for i := 0; i < ATTEMPTS; i++ {
-		strId := rand.Intn(CACHE_SIZE * 2)
-		s := strconv.Itoa(strId)
-		c.FastRateLookup(s, sales_tax_lookup)
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(os.Args[2:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "salestax-srv %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if name != "-h" && name != "-help" && name != "help" {
+		fmt.Fprintf(os.Stderr, "salestax-srv: unknown command %q\n", name)
 	}
+	usage()
+	os.Exit(2)
+}
 
-	fmt.Println(c)
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: salestax-srv <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
 }
 
-// Fake slow lookup routine. The street addresses are stringify'd random numbers
-// from [0, CACHE*2]. This routine sleeps for 10ms before returning.
-func sales_tax_lookup(key string) (float64, error) {
-	val, _ := strconv.ParseInt(key, 10, 64)
-	fval := float64(val) * 1.238712
-	time.Sleep(10 * time.Millisecond)
-	return fval, nil
+// newFlagSet returns a flag set for the named subcommand that returns parse
+// errors instead of exiting. args describes the positional arguments.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: salestax-srv %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
 }
@@ -0,0 +1,631 @@
+// Package memcacheserver exposes a salestax.RateCache over the memcached
+// text protocol, so that the memcached client of any language can look up
+// rates without an SDK of salestax-srv.
+//
+// Commands:
+//
+//	get <address>*          the combined rates, e.g. "0.0725", loading misses;
+//	                        addresses that cannot be looked up are left out
+//	gets <address>*         get with the version of each rate as its cas value
+//	set <address> <flags> <exptime> <bytes> [noreply]
+//	                        store a rate, a number or the JSON of a
+//	                        salestax.TaxRate breakdown; a positive exptime
+//	                        is its TTL in seconds, or a Unix time if above 30
+//	                        days, as in memcached, and 0 the TTL of the cache
+//	add, replace            set if the address is absent, or present
+//	cas <address> <flags> <exptime> <bytes> <cas> [noreply]
+//	                        set if the rate is still at version cas; exptime
+//	                        is ignored
+//	delete <address> [noreply]
+//	flush_all [noreply]     remove every rate
+//	stats, version, quit
+//
+// With EnableCluster the writes, but for flush_all which empties the cache
+// of this instance only, are broadcast to the other instances.
+//
+// A server made read-only with SetReadOnly, on a replica, fails set, add,
+// replace, cas, delete and flush_all with SERVER_ERROR.
+//
+// With EnableAuth a connection authenticates as in memcached: its first
+// command is a set of any key whose data is "<username> <API key>", the
+// username being ignored, or the API key alone. Until then commands fail
+// with CLIENT_ERROR unauthenticated; after, those that write require the
+// admin role.
+//
+// Keys are addresses percent-encoded as in URL paths, e.g. 1%20Main%20St,
+// since memcached keys cannot contain spaces. Flags are not stored: values
+// are returned with flags 0.
+package memcacheserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// DefaultAddr is the listen address used when none is configured.
+const DefaultAddr = ":11211"
+
+// maxValue bounds the size of stored values.
+const maxValue = 64 << 10
+
+// relativeLimit is the largest exptime memcached takes as relative, 30 days;
+// larger ones are Unix times.
+const relativeLimit = 30 * 24 * 60 * 60
+
+// Server serves the memcached text protocol from a cache. A nil loader makes
+// the server cache-only: misses are left out of get replies instead of being
+// loaded.
+type Server struct {
+	addr     string
+	cache    *salestax.RateCache
+	loader   salestax.RateLoaderFuncCtx
+	started  time.Time
+	readOnly bool
+	auth     *auth.Authenticator   // nil unless EnableAuth was called
+	cluster  *invalidation.Cluster // nil unless EnableCluster was called
+
+	mu       sync.Mutex
+	lis      net.Listener
+	conns    map[net.Conn]bool // whether each waits for its next command
+	shutdown bool
+	done     sync.WaitGroup
+}
+
+// New returns a Server listening on addr (DefaultAddr if empty). The loader
+// is called with a context cancelled when the client disconnects or the
+// server shuts down.
+func New(addr string, cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx) *Server {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	return &Server{addr: addr, cache: cache, loader: loader, started: time.Now(), conns: make(map[net.Conn]bool)}
+}
+
+// SetReadOnly makes the server refuse the commands that write, for
+// read-only replicas. It must be called before the server starts serving.
+func (s *Server) SetReadOnly() {
+	s.readOnly = true
+}
+
+// EnableAuth makes the server authenticate its connections with the API
+// keys of a, see the package documentation, and authorize each command as
+// Authorize does. Clients of a tenant are refused, as the server serves the
+// default cache. It must be called before the server starts serving.
+func (s *Server) EnableAuth(a *auth.Authenticator) {
+	s.auth = a
+}
+
+// EnableCluster makes the server broadcast its writes through cluster, so
+// that the other instances evict their copies. The cluster must invalidate
+// the cache of the server. It must be called before the server starts
+// serving.
+func (s *Server) EnableCluster(cluster *invalidation.Cluster) {
+	s.cluster = cluster
+}
+
+// errReadOnly is the SERVER_ERROR of writes to a read-only server.
+const errReadOnly = "SERVER_ERROR read-only replica, write to the primary"
+
+// Addr returns the configured listen address.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// ListenAndServe serves requests until Shutdown is called, in which case
+// it returns nil.
+func (s *Server) ListenAndServe() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve serves requests on lis until Shutdown is called.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.lis = lis
+	s.mu.Unlock()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			s.mu.Lock()
+			shutdown := s.shutdown
+			s.mu.Unlock()
+			if shutdown {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.shutdown {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = false
+		s.done.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections and lets every connection finish
+// its current command. If ctx expires first the connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	if s.lis != nil {
+		s.lis.Close()
+	}
+	for conn, idle := range s.conns {
+		// unblocks connections waiting for their next command, the others
+		// stop once their command is done
+		if idle {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.done.Done()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var sess session
+	for s.waitCommand(conn, r) {
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				fmt.Fprint(w, "CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return
+		}
+		quit := s.command(ctx, &sess, strings.Fields(line), r, w)
+		if w.Flush() != nil || quit {
+			return
+		}
+	}
+}
+
+// waitCommand waits for the next command on conn and reports whether one
+// arrived. Shutdown interrupts the wait, but not a command that has begun to
+// arrive, so that a set is not cut off between its line and its data.
+func (s *Server) waitCommand(conn net.Conn, r *bufio.Reader) bool {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return false
+	}
+	s.conns[conn] = true
+	s.mu.Unlock()
+	_, err := r.Peek(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = false
+	if err == nil && s.shutdown {
+		// the deadline of Shutdown may have come after the command
+		conn.SetReadDeadline(time.Time{})
+	}
+	return err == nil
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a command line, without its \r\n.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// session is the state of a connection.
+type session struct {
+	key string // API key the connection authenticated with, see EnableAuth
+}
+
+// command runs one command and reports whether the connection is to be
+// closed.
+func (s *Server) command(ctx context.Context, sess *session, args []string, r *bufio.Reader, w *bufio.Writer) bool {
+	if len(args) == 0 {
+		fmt.Fprint(w, "ERROR\r\n")
+		return false
+	}
+	switch args[0] {
+	case "set", "add", "replace", "cas", "quit":
+		// stores are authorized once their data is read
+	case "delete", "flush_all":
+		if _, noreply := noReply(args[1:]); !s.authorize(sess, auth.Admin, noreply, w) {
+			return false
+		}
+	default:
+		if !s.authorize(sess, auth.Read, false, w) {
+			return false
+		}
+	}
+	switch args[0] {
+	case "get", "gets":
+		s.get(ctx, args[1:], args[0] == "gets", w)
+	case "set", "add", "replace", "cas":
+		return s.store(ctx, sess, args, r, w)
+	case "delete":
+		keys, noreply := noReply(args[1:])
+		address, ok := "", len(keys) == 1
+		if ok {
+			address, ok = parseKey(keys[0])
+		}
+		switch {
+		case !ok:
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		case s.readOnly:
+			reply(w, noreply, errReadOnly)
+		default:
+			deleted := s.cache.Delete(address)
+			// other instances may hold a copy even if this one did not
+			if err := s.broadcast(ctx, address); err != nil {
+				reply(w, noreply, "SERVER_ERROR "+err.Error())
+			} else if deleted {
+				reply(w, noreply, "DELETED")
+			} else {
+				reply(w, noreply, "NOT_FOUND")
+			}
+		}
+	case "flush_all":
+		_, noreply := noReply(args[1:])
+		if s.readOnly {
+			reply(w, noreply, errReadOnly)
+			break
+		}
+		s.cache.Purge()
+		reply(w, noreply, "OK")
+	case "stats":
+		s.stats(w)
+	case "version":
+		fmt.Fprint(w, "VERSION salestax-srv\r\n")
+	case "quit":
+		return true
+	default:
+		fmt.Fprint(w, "ERROR\r\n")
+	}
+	return false
+}
+
+func (s *Server) get(ctx context.Context, keys []string, withCAS bool, w *bufio.Writer) {
+	for _, key := range keys {
+		address, ok := parseKey(key)
+		if !ok {
+			continue
+		}
+		var rate salestax.TaxRate
+		var version uint64
+		var err error
+		if s.loader == nil {
+			rate, err = s.cache.Lookup(address)
+		} else {
+			rate, err = s.cache.GetOrLoadCtx(ctx, address, s.loader)
+		}
+		if err == nil && withCAS {
+			rate, version, err = s.cache.GetWithVersion(address)
+		}
+		if err != nil {
+			continue
+		}
+		// rounded so that sums of components like 0.06 + 0.01 read 0.07
+		value := strconv.FormatFloat(math.Round(rate.Total()*1e9)/1e9, 'f', -1, 64)
+		if withCAS {
+			fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(value), version, value)
+		} else {
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\n", key, len(value), value)
+		}
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+// authorize reports whether the connection of sess may run a command
+// requiring role, replying the error if not.
+func (s *Server) authorize(sess *session, role auth.Role, noreply bool, w *bufio.Writer) bool {
+	if s.auth == nil {
+		return true
+	}
+	if sess.key == "" {
+		reply(w, noreply, "CLIENT_ERROR unauthenticated")
+		return false
+	}
+	_, err := s.auth.Authorize(sess.key, nil, role)
+	switch {
+	case errors.Is(err, auth.ErrRateLimited):
+		reply(w, noreply, "SERVER_ERROR "+err.Error())
+	case err != nil:
+		reply(w, noreply, "CLIENT_ERROR "+err.Error())
+	default:
+		return true
+	}
+	return false
+}
+
+// login authenticates the connection of sess with the data of its first
+// set, "<username> <API key>" or the API key alone.
+func (s *Server) login(sess *session, data []byte, noreply bool, w *bufio.Writer) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 1 && len(fields) != 2 {
+		reply(w, noreply, "CLIENT_ERROR authentication failure")
+		return
+	}
+	key := fields[len(fields)-1]
+	c, err := s.auth.Authorize(key, nil, auth.Read)
+	switch {
+	case errors.Is(err, auth.ErrRateLimited):
+		reply(w, noreply, "SERVER_ERROR "+err.Error())
+	case err != nil:
+		reply(w, noreply, "CLIENT_ERROR authentication failure")
+	case c.Tenant != "":
+		reply(w, noreply, fmt.Sprintf("CLIENT_ERROR client %s is of tenant %s, which the memcached protocol does not serve", c.Name, c.Tenant))
+	default:
+		sess.key = key
+		reply(w, noreply, "STORED")
+	}
+}
+
+// store runs set, add, replace and cas.
+func (s *Server) store(ctx context.Context, sess *session, args []string, r *bufio.Reader, w *bufio.Writer) bool {
+	cmd := args[0]
+	args, noreply := noReply(args[1:])
+	want := 4
+	if cmd == "cas" {
+		want = 5
+	}
+	if len(args) != want {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	key, ok := parseKey(args[0])
+	if !ok {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	exptime, err1 := strconv.ParseInt(args[2], 10, 64)
+	n, err2 := strconv.Atoi(args[3])
+	if _, err := strconv.ParseUint(args[1], 10, 32); err != nil || err1 != nil || err2 != nil || n < 0 {
+		fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+	if n > maxValue {
+		// the data cannot be skipped reliably, so give up on the connection
+		fmt.Fprint(w, "SERVER_ERROR object too large for cache\r\n")
+		return true
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return true
+	}
+	if string(data[n:]) != "\r\n" {
+		fmt.Fprint(w, "CLIENT_ERROR bad data chunk\r\n")
+		return true
+	}
+	// after the data, which the connection has to skip anyway
+	if s.auth != nil && sess.key == "" && cmd == "set" {
+		s.login(sess, data[:n], noreply, w)
+		return false
+	}
+	if !s.authorize(sess, auth.Admin, noreply, w) {
+		return false
+	}
+	if s.readOnly {
+		reply(w, noreply, errReadOnly)
+		return false
+	}
+	rate, err := parseRate(data[:n])
+	if err != nil {
+		reply(w, noreply, "CLIENT_ERROR "+err.Error())
+		return false
+	}
+
+	switch cmd {
+	case "cas":
+		version, err := strconv.ParseUint(args[4], 10, 64)
+		if err != nil {
+			fmt.Fprint(w, "CLIENT_ERROR bad command line format\r\n")
+			return false
+		}
+		if !s.cache.Contains(key) {
+			reply(w, noreply, "NOT_FOUND")
+			return false
+		}
+		switch err := s.cache.InsertIfVersion(key, rate, version); {
+		case errors.Is(err, salestax.ErrVersionMismatch):
+			reply(w, noreply, "EXISTS")
+		case err != nil:
+			reply(w, noreply, "SERVER_ERROR "+err.Error())
+		default:
+			s.stored(ctx, key, noreply, w)
+		}
+		return false
+	case "add":
+		if s.cache.Contains(key) {
+			reply(w, noreply, "NOT_STORED")
+			return false
+		}
+	case "replace":
+		if !s.cache.Contains(key) {
+			reply(w, noreply, "NOT_STORED")
+			return false
+		}
+	}
+	if exptime < 0 {
+		// memcached stores already expired items, i.e. none
+		s.cache.Delete(key)
+		s.stored(ctx, key, noreply, w)
+		return false
+	}
+	// InsertWithTTL takes 0 as no expiration, Insert applies the TTL of
+	// the cache
+	if d := ttl(exptime, time.Now()); d > 0 {
+		err = s.cache.InsertWithTTL(key, rate, d)
+	} else {
+		err = s.cache.Insert(key, rate)
+	}
+	if err != nil {
+		reply(w, noreply, "SERVER_ERROR "+err.Error())
+		return false
+	}
+	s.stored(ctx, key, noreply, w)
+	return false
+}
+
+// stored replies STORED to the write of address once it is broadcast.
+func (s *Server) stored(ctx context.Context, address string, noreply bool, w *bufio.Writer) {
+	if err := s.broadcast(ctx, address); err != nil {
+		reply(w, noreply, "SERVER_ERROR "+err.Error())
+		return
+	}
+	reply(w, noreply, "STORED")
+}
+
+// broadcast makes the other instances of the cluster evict their copies of
+// the rate of address, after a write.
+func (s *Server) broadcast(ctx context.Context, address string) error {
+	if s.cluster == nil {
+		return nil
+	}
+	if _, err := s.cluster.Invalidate(ctx, invalidation.Event{Keys: []string{address}}); err != nil {
+		return fmt.Errorf("broadcasting the invalidation of %s: %w", address, err)
+	}
+	return nil
+}
+
+// ttl converts a memcached exptime to a TTL, 0 for the default of the
+// cache.
+func ttl(exptime int64, now time.Time) time.Duration {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime > relativeLimit:
+		if d := time.Unix(exptime, 0).Sub(now); d > 0 {
+			return d
+		}
+		return time.Nanosecond
+	}
+	return time.Duration(exptime) * time.Second
+}
+
+// parseRate parses a stored value: a combined rate, or the JSON of a
+// salestax.TaxRate.
+func parseRate(data []byte) (salestax.TaxRate, error) {
+	s := strings.TrimSpace(string(data))
+	if rate, err := strconv.ParseFloat(s, 64); err == nil {
+		return salestax.Flat(rate), nil
+	}
+	var rate salestax.TaxRate
+	if err := json.Unmarshal(data, &rate); err != nil || len(rate.Components) == 0 {
+		return salestax.TaxRate{}, errors.New("value is neither a rate nor a JSON breakdown")
+	}
+	return rate, nil
+}
+
+func (s *Server) stats(w *bufio.Writer) {
+	st := s.cache.Stats()
+	for _, stat := range []struct {
+		name  string
+		value any
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(time.Since(s.started).Seconds())},
+		{"time", time.Now().Unix()},
+		{"version", "salestax-srv"},
+		{"curr_items", st.Size},
+		{"get_hits", st.Hits},
+		{"get_misses", st.Misses},
+		{"evictions", st.Evictions},
+		{"expired_unfetched", st.Expirations},
+		{"loader_calls", st.LoaderCalls},
+		{"loader_errors", st.LoaderErrors},
+	} {
+		fmt.Fprintf(w, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	fmt.Fprint(w, "END\r\n")
+}
+
+// noReply strips a trailing "noreply" from args.
+func noReply(args []string) ([]string, bool) {
+	if n := len(args); n > 0 && args[n-1] == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+func reply(w *bufio.Writer, noreply bool, msg string) {
+	if !noreply {
+		fmt.Fprint(w, msg+"\r\n")
+	}
+}
+
+// parseKey returns the address of a key, which must be a memcached key: at
+// most 250 bytes, without control characters or spaces.
+func parseKey(key string) (string, bool) {
+	if len(key) == 0 || len(key) > 250 {
+		return "", false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return "", false
+		}
+	}
+	address, err := url.PathUnescape(key)
+	return address, err == nil && address != ""
+}
@@ -0,0 +1,572 @@
+package memcacheserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// client is a connection to a test server.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newTestServer serves cache on a loopback port until the test ends, once
+// the setup functions have been called on the server.
+func newTestServer(t *testing.T, cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx, setup ...func(*Server)) (*Server, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(lis.Addr().String(), cache, loader)
+	for _, f := range setup {
+		f(s)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return s, lis.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes s as is, \r\n included.
+func (c *client) send(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads the reply lines want.
+func (c *client) expect(want ...string) {
+	c.t.Helper()
+	for _, w := range want {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading %q: %v", w, err)
+		}
+		if got := strings.TrimSuffix(line, "\r\n"); got != w {
+			c.t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+}
+
+// closed checks that the server closed the connection, resetting it if it
+// left data of the client unread.
+func (c *client) closed() {
+	c.t.Helper()
+	if line, err := c.r.ReadString('\n'); !errors.Is(err, io.EOF) && !errors.Is(err, syscall.ECONNRESET) {
+		c.t.Fatalf("read %q, %v, want the connection closed", line, err)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	_, addr := newTestServer(t, salestax.NewRateCache(100), nil)
+	c := dial(t, addr)
+
+	c.send("set 1%20Main%20St 5 0 6\r\n0.0725\r\n")
+	c.expect("STORED")
+	c.send("get 1%20Main%20St nowhere\r\n")
+	c.expect("VALUE 1%20Main%20St 0 6", "0.0725", "END")
+
+	body := `{"components": [{"level": "state", "rate": 0.06}, {"rate": 0.01}]}`
+	c.send("set b 0 0 " + strconv.Itoa(len(body)) + "\r\n" + body + "\r\n")
+	c.expect("STORED")
+	c.send("get b 1%20Main%20St\r\n")
+	c.expect("VALUE b 0 4", "0.07", "VALUE 1%20Main%20St 0 6", "0.0725", "END")
+
+	c.send("set c 0 0 3\r\nabc\r\n")
+	c.expect("CLIENT_ERROR value is neither a rate nor a JSON breakdown")
+	c.send("get c\r\n")
+	c.expect("END")
+}
+
+func TestAddReplace(t *testing.T) {
+	_, addr := newTestServer(t, salestax.NewRateCache(100), nil)
+	c := dial(t, addr)
+
+	c.send("replace a 0 0 4\r\n0.05\r\n")
+	c.expect("NOT_STORED")
+	c.send("add a 0 0 4\r\n0.05\r\n")
+	c.expect("STORED")
+	c.send("add a 0 0 4\r\n0.06\r\n")
+	c.expect("NOT_STORED")
+	c.send("replace a 0 0 4\r\n0.07\r\n")
+	c.expect("STORED")
+	c.send("get a\r\n")
+	c.expect("VALUE a 0 4", "0.07", "END")
+}
+
+func TestCAS(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	_, addr := newTestServer(t, cache, nil)
+	c := dial(t, addr)
+
+	c.send("cas a 0 0 4 1\r\n0.05\r\n")
+	c.expect("NOT_FOUND")
+	c.send("set a 0 0 4\r\n0.05\r\n")
+	c.expect("STORED")
+	_, version, err := cache.GetWithVersion("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := strconv.FormatUint(version, 10)
+	c.send("gets a\r\n")
+	c.expect("VALUE a 0 4 "+v, "0.05", "END")
+	c.send("cas a 0 0 4 " + v + "\r\n0.06\r\n")
+	c.expect("STORED")
+	c.send("cas a 0 0 4 " + v + "\r\n0.07\r\n")
+	c.expect("EXISTS")
+	c.send("get a\r\n")
+	c.expect("VALUE a 0 4", "0.06", "END")
+	c.send("cas a 0 0 4 x\r\n0.07\r\n")
+	c.expect("CLIENT_ERROR bad command line format")
+}
+
+func TestDelete(t *testing.T) {
+	_, addr := newTestServer(t, salestax.NewRateCache(100), nil)
+	c := dial(t, addr)
+
+	c.send("set a 0 0 4\r\n0.05\r\n")
+	c.expect("STORED")
+	c.send("delete a\r\n")
+	c.expect("DELETED")
+	c.send("delete a\r\n")
+	c.expect("NOT_FOUND")
+	for _, cmd := range []string{"delete", "delete a b", "delete %zz"} {
+		c.send(cmd + "\r\n")
+		c.expect("CLIENT_ERROR bad command line format")
+	}
+
+	c.send("set a 0 0 4\r\n0.05\r\n")
+	c.expect("STORED")
+	c.send("flush_all\r\n")
+	c.expect("OK")
+	c.send("get a\r\n")
+	c.expect("END")
+}
+
+func TestReadOnly(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("a", salestax.Flat(0.05))
+	_, addr := newTestServer(t, cache, nil, (*Server).SetReadOnly)
+	c := dial(t, addr)
+
+	for _, cmd := range []string{
+		"set a 0 0 4\r\n0.06\r\n",
+		"add b 0 0 4\r\n0.06\r\n",
+		"replace a 0 0 4\r\n0.06\r\n",
+		"cas a 0 0 4 1\r\n0.06\r\n",
+		"delete a\r\n",
+		"flush_all\r\n",
+	} {
+		c.send(cmd)
+		c.expect("SERVER_ERROR read-only replica, write to the primary")
+	}
+	c.send("set b 0 0 4 noreply\r\n0.06\r\nget a b\r\n")
+	c.expect("VALUE a 0 4", "0.05", "END")
+	if cache.Len() != 1 {
+		t.Errorf("cache holds %v, want a alone", cache.Keys())
+	}
+}
+
+func TestAuth(t *testing.T) {
+	a, err := auth.New([]auth.Client{
+		{Name: "checkout", Role: auth.Read, Key: "r-key"},
+		{Name: "rates-sync", Role: auth.Admin, Key: "w-key"},
+		{Name: "acme-pos", Role: auth.Read, Key: "t-key", Tenant: "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := salestax.NewRateCache(100)
+	cache.Insert("a", salestax.Flat(0.05))
+	_, addr := newTestServer(t, cache, nil, func(s *Server) { s.EnableAuth(a) })
+
+	c := dial(t, addr)
+	for _, cmd := range []string{"get a\r\n", "stats\r\n", "delete a\r\n", "flush_all\r\n", "add a 0 0 4\r\n0.06\r\n"} {
+		c.send(cmd)
+		c.expect("CLIENT_ERROR unauthenticated")
+	}
+	for _, creds := range []string{"checkout bad-key", "a b c", "", "acme-pos t-key"} {
+		c.send("set auth 0 0 " + strconv.Itoa(len(creds)) + "\r\n" + creds + "\r\n")
+		if creds == "acme-pos t-key" {
+			c.expect("CLIENT_ERROR client acme-pos is of tenant acme, which the memcached protocol does not serve")
+		} else {
+			c.expect("CLIENT_ERROR authentication failure")
+		}
+	}
+	c.send("set auth 0 0 5\r\nr-key\r\n")
+	c.expect("STORED")
+	c.send("get a\r\n")
+	c.expect("VALUE a 0 4", "0.05", "END")
+	for _, cmd := range []string{"set a 0 0 4\r\n0.06\r\n", "delete a\r\n", "flush_all\r\n"} {
+		c.send(cmd)
+		c.expect("CLIENT_ERROR forbidden: client checkout has the read role, admin is required")
+	}
+
+	c = dial(t, addr)
+	c.send("set auth 0 0 16\r\nrates-sync w-key\r\n")
+	c.expect("STORED")
+	c.send("set a 0 0 4\r\n0.06\r\nget a\r\n")
+	c.expect("STORED", "VALUE a 0 4", "0.06", "END")
+	c.send("flush_all\r\n")
+	c.expect("OK")
+	if cache.Len() != 0 {
+		t.Errorf("flush_all left %v", cache.Keys())
+	}
+}
+
+// bus records the keys of the events published, or fails with err.
+type bus struct {
+	mu   sync.Mutex
+	keys []string
+	err  error
+}
+
+func (b *bus) Publish(_ context.Context, msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	var e invalidation.Event
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, e.Keys...)
+	return nil
+}
+
+func (b *bus) Subscribe(ctx context.Context, _ func([]byte)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestCluster(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	b := &bus{}
+	cluster := invalidation.New(b, cache)
+	_, addr := newTestServer(t, cache, nil, func(s *Server) { s.EnableCluster(cluster) })
+	c := dial(t, addr)
+
+	c.send("set a 0 0 4\r\n0.05\r\nadd b 0 0 4\r\n0.05\r\nset c 0 -1 4\r\n0.05\r\n")
+	c.expect("STORED", "STORED", "STORED")
+	cache.Insert("a", salestax.Flat(0.05))
+	_, version, err := cache.GetWithVersion("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.send("cas a 0 0 4 " + strconv.FormatUint(version, 10) + "\r\n0.06\r\n")
+	c.expect("STORED")
+	c.send("delete d\r\nflush_all\r\n")
+	c.expect("NOT_FOUND", "OK")
+	if want := []string{"a", "b", "c", "a", "d"}; !slices.Equal(b.keys, want) {
+		t.Errorf("broadcast %q, want %q", b.keys, want)
+	}
+
+	b.mu.Lock()
+	b.err = errors.New("bus down")
+	b.mu.Unlock()
+	c.send("set a 0 0 4\r\n0.05\r\ndelete a\r\n")
+	c.expect("SERVER_ERROR broadcasting the invalidation of a: bus down", "SERVER_ERROR broadcasting the invalidation of a: bus down")
+}
+
+func TestNoReply(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	_, addr := newTestServer(t, cache, nil)
+	c := dial(t, addr)
+
+	c.send("set a 0 0 4 noreply\r\n0.05\r\n")
+	c.send("add a 0 0 4 noreply\r\n0.06\r\n")
+	c.send("set b 0 0 4 noreply\r\n0.05\r\n")
+	c.send("delete b noreply\r\n")
+	c.send("delete b noreply\r\n")
+	c.send("set c 0 0 3 noreply\r\nabc\r\n")
+	c.send("version\r\n")
+	// only the version is replied
+	c.expect("VERSION salestax-srv")
+	if cache.Len() != 1 || !cache.Contains("a") {
+		t.Errorf("cache holds %v, want a alone", cache.Keys())
+	}
+	c.send("flush_all noreply\r\nversion\r\n")
+	c.expect("VERSION salestax-srv")
+	if cache.Len() != 0 {
+		t.Errorf("flush_all noreply left %v", cache.Keys())
+	}
+}
+
+func TestExptime(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	_, addr := newTestServer(t, cache, nil)
+	c := dial(t, addr)
+
+	c.send("set a 0 -1 4\r\n0.05\r\n")
+	c.expect("STORED")
+	c.send("get a\r\n")
+	c.expect("END")
+
+	c.send("set a 0 100 4\r\n0.05\r\n")
+	c.expect("STORED")
+	item, err := cache.Peek("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := item.Info(time.Now()).TTL; left <= 90*time.Second || left > 100*time.Second {
+		t.Errorf("TTL of exptime 100 = %v, want 100s", left)
+	}
+
+	// exptime 0 is the TTL of the cache, not no expiration
+	ttlCache := salestax.NewRateCache(100, lrucache.WithTTL(time.Hour))
+	_, addr = newTestServer(t, ttlCache, nil)
+	c = dial(t, addr)
+	c.send("set a 0 0 4\r\n0.05\r\n")
+	c.expect("STORED")
+	item, err = ttlCache.Peek("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Until(item.Expires()); item.Expires().IsZero() || left <= 59*time.Minute || left > time.Hour {
+		t.Errorf("exptime 0 on a cache with a TTL of 1h expires %v, want in 1h", item.Expires())
+	}
+
+	now := time.Now()
+	for _, tt := range []struct {
+		exptime int64
+		want    time.Duration
+	}{
+		{0, 0},
+		{60, time.Minute},
+		{relativeLimit, relativeLimit * time.Second},
+		{now.Add(time.Hour).Unix(), time.Hour},
+		{now.Add(-time.Hour).Unix(), time.Nanosecond},
+	} {
+		if got := ttl(tt.exptime, now); (got - tt.want).Abs() > time.Second {
+			t.Errorf("ttl(%d) = %v, want %v", tt.exptime, got, tt.want)
+		}
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var loads atomic.Int32
+	_, addr := newTestServer(t, salestax.NewRateCache(100), func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		loads.Add(1)
+		if address == "nowhere" {
+			return salestax.TaxRate{}, salestax.ErrNotFound
+		}
+		return salestax.Flat(0.0825), nil
+	})
+	c := dial(t, addr)
+	c.send("get 1%20Main%20St nowhere 1%20Main%20St\r\n")
+	c.expect("VALUE 1%20Main%20St 0 6", "0.0825", "VALUE 1%20Main%20St 0 6", "0.0825", "END")
+	if n := loads.Load(); n != 2 {
+		t.Errorf("loader called %d times, want once per address", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, addr := newTestServer(t, salestax.NewRateCache(100), nil)
+	c := dial(t, addr)
+	c.send("set a 0 0 4\r\n0.05\r\nget a b\r\nstats\r\n")
+	c.expect("STORED", "VALUE a 0 4", "0.05", "END")
+	stats := make(map[string]string)
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != "STAT" {
+			t.Fatalf("stats line %q", line)
+		}
+		stats[f[1]] = f[2]
+	}
+	for name, want := range map[string]string{"curr_items": "1", "get_hits": "1", "get_misses": "1", "version": "salestax-srv"} {
+		if stats[name] != want {
+			t.Errorf("STAT %s = %q, want %q", name, stats[name], want)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	_, addr := newTestServer(t, salestax.NewRateCache(100), nil)
+
+	t.Run("command line", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("\r\n")
+		c.expect("ERROR")
+		c.send("incr a 1\r\n")
+		c.expect("ERROR")
+		for _, cmd := range []string{
+			"set a 0 0",
+			"set a 0 0 4 5",
+			"set a 0 0 four",
+			"set a 0 0 -1",
+			"set a 0 soon 4",
+			"set a -1 0 4",
+			"set a 4294967296 0 4",
+			"set a%20b%2 0 0 4",
+			"set " + strings.Repeat("k", 251) + " 0 0 4",
+			"cas a 0 0 4",
+		} {
+			c.send(cmd + "\r\n")
+			c.expect("CLIENT_ERROR bad command line format")
+		}
+		// the connection is still usable
+		c.send("version\r\n")
+		c.expect("VERSION salestax-srv")
+	})
+
+	t.Run("data chunk without its CRLF", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("set a 0 0 3\r\n0.05\r\n")
+		c.expect("CLIENT_ERROR bad data chunk")
+		c.closed()
+	})
+
+	t.Run("short data chunk", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("set a 0 0 10\r\n0.05\r\n")
+		c.conn.(*net.TCPConn).CloseWrite()
+		c.closed()
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("set a 0 0 " + strconv.Itoa(maxValue+1) + "\r\n")
+		c.expect("SERVER_ERROR object too large for cache")
+		c.closed()
+	})
+
+	t.Run("line too long", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("get " + strings.Repeat("k ", 4096) + "\r\n")
+		c.expect("CLIENT_ERROR line too long")
+		c.closed()
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		c := dial(t, addr)
+		c.send("quit\r\n")
+		c.closed()
+	})
+}
+
+// waitConns waits until every connection of s is idle, or every one is busy.
+func waitConns(s *Server, idle bool) {
+	for {
+		s.mu.Lock()
+		ok := len(s.conns) > 0
+		for _, i := range s.conns {
+			ok = ok && i == idle
+		}
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := salestax.NewRateCache(10)
+	s := New("", cache, nil)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	c := dial(t, lis.Addr().String())
+	waitConns(s, true)
+	c.send("set a 0 0 4\r\n")
+	waitConns(s, false)
+
+	// the set started before Shutdown, so its data is still read
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	for {
+		s.mu.Lock()
+		down := s.shutdown
+		s.mu.Unlock()
+		if down {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.send("0.07\r\n")
+	c.expect("STORED")
+	c.closed()
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve after Shutdown = %v, want nil", err)
+	}
+	if item, err := cache.Get("a"); err != nil || item.Value().Total() != 0.07 {
+		t.Errorf("Get(a) = %v, %v, want the rate set during Shutdown", item, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("", salestax.NewRateCache(10), nil)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	c := dial(t, lis.Addr().String())
+	c.send("version\r\n")
+	c.expect("VERSION salestax-srv")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve after Shutdown = %v, want nil", err)
+	}
+	c.closed()
+	if _, err := net.Dial("tcp", lis.Addr().String()); err == nil {
+		t.Error("the server still accepts connections after Shutdown")
+	}
+}
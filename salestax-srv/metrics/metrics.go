@@ -0,0 +1,122 @@
+// Package metrics exports lrucache statistics to Prometheus.
+//
+// Register a Collector with a prometheus.Registerer and serve it with
+// promhttp, e.g.
+//
+//	col := metrics.NewCollector("salestax", cache)
+//	prometheus.MustRegister(col)
+//	loader = metrics.InstrumentLoader(col, loader)
+//	http.Handle("/metrics", promhttp.Handler())
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// StatsSource is implemented by every lrucache.LRUCache instantiation.
+type StatsSource interface {
+	Stats() lrucache.Stats
+}
+
+// Collector is a prometheus.Collector reporting the counters of a cache plus
+// a loader latency histogram fed by InstrumentLoader.
+type Collector struct {
+	src StatsSource
+
+	hits         *prometheus.Desc
+	misses       *prometheus.Desc
+	evictions    *prometheus.Desc
+	expirations  *prometheus.Desc
+	loaderCalls  *prometheus.Desc
+	loaderErrors *prometheus.Desc
+	entries      *prometheus.Desc
+	hitRatio     *prometheus.Desc
+
+	loaderDuration prometheus.Histogram
+}
+
+// NewCollector returns a Collector for src. All metric names are prefixed with
+// namespace (e.g. salestax_cache_hits_total).
+func NewCollector(namespace string, src StatsSource) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", name), help, nil, nil)
+	}
+	return &Collector{
+		src:          src,
+		hits:         desc("hits_total", "Lookups served from the cache."),
+		misses:       desc("misses_total", "Lookups for keys that were absent or expired."),
+		evictions:    desc("evictions_total", "Items evicted to make room for new ones."),
+		expirations:  desc("expirations_total", "Items removed because their TTL elapsed."),
+		loaderCalls:  desc("loader_calls_total", "Loader invocations."),
+		loaderErrors: desc("loader_errors_total", "Loader invocations that returned an error."),
+		entries:      desc("entries", "Current number of items in the cache."),
+		hitRatio:     desc("hit_ratio", "Lifetime ratio of hits to lookups."),
+		loaderDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "loader_duration_seconds",
+			Help:      "Latency of loader invocations.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+	ch <- c.loaderCalls
+	ch <- c.loaderErrors
+	ch <- c.entries
+	ch <- c.hitRatio
+	c.loaderDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	st := c.src.Stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(st.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(st.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(st.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(st.Expirations))
+	ch <- prometheus.MustNewConstMetric(c.loaderCalls, prometheus.CounterValue, float64(st.LoaderCalls))
+	ch <- prometheus.MustNewConstMetric(c.loaderErrors, prometheus.CounterValue, float64(st.LoaderErrors))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(st.Size))
+	ch <- prometheus.MustNewConstMetric(c.hitRatio, prometheus.GaugeValue, st.HitRatio())
+	c.loaderDuration.Collect(ch)
+}
+
+// ObserveLoad records the duration of one loader call.
+func (c *Collector) ObserveLoad(d time.Duration) {
+	c.loaderDuration.Observe(d.Seconds())
+}
+
+// InstrumentLoader wraps loader so that every call is timed into the
+// Collector's latency histogram.
+func InstrumentLoader[K comparable, V any](c *Collector, loader lrucache.LoaderFunc[K, V]) lrucache.LoaderFunc[K, V] {
+	return func(key K) (V, error) {
+		start := time.Now()
+		defer func() {
+			c.ObserveLoad(time.Since(start))
+		}()
+		return loader(key)
+	}
+}
+
+// InstrumentLoaderCtx is InstrumentLoader for a context aware loader.
+func InstrumentLoaderCtx[K comparable, V any](c *Collector, loader lrucache.LoaderFuncCtx[K, V]) lrucache.LoaderFuncCtx[K, V] {
+	return func(ctx context.Context, key K) (V, error) {
+		start := time.Now()
+		defer func() {
+			c.ObserveLoad(time.Since(start))
+		}()
+		return loader(ctx, key)
+	}
+}
@@ -0,0 +1,54 @@
+// Package vars publishes lrucache statistics through the standard expvar
+// package, for dashboards reading /debug/vars. Unlike package metrics it does
+// not depend on Prometheus.
+//
+//	vars.Publish("salestax_cache", cache)
+//	http.Handle("/debug/vars", expvar.Handler())
+package vars
+
+import (
+	"expvar"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// StatsSource is implemented by every lrucache.LRUCache instantiation.
+type StatsSource interface {
+	Stats() lrucache.Stats
+}
+
+// Stats is the JSON object published for a cache.
+type Stats struct {
+	Entries      int     `json:"entries"`
+	Hits         uint64  `json:"hits"`
+	Misses       uint64  `json:"misses"`
+	HitRatio     float64 `json:"hit_ratio"`
+	Evictions    uint64  `json:"evictions"`
+	Expirations  uint64  `json:"expirations"`
+	LoaderCalls  uint64  `json:"loader_calls"`
+	LoaderErrors uint64  `json:"loader_errors"`
+}
+
+// Func returns an expvar.Var reading the statistics of src each time it is
+// rendered.
+func Func(src StatsSource) expvar.Func {
+	return func() any {
+		st := src.Stats()
+		return Stats{
+			Entries:      st.Size,
+			Hits:         st.Hits,
+			Misses:       st.Misses,
+			HitRatio:     st.HitRatio(),
+			Evictions:    st.Evictions,
+			Expirations:  st.Expirations,
+			LoaderCalls:  st.LoaderCalls,
+			LoaderErrors: st.LoaderErrors,
+		}
+	}
+}
+
+// Publish publishes the statistics of src under name. Like expvar.Publish it
+// panics if name is already in use.
+func Publish(name string, src StatsSource) {
+	expvar.Publish(name, Func(src))
+}
@@ -0,0 +1,278 @@
+// Package objstore keeps files in an S3 compatible object storage: Amazon
+// S3, Google Cloud Storage through its XML API with HMAC keys, MinIO and
+// the like. Requests are signed with AWS Signature Version 4, and buckets
+// are addressed in the path, <endpoint>/<bucket>/<key>, which all of them
+// accept.
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultRegion is the region requests are signed for unless changed with
+// WithRegion. Google Cloud Storage accepts any.
+const DefaultRegion = "us-east-1"
+
+// emptyHash is the SHA-256 of an empty payload.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ErrNotFound is returned by Get for a key that is not in the bucket.
+var ErrNotFound = errors.New("objstore: object not found")
+
+// Store is a bucket of an object storage. It is safe for concurrent use.
+type Store struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithCredentials signs the requests with the access key ID accessKey and
+// its secret, those of an AWS user or the HMAC keys of a Google service
+// account. Without them, requests are anonymous.
+func WithCredentials(accessKey, secretKey string) Option {
+	return func(s *Store) {
+		s.accessKey, s.secretKey = accessKey, secretKey
+	}
+}
+
+// WithRegion signs the requests for region, by default DefaultRegion, which
+// must be that of the bucket on Amazon S3.
+func WithRegion(region string) Option {
+	return func(s *Store) {
+		s.region = region
+	}
+}
+
+// WithHTTPClient makes the requests with c instead of http.DefaultClient.
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Store) {
+		s.http = c
+	}
+}
+
+// New returns the bucket of the object storage at the base URL endpoint,
+// e.g. "https://s3.us-east-1.amazonaws.com" or
+// "https://storage.googleapis.com".
+func New(endpoint, bucket string, opts ...Option) *Store {
+	s := &Store{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   bucket,
+		region:   DefaultRegion,
+		http:     http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Object describes an object of a bucket.
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Put stores the content of body as key, replacing any object there.
+func (s *Store) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	resp, err := s.do(ctx, http.MethodPut, key, "", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get returns the content of the object key, to be closed by the caller.
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object key, if any.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, "", nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns the objects whose key starts with prefix, ordered by key.
+func (s *Store) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := "list-type=2&prefix=" + uriEncode(prefix, false)
+		if token != "" {
+			// the parameters are sorted, as the signature requires
+			query = "continuation-token=" + uriEncode(token, false) + "&" + query
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("objstore: decoding the objects of %s: %w", s.bucket, err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, Modified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	slices.SortFunc(objects, func(a, b Object) int { return strings.Compare(a.Key, b.Key) })
+	return objects, nil
+}
+
+// do sends a request for key, or the bucket if empty, with the canonical
+// query string query, and fails unless it succeeds.
+func (s *Store) do(ctx context.Context, method, key, query string, body io.ReadSeeker) (*http.Response, error) {
+	url := s.endpoint + "/" + uriEncode(s.bucket, false)
+	if key != "" {
+		url += "/" + uriEncode(key, true)
+	}
+	if query != "" {
+		url += "?" + query
+	}
+	hash := emptyHash
+	var size int64
+	if body != nil {
+		h := sha256.New()
+		n, err := io.Copy(h, body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		hash, size = hex.EncodeToString(h.Sum(nil)), n
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if s.accessKey != "" {
+		s.sign(req, hash, time.Now())
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("objstore: %s %s: %s: %s: %s", method, key, resp.Status, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("objstore: %s %s: %s", method, key, resp.Status)
+}
+
+// sign signs req, whose payload has the SHA-256 hash, at t with Signature
+// Version 4. The headers of req at this point are signed, those added by
+// net/http afterwards are not.
+func (s *Store) sign(req *http.Request, hash string, t time.Time) {
+	stamp := t.UTC().Format("20060102T150405Z")
+	date := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signed, hash}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode escapes s as Signature Version 4 requires: every byte but the
+// unreserved characters, and '/' if path.
+func uriEncode(s string, path bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
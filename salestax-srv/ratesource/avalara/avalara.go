@@ -0,0 +1,154 @@
+// Package avalara is a rate source backed by the tax rates API of Avalara
+// AvaTax. Street addresses are looked up with /api/v2/taxrates/byaddress,
+// bare ZIP codes with /api/v2/taxrates/bypostalcode.
+package avalara
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Base URLs of the AvaTax API.
+const (
+	DefaultURL = "https://rest.avatax.com"
+	SandboxURL = "https://sandbox-rest.avatax.com"
+)
+
+// Client looks rates up with the AvaTax API. It is safe for concurrent use.
+type Client struct {
+	base       string
+	accountID  string
+	licenseKey string
+	api        ratesource.Client
+}
+
+// New returns a client authenticating with the account ID and license key
+// of an AvaTax account. An empty base means DefaultURL. client is used for
+// all requests; its Timeout bounds each of them.
+func New(base, accountID, licenseKey string, client *http.Client) (*Client, error) {
+	if accountID == "" || licenseKey == "" {
+		return nil, errors.New("avalara: account ID and license key are required")
+	}
+	if base == "" {
+		base = DefaultURL
+	}
+	c := &Client{base: strings.TrimSuffix(base, "/"), accountID: accountID, licenseKey: licenseKey}
+	c.api.API, c.api.HTTP, c.api.Message = "avalara", client, errorMessage
+	return c, nil
+}
+
+// rateResponse is the body of a taxrates response.
+type rateResponse struct {
+	TotalRate float64 `json:"totalRate"`
+	Rates     []struct {
+		Rate float64 `json:"rate"`
+		Name string  `json:"name"`
+		Type string  `json:"type"` // State, County, City or Special
+	} `json:"rates"`
+}
+
+// LookupRate returns the rate of address, parsed with
+// ratesource.ParseAddress. Addresses with neither a street and ZIP code nor
+// a street, city and state, or a ZIP code alone, fail with
+// ratesource.ErrIncompleteAddress. It is a salestax.RateLoaderFuncCtx.
+func (c *Client) LookupRate(ctx context.Context, address string) (salestax.TaxRate, error) {
+	a := ratesource.ParseAddress(address)
+	q := url.Values{"country": {"US"}}
+	var path string
+	switch {
+	case a.Street != "" && (a.ZIP != "" || a.City != "" && a.State != ""):
+		path = "/api/v2/taxrates/byaddress"
+		q.Set("line1", a.Street)
+		for name, value := range map[string]string{"city": a.City, "region": a.State, "postalCode": a.ZIP} {
+			if value != "" {
+				q.Set(name, value)
+			}
+		}
+	case a.ZIP != "":
+		path = "/api/v2/taxrates/bypostalcode"
+		q.Set("postalCode", a.ZIP)
+	default:
+		return salestax.TaxRate{}, ratesource.ErrIncompleteAddress
+	}
+
+	req, err := c.newRequest(ctx, path+"?"+q.Encode())
+	if err != nil {
+		return salestax.TaxRate{}, err
+	}
+	var resp rateResponse
+	if err := c.api.GetJSON(req, &resp); err != nil {
+		return salestax.TaxRate{}, err
+	}
+	var rate salestax.TaxRate
+	for _, r := range resp.Rates {
+		rate.Components = append(rate.Components, salestax.Component{
+			Level: ratesource.Level(r.Type),
+			Name:  r.Name,
+			Rate:  r.Rate,
+		})
+	}
+	if len(rate.Components) == 0 {
+		rate = salestax.Flat(resp.TotalRate)
+	}
+	return rate, nil
+}
+
+// Ping checks that the API is reachable and accepts the credentials, using
+// the free /api/v2/utilities/ping endpoint.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, "/api/v2/utilities/ping")
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Authenticated bool `json:"authenticated"`
+	}
+	if err := c.api.GetJSON(req, &resp); err != nil {
+		return err
+	}
+	if !resp.Authenticated {
+		return ratesource.ErrUnauthorized
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.accountID, c.licenseKey)
+	req.Header.Set("X-Avalara-Client", "salestax-srv; 1.0")
+	return req, nil
+}
+
+// errorMessage returns the message of an AvaTax error response, e.g.
+//
+//	{"error": {"code": "AuthenticationException", "message": "Authentication failed.",
+//	           "details": [{"description": "Missing authentication or unable to authenticate the user or the account."}]}}
+func errorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+			Details []struct {
+				Description string `json:"description"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Error.Message == "" {
+		return ""
+	}
+	msg := resp.Error.Code + ": " + resp.Error.Message
+	if len(resp.Error.Details) > 0 && resp.Error.Details[0].Description != "" {
+		msg += " " + resp.Error.Details[0].Description
+	}
+	return strings.TrimPrefix(msg, ": ")
+}
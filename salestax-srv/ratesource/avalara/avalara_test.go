@@ -0,0 +1,93 @@
+package avalara
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	rates := `{"totalRate": 0.08625, "rates": [
+		{"rate": 0.06, "name": "CA STATE TAX", "type": "State"},
+		{"rate": 0.0025, "name": "SAN FRANCISCO", "type": "County"},
+		{"rate": 0.02375, "name": "CA SPECIAL TAX", "type": "Special"}]}`
+	auth := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "acct" || pass != "key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": {"code": "AuthenticationException", "message": "Authentication failed."}}`))
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("/api/v2/taxrates/byaddress", auth(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("line1") != "1 Main St" || q.Get("postalCode") != "94103" || q.Get("country") != "US" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "InvalidAddress", "message": "The address is not deliverable."}}`))
+			return
+		}
+		w.Write([]byte(rates))
+	}))
+	mux.HandleFunc("/api/v2/taxrates/bypostalcode", auth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"totalRate": 0.0725, "rates": []}`))
+	}))
+	mux.HandleFunc("/api/v2/utilities/ping", func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		w.Write([]byte(`{"version": "1", "authenticated": ` + map[bool]string{true: "true", false: "false"}[ok] + `}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLookupRate(t *testing.T) {
+	srv := newTestServer(t)
+	c, err := New(srv.URL, "acct", "key", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	rate, err := c.LookupRate(ctx, "1 Main St, San Francisco, CA 94103")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(rate.Total()-0.08625) > 1e-9 || len(rate.Components) != 3 {
+		t.Errorf("rate = %+v", rate)
+	}
+	if comp := rate.Components[2]; comp.Level != salestax.Special || comp.Name != "CA SPECIAL TAX" {
+		t.Errorf("special component = %+v", comp)
+	}
+
+	if rate, err := c.LookupRate(ctx, "94103"); err != nil || rate.Total() != 0.0725 {
+		t.Errorf("LookupRate of a ZIP code = %+v, %v", rate, err)
+	}
+	_, err = c.LookupRate(ctx, "2 Nowhere Rd, 94103")
+	if !errors.Is(err, salestax.ErrNotFound) || !strings.Contains(err.Error(), "InvalidAddress") {
+		t.Errorf("LookupRate of a bad address: %v", err)
+	}
+	if _, err := c.LookupRate(ctx, "1 Main St"); !errors.Is(err, ratesource.ErrIncompleteAddress) {
+		t.Errorf("LookupRate of an incomplete address: %v", err)
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	bad, _ := New(srv.URL, "acct", "wrong", srv.Client())
+	if _, err := bad.LookupRate(ctx, "94103"); !errors.Is(err, ratesource.ErrUnauthorized) {
+		t.Errorf("LookupRate with bad credentials: %v", err)
+	}
+	if _, err := New("", "acct", "", nil); err == nil {
+		t.Error("New without a license key succeeded")
+	}
+}
@@ -0,0 +1,175 @@
+// Package canada is a rate source for the Canadian sales taxes, from a
+// table of the GST, HST and provincial sales tax rates built into the
+// server.
+//
+// Keys end with the province: a postal code, whose first letter determines
+// it, the two letter code or the English name of the province, optionally
+// followed by ", Canada" and by the category of goods after a "|":
+//
+//	M5V 2T6
+//	100 Queen St W, Toronto, ON M5H 2N2
+//	Quebec, Canada
+//	1 Main St, Vancouver, BC|zero
+//
+// The categories are ratesource.Standard and ratesource.Zero, for zero
+// rated goods like basic groceries.
+package canada
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// ErrNoProvince is returned for an address that does not end with a
+// Canadian province, territory or postal code. It wraps
+// salestax.ErrNotFound.
+var ErrNoProvince = fmt.Errorf("address is not in a Canadian province: %w", salestax.ErrNotFound)
+
+// gst is the federal Goods and Services Tax in percent.
+const gst = 5
+
+// provincialTax is a sales tax levied by a province, in percent.
+type provincialTax struct {
+	name string
+	rate float64
+}
+
+// provinces maps the province and territory codes to their sales tax on
+// top of the GST. The participating provinces levy the HST instead, whose
+// rate includes the GST.
+var provinces = map[string]struct {
+	hst float64
+	pst provincialTax
+}{
+	"AB": {},
+	"BC": {pst: provincialTax{"PST", 7}},
+	"MB": {pst: provincialTax{"RST", 7}},
+	"NB": {hst: 15},
+	"NL": {hst: 15},
+	"NS": {hst: 14},
+	"NT": {},
+	"NU": {},
+	"ON": {hst: 13},
+	"PE": {hst: 15},
+	"QC": {pst: provincialTax{"QST", 9.975}},
+	"SK": {pst: provincialTax{"PST", 6}},
+	"YT": {},
+}
+
+// names maps the English names of the provinces to their codes.
+var names = map[string]string{
+	"ALBERTA":                   "AB",
+	"BRITISH COLUMBIA":          "BC",
+	"MANITOBA":                  "MB",
+	"NEW BRUNSWICK":             "NB",
+	"NEWFOUNDLAND AND LABRADOR": "NL",
+	"NEWFOUNDLAND":              "NL",
+	"NOVA SCOTIA":               "NS",
+	"NORTHWEST TERRITORIES":     "NT",
+	"NUNAVUT":                   "NU",
+	"ONTARIO":                   "ON",
+	"PRINCE EDWARD ISLAND":      "PE",
+	"QUEBEC":                    "QC",
+	"QUÉBEC":                    "QC",
+	"SASKATCHEWAN":              "SK",
+	"YUKON":                     "YT",
+}
+
+// postal maps the first letter of a postal code to its province. X is
+// either, see postalProvince.
+var postal = map[byte]string{
+	'A': "NL", 'B': "NS", 'C': "PE", 'E': "NB", 'G': "QC", 'H': "QC", 'J': "QC",
+	'K': "ON", 'L': "ON", 'M': "ON", 'N': "ON", 'P': "ON", 'R': "MB", 'S': "SK",
+	'T': "AB", 'V': "BC", 'X': "NT", 'Y': "YT",
+}
+
+// Province returns the code of the province or territory key ends with, if
+// any. A category suffix is ignored.
+func Province(key string) (string, bool) {
+	address, _ := ratesource.SplitCategory(key)
+	a := ratesource.ParseAddress(address)
+	if a.Country != "" && a.Country != "CA" {
+		return "", false
+	}
+	parts := strings.Split(address, ",")
+	last := strings.TrimSpace(parts[len(parts)-1])
+	if a.Country == "CA" && len(parts) > 1 {
+		last = strings.TrimSpace(parts[len(parts)-2])
+	}
+	last = strings.ToUpper(last)
+	if code, ok := names[strings.Join(strings.Fields(last), " ")]; ok {
+		return code, true
+	}
+
+	words := strings.Fields(last)
+	// a postal code is written "M5V 2T6" or "M5V2T6"
+	if n := len(words); n >= 2 && isPostalCode(words[n-2]+words[n-1]) {
+		return postalProvince(words[n-2] + words[n-1]), true
+	} else if n >= 1 && isPostalCode(words[n-1]) {
+		return postalProvince(words[n-1]), true
+	} else if n >= 1 {
+		if _, ok := provinces[words[n-1]]; ok {
+			return words[n-1], true
+		}
+	}
+	return "", false
+}
+
+// isPostalCode reports whether s is a postal code like M5V2T6.
+func isPostalCode(s string) bool {
+	if len(s) != 6 {
+		return false
+	}
+	for i := 0; i < 6; i++ {
+		letter := s[i] >= 'A' && s[i] <= 'Z'
+		digit := s[i] >= '0' && s[i] <= '9'
+		if i%2 == 0 && !letter || i%2 == 1 && !digit {
+			return false
+		}
+	}
+	_, ok := postal[s[0]]
+	return ok
+}
+
+// postalProvince returns the province of a postal code. X0A to X0C are in
+// Nunavut, the other X codes in the Northwest Territories.
+func postalProvince(code string) string {
+	if code[0] == 'X' && code[1] == '0' && code[2] >= 'A' && code[2] <= 'C' {
+		return "NU"
+	}
+	return postal[code[0]]
+}
+
+// LookupRate returns the sales tax of the province key ends with. It is a
+// salestax.RateLoaderFuncCtx.
+func LookupRate(_ context.Context, key string) (salestax.TaxRate, error) {
+	code, ok := Province(key)
+	if !ok {
+		return salestax.TaxRate{}, ErrNoProvince
+	}
+	_, category := ratesource.SplitCategory(key)
+	rate := salestax.TaxRate{Country: "CA", Category: category}
+	switch category {
+	case ratesource.Standard:
+	case ratesource.Zero:
+		rate.Components = []salestax.Component{{Level: salestax.National, Name: "GST", Rate: 0}}
+		return rate, nil
+	default:
+		return salestax.TaxRate{}, fmt.Errorf("%s: %w", category, ratesource.ErrUnknownCategory)
+	}
+
+	p := provinces[code]
+	if p.hst != 0 {
+		rate.Components = []salestax.Component{{Level: salestax.National, Code: code, Name: "HST", Rate: p.hst / 100}}
+		return rate, nil
+	}
+	rate.Components = []salestax.Component{{Level: salestax.National, Name: "GST", Rate: gst / 100.0}}
+	if p.pst.rate != 0 {
+		rate.Components = append(rate.Components, salestax.Component{Level: salestax.Province, Code: code, Name: p.pst.name, Rate: p.pst.rate / 100})
+	}
+	return rate, nil
+}
@@ -0,0 +1,76 @@
+package canada
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func TestProvince(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"M5V 2T6", "ON"},
+		{"100 Queen St W, Toronto, ON M5H 2N2", "ON"},
+		{"100 Queen St W, Toronto, ON M5H2N2, Canada", "ON"},
+		{"1 Rue, Montréal, QC|zero", "QC"},
+		{"Québec, Canada", "QC"},
+		{"Halifax, Nova Scotia", "NS"},
+		{"Iqaluit X0A 0H0", "NU"},
+		{"Yellowknife X1A 2L9", "NT"},
+		{"1 Main St, San Francisco, CA 94103", ""},
+		{"Toronto, ON, France", ""},
+		{"Canada", ""},
+		{"D1A 1A1", ""},
+	}
+	for _, tt := range tests {
+		got, ok := Province(tt.key)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("Province(%q) = %q, %v, want %q", tt.key, got, ok, tt.want)
+		}
+	}
+	for code := range provinces {
+		if got, _ := Province("Somewhere, " + code); got != code {
+			t.Errorf("Province of code %s = %q", code, got)
+		}
+	}
+}
+
+func TestLookupRate(t *testing.T) {
+	tests := []struct {
+		key   string
+		rate  float64
+		comps int
+	}{
+		{"Calgary, AB", 0.05, 1},
+		{"Vancouver, BC V6B 1A1", 0.12, 2},
+		{"Toronto, ON", 0.13, 1},
+		{"Montreal, QC", 0.14975, 2},
+		{"Toronto, ON|zero", 0, 1},
+	}
+	for _, tt := range tests {
+		rate, err := LookupRate(context.Background(), tt.key)
+		if err != nil {
+			t.Errorf("LookupRate(%q): %v", tt.key, err)
+			continue
+		}
+		if math.Abs(rate.Total()-tt.rate) > 1e-9 || len(rate.Components) != tt.comps || rate.Country != "CA" {
+			t.Errorf("LookupRate(%q) = %+v, want %v in %d components", tt.key, rate, tt.rate, tt.comps)
+		}
+	}
+
+	rate, _ := LookupRate(context.Background(), "Regina, SK")
+	if c := rate.Components[1]; c.Level != salestax.Province || c.Code != "SK" || c.Name != "PST" {
+		t.Errorf("provincial component = %+v", c)
+	}
+	if _, err := LookupRate(context.Background(), "Toronto, ON|reduced"); !errors.Is(err, ratesource.ErrUnknownCategory) {
+		t.Errorf("unknown category: %v", err)
+	}
+	if _, err := LookupRate(context.Background(), "Dover, DE"); !errors.Is(err, ErrNoProvince) || !errors.Is(err, salestax.ErrNotFound) {
+		t.Errorf("address outside Canada: %v", err)
+	}
+}
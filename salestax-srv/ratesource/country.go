@@ -0,0 +1,90 @@
+package ratesource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Categories of goods with a rate of their own, given after a "|" at the
+// end of a key as built by salestax.CategoryKey, e.g. "FR|reduced". Without
+// one the rate is Standard.
+const (
+	Standard     = "standard"
+	Reduced      = "reduced"  // the lower reduced rate
+	Reduced2     = "reduced2" // the higher one, where there are two
+	SuperReduced = "super-reduced"
+	Parking      = "parking"
+	Zero         = "zero" // zero rated goods, e.g. basic groceries in Canada
+)
+
+// ErrUnknownCategory is returned for a category the country of an address
+// has no rate for. It wraps salestax.ErrNotFound.
+var ErrUnknownCategory = fmt.Errorf("no rate for category: %w", salestax.ErrNotFound)
+
+// SplitCategory splits key into the address and the lower-cased category
+// following its last "|", which is Standard if there is none.
+func SplitCategory(key string) (address, category string) {
+	i := strings.LastIndexByte(key, '|')
+	if i < 0 {
+		return key, Standard
+	}
+	category = strings.ToLower(strings.TrimSpace(key[i+1:]))
+	if category == "" {
+		category = Standard
+	}
+	return key[:i], category
+}
+
+// countries maps the ISO 3166 codes and English names of the countries with
+// a rate source to their code. The codes CA, DE and MT are also US state
+// codes, ending addresses like "Dover, DE", and NL and SK Canadian province
+// codes, so those countries are only recognized by name.
+var countries = func() map[string]string {
+	names := map[string][]string{
+		"US": {"US", "USA", "United States", "United States of America"},
+		"CA": {"Canada"},
+		"AT": {"AT", "Austria"},
+		"BE": {"BE", "Belgium"},
+		"BG": {"BG", "Bulgaria"},
+		"CY": {"CY", "Cyprus"},
+		"CZ": {"CZ", "Czechia", "Czech Republic"},
+		"DE": {"Germany"},
+		"DK": {"DK", "Denmark"},
+		"EE": {"EE", "Estonia"},
+		"ES": {"ES", "Spain"},
+		"FI": {"FI", "Finland"},
+		"FR": {"FR", "France"},
+		"GR": {"GR", "EL", "Greece"},
+		"HR": {"HR", "Croatia"},
+		"HU": {"HU", "Hungary"},
+		"IE": {"IE", "Ireland"},
+		"IT": {"IT", "Italy"},
+		"LT": {"LT", "Lithuania"},
+		"LU": {"LU", "Luxembourg"},
+		"LV": {"LV", "Latvia"},
+		"MT": {"Malta"},
+		"NL": {"Netherlands", "The Netherlands"},
+		"PL": {"PL", "Poland"},
+		"PT": {"PT", "Portugal"},
+		"RO": {"RO", "Romania"},
+		"SE": {"SE", "Sweden"},
+		"SI": {"SI", "Slovenia"},
+		"SK": {"Slovakia"},
+	}
+	m := make(map[string]string)
+	for code, names := range names {
+		for _, name := range names {
+			m[strings.ToUpper(name)] = code
+		}
+	}
+	return m
+}()
+
+// country returns the ISO 3166 code of the country a component of an
+// address names, if it is one with a rate source.
+func country(part string) (string, bool) {
+	code, ok := countries[strings.ToUpper(strings.Join(strings.Fields(part), " "))]
+	return code, ok
+}
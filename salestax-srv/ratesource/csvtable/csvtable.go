@@ -0,0 +1,259 @@
+// Package csvtable is a rate source backed by a ZIP code -> rate table held in
+// memory, e.g. one of the tables state departments of revenue publish, so
+// that salestax-srv can answer lookups without an external rate service.
+//
+// The table is a CSV file with a header row. Columns are matched by name,
+// ignoring case, spaces and punctuation:
+//
+//	zip, zipcode                             the 5 digit ZIP code, required
+//	rate, combinedrate, totalrate,
+//	estimatedcombinedrate                    the combined rate
+//	staterate, countyrate, cityrate,
+//	specialrate (each optionally prefixed
+//	with "estimated")                        the components of the rate
+//	state                                    the state, e.g. CA
+//	taxregionname, region                    used as the name of the city
+//	                                         component
+//
+// At least the combined rate or one component column is required; without
+// a combined rate column the components are summed. Other columns are
+// ignored.
+package csvtable
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Errors returned by Table lookups. Both wrap salestax.ErrNotFound, so the
+// servers report them as unknown addresses.
+var (
+	ErrNoZIP      = fmt.Errorf("no ZIP code in address: %w", salestax.ErrNotFound)
+	ErrUnknownZIP = fmt.Errorf("ZIP code not in rate table: %w", salestax.ErrNotFound)
+)
+
+// Table maps ZIP codes to tax rates. It is safe for concurrent use.
+type Table struct {
+	rates map[string]salestax.TaxRate
+}
+
+// Open reads the table in the CSV file at path.
+func Open(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// columns holds the index of each known column, -1 if absent.
+type columns struct {
+	zip, rate, state, region int
+	components               [4]int // State, County, City, Special
+}
+
+var levels = [4]string{salestax.State, salestax.County, salestax.City, salestax.Special}
+
+// Read reads a table in CSV form from r.
+func Read(r io.Reader) (*Table, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty rate table")
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Table{rates: make(map[string]salestax.TaxRate)}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		zip, rate, err := cols.parse(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		t.rates[zip] = rate
+	}
+}
+
+func parseHeader(header []string) (columns, error) {
+	cols := columns{zip: -1, rate: -1, state: -1, region: -1, components: [4]int{-1, -1, -1, -1}}
+	for i, name := range header {
+		switch name = strings.TrimPrefix(canonicalName(name), "estimated"); name {
+		case "zip", "zipcode":
+			cols.zip = i
+		case "rate", "combinedrate", "totalrate":
+			cols.rate = i
+		case "state":
+			cols.state = i
+		case "taxregionname", "region", "regionname":
+			cols.region = i
+		case "staterate":
+			cols.components[0] = i
+		case "countyrate":
+			cols.components[1] = i
+		case "cityrate":
+			cols.components[2] = i
+		case "specialrate":
+			cols.components[3] = i
+		}
+	}
+	if cols.zip < 0 {
+		return cols, errors.New("rate table has no zip column")
+	}
+	if cols.rate < 0 && cols.components == [4]int{-1, -1, -1, -1} {
+		return cols, errors.New("rate table has neither a rate nor a component rate column")
+	}
+	return cols, nil
+}
+
+// canonicalName lower-cases a column name and drops everything but letters
+// and digits, so "Zip Code", "zip_code" and "ZipCode" all read zipcode.
+func canonicalName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// parse returns the ZIP code and rate of a table row.
+func (cols columns) parse(record []string) (string, salestax.TaxRate, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	zip := field(cols.zip)
+	if !isZIP(zip) {
+		// spreadsheets drop the leading zeros of New England ZIP codes
+		if n, err := strconv.Atoi(zip); err == nil && n > 0 && n < 10000 {
+			zip = fmt.Sprintf("%05d", n)
+		} else {
+			return "", salestax.TaxRate{}, fmt.Errorf("invalid ZIP code %q", zip)
+		}
+	}
+
+	var rate salestax.TaxRate
+	for c, i := range cols.components {
+		if field(i) == "" {
+			continue
+		}
+		r, err := strconv.ParseFloat(field(i), 64)
+		if err != nil {
+			return "", salestax.TaxRate{}, fmt.Errorf("%s rate: %w", levels[c], err)
+		}
+		if r == 0 {
+			continue
+		}
+		comp := salestax.Component{Level: levels[c], Rate: r}
+		switch levels[c] {
+		case salestax.State:
+			comp.Code = field(cols.state)
+		case salestax.City:
+			comp.Name = field(cols.region)
+		}
+		rate.Components = append(rate.Components, comp)
+	}
+	if cols.rate >= 0 {
+		total, err := strconv.ParseFloat(field(cols.rate), 64)
+		if err != nil {
+			return "", salestax.TaxRate{}, fmt.Errorf("rate: %w", err)
+		}
+		if len(rate.Components) == 0 {
+			rate = salestax.Flat(total)
+		} else if diff := total - rate.Total(); diff > 1e-6 || diff < -1e-6 {
+			// published tables round, keep the combined rate they state
+			rate.Components = append(rate.Components, salestax.Component{Rate: math.Round(diff*1e9) / 1e9})
+		}
+	}
+	if len(rate.Components) == 0 {
+		rate = salestax.Flat(0)
+	}
+	return zip, rate, nil
+}
+
+// Len returns the number of ZIP codes in the table.
+func (t *Table) Len() int {
+	return len(t.rates)
+}
+
+// Lookup returns the combined rate of the ZIP code that key is or ends
+// with, e.g. "94103", "94103-1234" or "1 Main St, San Francisco, CA 94103".
+// It is a salestax.LoaderFunc.
+func (t *Table) Lookup(key string) (float64, error) {
+	rate, err := t.LookupRate(context.Background(), key)
+	if err != nil {
+		return 0, err
+	}
+	return rate.Total(), nil
+}
+
+// LookupRate is Lookup returning the breakdown of the rate. It is a
+// salestax.RateLoaderFuncCtx; ctx is not used.
+func (t *Table) LookupRate(_ context.Context, key string) (salestax.TaxRate, error) {
+	zip, ok := findZIP(key)
+	if !ok {
+		return salestax.TaxRate{}, ErrNoZIP
+	}
+	rate, ok := t.rates[zip]
+	if !ok {
+		return salestax.TaxRate{}, ErrUnknownZIP
+	}
+	return rate, nil
+}
+
+// findZIP returns the last ZIP or ZIP+4 code in key, without the +4.
+func findZIP(key string) (string, bool) {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	for i := len(words) - 1; i >= 0; i-- {
+		zip, _, _ := strings.Cut(words[i], "-")
+		if isZIP(zip) {
+			return zip, true
+		}
+	}
+	return "", false
+}
+
+func isZIP(s string) bool {
+	if len(s) != 5 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package csvtable
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// an excerpt in the layout of a state DOR table
+const table = `State,ZipCode,TaxRegionName,EstimatedCombinedRate,StateRate,EstimatedCountyRate,EstimatedCityRate,EstimatedSpecialRate,RiskLevel
+CA,94103,SAN FRANCISCO,0.08625,0.06,0.0025,0,0.02375,1
+CA,90210,BEVERLY HILLS,0.1025,0.06,0.0025,0.0025,0.0375,1
+MA,2108,BOSTON,0.0625,0.0625,0,0,0,1
+`
+
+func TestRead(t *testing.T) {
+	tbl, err := Read(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", tbl.Len())
+	}
+
+	tests := []struct {
+		key   string
+		total float64
+		comps int
+	}{
+		{"94103", 0.08625, 3},
+		{"90210-1234", 0.1025, 4},
+		{"1 Main St, Boston, MA 02108", 0.0625, 1},
+		{"9400 Hwy 1, CA 94103, USA", 0.08625, 3},
+	}
+	for _, tt := range tests {
+		rate, err := tbl.LookupRate(context.Background(), tt.key)
+		if err != nil {
+			t.Errorf("LookupRate(%q): %v", tt.key, err)
+			continue
+		}
+		if math.Abs(rate.Total()-tt.total) > 1e-9 || len(rate.Components) != tt.comps {
+			t.Errorf("LookupRate(%q) = %+v, want total %v in %d components", tt.key, rate, tt.total, tt.comps)
+		}
+		if total, _ := tbl.Lookup(tt.key); total != rate.Total() {
+			t.Errorf("Lookup(%q) = %v, want %v", tt.key, total, rate.Total())
+		}
+	}
+
+	rate, _ := tbl.LookupRate(context.Background(), "94103")
+	if c := rate.Components[0]; c.Level != salestax.State || c.Code != "CA" {
+		t.Errorf("state component = %+v", c)
+	}
+	rate, _ = tbl.LookupRate(context.Background(), "90210")
+	if c := rate.Components[2]; c.Level != salestax.City || c.Name != "BEVERLY HILLS" {
+		t.Errorf("city component = %+v", c)
+	}
+
+	if _, err := tbl.Lookup("1 Main St"); !errors.Is(err, ErrNoZIP) || !errors.Is(err, salestax.ErrNotFound) {
+		t.Errorf("Lookup without ZIP: %v", err)
+	}
+	if _, err := tbl.Lookup("10001"); !errors.Is(err, ErrUnknownZIP) || !errors.Is(err, salestax.ErrNotFound) {
+		t.Errorf("Lookup of unknown ZIP: %v", err)
+	}
+}
+
+func TestReadLayouts(t *testing.T) {
+	tests := []struct {
+		name, csv string
+		total     float64
+		comps     int
+	}{
+		{"combined only", "zip,rate\n94103,0.08625\n", 0.08625, 1},
+		{"components only", "zip_code,state_rate,city_rate\n94103,0.06,0.02625\n", 0.08625, 2},
+		{"rounded total", "zip,rate,state rate\n94103,0.0863,0.06\n", 0.0863, 2},
+		{"zero rate", "zip,rate\n97201,0\n", 0, 1},
+	}
+	for _, tt := range tests {
+		tbl, err := Read(strings.NewReader(tt.csv))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		rate, err := tbl.LookupRate(context.Background(), tt.csv[strings.IndexByte(tt.csv, '\n')+1:][:5])
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if math.Abs(rate.Total()-tt.total) > 1e-9 || len(rate.Components) != tt.comps {
+			t.Errorf("%s: rate = %+v, want total %v in %d components", tt.name, rate, tt.total, tt.comps)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, csv := range []string{
+		"",
+		"rate\n0.07\n",
+		"zip,region\n94103,SF\n",
+		"zip,rate\n9410x,0.07\n",
+		"zip,rate\n94103,seven\n",
+		"zip,rate\n94103,\"0.07\n",
+	} {
+		if _, err := Read(strings.NewReader(csv)); err == nil {
+			t.Errorf("Read(%q) succeeded", csv)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	if err := os.WriteFile(path, []byte(table), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := Open(path)
+	if err != nil || tbl.Len() != 3 {
+		t.Fatalf("Open = %v, %v", tbl, err)
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("Open of a missing file succeeded")
+	}
+}
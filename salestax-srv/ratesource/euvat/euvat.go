@@ -0,0 +1,53 @@
+// Package euvat is a rate source for the VAT of the EU member states, from
+// a table of their standard, reduced, super-reduced and parking rates built
+// into the server.
+//
+// Keys name the country after the last comma, by ISO 3166 code or English
+// name (see ratesource.ParseAddress), optionally followed by the category
+// of goods after a "|":
+//
+//	FR
+//	4 Rue de Rivoli, 75004 Paris, France|reduced
+//	Valletta, Malta|super-reduced
+//
+// VAT is levied at the same rates throughout a member state, so the rest of
+// the address is not used.
+package euvat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// ErrNotEU is returned for an address that does not end with an EU member
+// state. It wraps salestax.ErrNotFound.
+var ErrNotEU = fmt.Errorf("address is not in an EU member state: %w", salestax.ErrNotFound)
+
+// Member reports whether country, an ISO 3166 code, is an EU member state.
+func Member(country string) bool {
+	_, ok := rates[country]
+	return ok
+}
+
+// LookupRate returns the VAT rate of the category of goods named by key. It
+// is a salestax.RateLoaderFuncCtx.
+func LookupRate(_ context.Context, key string) (salestax.TaxRate, error) {
+	address, category := ratesource.SplitCategory(key)
+	country := ratesource.ParseAddress(address).Country
+	r, ok := rates[country]
+	if !ok {
+		return salestax.TaxRate{}, ErrNotEU
+	}
+	percent, ok := r.rate(category)
+	if !ok {
+		return salestax.TaxRate{}, fmt.Errorf("%s %s: %w", country, category, ratesource.ErrUnknownCategory)
+	}
+	return salestax.TaxRate{
+		Country:    country,
+		Category:   category,
+		Components: []salestax.Component{{Level: salestax.National, Code: country, Name: "VAT", Rate: percent / 100}},
+	}, nil
+}
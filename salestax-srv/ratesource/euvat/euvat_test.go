@@ -0,0 +1,68 @@
+package euvat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func TestLookupRate(t *testing.T) {
+	tests := []struct {
+		key      string
+		country  string
+		category string
+		rate     float64
+	}{
+		{"FR", "FR", ratesource.Standard, 0.20},
+		{"4 Rue de Rivoli, 75004 Paris, France|reduced", "FR", ratesource.Reduced, 0.055},
+		{"4 Rue de Rivoli, 75004 Paris, France|reduced2", "FR", ratesource.Reduced2, 0.10},
+		{"Berlin, Germany", "DE", ratesource.Standard, 0.19},
+		{"Athens, EL|super-reduced", "", "", 0},
+		{"Dublin, IE|super-reduced", "IE", ratesource.SuperReduced, 0.048},
+		{"Valletta, Malta|zero", "MT", ratesource.Zero, 0},
+		{"fi", "FI", ratesource.Standard, 0.255},
+	}
+	for _, tt := range tests {
+		rate, err := LookupRate(context.Background(), tt.key)
+		if tt.country == "" {
+			if !errors.Is(err, ratesource.ErrUnknownCategory) {
+				t.Errorf("LookupRate(%q): %v, want ErrUnknownCategory", tt.key, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("LookupRate(%q): %v", tt.key, err)
+			continue
+		}
+		if rate.Country != tt.country || rate.Category != tt.category || rate.Total() != tt.rate {
+			t.Errorf("LookupRate(%q) = %+v, want %s %s %v", tt.key, rate, tt.country, tt.category, tt.rate)
+		}
+		if c := rate.Components[0]; c.Level != salestax.National || c.Name != "VAT" {
+			t.Errorf("LookupRate(%q) component = %+v", tt.key, c)
+		}
+	}
+
+	for _, key := range []string{"1 Main St, Dover, DE 19901", "Oslo, Norway", ""} {
+		if _, err := LookupRate(context.Background(), key); !errors.Is(err, ErrNotEU) || !errors.Is(err, salestax.ErrNotFound) {
+			t.Errorf("LookupRate(%q): %v, want ErrNotEU", key, err)
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	if len(rates) != 27 {
+		t.Errorf("%d member states, want 27", len(rates))
+	}
+	for country, r := range rates {
+		if !slices.IsSorted(r.reduced) || len(r.reduced) > 0 && r.reduced[len(r.reduced)-1] >= r.standard {
+			t.Errorf("%s: reduced rates %v not ascending below %v", country, r.reduced, r.standard)
+		}
+		if (r.superReduced != 0) && len(r.reduced) > 0 && r.superReduced >= r.reduced[0] {
+			t.Errorf("%s: super-reduced rate %v not below %v", country, r.superReduced, r.reduced[0])
+		}
+	}
+}
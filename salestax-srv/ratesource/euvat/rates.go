@@ -0,0 +1,72 @@
+package euvat
+
+import "github.com/jared-d-smith/psl/salestax-srv/ratesource"
+
+// vatRates are the VAT rates of a member state in percent. A zero
+// superReduced or parking rate means the state has none.
+type vatRates struct {
+	standard     float64
+	reduced      []float64 // ascending
+	superReduced float64
+	parking      float64
+}
+
+// rate returns the rate of category, if the state has one.
+func (r vatRates) rate(category string) (float64, bool) {
+	switch category {
+	case ratesource.Standard:
+		return r.standard, true
+	case ratesource.Reduced:
+		return r.nth(0)
+	case ratesource.Reduced2:
+		return r.nth(1)
+	case ratesource.SuperReduced:
+		return r.superReduced, r.superReduced != 0
+	case ratesource.Parking:
+		return r.parking, r.parking != 0
+	case ratesource.Zero:
+		return 0, true
+	}
+	return 0, false
+}
+
+func (r vatRates) nth(i int) (float64, bool) {
+	if i >= len(r.reduced) {
+		return 0, false
+	}
+	return r.reduced[i], true
+}
+
+// rates are the VAT rates of the member states, by ISO 3166 code, as listed
+// by the European Commission's VAT rates database for 2025 and updated with
+// the changes in effect since: Estonia (24% from July 2025) and Romania (21%
+// and 11% from August 2025).
+var rates = map[string]vatRates{
+	"AT": {20, []float64{10, 13}, 0, 13},
+	"BE": {21, []float64{6, 12}, 0, 12},
+	"BG": {20, []float64{9}, 0, 0},
+	"CY": {19, []float64{5, 9}, 3, 0},
+	"CZ": {21, []float64{12}, 0, 0},
+	"DE": {19, []float64{7}, 0, 0},
+	"DK": {25, nil, 0, 0},
+	"EE": {24, []float64{9, 13}, 0, 0},
+	"ES": {21, []float64{10}, 4, 0},
+	"FI": {25.5, []float64{10, 14}, 0, 0},
+	"FR": {20, []float64{5.5, 10}, 2.1, 0},
+	"GR": {24, []float64{6, 13}, 0, 0},
+	"HR": {25, []float64{5, 13}, 0, 0},
+	"HU": {27, []float64{5, 18}, 0, 0},
+	"IE": {23, []float64{9, 13.5}, 4.8, 13.5},
+	"IT": {22, []float64{5, 10}, 4, 0},
+	"LT": {21, []float64{5, 9}, 0, 0},
+	"LU": {17, []float64{8}, 3, 14},
+	"LV": {21, []float64{5, 12}, 0, 0},
+	"MT": {18, []float64{5, 7}, 0, 0},
+	"NL": {21, []float64{9}, 0, 0},
+	"PL": {23, []float64{5, 8}, 0, 0},
+	"PT": {23, []float64{6, 13}, 0, 13},
+	"RO": {21, []float64{11}, 0, 0},
+	"SE": {25, []float64{6, 12}, 0, 0},
+	"SI": {22, []float64{5, 9.5}, 0, 0},
+	"SK": {23, []float64{5, 19}, 0, 0},
+}
@@ -0,0 +1,225 @@
+// Package ratesource holds what the rate API adapters in its subpackages
+// share: splitting the free-form address keys of the cache into the fields
+// the APIs ask for, mapping their error responses onto the cache's errors,
+// and backing off when they rate limit us.
+package ratesource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Errors of the rate API adapters.
+var (
+	// ErrIncompleteAddress is returned for an address lacking the fields
+	// the API needs, e.g. a ZIP code. It wraps salestax.ErrNotFound.
+	ErrIncompleteAddress = fmt.Errorf("incomplete address: %w", salestax.ErrNotFound)
+	// ErrUnauthorized is wrapped by an APIError for a 401 or 403 response.
+	ErrUnauthorized = errors.New("rate API rejected the credentials")
+	// ErrRateLimited is wrapped by an APIError for a 429 response, and
+	// returned without calling the API until its Retry-After has passed.
+	ErrRateLimited = errors.New("rate API rate limit exceeded")
+)
+
+// Address is a street address split into its fields. Any of them may be
+// empty.
+type Address struct {
+	Street  string
+	City    string
+	State   string // two letter code
+	ZIP     string // 5 digit or ZIP+4 code
+	Country string // ISO 3166 code, if the address ends with one
+}
+
+// ParseAddress splits key into an Address. It understands the forms
+//
+//	1 Main St, San Francisco, CA 94103
+//	1 Main St, San Francisco CA 94103-1234
+//	1 MAIN ST SAN FRANCISCO CA 94103 (as normalized by addrnorm)
+//	1 Main St, 94103
+//	94103
+//
+// optionally followed by the name or code of a country with a rate source,
+// e.g. "..., USA" or "4 Rue de Rivoli, 75004 Paris, France". Without commas
+// everything before the state and ZIP code is taken as the street.
+func ParseAddress(key string) Address {
+	var parts []string
+	for _, p := range strings.Split(key, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	var a Address
+	if n := len(parts); n > 0 {
+		if code, ok := country(parts[n-1]); ok {
+			a.Country, parts = code, parts[:n-1]
+		}
+	}
+	if len(parts) == 0 {
+		return a
+	}
+
+	last := strings.Fields(parts[len(parts)-1])
+	if n := len(last); n > 0 && isZIP(last[n-1]) {
+		a.ZIP, last = last[n-1], last[:n-1]
+	}
+	// "St" and the like end streets too, so a state is only taken in front
+	// of a ZIP code or as a part of its own
+	if n := len(last); n > 0 && isState(last[n-1]) && (a.ZIP != "" || n == 1 && len(parts) > 1) {
+		a.State, last = strings.ToUpper(last[n-1]), last[:n-1]
+	}
+	rest := strings.Join(last, " ")
+	parts = parts[:len(parts)-1]
+	switch {
+	case len(parts) == 0:
+		a.Street = rest
+	case rest != "":
+		a.Street, a.City = strings.Join(parts, ", "), rest
+	case len(parts) == 1:
+		a.Street = parts[0]
+	default:
+		a.Street, a.City = strings.Join(parts[:len(parts)-1], ", "), parts[len(parts)-1]
+	}
+	return a
+}
+
+// isZIP reports whether s is a 5 digit or ZIP+4 code.
+func isZIP(s string) bool {
+	zip, plus4, hasPlus4 := strings.Cut(s, "-")
+	return len(zip) == 5 && digits(zip) && (!hasPlus4 || len(plus4) == 4 && digits(plus4))
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isState(s string) bool {
+	return len(s) == 2 && unicode.IsLetter(rune(s[0])) && unicode.IsLetter(rune(s[1]))
+}
+
+// Level maps the jurisdiction type reported by an API, e.g. "State" or
+// "COUNTY", to a salestax Component level, or "" if it is not one of them.
+func Level(kind string) string {
+	switch kind = strings.ToLower(kind); kind {
+	case salestax.State, salestax.County, salestax.City, salestax.Special, salestax.National, salestax.Province:
+		return kind
+	}
+	return ""
+}
+
+// APIError is an error response of a rate API. It wraps
+// salestax.ErrNotFound for a 400, 404 or 422 response, which the APIs
+// return for addresses they can't resolve, ErrUnauthorized for 401 and 403
+// and ErrRateLimited for 429.
+type APIError struct {
+	API        string // e.g. "avalara"
+	StatusCode int
+	Status     string
+	Message    string        // as reported by the API, if any
+	RetryAfter time.Duration // of a 429 response, if given
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return e.API + " returned " + e.Status
+	}
+	return e.API + " returned " + e.Status + ": " + e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+		return salestax.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Temporary reports whether the request may succeed if retried, i.e. for
+// 429 and server errors.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// defaultRetryAfter is how long a client backs off after a 429 response
+// without a Retry-After header.
+const defaultRetryAfter = time.Second
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// Client sends requests to a rate API and decodes the JSON responses. It
+// is safe for concurrent use.
+type Client struct {
+	API  string // name used in errors
+	HTTP *http.Client
+	// Message extracts the error message from the body of an error
+	// response. If it is nil no message is reported.
+	Message func(body []byte) string
+
+	// backoff is the Unix nano time until which requests are not sent
+	// after a 429 response.
+	backoff atomic.Int64
+}
+
+// GetJSON sends req and decodes the JSON body of a 200 response into v.
+// Other responses are returned as an *APIError. After a 429 response
+// requests fail with ErrRateLimited without being sent until the
+// Retry-After of the response has passed.
+func (c *Client) GetJSON(req *http.Request, v any) error {
+	if wait := time.Until(time.Unix(0, c.backoff.Load())); wait > 0 {
+		return fmt.Errorf("%s: %w, retry in %v", c.API, ErrRateLimited, wait.Round(time.Millisecond))
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return fmt.Errorf("%s: decoding response: %w", c.API, err)
+		}
+		return nil
+	}
+
+	apiErr := &APIError{API: c.API, StatusCode: resp.StatusCode, Status: resp.Status}
+	if body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody)); err == nil && c.Message != nil {
+		apiErr.Message = c.Message(body)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		apiErr.RetryAfter = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		c.backoff.Store(time.Now().Add(apiErr.RetryAfter).UnixNano())
+	}
+	return apiErr
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP
+// date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return defaultRetryAfter
+}
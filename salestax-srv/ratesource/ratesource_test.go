@@ -0,0 +1,115 @@
+package ratesource
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		key  string
+		want Address
+	}{
+		{"1 Main St, San Francisco, CA 94103", Address{"1 Main St", "San Francisco", "CA", "94103", ""}},
+		{"1 Main St, Apt 2, San Francisco, CA 94103", Address{"1 Main St, Apt 2", "San Francisco", "CA", "94103", ""}},
+		{"1 Main St, San Francisco ca 94103-1234", Address{"1 Main St", "San Francisco", "CA", "94103-1234", ""}},
+		{"1 MAIN ST SAN FRANCISCO CA 94103", Address{"1 MAIN ST SAN FRANCISCO", "", "CA", "94103", ""}},
+		{"1 Main St, San Francisco, CA", Address{"1 Main St", "San Francisco", "CA", "", ""}},
+		{"1 Main St, 94103", Address{"1 Main St", "", "", "94103", ""}},
+		{"1 Main St", Address{"1 Main St", "", "", "", ""}},
+		{"94103", Address{"", "", "", "94103", ""}},
+		{"1 Main St, San Francisco, CA 94103, USA", Address{"1 Main St", "San Francisco", "CA", "94103", "US"}},
+		{"4 Rue de Rivoli, 75004 Paris, France", Address{"4 Rue de Rivoli", "75004 Paris", "", "", "FR"}},
+		{"Dover, DE", Address{"Dover", "", "DE", "", ""}},
+		{"fr", Address{Country: "FR"}},
+		{" , ", Address{}},
+	}
+	for _, tt := range tests {
+		if got := ParseAddress(tt.key); got != tt.want {
+			t.Errorf("ParseAddress(%q) = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCategory(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"FR":            {"FR", Standard},
+		"FR|Reduced":    {"FR", Reduced},
+		"Paris, FR | ":  {"Paris, FR ", Standard},
+		"ON|zero":       {"ON", Zero},
+		"1 Main St|x|y": {"1 Main St|x", "y"},
+	} {
+		if addr, cat := SplitCategory(key); addr != want[0] || cat != want[1] {
+			t.Errorf("SplitCategory(%q) = %q, %q, want %q, %q", key, addr, cat, want[0], want[1])
+		}
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		code      int
+		target    error
+		temporary bool
+	}{
+		{http.StatusNotFound, salestax.ErrNotFound, false},
+		{http.StatusBadRequest, salestax.ErrNotFound, false},
+		{http.StatusUnauthorized, ErrUnauthorized, false},
+		{http.StatusTooManyRequests, ErrRateLimited, true},
+		{http.StatusBadGateway, nil, true},
+	}
+	for _, tt := range tests {
+		err := &APIError{API: "test", StatusCode: tt.code, Status: http.StatusText(tt.code)}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("%d: %v is not %v", tt.code, err, tt.target)
+		}
+		if err.Temporary() != tt.temporary {
+			t.Errorf("%d: Temporary() = %v", tt.code, err.Temporary())
+		}
+	}
+}
+
+func TestClientBacksOff(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`slow down`))
+	}))
+	defer srv.Close()
+
+	c := &Client{API: "test", HTTP: srv.Client(), Message: func(body []byte) string { return string(body) }}
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		err := c.GetJSON(req, new(any))
+		if !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("request %d: %v, want ErrRateLimited", i, err)
+		}
+		var ae *APIError
+		if i == 0 && (!errors.As(err, &ae) || ae.RetryAfter != time.Minute || ae.Message != "slow down") {
+			t.Errorf("first error = %#v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("API called %d times while backing off", calls)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for header, want := range map[string]time.Duration{
+		"5":                             5 * time.Second,
+		"Thu, 01 Jan 2026 00:00:30 GMT": 30 * time.Second,
+		"":                              defaultRetryAfter,
+		"soon":                          defaultRetryAfter,
+	} {
+		if got := retryAfter(header, now); got != want {
+			t.Errorf("retryAfter(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
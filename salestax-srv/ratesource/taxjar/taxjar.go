@@ -0,0 +1,129 @@
+// Package taxjar is a rate source backed by the TaxJar rates API,
+// /v2/rates/<zip>. The street, city and state of an address, when known,
+// refine the lookup within the ZIP code.
+package taxjar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Base URLs of the TaxJar API.
+const (
+	DefaultURL = "https://api.taxjar.com"
+	SandboxURL = "https://api.sandbox.taxjar.com"
+)
+
+// Client looks rates up with the TaxJar API. It is safe for concurrent use.
+type Client struct {
+	base  string
+	token string
+	api   ratesource.Client
+}
+
+// New returns a client authenticating with an API token. An empty base
+// means DefaultURL. client is used for all requests; its Timeout bounds
+// each of them.
+func New(base, token string, client *http.Client) (*Client, error) {
+	if token == "" {
+		return nil, errors.New("taxjar: API token is required")
+	}
+	if base == "" {
+		base = DefaultURL
+	}
+	c := &Client{base: strings.TrimSuffix(base, "/"), token: token}
+	c.api.API, c.api.HTTP, c.api.Message = "taxjar", client, errorMessage
+	return c, nil
+}
+
+// number is a rate, which the API sends as a string, e.g. "0.0625", or for
+// some states as a number.
+type number float64
+
+func (n *number) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	*n = number(f)
+	return err
+}
+
+// rateResponse is the body of a /v2/rates response.
+type rateResponse struct {
+	Rate struct {
+		State        string `json:"state"`
+		StateRate    number `json:"state_rate"`
+		County       string `json:"county"`
+		CountyRate   number `json:"county_rate"`
+		City         string `json:"city"`
+		CityRate     number `json:"city_rate"`
+		DistrictRate number `json:"combined_district_rate"`
+		CombinedRate number `json:"combined_rate"`
+	} `json:"rate"`
+}
+
+// LookupRate returns the rate of address, parsed with
+// ratesource.ParseAddress. Addresses without a ZIP code fail with
+// ratesource.ErrIncompleteAddress. It is a salestax.RateLoaderFuncCtx.
+func (c *Client) LookupRate(ctx context.Context, address string) (salestax.TaxRate, error) {
+	a := ratesource.ParseAddress(address)
+	if a.ZIP == "" {
+		return salestax.TaxRate{}, ratesource.ErrIncompleteAddress
+	}
+	q := url.Values{"country": {"US"}}
+	for name, value := range map[string]string{"street": a.Street, "city": a.City, "state": a.State} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/v2/rates/"+url.PathEscape(a.ZIP)+"?"+q.Encode(), nil)
+	if err != nil {
+		return salestax.TaxRate{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	var resp rateResponse
+	if err := c.api.GetJSON(req, &resp); err != nil {
+		return salestax.TaxRate{}, err
+	}
+
+	r := resp.Rate
+	var rate salestax.TaxRate
+	for _, comp := range []salestax.Component{
+		{Level: salestax.State, Code: r.State, Rate: float64(r.StateRate)},
+		{Level: salestax.County, Name: r.County, Rate: float64(r.CountyRate)},
+		{Level: salestax.City, Name: r.City, Rate: float64(r.CityRate)},
+		{Level: salestax.Special, Rate: float64(r.DistrictRate)},
+	} {
+		if comp.Rate != 0 {
+			rate.Components = append(rate.Components, comp)
+		}
+	}
+	if len(rate.Components) == 0 {
+		rate = salestax.Flat(float64(r.CombinedRate))
+	}
+	return rate, nil
+}
+
+// errorMessage returns the detail of a TaxJar error response, e.g.
+//
+//	{"error": "Not Found", "detail": "Resource can not be found", "status": 404}
+func errorMessage(body []byte) string {
+	var resp struct {
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	return resp.Detail
+}
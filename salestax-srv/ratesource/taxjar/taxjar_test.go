@@ -0,0 +1,68 @@
+package taxjar
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/ratesource"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+func TestLookupRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "Unauthorized", "detail": "Not authorized for route", "status": 401}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/rates/90002":
+			if r.URL.Query().Get("street") != "1 Main St" {
+				t.Errorf("query = %v", r.URL.Query())
+			}
+			w.Write([]byte(`{"rate": {"zip": "90002", "state": "CA", "state_rate": "0.0625",
+				"county": "LOS ANGELES", "county_rate": "0.01", "city": "WATTS", "city_rate": "0.0",
+				"combined_district_rate": "0.025", "combined_rate": "0.0975"}}`))
+		case "/v2/rates/97201":
+			w.Write([]byte(`{"rate": {"zip": "97201", "state": "OR", "state_rate": 0, "combined_rate": 0}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Not Found", "detail": "Resource can not be found", "status": 404}`))
+		}
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL, "token", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	rate, err := c.LookupRate(ctx, "1 Main St, Los Angeles, CA 90002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(rate.Total()-0.0975) > 1e-9 || len(rate.Components) != 3 {
+		t.Errorf("rate = %+v", rate)
+	}
+	if comp := rate.Components[0]; comp.Level != salestax.State || comp.Code != "CA" {
+		t.Errorf("state component = %+v", comp)
+	}
+	if rate, err := c.LookupRate(ctx, "97201"); err != nil || rate.Total() != 0 {
+		t.Errorf("LookupRate of a tax free ZIP code = %+v, %v", rate, err)
+	}
+	if _, err := c.LookupRate(ctx, "00000"); !errors.Is(err, salestax.ErrNotFound) {
+		t.Errorf("LookupRate of an unknown ZIP code: %v", err)
+	}
+	if _, err := c.LookupRate(ctx, "1 Main St"); !errors.Is(err, ratesource.ErrIncompleteAddress) {
+		t.Errorf("LookupRate without a ZIP code: %v", err)
+	}
+
+	bad, _ := New(srv.URL, "wrong", srv.Client())
+	if _, err := bad.LookupRate(ctx, "90002"); !errors.Is(err, ratesource.ErrUnauthorized) {
+		t.Errorf("LookupRate with a bad token: %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+// Package replica keeps the cache of a read-only replica of a salestax-srv
+// primary, so that instances added for peak traffic, e.g. that of the
+// holidays, serve the rates of the primary instead of loading them from the
+// tax backend.
+//
+// A Replica pulls the snapshot of the primary, GET /admin/snapshot, every
+// interval and loads it into its cache. With WithWatch it also follows the
+// change stream of the primary, GET /watch, applying the rates written and
+// the invalidations as they happen; without it, they reach the replica with
+// the next snapshot, and rates removed on the primary stay on the replica
+// until they expire. A rate written while a snapshot is loaded may be set
+// back to its former value until the next one.
+//
+// The server of the replica is made read-only, see
+// httpserver.Server.SetReadOnly, and loads its misses from the primary,
+// see Loader.
+package replica
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/client"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// DefaultInterval is how often a Replica pulls the snapshot of the primary
+// unless changed with WithInterval.
+const DefaultInterval = time.Minute
+
+// reconnectDelay is how long a Replica waits before following the change
+// stream again once it broke.
+const reconnectDelay = time.Second
+
+// Replica keeps a cache a copy of that of a primary.
+type Replica struct {
+	primary  string
+	cache    *salestax.RateCache
+	http     *http.Client
+	apiKey   string
+	interval time.Duration
+	watch    bool
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	synced time.Time // of the last snapshot loaded
+	last   uint64    // ID of the last event applied
+}
+
+// Option configures a Replica.
+type Option func(*Replica)
+
+// WithInterval sets how often the snapshot of the primary is pulled, by
+// default DefaultInterval.
+func WithInterval(d time.Duration) Option {
+	return func(r *Replica) {
+		r.interval = d
+	}
+}
+
+// WithAPIKey sends key with the requests to the primary, the admin token or
+// the API key of an admin client of the primary.
+func WithAPIKey(key string) Option {
+	return func(r *Replica) {
+		r.apiKey = key
+	}
+}
+
+// WithHTTPClient makes the requests to the primary with c instead of
+// http.DefaultClient, e.g. to trust its certificate. c must not time out
+// the change stream, which lasts as long as the replica follows it.
+func WithHTTPClient(c *http.Client) Option {
+	return func(r *Replica) {
+		r.http = c
+	}
+}
+
+// WithWatch follows the change stream of the primary between snapshots,
+// which requires the primary to have watch enabled.
+func WithWatch() Option {
+	return func(r *Replica) {
+		r.watch = true
+	}
+}
+
+// WithLogger logs the failures to reach the primary to logger instead of
+// slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Replica) {
+		r.logger = logger
+	}
+}
+
+// New returns a Replica keeping cache a copy of that of the primary at the
+// base URL primary, e.g. "http://salestax-primary:8080".
+func New(primary string, cache *salestax.RateCache, opts ...Option) *Replica {
+	r := &Replica{
+		primary:  strings.TrimSuffix(primary, "/"),
+		cache:    cache,
+		http:     http.DefaultClient,
+		interval: DefaultInterval,
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Loader returns a loader looking the misses of a replica up on the primary
+// at the base URL primary, with the API key apiKey if not empty, so that
+// they are loaded once for the whole cluster, by the primary.
+func Loader(primary, apiKey string, opts ...client.Option) (salestax.RateLoaderFuncCtx, error) {
+	if apiKey != "" {
+		opts = append(opts, client.WithAPIKey(apiKey))
+	}
+	c, err := client.New([]string{primary}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetRate, nil
+}
+
+// Synced returns when the last snapshot was loaded, the zero time if none
+// was yet.
+func (r *Replica) Synced() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.synced
+}
+
+// Check fails until a snapshot was loaded, and once the last one is older
+// than three intervals, for the readiness checks of the replica.
+func (r *Replica) Check(context.Context) error {
+	synced := r.Synced()
+	switch {
+	case synced.IsZero():
+		return errors.New("no snapshot of the primary loaded yet")
+	case time.Since(synced) > 3*r.interval:
+		return fmt.Errorf("last snapshot of the primary loaded %s ago", time.Since(synced).Round(time.Second))
+	}
+	return nil
+}
+
+// Sync pulls the snapshot of the primary and loads it into the cache.
+func (r *Replica) Sync(ctx context.Context) error {
+	resp, err := r.get(ctx, "/admin/snapshot", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := r.cache.LoadSnapshot(resp.Body); err != nil {
+		return fmt.Errorf("loading the snapshot of %s: %w", r.primary, err)
+	}
+	r.mu.Lock()
+	r.synced = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// Run keeps the cache a copy of that of the primary until ctx is done.
+// Failures to reach the primary are logged and retried.
+func (r *Replica) Run(ctx context.Context) {
+	resync := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	if r.watch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.follow(ctx, resync)
+		}()
+	}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Sync(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Warn("pulling the snapshot of the primary", "primary", r.primary, "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-resync:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// follow applies the change stream of the primary until ctx is done,
+// reconnecting after the last event applied whenever the stream breaks. It
+// asks Run for a snapshot on resync whenever events were missed.
+func (r *Replica) follow(ctx context.Context, resync chan<- struct{}) {
+	for {
+		err := r.stream(ctx, func(e watch.Event) {
+			if !r.apply(e) {
+				select {
+				case resync <- struct{}{}:
+				default:
+				}
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		r.logger.Warn("following the changes of the primary", "primary", r.primary, "error", err)
+		t := time.NewTimer(reconnectDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// stream calls fn with the events of the change stream of the primary, from
+// the one after the last applied, until the stream ends.
+func (r *Replica) stream(ctx context.Context, fn func(watch.Event)) error {
+	r.mu.Lock()
+	last := r.last
+	r.mu.Unlock()
+	var lastID string
+	if last > 0 {
+		lastID = strconv.FormatUint(last, 10)
+	}
+	resp, err := r.get(ctx, "/watch", lastID)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the events are single data lines, see httpserver.Server.EnableWatch
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e watch.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return fmt.Errorf("decoding event: %w", err)
+		}
+		fn(e)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream closed by the primary")
+}
+
+// apply applies e to the cache, and returns false if it is a Reset, after
+// which the cache must be synced again.
+func (r *Replica) apply(e watch.Event) bool {
+	r.mu.Lock()
+	r.last = e.ID
+	r.mu.Unlock()
+	switch e.Kind {
+	case watch.Update:
+		if e.Rate != nil {
+			r.cache.Insert(e.Address, *e.Rate)
+		}
+	case watch.Delete:
+		r.cache.Delete(e.Address)
+	case watch.Invalidate:
+		switch {
+		case e.Prefix != "":
+			r.cache.InvalidateByPrefix(e.Prefix)
+		case e.Code != "":
+			r.cache.InvalidateByJurisdiction(e.Level, e.Code)
+		}
+		for _, address := range e.Addresses {
+			r.cache.Delete(address)
+		}
+	case watch.Reset:
+		return false
+	}
+	return true
+}
+
+// get sends GET path to the primary, with the Last-Event-ID lastID if not
+// empty, and fails unless it replies 200.
+func (r *Replica) get(ctx context.Context, path, lastID string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.primary+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,149 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// newPrimary returns a primary serving the rates of cache, with the admin
+// token "secret" and watch enabled.
+func newPrimary(t *testing.T, cache *salestax.RateCache) *httptest.Server {
+	t.Helper()
+	hs := httpserver.New("", cache, nil)
+	hs.EnableAdmin(httpserver.Admin{Token: "secret"})
+	hs.EnableWatch(watch.New())
+	srv := httptest.NewServer(hs.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// eventually fails t unless cond holds within a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: not after 1s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSync(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("TX:1 Congress Ave", salestax.Flat(0.0825))
+	cache.Insert("CA:1 Market St", salestax.Flat(0.08625))
+	primary := newPrimary(t, cache)
+
+	local := salestax.NewRateCache(100)
+	r := New(primary.URL, local, WithAPIKey("wrong"))
+	if err := r.Check(context.Background()); err == nil {
+		t.Error("Check passed before the first snapshot")
+	}
+	if err := r.Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Sync with a wrong key: %v, want 401", err)
+	}
+
+	r = New(primary.URL, local, WithAPIKey("secret"))
+	if err := r.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if local.Len() != 2 {
+		t.Errorf("replica holds %d rates, want 2", local.Len())
+	}
+	if item, err := local.Get("CA:1 Market St"); err != nil || item.Value().Total() != 0.08625 {
+		t.Errorf("CA:1 Market St = %v, %v", item, err)
+	}
+	if err := r.Check(context.Background()); err != nil {
+		t.Errorf("Check after a snapshot: %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("TX:1 Congress Ave", salestax.Flat(0.0825))
+	primary := newPrimary(t, cache)
+
+	local := salestax.NewRateCache(100)
+	r := New(primary.URL, local, WithAPIKey("secret"), WithWatch(), WithInterval(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	eventually(t, "first snapshot", func() bool { return !r.Synced().IsZero() })
+
+	send := func(method, path, body string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, primary.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			t.Fatalf("%s %s: %s", method, path, resp.Status)
+		}
+	}
+	// the stream may not be followed yet: write until a rate comes through
+	eventually(t, "PUT followed", func() bool {
+		send("PUT", "/rate/CA:1 Market St", `{"rate": 0.08625}`)
+		_, err := local.Get("CA:1 Market St")
+		return err == nil
+	})
+	send("DELETE", "/rate/CA:1 Market St", "")
+	eventually(t, "DELETE followed", func() bool {
+		_, err := local.Get("CA:1 Market St")
+		return errors.Is(err, salestax.ErrNotFound)
+	})
+	send("POST", "/invalidate", `{"prefix": "TX:"}`)
+	eventually(t, "invalidation followed", func() bool { return local.Len() == 0 })
+}
+
+func TestLoader(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("TX:1 Congress Ave", salestax.Flat(0.0825))
+	primary := newPrimary(t, cache)
+
+	loader, err := Loader(primary.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := salestax.NewRateCache(100)
+	rate, err := local.GetOrLoadCtx(context.Background(), "TX:1 Congress Ave", loader)
+	if err != nil || rate.Total() != 0.0825 {
+		t.Errorf("GetOrLoadCtx = %v, %v", rate, err)
+	}
+	if _, err := local.GetOrLoadCtx(context.Background(), "NV:1 Fremont St", loader); !errors.Is(err, salestax.ErrNotFound) {
+		t.Errorf("GetOrLoadCtx of an unknown address: %v, want ErrNotFound", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	hs := httpserver.New("", salestax.NewRateCache(100), nil)
+	hs.SetReadOnly()
+	srv := httptest.NewServer(hs.Handler())
+	defer srv.Close()
+	req, _ := http.NewRequest("PUT", srv.URL+"/rate/x", strings.NewReader(`{"rate": 0.05}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PUT on a read-only server: %s, want 403", resp.Status)
+	}
+}
@@ -0,0 +1,615 @@
+// Package respserver exposes a salestax.RateCache over a subset of RESP, the
+// Redis protocol, so that redis-cli and the Redis client of any language can
+// inspect and update the cache.
+//
+// Commands, case-insensitive:
+//
+//	GET <address>           the combined rate, e.g. "0.0725", loading a miss
+//	SET <address> <rate> [EX <seconds> | PX <milliseconds>]
+//	                        store a rate, a number or the JSON of a
+//	                        salestax.TaxRate breakdown, with the TTL of the
+//	                        cache unless EX or PX is given
+//	DEL <address>...        remove rates, replying how many were cached
+//	EXISTS <address>...     how many of the addresses are cached
+//	TTL <address>, PTTL     the time to live of a rate, -1 without expiry,
+//	                        -2 if not cached
+//	AUTH [<username>] <API key>
+//	                        authenticate the connection, see EnableAuth;
+//	                        the username is ignored
+//	DBSIZE, INFO, PING, ECHO, SELECT 0, COMMAND, QUIT
+//
+// With EnableCluster SET and DEL are broadcast to the other instances.
+//
+// A server made read-only with SetReadOnly, on a replica, fails SET and DEL
+// with a READONLY error.
+//
+// With EnableAuth commands but AUTH and QUIT fail with NOAUTH until the
+// connection authenticates, and SET and DEL require the admin role.
+//
+// Requests are RESP arrays of bulk strings, or inline commands as typed in
+// a telnet session.
+package respserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// DefaultAddr is the listen address used when none is configured.
+const DefaultAddr = ":6380"
+
+// maxBulk bounds the size of the bulk strings of requests, and maxArgs
+// their number.
+const (
+	maxBulk = 64 << 10
+	maxArgs = 1024
+)
+
+// Server serves RESP from a cache. A nil loader makes the server
+// cache-only: a GET of a miss replies nil instead of loading it.
+type Server struct {
+	addr     string
+	cache    *salestax.RateCache
+	loader   salestax.RateLoaderFuncCtx
+	started  time.Time
+	readOnly bool
+	auth     *auth.Authenticator   // nil unless EnableAuth was called
+	cluster  *invalidation.Cluster // nil unless EnableCluster was called
+
+	mu       sync.Mutex
+	lis      net.Listener
+	conns    map[net.Conn]bool // whether each waits for its next command
+	shutdown bool
+	done     sync.WaitGroup
+}
+
+// New returns a Server listening on addr (DefaultAddr if empty). The loader
+// is called with a context cancelled when the client disconnects.
+func New(addr string, cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx) *Server {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	return &Server{addr: addr, cache: cache, loader: loader, started: time.Now(), conns: make(map[net.Conn]bool)}
+}
+
+// SetReadOnly makes the server refuse the commands that write, for
+// read-only replicas. It must be called before the server starts serving.
+func (s *Server) SetReadOnly() {
+	s.readOnly = true
+}
+
+// EnableAuth makes the server authenticate its connections with the API
+// keys of a, given to AUTH, and authorize each command as Authorize does.
+// Clients of a tenant are refused, as the server serves the default cache.
+// It must be called before the server starts serving.
+func (s *Server) EnableAuth(a *auth.Authenticator) {
+	s.auth = a
+}
+
+// EnableCluster makes the server broadcast its writes through cluster, so
+// that the other instances evict their copies. The cluster must invalidate
+// the cache of the server. It must be called before the server starts
+// serving.
+func (s *Server) EnableCluster(cluster *invalidation.Cluster) {
+	s.cluster = cluster
+}
+
+// Addr returns the configured listen address.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// ListenAndServe serves requests until Shutdown is called, in which case
+// it returns nil.
+func (s *Server) ListenAndServe() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve serves requests on lis until Shutdown is called.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.lis = lis
+	s.mu.Unlock()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			s.mu.Lock()
+			shutdown := s.shutdown
+			s.mu.Unlock()
+			if shutdown {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.shutdown {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = false
+		s.done.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections and lets every connection finish
+// its current command. If ctx expires first the connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	if s.lis != nil {
+		s.lis.Close()
+	}
+	for conn, idle := range s.conns {
+		// unblocks connections waiting for their next command, the others
+		// stop once their command is done
+		if idle {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.done.Done()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var sess session
+	for s.waitCommand(conn, r) {
+		args, err := readCommand(r)
+		var pe protocolError
+		if errors.As(err, &pe) {
+			// the stream cannot be resynchronized, as Redis does
+			writeError(w, "ERR Protocol error: "+pe.msg)
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		quit := s.command(ctx, &sess, args, w)
+		// pipelined commands are answered together
+		if r.Buffered() == 0 || quit {
+			if w.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+	w.Flush()
+}
+
+// waitCommand waits for the next command on conn and reports whether one
+// arrived. Shutdown interrupts the wait, but not a command that has begun to
+// arrive, so that a multibulk is not cut off in the middle of its arguments.
+func (s *Server) waitCommand(conn net.Conn, r *bufio.Reader) bool {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return false
+	}
+	s.conns[conn] = true
+	s.mu.Unlock()
+	_, err := r.Peek(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = false
+	if err == nil && s.shutdown {
+		// the deadline of Shutdown may have come after the command
+		conn.SetReadDeadline(time.Time{})
+	}
+	return err == nil
+}
+
+type protocolError struct{ msg string }
+
+func (e protocolError) Error() string { return e.msg }
+
+// readCommand reads a RESP array of bulk strings or an inline command.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "*") {
+			if args := strings.Fields(line); len(args) > 0 {
+				return args, nil
+			}
+			continue // empty inline commands are ignored
+		}
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArgs {
+			return nil, protocolError{"invalid multibulk length"}
+		}
+		if n <= 0 {
+			continue
+		}
+		args := make([]string, n)
+		for i := range args {
+			line, err := readLine(r)
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(line, "$") {
+				return nil, protocolError{fmt.Sprintf("expected '$', got '%.1s'", line)}
+			}
+			size, err := strconv.Atoi(line[1:])
+			if err != nil || size < 0 || size > maxBulk {
+				return nil, protocolError{"invalid bulk length"}
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if string(data[size:]) != "\r\n" {
+				return nil, protocolError{"expected CRLF after bulk string"}
+			}
+			args[i] = string(data[:size])
+		}
+		return args, nil
+	}
+}
+
+// readLine reads a line, without its \r\n.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", protocolError{"too big inline request"}
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// session is the state of a connection.
+type session struct {
+	key string // API key the connection authenticated with, see EnableAuth
+}
+
+// command runs one command and reports whether the connection is to be
+// closed.
+func (s *Server) command(ctx context.Context, sess *session, args []string, w *bufio.Writer) bool {
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+	arity := func(lo, hi int) bool {
+		if len(args) < lo || (hi >= 0 && len(args) > hi) {
+			writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+			return false
+		}
+		return true
+	}
+	if name != "AUTH" && name != "QUIT" && !s.authorize(sess, name, w) {
+		return false
+	}
+	switch name {
+	case "SET", "DEL":
+		if s.readOnly {
+			writeError(w, "READONLY read-only replica, write to the primary")
+			return false
+		}
+	}
+	switch name {
+	case "GET":
+		if arity(1, 1) {
+			s.get(ctx, args[0], w)
+		}
+	case "SET":
+		if arity(2, 4) {
+			s.set(ctx, args, w)
+		}
+	case "DEL":
+		if arity(1, -1) {
+			n := 0
+			for _, key := range args {
+				if s.cache.Delete(key) {
+					n++
+				}
+			}
+			// other instances may hold a copy even if this one did not
+			if err := s.broadcast(ctx, args...); err != nil {
+				writeError(w, "ERR "+err.Error())
+			} else {
+				writeInt(w, int64(n))
+			}
+		}
+	case "EXISTS":
+		if arity(1, -1) {
+			n := 0
+			for _, key := range args {
+				if s.cache.Contains(key) {
+					n++
+				}
+			}
+			writeInt(w, int64(n))
+		}
+	case "TTL", "PTTL":
+		if arity(1, 1) {
+			unit := time.Second
+			if name == "PTTL" {
+				unit = time.Millisecond
+			}
+			writeInt(w, s.ttl(args[0], unit))
+		}
+	case "DBSIZE":
+		if arity(0, 0) {
+			writeInt(w, int64(s.cache.Len()))
+		}
+	case "INFO":
+		if arity(0, 1) {
+			writeBulk(w, s.info())
+		}
+	case "PING":
+		if arity(0, 1) {
+			if len(args) == 1 {
+				writeBulk(w, args[0])
+			} else {
+				fmt.Fprint(w, "+PONG\r\n")
+			}
+		}
+	case "ECHO":
+		if arity(1, 1) {
+			writeBulk(w, args[0])
+		}
+	case "SELECT":
+		if arity(1, 1) {
+			if args[0] == "0" {
+				fmt.Fprint(w, "+OK\r\n")
+			} else {
+				writeError(w, "ERR DB index is out of range")
+			}
+		}
+	case "AUTH":
+		if arity(1, 2) {
+			s.login(sess, args[len(args)-1], w)
+		}
+	case "COMMAND":
+		// redis-cli asks for the command docs on start; there are none
+		fmt.Fprint(w, "*0\r\n")
+	case "QUIT":
+		fmt.Fprint(w, "+OK\r\n")
+		return true
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%.64s'", name))
+	}
+	return false
+}
+
+// authorize reports whether the connection of sess may run the command
+// name, replying the error if not.
+func (s *Server) authorize(sess *session, name string, w *bufio.Writer) bool {
+	if s.auth == nil {
+		return true
+	}
+	if sess.key == "" {
+		writeError(w, "NOAUTH Authentication required.")
+		return false
+	}
+	role := auth.Read
+	if name == "SET" || name == "DEL" {
+		role = auth.Admin
+	}
+	_, err := s.auth.Authorize(sess.key, nil, role)
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		writeError(w, "NOPERM "+err.Error())
+	case err != nil:
+		writeError(w, "ERR "+err.Error())
+	default:
+		return true
+	}
+	return false
+}
+
+// login runs AUTH with key.
+func (s *Server) login(sess *session, key string, w *bufio.Writer) {
+	if s.auth == nil {
+		writeError(w, "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+	c, err := s.auth.Authorize(key, nil, auth.Read)
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		writeError(w, "WRONGPASS invalid username-password pair or user is disabled.")
+	case err != nil:
+		writeError(w, "ERR "+err.Error())
+	case c.Tenant != "":
+		writeError(w, fmt.Sprintf("NOPERM client %s is of tenant %s, which the Redis protocol does not serve", c.Name, c.Tenant))
+	default:
+		sess.key = key
+		fmt.Fprint(w, "+OK\r\n")
+	}
+}
+
+func (s *Server) get(ctx context.Context, address string, w *bufio.Writer) {
+	var rate salestax.TaxRate
+	var err error
+	if s.loader == nil {
+		rate, err = s.cache.Lookup(address)
+	} else {
+		rate, err = s.cache.GetOrLoadCtx(ctx, address, s.loader)
+	}
+	switch {
+	case errors.Is(err, salestax.ErrNotFound), errors.Is(err, salestax.ErrExpired):
+		fmt.Fprint(w, "$-1\r\n")
+	case err != nil:
+		writeError(w, "ERR "+err.Error())
+	default:
+		// rounded so that sums of components like 0.06 + 0.01 read 0.07
+		writeBulk(w, strconv.FormatFloat(math.Round(rate.Total()*1e9)/1e9, 'f', -1, 64))
+	}
+}
+
+func (s *Server) set(ctx context.Context, args []string, w *bufio.Writer) {
+	rate, err := parseRate(args[1])
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			writeError(w, "ERR syntax error")
+			return
+		}
+		var unit time.Duration
+		switch strings.ToUpper(args[2]) {
+		case "EX":
+			unit = time.Second
+		case "PX":
+			unit = time.Millisecond
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+		// beyond what a time.Duration holds the TTL would wrap negative
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 || n > math.MaxInt64/int64(unit) {
+			writeError(w, "ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl = time.Duration(n) * unit
+	}
+	// without EX or PX the TTL of the cache applies
+	if ttl > 0 {
+		err = s.cache.InsertWithTTL(args[0], rate, ttl)
+	} else {
+		err = s.cache.Insert(args[0], rate)
+	}
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	if err := s.broadcast(ctx, args[0]); err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	fmt.Fprint(w, "+OK\r\n")
+}
+
+// broadcast makes the other instances of the cluster evict their copies of
+// the rates of addresses, after a write.
+func (s *Server) broadcast(ctx context.Context, addresses ...string) error {
+	if s.cluster == nil {
+		return nil
+	}
+	if _, err := s.cluster.Invalidate(ctx, invalidation.Event{Keys: addresses}); err != nil {
+		return fmt.Errorf("broadcasting the invalidation of %s: %w", strings.Join(addresses, ", "), err)
+	}
+	return nil
+}
+
+// ttl returns the time to live of address in unit, on the clock of the
+// cache, -1 if it does not expire and -2 if it is not cached.
+func (s *Server) ttl(address string, unit time.Duration) int64 {
+	_, info, err := s.cache.PeekWithInfo(address)
+	if err != nil {
+		return -2
+	}
+	if info.Expires.IsZero() {
+		return -1
+	}
+	d := info.TTL
+	if d <= 0 {
+		return -2
+	}
+	// rounded up like Redis, so that a live key never reports 0, without
+	// adding to d which may be close to the largest Duration
+	n := int64(d / unit)
+	if d%unit != 0 {
+		n++
+	}
+	return n
+}
+
+func (s *Server) info() string {
+	st := s.cache.Stats()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Server\r\nredis_version:7.0.0\r\nsalestax_srv:1\r\nprocess_id:%d\r\nuptime_in_seconds:%d\r\n\r\n",
+		os.Getpid(), int64(time.Since(s.started).Seconds()))
+	fmt.Fprintf(&b, "# Stats\r\nkeyspace_hits:%d\r\nkeyspace_misses:%d\r\nevicted_keys:%d\r\nexpired_keys:%d\r\nloader_calls:%d\r\nloader_errors:%d\r\n\r\n",
+		st.Hits, st.Misses, st.Evictions, st.Expirations, st.LoaderCalls, st.LoaderErrors)
+	fmt.Fprintf(&b, "# Keyspace\r\ndb0:keys=%d,expires=0,avg_ttl=0\r\n", st.Size)
+	return b.String()
+}
+
+// parseRate parses a stored value: a combined rate, or the JSON of a
+// salestax.TaxRate.
+func parseRate(value string) (salestax.TaxRate, error) {
+	if rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+		return salestax.Flat(rate), nil
+	}
+	var rate salestax.TaxRate
+	if err := json.Unmarshal([]byte(value), &rate); err != nil || len(rate.Components) == 0 {
+		return salestax.TaxRate{}, errors.New("value is neither a rate nor a JSON breakdown")
+	}
+	return rate, nil
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+// writeError writes an error reply; msg must not contain newlines.
+func writeError(w *bufio.Writer, msg string) {
+	fmt.Fprintf(w, "-%s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+}
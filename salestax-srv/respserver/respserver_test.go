@@ -0,0 +1,513 @@
+package respserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache/lrucachetest"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// client is a connection to a test server.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newTestServer serves cache on a loopback port until the test ends, once
+// the setup functions have been called on the server, and returns a
+// connection to it.
+func newTestServer(t *testing.T, cache *salestax.RateCache, loader salestax.RateLoaderFuncCtx, setup ...func(*Server)) *client {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(lis.Addr().String(), cache, loader)
+	for _, f := range setup {
+		f(s)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return dial(t, lis.Addr().String())
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// multibulk encodes args as a RESP array of bulk strings.
+func multibulk(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return b.String()
+}
+
+// send writes s as is.
+func (c *client) send(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// do sends args as a multibulk and checks the reply lines.
+func (c *client) do(args []string, want ...string) {
+	c.t.Helper()
+	c.send(multibulk(args...))
+	c.expect(want...)
+}
+
+// expect reads the reply lines want.
+func (c *client) expect(want ...string) {
+	c.t.Helper()
+	for _, w := range want {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("reading %q: %v", w, err)
+		}
+		if got := strings.TrimSuffix(line, "\r\n"); got != w {
+			c.t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+}
+
+// integer reads an integer reply.
+func (c *client) integer() int64 {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(line, ":"), "\r\n"), 10, 64)
+	if err != nil {
+		c.t.Fatalf("reply %q is not an integer", line)
+	}
+	return n
+}
+
+// closed checks that the server closed the connection, resetting it if it
+// left data of the client unread.
+func (c *client) closed() {
+	c.t.Helper()
+	if line, err := c.r.ReadString('\n'); !errors.Is(err, io.EOF) && !errors.Is(err, syscall.ECONNRESET) {
+		c.t.Fatalf("read %q, %v, want the connection closed", line, err)
+	}
+}
+
+func args(a ...string) []string { return a }
+
+func TestFraming(t *testing.T) {
+	c := newTestServer(t, salestax.NewRateCache(100), nil)
+
+	// keys and values may hold spaces and line breaks in bulk strings
+	c.do(args("SET", "1 Main St", "0.0725"), "+OK")
+	c.do(args("get", "1 Main St"), "$6", "0.0725")
+	c.do(args("SET", "a\r\nb", " 0.05 "), "+OK")
+	c.do(args("GET", "a\r\nb"), "$4", "0.05")
+
+	body := `{"components": [{"level": "state", "rate": 0.06}, {"rate": 0.01}]}`
+	c.do(args("SET", "b", body), "+OK")
+	c.do(args("GET", "b"), "$4", "0.07")
+	c.do(args("SET", "c", "abc"), "-ERR value is neither a rate nor a JSON breakdown")
+	c.do(args("GET", "c"), "$-1")
+
+	// inline commands, empty lines and empty arrays are skipped
+	c.send("\r\n*0\r\n*-1\r\nGET b\r\n")
+	c.expect("$4", "0.07")
+	c.send("ping\r\n")
+	c.expect("+PONG")
+
+	// pipelined commands are answered in order
+	c.send(multibulk("SET", "d", "0.01") + multibulk("EXISTS", "b", "d", "e") + "DBSIZE\r\n" + multibulk("DEL", "b", "e"))
+	c.expect("+OK", ":2", ":4", ":1")
+}
+
+func TestCommands(t *testing.T) {
+	c := newTestServer(t, salestax.NewRateCache(100), nil)
+	c.do(args("SET", "a", "0.05"), "+OK")
+	c.do(args("PING"), "+PONG")
+	c.do(args("PING", "hi"), "$2", "hi")
+	c.do(args("ECHO", "hi there"), "$8", "hi there")
+	c.do(args("SELECT", "0"), "+OK")
+	c.do(args("SELECT", "1"), "-ERR DB index is out of range")
+	c.do(args("COMMAND", "DOCS"), "*0")
+	c.do(args("DEL", "a", "a", "b"), ":1")
+	c.do(args("EXISTS", "a"), ":0")
+	c.do(args("FLUSHALL"), "-ERR unknown command 'FLUSHALL'")
+
+	c.do(args("SET", "a", "0.05"), "+OK")
+	c.send(multibulk("INFO"))
+	line, _ := c.r.ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "$"), "\r\n"))
+	if err != nil {
+		t.Fatalf("INFO reply %q, want a bulk string", line)
+	}
+	info := make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, info); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(info), "db0:keys=1,") || !strings.Contains(string(info), "redis_version:") {
+		t.Errorf("INFO = %q, want the server and keyspace sections", info)
+	}
+
+	c.do(args("QUIT"), "+OK")
+	c.closed()
+}
+
+func TestArity(t *testing.T) {
+	c := newTestServer(t, salestax.NewRateCache(100), nil)
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{args("GET"), "-ERR wrong number of arguments for 'get' command"},
+		{args("GET", "a", "b"), "-ERR wrong number of arguments for 'get' command"},
+		{args("SET", "a"), "-ERR wrong number of arguments for 'set' command"},
+		{args("SET", "a", "0.05", "EX", "10", "NX"), "-ERR wrong number of arguments for 'set' command"},
+		{args("SET", "a", "0.05", "EX"), "-ERR syntax error"},
+		{args("DEL"), "-ERR wrong number of arguments for 'del' command"},
+		{args("EXISTS"), "-ERR wrong number of arguments for 'exists' command"},
+		{args("TTL"), "-ERR wrong number of arguments for 'ttl' command"},
+		{args("PTTL", "a", "b"), "-ERR wrong number of arguments for 'pttl' command"},
+		{args("DBSIZE", "x"), "-ERR wrong number of arguments for 'dbsize' command"},
+		{args("PING", "a", "b"), "-ERR wrong number of arguments for 'ping' command"},
+		{args("ECHO"), "-ERR wrong number of arguments for 'echo' command"},
+		{args("SELECT"), "-ERR wrong number of arguments for 'select' command"},
+		{args("INFO", "a", "b"), "-ERR wrong number of arguments for 'info' command"},
+	} {
+		c.do(tt.args, tt.want)
+	}
+	// errors leave the connection usable
+	c.do(args("PING"), "+PONG")
+}
+
+func TestReadOnly(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	cache.Insert("a", salestax.Flat(0.05))
+	c := newTestServer(t, cache, nil, (*Server).SetReadOnly)
+
+	c.do(args("SET", "b", "0.06"), "-READONLY read-only replica, write to the primary")
+	c.do(args("set", "a", "0.06", "EX", "10"), "-READONLY read-only replica, write to the primary")
+	c.do(args("DEL", "a"), "-READONLY read-only replica, write to the primary")
+	c.do(args("GET", "a"), "$4", "0.05")
+	c.do(args("DBSIZE"), ":1")
+}
+
+func TestAuth(t *testing.T) {
+	a, err := auth.New([]auth.Client{
+		{Name: "checkout", Role: auth.Read, Key: "r-key"},
+		{Name: "rates-sync", Role: auth.Admin, Key: "w-key"},
+		{Name: "acme-pos", Role: auth.Read, Key: "t-key", Tenant: "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := salestax.NewRateCache(100)
+	cache.Insert("a", salestax.Flat(0.05))
+	c := newTestServer(t, cache, nil, func(s *Server) { s.EnableAuth(a) })
+	addr := c.conn.RemoteAddr().String()
+
+	for _, cmd := range [][]string{args("GET", "a"), args("SET", "a", "0.06"), args("DEL", "a"), args("PING")} {
+		c.do(cmd, "-NOAUTH Authentication required.")
+	}
+	c.do(args("AUTH", "bad-key"), "-WRONGPASS invalid username-password pair or user is disabled.")
+	c.do(args("AUTH", "acme-pos", "t-key"), "-NOPERM client acme-pos is of tenant acme, which the Redis protocol does not serve")
+	c.do(args("AUTH", "a", "b", "c"), "-ERR wrong number of arguments for 'auth' command")
+	c.do(args("AUTH", "checkout", "r-key"), "+OK")
+	c.do(args("GET", "a"), "$4", "0.05")
+	c.do(args("SET", "a", "0.06"), "-NOPERM forbidden: client checkout has the read role, admin is required")
+	c.do(args("DEL", "a"), "-NOPERM forbidden: client checkout has the read role, admin is required")
+
+	c = dial(t, addr)
+	c.do(args("auth", "w-key"), "+OK")
+	c.do(args("SET", "a", "0.06"), "+OK")
+	c.do(args("DEL", "a"), ":1")
+	c.do(args("QUIT"), "+OK")
+	c.closed()
+
+	c = newTestServer(t, cache, nil)
+	c.do(args("AUTH", "w-key"), "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+}
+
+// bus records the keys of the events published, or fails with err.
+type bus struct {
+	mu   sync.Mutex
+	keys [][]string
+	err  error
+}
+
+func (b *bus) Publish(_ context.Context, msg []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	var e invalidation.Event
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, e.Keys)
+	return nil
+}
+
+func (b *bus) Subscribe(ctx context.Context, _ func([]byte)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestCluster(t *testing.T) {
+	cache := salestax.NewRateCache(100)
+	b := &bus{}
+	cluster := invalidation.New(b, cache)
+	c := newTestServer(t, cache, nil, func(s *Server) { s.EnableCluster(cluster) })
+
+	c.do(args("SET", "a", "0.05"), "+OK")
+	c.do(args("SET", "b", "0.05", "EX", "10"), "+OK")
+	// the cluster evicts the local copy of a write as well
+	c.do(args("DEL", "a", "c"), ":0")
+	want := [][]string{{"a"}, {"b"}, {"a", "c"}}
+	if !slices.EqualFunc(b.keys, want, slices.Equal) {
+		t.Errorf("broadcast %q, want %q", b.keys, want)
+	}
+
+	b.mu.Lock()
+	b.err = errors.New("bus down")
+	b.mu.Unlock()
+	c.do(args("SET", "a", "0.05"), "-ERR broadcasting the invalidation of a: bus down")
+	c.do(args("DEL", "a", "b"), "-ERR broadcasting the invalidation of a, b: bus down")
+}
+
+func TestExpire(t *testing.T) {
+	c := newTestServer(t, salestax.NewRateCache(100), nil)
+
+	c.do(args("TTL", "a"), ":-2")
+	c.do(args("SET", "a", "0.05"), "+OK")
+	c.do(args("TTL", "a"), ":-1")
+	c.do(args("PTTL", "a"), ":-1")
+
+	c.do(args("SET", "a", "0.05", "EX", "100"), "+OK")
+	c.send(multibulk("TTL", "a"))
+	if ttl := c.integer(); ttl != 100 {
+		t.Errorf("TTL after EX 100 = %d, want 100", ttl)
+	}
+	c.send(multibulk("PTTL", "a"))
+	if pttl := c.integer(); pttl <= 99000 || pttl > 100000 {
+		t.Errorf("PTTL after EX 100 = %d, want 100000", pttl)
+	}
+	c.do(args("SET", "a", "0.05", "px", "1500"), "+OK")
+	c.do(args("TTL", "a"), ":2")
+
+	c.do(args("SET", "a", "0.05", "PX", "1"), "+OK")
+	time.Sleep(5 * time.Millisecond)
+	c.do(args("TTL", "a"), ":-2")
+	c.do(args("GET", "a"), "$-1")
+
+	for _, tt := range [][]string{
+		{"EX", "0"},
+		{"EX", "-1"},
+		{"EX", "ten"},
+		{"EX", strconv.FormatInt(math.MaxInt64/int64(time.Second)+1, 10)},
+		{"EX", "9223372036854775807"},
+		{"PX", strconv.FormatInt(math.MaxInt64/int64(time.Millisecond)+1, 10)},
+	} {
+		c.do(args("SET", "b", "0.05", tt[0], tt[1]), "-ERR invalid expire time in 'set' command")
+	}
+	c.do(args("SET", "b", "0.05", "KEEPTTL", "10"), "-ERR syntax error")
+	c.do(args("EXISTS", "b"), ":0")
+
+	// without EX or PX the TTL of the cache applies
+	c = newTestServer(t, salestax.NewRateCache(100, lrucache.WithTTL(time.Hour)), nil)
+	c.do(args("SET", "a", "0.05"), "+OK")
+	c.do(args("TTL", "a"), ":3600")
+
+	// the longest TTLs accepted do not wrap around
+	c.do(args("SET", "b", "0.05", "EX", strconv.FormatInt(math.MaxInt64/int64(time.Second), 10)), "+OK")
+	c.send(multibulk("TTL", "b"))
+	if ttl := c.integer(); ttl < 1<<32 {
+		t.Errorf("TTL after the longest EX = %d, want centuries", ttl)
+	}
+}
+
+func TestExpireClock(t *testing.T) {
+	// years behind the wall clock, where the keys would have expired
+	clock := lrucachetest.NewClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := newTestServer(t, salestax.NewRateCache(100, lrucache.WithClock(clock)), nil)
+
+	c.do(args("SET", "a", "0.05", "EX", "100"), "+OK")
+	clock.Advance(40 * time.Second)
+	c.do(args("TTL", "a"), ":60")
+	c.do(args("PTTL", "a"), ":60000")
+	clock.Advance(time.Minute)
+	c.do(args("TTL", "a"), ":-2")
+}
+
+func TestLoader(t *testing.T) {
+	var loads atomic.Int32
+	c := newTestServer(t, salestax.NewRateCache(100), func(ctx context.Context, address string) (salestax.TaxRate, error) {
+		loads.Add(1)
+		switch address {
+		case "nowhere":
+			return salestax.TaxRate{}, salestax.ErrNotFound
+		case "broken":
+			return salestax.TaxRate{}, errors.New("backend down")
+		}
+		return salestax.Flat(0.0825), nil
+	})
+	c.do(args("GET", "a"), "$6", "0.0825")
+	c.do(args("GET", "a"), "$6", "0.0825")
+	c.do(args("GET", "nowhere"), "$-1")
+	c.send(multibulk("GET", "broken"))
+	if line, _ := c.r.ReadString('\n'); !strings.HasPrefix(line, "-ERR ") || !strings.Contains(line, "backend down") {
+		t.Errorf("GET of a failing load = %q, want an error", line)
+	}
+	if n := loads.Load(); n != 3 {
+		t.Errorf("loader called %d times, want 3", n)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, req, want string
+	}{
+		{"multibulk length", "*abc\r\n", "-ERR Protocol error: invalid multibulk length"},
+		{"too many arguments", fmt.Sprintf("*%d\r\n", maxArgs+1), "-ERR Protocol error: invalid multibulk length"},
+		{"no bulk", "*1\r\nGET\r\n", "-ERR Protocol error: expected '$', got 'G'"},
+		{"bulk length", "*1\r\n$x\r\n", "-ERR Protocol error: invalid bulk length"},
+		{"negative bulk length", "*1\r\n$-1\r\n", "-ERR Protocol error: invalid bulk length"},
+		{"bulk too large", fmt.Sprintf("*1\r\n$%d\r\n", maxBulk+1), "-ERR Protocol error: invalid bulk length"},
+		{"bulk longer than its length", "*2\r\n$3\r\nGET\r\n$1\r\nab\r\n", "-ERR Protocol error: expected CRLF after bulk string"},
+		{"bulk shorter than its length", "*1\r\n$5\r\nPING\r\n\r\n", "-ERR Protocol error: expected CRLF after bulk string"},
+		{"inline too long", strings.Repeat("x", 4096), "-ERR Protocol error: too big inline request"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestServer(t, salestax.NewRateCache(10), nil)
+			c.send(tt.req)
+			c.expect(tt.want)
+			c.closed()
+		})
+	}
+
+	t.Run("at the limits", func(t *testing.T) {
+		c := newTestServer(t, salestax.NewRateCache(10), nil)
+		keys := make([]string, maxArgs)
+		keys[0] = "EXISTS"
+		for i := 1; i < len(keys); i++ {
+			keys[i] = strconv.Itoa(i)
+		}
+		c.do(keys, ":0")
+		c.do(args("ECHO", strings.Repeat("x", maxBulk)), "$"+strconv.Itoa(maxBulk), strings.Repeat("x", maxBulk))
+	})
+}
+
+// waitConns waits until every connection of s is idle, or every one is busy.
+func waitConns(s *Server, idle bool) {
+	for {
+		s.mu.Lock()
+		ok := len(s.conns) > 0
+		for _, i := range s.conns {
+			ok = ok && i == idle
+		}
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := salestax.NewRateCache(10)
+	s := New("", cache, nil)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	c := dial(t, lis.Addr().String())
+	waitConns(s, true)
+	c.send("*3\r\n$3\r\nSET\r\n$1\r\na\r\n")
+	waitConns(s, false)
+
+	// the SET started before Shutdown, so its last argument is still read
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	for {
+		s.mu.Lock()
+		down := s.shutdown
+		s.mu.Unlock()
+		if down {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.send("$4\r\n0.07\r\n")
+	c.expect("+OK")
+	c.closed()
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve after Shutdown = %v, want nil", err)
+	}
+	if item, err := cache.Get("a"); err != nil || item.Value().Total() != 0.07 {
+		t.Errorf("Get(a) = %v, %v, want the rate set during Shutdown", item, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("", salestax.NewRateCache(10), nil)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	c := dial(t, lis.Addr().String())
+	c.do(args("PING"), "+PONG")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve after Shutdown = %v, want nil", err)
+	}
+	c.closed()
+}
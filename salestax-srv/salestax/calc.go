@@ -0,0 +1,141 @@
+package salestax
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// RoundingMode is how a tax amount is rounded to the precision of a
+// Rounding.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds halves away from zero: 0.125 becomes 0.13.
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds halves to the even neighbor, banker's rounding:
+	// 0.125 becomes 0.12 and 0.135 becomes 0.14.
+	RoundHalfEven
+)
+
+// Rounding configures how CalculateTax rounds tax amounts.
+type Rounding struct {
+	Mode      RoundingMode
+	Precision int // decimal places, e.g. 2 for cents
+	// PerLine rounds the tax of each jurisdiction and sums the rounded
+	// amounts. Otherwise the total tax is rounded once and the rounding
+	// difference of the jurisdiction amounts is assigned to the largest,
+	// so that they add up to the total.
+	PerLine bool
+}
+
+// DefaultRounding rounds half up to cents, once for the total.
+var DefaultRounding = Rounding{Mode: RoundHalfUp, Precision: 2}
+
+// MaxPrecision is the largest Rounding.Precision supported.
+const MaxPrecision = 6
+
+// TaxLine is the tax levied by one jurisdiction in a Calculation.
+type TaxLine struct {
+	Component
+	Tax float64 `json:"tax"`
+}
+
+// Calculation is the tax on an amount, itemized by jurisdiction.
+type Calculation struct {
+	Amount   float64   `json:"amount"`
+	Category string    `json:"category,omitempty"`
+	Rate     float64   `json:"rate"` // the combined rate
+	Tax      float64   `json:"tax"`
+	Total    float64   `json:"total"` // Amount plus Tax
+	Lines    []TaxLine `json:"lines"`
+}
+
+// Calculate returns the tax on amount at rate, rounded as r says. Negative
+// amounts, e.g. refunds, are rounded symmetrically to positive ones.
+func Calculate(rate TaxRate, amount float64, r Rounding) (Calculation, error) {
+	if r.Precision < 0 || r.Precision > MaxPrecision {
+		return Calculation{}, fmt.Errorf("rounding precision %d is not between 0 and %d", r.Precision, MaxPrecision)
+	}
+	if len(rate.Components) == 0 {
+		return Calculation{}, fmt.Errorf("rate has no components")
+	}
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return Calculation{}, fmt.Errorf("invalid amount %v", amount)
+	}
+	scale := math.Pow10(r.Precision)
+
+	// amounts are computed in units of the precision, e.g. cents
+	units := make([]float64, len(rate.Components))
+	sum := 0.0
+	for i, c := range rate.Components {
+		units[i] = r.round(amount * c.Rate * scale)
+		sum += units[i]
+	}
+	if !r.PerLine {
+		total := r.round(amount * rate.Total() * scale)
+		if diff := total - sum; diff != 0 {
+			largest := 0
+			for i := range units {
+				if math.Abs(units[i]) > math.Abs(units[largest]) {
+					largest = i
+				}
+			}
+			units[largest] += diff
+		}
+		sum = total
+	}
+
+	calc := Calculation{
+		Amount:   amount,
+		Category: rate.Category,
+		Rate:     rate.Total(),
+		Tax:      sum / scale,
+		Lines:    make([]TaxLine, len(rate.Components)),
+	}
+	calc.Total = (math.Round(amount*scale*1e6)/1e6 + sum) / scale
+	for i, c := range rate.Components {
+		calc.Lines[i] = TaxLine{Component: c, Tax: units[i] / scale}
+	}
+	return calc, nil
+}
+
+// round rounds x, an amount in units of the precision, to a whole unit.
+// x is first snapped to a millionth of a unit, so that products like
+// 10 * 0.0725 = 0.72499999... still count as the half they are meant to be.
+func (r Rounding) round(x float64) float64 {
+	x = math.Round(x*1e6) / 1e6
+	if r.Mode == RoundHalfEven {
+		return math.RoundToEven(x)
+	}
+	return math.Round(x)
+}
+
+// CategoryKey returns the cache key of the rate of a category of goods at
+// address: address followed by "|" and the category, which the rate
+// sources of international rates look for. The empty and the "standard"
+// category are address itself.
+func CategoryKey(address, category string) string {
+	if category == "" || category == "standard" {
+		return address
+	}
+	return address + "|" + category
+}
+
+// CalculateTax returns the tax on amount for goods of category at address,
+// using the cached rate and calling loader on a miss; see Calculate and
+// CategoryKey.
+func (c *RateCache) CalculateTax(ctx context.Context, address string, amount float64, category string, loader RateLoaderFuncCtx, r Rounding) (Calculation, error) {
+	key := CategoryKey(address, category)
+	var rate TaxRate
+	var err error
+	if loader == nil {
+		rate, err = c.Lookup(key)
+	} else {
+		rate, err = c.GetOrLoadCtx(ctx, key, loader)
+	}
+	if err != nil {
+		return Calculation{}, err
+	}
+	return Calculate(rate, amount, r)
+}
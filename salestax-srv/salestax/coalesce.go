@@ -0,0 +1,90 @@
+package salestax
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Coalescer shares the lookups of a RateCache among the concurrent requests
+// for the same address, so that a burst of identical requests costs one
+// cache and loader round trip however many servers they come in through.
+// Requests are matched by the key as the cache stores it, so with a key
+// normalizer different spellings of an address share a lookup too.
+//
+// The cache coalesces concurrent loads by itself; a Coalescer also covers
+// the cache-only lookups and the round trips to the second tier and the
+// store that precede the load. It is safe for concurrent use.
+type Coalescer struct {
+	cache  *RateCache
+	loader RateLoaderFuncCtx
+	shared atomic.Uint64
+
+	mu sync.Mutex
+	m  map[string]*lookup
+}
+
+// lookup is an in-flight lookup of a Coalescer.
+type lookup struct {
+	done chan struct{}
+	rate TaxRate
+	err  error
+}
+
+// NewCoalescer returns a Coalescer of the lookups of cache, calling loader
+// on a miss. A nil loader makes lookups cache-only.
+func NewCoalescer(cache *RateCache, loader RateLoaderFuncCtx) *Coalescer {
+	return &Coalescer{cache: cache, loader: loader, m: make(map[string]*lookup)}
+}
+
+// Lookup returns the rate of key, joining the lookup of key in flight if
+// there is one. The lookup runs with the context of the request that
+// started it, without its cancellation: a request whose ctx is done stops
+// waiting and returns ctx.Err() while the others keep waiting for the
+// result.
+func (c *Coalescer) Lookup(ctx context.Context, key string) (TaxRate, error) {
+	key = c.cache.NormalizeKey(key)
+
+	c.mu.Lock()
+	l, ok := c.m[key]
+	if ok {
+		c.shared.Add(1)
+	} else {
+		l = &lookup{done: make(chan struct{})}
+		c.m[key] = l
+		go c.run(context.WithoutCancel(ctx), key, l)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return l.rate, l.err
+	case <-ctx.Done():
+		return TaxRate{}, ctx.Err()
+	}
+}
+
+func (c *Coalescer) run(ctx context.Context, key string, l *lookup) {
+	if c.loader == nil {
+		var item *lrucache.CacheItem[string, TaxRate]
+		if item, l.err = c.cache.Get(key); l.err == nil {
+			l.rate = item.Value()
+		}
+	} else {
+		l.rate, l.err = c.cache.GetOrLoadCtx(ctx, key, c.loader)
+	}
+
+	c.mu.Lock()
+	delete(c.m, key)
+	c.mu.Unlock()
+
+	close(l.done)
+}
+
+// Shared returns the number of requests that joined a lookup in flight
+// instead of starting their own.
+func (c *Coalescer) Shared() uint64 {
+	return c.shared.Load()
+}
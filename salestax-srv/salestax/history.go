@@ -0,0 +1,124 @@
+package salestax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// ErrNotInEffect is returned by the as-of lookups of HistoryCache when none
+// of the rate records of an address is in effect at the requested time. It
+// wraps ErrNotFound.
+var ErrNotInEffect = fmt.Errorf("no rate in effect at that time: %w", ErrNotFound)
+
+// RateHistory is the effective-dated rate records of an address, ordered by
+// EffectiveFrom. Where the periods of records overlap the later one is in
+// effect, so a rate change can be recorded by adding the new rate without
+// closing the period of the old one.
+type RateHistory []TaxRate
+
+// At returns the record in effect at t.
+func (h RateHistory) At(t time.Time) (TaxRate, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].EffectiveAt(t) {
+			return h[i], true
+		}
+	}
+	return TaxRate{}, false
+}
+
+// Add returns h with rate added in order, replacing a record of the same
+// EffectiveFrom. h itself is not modified, as it may be shared with other
+// readers of a cache.
+func (h RateHistory) Add(rate TaxRate) RateHistory {
+	i, found := slices.BinarySearchFunc(h, rate.EffectiveFrom, func(r TaxRate, t time.Time) int {
+		return r.EffectiveFrom.Compare(t)
+	})
+	out := make(RateHistory, 0, len(h)+1)
+	out = append(out, h[:i]...)
+	out = append(out, rate)
+	if found {
+		i++
+	}
+	return append(out, h[i:]...)
+}
+
+// HistoryCache is an LRU cache of the rate histories of addresses, for
+// lookups of the rate in effect at a past time, e.g. that of the original
+// sale when refunding it. RateCache is its counterpart for current rates.
+type HistoryCache struct {
+	*lrucache.LRUCache[string, RateHistory]
+}
+
+// HistoryLoaderFunc resolves all known rate records of an address.
+type HistoryLoaderFunc = lrucache.LoaderFunc[string, RateHistory]
+
+// HistoryLoaderFuncCtx is a HistoryLoaderFunc that honors the caller's
+// context.
+type HistoryLoaderFuncCtx = lrucache.LoaderFuncCtx[string, RateHistory]
+
+// NewHistoryCache returns a pointer to an initialized HistoryCache.
+func NewHistoryCache(sz int, opts ...lrucache.Option) *HistoryCache {
+	return &HistoryCache{lrucache.New[string, RateHistory](sz, opts...)}
+}
+
+// CurrentHistory adapts a loader of current rates, for backends that keep
+// no history: the history of an address is the single record loader
+// returns.
+func CurrentHistory(loader RateLoaderFuncCtx) HistoryLoaderFuncCtx {
+	return func(ctx context.Context, key string) (RateHistory, error) {
+		rate, err := loader(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return RateHistory{rate}, nil
+	}
+}
+
+// GetRateAsOf returns the cached rate of key that was in effect at t. It
+// returns ErrNotFound if key is not cached and ErrNotInEffect if no record is
+// in effect at t.
+func (c *HistoryCache) GetRateAsOf(key string, t time.Time) (TaxRate, error) {
+	return c.GetRateAsOfCtx(context.Background(), key, t, nil)
+}
+
+// GetRateAsOfCtx is GetRateAsOf calling loader for the history of key on a
+// cache miss; see lrucache.LRUCache.GetOrLoadCtx. A nil loader makes it
+// cache-only.
+func (c *HistoryCache) GetRateAsOfCtx(ctx context.Context, key string, t time.Time, loader HistoryLoaderFuncCtx) (TaxRate, error) {
+	var history RateHistory
+	var err error
+	if loader == nil {
+		history, err = c.Lookup(key)
+	} else {
+		history, err = c.GetOrLoadCtx(ctx, key, loader)
+	}
+	if err != nil {
+		return TaxRate{}, err
+	}
+	rate, ok := history.At(t)
+	if !ok {
+		return TaxRate{}, fmt.Errorf("%s at %s: %w", key, t.Format(time.RFC3339), ErrNotInEffect)
+	}
+	return rate, nil
+}
+
+// AddRecord adds rate to the cached history of key, replacing a record of
+// the same EffectiveFrom. If no history of key is cached it becomes the
+// only record, until the history is evicted or deleted.
+func (c *HistoryCache) AddRecord(key string, rate TaxRate) error {
+	for {
+		history, version, err := c.GetWithVersion(key)
+		if err != nil {
+			history, version = nil, 0
+		}
+		err = c.InsertIfVersion(key, history.Add(rate), version)
+		if !errors.Is(err, ErrVersionMismatch) {
+			return err
+		}
+	}
+}
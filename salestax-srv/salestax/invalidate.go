@@ -0,0 +1,99 @@
+package salestax
+
+import (
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// InvalidateByPrefix removes every rate whose address starts with prefix,
+// from the second tier as well, and returns the number of rates removed
+// from this cache. Unlike DeletePrefix of Cache it takes the keys out of a
+// shared second tier too, so that other instances do not read them back.
+func (c *RateCache) InvalidateByPrefix(prefix string) int {
+	return invalidate(c.LRUCache, func(key string, _ TaxRate) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// InvalidateByJurisdiction removes every rate with a component of the
+// jurisdiction of level and code, e.g. after it published a rate change,
+// and returns the number of rates removed. code is matched against the Code
+// of components, or their Name if they have none, ignoring case; an empty
+// level matches any.
+func (c *RateCache) InvalidateByJurisdiction(level, code string) int {
+	return invalidate(c.LRUCache, func(_ string, rate TaxRate) bool {
+		return rate.levies(level, code)
+	})
+}
+
+// InvalidateByPrefix is RateCache.InvalidateByPrefix for histories.
+func (c *HistoryCache) InvalidateByPrefix(prefix string) int {
+	return invalidate(c.LRUCache, func(key string, _ RateHistory) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// InvalidateByJurisdiction is RateCache.InvalidateByJurisdiction for
+// histories: it removes those with any record of a component of the
+// jurisdiction.
+func (c *HistoryCache) InvalidateByJurisdiction(level, code string) int {
+	return invalidate(c.LRUCache, func(_ string, h RateHistory) bool {
+		for _, rate := range h {
+			if rate.levies(level, code) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// levies reports whether a component of r is of the jurisdiction of level
+// and code; see InvalidateByJurisdiction.
+func (r TaxRate) levies(level, code string) bool {
+	for _, comp := range r.Components {
+		id := comp.Code
+		if id == "" {
+			id = comp.Name
+		}
+		if (level == "" || comp.Level == level) && strings.EqualFold(id, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// funcDeleter is a second tier that removes the entries that match, such as
+// disktier.Tier. Invalidations reach the entries it holds that are not in
+// the cache as well; those of a shared tier like Redis are only removed if
+// they are.
+type funcDeleter[V any] interface {
+	DeleteFunc(match func(string, V) bool) (int, error)
+}
+
+// invalidate deletes the items of c that match, then those of its second
+// tier if it is a funcDeleter. The keys are collected first, as Delete
+// cannot be called from DeleteFunc, so an item inserted meanwhile is kept;
+// it was loaded after the change being invalidated. A failure of the
+// second tier leaves its entries until they expire.
+func invalidate[V any](c *lrucache.LRUCache[string, V], match func(string, V) bool) int {
+	var keys []string
+	c.Range(func(key string, value V) bool {
+		if match(key, value) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	n := 0
+	for _, key := range keys {
+		if c.Delete(key) {
+			n++
+		}
+	}
+	if l2, ok := c.SecondTier().(funcDeleter[V]); ok {
+		if removed, err := l2.DeleteFunc(match); err == nil {
+			n += removed
+		}
+	}
+	return n
+}
@@ -0,0 +1,48 @@
+package salestax
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// hashSize is the number of bytes of the HMAC kept in a hashed key.
+const hashSize = 16
+
+// HashKeys returns a KeyCanonicalizer caching addresses under their salted
+// hash, the HMAC-SHA256 keyed with salt, so that neither the cache nor its
+// snapshots, write-ahead log, second tier, metrics or debug endpoints hold
+// the addresses themselves. The loader is still called with the address.
+//
+// The prefix of a key up to its first colon, the state or ZIP of
+// "TX:1 Congress Ave", is kept in clear, so that InvalidateByPrefix still
+// removes the rates of a state or a ZIP; longer prefixes match nothing.
+// Hashing a hashed key returns it unchanged, as lrucache canonicalizes the
+// keys of Keys and Range again when they are deleted. Instances sharing a
+// second tier or snapshots must share salt.
+func HashKeys(salt []byte) KeyCanonicalizerFunc {
+	return func(_ context.Context, key string) (string, error) {
+		i := strings.IndexByte(key, ':') + 1
+		if isHash(key[i:]) {
+			return key, nil
+		}
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(key))
+		return key[:i] + "#" + hex.EncodeToString(mac.Sum(nil)[:hashSize]), nil
+	}
+}
+
+// isHash reports whether s is a hash of HashKeys.
+func isHash(s string) bool {
+	if len(s) != 1+2*hashSize || s[0] != '#' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+// Package salestax is a thin wrapper around lrucache that keeps the original
+// string address -> float64 tax rate API.
+package salestax
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Cache is an LRU cache of tax rates keyed by street address.
+type Cache struct {
+	*lrucache.LRUCache[string, float64]
+}
+
+// LoaderFunc is a function that matches the signiture of sales_tax_lookup.
+type LoaderFunc = lrucache.LoaderFunc[string, float64]
+
+// LoaderFuncCtx is a LoaderFunc that honors the caller's context.
+type LoaderFuncCtx = lrucache.LoaderFuncCtx[string, float64]
+
+// LoaderFuncTTL is a LoaderFunc that also returns how long its rate is
+// cached, e.g. shorter for a special event district; see
+// lrucache.LoaderFuncTTL.
+type LoaderFuncTTL = lrucache.LoaderFuncTTL[string, float64]
+
+// LoaderFuncTTLCtx is a LoaderFuncTTL that honors the caller's context.
+type LoaderFuncTTLCtx = lrucache.LoaderFuncTTLCtx[string, float64]
+
+// BatchLoaderFunc resolves the rates of several addresses in one call.
+type BatchLoaderFunc = lrucache.BatchLoaderFunc[string, float64]
+
+// BatchLoaderFuncCtx is a BatchLoaderFunc that honors the caller's context.
+type BatchLoaderFuncCtx = lrucache.BatchLoaderFuncCtx[string, float64]
+
+// Store is a persistent rate store the cache writes updates to.
+type Store = lrucache.Store[string, float64]
+
+// SecondTier is a shared rate cache consulted before the loader.
+type SecondTier = lrucache.SecondTier[string, float64]
+
+// KeyCanonicalizer maps the addresses of a tax jurisdiction to one cache
+// key, e.g. its FIPS code; see lrucache.WithKeyCanonicalizer.
+type KeyCanonicalizer = lrucache.KeyCanonicalizer[string]
+
+// KeyCanonicalizerFunc is a function implementing KeyCanonicalizer.
+type KeyCanonicalizerFunc = lrucache.KeyCanonicalizerFunc[string]
+
+// Errors returned by Cache, see the lrucache errors of the same name.
+var (
+	ErrNotFound           = lrucache.ErrNotFound
+	ErrExpired            = lrucache.ErrExpired
+	ErrLoaderFailed       = lrucache.ErrLoaderFailed
+	ErrThrottled          = lrucache.ErrThrottled
+	ErrBackendUnavailable = lrucache.ErrBackendUnavailable
+	ErrVersionMismatch    = lrucache.ErrVersionMismatch
+)
+
+// New returns a pointer to an initialized tax rate Cache.
+func New(sz int, opts ...lrucache.Option) *Cache {
+	return &Cache{lrucache.New[string, float64](sz, opts...)}
+}
+
+// GetOrLoad returns the tax rate for key, calling loader on a cache miss; see
+// lrucache.LRUCache.GetOrLoad. Unlike the generic version, NaN is returned
+// alongside any error, so a failed lookup can't pass for a 0% rate.
+func (c *Cache) GetOrLoad(key string, loader LoaderFunc) (float64, error) {
+	return c.GetOrLoadCtx(context.Background(), key, loader.WithContext())
+}
+
+// FastRateLookup is the original name of GetOrLoad.
+//
+// Deprecated: Use GetOrLoad.
+func (c *Cache) FastRateLookup(key string, loader LoaderFunc) (float64, error) {
+	return c.GetOrLoad(key, loader)
+}
+
+// GetWithVersion returns the cached tax rate for key and its version, for
+// InsertIfVersion; see lrucache.LRUCache.GetWithVersion. NaN is returned
+// alongside any error.
+func (c *Cache) GetWithVersion(key string) (float64, uint64, error) {
+	rate, version, err := c.LRUCache.GetWithVersion(key)
+	if err != nil {
+		return math.NaN(), 0, err
+	}
+	return rate, version, nil
+}
+
+// Lookup returns the tax rate for key using the loader given to New via
+// lrucache.WithLoader. NaN is returned alongside any error.
+func (c *Cache) Lookup(key string) (float64, error) {
+	return c.LookupCtx(context.Background(), key)
+}
+
+// LookupCtx is Lookup with a context passed to the loader.
+func (c *Cache) LookupCtx(ctx context.Context, key string) (float64, error) {
+	rate, err := c.LRUCache.LookupCtx(ctx, key)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return rate, nil
+}
+
+// GetOrLoadCtx is GetOrLoad with a context passed to the loader.
+func (c *Cache) GetOrLoadCtx(ctx context.Context, key string, loader LoaderFuncCtx) (float64, error) {
+	rate, err := c.LRUCache.GetOrLoadCtx(ctx, key, loader)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return rate, nil
+}
+
+// GetOrLoadTTL is GetOrLoad with a loader choosing how long each rate it
+// loads is cached. NaN is returned alongside any error.
+func (c *Cache) GetOrLoadTTL(key string, loader LoaderFuncTTL) (float64, error) {
+	return c.GetOrLoadTTLCtx(context.Background(), key, loader.WithContext())
+}
+
+// GetOrLoadTTLCtx is GetOrLoadTTL with a context passed to the loader.
+func (c *Cache) GetOrLoadTTLCtx(ctx context.Context, key string, loader LoaderFuncTTLCtx) (float64, error) {
+	return c.GetOrLoadCtx(ctx, key, loader.Loader())
+}
+
+// FastRateLookupCtx is the original name of GetOrLoadCtx.
+//
+// Deprecated: Use GetOrLoadCtx.
+func (c *Cache) FastRateLookupCtx(ctx context.Context, key string, loader LoaderFuncCtx) (float64, error) {
+	return c.GetOrLoadCtx(ctx, key, loader)
+}
+
+// DeletePrefix removes every address starting with prefix, e.g. all addresses
+// in a ZIP when keys are of the form "ZIP:street". It returns the number of
+// rates removed.
+func (c *Cache) DeletePrefix(prefix string) int {
+	return c.DeleteFunc(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
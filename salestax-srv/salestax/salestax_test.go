@@ -0,0 +1,399 @@
+package salestax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+func TestGetOrLoadNaNOnError(t *testing.T) {
+	c := New(10)
+	errBackend := errors.New("backend down")
+	rate, err := c.GetOrLoad("1 Main St", func(string) (float64, error) {
+		return 0, errBackend
+	})
+	if !math.IsNaN(rate) {
+		t.Errorf("rate = %v, want NaN", rate)
+	}
+	if !errors.Is(err, ErrLoaderFailed) || !errors.Is(err, errBackend) {
+		t.Errorf("err = %v, want it to wrap ErrLoaderFailed and the loader error", err)
+	}
+
+	rate, err = c.GetOrLoad("1 Main St", func(string) (float64, error) {
+		return 0.0725, nil
+	})
+	if err != nil || rate != 0.0725 {
+		t.Errorf("GetOrLoad = %v, %v, want 0.0725, nil", rate, err)
+	}
+}
+
+func TestTaxRate(t *testing.T) {
+	r := TaxRate{
+		Components: []Component{
+			{Level: State, Code: "06", Rate: 0.06},
+			{Level: County, Code: "06037", Rate: 0.0025},
+			{Level: Special, Name: "Metro transit", Rate: 0.01},
+		},
+		EffectiveFrom:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		EffectiveUntil: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if got := r.Total(); math.Abs(got-0.0725) > 1e-12 {
+		t.Errorf("Total = %v, want 0.0725", got)
+	}
+	if got := (TaxRate{}).Total(); !math.IsNaN(got) {
+		t.Errorf("Total of no components = %v, want NaN", got)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{r.EffectiveFrom.Add(-time.Second), false},
+		{r.EffectiveFrom, true},
+		{r.EffectiveUntil.Add(-time.Second), true},
+		{r.EffectiveUntil, false},
+	} {
+		if got := r.EffectiveAt(tt.at); got != tt.want {
+			t.Errorf("EffectiveAt(%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if !Flat(0).EffectiveAt(time.Now()) || Flat(0).Total() != 0 {
+		t.Error("Flat(0) is not an open ended 0% rate")
+	}
+}
+
+func TestRateCache(t *testing.T) {
+	c := NewRateCache(10)
+	errBackend := errors.New("backend down")
+	rate, err := c.Rate(context.Background(), "1 Main St", func(context.Context, string) (TaxRate, error) {
+		return TaxRate{}, errBackend
+	})
+	if !math.IsNaN(rate) || !errors.Is(err, errBackend) {
+		t.Errorf("Rate = %v, %v, want NaN and the loader error", rate, err)
+	}
+	c.Insert("1 Main St", TaxRate{Components: []Component{{Level: State, Rate: 0.05}, {Level: City, Rate: 0.02}}})
+	rate, err = c.Rate(context.Background(), "1 Main St", nil)
+	if err != nil || math.Abs(rate-0.07) > 1e-12 {
+		t.Errorf("Rate = %v, %v, want 0.07", rate, err)
+	}
+	if n := c.WarmRates([]Rate{{"2 Oak St", 0.08}}); n != 1 {
+		t.Errorf("WarmRates = %d, want 1", n)
+	}
+	if item, err := c.Get("2 Oak St"); err != nil || item.Value().Total() != 0.08 {
+		t.Errorf("warmed rate = %v, %v, want Flat(0.08)", item, err)
+	}
+}
+
+func TestRateHistory(t *testing.T) {
+	jan := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	jul := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	old := Flat(0.07)
+	raised := Flat(0.0725)
+	raised.EffectiveFrom = jul
+	holiday := Flat(0.05)
+	holiday.EffectiveFrom, holiday.EffectiveUntil = jan, jan.AddDate(0, 0, 7)
+
+	h := RateHistory{}.Add(raised).Add(old).Add(holiday)
+	if len(h) != 3 || !h[0].EffectiveFrom.IsZero() || h[1].EffectiveFrom != jan || h[2].EffectiveFrom != jul {
+		t.Fatalf("history out of order: %+v", h)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		want float64
+	}{
+		{jan.AddDate(-1, 0, 0), 0.07},
+		{jan.AddDate(0, 0, 3), 0.05},
+		{jan.AddDate(0, 1, 0), 0.07},
+		{jul, 0.0725},
+	} {
+		if rate, ok := h.At(tt.at); !ok || rate.Total() != tt.want {
+			t.Errorf("At(%v) = %v, %v, want %v", tt.at, rate.Total(), ok, tt.want)
+		}
+	}
+
+	replaced := h.Add(Flat(0.06))
+	if len(replaced) != 3 || replaced[0].Total() != 0.06 || h[0].Total() != 0.07 {
+		t.Errorf("Add of the same EffectiveFrom = %+v, original %+v", replaced, h)
+	}
+	if _, ok := (RateHistory{raised}).At(jan); ok {
+		t.Error("At before the first record succeeded")
+	}
+}
+
+func TestHistoryCache(t *testing.T) {
+	c := NewHistoryCache(10)
+	jul := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := c.GetRateAsOf("1 Main St", jul); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRateAsOf of a missing key: %v", err)
+	}
+
+	loads := 0
+	loader := CurrentHistory(func(context.Context, string) (TaxRate, error) {
+		loads++
+		rate := Flat(0.0725)
+		rate.EffectiveFrom = jul
+		return rate, nil
+	})
+	ctx := context.Background()
+	if rate, err := c.GetRateAsOfCtx(ctx, "1 Main St", jul.AddDate(0, 1, 0), loader); err != nil || rate.Total() != 0.0725 {
+		t.Errorf("GetRateAsOfCtx = %v, %v", rate, err)
+	}
+	_, err := c.GetRateAsOfCtx(ctx, "1 Main St", jul.AddDate(0, -1, 0), loader)
+	if !errors.Is(err, ErrNotInEffect) || !errors.Is(err, ErrNotFound) || loads != 1 {
+		t.Errorf("GetRateAsOfCtx before the history = %v after %d loads", err, loads)
+	}
+
+	if err := c.AddRecord("1 Main St", Flat(0.07)); err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := c.GetRateAsOf("1 Main St", jul.AddDate(0, -1, 0)); err != nil || rate.Total() != 0.07 {
+		t.Errorf("GetRateAsOf after AddRecord = %v, %v", rate, err)
+	}
+	if rate, err := c.GetRateAsOf("1 Main St", jul); err != nil || rate.Total() != 0.0725 {
+		t.Errorf("GetRateAsOf of the later record = %v, %v", rate, err)
+	}
+	if err := c.AddRecord("2 Oak St", Flat(0.08)); err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := c.GetRateAsOf("2 Oak St", jul); err != nil || rate.Total() != 0.08 {
+		t.Errorf("GetRateAsOf of an added history = %v, %v", rate, err)
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	// 6.25% state, 1% county, 2.25% district: 9.5% combined
+	la := TaxRate{Components: []Component{{Level: State, Rate: 0.0625}, {Level: County, Rate: 0.01}, {Level: Special, Rate: 0.0225}}}
+	tests := []struct {
+		name   string
+		rate   TaxRate
+		amount float64
+		r      Rounding
+		tax    float64
+		lines  []float64
+	}{
+		{"half up", Flat(0.0725), 10, DefaultRounding, 0.73, []float64{0.73}},
+		{"half even", Flat(0.0725), 10, Rounding{Mode: RoundHalfEven, Precision: 2}, 0.72, []float64{0.72}},
+		{"half even up", Flat(0.0735), 10, Rounding{Mode: RoundHalfEven, Precision: 2}, 0.74, []float64{0.74}},
+		{"total", la, 9.99, DefaultRounding, 0.95, []float64{0.63, 0.10, 0.22}},
+		{"per line", la, 9.99, Rounding{Precision: 2, PerLine: true}, 0.94, []float64{0.62, 0.10, 0.22}},
+		{"whole units", Flat(0.2), 12.5, Rounding{Precision: 0}, 3, []float64{3}},
+		{"refund", Flat(0.0725), -10, DefaultRounding, -0.73, []float64{-0.73}},
+	}
+	for _, tt := range tests {
+		calc, err := Calculate(tt.rate, tt.amount, tt.r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if calc.Tax != tt.tax || math.Abs(calc.Total-(tt.amount+tt.tax)) > 1e-9 {
+			t.Errorf("%s: tax %v total %v, want %v", tt.name, calc.Tax, calc.Total, tt.tax)
+		}
+		sum := 0.0
+		for i, line := range calc.Lines {
+			sum += line.Tax
+			if line.Tax != tt.lines[i] || line.Component != tt.rate.Components[i] {
+				t.Errorf("%s: line %d = %+v, want tax %v", tt.name, i, line, tt.lines[i])
+			}
+		}
+		if math.Abs(sum-calc.Tax) > 1e-9 {
+			t.Errorf("%s: lines add up to %v, not %v", tt.name, sum, calc.Tax)
+		}
+	}
+
+	if _, err := Calculate(Flat(0.07), 10, Rounding{Precision: MaxPrecision + 1}); err == nil {
+		t.Error("Calculate with too fine a precision succeeded")
+	}
+	if _, err := Calculate(TaxRate{}, 10, DefaultRounding); err == nil {
+		t.Error("Calculate without components succeeded")
+	}
+}
+
+func TestCalculateTax(t *testing.T) {
+	c := NewRateCache(10)
+	c.Insert("FR|reduced", TaxRate{Country: "FR", Category: "reduced", Components: []Component{{Rate: 0.055}}})
+	calc, err := c.CalculateTax(context.Background(), "FR", 20, "reduced", nil, DefaultRounding)
+	if err != nil || calc.Tax != 1.1 || calc.Category != "reduced" {
+		t.Errorf("CalculateTax = %+v, %v", calc, err)
+	}
+	if _, err := c.CalculateTax(context.Background(), "FR", 20, "standard", nil, DefaultRounding); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CalculateTax of an uncached rate: %v", err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := NewRateCache(10)
+	tx := TaxRate{Components: []Component{{Level: State, Code: "48", Rate: 0.0625}, {Level: City, Name: "Austin", Rate: 0.01}}}
+	ca := TaxRate{Components: []Component{{Level: State, Code: "06", Rate: 0.0725}}}
+	c.Insert("TX:1 Congress Ave", tx)
+	c.Insert("TX:2 Congress Ave", tx)
+	c.Insert("CA:1 Main St", ca)
+
+	if n := c.InvalidateByJurisdiction(State, "06"); n != 1 {
+		t.Errorf("InvalidateByJurisdiction(state, 06) = %d, want 1", n)
+	}
+	if n := c.InvalidateByJurisdiction(County, "austin"); n != 0 {
+		t.Errorf("InvalidateByJurisdiction(county, austin) = %d, want 0", n)
+	}
+	if n := c.InvalidateByJurisdiction("", "austin"); n != 2 {
+		t.Errorf("InvalidateByJurisdiction(\"\", austin) = %d, want 2", n)
+	}
+	if c.Len() != 0 {
+		t.Errorf("%d rates left, want 0", c.Len())
+	}
+
+	c.Insert("TX:1 Congress Ave", tx)
+	c.Insert("CA:1 Main St", ca)
+	if n := c.InvalidateByPrefix("TX:"); n != 1 {
+		t.Errorf("InvalidateByPrefix(TX:) = %d, want 1", n)
+	}
+	if _, err := c.Lookup("CA:1 Main St"); err != nil {
+		t.Errorf("CA rate invalidated: %v", err)
+	}
+
+	h := NewHistoryCache(10)
+	h.AddRecord("TX:1 Congress Ave", ca)
+	h.AddRecord("TX:1 Congress Ave", TaxRate{Components: tx.Components, EffectiveFrom: time.Now()})
+	if n := h.InvalidateByJurisdiction(State, "48"); n != 1 {
+		t.Errorf("HistoryCache.InvalidateByJurisdiction(state, 48) = %d, want 1", n)
+	}
+}
+
+// mapTier is a second tier in a map that deletes the entries matching a
+// function, like disktier.
+type mapTier map[string]TaxRate
+
+func (m mapTier) Get(_ context.Context, key string) (TaxRate, bool, error) {
+	rate, ok := m[key]
+	return rate, ok, nil
+}
+
+func (m mapTier) Set(_ context.Context, key string, rate TaxRate, _ time.Duration) error {
+	m[key] = rate
+	return nil
+}
+
+func (m mapTier) Delete(_ context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m mapTier) DeleteFunc(match func(string, TaxRate) bool) (int, error) {
+	n := 0
+	for key, rate := range m {
+		if match(key, rate) {
+			delete(m, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestInvalidateSecondTier(t *testing.T) {
+	l2 := mapTier{}
+	c := NewRateCache(1, lrucache.WithSecondTier[string, TaxRate](l2))
+	c.Insert("TX:1 Congress Ave", Flat(0.0825))
+	c.Insert("TX:2 Congress Ave", Flat(0.0825)) // only left in the tier
+	c.Insert("CA:1 Main St", Flat(0.0725))
+	if n := c.InvalidateByPrefix("TX:"); n != 2 {
+		t.Errorf("InvalidateByPrefix(TX:) = %d, want 2", n)
+	}
+	if len(l2) != 1 {
+		t.Errorf("second tier holds %d rates, want 1", len(l2))
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+	c := NewRateCache(10, lrucache.WithKeyNormalizer(strings.ToUpper))
+	var calls atomic.Int32
+	release := make(chan struct{})
+	co := NewCoalescer(c, func(ctx context.Context, key string) (TaxRate, error) {
+		calls.Add(1)
+		<-release
+		return Flat(0.0725), nil
+	})
+
+	const n = 8
+	errs := make(chan error, n)
+	for i := range n {
+		address := "1 Main St"
+		if i%2 == 1 {
+			address = "1 MAIN ST"
+		}
+		go func() {
+			rate, err := co.Lookup(context.Background(), address)
+			if err == nil && rate.Total() != 0.0725 {
+				err = fmt.Errorf("rate %v", rate.Total())
+			}
+			errs <- err
+		}()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := co.Lookup(ctx, "1 main st")
+		errs <- err
+	}()
+	for co.Shared() < n {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Lookup: %v, want context.Canceled", err)
+	}
+	close(release)
+	for range n {
+		if err := <-errs; err != nil {
+			t.Errorf("Lookup: %v", err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("%d loader calls, want 1", got)
+	}
+
+	if _, err := NewCoalescer(c, nil).Lookup(context.Background(), "2 Oak St"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("cache-only Lookup of a miss: %v, want ErrNotFound", err)
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	var loaded []string
+	c := NewRateCache(10, lrucache.WithKeyCanonicalizer[string](HashKeys([]byte("salt"))))
+	loader := func(_ context.Context, address string) (TaxRate, error) {
+		loaded = append(loaded, address)
+		return Flat(0.0825), nil
+	}
+	if _, err := c.GetOrLoadCtx(context.Background(), "TX:1 Congress Ave", loader); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0] != "TX:1 Congress Ave" {
+		t.Errorf("loader called with %q, want the address", loaded)
+	}
+	c.Insert("CA:1 Main St", Flat(0.0725))
+	for _, key := range c.Keys() {
+		if strings.Contains(key, "Congress") || strings.Contains(key, "Main") {
+			t.Errorf("key %q holds the address", key)
+		}
+	}
+	if item, err := c.Get("CA:1 Main St"); err != nil || item.Value().Total() != 0.0725 {
+		t.Errorf("CA:1 Main St = %v, %v", item, err)
+	}
+
+	other := NewRateCache(10, lrucache.WithKeyCanonicalizer[string](HashKeys([]byte("other"))))
+	other.Insert("CA:1 Main St", Flat(0.0725))
+	if slices.Contains(c.Keys(), other.Keys()[0]) {
+		t.Error("keys hashed alike with another salt")
+	}
+
+	if n := c.InvalidateByPrefix("TX:"); n != 1 {
+		t.Errorf("InvalidateByPrefix(TX:) = %d, want 1", n)
+	}
+	if !c.Delete("CA:1 Main St") || c.Len() != 0 {
+		t.Errorf("%d rates left after deleting the last, want 0", c.Len())
+	}
+}
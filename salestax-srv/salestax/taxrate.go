@@ -0,0 +1,96 @@
+package salestax
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// Jurisdiction levels of a Component.
+const (
+	State   = "state"
+	County  = "county"
+	City    = "city"
+	Special = "special" // transit, stadium and other special purpose districts
+
+	National = "national" // e.g. EU VAT, Canadian GST and HST
+	Province = "province" // e.g. Canadian PST and QST
+)
+
+// Component is the share of a TaxRate levied by one jurisdiction.
+type Component struct {
+	Level string  `json:"level,omitempty"` // one of the levels above, or "" if unknown
+	Code  string  `json:"code,omitempty"`  // e.g. the FIPS code of the jurisdiction
+	Name  string  `json:"name,omitempty"`
+	Rate  float64 `json:"rate"`
+}
+
+// TaxRate is the sales tax of an address broken down by jurisdiction, and
+// the period it is in effect. A zero EffectiveFrom or EffectiveUntil leaves
+// that end of the period open. Country and Category are set by the sources
+// of international rates: the ISO 3166 country code, and the category of
+// goods the rate applies to where it has reduced rates, as EU VAT does.
+type TaxRate struct {
+	Country        string      `json:"country,omitempty"`
+	Category       string      `json:"category,omitempty"`
+	Components     []Component `json:"components"`
+	EffectiveFrom  time.Time   `json:"effective_from,omitzero"`
+	EffectiveUntil time.Time   `json:"effective_until,omitzero"` // exclusive
+}
+
+// Flat returns a TaxRate of a single component of unknown jurisdiction, for
+// sources that only know the combined rate.
+func Flat(rate float64) TaxRate {
+	return TaxRate{Components: []Component{{Rate: rate}}}
+}
+
+// Total returns the combined rate of all components. It is NaN for a rate
+// without components, such as the zero TaxRate returned with an error, so a
+// failed lookup can't pass for a 0% rate.
+func (r TaxRate) Total() float64 {
+	if len(r.Components) == 0 {
+		return math.NaN()
+	}
+	total := 0.0
+	for _, c := range r.Components {
+		total += c.Rate
+	}
+	return total
+}
+
+// EffectiveAt reports whether r is in effect at t.
+func (r TaxRate) EffectiveAt(t time.Time) bool {
+	return !t.Before(r.EffectiveFrom) && (r.EffectiveUntil.IsZero() || t.Before(r.EffectiveUntil))
+}
+
+// RateCache is an LRU cache of tax rate breakdowns keyed by street address.
+// Cache is its counterpart for combined rates only.
+type RateCache struct {
+	*lrucache.LRUCache[string, TaxRate]
+}
+
+// RateLoaderFunc resolves the tax rate breakdown of an address.
+type RateLoaderFunc = lrucache.LoaderFunc[string, TaxRate]
+
+// RateLoaderFuncCtx is a RateLoaderFunc that honors the caller's context.
+type RateLoaderFuncCtx = lrucache.LoaderFuncCtx[string, TaxRate]
+
+// RateSecondTier is a shared breakdown cache consulted before the loader.
+type RateSecondTier = lrucache.SecondTier[string, TaxRate]
+
+// NewRateCache returns a pointer to an initialized RateCache.
+func NewRateCache(sz int, opts ...lrucache.Option) *RateCache {
+	return &RateCache{lrucache.New[string, TaxRate](sz, opts...)}
+}
+
+// Rate returns the combined rate for key, calling loader on a cache miss;
+// see lrucache.LRUCache.GetOrLoadCtx. NaN is returned alongside any error.
+func (c *RateCache) Rate(ctx context.Context, key string, loader RateLoaderFuncCtx) (float64, error) {
+	rate, err := c.GetOrLoadCtx(ctx, key, loader)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return rate.Total(), nil
+}
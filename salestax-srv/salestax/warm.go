@@ -0,0 +1,121 @@
+package salestax
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Rate is one address -> rate pair of a warm file.
+type Rate struct {
+	Address string  `json:"address"`
+	Rate    float64 `json:"rate"`
+}
+
+// WarmFromCSV pre-populates the cache from a CSV file of "address,rate" rows,
+// e.g. the top N addresses or ZIP -> rate mappings exported from the rate
+// database. A header row is skipped. Rows are expected most important
+// first; if the file holds more rows than fit, the last rows are the ones
+// dropped. It returns the number of rates loaded.
+func (c *Cache) WarmFromCSV(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	rates, err := ReadCSV(f)
+	if err != nil {
+		return 0, err
+	}
+	return c.WarmRates(rates), nil
+}
+
+// WarmFromJSON pre-populates the cache from JSON in the format read by
+// ReadJSON. It returns the number of rates loaded.
+func (c *Cache) WarmFromJSON(r io.Reader) (int, error) {
+	rates, err := ReadJSON(r)
+	if err != nil {
+		return 0, err
+	}
+	return c.WarmRates(rates), nil
+}
+
+// ReadCSV parses "address,rate" rows as accepted by WarmFromCSV.
+func ReadCSV(r io.Reader) ([]Rate, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	var rates []Rate
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rates, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, errors.New("Invalid rate in warm file on line " + strconv.Itoa(line))
+		}
+		rates = append(rates, Rate{rec[0], value})
+	}
+}
+
+// ReadJSON parses either an object mapping addresses to rates or an array of
+// {"address": ..., "rate": ...} objects listed most important first.
+func ReadJSON(r io.Reader) ([]Rate, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var rates []Rate
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var m map[string]float64
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		for address, value := range m {
+			rates = append(rates, Rate{address, value})
+		}
+		return rates, nil
+	}
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// WarmRates inserts rates, listed most important first, and returns the
+// number inserted. They are inserted in reverse, so that the first ones end
+// up most recently used and survive if not all of them fit.
+func (c *Cache) WarmRates(rates []Rate) int {
+	return c.Warm(func(yield func(string, float64) bool) {
+		for _, r := range slices.Backward(rates) {
+			if !yield(r.Address, r.Rate) {
+				return
+			}
+		}
+	})
+}
+
+// WarmRates is Cache.WarmRates for rate breakdowns: each rate is inserted as
+// Flat(rate).
+func (c *RateCache) WarmRates(rates []Rate) int {
+	return c.Warm(func(yield func(string, TaxRate) bool) {
+		for _, r := range slices.Backward(rates) {
+			if !yield(r.Address, Flat(r.Rate)) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Package seal encrypts what the server persists, the snapshots and their
+// uploads, the WAL segments and the disk tier, with AES-256-GCM: addresses
+// are personal data under some compliance regimes.
+//
+// A Cipher encrypts with its primary key and decrypts with any of its keys,
+// so that data written with a former key stays readable once the key is
+// rotated. Keys come from the configuration, or wrapped by a key management
+// service, which a KMS unwraps at startup.
+package seal
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// KeySize is the size of the keys, those of AES-256.
+const KeySize = 32
+
+// idSize is the size of the key IDs starting every sealed message.
+const idSize = 8
+
+// Errors of Open, for use with errors.Is.
+var (
+	// ErrUnknownKey is returned for data sealed with a key the Cipher does
+	// not have.
+	ErrUnknownKey = errors.New("seal: sealed with an unknown key")
+	// ErrAuth is returned for data that was altered or is not sealed.
+	ErrAuth = errors.New("seal: message authentication failed")
+)
+
+type key struct {
+	id   [idSize]byte
+	aead cipher.AEAD
+	mac  []byte // key of MAC, derived from the key
+}
+
+// Cipher seals and opens data. It is safe for concurrent use.
+type Cipher struct {
+	primary *key
+	keys    map[[idSize]byte]*key
+}
+
+// New returns a Cipher sealing with primary and opening with primary and
+// the former keys old, all of KeySize bytes.
+func New(primary []byte, old ...[]byte) (*Cipher, error) {
+	c := &Cipher{keys: make(map[[idSize]byte]*key)}
+	for i, k := range append([][]byte{primary}, old...) {
+		if len(k) != KeySize {
+			return nil, fmt.Errorf("seal: key of %d bytes, want %d", len(k), KeySize)
+		}
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := derive(k, "id")
+		kk := &key{aead: aead, mac: derive(k, "mac")}
+		copy(kk.id[:], sum)
+		if i == 0 {
+			c.primary = kk
+		}
+		c.keys[kk.id] = kk
+	}
+	return c, nil
+}
+
+// derive returns the key of purpose derived from k.
+func derive(k []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, k)
+	h.Write([]byte("salestax-srv seal " + purpose))
+	return h.Sum(nil)
+}
+
+// ID returns the ID of the primary key, which identifies the data it sealed
+// without revealing the key.
+func (c *Cipher) ID() []byte {
+	return bytes.Clone(c.primary.id[:])
+}
+
+// Overhead is the number of bytes Seal adds to the data.
+func (c *Cipher) Overhead() int {
+	return idSize + c.primary.aead.NonceSize() + c.primary.aead.Overhead()
+}
+
+// Seal returns data encrypted and authenticated together with ad, which is
+// not encrypted but must be given to Open again, e.g. the key of a value so
+// that values cannot be swapped.
+func (c *Cipher) Seal(data, ad []byte) []byte {
+	k := c.primary
+	out := make([]byte, idSize+k.aead.NonceSize(), c.Overhead()+len(data))
+	copy(out, k.id[:])
+	nonce := out[idSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand does not fail on the supported systems
+	}
+	return k.aead.Seal(out, nonce, data, ad)
+}
+
+// Open returns the data of sealed, sealed by Seal with ad.
+func (c *Cipher) Open(sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < idSize {
+		return nil, ErrAuth
+	}
+	k, ok := c.keys[[idSize]byte(sealed[:idSize])]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	sealed = sealed[idSize:]
+	if len(sealed) < k.aead.NonceSize() {
+		return nil, ErrAuth
+	}
+	data, err := k.aead.Open(nil, sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():], ad)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return data, nil
+}
+
+// MAC returns a keyed hash of data with the primary key, e.g. to store
+// values under a name that does not reveal their key.
+func (c *Cipher) MAC(data []byte) []byte {
+	h := hmac.New(sha256.New, c.primary.mac)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// KMS unwraps keys encrypted by a key management service, e.g. AWS KMS or
+// Google Cloud KMS, so that only their wrapped form is configured.
+type KMS interface {
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Command is a KMS running a command, e.g. the CLI of the key management
+// service, with the wrapped key on its standard input. The command prints
+// the key, raw or in base64.
+type Command []string
+
+func (cmd Command) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(cmd) == 0 {
+		return nil, errors.New("seal: no KMS command")
+	}
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Stdin = bytes.NewReader(wrapped)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("seal: %s: %w: %s", cmd[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if len(out) == KeySize {
+		return out, nil
+	}
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(k) != KeySize {
+		return nil, fmt.Errorf("seal: %s printed no key of %d bytes", cmd[0], KeySize)
+	}
+	return k, nil
+}
+
+// DecodeKeys returns the keys given in base64, unwrapped with kms if not
+// nil.
+func DecodeKeys(ctx context.Context, kms KMS, encoded ...string) ([][]byte, error) {
+	keys := make([][]byte, 0, len(encoded))
+	for _, e := range encoded {
+		k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(e))
+		if err != nil {
+			return nil, fmt.Errorf("seal: key is not base64: %w", err)
+		}
+		if kms != nil {
+			if k, err = kms.Unwrap(ctx, k); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
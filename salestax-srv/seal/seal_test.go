@@ -0,0 +1,108 @@
+package seal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	k := make([]byte, KeySize)
+	rand.Read(k)
+	return k
+}
+
+func TestSeal(t *testing.T) {
+	old, primary := newKey(t), newKey(t)
+	c, err := New(primary, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := c.Seal([]byte("1 Congress Ave"), []byte("TX"))
+	if bytes.Contains(sealed, []byte("Congress")) {
+		t.Error("Seal left the data in clear")
+	}
+	if data, err := c.Open(sealed, []byte("TX")); err != nil || string(data) != "1 Congress Ave" {
+		t.Errorf("Open = %q, %v", data, err)
+	}
+	if _, err := c.Open(sealed, []byte("CA")); !errors.Is(err, ErrAuth) {
+		t.Errorf("Open with other additional data: %v, want ErrAuth", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := c.Open(sealed, []byte("TX")); !errors.Is(err, ErrAuth) {
+		t.Errorf("Open of altered data: %v, want ErrAuth", err)
+	}
+
+	// data sealed with the former key stays readable, not the other way
+	former, _ := New(old)
+	if data, err := c.Open(former.Seal([]byte("x"), nil), nil); err != nil || string(data) != "x" {
+		t.Errorf("Open of data of a former key = %q, %v", data, err)
+	}
+	if _, err := former.Open(c.Seal([]byte("x"), nil), nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open of data of an unknown key: %v, want ErrUnknownKey", err)
+	}
+	if bytes.Equal(c.MAC([]byte("x")), former.MAC([]byte("x"))) {
+		t.Error("MAC does not depend on the key")
+	}
+
+	if _, err := New([]byte("short")); err == nil {
+		t.Error("New accepted a short key")
+	}
+}
+
+func TestStream(t *testing.T) {
+	c, _ := New(newKey(t))
+	for _, size := range []int{0, 10, chunkSize, chunkSize + 1, 3*chunkSize + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+		var buf bytes.Buffer
+		w := c.NewWriter(&buf)
+		w.Write(data[:size/2])
+		w.Write(data[size/2:])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(buf.Bytes()) {
+			t.Errorf("%d bytes: stream not sealed", size)
+		}
+		stream := buf.Bytes()
+
+		r, err := c.NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: read %d bytes, %v", size, len(got), err)
+		}
+
+		// cut short, at the end of a chunk or within one
+		for _, cut := range []int{len(stream) - 1, headerSize + chunkSize + c.primary.aead.Overhead()} {
+			if cut >= len(stream) {
+				continue
+			}
+			r, err := c.NewReader(bytes.NewReader(stream[:cut]))
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if !errors.Is(err, ErrAuth) {
+				t.Errorf("%d bytes cut to %d: %v, want ErrAuth", size, cut, err)
+			}
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	k := newKey(t)
+	keys, err := DecodeKeys(context.Background(), Command{"cat"}, "  "+base64.StdEncoding.EncodeToString(k)+"\n")
+	if err != nil || len(keys) != 1 || !bytes.Equal(keys[0], k) {
+		t.Errorf("DecodeKeys with cat = %x, %v, want %x", keys, err, k)
+	}
+	if _, err := DecodeKeys(context.Background(), Command{"false"}, base64.StdEncoding.EncodeToString(k)); err == nil {
+		t.Error("DecodeKeys with a failing command did not fail")
+	}
+}
@@ -0,0 +1,179 @@
+package seal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A stream starts with streamMagic, the ID of its key and a random nonce
+// prefix, followed by chunks of up to chunkSize bytes, each sealed with the
+// nonce of the prefix, its number and whether it is the last one, so that
+// chunks can be neither reordered nor dropped, nor the stream cut short.
+const (
+	streamMagic = "\x89SEA\r\n\x1a\n"
+	prefixSize  = 7
+	headerSize  = len(streamMagic) + idSize + prefixSize
+	chunkSize   = 64 << 10
+)
+
+// IsSealed reports whether data starts like a stream written by NewWriter.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(streamMagic))
+}
+
+// MagicSize is the number of bytes IsSealed needs.
+const MagicSize = len(streamMagic)
+
+// NewWriter returns a writer sealing what is written to it into w with the
+// primary key. Close must be called to write the last chunk; it does not
+// close w.
+func (c *Cipher) NewWriter(w io.Writer) io.WriteCloser {
+	sw := &writer{w: w, k: c.primary, header: make([]byte, 0, headerSize)}
+	sw.header = append(sw.header, streamMagic...)
+	sw.header = append(sw.header, c.primary.id[:]...)
+	var prefix [prefixSize]byte
+	if _, err := rand.Read(prefix[:]); err != nil {
+		panic(err) // crypto/rand does not fail on the supported systems
+	}
+	sw.header = append(sw.header, prefix[:]...)
+	return sw
+}
+
+type writer struct {
+	w      io.Writer
+	k      *key
+	header []byte
+	wrote  bool // the header
+	buf    []byte
+	n      uint32 // number of the next chunk
+	err    error
+	closed bool
+}
+
+func (sw *writer) Write(p []byte) (int, error) {
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	sw.buf = append(sw.buf, p...)
+	// the last chunk is left for Close, even if full
+	for len(sw.buf) > chunkSize {
+		if sw.err = sw.flush(sw.buf[:chunkSize], false); sw.err != nil {
+			return 0, sw.err
+		}
+		sw.buf = append(sw.buf[:0], sw.buf[chunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (sw *writer) Close() error {
+	if sw.closed || sw.err != nil {
+		return sw.err
+	}
+	sw.closed = true
+	if err := sw.flush(sw.buf, true); err != nil {
+		sw.err = err
+		return err
+	}
+	sw.err = errors.New("seal: write after Close")
+	return nil
+}
+
+func (sw *writer) flush(chunk []byte, last bool) error {
+	if !sw.wrote {
+		if _, err := sw.w.Write(sw.header); err != nil {
+			return err
+		}
+		sw.wrote = true
+	}
+	sealed := sw.k.aead.Seal(nil, chunkNonce(sw.header, sw.n, last), chunk, sw.header)
+	sw.n++
+	_, err := sw.w.Write(sealed)
+	return err
+}
+
+// chunkNonce returns the nonce of chunk n of the stream of header.
+func chunkNonce(header []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, header[headerSize-prefixSize:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, n)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// NewReader returns a reader of the data sealed by a writer of NewWriter
+// into r, with any key of the Cipher. Reading a stream that was altered or
+// cut short fails with ErrAuth.
+func (c *Cipher) NewReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+64)
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrAuth
+		}
+		return nil, err
+	}
+	if !IsSealed(header) {
+		return nil, ErrAuth
+	}
+	k, ok := c.keys[[idSize]byte(header[len(streamMagic):])]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return &reader{r: br, k: k, header: header, chunk: make([]byte, chunkSize+k.aead.Overhead())}, nil
+}
+
+type reader struct {
+	r      *bufio.Reader
+	k      *key
+	header []byte
+	chunk  []byte
+	rest   []byte // of the data of the last chunk opened
+	n      uint32
+	done   bool
+	err    error
+}
+
+func (sr *reader) Read(p []byte) (int, error) {
+	for len(sr.rest) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.next()
+	}
+	n := copy(p, sr.rest)
+	sr.rest = sr.rest[n:]
+	return n, nil
+}
+
+// next opens the next chunk.
+func (sr *reader) next() error {
+	n, err := io.ReadFull(sr.r, sr.chunk)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF):
+		return ErrAuth // the last chunk is missing
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		_, err := sr.r.Peek(1)
+		last = errors.Is(err, io.EOF)
+	}
+	data, err := sr.k.aead.Open(sr.chunk[:0], chunkNonce(sr.header, sr.n, last), sr.chunk[:n], sr.header)
+	if err != nil {
+		return ErrAuth
+	}
+	sr.n++
+	sr.rest, sr.done = data, last
+	return nil
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/jared-d-smith/psl/salestax-srv/accesslog"
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+	"github.com/jared-d-smith/psl/salestax-srv/certreload"
+	"github.com/jared-d-smith/psl/salestax-srv/client"
+	"github.com/jared-d-smith/psl/salestax-srv/config"
+	"github.com/jared-d-smith/psl/salestax-srv/grpcserver"
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation/redisbus"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/memcacheserver"
+	"github.com/jared-d-smith/psl/salestax-srv/metrics"
+	"github.com/jared-d-smith/psl/salestax-srv/metrics/vars"
+	"github.com/jared-d-smith/psl/salestax-srv/replica"
+	"github.com/jared-d-smith/psl/salestax-srv/respserver"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/seal"
+	"github.com/jared-d-smith/psl/salestax-srv/taxability"
+	"github.com/jared-d-smith/psl/salestax-srv/tier"
+	"github.com/jared-d-smith/psl/salestax-srv/tier/disktier"
+	"github.com/jared-d-smith/psl/salestax-srv/tier/memcachetier"
+	"github.com/jared-d-smith/psl/salestax-srv/tier/redistier"
+	"github.com/jared-d-smith/psl/salestax-srv/tracing"
+	"github.com/jared-d-smith/psl/salestax-srv/wal"
+	"github.com/jared-d-smith/psl/salestax-srv/watch"
+)
+
+// runServe implements "salestax-srv serve". Settings come from the defaults,
+// the -config file, SALESTAX_* environment variables and finally the flags,
+// each overriding the previous ones.
+func runServe(args []string) error {
+	cfg, err := config.Load(configPath(args))
+	if err != nil {
+		return err
+	}
+
+	fs := newFlagSet("serve", "")
+	fs.String("config", "", "YAML configuration file")
+	registerCacheFlags(fs, &cfg.Cache)
+	registerLoaderFlags(fs, &cfg.Loader)
+	fs.StringVar(&cfg.HTTP.Addr, "http", cfg.HTTP.Addr, "HTTP listen address, also serving /metrics and /debug/vars (empty disables)")
+	fs.IntVar(&cfg.HTTP.MaxBatch, "max-batch", cfg.HTTP.MaxBatch, "addresses a POST /rates:batchGet may look up")
+	fs.BoolVar(&cfg.Watch.Enabled, "watch", cfg.Watch.Enabled, "stream rate changes as Server-Sent Events on GET /watch")
+	fs.StringVar(&cfg.Replica.Primary, "replica-of", cfg.Replica.Primary, "serve as a read-only replica of the primary at this base URL")
+	fs.StringVar(&cfg.GRPC.Addr, "grpc", cfg.GRPC.Addr, "gRPC listen address (empty disables)")
+	fs.StringVar(&cfg.Memcache.Addr, "memcache", cfg.Memcache.Addr, "memcached text protocol listen address, e.g. :11211 (empty disables)")
+	fs.StringVar(&cfg.RESP.Addr, "resp", cfg.RESP.Addr, "Redis protocol listen address for redis-cli and Redis clients, e.g. :6380 (empty disables)")
+	fs.StringVar(&cfg.Redis.Addr, "redis", cfg.Redis.Addr, "Redis address used as a shared second cache tier")
+	fs.StringVar(&cfg.Disk.Path, "disk", cfg.Disk.Path, "file on local disk used as the second cache tier, holding more rates than -size")
+	fs.StringVar(&cfg.Redis.Channel, "redis-channel", cfg.Redis.Channel, "Redis pub/sub channel broadcasting invalidations to the other instances (requires -redis)")
+	fs.StringVar(&cfg.Peers.Self, "peer-self", cfg.Peers.Self, "base URL of this node among -peers")
+	fs.Func("peers", "comma separated base URLs of the nodes filling their caches from each other", func(s string) error {
+		cfg.Peers.Nodes = strings.Split(s, ",")
+		return nil
+	})
+	fs.StringVar(&cfg.Warm, "warm", cfg.Warm, "CSV or JSON file of address/rate pairs loaded before serving")
+	fs.StringVar(&cfg.Snapshot.Path, "snapshot", cfg.Snapshot.Path, "file the cache is restored from at startup and saved to on exit")
+	fs.DurationVar(&cfg.Snapshot.Interval, "snapshot-interval", cfg.Snapshot.Interval, "also save the snapshot periodically (0 disables)")
+	fs.StringVar(&cfg.WAL.Dir, "wal", cfg.WAL.Dir, "directory of the write-ahead log of cache changes, replayed at startup after -snapshot")
+	fs.DurationVar(&cfg.Shutdown.Delay, "shutdown-delay", cfg.Shutdown.Delay, "how long /readyz fails before the servers stop accepting connections on shutdown")
+	fs.DurationVar(&cfg.Shutdown.Timeout, "shutdown-timeout", cfg.Shutdown.Timeout, "how long in-flight requests may take to finish on shutdown")
+	fs.StringVar(&cfg.Tax.Rounding, "tax-rounding", cfg.Tax.Rounding, "rounding of /tax amounts: "+strings.Join(config.RoundingModes, ", ")+" (banker's rounding)")
+	fs.IntVar(&cfg.Tax.Precision, "tax-precision", cfg.Tax.Precision, "decimal places of /tax amounts")
+	fs.BoolVar(&cfg.Tax.PerLine, "tax-per-line", cfg.Tax.PerLine, "round the /tax amount of each jurisdiction instead of the total")
+	fs.StringVar(&cfg.Tax.Taxability, "taxability-matrix", cfg.Tax.Taxability, "CSV file of the taxability rules of product categories by jurisdiction, for ?category=")
+	fs.StringVar(&cfg.Tax.Holidays, "tax-holidays", cfg.Tax.Holidays, "JSON calendar of the sales tax holidays exempting /tax amounts")
+	fs.StringVar(&cfg.Tax.Exemptions, "tax-exemptions", cfg.Tax.Exemptions, "JSON file of the exemption certificates of customers, applied by /tax?customer=")
+	fs.IntVar(&cfg.Tax.Customers, "tax-customers", cfg.Tax.Customers, "customers whose exemption certificates are cached (0 disables exemptions)")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "log level: "+strings.Join(config.LogLevels, ", "))
+	fs.Float64Var(&cfg.Log.Access.Sample, "access-log-sample", cfg.Log.Access.Sample, "share of HTTP requests written to the access log, from 0 (disabled) to 1 (all)")
+	fs.StringVar(&cfg.Tracing.Exporter, "trace-exporter", cfg.Tracing.Exporter, "OpenTelemetry span exporter: "+strings.Join(config.TracingExporters, ", "))
+	fs.StringVar(&cfg.Auth.Clients, "auth-clients", cfg.Auth.Clients, "JSON file of the API keys and client certificates allowed, with their roles and rate limits (empty disables authentication)")
+	fs.Float64Var(&cfg.Auth.Anonymous.Rate, "anonymous-rate", cfg.Auth.Anonymous.Rate, "requests per second each IP may make without credentials, read-only (0 disables)")
+	fs.Func("cors-origins", "comma separated origins of the web pages that may call the HTTP server, * for any", func(s string) error {
+		cfg.HTTP.CORS.Origins = strings.Split(s, ",")
+		return nil
+	})
+	fs.StringVar(&cfg.TLS.Cert, "tls-cert", cfg.TLS.Cert, "PEM certificate to serve HTTP and gRPC over TLS with")
+	fs.StringVar(&cfg.TLS.Key, "tls-key", cfg.TLS.Key, "PEM key of -tls-cert")
+	fs.DurationVar(&cfg.TLS.Reload, "tls-reload", cfg.TLS.Reload, "how often the -tls-cert files are checked for a rotated certificate (0 only reloads on SIGHUP)")
+	fs.StringVar(&cfg.TLS.ClientCA, "tls-client-ca", cfg.TLS.ClientCA, "PEM CA certificates verifying client certificates, for mutual TLS")
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "serve /debug/pprof and /debug/cache on the HTTP listener; do not expose publicly")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	tp, stopTracing, err := newTracerProvider(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := stopTracing(context.Background()); err != nil {
+			log.Printf("flushing traces: %v", err)
+		}
+	}()
+
+	ciph, err := newCipher(context.Background(), cfg.Encryption)
+	if err != nil {
+		return err
+	}
+	var wlog *wal.Log[string, salestax.TaxRate]
+	if cfg.WAL.Dir != "" {
+		walOpts := []wal.Option{
+			wal.WithMaxSize(int64(cfg.WAL.MaxSize)),
+			wal.WithMaxSegments(cfg.WAL.MaxSegments),
+			wal.WithSyncInterval(cfg.WAL.Sync),
+			wal.WithLogger(slog.Default()),
+		}
+		if ciph != nil {
+			walOpts = append(walOpts, wal.WithCipher(ciph))
+		}
+		wlog, err = wal.Open[string, salestax.TaxRate](cfg.WAL.Dir, walOpts...)
+		if err != nil {
+			return err
+		}
+		// deferred first, so it closes after the final snapshot
+		defer func() {
+			if err := wlog.Close(); err != nil {
+				log.Printf("closing write-ahead log %s: %v", cfg.WAL.Dir, err)
+			}
+		}()
+	}
+
+	upload := newSnapshotUpload(cfg.Snapshot.Store)
+
+	opts := append([]lrucache.Option{lrucache.WithLogger(slog.Default())}, loaderOptions(cfg.Loader)...)
+	if tp != nil {
+		opts = append(opts, lrucache.WithTracer[string](tracing.New[string](tp)))
+	}
+	accessLog := cfg.Log.Access.Sample > 0 || cfg.Log.Access.Slow > 0
+	if accessLog {
+		opts = append(opts, lrucache.WithTracer[string](accesslog.Tracer{}))
+	}
+	// the caches of tenants are kept apart in second tiers as well
+	tenantOpts := slices.Clone(opts)
+	if wlog != nil {
+		opts = append(opts, lrucache.WithJournal[string, salestax.TaxRate](wlog))
+	}
+	l2 := secondTier(cfg)
+	if cfg.Disk.Path != "" {
+		disk, err := openDisk(cfg.Disk, ciph)
+		if err != nil {
+			return err
+		}
+		defer disk.Close()
+		l2 = disk
+	}
+	if l2 != nil {
+		opts = append(opts, lrucache.WithSecondTier[string, salestax.TaxRate](l2))
+	}
+	c, err := newRateCache(cfg.Cache, opts...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	var loader salestax.RateLoaderFuncCtx
+	var rep *replica.Replica
+	if cfg.Replica.Primary == "" {
+		if loader, err = newLoader(cfg.Loader); err != nil {
+			return err
+		}
+	} else {
+		// the primary loads the misses, sparing the backend
+		loader, err = replica.Loader(cfg.Replica.Primary, cfg.Replica.Key, client.WithHTTPClient(&http.Client{Timeout: cfg.Loader.Timeout}))
+		if err != nil {
+			return err
+		}
+		ropts := []replica.Option{replica.WithInterval(cfg.Replica.Interval), replica.WithAPIKey(cfg.Replica.Key)}
+		if cfg.Replica.Watch {
+			ropts = append(ropts, replica.WithWatch())
+		}
+		rep = replica.New(cfg.Replica.Primary, c, ropts...)
+	}
+
+	reg := prometheus.NewRegistry()
+	col := metrics.NewCollector("salestax", c)
+	reg.MustRegister(col)
+	vars.Publish("salestax_cache", c)
+	if loader != nil {
+		loader = metrics.InstrumentLoaderCtx(col, loader)
+		if tp != nil {
+			loader = tracing.InstrumentLoaderCtx(tp, loader)
+		}
+	}
+	var peers *client.Peers
+	if len(cfg.Peers.Nodes) > 0 && loader != nil {
+		peers, err = client.NewPeers(cfg.Peers.Self, cfg.Peers.Nodes, client.WithHTTPClient(&http.Client{Timeout: cfg.Loader.Timeout}), client.WithAPIKey(cfg.Peers.Key))
+		if err != nil {
+			return err
+		}
+		// outside the instrumentation, which counts backend calls only
+		loader = peers.Loader(loader)
+	}
+
+	// read before serving so that a bad file fails the start
+	var rates []salestax.Rate
+	if cfg.Warm != "" {
+		if rates, err = readWarmFile(cfg.Warm); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// The servers start before the cache is warmed so that liveness probes
+	// pass meanwhile; /readyz reports 503 until warm-up is done.
+
+	var history *salestax.HistoryCache
+	if cfg.HTTP.Addr != "" && cfg.Cache.History > 0 {
+		if history, err = newHistoryCache(cfg.Cache); err != nil {
+			return err
+		}
+		defer history.Close()
+	}
+	var hub *watch.Hub
+	if cfg.Watch.Enabled {
+		hub = watch.New(watch.WithHistory(cfg.Watch.History), watch.WithBuffer(cfg.Watch.Buffer))
+	}
+	var cluster *invalidation.Cluster
+	if cfg.Redis.Channel != "" {
+		targets := []invalidation.Target{c}
+		if history != nil {
+			targets = append(targets, history)
+		}
+		rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr})
+		cluster = invalidation.New(redisbus.New(rdb, cfg.Redis.Channel), targets...)
+		if hub != nil {
+			cluster.Notify(hub.PublishInvalidation)
+		}
+		go func() {
+			if err := cluster.Run(ctx); err != nil {
+				log.Printf("invalidation channel %s: %v", cfg.Redis.Channel, err)
+			}
+		}()
+	}
+
+	tlsConfig, certs, err := serverTLS(cfg.TLS)
+	if err != nil {
+		return err
+	}
+	if certs != nil {
+		// rotated certificates are picked up by polling and on SIGHUP
+		if cfg.TLS.Reload > 0 {
+			go certs.Watch(ctx, cfg.TLS.Reload, func(err error) {
+				log.Printf("reloading TLS certificate: %v", err)
+			})
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-hup:
+					if err := certs.Reload(); err != nil {
+						log.Printf("reloading TLS certificate: %v", err)
+					} else {
+						log.Printf("reloaded TLS certificate %s", cfg.TLS.Cert)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	var authn *auth.Authenticator
+	if cfg.Auth.Enabled() {
+		// probes come without credentials
+		opts := []auth.Option{auth.WithPublic("/healthz", "/readyz")}
+		if an := cfg.Auth.Anonymous; an.Rate > 0 {
+			opts = append(opts, auth.WithAnonymous(an.Rate, an.Burst, an.Paths...))
+		}
+		if cfg.Auth.Clients != "" {
+			authn, err = auth.OpenClients(cfg.Auth.Clients, opts...)
+		} else {
+			authn, err = auth.New(nil, opts...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// concurrent lookups of an address share one round trip, whichever
+	// server they come in through
+	coalescer := salestax.NewCoalescer(c, loader)
+
+	// servers that stopped serving report here; nil once shut down
+	errc := make(chan error, 4)
+	var shutdown []func(context.Context) error
+	var hs *httpserver.Server
+	if cfg.HTTP.Addr != "" {
+		hs = httpserver.New(cfg.HTTP.Addr, c, loader)
+		hs.Handle("GET /metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		hs.Handle("GET /debug/vars", expvar.Handler())
+		if tlsConfig != nil {
+			hs.SetTLSConfig(tlsConfig)
+		}
+		hs.SetMaxBatch(cfg.HTTP.MaxBatch)
+		hs.EnableCoalescing(coalescer)
+		if err := enableTax(hs, c, loader, cfg.Tax); err != nil {
+			return err
+		}
+		if cfg.Tax.Customers > 0 {
+			var certLoader taxability.ExemptionLoaderFuncCtx
+			if cfg.Tax.Exemptions != "" {
+				certs, err := taxability.OpenCertificates(cfg.Tax.Exemptions)
+				if err != nil {
+					return err
+				}
+				certLoader = certs.Exemptions
+			}
+			exemptions := taxability.NewExemptionCache(cfg.Tax.Customers)
+			defer exemptions.Close()
+			hs.EnableExemptions(exemptions, certLoader)
+		}
+		if cfg.Admin.Token != "" || authn != nil {
+			admin := httpserver.Admin{Token: cfg.Admin.Token, Policy: cfg.Cache.Policy, Policies: policies}
+			if path := cfg.Snapshot.Path; path != "" {
+				save := snapshotSaver(c, path, ciph, wlog, upload)
+				admin.Snapshot = func(context.Context) error { return save() }
+			}
+			hs.EnableAdmin(admin)
+		}
+		if cfg.Debug {
+			hs.EnableDebug()
+		}
+		if history != nil {
+			hs.EnableHistory(history, newHistoryLoader(cfg.Loader, loader))
+		}
+		if hub != nil {
+			hs.EnableWatch(hub)
+		}
+		if cluster != nil {
+			hs.EnableCluster(cluster)
+		}
+		if rep != nil {
+			hs.SetReadOnly()
+			hs.AddReadyCheck("primary", rep.Check)
+		} else if check := loaderCheck(cfg.Loader); check != nil {
+			hs.AddReadyCheck("loader", check)
+		}
+		if len(cfg.Tenants) > 0 {
+			tenants, caches, err := newTenants(ctx, cfg, loader, tenantOpts...)
+			for _, tc := range caches {
+				defer tc.Close()
+			}
+			if err != nil {
+				return err
+			}
+			// after auth, which tells the tenant of the client
+			hs.Use(tenants.Middleware)
+		}
+		if peers != nil {
+			hs.Use(peers.Middleware)
+		}
+		if authn != nil {
+			hs.Use(authn.Middleware)
+		}
+		if len(cfg.HTTP.CORS.Origins) > 0 {
+			// preflights carry no credentials, answer them before auth
+			hs.Use(httpserver.CORS{Origins: cfg.HTTP.CORS.Origins, MaxAge: cfg.HTTP.CORS.MaxAge}.Middleware)
+		}
+		if accessLog {
+			// outermost but for tracing, so its latency covers auth
+			hs.Use(accesslog.New(slog.Default(), cfg.Log.Access.Sample, accesslog.WithSlow(cfg.Log.Access.Slow), accesslog.WithRoute(hs.Route)).Middleware)
+		}
+		if tp != nil {
+			hs.Use(tracing.Middleware(tp))
+		}
+		shutdown = append(shutdown, hs.Shutdown)
+		go func() { errc <- hs.ListenAndServe() }()
+		log.Printf("serving HTTP on %s", hs.Addr())
+	}
+	if cfg.GRPC.Addr != "" {
+		var gopts []grpc.ServerOption
+		if tlsConfig != nil {
+			gopts = append(gopts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		var interceptors []grpc.UnaryServerInterceptor
+		if tp != nil {
+			interceptors = append(interceptors, tracing.UnaryServerInterceptor(tp))
+		}
+		if authn != nil {
+			interceptors = append(interceptors, authn.UnaryServerInterceptor())
+		}
+		gopts = append(gopts, grpc.ChainUnaryInterceptor(interceptors...))
+		gs := grpcserver.New(cfg.GRPC.Addr, c, loader, gopts...)
+		gs.SetMaxBatch(cfg.HTTP.MaxBatch)
+		gs.EnableCoalescing(coalescer)
+		if rep != nil {
+			gs.SetReadOnly()
+		}
+		if hub != nil {
+			gs.EnableWatch(hub)
+		}
+		if cluster != nil {
+			gs.EnableCluster(cluster)
+		}
+		shutdown = append(shutdown, gs.Shutdown)
+		go func() { errc <- gs.ListenAndServe() }()
+		log.Printf("serving gRPC on %s", gs.Addr())
+	}
+
+	if cfg.Memcache.Addr != "" {
+		ms := memcacheserver.New(cfg.Memcache.Addr, c, loader)
+		if rep != nil {
+			ms.SetReadOnly()
+		}
+		if authn != nil {
+			ms.EnableAuth(authn)
+		}
+		if cluster != nil {
+			ms.EnableCluster(cluster)
+		}
+		shutdown = append(shutdown, ms.Shutdown)
+		go func() { errc <- ms.ListenAndServe() }()
+		log.Printf("serving memcached protocol on %s", ms.Addr())
+	}
+	if cfg.RESP.Addr != "" {
+		rs := respserver.New(cfg.RESP.Addr, c, loader)
+		if rep != nil {
+			rs.SetReadOnly()
+		}
+		if authn != nil {
+			rs.EnableAuth(authn)
+		}
+		if cluster != nil {
+			rs.EnableCluster(cluster)
+		}
+		shutdown = append(shutdown, rs.Shutdown)
+		go func() { errc <- rs.ListenAndServe() }()
+		log.Printf("serving Redis protocol on %s", rs.Addr())
+	}
+
+	snapshotPath := cfg.Snapshot.Path
+	if _, err := os.Stat(snapshotPath); upload != nil && os.IsNotExist(err) {
+		// a new instance starts from the latest snapshot of the fleet
+		bctx, cancel := context.WithTimeout(ctx, storeTimeout)
+		key, err := upload.bootstrap(bctx, snapshotPath)
+		cancel()
+		switch {
+		case err != nil:
+			log.Printf("downloading the latest snapshot of %s: %v", cfg.Snapshot.Store.Bucket, err)
+		case key != "":
+			log.Printf("downloaded snapshot %s of %s to %s", key, cfg.Snapshot.Store.Bucket, snapshotPath)
+		}
+	}
+	if snapshotPath != "" {
+		load := func() error { return loadSnapshot(c, snapshotPath, ciph) }
+		if wlog != nil {
+			// the log holds the changes since, not the snapshot itself
+			load = func() error { return wlog.Unlogged(func() error { return loadSnapshot(c, snapshotPath, ciph) }) }
+		}
+		if err := load(); err != nil && !os.IsNotExist(err) {
+			log.Printf("restoring snapshot %s: %v", snapshotPath, err)
+			if errors.Is(err, lrucache.ErrSnapshotVersion) {
+				// written by a newer binary, kept for a rollback to it
+				aside := snapshotPath + ".unsupported"
+				if err := os.Rename(snapshotPath, aside); err == nil {
+					log.Printf("moved snapshot %s to %s", snapshotPath, aside)
+				}
+			}
+		}
+	}
+	if wlog != nil {
+		n, err := wlog.Replay(c.Restore, c.Evict)
+		if err != nil {
+			log.Printf("replaying write-ahead log %s: %v", cfg.WAL.Dir, err)
+		}
+		log.Printf("replayed %d changes from write-ahead log %s", n, cfg.WAL.Dir)
+	}
+	if cfg.Warm != "" {
+		log.Printf("warmed cache with %d rates from %s", c.WarmRates(rates), cfg.Warm)
+	}
+	if snapshotPath != "" {
+		save := snapshotSaver(c, snapshotPath, ciph, wlog, upload)
+		defer func() {
+			if err := save(); err != nil {
+				log.Printf("saving snapshot %s: %v", snapshotPath, err)
+				return
+			}
+			log.Printf("saved %d rates to snapshot %s", c.Len(), snapshotPath)
+		}()
+		if cfg.Snapshot.Interval > 0 {
+			// deferred after the final save, so it stops before that runs
+			stop := autoSnapshot(save, snapshotPath, cfg.Snapshot.Interval)
+			defer stop()
+		}
+	}
+	if rep != nil {
+		// readiness waits for the first snapshot of the primary, see rep.Check
+		rctx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			rep.Run(rctx)
+		}()
+		defer func() {
+			stop()
+			<-done
+		}()
+		log.Printf("replicating %s every %v", cfg.Replica.Primary, cfg.Replica.Interval)
+	}
+	if hs != nil {
+		hs.SetReady(true)
+	}
+
+	select {
+	case <-ctx.Done():
+		// a second signal kills the process instead of waiting for the drain
+		cancel()
+		log.Printf("shutting down")
+		if hs != nil && cfg.Shutdown.Delay > 0 {
+			hs.SetReady(false)
+			time.Sleep(cfg.Shutdown.Delay)
+		}
+	case err = <-errc:
+		// one server failed, take the others down with it
+	}
+	sctx, scancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer scancel()
+	var wg sync.WaitGroup
+	for _, fn := range shutdown {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if serr := fn(sctx); serr != nil {
+				log.Printf("shutdown: %v", serr)
+			}
+		}()
+	}
+	wg.Wait()
+	return err
+}
+
+// configPath returns the value of the -config flag in args, if any. It is
+// needed before the other flags are defined, since their defaults come from
+// the file.
+func configPath(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// secondTier returns the configured shared cache tier, or nil.
+func secondTier(cfg config.Config) salestax.RateSecondTier {
+	switch {
+	case cfg.Redis.Addr != "":
+		client := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr})
+		return redistier.New[salestax.TaxRate](client, cfg.Redis.Prefix, rateCodec{})
+	case len(cfg.Memcached.Servers) > 0:
+		client := memcache.New(cfg.Memcached.Servers...)
+		return memcachetier.New[salestax.TaxRate](client, cfg.Memcached.Prefix, rateCodec{})
+	}
+	return nil
+}
+
+// openDisk opens the disk tier described by cfg, encrypted with ciph if not
+// nil.
+func openDisk(cfg config.Disk, ciph *seal.Cipher) (*disktier.Tier[salestax.TaxRate], error) {
+	opts := []disktier.Option{disktier.WithSweepInterval(cfg.Sweep)}
+	if cfg.NoSync {
+		opts = append(opts, disktier.WithNoSync())
+	}
+	if ciph != nil {
+		opts = append(opts, disktier.WithCipher(ciph))
+	}
+	disk, err := disktier.Open[salestax.TaxRate](cfg.Path, rateCodec{}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("opening disk tier %s: %w", cfg.Path, err)
+	}
+	log.Printf("disk tier %s holds %d rates", cfg.Path, disk.Len())
+	return disk, nil
+}
+
+// newCipher returns the cipher of the persisted files described by cfg, nil
+// if encryption is disabled.
+func newCipher(ctx context.Context, cfg config.Encryption) (*seal.Cipher, error) {
+	if cfg.Key == "" {
+		return nil, nil
+	}
+	var kms seal.KMS
+	if len(cfg.KMS) > 0 {
+		kms = seal.Command(cfg.KMS)
+	}
+	keys, err := seal.DecodeKeys(ctx, kms, append([]string{cfg.Key}, cfg.OldKeys...)...)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	return seal.New(keys[0], keys[1:]...)
+}
+
+// rateCodec stores rate breakdowns in the second tier as JSON. It still
+// reads the plain numbers stored by servers that only cached combined rates,
+// so that a rolling upgrade keeps sharing their entries.
+type rateCodec struct {
+	tier.JSON[salestax.TaxRate]
+}
+
+func (c rateCodec) Decode(data []byte) (salestax.TaxRate, error) {
+	if rate, err := (tier.Float64{}).Decode(data); err == nil {
+		return salestax.Flat(rate), nil
+	}
+	return c.JSON.Decode(data)
+}
+
+// serverTLS returns the TLS configuration of the servers described by cfg
+// and the reloader of its certificate, nil if TLS is disabled.
+func serverTLS(cfg config.TLS) (*tls.Config, *certreload.Reloader, error) {
+	if cfg.Cert == "" {
+		return nil, nil, nil
+	}
+	certs, err := certreload.New(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	tc := &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA != "" {
+		data, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("%s: no certificates found", cfg.ClientCA)
+		}
+		tc.ClientCAs = pool
+		// clients without a certificate may still present an API key
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, certs, nil
+}
+
+// taxRounding returns the rounding of tax calculations configured by cfg,
+// which must be valid.
+func taxRounding(cfg config.Tax) salestax.Rounding {
+	r := salestax.Rounding{Mode: salestax.RoundHalfUp, Precision: cfg.Precision, PerLine: cfg.PerLine}
+	if cfg.Rounding == "half-even" {
+		r.Mode = salestax.RoundHalfEven
+	}
+	return r
+}
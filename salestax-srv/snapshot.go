@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/config"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/objstore"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/seal"
+	"github.com/jared-d-smith/psl/salestax-srv/wal"
+)
+
+// storeTimeout bounds an upload or a download of a snapshot.
+const storeTimeout = 5 * time.Minute
+
+// runSnapshot implements "salestax-srv snapshot". Without -from it prints the
+// contents of a snapshot as CSV; with -from it builds the snapshot from a warm
+// file, so that a server can start from prepared data. Snapshots are
+// encrypted and decrypted with the encryption key of the configuration.
+func runSnapshot(args []string) error {
+	cfg, err := config.Load(configPath(args))
+	if err != nil {
+		return err
+	}
+	fs := newFlagSet("snapshot", "file")
+	fs.String("config", "", "YAML configuration file, for the encryption key")
+	from := fs.String("from", "", "build the snapshot from this CSV or JSON warm file instead of printing it")
+	size := fs.Int("size", 50000, "maximum number of rates kept when building")
+	ttl := fs.Duration("ttl", 0, "expire rates in a built snapshot after this long (0 disables)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	path := fs.Arg(0)
+	ciph, err := newCipher(context.Background(), cfg.Encryption)
+	if err != nil {
+		return err
+	}
+
+	if *from != "" {
+		if *size <= 0 {
+			return fmt.Errorf("-size must be positive, got %d", *size)
+		}
+		rates, err := readWarmFile(*from)
+		if err != nil {
+			return err
+		}
+		c := salestax.NewRateCache(*size, lrucache.WithTTL(*ttl))
+		c.WarmRates(rates)
+		if err := saveSnapshot(c, path, ciph); err != nil {
+			return err
+		}
+		log.Printf("wrote %d rates to %s", c.Len(), path)
+		return nil
+	}
+
+	c := salestax.NewRateCache(*size)
+	if err := loadSnapshot(c, path, ciph); err != nil {
+		return err
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"address", "rate", "expires"})
+	for _, address := range c.Keys() {
+		item, err := c.Peek(address)
+		if err != nil {
+			continue
+		}
+		expires := ""
+		if !item.Expires().IsZero() {
+			expires = item.Expires().Format(time.RFC3339)
+		}
+		w.Write([]string{address, strconv.FormatFloat(item.Value().Total(), 'g', -1, 64), expires})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// loadSnapshot restores the cache from the snapshot file at path, decrypted
+// with ciph if encrypted. A snapshot in clear is loaded even with ciph, so
+// that enabling encryption keeps the cache.
+func loadSnapshot(c *salestax.RateCache, path string, ciph *seal.Cipher) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(seal.MagicSize); seal.IsSealed(magic) {
+		if ciph == nil {
+			return fmt.Errorf("snapshot %s is encrypted, and no encryption.key is configured", path)
+		}
+		if r, err = ciph.NewReader(br); err != nil {
+			return err
+		}
+	}
+	return c.LoadSnapshot(r)
+}
+
+// saveSnapshot writes the cache to path, encrypted with ciph if not nil.
+// The snapshot is written to a temporary file first and renamed, so a crash
+// never leaves a truncated file.
+func saveSnapshot(c *salestax.RateCache, path string, ciph *seal.Cipher) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	var w io.WriteCloser = f
+	if ciph != nil {
+		w = ciph.NewWriter(f)
+	}
+	if err := c.SaveSnapshot(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// snapshotSaver returns the function saving c to path, encrypted with ciph
+// if not nil, as a checkpoint of wlog if not nil, so that the segments of
+// wlog the snapshot holds are removed, then uploading it with up if not nil.
+func snapshotSaver(c *salestax.RateCache, path string, ciph *seal.Cipher, wlog *wal.Log[string, salestax.TaxRate], up *snapshotUpload) func() error {
+	save := func() error { return saveSnapshot(c, path, ciph) }
+	if wlog != nil {
+		save = func() error {
+			return wlog.Checkpoint(func() error { return saveSnapshot(c, path, ciph) })
+		}
+	}
+	if up == nil {
+		return save
+	}
+	return func() error {
+		if err := save(); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		return up.upload(ctx, path)
+	}
+}
+
+// snapshotUpload keeps the snapshots in the bucket of an object storage,
+// for new instances to start from the latest.
+type snapshotUpload struct {
+	store *objstore.Store
+	cfg   config.Store
+}
+
+// newSnapshotUpload returns the snapshotUpload described by cfg, nil if
+// disabled.
+func newSnapshotUpload(cfg config.Store) *snapshotUpload {
+	if cfg.Bucket == "" {
+		return nil
+	}
+	opts := []objstore.Option{objstore.WithRegion(cfg.Region), objstore.WithHTTPClient(&http.Client{Timeout: storeTimeout})}
+	if cfg.AccessKey != "" {
+		opts = append(opts, objstore.WithCredentials(cfg.AccessKey, cfg.SecretKey))
+	}
+	return &snapshotUpload{store: objstore.New(cfg.Endpoint, cfg.Bucket, opts...), cfg: cfg}
+}
+
+// upload uploads the snapshot at path, named after the time, and removes
+// the oldest beyond cfg.Keep. The snapshot is uploaded as saved, encrypted
+// if encryption is enabled.
+func (u *snapshotUpload) upload(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	key := u.cfg.Prefix + time.Now().UTC().Format("20060102T150405.000Z") + ".snap"
+	if err := u.store.Put(ctx, key, f); err != nil {
+		return fmt.Errorf("uploading to %s: %w", u.cfg.Bucket, err)
+	}
+	if u.cfg.Keep == 0 {
+		return nil
+	}
+	snapshots, err := u.list(ctx)
+	if err != nil {
+		return err
+	}
+	for len(snapshots) > u.cfg.Keep {
+		if err := u.store.Delete(ctx, snapshots[0].Key); err != nil {
+			return fmt.Errorf("removing %s from %s: %w", snapshots[0].Key, u.cfg.Bucket, err)
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+// bootstrap downloads the latest snapshot uploaded to path, and returns its
+// key, empty if there is none.
+func (u *snapshotUpload) bootstrap(ctx context.Context, path string) (string, error) {
+	snapshots, err := u.list(ctx)
+	if err != nil || len(snapshots) == 0 {
+		return "", err
+	}
+	key := snapshots[len(snapshots)-1].Key
+	body, err := u.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.ReadFrom(body); err != nil {
+		f.Close()
+		return "", fmt.Errorf("downloading %s: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return key, os.Rename(f.Name(), path)
+}
+
+// list returns the snapshots uploaded, oldest first.
+func (u *snapshotUpload) list(ctx context.Context) ([]objstore.Object, error) {
+	objects, err := u.store.List(ctx, u.cfg.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing the snapshots of %s: %w", u.cfg.Bucket, err)
+	}
+	var snapshots []objstore.Object
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, ".snap") && !strings.Contains(o.Key[len(u.cfg.Prefix):], "/") {
+			snapshots = append(snapshots, o)
+		}
+	}
+	return snapshots, nil
+}
+
+// autoSnapshot saves the snapshot of path with save every interval until
+// the returned function is called.
+func autoSnapshot(save func() error, path string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := save(); err != nil {
+					log.Printf("saving snapshot %s: %v", path, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,177 @@
+package taxability
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Certificate is an exemption certificate of a customer, e.g. for resale or
+// of a nonprofit: until it expires, the jurisdictions of Jurisdictions levy
+// no tax on the customer's purchases. Empty Jurisdictions match all of them.
+type Certificate struct {
+	ID            string         `json:"id"`
+	Customer      string         `json:"customer"`
+	Jurisdictions []Jurisdiction `json:"jurisdictions,omitempty"`
+	Expires       string         `json:"expires,omitempty"` // the last date of validity; empty never expires
+}
+
+func (c Certificate) check() error {
+	if c.ID == "" {
+		return errors.New("empty id")
+	}
+	if c.Customer == "" {
+		return errors.New("empty customer")
+	}
+	if c.Expires != "" {
+		if _, err := time.Parse(time.DateOnly, c.Expires); err != nil {
+			return fmt.Errorf("expires: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidOn reports whether c has not expired on date, a time.DateOnly
+// string.
+func (c Certificate) ValidOn(date string) bool {
+	return c.Expires == "" || date <= c.Expires
+}
+
+// Exemptions is the exemption certificates of a customer.
+type Exemptions []Certificate
+
+// Check returns an error if a certificate of e is malformed or is not of
+// customer.
+func (e Exemptions) Check(customer string) error {
+	for _, c := range e {
+		if err := c.check(); err != nil {
+			return fmt.Errorf("certificate %q: %w", c.ID, err)
+		}
+		if c.Customer != customer {
+			return fmt.Errorf("certificate %q is of customer %q, not %q", c.ID, c.Customer, customer)
+		}
+	}
+	return nil
+}
+
+// Apply returns rate with the components exempted by a certificate of e
+// valid at t kept at a zero rate, and the IDs of the certificates that
+// exempted any. The date of t is taken in its location.
+func (e Exemptions) Apply(rate salestax.TaxRate, t time.Time) (salestax.TaxRate, []string) {
+	date := t.Format(time.DateOnly)
+	var ids []string
+	out := rate
+	out.Components = make([]salestax.Component, len(rate.Components))
+	for i, comp := range rate.Components {
+		for _, c := range e {
+			if comp.Rate != 0 && c.ValidOn(date) && inJurisdictions(c.Jurisdictions, JurisdictionOf(comp)) {
+				comp.Rate = 0
+				if !slices.Contains(ids, c.ID) {
+					ids = append(ids, c.ID)
+				}
+			}
+		}
+		out.Components[i] = comp
+	}
+	return out, ids
+}
+
+// ExemptionLoaderFunc resolves the exemption certificates of a customer. A
+// customer without certificates has empty Exemptions, not an error, so that
+// the absence is cached too.
+type ExemptionLoaderFunc = lrucache.LoaderFunc[string, Exemptions]
+
+// ExemptionLoaderFuncCtx is an ExemptionLoaderFunc that honors the caller's
+// context.
+type ExemptionLoaderFuncCtx = lrucache.LoaderFuncCtx[string, Exemptions]
+
+// ExemptionCache is an LRU cache of the exemption certificates of
+// customers, by customer ID.
+type ExemptionCache struct {
+	*lrucache.LRUCache[string, Exemptions]
+}
+
+// NewExemptionCache returns a pointer to an initialized ExemptionCache.
+func NewExemptionCache(sz int, opts ...lrucache.Option) *ExemptionCache {
+	return &ExemptionCache{lrucache.New[string, Exemptions](sz, opts...)}
+}
+
+// Apply returns rate with the exemptions of customer valid at t applied,
+// and the IDs of the certificates applied; see Exemptions.Apply. It calls
+// loader if the exemptions of customer are not cached; with a nil loader a
+// customer that is not cached has none.
+func (c *ExemptionCache) Apply(ctx context.Context, rate salestax.TaxRate, customer string, t time.Time, loader ExemptionLoaderFuncCtx) (salestax.TaxRate, []string, error) {
+	var e Exemptions
+	var err error
+	if loader == nil {
+		e, err = c.Lookup(customer)
+		if errors.Is(err, lrucache.ErrNotFound) {
+			err = nil
+		}
+	} else {
+		e, err = c.GetOrLoadCtx(ctx, customer, loader)
+	}
+	if err != nil {
+		return salestax.TaxRate{}, nil, err
+	}
+	rate, ids := e.Apply(rate, t)
+	return rate, ids, nil
+}
+
+// Certificates is a set of exemption certificates held in memory, read
+// from a JSON array of Certificates. It is safe for concurrent use.
+type Certificates struct {
+	customers map[string]Exemptions
+}
+
+// OpenCertificates reads the certificates in the JSON file at path.
+func OpenCertificates(path string) (*Certificates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadCertificates(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ReadCertificates reads certificates in JSON form from r.
+func ReadCertificates(r io.Reader) (*Certificates, error) {
+	var certs []Certificate
+	if err := json.NewDecoder(r).Decode(&certs); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty certificate list")
+		}
+		return nil, err
+	}
+	c := &Certificates{customers: make(map[string]Exemptions)}
+	for i, cert := range certs {
+		if err := cert.check(); err != nil {
+			return nil, fmt.Errorf("certificate %d (%s): %w", i, cert.ID, err)
+		}
+		c.customers[cert.Customer] = append(c.customers[cert.Customer], cert)
+	}
+	return c, nil
+}
+
+// Len returns the number of customers with certificates.
+func (c *Certificates) Len() int {
+	return len(c.customers)
+}
+
+// Exemptions returns the certificates of customer. It never fails; it is an
+// ExemptionLoaderFuncCtx.
+func (c *Certificates) Exemptions(_ context.Context, customer string) (Exemptions, error) {
+	return c.customers[customer], nil
+}
@@ -0,0 +1,87 @@
+package taxability
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+const certificates = `[
+	{"id": "R-1", "customer": "acme", "expires": "2026-12-31",
+	 "jurisdictions": [{"level": "state", "code": "TX"}, {"level": "county", "code": "*"}]},
+	{"id": "N-7", "customer": "charity"}
+]`
+
+func TestExemptions(t *testing.T) {
+	certs, err := ReadCertificates(strings.NewReader(certificates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", certs.Len())
+	}
+	rate := salestax.TaxRate{Components: []salestax.Component{
+		{Level: salestax.State, Code: "TX", Rate: 0.0625},
+		{Level: salestax.County, Name: "Travis", Rate: 0.005},
+		{Level: salestax.City, Name: "Austin", Rate: 0.01},
+	}}
+	c := NewExemptionCache(10)
+	loads := 0
+	loader := func(ctx context.Context, customer string) (Exemptions, error) {
+		loads++
+		return certs.Exemptions(ctx, customer)
+	}
+
+	tests := []struct {
+		customer string
+		date     string
+		total    float64
+		ids      []string
+	}{
+		{"acme", "2026-12-31", 0.01, []string{"R-1"}},
+		{"acme", "2027-01-01", 0.0775, nil},
+		{"charity", "2030-01-01", 0, []string{"N-7"}},
+		{"nobody", "2026-01-01", 0.0775, nil},
+		{"nobody", "2026-01-02", 0.0775, nil},
+	}
+	for _, tt := range tests {
+		date, _ := time.Parse(time.DateOnly, tt.date)
+		got, ids, err := c.Apply(context.Background(), rate, tt.customer, date, loader)
+		if err != nil {
+			t.Errorf("Apply(%s, %s): %v", tt.customer, tt.date, err)
+			continue
+		}
+		if diff := got.Total() - tt.total; diff > 1e-9 || diff < -1e-9 || !slices.Equal(ids, tt.ids) {
+			t.Errorf("Apply(%s, %s) = %v %q, want %v %q", tt.customer, tt.date, got.Total(), ids, tt.total, tt.ids)
+		}
+	}
+	if loads != 3 {
+		t.Errorf("%d loads, want 3: customers without certificates are cached too", loads)
+	}
+	if rate.Components[0].Rate != 0.0625 {
+		t.Error("Apply modified its rate")
+	}
+
+	// cache-only
+	if got, ids, err := NewExemptionCache(10).Apply(context.Background(), rate, "acme", time.Now(), nil); err != nil || ids != nil || got.Total() != rate.Total() {
+		t.Errorf("cache-only Apply = %v %q %v, want no exemptions", got.Total(), ids, err)
+	}
+
+	if err := (Exemptions{{ID: "R-1", Customer: "acme"}}).Check("other"); err == nil {
+		t.Error("Check accepted a certificate of another customer")
+	}
+	for _, bad := range []string{
+		"",
+		`[{"customer": "acme"}]`,
+		`[{"id": "R-1"}]`,
+		`[{"id": "R-1", "customer": "acme", "expires": "end of year"}]`,
+	} {
+		if _, err := ReadCertificates(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadCertificates(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package taxability
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Holiday is a sales tax holiday: from Start to End, both inclusive, the
+// jurisdictions of Jurisdictions exempt the products of Categories. Empty
+// Jurisdictions or Categories match all of them.
+type Holiday struct {
+	Name          string         `json:"name"`
+	Start         string         `json:"start"` // date, e.g. 2026-08-07
+	End           string         `json:"end"`   // date
+	Jurisdictions []Jurisdiction `json:"jurisdictions,omitempty"`
+	Categories    []string       `json:"categories,omitempty"`
+	// MaxAmount limits the exemption to amounts of at most MaxAmount, e.g.
+	// clothing under $100 an item. Zero means any amount.
+	MaxAmount float64 `json:"max_amount,omitempty"`
+}
+
+func (h Holiday) check() error {
+	start, err := time.Parse(time.DateOnly, h.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := time.Parse(time.DateOnly, h.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("ends on %s before it starts on %s", h.End, h.Start)
+	}
+	if h.MaxAmount < 0 {
+		return fmt.Errorf("negative max_amount %v", h.MaxAmount)
+	}
+	return nil
+}
+
+// covers reports whether h exempts products of category sold for amount on
+// date, a time.DateOnly string, in j.
+func (h Holiday) covers(date string, j Jurisdiction, category string, amount float64) bool {
+	if date < h.Start || date > h.End {
+		return false
+	}
+	if h.MaxAmount > 0 && amount > h.MaxAmount {
+		return false
+	}
+	if len(h.Categories) > 0 && !containsFold(h.Categories, category) {
+		return false
+	}
+	return inJurisdictions(h.Jurisdictions, j)
+}
+
+// inJurisdictions reports whether j is one of list, where a level or code
+// of Any matches all. An empty list matches every jurisdiction.
+func inJurisdictions(list []Jurisdiction, j Jurisdiction) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if (l.Level == Any || strings.EqualFold(l.Level, j.Level)) && (l.Code == Any || strings.EqualFold(l.Code, j.Code)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Calendar is a calendar of tax holidays. It is safe for concurrent use.
+//
+// It is read from a JSON array of Holidays, e.g.
+//
+//	[{"name": "Texas back to school", "start": "2026-08-07", "end": "2026-08-09",
+//	  "jurisdictions": [{"level": "state", "code": "TX"}, {"level": "county", "code": "*"}],
+//	  "categories": ["clothing"], "max_amount": 100}]
+type Calendar struct {
+	holidays []Holiday
+}
+
+// OpenCalendar reads the calendar in the JSON file at path.
+func OpenCalendar(path string) (*Calendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadCalendar(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// ReadCalendar reads a calendar in JSON form from r.
+func ReadCalendar(r io.Reader) (*Calendar, error) {
+	var holidays []Holiday
+	if err := json.NewDecoder(r).Decode(&holidays); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty holiday calendar")
+		}
+		return nil, err
+	}
+	for i, h := range holidays {
+		if err := h.check(); err != nil {
+			return nil, fmt.Errorf("holiday %d (%s): %w", i, h.Name, err)
+		}
+	}
+	return &Calendar{holidays: holidays}, nil
+}
+
+// Len returns the number of holidays in the calendar.
+func (c *Calendar) Len() int {
+	return len(c.holidays)
+}
+
+// Apply returns rate with the components exempted by a holiday for products
+// of category sold for amount at t kept at a zero rate, and the names of the
+// holidays that exempted any. The date of t is taken in its location, which
+// should be that of the sale.
+func (c *Calendar) Apply(rate salestax.TaxRate, category string, amount float64, t time.Time) (salestax.TaxRate, []string) {
+	date := t.Format(time.DateOnly)
+	var names []string
+	out := rate
+	out.Components = make([]salestax.Component, len(rate.Components))
+	for i, comp := range rate.Components {
+		for _, h := range c.holidays {
+			if comp.Rate != 0 && h.covers(date, JurisdictionOf(comp), category, amount) {
+				comp.Rate = 0
+				if !slices.Contains(names, h.Name) {
+					names = append(names, h.Name)
+				}
+			}
+		}
+		out.Components[i] = comp
+	}
+	return out, names
+}
@@ -0,0 +1,76 @@
+package taxability
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+const calendar = `[
+	{"name": "back to school", "start": "2026-08-07", "end": "2026-08-09",
+	 "jurisdictions": [{"level": "state", "code": "TX"}, {"level": "county", "code": "*"}],
+	 "categories": ["clothing"], "max_amount": 100},
+	{"name": "energy star", "start": "2026-05-23", "end": "2026-05-25",
+	 "jurisdictions": [{"level": "state", "code": "TX"}], "categories": ["appliances"]}
+]`
+
+func TestCalendar(t *testing.T) {
+	c, err := ReadCalendar(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+	rate := salestax.TaxRate{Components: []salestax.Component{
+		{Level: salestax.State, Code: "TX", Rate: 0.0625},
+		{Level: salestax.County, Name: "Travis", Rate: 0.005},
+		{Level: salestax.City, Name: "Austin", Rate: 0.01},
+	}}
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		category string
+		amount   float64
+		date     string
+		total    float64
+		holidays []string
+	}{
+		{"clothing", 50, "2026-08-07", 0.01, []string{"back to school"}},
+		{"Clothing", 100, "2026-08-09", 0.01, []string{"back to school"}},
+		{"clothing", 150, "2026-08-08", 0.0775, nil},
+		{"clothing", 50, "2026-08-10", 0.0775, nil},
+		{"appliances", 900, "2026-05-24", 0.015, []string{"energy star"}},
+		{"", 50, "2026-08-08", 0.0775, nil},
+	}
+	for _, tt := range tests {
+		got, holidays := c.Apply(rate, tt.category, tt.amount, day(tt.date))
+		if diff := got.Total() - tt.total; diff > 1e-9 || diff < -1e-9 || !slices.Equal(holidays, tt.holidays) {
+			t.Errorf("Apply(%q, %v, %s) = %v %q, want %v %q", tt.category, tt.amount, tt.date, got.Total(), holidays, tt.total, tt.holidays)
+		}
+	}
+	if rate.Components[0].Rate != 0.0625 {
+		t.Error("Apply modified its rate")
+	}
+
+	for _, bad := range []string{
+		"",
+		`{"name": "not a list"}`,
+		`[{"name": "x", "start": "2026-08-07"}]`,
+		`[{"name": "x", "start": "2026-08-09", "end": "2026-08-07"}]`,
+		`[{"name": "x", "start": "2026-08-07", "end": "2026-08-09", "max_amount": -1}]`,
+	} {
+		if _, err := ReadCalendar(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadCalendar(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package taxability
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Matrix is a taxability matrix held in memory. It is safe for concurrent
+// use.
+//
+// It is read from a CSV file with a header row naming the columns
+// category, level, code, treatment and rate, e.g.
+//
+//	category,level,code,treatment,rate
+//	groceries,state,CA,exempt,
+//	groceries,state,IL,reduced,0.01
+//	clothing,state,NY,exempt,
+//	digital,*,*,exempt,
+//	groceries,national,FR,reduced,0.055
+//
+// A level or code of "*" matches any. Category names are case-insensitive.
+type Matrix struct {
+	rules map[Key]Rule
+}
+
+// OpenMatrix reads the matrix in the CSV file at path.
+func OpenMatrix(path string) (*Matrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ReadMatrix(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// ReadMatrix reads a matrix in CSV form from r.
+func ReadMatrix(r io.Reader) (*Matrix, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty taxability matrix")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the csv reader holds every record to the length of the header
+	want := []string{"category", "level", "code", "treatment", "rate"}
+	if len(header) != len(want) {
+		return nil, fmt.Errorf("taxability matrix header is %q, want %q", header, want)
+	}
+	for i, name := range header {
+		if strings.ToLower(strings.TrimSpace(name)) != want[i] {
+			return nil, fmt.Errorf("taxability matrix header is %q, want %q", header, want)
+		}
+	}
+
+	m := &Matrix{rules: make(map[Key]Rule)}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		key, rule, err := parseRule(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		m.rules[key] = rule
+	}
+}
+
+func parseRule(record []string) (Key, Rule, error) {
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	key := Key{
+		Category:     strings.ToLower(record[0]),
+		Jurisdiction: Jurisdiction{Level: strings.ToLower(record[1]), Code: record[2]},
+	}
+	if key.Category == "" {
+		return Key{}, Rule{}, errors.New("empty category")
+	}
+	rule := Rule{Treatment: Treatment(strings.ToLower(record[3]))}
+	switch rule.Treatment {
+	case Standard, Exempt:
+		if record[4] != "" {
+			return Key{}, Rule{}, fmt.Errorf("rate given for %s treatment", rule.Treatment)
+		}
+	case Reduced:
+		rate, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			return Key{}, Rule{}, fmt.Errorf("rate of reduced treatment: %w", err)
+		}
+		rule.Rate = rate
+	default:
+		return Key{}, Rule{}, fmt.Errorf("unknown treatment %q", record[3])
+	}
+	return key, rule, nil
+}
+
+// Len returns the number of rules in the matrix.
+func (m *Matrix) Len() int {
+	return len(m.rules)
+}
+
+// Rule returns the rule of key: that of its jurisdiction, else that of the
+// level of its jurisdiction with any code, else that of any jurisdiction,
+// else Standard. It never fails; it is a LoaderFuncCtx.
+func (m *Matrix) Rule(_ context.Context, key Key) (Rule, error) {
+	key.Category = strings.ToLower(key.Category)
+	for _, j := range []Jurisdiction{key.Jurisdiction, {key.Jurisdiction.Level, Any}, {Any, Any}} {
+		if rule, ok := m.rules[Key{Category: key.Category, Jurisdiction: j}]; ok {
+			return rule, nil
+		}
+	}
+	return Rule{Treatment: Standard}, nil
+}
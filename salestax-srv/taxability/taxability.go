@@ -0,0 +1,131 @@
+// Package taxability decides how the jurisdictions of a tax rate treat a
+// category of products: groceries, clothing, digital goods and the like are
+// exempt, or taxed at a reduced or the standard rate, depending on the
+// state, county or country.
+//
+// The rules form a matrix of category × jurisdiction → Rule, looked up
+// through a Cache of their own and loaded on a miss, typically from a
+// Matrix file. A Resolver combines them with a salestax.RateCache of the
+// rates of addresses into RateFor(address, category). A Calendar of tax
+// holidays exempts categories for a few days on top of that.
+package taxability
+
+import (
+	"context"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Treatment is how a jurisdiction taxes a category of products.
+type Treatment string
+
+// Treatments of a Rule.
+const (
+	Standard Treatment = "standard" // the rate of the jurisdiction
+	Reduced  Treatment = "reduced"  // the Rate of the Rule instead
+	Exempt   Treatment = "exempt"   // no tax
+)
+
+// Rule is the treatment of a category by a jurisdiction.
+type Rule struct {
+	Treatment Treatment `json:"treatment"`
+	Rate      float64   `json:"rate,omitempty"` // the rate applied if Reduced
+}
+
+// Any matches every level or code in a Key of a Matrix.
+const Any = "*"
+
+// Jurisdiction identifies the jurisdiction of a salestax.Component: its
+// Level and its Code, or its Name if it has no code.
+type Jurisdiction struct {
+	Level string `json:"level"`
+	Code  string `json:"code"`
+}
+
+// JurisdictionOf returns the jurisdiction of c.
+func JurisdictionOf(c salestax.Component) Jurisdiction {
+	code := c.Code
+	if code == "" {
+		code = c.Name
+	}
+	return Jurisdiction{Level: c.Level, Code: code}
+}
+
+// Key identifies a Rule in the matrix.
+type Key struct {
+	Category     string
+	Jurisdiction Jurisdiction
+}
+
+// LoaderFunc resolves the rule of a key.
+type LoaderFunc = lrucache.LoaderFunc[Key, Rule]
+
+// LoaderFuncCtx is a LoaderFunc that honors the caller's context.
+type LoaderFuncCtx = lrucache.LoaderFuncCtx[Key, Rule]
+
+// Cache is an LRU cache of taxability rules.
+type Cache struct {
+	*lrucache.LRUCache[Key, Rule]
+}
+
+// NewCache returns a pointer to an initialized Cache.
+func NewCache(sz int, opts ...lrucache.Option) *Cache {
+	return &Cache{lrucache.New[Key, Rule](sz, opts...)}
+}
+
+// Apply returns rate with the rules of category applied to each of its
+// components, calling loader for rules that are not cached. Exempt
+// components are kept at a zero rate, so that the breakdown still lists
+// them.
+func (c *Cache) Apply(ctx context.Context, rate salestax.TaxRate, category string, loader LoaderFuncCtx) (salestax.TaxRate, error) {
+	out := rate
+	out.Category = category
+	out.Components = make([]salestax.Component, len(rate.Components))
+	for i, comp := range rate.Components {
+		rule, err := c.GetOrLoadCtx(ctx, Key{Category: category, Jurisdiction: JurisdictionOf(comp)}, loader)
+		if err != nil {
+			return salestax.TaxRate{}, err
+		}
+		switch rule.Treatment {
+		case Exempt:
+			comp.Rate = 0
+		case Reduced:
+			comp.Rate = rule.Rate
+		}
+		out.Components[i] = comp
+	}
+	return out, nil
+}
+
+// Resolver looks up the rates of product categories at addresses.
+type Resolver struct {
+	rates      *salestax.RateCache
+	rateLoader salestax.RateLoaderFuncCtx
+	rules      *Cache
+	ruleLoader LoaderFuncCtx
+}
+
+// NewResolver returns a Resolver of the rates in rates, loaded with
+// rateLoader on a miss, and the rules in rules, loaded with ruleLoader. A
+// nil rateLoader makes rate lookups cache-only.
+func NewResolver(rates *salestax.RateCache, rateLoader salestax.RateLoaderFuncCtx, rules *Cache, ruleLoader LoaderFuncCtx) *Resolver {
+	return &Resolver{rates: rates, rateLoader: rateLoader, rules: rules, ruleLoader: ruleLoader}
+}
+
+// RateFor returns the rate of products of category at address: its rate
+// with the rules of category applied. An empty category is the rate of
+// address as it is.
+func (r *Resolver) RateFor(ctx context.Context, address, category string) (salestax.TaxRate, error) {
+	var rate salestax.TaxRate
+	var err error
+	if r.rateLoader == nil {
+		rate, err = r.rates.Lookup(address)
+	} else {
+		rate, err = r.rates.GetOrLoadCtx(ctx, address, r.rateLoader)
+	}
+	if err != nil || category == "" {
+		return rate, err
+	}
+	return r.rules.Apply(ctx, rate, category, r.ruleLoader)
+}
@@ -0,0 +1,100 @@
+package taxability
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+const matrix = `category,level,code,treatment,rate
+groceries,state,CA,exempt,
+groceries,state,IL,reduced,0.01
+groceries,county,*,exempt,
+Clothing,*,*,exempt,
+`
+
+func TestMatrix(t *testing.T) {
+	m, err := ReadMatrix(strings.NewReader(matrix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", m.Len())
+	}
+	tests := []struct {
+		key  Key
+		want Rule
+	}{
+		{Key{"groceries", Jurisdiction{"state", "CA"}}, Rule{Treatment: Exempt}},
+		{Key{"Groceries", Jurisdiction{"state", "IL"}}, Rule{Treatment: Reduced, Rate: 0.01}},
+		{Key{"groceries", Jurisdiction{"state", "NY"}}, Rule{Treatment: Standard}},
+		{Key{"groceries", Jurisdiction{"county", "Cook"}}, Rule{Treatment: Exempt}},
+		{Key{"clothing", Jurisdiction{"city", "Chicago"}}, Rule{Treatment: Exempt}},
+		{Key{"digital", Jurisdiction{"state", "CA"}}, Rule{Treatment: Standard}},
+	}
+	for _, tt := range tests {
+		if got, err := m.Rule(context.Background(), tt.key); err != nil || got != tt.want {
+			t.Errorf("Rule(%v) = %+v, %v, want %+v", tt.key, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"category,level,code,treatment\n",
+		"category,level,code,treatment,rate\n,state,CA,exempt,\n",
+		"category,level,code,treatment,rate\ngroceries,state,CA,exempt,0.01\n",
+		"category,level,code,treatment,rate\ngroceries,state,CA,reduced,\n",
+		"category,level,code,treatment,rate\ngroceries,state,CA,free,\n",
+	} {
+		if _, err := ReadMatrix(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadMatrix(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	m, err := ReadMatrix(strings.NewReader(matrix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate := salestax.TaxRate{Components: []salestax.Component{
+		{Level: "state", Code: "IL", Rate: 0.0625},
+		{Level: "county", Name: "Cook", Rate: 0.0175},
+		{Level: "city", Name: "Chicago", Rate: 0.0125},
+	}}
+	loads := 0
+	loader := func(context.Context, string) (salestax.TaxRate, error) {
+		loads++
+		return rate, nil
+	}
+	r := NewResolver(salestax.NewRateCache(10), loader, NewCache(10), m.Rule)
+
+	got, err := r.RateFor(context.Background(), "Chicago, IL", "groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Category != "groceries" || len(got.Components) != 3 {
+		t.Fatalf("RateFor(groceries) = %+v", got)
+	}
+	// reduced by the state, exempt in the county, standard in the city
+	for i, want := range []float64{0.01, 0, 0.0125} {
+		if got.Components[i].Rate != want {
+			t.Errorf("component %d rate = %v, want %v", i, got.Components[i].Rate, want)
+		}
+	}
+	if rate.Components[0].Rate != 0.0625 {
+		t.Error("RateFor modified the cached rate")
+	}
+
+	if got, err := r.RateFor(context.Background(), "Chicago, IL", ""); err != nil || got.Total() != rate.Total() {
+		t.Errorf("RateFor(\"\") = %+v, %v, want the rate as it is", got, err)
+	}
+	if got, err := r.RateFor(context.Background(), "Chicago, IL", "clothing"); err != nil || got.Total() != 0 {
+		t.Errorf("RateFor(clothing) = %+v, %v, want exempt", got, err)
+	}
+	if loads != 1 {
+		t.Errorf("%d loads of the rate, want 1", loads)
+	}
+}
@@ -0,0 +1,124 @@
+package tenant
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Cache is a cache of a tenant whose capacity a Pool manages, such as a
+// salestax.RateCache without a weigher.
+type Cache interface {
+	Len() int
+	Cap() int
+	Resize(int)
+}
+
+// Pool shares a capacity between the caches of tenants, so that one
+// tenant filling its cache, e.g. with a bulk import, evicts its own rates
+// but never those of another. Each cache is guaranteed its quota, the
+// capacity it had when added; the capacity left by the quotas is lent to
+// the caches that fill up, a tenth of the capacity at a time. Caches that
+// no longer use what they borrowed give it back, and once the capacity is
+// used up, the full cache borrowing least takes from the one borrowing
+// most, evicting rates of that one only, until they borrow as much.
+//
+// The capacity is rebalanced by Rebalance, which Run calls periodically.
+type Pool struct {
+	capacity int
+
+	mu      sync.Mutex
+	members []*member
+}
+
+type member struct {
+	cache Cache
+	quota int
+}
+
+// NewPool returns a Pool sharing capacity between the caches added to it.
+func NewPool(capacity int) *Pool {
+	return &Pool{capacity: capacity}
+}
+
+// Add adds c, guaranteeing it its current capacity.
+func (p *Pool) Add(c Cache) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.members = append(p.members, &member{cache: c, quota: c.Cap()})
+}
+
+// Run calls Rebalance every interval until ctx is done.
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Rebalance()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Rebalance resizes the caches for their current use. A cache is full at
+// 95% of its capacity, since its shards may evict before it is, and gives
+// back what it borrowed down to 90% once below 80%.
+func (p *Pool) Rebalance() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	step := max(p.capacity/10, 1)
+	used := 0
+	var full []*member
+	for _, m := range p.members {
+		n, capacity := m.cache.Len(), m.cache.Cap()
+		switch {
+		case n >= capacity-capacity/20:
+			full = append(full, m)
+		case capacity > m.quota && n < capacity-capacity/5:
+			capacity = max(m.quota, n+n/9)
+			m.cache.Resize(capacity)
+		}
+		used += capacity
+	}
+	if len(full) == 0 {
+		return
+	}
+
+	// those at their quota first
+	slices.SortStableFunc(full, func(a, b *member) int {
+		return cmp.Compare(a.borrowed(), b.borrowed())
+	})
+	spare := p.capacity - used
+	if spare <= 0 {
+		// the full cache borrowing least takes from the one borrowing most
+		var lender *member
+		for _, m := range p.members {
+			if lender == nil || m.borrowed() > lender.borrowed() {
+				lender = m
+			}
+		}
+		n := min(step, (lender.borrowed()-full[0].borrowed())/2)
+		if n > 0 {
+			lender.cache.Resize(lender.cache.Cap() - n)
+			full[0].cache.Resize(full[0].cache.Cap() + n)
+		}
+		return
+	}
+	for i, m := range full {
+		if spare <= 0 {
+			break
+		}
+		grant := min(step, max(spare/(len(full)-i), 1))
+		m.cache.Resize(m.cache.Cap() + grant)
+		spare -= grant
+	}
+}
+
+// borrowed returns the capacity m has beyond its quota.
+func (m *member) borrowed() int {
+	return m.cache.Cap() - m.quota
+}
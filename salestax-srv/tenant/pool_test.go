@@ -0,0 +1,91 @@
+package tenant
+
+import "testing"
+
+// fakeCache is a Cache holding n items, dropping those beyond its capacity.
+type fakeCache struct {
+	n, cap int
+}
+
+func (c *fakeCache) Len() int { return c.n }
+func (c *fakeCache) Cap() int { return c.cap }
+
+func (c *fakeCache) Resize(sz int) {
+	c.cap = sz
+	c.n = min(c.n, sz)
+}
+
+// fill adds n items to c, evicting its own once full.
+func (c *fakeCache) fill(n int) {
+	c.n = min(c.n+n, c.cap)
+}
+
+func TestPoolLends(t *testing.T) {
+	a, b := &fakeCache{cap: 100}, &fakeCache{cap: 100}
+	p := NewPool(400)
+	p.Add(a)
+	p.Add(b)
+
+	// a bulk import fills a, which borrows a tenth of the pool at a time
+	for range 5 {
+		a.fill(1000)
+		p.Rebalance()
+	}
+	if a.cap != 300 || b.cap != 100 {
+		t.Errorf("after the import of a: caps %d, %d, want 300, 100", a.cap, b.cap)
+	}
+
+	// a no longer needs it: it gives back all but 90% of its use
+	a.n = 90
+	p.Rebalance()
+	if a.cap != 100 {
+		t.Errorf("cap of a = %d after its use dropped, want its quota 100", a.cap)
+	}
+}
+
+func TestPoolReclaims(t *testing.T) {
+	a, b := &fakeCache{cap: 100}, &fakeCache{cap: 100}
+	p := NewPool(400)
+	p.Add(a)
+	p.Add(b)
+	for range 5 {
+		a.fill(1000)
+		p.Rebalance()
+	}
+	b.fill(50)
+	p.Rebalance()
+	if a.cap != 300 || b.n != 50 {
+		t.Fatalf("caps %d, %d with b half full, want 300, 100", a.cap, b.cap)
+	}
+
+	// b fills up too: what a borrowed goes to b, a tenth at a time, until
+	// both hold as much
+	for range 10 {
+		a.fill(1000)
+		b.fill(1000)
+		p.Rebalance()
+	}
+	if a.cap+b.cap != 400 || a.cap < b.cap-40 || b.cap < a.cap-40 {
+		t.Errorf("caps %d, %d with both full, want an even split of 400", a.cap, b.cap)
+	}
+	if b.cap < 100 || a.cap < 100 {
+		t.Errorf("caps %d, %d below the quotas of 100", a.cap, b.cap)
+	}
+}
+
+func TestPoolQuota(t *testing.T) {
+	a, b := &fakeCache{cap: 200}, &fakeCache{cap: 200}
+	p := NewPool(400)
+	p.Add(a)
+	p.Add(b)
+	b.fill(150)
+
+	// no spare capacity: a evicts its own rates, never those of b
+	for range 5 {
+		a.fill(1000)
+		p.Rebalance()
+	}
+	if a.cap != 200 || b.cap != 200 || b.n != 150 {
+		t.Errorf("a full, b at 150 of 200: caps %d, %d, b holding %d", a.cap, b.cap, b.n)
+	}
+}
@@ -0,0 +1,88 @@
+// Package tenant serves several tenants from one HTTP server. Each tenant
+// has its own handler, typically an httpserver.Server over a cache of its
+// own, sized to the quota of the tenant, and configured with its rounding
+// and taxability rules: the rates one tenant stores, invalidates or evicts
+// never affect those of another.
+//
+// The tenant of a request is that of its client, see auth.Client.Tenant.
+// Requests of clients bound to no tenant, and those of servers without
+// authentication, name theirs in the X-Tenant header. Requests naming none
+// are served by the default tenant.
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+)
+
+// Header is the request header naming the tenant of a request.
+const Header = "X-Tenant"
+
+// Router dispatches the requests of each tenant to its handler. It must be
+// set up with Add before serving, and is then safe for concurrent use.
+type Router struct {
+	tenants map[string]http.Handler
+}
+
+// New returns a Router without tenants.
+func New() *Router {
+	return &Router{tenants: make(map[string]http.Handler)}
+}
+
+// Add serves the requests of the tenant name with h.
+func (rt *Router) Add(name string, h http.Handler) {
+	rt.tenants[name] = h
+}
+
+// Len returns the number of tenants added.
+func (rt *Router) Len() int {
+	return len(rt.tenants)
+}
+
+// Of returns the tenant of r, "" for the default tenant, and whether its
+// client may make requests for it, i.e. is bound to no other tenant. It
+// must be called after authentication.
+func Of(r *http.Request) (name string, ok bool) {
+	name = r.Header.Get(Header)
+	c, authenticated := auth.FromContext(r.Context())
+	if !authenticated || c.Tenant == "" {
+		return name, true
+	}
+	return c.Tenant, name == "" || name == c.Tenant
+}
+
+// Middleware serves the requests of the tenants added with their handler,
+// and those of the default tenant with next. It replies 403 to clients
+// naming a tenant other than theirs and 404 to requests for an unknown
+// tenant. It must run after authentication, i.e. be passed to Server.Use
+// before the authentication middleware.
+func (rt *Router) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := Of(r)
+		if !ok {
+			writeError(w, http.StatusForbidden, fmt.Errorf("client is not of tenant %q", r.Header.Get(Header)))
+			return
+		}
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h, ok := rt.tenants[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown tenant %q", name))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,86 @@
+package tenant
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/auth"
+)
+
+// named replies the name it was given, to tell which handler served.
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	rt := New()
+	rt.Add("acme", named("acme"))
+	rt.Add("globex", named("globex"))
+	if rt.Len() != 2 {
+		t.Errorf("Len = %d, want 2", rt.Len())
+	}
+	h := rt.Middleware(named("default"))
+
+	for _, tc := range []struct {
+		tenant string
+		status int
+		body   string
+	}{
+		{"", http.StatusOK, "default"},
+		{"acme", http.StatusOK, "acme"},
+		{"globex", http.StatusOK, "globex"},
+		{"initech", http.StatusNotFound, `{"error":"unknown tenant \"initech\""}` + "\n"},
+	} {
+		req := httptest.NewRequest("GET", "/rate/x", nil)
+		if tc.tenant != "" {
+			req.Header.Set(Header, tc.tenant)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status || rec.Body.String() != tc.body {
+			t.Errorf("tenant %q: %d %q, want %d %q", tc.tenant, rec.Code, rec.Body, tc.status, tc.body)
+		}
+	}
+}
+
+func TestClientTenant(t *testing.T) {
+	a, err := auth.ReadClients(strings.NewReader(`[
+		{"name": "checkout", "role": "read", "key": "any"},
+		{"name": "acme-pos", "role": "read", "key": "acme", "tenant": "acme"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := New()
+	rt.Add("acme", named("acme"))
+	rt.Add("globex", named("globex"))
+	h := a.Middleware(rt.Middleware(named("default")))
+
+	for _, tc := range []struct {
+		key, tenant string
+		status      int
+		body        string
+	}{
+		{"any", "", http.StatusOK, "default"},
+		{"any", "globex", http.StatusOK, "globex"},
+		{"acme", "", http.StatusOK, "acme"},
+		{"acme", "acme", http.StatusOK, "acme"},
+		{"acme", "globex", http.StatusForbidden, `{"error":"client is not of tenant \"globex\""}` + "\n"},
+	} {
+		req := httptest.NewRequest("GET", "/rate/x", nil)
+		req.Header.Set("X-API-Key", tc.key)
+		if tc.tenant != "" {
+			req.Header.Set(Header, tc.tenant)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status || rec.Body.String() != tc.body {
+			t.Errorf("key %q, tenant %q: %d %q, want %d %q", tc.key, tc.tenant, rec.Code, rec.Body, tc.status, tc.body)
+		}
+	}
+}
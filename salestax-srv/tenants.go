@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/config"
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+	"github.com/jared-d-smith/psl/salestax-srv/taxability"
+	"github.com/jared-d-smith/psl/salestax-srv/tenant"
+)
+
+// tenantRebalance is how often the capacity of cfg.Cache.Tenants is
+// rebalanced between the caches of the tenants.
+const tenantRebalance = 10 * time.Second
+
+// newTenants returns the router of cfg.Tenants, each served by a server of
+// its own over a cache made with opts and sized to its quota, and the
+// caches to close once done. The tenants share loader, but neither the
+// second tier, nor the snapshots, nor the admin API of the default tenant.
+// With cfg.Cache.Tenants, the caches share it until ctx is done.
+func newTenants(ctx context.Context, cfg config.Config, loader salestax.RateLoaderFuncCtx, opts ...lrucache.Option) (*tenant.Router, []*salestax.RateCache, error) {
+	rt := tenant.New()
+	var caches []*salestax.RateCache
+	var pool *tenant.Pool
+	if cfg.Cache.Tenants > 0 {
+		pool = tenant.NewPool(cfg.Cache.Tenants)
+	}
+	for _, t := range cfg.Tenants {
+		cc := cfg.Cache
+		if t.Size > 0 {
+			cc.Size = t.Size
+		}
+		c, err := newRateCache(cc, opts...)
+		if err != nil {
+			return nil, caches, err
+		}
+		caches = append(caches, c)
+		ts := httpserver.New("", c, loader)
+		ts.SetMaxBatch(cfg.HTTP.MaxBatch)
+		ts.EnableCoalescing(salestax.NewCoalescer(c, loader))
+		if err := enableTax(ts, c, loader, cfg.TenantTax(t)); err != nil {
+			return nil, caches, err
+		}
+		ts.SetReady(true)
+		rt.Add(t.Name, ts.Handler())
+		if pool != nil {
+			pool.Add(c)
+		}
+		log.Printf("serving tenant %s from a cache of %d rates", t.Name, cc.Size)
+	}
+	if pool != nil {
+		go pool.Run(ctx, tenantRebalance)
+	}
+	return rt, caches, nil
+}
+
+// enableTax sets the rounding of hs and enables the taxability rules and
+// the tax holidays configured by cfg, looking the rates of categories up in
+// c.
+func enableTax(hs *httpserver.Server, c *salestax.RateCache, loader salestax.RateLoaderFuncCtx, cfg config.Tax) error {
+	hs.SetRounding(taxRounding(cfg))
+	if cfg.Taxability != "" {
+		matrix, err := taxability.OpenMatrix(cfg.Taxability)
+		if err != nil {
+			return err
+		}
+		rules := taxability.NewCache(cfg.RulesSize)
+		hs.EnableTaxability(taxability.NewResolver(c, loader, rules, matrix.Rule))
+	}
+	if cfg.Holidays != "" {
+		calendar, err := taxability.OpenCalendar(cfg.Holidays)
+		if err != nil {
+			return err
+		}
+		hs.EnableHolidays(calendar)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+// Package disktier implements lrucache.SecondTier on top of bbolt, a store
+// embedded in a file on local disk, so that a single node holds millions of
+// rates with bounded memory: the in-process cache keeps the hot ones, and
+// its misses are answered from the disk before the loader. Unlike redistier
+// and memcachetier the tier is not shared, every node has its own file.
+//
+// With WithCipher the file holds neither the keys nor the values in clear:
+// entries are stored under the MAC of their key, and their key and value
+// are encrypted.
+package disktier
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/tier"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket holds the entries: the key, or its MAC, and the expiration in Unix
+// nanoseconds (0 for none) as a big-endian uint64 followed by the encoded
+// value.
+var bucket = []byte("entries")
+
+// meta holds the ID of the cipher of the entries under cipherKey, empty if
+// in clear.
+var (
+	meta      = []byte("meta")
+	cipherKey = []byte("cipher")
+)
+
+// Cipher encrypts the entries of a Tier, e.g. a *seal.Cipher.
+type Cipher interface {
+	// ID identifies the key of the cipher.
+	ID() []byte
+	Seal(data, ad []byte) []byte
+	Open(sealed, ad []byte) ([]byte, error)
+	// MAC returns the authentication code of data, which hides it.
+	MAC(data []byte) []byte
+}
+
+// Tier is a second tier for string keys stored in a bbolt file. Expired
+// entries are misses until Sweep removes them.
+type Tier[V any] struct {
+	db     *bolt.DB
+	codec  tier.Codec[V]
+	cipher Cipher // nil for none
+
+	done     chan struct{} // closed by Close to stop the sweeper
+	finished chan struct{}
+}
+
+var _ lrucache.SecondTier[string, float64] = (*Tier[float64])(nil)
+
+// Option configures a Tier.
+type Option func(*options)
+
+type options struct {
+	bolt   bolt.Options
+	sweep  time.Duration
+	cipher Cipher
+}
+
+// WithNoSync does not sync the file to disk after every write, which makes
+// them much faster but may leave the file corrupt after a power loss, to be
+// removed before restarting.
+func WithNoSync() Option {
+	return func(o *options) {
+		o.bolt.NoSync = true
+	}
+}
+
+// WithSweepInterval calls Sweep every interval until Close, instead of
+// leaving the expired entries on disk until they are looked up again.
+func WithSweepInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.sweep = interval
+	}
+}
+
+// WithCipher encrypts the entries with c. The entries written in clear or
+// with another key, an older key of c included, are removed by Open: the
+// tier is emptied when encryption is enabled or the key rotated.
+func WithCipher(c Cipher) Option {
+	return func(o *options) {
+		o.cipher = c
+	}
+}
+
+// Open opens the tier stored in the file at path, creating it if needed,
+// with values encoded with codec. The file is locked until Close.
+func Open[V any](path string, codec tier.Codec[V], opts ...Option) (*Tier[V], error) {
+	o := options{bolt: bolt.Options{Timeout: time.Second, NoFreelistSync: true}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	db, err := bolt.Open(path, 0o600, &o.bolt)
+	if err != nil {
+		return nil, err
+	}
+	id := []byte{}
+	if o.cipher != nil {
+		id = o.cipher.ID()
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		m, err := tx.CreateBucketIfNotExists(meta)
+		if err != nil {
+			return err
+		}
+		if b := tx.Bucket(bucket); b != nil && !bytes.Equal(m.Get(cipherKey), id) {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+			return err
+		}
+		return m.Put(cipherKey, id)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	t := &Tier[V]{db: db, codec: codec, cipher: o.cipher, done: make(chan struct{}), finished: make(chan struct{})}
+	go t.sweeper(o.sweep)
+	return t, nil
+}
+
+func (t *Tier[V]) Get(ctx context.Context, key string) (V, bool, error) {
+	var zero V
+	if err := ctx.Err(); err != nil {
+		return zero, false, err
+	}
+	var data []byte
+	k := t.storedKey(key)
+	err := t.db.View(func(tx *bolt.Tx) error {
+		if entry := tx.Bucket(bucket).Get(k); entry != nil && !expired(entry, time.Now()) {
+			// entry is only valid during the transaction
+			data = append([]byte(nil), entry[8:]...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return zero, false, err
+	}
+	if t.cipher != nil {
+		// an entry that fails to open was altered, or sealed under the MAC
+		// of another key: it is a miss, not a failure of the tier
+		stored, plain, err := t.open(k, data)
+		if err != nil || stored != key {
+			return zero, false, nil
+		}
+		data = plain
+	}
+	value, err := t.codec.Decode(data)
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+func (t *Tier[V]) Set(ctx context.Context, key string, value V, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	var expires uint64
+	if ttl > 0 {
+		expires = uint64(time.Now().Add(ttl).UnixNano())
+	}
+	k := t.storedKey(key)
+	if t.cipher != nil {
+		data = t.seal(k, key, data)
+	}
+	entry := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(data)), expires)
+	entry = append(entry, data...)
+	// concurrent writes share a transaction, and its sync
+	return t.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(k, entry)
+	})
+}
+
+func (t *Tier[V]) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(t.storedKey(key))
+	})
+}
+
+// DeleteFunc removes the entries for which match returns true, expired ones
+// included, and returns the number removed. It scans the whole file in one
+// transaction, blocking the writes meanwhile. Entries that fail to decode
+// are left alone.
+func (t *Tier[V]) DeleteFunc(match func(key string, value V) bool) (int, error) {
+	n := 0
+	err := t.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, entry := c.First(); k != nil; {
+			if len(entry) < 8 {
+				k, entry = c.Next()
+				continue
+			}
+			key, data := string(k), entry[8:]
+			var err error
+			if t.cipher != nil {
+				key, data, err = t.open(k, data)
+			}
+			var value V
+			if err == nil {
+				value, err = t.codec.Decode(data)
+			}
+			if err != nil || !match(key, value) {
+				k, entry = c.Next()
+				continue
+			}
+			if k, entry, err = deleteAt(c, k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Sweep removes the expired entries and returns their number.
+func (t *Tier[V]) Sweep() (int, error) {
+	n := 0
+	now := time.Now()
+	err := t.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, entry := c.First(); k != nil; {
+			if !expired(entry, now) {
+				k, entry = c.Next()
+				continue
+			}
+			var err error
+			if k, entry, err = deleteAt(c, k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Len returns the number of entries, expired ones included.
+func (t *Tier[V]) Len() int {
+	n := 0
+	t.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Close stops the sweeper and closes the file.
+func (t *Tier[V]) Close() error {
+	close(t.done)
+	<-t.finished
+	return t.db.Close()
+}
+
+// sweeper calls Sweep every interval, if positive, until Close. A failed
+// sweep is retried at the next interval.
+func (t *Tier[V]) sweeper(interval time.Duration) {
+	defer close(t.finished)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Sweep()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// storedKey returns the key the entry of key is stored under.
+func (t *Tier[V]) storedKey(key string) []byte {
+	if t.cipher == nil {
+		return []byte(key)
+	}
+	return t.cipher.MAC([]byte(key))
+}
+
+// seal encrypts key, which the MAC k hides, and data, the value of its
+// entry: the length of key as a uvarint, key and data.
+func (t *Tier[V]) seal(k []byte, key string, data []byte) []byte {
+	plain := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(key)+len(data)), uint64(len(key)))
+	plain = append(plain, key...)
+	return t.cipher.Seal(append(plain, data...), k)
+}
+
+// open decrypts the key and the data of the value of the entry stored
+// under k, sealed by seal.
+func (t *Tier[V]) open(k, sealed []byte) (key string, data []byte, err error) {
+	plain, err := t.cipher.Open(sealed, k)
+	if err != nil {
+		return "", nil, err
+	}
+	n, size := binary.Uvarint(plain)
+	if size <= 0 || uint64(len(plain)-size) < n {
+		return "", nil, errors.New("disktier: malformed entry")
+	}
+	plain = plain[size:]
+	return string(plain[:n]), plain[n:], nil
+}
+
+// deleteAt deletes the entry of k, where c is, and returns the next one:
+// c.Next would skip it after a deletion.
+func deleteAt(c *bolt.Cursor, k []byte) (next, entry []byte, err error) {
+	k = slices.Clone(k)
+	if err := c.Delete(); err != nil {
+		return nil, nil, err
+	}
+	next, entry = c.Seek(k)
+	return next, entry, nil
+}
+
+// expired reports whether entry expired at now. A malformed entry counts
+// as expired.
+func expired(entry []byte, now time.Time) bool {
+	if len(entry) < 8 {
+		return true
+	}
+	expires := binary.BigEndian.Uint64(entry)
+	return expires != 0 && now.UnixNano() > int64(expires)
+}
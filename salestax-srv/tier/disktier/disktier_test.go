@@ -0,0 +1,286 @@
+package disktier
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/seal"
+	"github.com/jared-d-smith/psl/salestax-srv/tier"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTier(t *testing.T, path string, opts ...Option) *Tier[float64] {
+	t.Helper()
+	d, err := Open[float64](path, tier.Float64{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func newCipher(t *testing.T) *seal.Cipher {
+	t.Helper()
+	k := make([]byte, seal.KeySize)
+	rand.Read(k)
+	c, err := seal.New(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// keys returns the keys stored in d, in clear, in order.
+func keys(t *testing.T, d *Tier[float64]) []string {
+	t.Helper()
+	var ks []string
+	if _, err := d.DeleteFunc(func(key string, _ float64) bool {
+		ks = append(ks, key)
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(ks)
+	return ks
+}
+
+func TestSetGetDelete(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tier.db")
+	d := openTier(t, path)
+
+	if _, ok, err := d.Get(ctx, "a"); ok || err != nil {
+		t.Errorf("Get of a missing key = %v, %v, want a miss", ok, err)
+	}
+	if err := d.Set(ctx, "a", 0.0625, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set(ctx, "b", 0.0825, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := d.Get(ctx, "a"); v != 0.0625 || !ok || err != nil {
+		t.Errorf("Get(a) = %v, %v, %v, want 0.0625", v, ok, err)
+	}
+	if err := d.Set(ctx, "a", 0.07, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := d.Get(ctx, "a"); v != 0.07 {
+		t.Errorf("Get(a) after an overwrite = %v, want 0.07", v)
+	}
+	if err := d.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := d.Get(ctx, "a"); ok {
+		t.Error("a is still stored after Delete")
+	}
+	if err := d.Delete(ctx, "a"); err != nil {
+		t.Errorf("Delete of a missing key = %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := d.Get(cancelled, "b"); err == nil {
+		t.Error("Get with a cancelled context succeeded")
+	}
+	if err := d.Set(cancelled, "c", 0.01, 0); err == nil {
+		t.Error("Set with a cancelled context succeeded")
+	}
+
+	// the entries outlive the process
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = openTier(t, path)
+	defer d.Close()
+	if v, ok, err := d.Get(ctx, "b"); v != 0.0825 || !ok || err != nil {
+		t.Errorf("Get(b) after reopening = %v, %v, %v, want 0.0825", v, ok, err)
+	}
+	if n := d.Len(); n != 1 {
+		t.Errorf("Len = %d, want 1", n)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	d := openTier(t, filepath.Join(t.TempDir(), "tier.db"))
+	defer d.Close()
+	d.Set(ctx, "short", 0.05, time.Millisecond)
+	d.Set(ctx, "long", 0.06, time.Hour)
+	d.Set(ctx, "forever", 0.07, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok, err := d.Get(ctx, "short"); ok || err != nil {
+		t.Errorf("Get of an expired entry = %v, %v, want a miss", ok, err)
+	}
+	if n := d.Len(); n != 3 {
+		t.Errorf("Len before Sweep = %d, want the expired entry counted", n)
+	}
+	if n, err := d.Sweep(); n != 1 || err != nil {
+		t.Errorf("Sweep = %d, %v, want 1", n, err)
+	}
+	if got := keys(t, d); !slices.Equal(got, []string{"forever", "long"}) {
+		t.Errorf("after Sweep the tier holds %q", got)
+	}
+	if n, _ := d.Sweep(); n != 0 {
+		t.Errorf("second Sweep = %d, want 0", n)
+	}
+}
+
+func TestSweepInterval(t *testing.T) {
+	ctx := context.Background()
+	d := openTier(t, filepath.Join(t.TempDir(), "tier.db"), WithSweepInterval(time.Millisecond), WithNoSync())
+	d.Set(ctx, "a", 0.05, time.Millisecond)
+	for deadline := time.Now().Add(5 * time.Second); d.Len() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the sweeper did not remove the expired entry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"clear", nil},
+		{"sealed", []Option{WithCipher(newCipher(t))}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openTier(t, filepath.Join(t.TempDir(), "tier.db"), tt.opts...)
+			defer d.Close()
+			var want []string
+			for i := range 100 {
+				key := strconv.Itoa(i)
+				d.Set(ctx, key, float64(i)/1000, 0)
+				if i%10 >= 3 {
+					want = append(want, key)
+				}
+			}
+			// expired entries are matched too
+			d.Set(ctx, "expired", 1, time.Nanosecond)
+			time.Sleep(time.Millisecond)
+
+			// runs of consecutive entries are deleted, where c.Next after a
+			// deletion would skip one
+			n, err := d.DeleteFunc(func(key string, value float64) bool {
+				if key == "expired" {
+					return value == 1
+				}
+				i, _ := strconv.Atoi(key)
+				return float64(i)/1000 == value && i%10 < 3
+			})
+			if n != 31 || err != nil {
+				t.Errorf("DeleteFunc = %d, %v, want 31", n, err)
+			}
+			slices.Sort(want)
+			if got := keys(t, d); !slices.Equal(got, want) {
+				t.Errorf("after DeleteFunc the tier holds %q, want %q", got, want)
+			}
+
+			if n, _ := d.DeleteFunc(func(string, float64) bool { return true }); n != len(want) || d.Len() != 0 {
+				t.Errorf("DeleteFunc of all = %d leaving %d, want %d leaving none", n, d.Len(), len(want))
+			}
+		})
+	}
+}
+
+func TestCipher(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tier.db")
+	c := newCipher(t)
+	d := openTier(t, path, WithCipher(c))
+	d.Set(ctx, "1 Congress Ave", 0.0825, 0)
+	if v, ok, err := d.Get(ctx, "1 Congress Ave"); v != 0.0825 || !ok || err != nil {
+		t.Errorf("Get of a sealed entry = %v, %v, %v, want 0.0825", v, ok, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(file), "Congress") || strings.Contains(string(file), "0.0825") {
+		t.Error("the file holds the key or the value in clear")
+	}
+
+	// the same key keeps the entries
+	d = openTier(t, path, WithCipher(c))
+	if d.Len() != 1 {
+		t.Errorf("reopening with the same cipher left %d entries, want 1", d.Len())
+	}
+	d.Close()
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"another key", []Option{WithCipher(newCipher(t))}},
+		{"in clear", nil},
+		{"sealed again", []Option{WithCipher(c)}},
+	} {
+		d := openTier(t, path, tt.opts...)
+		if d.Len() != 0 {
+			t.Errorf("reopening %s left %d entries, want the tier emptied", tt.name, d.Len())
+		}
+		if _, ok, err := d.Get(ctx, "1 Congress Ave"); ok || err != nil {
+			t.Errorf("Get after reopening %s = %v, %v, want a miss", tt.name, ok, err)
+		}
+		d.Set(ctx, "1 Congress Ave", 0.0825, 0)
+		d.Close()
+	}
+}
+
+func TestTampered(t *testing.T) {
+	ctx := context.Background()
+	d := openTier(t, filepath.Join(t.TempDir(), "tier.db"), WithCipher(newCipher(t)))
+	defer d.Close()
+	d.Set(ctx, "a", 0.05, 0)
+	d.Set(ctx, "b", 0.06, 0)
+	d.Set(ctx, "c", 0.07, 0)
+
+	alter := func(key string, f func(entry []byte) []byte) {
+		t.Helper()
+		if err := d.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(bucket)
+			k := d.storedKey(key)
+			return b.Put(k, f(slices.Clone(b.Get(k))))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a flipped bit, and the entry of b moved under the MAC of a
+	alter("c", func(entry []byte) []byte {
+		entry[len(entry)-1] ^= 1
+		return entry
+	})
+	var entryB []byte
+	d.db.View(func(tx *bolt.Tx) error {
+		entryB = slices.Clone(tx.Bucket(bucket).Get(d.storedKey("b")))
+		return nil
+	})
+	alter("a", func([]byte) []byte { return entryB })
+
+	for _, key := range []string{"a", "c"} {
+		if v, ok, err := d.Get(ctx, key); ok || err != nil {
+			t.Errorf("Get of the tampered %s = %v, %v, %v, want a miss", key, v, ok, err)
+		}
+	}
+	if v, ok, err := d.Get(ctx, "b"); v != 0.06 || !ok || err != nil {
+		t.Errorf("Get(b) = %v, %v, %v, want 0.06", v, ok, err)
+	}
+	if n, err := d.DeleteFunc(func(string, float64) bool { return true }); n != 1 || err != nil {
+		t.Errorf("DeleteFunc of all = %d, %v, want the tampered entries left alone", n, err)
+	}
+}
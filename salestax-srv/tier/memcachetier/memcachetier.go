@@ -0,0 +1,114 @@
+// Package memcachetier implements lrucache.SecondTier on top of memcached. It
+// is a drop-in alternative to redistier for deployments that already run
+// memcached.
+package memcachetier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/tier"
+)
+
+// maxKeyLen is the longest key memcached accepts.
+const maxKeyLen = 250
+
+// maxRelativeTTL is the longest expiration memcached interprets as relative;
+// larger values are taken as a Unix timestamp.
+const maxRelativeTTL = 30 * 24 * time.Hour
+
+// Tier is a memcached backed second tier for string keys. All keys are stored
+// under a common prefix so that the cache can share a memcached cluster.
+type Tier[V any] struct {
+	client *memcache.Client
+	prefix string
+	codec  tier.Codec[V]
+}
+
+var _ lrucache.SecondTier[string, float64] = (*Tier[float64])(nil)
+
+// New returns a second tier storing values in client under prefix, encoded
+// with codec. The memcached client does not support contexts; the context
+// is only checked before each request.
+func New[V any](client *memcache.Client, prefix string, codec tier.Codec[V]) *Tier[V] {
+	return &Tier[V]{client: client, prefix: prefix, codec: codec}
+}
+
+func (t *Tier[V]) Get(ctx context.Context, key string) (V, bool, error) {
+	var zero V
+	if err := ctx.Err(); err != nil {
+		return zero, false, err
+	}
+	item, err := t.client.Get(t.key(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+	value, err := t.codec.Decode(item.Value)
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+func (t *Tier[V]) Set(ctx context.Context, key string, value V, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return t.client.Set(&memcache.Item{Key: t.key(key), Value: data, Expiration: expiration(ttl)})
+}
+
+func (t *Tier[V]) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := t.client.Delete(t.key(key))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// key returns the memcached key for key. Addresses contain spaces, which
+// memcached does not allow, and may be longer than maxKeyLen, so such keys
+// are replaced by their SHA-256.
+func (t *Tier[V]) key(key string) string {
+	k := t.prefix + key
+	if len(k) <= maxKeyLen && legalKey(k) {
+		return k
+	}
+	sum := sha256.Sum256([]byte(key))
+	return t.prefix + "#" + hex.EncodeToString(sum[:])
+}
+
+func legalKey(k string) bool {
+	for i := 0; i < len(k); i++ {
+		if k[i] <= ' ' || k[i] == 0x7f || k[i] == '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// expiration converts ttl to memcached's expiration field: seconds for
+// short TTLs, an absolute Unix time beyond 30 days, 0 for none.
+func expiration(ttl time.Duration) int32 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl > maxRelativeTTL:
+		return int32(time.Now().Add(ttl).Unix())
+	}
+	return int32((ttl + time.Second - 1) / time.Second)
+}
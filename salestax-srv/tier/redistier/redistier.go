@@ -0,0 +1,57 @@
+// Package redistier implements lrucache.SecondTier on top of Redis, so that
+// several salestax-srv instances share one warm set of rates.
+package redistier
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/tier"
+	"github.com/redis/go-redis/v9"
+)
+
+// Tier is a Redis backed second tier for string keys. All keys are stored
+// under a common prefix so that the cache can share a Redis instance.
+type Tier[V any] struct {
+	client redis.UniversalClient
+	prefix string
+	codec  tier.Codec[V]
+}
+
+var _ lrucache.SecondTier[string, float64] = (*Tier[float64])(nil)
+
+// New returns a second tier storing values in client under prefix, encoded
+// with codec.
+func New[V any](client redis.UniversalClient, prefix string, codec tier.Codec[V]) *Tier[V] {
+	return &Tier[V]{client: client, prefix: prefix, codec: codec}
+}
+
+func (t *Tier[V]) Get(ctx context.Context, key string) (V, bool, error) {
+	var zero V
+	data, err := t.client.Get(ctx, t.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+	value, err := t.codec.Decode(data)
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+func (t *Tier[V]) Set(ctx context.Context, key string, value V, ttl time.Duration) error {
+	data, err := t.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return t.client.Set(ctx, t.prefix+key, data, ttl).Err()
+}
+
+func (t *Tier[V]) Delete(ctx context.Context, key string) error {
+	return t.client.Del(ctx, t.prefix+key).Err()
+}
@@ -0,0 +1,40 @@
+// Package tier holds the pieces shared by the second tier adapters
+// (redistier, memcachetier, disktier) that plug into lrucache.WithSecondTier.
+package tier
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Codec converts cached values to and from the bytes stored in a remote tier.
+type Codec[V any] interface {
+	Encode(value V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// JSON encodes values with encoding/json. It works for any value type that
+// round trips through JSON.
+type JSON[V any] struct{}
+
+func (JSON[V]) Encode(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSON[V]) Decode(data []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// Float64 stores rates as their shortest decimal representation, which keeps
+// them readable with redis-cli or a memcached telnet session.
+type Float64 struct{}
+
+func (Float64) Encode(value float64) ([]byte, error) {
+	return strconv.AppendFloat(nil, value, 'g', -1, 64), nil
+}
+
+func (Float64) Decode(data []byte) (float64, error) {
+	return strconv.ParseFloat(string(data), 64)
+}
@@ -0,0 +1,162 @@
+// Package tracing adds OpenTelemetry spans to cache lookups and loader calls
+// and propagates trace context through the HTTP and gRPC servers, so that
+// tax backend latency shows up in distributed traces.
+//
+//	tr := tracing.New[string](nil) // global TracerProvider
+//	cache := salestax.New(sz, lrucache.WithTracer[string](tr))
+//	loader = tracing.InstrumentLoaderCtx(nil, loader)
+//
+// Keys such as street addresses are personal data, so spans only carry a hash
+// of the key.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+)
+
+// ScopeName is the instrumentation scope of the spans created here.
+const ScopeName = "github.com/jared-d-smith/psl/salestax-srv/tracing"
+
+// Span attribute keys.
+const (
+	KeyHashAttr      = attribute.Key("cache.key_hash")
+	HitAttr          = attribute.Key("cache.hit")
+	LoadDurationAttr = attribute.Key("cache.load_duration_ms")
+)
+
+func tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(ScopeName)
+}
+
+// Tracer implements lrucache.Tracer with one span per lookup.
+type Tracer[K comparable] struct {
+	tracer trace.Tracer
+}
+
+var _ lrucache.Tracer[string] = (*Tracer[string])(nil)
+
+// New returns a Tracer creating spans with tp, or with the global
+// TracerProvider if tp is nil.
+func New[K comparable](tp trace.TracerProvider) *Tracer[K] {
+	return &Tracer[K]{tracer: tracer(tp)}
+}
+
+// StartLookup starts a "cache.Lookup" span carrying the key hash. The span
+// records whether the lookup was a hit and any error.
+func (t *Tracer[K]) StartLookup(ctx context.Context, key K) (context.Context, func(bool, error)) {
+	ctx, span := t.tracer.Start(ctx, "cache.Lookup", trace.WithAttributes(KeyHashAttr.String(KeyHash(key))))
+	return ctx, func(hit bool, err error) {
+		span.SetAttributes(HitAttr.Bool(hit))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// InstrumentLoaderCtx wraps loader in a "cache.Load" span, a child of the
+// lookup span when used with Tracer. tp may be nil for the global provider.
+func InstrumentLoaderCtx[K comparable, V any](tp trace.TracerProvider, loader lrucache.LoaderFuncCtx[K, V]) lrucache.LoaderFuncCtx[K, V] {
+	t := tracer(tp)
+	return func(ctx context.Context, key K) (V, error) {
+		ctx, span := t.Start(ctx, "cache.Load", trace.WithAttributes(KeyHashAttr.String(KeyHash(key))))
+		defer span.End()
+		start := time.Now()
+		value, err := loader(ctx, key)
+		span.SetAttributes(LoadDurationAttr.Float64(float64(time.Since(start)) / float64(time.Millisecond)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return value, err
+	}
+}
+
+// KeyHash returns a stable, non reversible identifier of key (FNV-1a of its
+// default formatting) that is the same on every instance, so that spans for
+// one address can be correlated without recording the address.
+func KeyHash[K comparable](key K) string {
+	h := fnv.New64a()
+	fmt.Fprint(h, key)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// Middleware starts a server span for every HTTP request, continuing the
+// trace of the caller if the request carries trace context.
+func Middleware(tp trace.TracerProvider) func(http.Handler) http.Handler {
+	t := tracer(tp)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			// the path contains the address, keep it out of the span name
+			ctx, span := t.Start(ctx, "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// UnaryServerInterceptor starts a server span for every unary RPC, continuing
+// the trace of the caller if the request metadata carries trace context.
+func UnaryServerInterceptor(tp trace.TracerProvider) grpc.UnaryServerInterceptor {
+	t := tracer(tp)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		ctx, span := t.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		resp, err := handler(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return resp, err
+	}
+}
+
+// Inject adds the trace context of ctx to an outgoing request's headers, for
+// loaders that call an HTTP backend.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (mc metadataCarrier) Get(key string) string {
+	if v := metadata.MD(mc).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (mc metadataCarrier) Set(key, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for k := range mc {
+		keys = append(keys, k)
+	}
+	return keys
+}
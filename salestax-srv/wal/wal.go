@@ -0,0 +1,563 @@
+// Package wal is a write-ahead log of the changes to a cache, so that a
+// restarted server is nearly as warm as before without saving the whole
+// cache periodically: a Log is given to the cache with lrucache.WithJournal,
+// records every item inserted or deleted, and is replayed into the new cache
+// on the next start.
+//
+// The log is a directory of segments, numbered files written one after the
+// other. Once a segment reaches its maximum size the next one is started,
+// and the oldest segments beyond the maximum number are removed: the items
+// they held are lost to the replay, which costs misses and nothing else.
+// Checkpoint removes every segment a snapshot of the cache made redundant.
+//
+// The records are buffered and written out every sync interval, so a crash
+// loses those of the last interval at most. A record cut short by a crash,
+// or damaged since, ends the replay of its segment.
+//
+// A segment starts with segmentMagic and the version of its format, a
+// big-endian uint16, followed by a frame per record: the big-endian uint32
+// length of a payload, the payload and its CRC-32C. The payloads make up a
+// gob stream of records, whose unknown fields older binaries skip. Segments
+// of version 1, bare gob streams, are still replayed; those of a newer
+// version fail Replay with ErrVersion. Version 3 is version 2 with the
+// payloads encrypted by the Cipher of WithCipher.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a Log.
+const (
+	DefaultMaxSize      = 64 << 20 // bytes of a segment
+	DefaultMaxSegments  = 8
+	DefaultSyncInterval = time.Second
+)
+
+// suffix is the extension of the segment files.
+const suffix = ".wal"
+
+const (
+	segmentMagic   = "\x89WAL\r\n\x1a\n"
+	segmentVersion = 2
+	sealedVersion  = 3
+	headerSize     = len(segmentMagic) + 2
+	// maxFrame bounds the payload allocated for a damaged length.
+	maxFrame = 1 << 30
+)
+
+// Errors of Replay, for use with errors.Is.
+var (
+	// ErrVersion is returned for a segment written in a newer format.
+	ErrVersion = errors.New("wal: unsupported segment version")
+	// ErrEncrypted is returned for an encrypted segment without
+	// WithCipher.
+	ErrEncrypted = errors.New("wal: segment encrypted, and no cipher given")
+)
+
+// Cipher encrypts the records of a Log, e.g. a *seal.Cipher.
+type Cipher interface {
+	Seal(data, ad []byte) []byte
+	Open(sealed, ad []byte) ([]byte, error)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// record is a change to the cache, an insertion unless Delete.
+type record[K comparable, V any] struct {
+	Delete  bool
+	Key     K
+	Value   V
+	Expires time.Time
+}
+
+// Log is a write-ahead log of the changes to a cache holding K keys and V
+// values, which must be gob encodable. It implements lrucache.Journal and
+// is safe for concurrent use.
+type Log[K comparable, V any] struct {
+	dir         string
+	maxSize     int64
+	maxSegments int
+	cipher      Cipher // nil for none
+	logger      *slog.Logger
+
+	mu       sync.Mutex
+	seq      uint64 // number of the segment written
+	f        *os.File
+	w        *bufio.Writer
+	cw       *countingWriter // over w, counts the size of the segment
+	buf      bytes.Buffer    // payload of the record encoded
+	enc      *gob.Encoder    // to buf
+	frame    []byte
+	unlogged bool // the changes are those of Unlogged
+	closed   bool
+
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// Option configures a Log.
+type Option func(*options)
+
+type options struct {
+	maxSize      int64
+	maxSegments  int
+	syncInterval time.Duration
+	cipher       Cipher
+	logger       *slog.Logger
+}
+
+// WithMaxSize sets the size from which the next segment is started, by
+// default DefaultMaxSize.
+func WithMaxSize(bytes int64) Option {
+	return func(o *options) {
+		o.maxSize = bytes
+	}
+}
+
+// WithMaxSegments sets the number of segments kept, by default
+// DefaultMaxSegments.
+func WithMaxSegments(n int) Option {
+	return func(o *options) {
+		o.maxSegments = n
+	}
+}
+
+// WithSyncInterval sets how often the records are written out and synced
+// to disk, by default DefaultSyncInterval.
+func WithSyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = d
+	}
+}
+
+// WithCipher encrypts the records written with c, and decrypts those of
+// the encrypted segments replayed. Segments in clear are still replayed.
+func WithCipher(c Cipher) Option {
+	return func(o *options) {
+		o.cipher = c
+	}
+}
+
+// WithLogger logs the failures to write the log to logger instead of
+// slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Open opens the log in dir, creating dir if needed, and starts a new
+// segment after those already there, which Replay reads.
+func Open[K comparable, V any](dir string, opts ...Option) (*Log[K, V], error) {
+	o := options{
+		maxSize:      DefaultMaxSize,
+		maxSegments:  DefaultMaxSegments,
+		syncInterval: DefaultSyncInterval,
+		logger:       slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log[K, V]{
+		dir:         dir,
+		maxSize:     o.maxSize,
+		maxSegments: max(o.maxSegments, 1),
+		cipher:      o.cipher,
+		logger:      o.logger,
+		done:        make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+	if len(segments) > 0 {
+		l.seq = segments[len(segments)-1]
+	}
+	l.mu.Lock()
+	err = l.rotateLocked()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go l.syncer(o.syncInterval)
+	return l, nil
+}
+
+// Insert logs the insertion of value for key, expiring at expires.
+func (l *Log[K, V]) Insert(key K, value V, expires time.Time) {
+	l.append(record[K, V]{Key: key, Value: value, Expires: expires})
+}
+
+// Delete logs the deletion of key.
+func (l *Log[K, V]) Delete(key K) {
+	l.append(record[K, V]{Delete: true, Key: key})
+}
+
+func (l *Log[K, V]) append(r record[K, V]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unlogged || l.closed || l.enc == nil {
+		return
+	}
+	l.buf.Reset()
+	if err := l.enc.Encode(&r); err != nil {
+		l.logger.Warn("wal: encoding record", "dir", l.dir, "error", err)
+		return
+	}
+	payload := l.buf.Bytes()
+	if l.cipher != nil {
+		payload = l.cipher.Seal(payload, nil)
+	}
+	l.frame = binary.BigEndian.AppendUint32(l.frame[:0], uint32(len(payload)))
+	l.frame = append(l.frame, payload...)
+	l.frame = binary.BigEndian.AppendUint32(l.frame, crc32.Checksum(payload, castagnoli))
+	if _, err := l.cw.Write(l.frame); err != nil {
+		l.logger.Warn("wal: writing record", "dir", l.dir, "error", err)
+		return
+	}
+	if l.cw.n >= l.maxSize {
+		if err := l.rotateLocked(); err != nil {
+			l.logger.Warn("wal: starting segment", "dir", l.dir, "error", err)
+		}
+	}
+}
+
+// Replay calls restore for the insertions and evict for the deletions
+// logged in the segments before the one Open started, oldest first, and
+// returns the number of records replayed. Insertions that expired since
+// are replayed as deletions. The changes the replay makes to the cache are
+// not logged again. It must be called before the cache is used, after
+// loading the snapshot of the last Checkpoint, if any.
+func (l *Log[K, V]) Replay(restore func(key K, value V, expires time.Time) error, evict func(key K) bool) (n int, err error) {
+	err = l.Unlogged(func() error {
+		n, err = l.replay(restore, evict)
+		return err
+	})
+	return n, err
+}
+
+// Unlogged calls fn, e.g. loading a snapshot into the cache, without
+// logging the changes made meanwhile. It must be called before the cache
+// is used.
+func (l *Log[K, V]) Unlogged(fn func() error) error {
+	l.mu.Lock()
+	l.unlogged = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.unlogged = false
+		l.mu.Unlock()
+	}()
+	return fn()
+}
+
+func (l *Log[K, V]) replay(restore func(key K, value V, expires time.Time) error, evict func(key K) bool) (int, error) {
+	l.mu.Lock()
+	current := l.seq
+	l.mu.Unlock()
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	now := time.Now()
+	for _, seq := range segments {
+		if seq >= current {
+			break
+		}
+		f, err := os.Open(l.path(seq))
+		if err != nil {
+			return n, err
+		}
+		dec, err := newDecoder(f, l.cipher)
+		if err != nil {
+			f.Close()
+			return n, fmt.Errorf("%s: %w", l.path(seq), err)
+		}
+		for {
+			var r record[K, V]
+			if err := dec.Decode(&r); err != nil {
+				if !errors.Is(err, io.EOF) {
+					l.logger.Warn("wal: segment cut short or damaged", "path", l.path(seq), "error", err)
+				}
+				break
+			}
+			switch {
+			case r.Delete, !r.Expires.IsZero() && now.After(r.Expires):
+				evict(r.Key)
+			default:
+				// an item too heavy for the cache is dropped
+				restore(r.Key, r.Value, r.Expires)
+			}
+			n++
+		}
+		f.Close()
+	}
+	return n, nil
+}
+
+// Checkpoint starts a new segment and calls save, which saves a snapshot of
+// the cache; once it succeeds, the segments before the new one are removed,
+// as the snapshot holds their changes. The changes made while save runs are
+// logged in the new segment, to be replayed after loading the snapshot.
+func (l *Log[K, V]) Checkpoint(save func() error) error {
+	l.mu.Lock()
+	var err error
+	mark := l.seq + 1 // once closed, every segment
+	if !l.closed {
+		err = l.rotateLocked()
+		mark = l.seq
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, seq := range segments {
+		if seq < mark {
+			if err := os.Remove(l.path(seq)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes out the records buffered, syncs the segment and closes it,
+// removing it if empty so that restarts do not push the others out. The
+// changes made afterwards are not logged.
+func (l *Log[K, V]) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+	close(l.done)
+	<-l.finished
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	empty := l.cw != nil && l.cw.n == int64(headerSize)
+	if err := l.closeSegmentLocked(); err != nil {
+		return err
+	}
+	if empty {
+		return os.Remove(l.path(l.seq))
+	}
+	return nil
+}
+
+// syncer writes the records out every interval until Close.
+func (l *Log[K, V]) syncer(interval time.Duration) {
+	defer close(l.finished)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			err := l.syncLocked()
+			l.mu.Unlock()
+			if err != nil {
+				l.logger.Warn("wal: syncing segment", "dir", l.dir, "error", err)
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *Log[K, V]) syncLocked() error {
+	if l.f == nil {
+		return nil
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+func (l *Log[K, V]) closeSegmentLocked() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.syncLocked()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f, l.w, l.cw, l.enc = nil, nil, nil, nil
+	return err
+}
+
+// rotateLocked closes the segment written and starts the next one, removing
+// the oldest beyond the maximum number of segments.
+func (l *Log[K, V]) rotateLocked() error {
+	if err := l.closeSegmentLocked(); err != nil {
+		l.logger.Warn("wal: closing segment", "path", l.path(l.seq), "error", err)
+	}
+	l.seq++
+	f, err := os.OpenFile(l.path(l.seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f, l.w = f, bufio.NewWriter(f)
+	l.cw = &countingWriter{w: l.w}
+	// each segment is a gob stream of its own, decodable without the others
+	l.enc = gob.NewEncoder(&l.buf)
+	version := uint16(segmentVersion)
+	if l.cipher != nil {
+		version = sealedVersion
+	}
+	if _, err := l.cw.Write(binary.BigEndian.AppendUint16([]byte(segmentMagic), version)); err != nil {
+		return err
+	}
+
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	for len(segments) > l.maxSegments {
+		if err := os.Remove(l.path(segments[0])); err != nil {
+			return err
+		}
+		segments = segments[1:]
+	}
+	return nil
+}
+
+func (l *Log[K, V]) path(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", seq, suffix))
+}
+
+// newDecoder returns the decoder of the records of the segment read from r,
+// whichever its version, decrypted with c if encrypted.
+func newDecoder(r io.Reader, c Cipher) (*gob.Decoder, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(segmentMagic)); err != nil || string(magic) != segmentMagic {
+		return gob.NewDecoder(br), nil // version 1
+	}
+	var header [headerSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, err
+	}
+	switch version := binary.BigEndian.Uint16(header[len(segmentMagic):]); {
+	case version == sealedVersion && c == nil:
+		return nil, ErrEncrypted
+	case version == sealedVersion:
+		return gob.NewDecoder(&frameReader{r: br, cipher: c}), nil
+	case version != segmentVersion:
+		return nil, fmt.Errorf("%w %d", ErrVersion, version)
+	}
+	return gob.NewDecoder(&frameReader{r: br}), nil
+}
+
+// frameReader reads the payloads of the frames of a segment, decrypted
+// with cipher if not nil, failing on a checksum mismatch.
+type frameReader struct {
+	r      io.Reader
+	cipher Cipher
+	frame  []byte
+	rest   []byte // of the payload of frame
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for len(fr.rest) == 0 {
+		if err := fr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, fr.rest)
+	fr.rest = fr.rest[n:]
+	return n, nil
+}
+
+// next reads the next frame, returning io.EOF at the end of r.
+func (fr *frameReader) next() error {
+	var size [4]byte
+	if _, err := io.ReadFull(fr.r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrame {
+		return fmt.Errorf("frame of %d bytes", n)
+	}
+	fr.frame = slices.Grow(fr.frame[:0], int(n)+4)[:n+4]
+	if _, err := io.ReadFull(fr.r, fr.frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	payload := fr.frame[:n]
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(fr.frame[n:]) {
+		return errors.New("checksum mismatch")
+	}
+	if fr.cipher != nil {
+		var err error
+		if payload, err = fr.cipher.Open(payload, nil); err != nil {
+			return err
+		}
+	}
+	fr.rest = payload
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// listSegments returns the numbers of the segments in dir, in order.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), suffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if seq, err := strconv.ParseUint(name, 10, 64); err == nil {
+			segments = append(segments, seq)
+		}
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
@@ -0,0 +1,265 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/lrucache"
+	"github.com/jared-d-smith/psl/salestax-srv/seal"
+)
+
+// newCache returns a cache logging its changes to l.
+func newCache(l *Log[string, int]) *lrucache.LRUCache[string, int] {
+	return lrucache.New[string, int](100, lrucache.WithJournal[string, int](l))
+}
+
+func open(t *testing.T, dir string, opts ...Option) *Log[string, int] {
+	t.Helper()
+	l, err := Open[string, int](dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir)
+	c := newCache(l)
+	c.Insert("a", 1)
+	c.Insert("b", 2)
+	c.Insert("c", 3)
+	c.Insert("a", 4)
+	c.Delete("b")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir)
+	r := newCache(l)
+	n, err := l.Replay(r.Restore, r.Evict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("replayed %d records, want 5", n)
+	}
+	if keys, want := r.Keys(), c.Keys(); !slices.Equal(keys, want) {
+		t.Errorf("replayed keys %v, want %v", keys, want)
+	}
+	if item, err := r.Get("a"); err != nil || item.Value() != 4 {
+		t.Errorf("a = %v, %v, want 4", item, err)
+	}
+
+	// the replay is not logged again: a third start replays the same
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l = open(t, dir)
+	r = lrucache.New[string, int](100)
+	if n, err := l.Replay(r.Restore, r.Evict); err != nil || n != 5 {
+		t.Errorf("second replay: %d records, %v, want 5", n, err)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir)
+	l.Insert("a", 1, time.Now().Add(-time.Minute))
+	l.Insert("b", 2, time.Now().Add(time.Hour))
+	l.Close()
+
+	l = open(t, dir)
+	r := lrucache.New[string, int](100)
+	r.Restore("a", 0, time.Time{})
+	if _, err := l.Replay(r.Restore, r.Evict); err != nil {
+		t.Fatal(err)
+	}
+	if keys := r.Keys(); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("replayed keys %v, want [b]", keys)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir, WithMaxSize(256), WithMaxSegments(3))
+	c := newCache(l)
+	for i := range 200 {
+		c.Insert(string(rune('a'+i%26))+"-key", i)
+	}
+	l.Close()
+	segments, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Errorf("%d segments kept, want 3", len(segments))
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir)
+	c := newCache(l)
+	c.Insert("a", 1)
+	saved := false
+	if err := l.Checkpoint(func() error {
+		saved = true
+		c.Insert("b", 2) // logged in the new segment
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !saved {
+		t.Fatal("Checkpoint did not save")
+	}
+	l.Close()
+
+	l = open(t, dir)
+	r := lrucache.New[string, int](100)
+	if n, err := l.Replay(r.Restore, r.Evict); err != nil || n != 1 {
+		t.Fatalf("replay after a checkpoint: %d records, %v, want 1", n, err)
+	}
+	if keys := r.Keys(); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("replayed keys %v, want [b]", keys)
+	}
+
+	// once closed, a checkpoint makes every segment redundant
+	l.Close()
+	if err := l.Checkpoint(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := listSegments(dir); len(segments) != 0 {
+		t.Errorf("segments %v left after the final checkpoint", segments)
+	}
+}
+
+func TestCutShort(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir)
+	c := newCache(l)
+	c.Insert("a", 1)
+	c.Insert("b", 2)
+	l.Close()
+
+	segments, _ := listSegments(dir)
+	path := l.path(segments[len(segments)-1])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir)
+	r := lrucache.New[string, int](100)
+	if n, err := l.Replay(r.Restore, r.Evict); err != nil || n != 1 {
+		t.Errorf("replay of a segment cut short: %d records, %v, want 1", n, err)
+	}
+}
+
+func TestDamaged(t *testing.T) {
+	dir := t.TempDir()
+	l := open(t, dir)
+	c := newCache(l)
+	c.Insert("a", 1)
+	c.Insert("b", 2)
+	l.Close()
+
+	segments, _ := listSegments(dir)
+	path := l.path(segments[len(segments)-1])
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = open(t, dir)
+	r := lrucache.New[string, int](100)
+	if n, err := l.Replay(r.Restore, r.Evict); err != nil || n != 1 {
+		t.Errorf("replay of a damaged segment: %d records, %v, want 1", n, err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	// a bare gob stream, as written by version 1
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "0000000000000001"+suffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(f)
+	enc.Encode(&record[string, int]{Key: "a", Value: 1})
+	enc.Encode(&record[string, int]{Key: "b", Value: 2})
+	enc.Encode(&record[string, int]{Delete: true, Key: "a"})
+	f.Close()
+
+	l := open(t, dir)
+	r := lrucache.New[string, int](100)
+	if n, err := l.Replay(r.Restore, r.Evict); err != nil || n != 3 {
+		t.Fatalf("replay of version 1: %d records, %v, want 3", n, err)
+	}
+	if keys := r.Keys(); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("replayed keys %v, want [b]", keys)
+	}
+	l.Close()
+
+	header := binary.BigEndian.AppendUint16([]byte(segmentMagic), sealedVersion+1)
+	if err := os.WriteFile(filepath.Join(dir, "0000000000000001"+suffix), header, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l = open(t, dir)
+	if _, err := l.Replay(r.Restore, r.Evict); !errors.Is(err, ErrVersion) {
+		t.Errorf("replay of a newer version: %v, want ErrVersion", err)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	c, err := seal.New(bytes.Repeat([]byte{1}, seal.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	l := open(t, dir, WithCipher(c))
+	cache := newCache(l)
+	cache.Insert("1 Congress Ave", 1)
+	cache.Insert("1 Market St", 2)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+suffix))
+	for _, name := range segments {
+		data, _ := os.ReadFile(name)
+		if bytes.Contains(data, []byte("Congress")) {
+			t.Errorf("%s holds a key in clear", name)
+		}
+	}
+
+	l = open(t, dir)
+	r := newCache(l)
+	if _, err := l.Replay(r.Restore, r.Evict); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("replay without the cipher: %v, want ErrEncrypted", err)
+	}
+	l.Close()
+
+	l = open(t, dir, WithCipher(c))
+	r = newCache(l)
+	if n, err := l.Replay(r.Restore, r.Evict); err != nil || n != 2 {
+		t.Fatalf("replay with the cipher: %d records, %v, want 2", n, err)
+	}
+	if item, err := r.Get("1 Market St"); err != nil || item.Value() != 2 {
+		t.Errorf("1 Market St = %v, %v, want 2", item, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/httpserver"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// runWarm implements "salestax-srv warm": it reads a warm file and stores
+// every rate in a running server with PUT /rate/{address}.
+func runWarm(args []string) error {
+	fs := newFlagSet("warm", "file")
+	server := fs.String("server", "http://localhost"+httpserver.DefaultAddr, "base URL of the server to warm")
+	apiKey := fs.String("api-key", os.Getenv("SALESTAX_API_KEY"), "API key of an admin client, for servers requiring authentication")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	path := fs.Arg(0)
+
+	rates, err := readWarmFile(path)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	base := strings.TrimSuffix(*server, "/")
+	// least important first, like WarmRates, so they survive on the server
+	for _, r := range slices.Backward(rates) {
+		if err := putRate(client, base, *apiKey, r.Address, r.Rate); err != nil {
+			return err
+		}
+	}
+	log.Printf("stored %d rates in %s", len(rates), base)
+	return nil
+}
+
+func putRate(client *http.Client, base, apiKey, address string, rate float64) error {
+	body, err := json.Marshal(httpserver.RateRequest{Rate: rate})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, base+"/rate/"+url.PathEscape(address), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storing %q: server returned %s", address, resp.Status)
+	}
+	return nil
+}
+
+// readWarmFile parses the warm file at path, picking the format by its
+// extension.
+func readWarmFile(path string) ([]salestax.Rate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return salestax.ReadJSON(f)
+	}
+	return salestax.ReadCSV(f)
+}
@@ -0,0 +1,180 @@
+// Package watch fans the rate changes of a salestax-srv out to the services
+// watching them, so that those keeping a copy of the rates, e.g. a pricing
+// service, update it as the rates change instead of polling.
+//
+// A Hub numbers the events published to it and keeps the latest of them, so
+// that a watcher reconnecting after the event it saw last is sent those it
+// missed; if they are gone, or were published by an earlier process, it is
+// sent a Reset event and must drop its whole copy.
+package watch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// Kind is the kind of change of an Event.
+type Kind string
+
+// Kinds of an Event.
+const (
+	Update     Kind = "update"     // Address was written, Rate is its new rate
+	Delete     Kind = "delete"     // Address was removed
+	Invalidate Kind = "invalidate" // the rates of Prefix, of the jurisdiction of Level and Code, or of Addresses are stale
+	Reset      Kind = "reset"      // events were missed, every rate may be stale
+)
+
+// Event is a rate change.
+type Event struct {
+	ID        uint64            `json:"id"`
+	Kind      Kind              `json:"kind"`
+	Time      time.Time         `json:"time"`
+	Address   string            `json:"address,omitempty"`
+	Rate      *salestax.TaxRate `json:"rate,omitempty"`
+	Prefix    string            `json:"prefix,omitempty"`
+	Level     string            `json:"level,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	Addresses []string          `json:"addresses,omitempty"`
+}
+
+// Defaults of a Hub.
+const (
+	DefaultBuffer  = 256  // events queued for a watcher before it is dropped
+	DefaultHistory = 1024 // events kept for watchers that reconnect
+)
+
+// Hub publishes events to its watchers. It is safe for concurrent use.
+type Hub struct {
+	buffer  int
+	size    int // of history
+	mu      sync.Mutex
+	next    uint64  // ID of the next event
+	history []Event // the latest events, oldest first
+	subs    map[*Subscription]struct{}
+}
+
+// Option configures a Hub.
+type Option func(*Hub)
+
+// WithBuffer sets how many events may be queued for a watcher that does not
+// keep up; once they are, it is dropped and has to reconnect.
+func WithBuffer(n int) Option {
+	return func(h *Hub) {
+		h.buffer = n
+	}
+}
+
+// WithHistory sets how many of the latest events are kept for watchers that
+// reconnect. With 0 every reconnection is sent a Reset.
+func WithHistory(n int) Option {
+	return func(h *Hub) {
+		h.size = n
+	}
+}
+
+// New returns a Hub.
+func New(opts ...Option) *Hub {
+	h := &Hub{
+		buffer: DefaultBuffer,
+		size:   DefaultHistory,
+		next:   1,
+		subs:   make(map[*Subscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Publish numbers e, stamps it with the current time unless it has one, and
+// sends it to every watcher. Watchers whose queue is full are dropped. It
+// returns e as sent.
+func (h *Hub) Publish(e Event) Event {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e.ID = h.next
+	h.next++
+	if h.size > 0 {
+		if len(h.history) == h.size {
+			h.history = append(h.history[:0], h.history[1:]...)
+		}
+		h.history = append(h.history, e)
+	}
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			h.drop(s)
+		}
+	}
+	return e
+}
+
+// PublishInvalidation publishes an invalidation of the cluster, e.g. one
+// received from another instance; see invalidation.Cluster.Notify.
+func (h *Hub) PublishInvalidation(e invalidation.Event) {
+	h.Publish(Event{Kind: Invalidate, Prefix: e.Prefix, Level: e.Level, Code: e.Code, Addresses: e.Keys})
+}
+
+// Subscription is a watcher of a Hub.
+type Subscription struct {
+	h *Hub
+	c chan Event
+}
+
+// Subscribe returns a new watcher of h. after is the ID of the last event a
+// reconnecting watcher saw, 0 for a new one: the events it missed are sent
+// first, or a Reset if they are no longer kept.
+func (h *Hub) Subscribe(after uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var replay []Event
+	if last := h.next - 1; after > 0 && after != last {
+		oldest := h.next - uint64(len(h.history))
+		if after > last || after+1 < oldest {
+			replay = []Event{{ID: last, Kind: Reset, Time: time.Now()}}
+		} else {
+			replay = h.history[after+1-oldest:]
+		}
+	}
+	s := &Subscription{h: h, c: make(chan Event, h.buffer+len(replay))}
+	for _, e := range replay {
+		s.c <- e
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Events returns the channel of the events of s. It is closed by Close, or
+// if s did not keep up and was dropped.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Close stops s.
+func (s *Subscription) Close() {
+	s.h.mu.Lock()
+	defer s.h.mu.Unlock()
+	s.h.drop(s)
+}
+
+// Watchers returns the number of watchers of h.
+func (h *Hub) Watchers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// drop removes s, if it was not already. The caller must hold h.mu.
+func (h *Hub) drop(s *Subscription) {
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/jared-d-smith/psl/salestax-srv/invalidation"
+	"github.com/jared-d-smith/psl/salestax-srv/salestax"
+)
+
+// drain returns the events queued for s.
+func drain(s *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func kinds(events []Event) []Kind {
+	var out []Kind
+	for _, e := range events {
+		out = append(out, e.Kind)
+	}
+	return out
+}
+
+func TestHub(t *testing.T) {
+	h := New(WithHistory(3))
+	s := h.Subscribe(0)
+	rate := salestax.Flat(0.0825)
+	h.Publish(Event{Kind: Update, Address: "TX:1", Rate: &rate})
+	h.Publish(Event{Kind: Delete, Address: "TX:1"})
+	h.PublishInvalidation(invalidation.Event{Level: salestax.State, Code: "48"})
+
+	events := drain(s)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	for i, e := range events {
+		if e.ID != uint64(i+1) || e.Time.IsZero() {
+			t.Errorf("event %d: ID %d, time %v", i, e.ID, e.Time)
+		}
+	}
+	if e := events[2]; e.Kind != Invalidate || e.Code != "48" {
+		t.Errorf("invalidation published as %+v", e)
+	}
+
+	h.Publish(Event{Kind: Delete, Address: "TX:2"})
+	for _, tc := range []struct {
+		after uint64
+		want  []Kind
+	}{
+		{0, nil},
+		{4, nil},
+		{2, []Kind{Invalidate, Delete}},
+		{1, []Kind{Delete, Invalidate, Delete}},
+		{99, []Kind{Reset}}, // of an earlier process
+	} {
+		r := h.Subscribe(tc.after)
+		if got := kinds(drain(r)); !slices.Equal(got, tc.want) {
+			t.Errorf("Subscribe(%d) replayed %v, want %v", tc.after, got, tc.want)
+		}
+		r.Close()
+	}
+	// event 2 is no longer kept
+	h.Publish(Event{Kind: Delete, Address: "TX:3"})
+	if got := kinds(drain(h.Subscribe(1))); !slices.Equal(got, []Kind{Reset}) {
+		t.Errorf("Subscribe(1) replayed %v, want [reset]", got)
+	}
+	if got := kinds(drain(s)); len(got) != 2 {
+		t.Errorf("watcher got %v, want the last 2 events", got)
+	}
+	s.Close()
+	if _, ok := <-s.Events(); ok {
+		t.Error("Events not closed by Close")
+	}
+}
+
+func TestHubDropsSlowWatchers(t *testing.T) {
+	h := New(WithBuffer(2), WithHistory(10))
+	slow := h.Subscribe(0)
+	for range 3 {
+		h.Publish(Event{Kind: Delete, Address: "TX:1"})
+	}
+	if n := len(drain(slow)); n != 2 {
+		t.Errorf("slow watcher got %d events, want 2", n)
+	}
+	if _, ok := <-slow.Events(); ok {
+		t.Error("slow watcher was not dropped")
+	}
+	if n := h.Watchers(); n != 0 {
+		t.Errorf("Watchers = %d, want 0", n)
+	}
+	slow.Close() // no-op once dropped
+
+	// it catches up on reconnecting
+	if got := kinds(drain(h.Subscribe(2))); !slices.Equal(got, []Kind{Delete}) {
+		t.Errorf("reconnection after 2 replayed %v, want [delete]", got)
+	}
+	if got := kinds(drain(New(WithHistory(0)).Subscribe(5))); !slices.Equal(got, []Kind{Reset}) {
+		t.Errorf("reconnection without history replayed %v, want [reset]", got)
+	}
+}